@@ -9,13 +9,19 @@ import (
 	"encoding/json"
 	"errors"
 
+	"github.com/aungmawjj/juria-blockchain/core"
 	"github.com/aungmawjj/juria-blockchain/execution/chaincode"
 )
 
+// Input of juriacoin, destination account can be given
+// either as raw public key (Dest) or as address string (DestAddr).
+// Balances stored under raw public keys, before accounts were keyed by address,
+// are found only when the account is given as public key.
 type Input struct {
-	Method string `json:"method"`
-	Dest   []byte `json:"dest"`
-	Value  int64  `json:"value"`
+	Method   string `json:"method"`
+	Dest     []byte `json:"dest"`
+	DestAddr string `json:"destAddr,omitempty"`
+	Value    int64  `json:"value"`
 }
 
 var (
@@ -77,41 +83,58 @@ func (jctx *JuriaCoin) Query(ctx chaincode.CallContext) ([]byte, error) {
 
 func invokeSetMinter(ctx chaincode.CallContext, input *Input) error {
 	minter := ctx.GetState(keyMinter)
-	if !bytes.Equal(minter, ctx.Sender()) {
+	if !bytes.Equal(account(minter), account(ctx.Sender())) {
 		return errors.New("sender must be minter")
 	}
-	ctx.SetState(keyMinter, input.Dest)
+	dest := input.Dest
+	if len(input.DestAddr) > 0 {
+		addr, err := core.ParseAddress(input.DestAddr)
+		if err != nil {
+			return err
+		}
+		dest = addr.Bytes()
+	}
+	ctx.SetState(keyMinter, dest)
 	return nil
 }
 
 func invokeMint(ctx chaincode.CallContext, input *Input) error {
 	minter := ctx.GetState(keyMinter)
-	if !bytes.Equal(minter, ctx.Sender()) {
+	if !bytes.Equal(account(minter), account(ctx.Sender())) {
 		return errors.New("sender must be minter")
 	}
+	dest, err := destAccount(input)
+	if err != nil {
+		return err
+	}
 	total := decodeBalance(ctx.GetState(keyTotal))
-	balance := decodeBalance(ctx.GetState(input.Dest))
+	balance := getBalance(ctx, dest)
 
 	total += input.Value
 	balance += input.Value
 
 	ctx.SetState(keyTotal, encodeBalance(total))
-	ctx.SetState(input.Dest, encodeBalance(balance))
+	setBalance(ctx, dest, balance)
 	return nil
 }
 
 func invokeTransfer(ctx chaincode.CallContext, input *Input) error {
-	bsctx := decodeBalance(ctx.GetState(ctx.Sender()))
+	sender := ctx.Sender()
+	dest, err := destAccount(input)
+	if err != nil {
+		return err
+	}
+	bsctx := getBalance(ctx, sender)
 	if bsctx < input.Value {
 		return errors.New("not enough balance")
 	}
-	bdes := decodeBalance(ctx.GetState(input.Dest))
+	bdes := getBalance(ctx, dest)
 
 	bsctx -= input.Value
 	bdes += input.Value
 
-	ctx.SetState(ctx.Sender(), encodeBalance(bsctx))
-	ctx.SetState(input.Dest, encodeBalance(bdes))
+	setBalance(ctx, sender, bsctx)
+	setBalance(ctx, dest, bdes)
 	return nil
 }
 
@@ -120,11 +143,64 @@ func queryTotal(ctx chaincode.CallContext) ([]byte, error) {
 }
 
 func queryBalance(ctx chaincode.CallContext, input *Input) ([]byte, error) {
-	return json.Marshal(decodeBalance(ctx.GetState(input.Dest)))
+	dest, err := destAccount(input)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(getBalance(ctx, dest))
+}
+
+// account returns the state key of an account.
+// public keys are converted to addresses, so that accounts can be referred by either of them
+func account(b []byte) []byte {
+	pubKey, err := core.NewPublicKey(b)
+	if err != nil {
+		return b
+	}
+	return pubKey.Address().Bytes()
+}
+
+// legacyAccount returns the state key of the account before accounts were keyed by address,
+// the raw public key, or nil if b is not a public key
+func legacyAccount(b []byte) []byte {
+	if _, err := core.NewPublicKey(b); err != nil {
+		return nil
+	}
+	return b
+}
+
+// getBalance returns the balance of account b including the balance under its legacy key
+func getBalance(ctx chaincode.CallContext, b []byte) int64 {
+	balance := decodeBalance(ctx.GetState(account(b)))
+	if legacy := legacyAccount(b); legacy != nil {
+		balance += decodeBalance(ctx.GetState(legacy))
+	}
+	return balance
+}
+
+// setBalance stores the balance of account b under its address
+// and clears the legacy key, whose balance is included by getBalance
+func setBalance(ctx chaincode.CallContext, b []byte, value int64) {
+	if legacy := legacyAccount(b); legacy != nil && len(ctx.GetState(legacy)) > 0 {
+		ctx.SetState(legacy, nil)
+	}
+	ctx.SetState(account(b), encodeBalance(value))
+}
+
+// destAccount returns the destination public key or address bytes
+func destAccount(input *Input) ([]byte, error) {
+	if len(input.DestAddr) == 0 {
+		return input.Dest, nil
+	}
+	addr, err := core.ParseAddress(input.DestAddr)
+	if err != nil {
+		return nil, err
+	}
+	return addr.Bytes(), nil
 }
 
 func decodeBalance(b []byte) int64 {
-	if b == nil {
+	if len(b) == 0 { // cleared legacy balance
 		return 0
 	}
 	return int64(binary.BigEndian.Uint64(b))
@@ -7,6 +7,7 @@ import (
 	"encoding/json"
 	"testing"
 
+	"github.com/aungmawjj/juria-blockchain/core"
 	"github.com/aungmawjj/juria-blockchain/execution/chaincode"
 	"github.com/stretchr/testify/assert"
 )
@@ -197,3 +198,119 @@ func TestJuriaCoin_Transfer(t *testing.T) {
 
 	assert.EqualValues(100, balance)
 }
+
+func TestJuriaCoin_Address(t *testing.T) {
+	assert := assert.New(t)
+	state := chaincode.NewMockState()
+	jctx := new(JuriaCoin)
+
+	minter := core.GenerateKey(nil).PublicKey()
+	dest := core.GenerateKey(nil).PublicKey()
+
+	ctx := new(chaincode.MockCallContext)
+	ctx.MockState = state
+	ctx.MockSender = minter.Bytes()
+	jctx.Init(ctx)
+
+	// mint with raw public key
+	input := &Input{
+		Method: "mint",
+		Dest:   dest.Bytes(),
+		Value:  100,
+	}
+	b, _ := json.Marshal(input)
+	ctx.MockInput = b
+	assert.NoError(jctx.Invoke(ctx))
+
+	// query with address string
+	input = &Input{
+		Method:   "balance",
+		DestAddr: dest.Address().String(),
+	}
+	b, _ = json.Marshal(input)
+	ctx.MockInput = b
+	b, err := jctx.Query(ctx)
+	assert.NoError(err)
+	var balance int64
+	json.Unmarshal(b, &balance)
+	assert.EqualValues(100, balance)
+
+	// transfer to address string
+	dest2 := core.GenerateKey(nil).PublicKey()
+	input = &Input{
+		Method:   "transfer",
+		DestAddr: dest2.Address().String(),
+		Value:    30,
+	}
+	b, _ = json.Marshal(input)
+	ctx.MockSender = dest.Bytes()
+	ctx.MockInput = b
+	assert.NoError(jctx.Invoke(ctx))
+
+	// query with raw public key
+	input = &Input{
+		Method: "balance",
+		Dest:   dest2.Bytes(),
+	}
+	b, _ = json.Marshal(input)
+	ctx.MockInput = b
+	b, err = jctx.Query(ctx)
+	assert.NoError(err)
+	balance = 0
+	json.Unmarshal(b, &balance)
+	assert.EqualValues(30, balance)
+
+	input.DestAddr = "juria1invalid"
+	b, _ = json.Marshal(input)
+	ctx.MockInput = b
+	_, err = jctx.Query(ctx)
+	assert.Error(err)
+}
+
+func TestJuriaCoin_LegacyBalance(t *testing.T) {
+	assert := assert.New(t)
+	state := chaincode.NewMockState()
+	jctx := new(JuriaCoin)
+
+	sender := core.GenerateKey(nil).PublicKey()
+	dest := core.GenerateKey(nil).PublicKey()
+
+	ctx := new(chaincode.MockCallContext)
+	ctx.MockState = state
+	ctx.MockSender = sender.Bytes()
+	jctx.Init(ctx)
+
+	// balances stored under raw public keys before accounts were keyed by address
+	state.SetState(sender.Bytes(), encodeBalance(100))
+	state.SetState(dest.Bytes(), encodeBalance(20))
+
+	queryBalance := func(input *Input) int64 {
+		input.Method = "balance"
+		b, _ := json.Marshal(input)
+		ctx.MockInput = b
+		b, err := jctx.Query(ctx)
+		assert.NoError(err)
+		var balance int64
+		json.Unmarshal(b, &balance)
+		return balance
+	}
+	assert.EqualValues(100, queryBalance(&Input{Dest: sender.Bytes()}))
+
+	input := &Input{
+		Method: "transfer",
+		Dest:   dest.Bytes(),
+		Value:  30,
+	}
+	b, _ := json.Marshal(input)
+	ctx.MockInput = b
+	assert.NoError(jctx.Invoke(ctx))
+
+	assert.EqualValues(70, queryBalance(&Input{Dest: sender.Bytes()}))
+	assert.EqualValues(50, queryBalance(&Input{Dest: dest.Bytes()}))
+
+	// legacy balances are moved to addresses once written
+	assert.Empty(state.GetState(sender.Bytes()))
+	assert.Empty(state.GetState(dest.Bytes()))
+	assert.EqualValues(70, queryBalance(&Input{DestAddr: sender.Address().String()}))
+	assert.EqualValues(50, queryBalance(&Input{DestAddr: dest.Address().String()}))
+}
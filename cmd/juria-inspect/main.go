@@ -0,0 +1,191 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"strconv"
+
+	"github.com/aungmawjj/juria-blockchain/storage"
+	"github.com/spf13/cobra"
+)
+
+const (
+	FlagDataDir            = "datadir"
+	FlagMerkleBranchFactor = "storage-merkleBranchFactor"
+	FlagHexKey             = "hex"
+	FlagCodeAddr           = "code"
+)
+
+var (
+	datadir       string
+	storageConfig = storage.DefaultConfig
+	hexKey        bool
+	codeAddrHex   string
+)
+
+var rootCmd = &cobra.Command{
+	Use:   "juria-inspect",
+	Short: "Inspect blocks and state of a stopped juria node",
+
+	SilenceUsage: true,
+}
+
+var blockCmd = &cobra.Command{
+	Use:   "block <height>",
+	Short: "Print the block at height",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		height, err := strconv.ParseUint(args[0], 10, 64)
+		if err != nil {
+			return err
+		}
+		return inspect(func(strg *storage.Storage) error {
+			return printBlock(strg, height)
+		})
+	},
+}
+
+var stateCmd = &cobra.Command{
+	Use:   "state <key>",
+	Short: "Print the state value of key, verified against the state root",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		key := []byte(args[0])
+		if hexKey {
+			var err error
+			if key, err = hex.DecodeString(args[0]); err != nil {
+				return err
+			}
+		}
+		codeAddr, err := hex.DecodeString(codeAddrHex)
+		if err != nil {
+			return err
+		}
+		return inspect(func(strg *storage.Storage) error {
+			return printState(strg, codeAddr, key)
+		})
+	},
+}
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Verify the state root with the one recorded by the last block commit with state changes",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return inspect(verifyMerkleRoot)
+	},
+}
+
+func inspect(fn func(strg *storage.Storage) error) error {
+	strg, err := storage.OpenReadOnly(path.Join(datadir, "db"), storageConfig)
+	if err != nil {
+		return err
+	}
+	defer strg.Close()
+	return fn(strg)
+}
+
+func printBlock(strg *storage.Storage, height uint64) error {
+	blk, err := strg.GetBlockByHeight(height)
+	pruned := errors.Is(err, storage.ErrPruned)
+	if pruned {
+		blk, err = strg.GetBlockHeaderByHeight(height)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("height:      %d\n", blk.Height())
+	fmt.Printf("hash:        %x\n", blk.Hash())
+	fmt.Printf("parent:      %x\n", blk.ParentHash())
+	fmt.Printf("proposer:    %s\n", blk.Proposer())
+	fmt.Printf("exec height: %d\n", blk.ExecHeight())
+	fmt.Printf("merkle root: %x\n", blk.MerkleRoot())
+	fmt.Printf("timestamp:   %d\n", blk.Timestamp())
+	if pruned {
+		fmt.Println("txs:         pruned")
+		return nil
+	}
+	fmt.Printf("txs:         %d\n", len(blk.TransactionsRef()))
+	for _, hash := range blk.TransactionsRef() {
+		fmt.Printf("  %x\n", hash)
+	}
+	return nil
+}
+
+func printState(strg *storage.Storage, codeAddr, key []byte) error {
+	value := strg.VerifyState(codeAddr, key)
+	if value == nil {
+		if strg.GetState(codeAddr, key) != nil {
+			return fmt.Errorf("state of key %x doesn't match state root", key)
+		}
+		return fmt.Errorf("state of key %x not found", key)
+	}
+	if len(codeAddr) > 0 {
+		fmt.Printf("code:  %x\n", codeAddr)
+	}
+	fmt.Printf("key:   %x\n", key)
+	fmt.Printf("value: %x\n", value)
+	return nil
+}
+
+func verifyMerkleRoot(strg *storage.Storage) error {
+	root := strg.GetMerkleRoot()
+	height := strg.GetBlockHeight()
+	fmt.Printf("block height: %d\n", height)
+	fmt.Printf("state root:   %x\n", root)
+	for h := height; ; h-- {
+		blk, err := strg.GetBlockHeaderByHeight(h)
+		if err != nil {
+			return err
+		}
+		bcm, err := strg.GetBlockCommit(blk.Hash())
+		if err != nil {
+			return fmt.Errorf("block commit %d, %w", h, err)
+		}
+		if len(bcm.MerkleRoot()) > 0 {
+			if !bytes.Equal(root, bcm.MerkleRoot()) {
+				return fmt.Errorf("state root doesn't match %x of block %d", bcm.MerkleRoot(), h)
+			}
+			fmt.Printf("state root matches block %d\n", h)
+			return nil
+		}
+		if h == 0 {
+			break
+		}
+	}
+	if root != nil {
+		return errors.New("no block commit with state root")
+	}
+	fmt.Println("no state")
+	return nil
+}
+
+func main() {
+	err := rootCmd.Execute()
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func init() {
+	rootCmd.PersistentFlags().StringVarP(&datadir,
+		FlagDataDir, "d", "", "blockchain data directory of a stopped node")
+	rootCmd.MarkPersistentFlagRequired(FlagDataDir)
+
+	rootCmd.PersistentFlags().Uint8Var(&storageConfig.MerkleBranchFactor,
+		FlagMerkleBranchFactor, storageConfig.MerkleBranchFactor,
+		"merkle tree branching factor of the node")
+
+	stateCmd.Flags().BoolVar(&hexKey, FlagHexKey, false, "key is hex encoded")
+	stateCmd.Flags().StringVar(&codeAddrHex, FlagCodeAddr, "",
+		"hex encoded code address of the key namespace, empty for system keys")
+
+	rootCmd.AddCommand(blockCmd, stateCmd, verifyCmd)
+}
@@ -4,7 +4,9 @@
 package main
 
 import (
+	"fmt"
 	"log"
+	"os"
 
 	"github.com/aungmawjj/juria-blockchain/node"
 	"github.com/spf13/cobra"
@@ -17,8 +19,56 @@ const (
 	FlagPort    = "port"
 	FlagAPIPort = "apiPort"
 
+	FlagGRPCAddr  = "grpcAddr"
+	FlagGRPCToken = "grpcToken"
+
+	FlagAdminToken = "adminToken"
+
+	FlagDiskSoftLimit     = "disk-softLimit"
+	FlagDiskHardLimit     = "disk-hardLimit"
+	FlagDiskCheckInterval = "disk-checkInterval"
+
+	FlagMaxMsgSize      = "maxMsgSize"
+	FlagMsgCompression  = "msgCompression"
+	FlagPeerMsgRate     = "peerMsgRate"
+	FlagPeerByteRate    = "peerByteRate"
+	FlagPeerBanScore    = "peerBanScore"
+	FlagPeerBanDuration = "peerBanDuration"
+
+	FlagSeenMsgCacheSize = "seenMsgCacheSize"
+	FlagSeenMsgCacheTTL  = "seenMsgCacheTTL"
+
+	FlagPeerExchange = "peerExchange"
+	FlagSeeds        = "seeds"
+
+	FlagEpochLength = "epochLength"
+
+	FlagSigCacheSize = "sigCacheSize"
+
+	FlagMaxTxInputSize = "maxTxInputSize"
+
+	FlagHashFunc = "hashFunc"
+
+	FlagDomainSeparation       = "domainSeparation"
+	FlagDomainSeparationHeight = "domainSeparationHeight"
+
 	// storage
 	FlagMerkleBranchFactor = "storage-merkleBranchFactor"
+	FlagPruneInterval      = "storage-pruneInterval"
+	FlagKeepRecentBlocks   = "storage-keepRecentBlocks"
+	FlagMerkleCacheSize    = "storage-merkleCacheSize"
+	FlagKeepStateVersions  = "storage-keepStateVersions"
+	FlagKeepMerkleVersions = "storage-keepMerkleVersions"
+	FlagStateCacheSize     = "storage-stateCacheSize"
+	FlagBlockCacheSize     = "storage-blockCacheSize"
+	FlagGCInterval         = "storage-gcInterval"
+	FlagGCDiscardRatio     = "storage-gcDiscardRatio"
+	FlagValueLogFileSize   = "storage-valueLogFileSize"
+	FlagCompression        = "storage-compression"
+	FlagSyncWrites         = "storage-syncWrites"
+	FlagNumCompactors      = "storage-numCompactors"
+	FlagDisableSenderIndex = "storage-disableSenderIndex"
+	FlagRequireCodeAddr    = "storage-requireCodeAddr"
 
 	// execution
 	FlagTxExecTimeout       = "execution-txExecTimeout"
@@ -27,11 +77,17 @@ const (
 	// consensus
 	FlagChainID       = "chainid"
 	FlagBlockTxLimit  = "consensus-blockTxLimit"
+	FlagMaxTxPerBlock = "consensus-maxTxPerBlock"
 	FlagTxWaitTime    = "consensus-txWaitTime"
 	FlagBeatTimeout   = "consensus-beatTimeout"
 	FlagBlockDelay    = "consensus-blockDelay"
 	FlagViewWidth     = "consensus-viewWidth"
 	FlagLeaderTimeout = "consensus-leaderTimeout"
+	FlagSyncBatchSize = "consensus-syncBatchSize"
+
+	FlagStandby            = "consensus-standby"
+	FlagStandbySilentViews = "consensus-standbySilentViews"
+	FlagStandbyLeaseToken  = "consensus-standbyLeaseToken"
 )
 
 var nodeConfig = node.DefaultConfig
@@ -44,6 +100,34 @@ var rootCmd = &cobra.Command{
 	},
 }
 
+var restoreCmd = &cobra.Command{
+	Use:   "restore <backup file>",
+	Short: "Restore a storage backup into the datadir before starting the node",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return node.RestoreBackup(nodeConfig, f)
+	},
+}
+
+var rebuildMerkleCmd = &cobra.Command{
+	Use:   "rebuild-merkle",
+	Short: "Rebuild the state merkle tree of a stopped node from the stored state",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		root, err := node.RebuildMerkleTree(nodeConfig)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("merkle root %x\n", root)
+		return nil
+	},
+}
+
 func main() {
 	err := rootCmd.Execute()
 	if err != nil {
@@ -65,10 +149,156 @@ func init() {
 	rootCmd.Flags().IntVarP(&nodeConfig.APIPort,
 		FlagAPIPort, "P", nodeConfig.APIPort, "node api port")
 
-	rootCmd.Flags().Uint8Var(&nodeConfig.StorageConfig.MerkleBranchFactor,
+	rootCmd.Flags().StringVar(&nodeConfig.GRPCAddr,
+		FlagGRPCAddr, nodeConfig.GRPCAddr, "grpc server address, disabled if empty")
+
+	rootCmd.Flags().StringVar(&nodeConfig.GRPCToken,
+		FlagGRPCToken, nodeConfig.GRPCToken, "token required by grpc clients")
+
+	rootCmd.Flags().StringVar(&nodeConfig.AdminToken,
+		FlagAdminToken, nodeConfig.AdminToken, "token required by admin api clients, admin api is disabled if empty")
+
+	rootCmd.Flags().Uint64Var(&nodeConfig.DiskSoftLimit,
+		FlagDiskSoftLimit, nodeConfig.DiskSoftLimit,
+		"free disk space in bytes below which new txs are rejected")
+
+	rootCmd.Flags().Uint64Var(&nodeConfig.DiskHardLimit,
+		FlagDiskHardLimit, nodeConfig.DiskHardLimit,
+		"free disk space in bytes below which only empty blocks are proposed")
+
+	rootCmd.Flags().DurationVar(&nodeConfig.DiskCheckInterval,
+		FlagDiskCheckInterval, nodeConfig.DiskCheckInterval,
+		"interval to sample free disk space")
+
+	rootCmd.Flags().IntVar(&nodeConfig.MaxMsgSize,
+		FlagMaxMsgSize, nodeConfig.MaxMsgSize,
+		"maximum size in bytes of a p2p message")
+
+	rootCmd.Flags().BoolVar(&nodeConfig.MsgCompression,
+		FlagMsgCompression, nodeConfig.MsgCompression,
+		"compress p2p messages for the peers which support it")
+
+	rootCmd.Flags().Float64Var(&nodeConfig.PeerRateLimit.MsgPerSec,
+		FlagPeerMsgRate, nodeConfig.PeerRateLimit.MsgPerSec,
+		"inbound messages per second of each peer, no limit if zero")
+
+	rootCmd.Flags().Float64Var(&nodeConfig.PeerRateLimit.BytesPerSec,
+		FlagPeerByteRate, nodeConfig.PeerRateLimit.BytesPerSec,
+		"inbound message bytes per second of each peer, no limit if zero")
+
+	rootCmd.Flags().Float64Var(&nodeConfig.PeerScore.BanScore,
+		FlagPeerBanScore, nodeConfig.PeerScore.BanScore,
+		"penalty score of invalid messages to ban a peer, banning is disabled if zero")
+
+	rootCmd.Flags().DurationVar(&nodeConfig.PeerScore.BanDuration,
+		FlagPeerBanDuration, nodeConfig.PeerScore.BanDuration,
+		"duration a banned peer cannot reconnect")
+
+	rootCmd.Flags().IntVar(&nodeConfig.SeenMsgCache.Size,
+		FlagSeenMsgCacheSize, nodeConfig.SeenMsgCache.Size,
+		"maximum hashes of gossiped messages to drop duplicates, disabled if zero")
+
+	rootCmd.Flags().DurationVar(&nodeConfig.SeenMsgCache.TTL,
+		FlagSeenMsgCacheTTL, nodeConfig.SeenMsgCache.TTL,
+		"duration a gossiped message hash is remembered, no expiry if zero")
+
+	rootCmd.Flags().BoolVar(&nodeConfig.PeerExchange,
+		FlagPeerExchange, nodeConfig.PeerExchange,
+		"answer peer exchanges of the nodes bootstrapping from this node")
+
+	rootCmd.Flags().StringSliceVar(&nodeConfig.Seeds,
+		FlagSeeds, nodeConfig.Seeds,
+		"multiaddrs with /p2p/<peer id> of the seed nodes to discover peers from")
+
+	rootCmd.Flags().IntVar(&nodeConfig.TxLimits.MaxInputSize,
+		FlagMaxTxInputSize, nodeConfig.TxLimits.MaxInputSize,
+		"maximum size in bytes of a tx input, no limit if zero")
+
+	rootCmd.Flags().IntVar(&nodeConfig.SigCacheSize,
+		FlagSigCacheSize, nodeConfig.SigCacheSize,
+		"maximum entries of verified signature cache, disabled if zero")
+
+	rootCmd.Flags().Uint64Var(&nodeConfig.EpochLength,
+		FlagEpochLength, nodeConfig.EpochLength,
+		"blocks per validator set epoch, validators are fixed to genesis if zero")
+
+	rootCmd.Flags().StringVar(&nodeConfig.HashFunc,
+		FlagHashFunc, nodeConfig.HashFunc,
+		"hash function of block, tx and vote digests (sha3-256, sha256, blake2b-256)")
+
+	rootCmd.Flags().BoolVar(&nodeConfig.DomainSeparation.Enabled,
+		FlagDomainSeparation, nodeConfig.DomainSeparation.Enabled,
+		"prefix block, tx and vote digests with domain tags")
+
+	rootCmd.Flags().Uint64Var(&nodeConfig.DomainSeparation.Height,
+		FlagDomainSeparationHeight, nodeConfig.DomainSeparation.Height,
+		"block height from which domain tags are required")
+
+	// persistent, the repair commands must rebuild the tree with the same factor
+	rootCmd.PersistentFlags().Uint8Var(&nodeConfig.StorageConfig.MerkleBranchFactor,
 		FlagMerkleBranchFactor, nodeConfig.StorageConfig.MerkleBranchFactor,
 		"merkle tree branching factor")
 
+	rootCmd.Flags().IntVar(&nodeConfig.StorageConfig.MerkleCacheSize,
+		FlagMerkleCacheSize, nodeConfig.StorageConfig.MerkleCacheSize,
+		"maximum merkle tree nodes cached in memory, cache is disabled if zero")
+
+	rootCmd.Flags().DurationVar(&nodeConfig.StorageConfig.PruneInterval,
+		FlagPruneInterval, nodeConfig.StorageConfig.PruneInterval,
+		"interval to prune old blocks, pruning is disabled if zero")
+
+	rootCmd.Flags().Uint64Var(&nodeConfig.StorageConfig.KeepRecentBlocks,
+		FlagKeepRecentBlocks, nodeConfig.StorageConfig.KeepRecentBlocks,
+		"number of recent blocks to keep txs and commits, pruning is disabled if zero")
+
+	rootCmd.Flags().Uint64Var(&nodeConfig.StorageConfig.KeepStateVersions,
+		FlagKeepStateVersions, nodeConfig.StorageConfig.KeepStateVersions,
+		"number of recent blocks to query state at, state history is disabled if zero")
+
+	rootCmd.Flags().Uint64Var(&nodeConfig.StorageConfig.KeepMerkleVersions,
+		FlagKeepMerkleVersions, nodeConfig.StorageConfig.KeepMerkleVersions,
+		"number of recent blocks to prove state at, merkle history is disabled if zero")
+
+	rootCmd.Flags().IntVar(&nodeConfig.StorageConfig.StateCacheSize,
+		FlagStateCacheSize, nodeConfig.StorageConfig.StateCacheSize,
+		"maximum state values cached in memory, cache is disabled if zero")
+
+	rootCmd.Flags().IntVar(&nodeConfig.StorageConfig.BlockCacheSize,
+		FlagBlockCacheSize, nodeConfig.StorageConfig.BlockCacheSize,
+		"maximum decoded blocks cached in memory, cache is disabled if zero")
+
+	rootCmd.Flags().DurationVar(&nodeConfig.StorageConfig.GCInterval,
+		FlagGCInterval, nodeConfig.StorageConfig.GCInterval,
+		"interval of value log gc, gc is disabled if zero")
+
+	rootCmd.Flags().Float64Var(&nodeConfig.StorageConfig.GCDiscardRatio,
+		FlagGCDiscardRatio, nodeConfig.StorageConfig.GCDiscardRatio,
+		"minimum discardable ratio of a value log file to rewrite it")
+
+	rootCmd.PersistentFlags().Int64Var(&nodeConfig.StorageConfig.ValueLogFileSize,
+		FlagValueLogFileSize, nodeConfig.StorageConfig.ValueLogFileSize,
+		"maximum size in bytes of a db value log file")
+
+	rootCmd.PersistentFlags().StringVar((*string)(&nodeConfig.StorageConfig.Compression),
+		FlagCompression, string(nodeConfig.StorageConfig.Compression),
+		"compression of db blocks (none, snappy, zstd)")
+
+	rootCmd.PersistentFlags().BoolVar(&nodeConfig.StorageConfig.SyncWrites,
+		FlagSyncWrites, nodeConfig.StorageConfig.SyncWrites,
+		"sync db writes to disk before commits return")
+
+	rootCmd.PersistentFlags().IntVar(&nodeConfig.StorageConfig.NumCompactors,
+		FlagNumCompactors, nodeConfig.StorageConfig.NumCompactors,
+		"number of db compaction workers, at least 2")
+
+	rootCmd.Flags().BoolVar(&nodeConfig.StorageConfig.DisableSenderIndex,
+		FlagDisableSenderIndex, nodeConfig.StorageConfig.DisableSenderIndex,
+		"don't index txs by sender, the senders api is not available")
+
+	rootCmd.Flags().BoolVar(&nodeConfig.StorageConfig.RequireCodeAddr,
+		FlagRequireCodeAddr, nodeConfig.StorageConfig.RequireCodeAddr,
+		"reject state changes without code address")
+
 	rootCmd.Flags().DurationVar(&nodeConfig.ExecutionConfig.TxExecTimeout,
 		FlagTxExecTimeout, nodeConfig.ExecutionConfig.TxExecTimeout,
 		"tx execution timeout")
@@ -85,6 +315,10 @@ func init() {
 		FlagBlockTxLimit, nodeConfig.ConsensusConfig.BlockTxLimit,
 		"maximum tx count in a block")
 
+	rootCmd.Flags().IntVar(&nodeConfig.ConsensusConfig.MaxTxPerBlock,
+		FlagMaxTxPerBlock, nodeConfig.ConsensusConfig.MaxTxPerBlock,
+		"maximum tx count accepted in a block from other proposers")
+
 	rootCmd.Flags().DurationVar(&nodeConfig.ConsensusConfig.TxWaitTime,
 		FlagTxWaitTime, nodeConfig.ConsensusConfig.TxWaitTime,
 		"block creation delay if no transactions in the pool")
@@ -104,4 +338,22 @@ func init() {
 	rootCmd.Flags().DurationVar(&nodeConfig.ConsensusConfig.LeaderTimeout,
 		FlagLeaderTimeout, nodeConfig.ConsensusConfig.LeaderTimeout,
 		"leader must create next qc in this duration")
+
+	rootCmd.Flags().IntVar(&nodeConfig.ConsensusConfig.SyncBatchSize,
+		FlagSyncBatchSize, nodeConfig.ConsensusConfig.SyncBatchSize,
+		"blocks commited in one batch when syncing from peers, batching is disabled if less than 2")
+
+	rootCmd.Flags().BoolVar(&nodeConfig.ConsensusConfig.Standby,
+		FlagStandby, nodeConfig.ConsensusConfig.Standby,
+		"hold validator key without signing until promoted")
+
+	rootCmd.Flags().IntVar(&nodeConfig.ConsensusConfig.StandbySilentViews,
+		FlagStandbySilentViews, nodeConfig.ConsensusConfig.StandbySilentViews,
+		"views the primary must be silent before standby can be promoted")
+
+	rootCmd.Flags().StringVar(&nodeConfig.ConsensusConfig.StandbyLeaseToken,
+		FlagStandbyLeaseToken, nodeConfig.ConsensusConfig.StandbyLeaseToken,
+		"leadership lease token required to promote standby node")
+
+	rootCmd.AddCommand(restoreCmd, rebuildMerkleCmd)
 }
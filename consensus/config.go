@@ -11,6 +11,10 @@ type Config struct {
 	// maximum tx count in a block
 	BlockTxLimit int
 
+	// maximum tx count accepted in a block from other proposers,
+	// should not be lower than BlockTxLimit of any validator
+	MaxTxPerBlock int
+
 	// block creation delay if no transactions in the pool
 	TxWaitTime time.Duration
 
@@ -25,13 +29,32 @@ type Config struct {
 
 	// leader must create next qc within this duration
 	LeaderTimeout time.Duration
+
+	// standby node holds validator key but never signs until promoted
+	Standby bool
+
+	// views the primary must be silent before standby can be promoted
+	StandbySilentViews int
+
+	// token required to promote standby node
+	StandbyLeaseToken string
+
+	// file of the consensus write-ahead log, the log is kept in memory only if empty
+	WALFile string
+
+	// blocks synced from peers are commited in batches of this size,
+	// batching is disabled if less than 2
+	SyncBatchSize int
 }
 
 var DefaultConfig = Config{
 	BlockTxLimit:  400,
+	MaxTxPerBlock: 1000,
 	TxWaitTime:    1 * time.Second,
 	BeatTimeout:   500 * time.Millisecond,
 	BlockDelay:    40 * time.Millisecond, // maximum block rate = 25 blk per sec
 	ViewWidth:     30 * time.Second,
 	LeaderTimeout: 10 * time.Second,
+
+	StandbySilentViews: 3,
 }
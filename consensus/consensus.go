@@ -4,11 +4,15 @@
 package consensus
 
 import (
+	"errors"
+	"sync/atomic"
 	"time"
 
 	"github.com/aungmawjj/juria-blockchain/core"
+	"github.com/aungmawjj/juria-blockchain/emitter"
 	"github.com/aungmawjj/juria-blockchain/hotstuff"
 	"github.com/aungmawjj/juria-blockchain/logger"
+	"github.com/aungmawjj/juria-blockchain/storage"
 )
 
 type Consensus struct {
@@ -17,6 +21,12 @@ type Consensus struct {
 
 	startTime int64
 
+	// 1 when proposals must not include transactions
+	emptyProposal int32
+
+	// emits *storage.CommitData after each block is commited to storage
+	commitEmitter *emitter.Emitter
+
 	state     *state
 	hsDriver  *hsDriver
 	hotstuff  *hotstuff.Hotstuff
@@ -25,10 +35,13 @@ type Consensus struct {
 	rotator   *rotator
 }
 
+var _ core.LeaderSchedule = (*Consensus)(nil)
+
 func New(resources *Resources, config Config) *Consensus {
 	cons := &Consensus{
-		resources: resources,
-		config:    config,
+		resources:     resources,
+		config:        config,
+		commitEmitter: emitter.New(),
 	}
 	return cons
 }
@@ -41,10 +54,82 @@ func (cons *Consensus) Stop() {
 	cons.stop()
 }
 
+// SubscribeCommit subscribes commited blocks as *storage.CommitData events.
+// Events are dropped for the subscriber when its buffer is full.
+func (cons *Consensus) SubscribeCommit(buffer int) *emitter.Subscription {
+	return cons.commitEmitter.Subscribe(buffer)
+}
+
 func (cons *Consensus) GetStatus() Status {
 	return cons.getStatus()
 }
 
+// IsStandby returns true if node is not allowed to sign
+func (cons *Consensus) IsStandby() bool {
+	if cons.state == nil {
+		return cons.config.Standby
+	}
+	return !cons.state.canSign()
+}
+
+// Promote enables signing on standby node, after the validators holding majority stake
+// confirm the primary is silent
+func (cons *Consensus) Promote(token string) error {
+	if cons.state == nil {
+		return ErrNotStandby
+	}
+	sb := cons.state.standby
+	if err := sb.checkPromote(token, cons.resources.Signer.PublicKey()); err != nil {
+		return err
+	}
+	if err := cons.state.checkWAL(cons.hotstuff.GetBLeaf().Height()); err != nil {
+		return err
+	}
+	if err := cons.state.confirmSilent(sb.silentViews); err != nil {
+		return err
+	}
+	if err := sb.promote(); err != nil {
+		return err
+	}
+	logger.I().Warnw("promoted standby node, signing enabled")
+	return nil
+}
+
+// SetEmptyProposal makes the node propose blocks without transactions,
+// it keeps voting for blocks of other proposers
+func (cons *Consensus) SetEmptyProposal(val bool) {
+	var v int32
+	if val {
+		v = 1
+	}
+	atomic.StoreInt32(&cons.emptyProposal, v)
+}
+
+// GetSilentViews returns the views since the validator's signature was last seen,
+// see p2p.SilentViewsReqHandler
+func (cons *Consensus) GetSilentViews(pubKey *core.PublicKey) uint64 {
+	if cons.state == nil {
+		return 0
+	}
+	return cons.state.standby.getSilentViews(pubKey)
+}
+
+// LeaderFor returns the leader approved by this node at height, -1 if not known since node start
+func (cons *Consensus) LeaderFor(height uint64) int {
+	if cons.rotator == nil {
+		return -1
+	}
+	return cons.rotator.LeaderFor(height)
+}
+
+// GetLeaderHistory returns the leaders approved since node start
+func (cons *Consensus) GetLeaderHistory() core.LeaderHistory {
+	if cons.state == nil {
+		return nil
+	}
+	return cons.state.getLeaderHistory()
+}
+
 func (cons *Consensus) GetBlock(hash []byte) *core.Block {
 	return cons.state.getBlock(hash)
 }
@@ -75,23 +160,30 @@ func (cons *Consensus) stop() {
 
 func (cons *Consensus) setupState(b0 *core.Block) {
 	cons.state = newState(cons.resources)
+	cons.state.standby = newStandby(cons.config)
+	wal, err := openSignWAL(cons.config.WALFile)
+	if err != nil {
+		logger.I().Fatalw("setup consensus wal failed", "error", err)
+	}
+	cons.state.wal = wal
 	cons.state.setBlock(b0)
-	cons.state.setLeaderIndex(cons.resources.VldStore.GetValidatorIndex(b0.Proposer()))
+	leaderIdx := cons.resources.VldStore.GetValidatorIndex(b0.Proposer())
+	cons.state.setLeaderIndex(leaderIdx)
+	cons.state.recordLeader(b0.Height(), leaderIdx)
 }
 
 func (cons *Consensus) getInitialBlockAndQC() (*core.Block, *core.QuorumCert) {
-	b0, err := cons.resources.Storage.GetLastBlock()
+	b0, q0, err := cons.resources.Storage.GetLastCommitted()
 	if err == nil {
-		q0, err := cons.resources.Storage.GetLastQC()
-		if err != nil {
-			logger.I().Fatalf("cannot get last qc %d", b0.Height())
-		}
 		return b0, q0
 	}
+	if !errors.Is(err, storage.ErrNoBlocks) {
+		logger.I().Fatalf("cannot get last commited block, %+v", err)
+	}
 	// chain not started, create genesis block
 	genesis := &genesis{
 		resources: cons.resources,
-		chainID:   cons.config.ChainID,
+		standby:   cons.config.Standby,
 	}
 	return genesis.run()
 }
@@ -102,6 +194,9 @@ func (cons *Consensus) setupHsDriver() {
 		config:       cons.config,
 		checkTxDelay: 10 * time.Millisecond,
 		state:        cons.state,
+
+		emptyProposal: &cons.emptyProposal,
+		commitEmitter: cons.commitEmitter,
 	}
 }
 
@@ -116,8 +211,10 @@ func (cons *Consensus) setupHotstuff(b0 *core.Block, q0 *core.QuorumCert) {
 func (cons *Consensus) setupValidator() {
 	cons.validator = &validator{
 		resources: cons.resources,
+		config:    cons.config,
 		state:     cons.state,
 		hotstuff:  cons.hotstuff,
+		hsDriver:  cons.hsDriver,
 	}
 }
 
@@ -148,6 +245,8 @@ func (cons *Consensus) getStatus() (status Status) {
 	status.BlockPoolSize = cons.state.getBlockPoolSize()
 	status.QCPoolSize = cons.state.getQCPoolSize()
 	status.LeaderIndex = cons.state.getLeaderIndex()
+	status.Standby = !cons.state.canSign()
+	status.Equivocations = cons.state.equivocations.getCount()
 	status.ViewStart = cons.rotator.getViewStart()
 	status.PendingViewChange = cons.rotator.getPendingViewChange()
 
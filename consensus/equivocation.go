@@ -0,0 +1,69 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package consensus
+
+import (
+	"bytes"
+	"sync"
+	"sync/atomic"
+
+	"github.com/aungmawjj/juria-blockchain/core"
+	"github.com/aungmawjj/juria-blockchain/logger"
+)
+
+type signedBlock struct {
+	height uint64
+	hash   []byte
+}
+
+/*
+equivocations counts the validators seen signing two blocks at the same height,
+as proposals or as votes.
+
+Only the last block signed by each validator is kept,
+since proposals and votes are mostly received in height order.
+*/
+type equivocations struct {
+	count uint64
+
+	mtx      sync.Mutex
+	proposed map[string]signedBlock
+	voted    map[string]signedBlock
+}
+
+func newEquivocations() *equivocations {
+	return &equivocations{
+		proposed: make(map[string]signedBlock),
+		voted:    make(map[string]signedBlock),
+	}
+}
+
+func (eq *equivocations) getCount() uint64 {
+	return atomic.LoadUint64(&eq.count)
+}
+
+func (eq *equivocations) onProposal(blk *core.Block) {
+	eq.check(eq.proposed, "proposal", blk.Proposer(), blk)
+}
+
+func (eq *equivocations) onVote(voter *core.PublicKey, blk *core.Block) {
+	eq.check(eq.voted, "vote", voter, blk)
+}
+
+func (eq *equivocations) check(
+	signed map[string]signedBlock, kind string, signer *core.PublicKey, blk *core.Block,
+) {
+	eq.mtx.Lock()
+	defer eq.mtx.Unlock()
+	key := signer.String()
+	last, found := signed[key]
+	if found && last.height == blk.Height() && !bytes.Equal(last.hash, blk.Hash()) {
+		atomic.AddUint64(&eq.count, 1)
+		logger.I().Errorw("validator equivocation",
+			"kind", kind, "signer", signer, "height", blk.Height())
+	}
+	if !found || blk.Height() >= last.height {
+		signed[key] = signedBlock{blk.Height(), blk.Hash()}
+	}
+}
@@ -0,0 +1,43 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package consensus
+
+import (
+	"testing"
+
+	"github.com/aungmawjj/juria-blockchain/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestState_observeEquivocations(t *testing.T) {
+	assert := assert.New(t)
+
+	priv := core.GenerateKey(nil)
+	other := core.GenerateKey(nil)
+	state := newState(&Resources{Signer: priv})
+
+	b1 := core.NewBlock().SetHeight(1).Sign(other)
+	state.setBlock(b1)
+	state.observeBlock(b1)
+	state.observeBlock(b1) // received twice
+	state.observeVote(b1.Vote(priv))
+	state.observeVote(b1.Vote(priv))
+	state.observeBlock(core.NewBlock().SetHeight(0).SetTimestamp(1).Sign(other)) // older block
+	assert.EqualValues(0, state.equivocations.getCount())
+
+	b1x := core.NewBlock().SetHeight(1).SetTimestamp(1).Sign(other)
+	state.setBlock(b1x)
+	state.observeBlock(b1x)
+	assert.EqualValues(1, state.equivocations.getCount(), "two proposals at the same height")
+
+	state.observeVote(b1x.Vote(priv))
+	assert.EqualValues(2, state.equivocations.getCount(), "two votes at the same height")
+
+	// vote inside qc
+	qc := core.NewQuorumCert().Build([]*core.Vote{b1.Vote(other)})
+	state.observeBlock(core.NewBlock().SetHeight(2).SetQuorumCert(qc).Sign(priv))
+	qc = core.NewQuorumCert().Build([]*core.Vote{b1x.Vote(other)})
+	state.observeBlock(core.NewBlock().SetHeight(3).SetQuorumCert(qc).Sign(priv))
+	assert.EqualValues(3, state.equivocations.getCount(), "two qc votes at the same height")
+}
@@ -5,7 +5,6 @@ package consensus
 
 import (
 	"bytes"
-	"encoding/binary"
 	"errors"
 	"fmt"
 	"sync"
@@ -14,12 +13,13 @@ import (
 	"github.com/aungmawjj/juria-blockchain/core"
 	"github.com/aungmawjj/juria-blockchain/logger"
 	"github.com/aungmawjj/juria-blockchain/storage"
-	"golang.org/x/crypto/sha3"
 )
 
 type genesis struct {
 	resources *Resources
-	chainID   int64
+	standby   bool // don't propose or vote
+
+	pinned *core.Genesis // genesis stored on first boot, b0 must have its hash
 
 	done chan struct{}
 
@@ -38,6 +38,11 @@ type genesis struct {
 
 func (gns *genesis) run() (*core.Block, *core.QuorumCert) {
 	logger.I().Infow("creating genesis block...")
+	pinned, err := gns.resources.Storage.GetGenesis()
+	if err != nil {
+		logger.I().Fatalf("cannot get genesis, %+v", err)
+	}
+	gns.pinned = pinned
 	gns.done = make(chan struct{})
 
 	go gns.proposalLoop()
@@ -65,23 +70,24 @@ func (gns *genesis) commit() {
 }
 
 func (gns *genesis) propose() {
-	if !gns.isLeader(gns.resources.Signer.PublicKey()) {
+	if gns.standby || !gns.isLeader(gns.resources.Signer.PublicKey()) {
 		return
 	}
 	gns.votes = make(map[string]*core.Vote, gns.resources.VldStore.MajorityCount())
 	b0 := gns.createGenesisBlock()
+	if !bytes.Equal(b0.Hash(), gns.pinned.Hash()) {
+		logger.I().Fatalw("signed genesis block doesn't match pinned genesis",
+			"hash", b0.Hash(), "pinned", gns.pinned.Hash())
+	}
 	gns.setB0(b0)
 	logger.I().Infow("created genesis block, broadcasting...")
 	go gns.broadcastProposalLoop()
 	gns.onReceiveVote(b0.ProposerVote())
 }
 
+// createGenesisBlock signs the pinned genesis block, the hash is unchanged
 func (gns *genesis) createGenesisBlock() *core.Block {
-	return core.NewBlock().
-		SetHeight(0).
-		SetParentHash(hashChainID(gns.chainID)).
-		SetTimestamp(time.Now().UnixNano()).
-		Sign(gns.resources.Signer)
+	return gns.pinned.Block().Clone().Sign(gns.resources.Signer)
 }
 
 func (gns *genesis) broadcastProposalLoop() {
@@ -107,12 +113,6 @@ func (gns *genesis) isLeader(pubKey *core.PublicKey) bool {
 	return gns.resources.VldStore.GetValidatorIndex(pubKey) == 0
 }
 
-func hashChainID(chainID int64) []byte {
-	h := sha3.New256()
-	binary.Write(h, binary.BigEndian, chainID)
-	return h.Sum(nil)
-}
-
 func (gns *genesis) proposalLoop() {
 	sub := gns.resources.MsgSvc.SubscribeProposal(10)
 	defer sub.Unsubscribe()
@@ -172,8 +172,8 @@ func (gns *genesis) onReceiveProposal(proposal *core.Block) error {
 		logger.I().Info("left behind, fetching genesis block...")
 		return gns.fetchGenesisBlockAndQC(proposal.Proposer())
 	}
-	if !bytes.Equal(hashChainID(gns.chainID), proposal.ParentHash()) {
-		return fmt.Errorf("different chain id genesis")
+	if !bytes.Equal(gns.pinned.Hash(), proposal.Hash()) {
+		return fmt.Errorf("genesis block doesn't match pinned genesis")
 	}
 	if !gns.isLeader(proposal.Proposer()) {
 		return fmt.Errorf("proposer is not leader")
@@ -182,6 +182,10 @@ func (gns *genesis) onReceiveProposal(proposal *core.Block) error {
 		return fmt.Errorf("genesis block with txs")
 	}
 	gns.setB0(proposal)
+	if gns.standby {
+		logger.I().Infow("got genesis block, standby node doesn't vote")
+		return nil
+	}
 	logger.I().Infow("got genesis block, voting...")
 	return gns.resources.MsgSvc.SendVote(proposal.Proposer(), proposal.Vote(gns.resources.Signer))
 }
@@ -191,15 +195,15 @@ func (gns *genesis) fetchGenesisBlockAndQC(peer *core.PublicKey) error {
 	if err != nil {
 		return err
 	}
-	if !b0.IsGenesis() {
-		return fmt.Errorf("not genesis block")
+	if !bytes.Equal(gns.pinned.Hash(), b0.Hash()) {
+		return fmt.Errorf("genesis block doesn't match pinned genesis")
 	}
 	b1, err := gns.requestBlockByHeight(peer, 1)
 	if err != nil {
 		return err
 	}
-	if !bytes.Equal(b0.Hash(), b1.QuorumCert().BlockHash()) {
-		return fmt.Errorf("b1 qc ref is not b0")
+	if err := b1.QuorumCert().CheckBlock(b0); err != nil {
+		return fmt.Errorf("b1 qc ref is not b0, %w", err)
 	}
 	gns.setB0(b0)
 	gns.setQ0(b1.QuorumCert())
@@ -222,8 +226,11 @@ func (gns *genesis) onReceiveVote(vote *core.Vote) error {
 	if gns.votes == nil {
 		return errors.New("not accepting votes")
 	}
+	if err := vote.CheckBlock(gns.getB0()); err != nil {
+		return fmt.Errorf("invalid vote from %s, %w", vote.Voter(), err)
+	}
 	if err := vote.Validate(gns.resources.VldStore); err != nil {
-		return err
+		return fmt.Errorf("invalid vote from %s, %w", vote.Voter(), err)
 	}
 	gns.acceptVote(vote)
 	return nil
@@ -234,14 +241,16 @@ func (gns *genesis) acceptVote(vote *core.Vote) {
 	defer gns.mtxVote.Unlock()
 
 	gns.votes[vote.Voter().String()] = vote
-	if len(gns.votes) < gns.resources.VldStore.MajorityCount() {
-		return
-	}
 	vlist := make([]*core.Vote, 0, len(gns.votes))
 	for _, vote := range gns.votes {
 		vlist = append(vlist, vote)
 	}
-	gns.setQ0(core.NewQuorumCert().Build(vlist))
+	if !core.HasMajorityVotes(gns.resources.VldStore, vlist) {
+		return
+	}
+	gns.setQ0(core.NewQuorumCert().Build(vlist, core.QCBuildOptions{
+		VldStore: gns.resources.VldStore,
+	}))
 	logger.I().Infow("created qc, broadcasting...")
 	gns.broadcastQC()
 }
@@ -261,15 +270,15 @@ func (gns *genesis) broadcastQC() {
 }
 
 func (gns *genesis) onReceiveNewView(qc *core.QuorumCert) error {
-	if err := qc.Validate(gns.resources.VldStore); err != nil {
-		return err
-	}
 	b0 := gns.getB0()
 	if b0 == nil {
 		return fmt.Errorf("no received genesis block yet")
 	}
-	if !bytes.Equal(b0.Hash(), qc.BlockHash()) {
-		return fmt.Errorf("invalid qc reference")
+	if err := qc.CheckBlock(b0); err != nil {
+		return fmt.Errorf("invalid qc reference, %w", err)
+	}
+	if err := qc.Validate(gns.resources.VldStore); err != nil {
+		return err
 	}
 	gns.acceptQC(qc)
 	return nil
@@ -0,0 +1,44 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package consensus
+
+import (
+	"testing"
+
+	"github.com/aungmawjj/juria-blockchain/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenesis_pinned(t *testing.T) {
+	assert := assert.New(t)
+
+	privs := []*core.PrivateKey{core.GenerateKey(nil), core.GenerateKey(nil)}
+	vlds := [][]byte{privs[0].PublicKey().Bytes(), privs[1].PublicKey().Bytes()}
+	pubKeys := []*core.PublicKey{privs[0].PublicKey(), privs[1].PublicKey()}
+	pinned := core.NewGenesis([]byte{1}, vlds, nil)
+
+	leader := &genesis{
+		resources: &Resources{Signer: privs[0], VldStore: core.NewValidatorStore(pubKeys)},
+		pinned:    pinned,
+	}
+	b0 := leader.createGenesisBlock()
+	assert.Equal(pinned.Hash(), b0.Hash())
+
+	msgSvc := new(MockMsgService)
+	msgSvc.On("SendVote", privs[0].PublicKey(), b0.Vote(privs[1])).Return(nil)
+	voter := &genesis{
+		resources: &Resources{
+			Signer:   privs[1],
+			VldStore: core.NewValidatorStore(pubKeys),
+			MsgSvc:   msgSvc,
+		},
+		pinned: pinned,
+	}
+	assert.NoError(voter.onReceiveProposal(b0))
+	msgSvc.AssertExpectations(t)
+
+	// signed by the leader but not the pinned block
+	other := core.NewBlock().SetHeight(0).SetParentHash(b0.ParentHash()).SetTimestamp(1).Sign(privs[0])
+	assert.Error(voter.onReceiveProposal(other))
+}
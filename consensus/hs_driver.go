@@ -4,9 +4,13 @@
 package consensus
 
 import (
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/aungmawjj/juria-blockchain/core"
+	"github.com/aungmawjj/juria-blockchain/emitter"
+	"github.com/aungmawjj/juria-blockchain/execution"
 	"github.com/aungmawjj/juria-blockchain/hotstuff"
 	"github.com/aungmawjj/juria-blockchain/logger"
 	"github.com/aungmawjj/juria-blockchain/storage"
@@ -19,36 +23,73 @@ type hsDriver struct {
 	checkTxDelay time.Duration
 
 	state *state
+
+	emptyProposal *int32           // owned by Consensus
+	commitEmitter *emitter.Emitter // owned by Consensus
+
+	mtxBatch sync.Mutex
+	batch    *commitBatch // nil if commits are not batched
+}
+
+// commitBatch holds the blocks executed on the pending state until they are commited together
+type commitBatch struct {
+	pending *execution.PendingState
+	data    []*storage.CommitData
+	txs     map[string]struct{}
 }
 
 var _ hotstuff.Driver = (*hsDriver)(nil)
 
-func (hsd *hsDriver) MajorityCount() int {
-	return hsd.resources.VldStore.MajorityCount()
+// HasMajority returns true if the voters hold majority stake
+func (hsd *hsDriver) HasMajority(hsVotes []hotstuff.Vote) bool {
+	return core.HasMajorityVotes(hsd.resources.VldStore, hsd.coreVotes(hsVotes))
 }
 
 func (hsd *hsDriver) CreateLeaf(parent hotstuff.Block, qc hotstuff.QC, height uint64) hotstuff.Block {
+	if !hsd.state.wal.canSign(height) {
+		logger.I().Warnw("skip proposal, height already signed",
+			"height", height, "signed", hsd.state.wal.lastSigned())
+		return nil
+	}
 	blk := core.NewBlock().
 		SetParentHash(parent.(*hsBlock).block.Hash()).
 		SetQuorumCert(qc.(*hsQC).qc).
 		SetHeight(height).
-		SetTransactions(hsd.resources.TxPool.PopTxsFromQueue(hsd.config.BlockTxLimit)).
+		SetTransactions(hsd.popTxsToPropose()).
 		SetExecHeight(hsd.resources.Storage.GetBlockHeight()).
 		SetMerkleRoot(hsd.resources.Storage.GetMerkleRoot()).
 		SetTimestamp(time.Now().UnixNano()).
 		Sign(hsd.resources.Signer)
 
+	if err := hsd.state.wal.record(blk.Height(), blk.Hash()); err != nil {
+		logger.I().Errorw("skip proposal", "error", err)
+		hsd.resources.TxPool.PutTxsToQueue(blk.Transactions())
+		return nil
+	}
 	hsd.state.setBlock(blk)
 	return newHsBlock(blk, hsd.state)
 }
 
+func (hsd *hsDriver) popTxsToPropose() [][]byte {
+	if hsd.emptyProposal != nil && atomic.LoadInt32(hsd.emptyProposal) == 1 {
+		return nil
+	}
+	return hsd.resources.TxPool.PopTxsFromQueue(hsd.config.BlockTxLimit)
+}
+
 func (hsd *hsDriver) CreateQC(hsVotes []hotstuff.Vote) hotstuff.QC {
+	qc := core.NewQuorumCert().Build(hsd.coreVotes(hsVotes), core.QCBuildOptions{
+		VldStore: hsd.resources.VldStore,
+	})
+	return newHsQC(qc, hsd.state)
+}
+
+func (hsd *hsDriver) coreVotes(hsVotes []hotstuff.Vote) []*core.Vote {
 	votes := make([]*core.Vote, len(hsVotes))
 	for i, hsv := range hsVotes {
 		votes[i] = hsv.(*hsVote).vote
 	}
-	qc := core.NewQuorumCert().Build(votes)
-	return newHsQC(qc, hsd.state)
+	return votes
 }
 
 func (hsd *hsDriver) BroadcastProposal(hsBlk hotstuff.Block) {
@@ -58,8 +99,15 @@ func (hsd *hsDriver) BroadcastProposal(hsBlk hotstuff.Block) {
 
 func (hsd *hsDriver) VoteBlock(hsBlk hotstuff.Block) {
 	blk := hsBlk.(*hsBlock).block
-	vote := blk.Vote(hsd.resources.Signer)
 	hsd.resources.TxPool.SetTxsPending(blk.Transactions())
+	if !hsd.state.canSign() {
+		return // standby node never votes
+	}
+	if err := hsd.state.wal.record(blk.Height(), blk.Hash()); err != nil {
+		logger.I().Warnw("skip vote", "error", err)
+		return
+	}
+	vote := blk.Vote(hsd.resources.Signer)
 	hsd.delayVoteWhenNoTxs()
 	proposer := hsd.resources.VldStore.GetValidatorIndex(blk.Proposer())
 	if proposer != hsd.state.getLeaderIndex() {
@@ -87,6 +135,12 @@ func (hsd *hsDriver) delayVoteWhenNoTxs() {
 
 func (hsd *hsDriver) Commit(hsBlk hotstuff.Block) {
 	bexe := hsBlk.(*hsBlock).block
+	hsd.mtxBatch.Lock()
+	defer hsd.mtxBatch.Unlock()
+	if hsd.batch != nil {
+		hsd.addToBatch(bexe)
+		return
+	}
 	start := time.Now()
 	txs, old := hsd.resources.TxPool.GetTxsToExecute(bexe.Transactions())
 	logger.I().Debugw("commiting block", "height", bexe.Height(), "txs", len(txs))
@@ -103,14 +157,97 @@ func (hsd *hsDriver) Commit(hsBlk hotstuff.Block) {
 	if err != nil {
 		logger.I().Fatalf("commit storage error: %+v", err)
 	}
-	hsd.state.addCommitedTxCount(len(txs))
-	hsd.cleanStateOnCommited(bexe)
+	hsd.onCommited(data)
 	logger.I().Debugw("commited bock",
 		"height", bexe.Height(),
 		"txs", len(txs),
 		"elapsed", time.Since(start))
 }
 
+func (hsd *hsDriver) onCommited(data *storage.CommitData) {
+	hsd.state.addCommitedTxCount(len(data.Transactions))
+	hsd.cleanStateOnCommited(data.Block)
+	if hsd.commitEmitter != nil {
+		hsd.commitEmitter.Emit(data)
+	}
+}
+
+// startCommitBatch batches the following commits until endCommitBatch,
+// used while syncing commited blocks from peers
+func (hsd *hsDriver) startCommitBatch() {
+	if hsd.config.SyncBatchSize < 2 {
+		return
+	}
+	hsd.mtxBatch.Lock()
+	defer hsd.mtxBatch.Unlock()
+	if hsd.batch != nil {
+		return
+	}
+	hsd.batch = &commitBatch{
+		pending: hsd.resources.Execution.NewPendingState(),
+		txs:     make(map[string]struct{}),
+	}
+}
+
+func (hsd *hsDriver) endCommitBatch() {
+	hsd.mtxBatch.Lock()
+	defer hsd.mtxBatch.Unlock()
+	if hsd.batch == nil {
+		return
+	}
+	hsd.flushBatch()
+	hsd.batch = nil
+}
+
+// addToBatch executes the block on top of the pending blocks of the batch
+func (hsd *hsDriver) addToBatch(bexe *core.Block) {
+	txs, old := hsd.resources.TxPool.GetTxsToExecute(bexe.Transactions())
+	// txs of pending blocks are not commited to storage yet
+	execTxs := make([]*core.Transaction, 0, len(txs))
+	for _, tx := range txs {
+		if _, ok := hsd.batch.txs[string(tx.Hash())]; ok {
+			old = append(old, tx.Hash())
+		} else {
+			execTxs = append(execTxs, tx)
+			hsd.batch.txs[string(tx.Hash())] = struct{}{}
+		}
+	}
+	bcm, txcs := hsd.resources.Execution.ExecuteOn(hsd.batch.pending, bexe, execTxs)
+	bcm.SetOldBlockTxs(old)
+	hsd.batch.pending.Apply(bcm)
+	hsd.batch.data = append(hsd.batch.data, &storage.CommitData{
+		Block:        bexe,
+		QC:           hsd.state.getQC(bexe.Hash()),
+		Transactions: execTxs,
+		BlockCommit:  bcm,
+		TxCommits:    txcs,
+	})
+	if len(hsd.batch.data) >= hsd.config.SyncBatchSize {
+		hsd.flushBatch()
+	}
+}
+
+func (hsd *hsDriver) flushBatch() {
+	if len(hsd.batch.data) == 0 {
+		return
+	}
+	start := time.Now()
+	data := hsd.batch.data
+	if err := hsd.resources.Storage.CommitBatch(data); err != nil {
+		logger.I().Fatalf("commit storage batch error: %+v", err)
+	}
+	hsd.batch.data = nil
+	hsd.batch.txs = make(map[string]struct{})
+	hsd.batch.pending.Reset()
+	for _, d := range data {
+		hsd.onCommited(d)
+	}
+	logger.I().Debugw("commited blocks",
+		"height", data[len(data)-1].Block.Height(),
+		"blocks", len(data),
+		"elapsed", time.Since(start))
+}
+
 func (hsd *hsDriver) cleanStateOnCommited(bexec *core.Block) {
 	// qc for bexe is no longer needed here after commited to storage
 	hsd.state.deleteQC(bexec.Hash())
@@ -8,10 +8,12 @@ import (
 	"time"
 
 	"github.com/aungmawjj/juria-blockchain/core"
+	"github.com/aungmawjj/juria-blockchain/execution"
 	"github.com/aungmawjj/juria-blockchain/hotstuff"
 	"github.com/aungmawjj/juria-blockchain/storage"
 	"github.com/aungmawjj/juria-blockchain/txpool"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 )
 
 func setupTestHsDriver() *hsDriver {
@@ -26,19 +28,31 @@ func setupTestHsDriver() *hsDriver {
 	}
 }
 
-func TestHsDriver_TestMajorityCount(t *testing.T) {
-	hsd := setupTestHsDriver()
-	hsd.resources.VldStore = core.NewValidatorStore([]*core.PublicKey{
-		core.GenerateKey(nil).PublicKey(),
-		core.GenerateKey(nil).PublicKey(),
-		core.GenerateKey(nil).PublicKey(),
-		core.GenerateKey(nil).PublicKey(),
-	})
+func TestHsDriver_HasMajority(t *testing.T) {
+	assert := assert.New(t)
 
-	res := hsd.MajorityCount()
+	hsd := setupTestHsDriver()
+	keys := make([]*core.PrivateKey, 4)
+	validators := make([]*core.PublicKey, len(keys))
+	for i := range keys {
+		keys[i] = core.GenerateKey(nil)
+		validators[i] = keys[i].PublicKey()
+	}
+	// majority stake is 7 of 10
+	hsd.resources.VldStore = core.NewWeightedValidatorStore(validators, []uint64{6, 2, 1, 1})
+	blk := core.NewBlock().SetHeight(1).Sign(keys[0])
+	votes := func(idx ...int) []hotstuff.Vote {
+		ret := make([]hotstuff.Vote, len(idx))
+		for i, v := range idx {
+			ret[i] = newHsVote(blk.Vote(keys[v]), hsd.state)
+		}
+		return ret
+	}
 
-	assert := assert.New(t)
-	assert.Equal(hsd.resources.VldStore.MajorityCount(), res)
+	assert.False(hsd.HasMajority(votes(1, 2, 3)), "three of four validators without majority stake")
+	assert.True(hsd.HasMajority(votes(0, 2)))
+	assert.False(hsd.HasMajority(votes(0, 0)), "duplicate voter")
+	assert.False(hsd.HasMajority(nil))
 }
 
 func TestHsDriver_CreateLeaf(t *testing.T) {
@@ -78,6 +92,30 @@ func TestHsDriver_CreateLeaf(t *testing.T) {
 	assert.NotNil(hsd.state.getBlock(blk.Hash()), "should store leaf block in state")
 }
 
+func TestHsDriver_CreateLeafEmptyProposal(t *testing.T) {
+	hsd := setupTestHsDriver()
+	emptyProposal := int32(1)
+	hsd.emptyProposal = &emptyProposal
+	parent := newHsBlock(core.NewBlock().Sign(hsd.resources.Signer), hsd.state)
+	hsd.state.setBlock(parent.(*hsBlock).block)
+	qc := newHsQC(core.NewQuorumCert(), hsd.state)
+
+	txPool := new(MockTxPool)
+	hsd.resources.TxPool = txPool
+
+	storage := new(MockStorage)
+	storage.On("GetBlockHeight").Return(2)
+	storage.On("GetMerkleRoot").Return([]byte("merkle-root"))
+	hsd.resources.Storage = storage
+
+	leaf := hsd.CreateLeaf(parent, qc, 5)
+
+	txPool.AssertNotCalled(t, "PopTxsFromQueue", hsd.config.BlockTxLimit)
+
+	assert := assert.New(t)
+	assert.Empty(leaf.(*hsBlock).block.Transactions(), "should not pop txs from pool")
+}
+
 func TestHsDriver_VoteBlock(t *testing.T) {
 	hsd := setupTestHsDriver()
 	hsd.checkTxDelay = time.Millisecond
@@ -121,6 +159,12 @@ func TestHsDriver_VoteBlock(t *testing.T) {
 	msgSvc.AssertExpectations(t)
 
 	assert.Less(elapsed, hsd.config.TxWaitTime, "should not delay if txs in the pool")
+
+	// another block at the voted height, msgSvc panics if vote is sent
+	other := core.NewBlock().SetTimestamp(1).Sign(proposer)
+	txPool.On("SetTxsPending", other.Transactions())
+	hsd.VoteBlock(newHsBlock(other, hsd.state))
+	msgSvc.AssertNumberOfCalls(t, "SendVote", 2)
 }
 
 func TestHsDriver_Commit(t *testing.T) {
@@ -174,6 +218,69 @@ func TestHsDriver_Commit(t *testing.T) {
 		"should delete folked block from state")
 }
 
+func TestHsDriver_CommitBatch(t *testing.T) {
+	assert := assert.New(t)
+	hsd := setupTestHsDriver()
+	hsd.config.SyncBatchSize = 2
+
+	parent := core.NewBlock().SetHeight(10).Sign(hsd.resources.Signer)
+	hsd.state.setBlock(parent)
+	hsd.state.setCommitedBlock(parent)
+	tx1 := core.NewTransaction().SetNonce(1).Sign(hsd.resources.Signer)
+	tx2 := core.NewTransaction().SetNonce(2).Sign(hsd.resources.Signer)
+	blks := make([]*core.Block, 3)
+	txs := [][]*core.Transaction{{tx1}, {tx1, tx2}, nil}
+	for i := range blks {
+		hashes := make([][]byte, len(txs[i]))
+		for j, tx := range txs[i] {
+			hashes[j] = tx.Hash()
+		}
+		blks[i] = core.NewBlock().SetTransactions(hashes).
+			SetParentHash(parent.Hash()).SetHeight(parent.Height() + 1).Sign(hsd.resources.Signer)
+		hsd.state.setBlock(blks[i])
+		parent = blks[i]
+	}
+
+	txPool := new(MockTxPool)
+	exec := new(MockExecution)
+	pending := execution.NewPendingState(nil)
+	exec.On("NewPendingState").Return(pending).Once()
+	bcms := make([]*core.BlockCommit, len(blks))
+	for i, blk := range blks {
+		txPool.On("GetTxsToExecute", blk.Transactions()).Return(txs[i], nil)
+		txPool.On("RemoveTxs", blk.Transactions()).Once()
+		bcms[i] = core.NewBlockCommit().SetHash(blk.Hash())
+	}
+	exec.On("ExecuteOn", pending, blks[0], []*core.Transaction{tx1}).Return(bcms[0], nil)
+	// tx1 is commited in the pending block
+	exec.On("ExecuteOn", pending, blks[1], []*core.Transaction{tx2}).Return(bcms[1], nil)
+	exec.On("ExecuteOn", pending, blks[2], []*core.Transaction{}).Return(bcms[2], nil)
+	hsd.resources.TxPool = txPool
+	hsd.resources.Execution = exec
+
+	strg := new(MockStorage)
+	strg.On("CommitBatch", mock.Anything).Return(nil).Twice()
+	hsd.resources.Storage = strg
+
+	hsd.startCommitBatch()
+	for _, blk := range blks {
+		hsd.Commit(newHsBlock(blk, hsd.state))
+	}
+	strg.AssertNumberOfCalls(t, "CommitBatch", 1)
+	batch := strg.Calls[0].Arguments.Get(0).([]*storage.CommitData)
+	assert.Len(batch, 2)
+	assert.Equal([][]byte{tx1.Hash()}, batch[1].BlockCommit.OldBlockTxs())
+
+	hsd.endCommitBatch()
+	strg.AssertNumberOfCalls(t, "CommitBatch", 2)
+	assert.Equal(blks[2], strg.Calls[1].Arguments.Get(0).([]*storage.CommitData)[0].Block)
+	assert.Nil(hsd.batch)
+	assert.Equal(blks[2].Height(), hsd.state.getCommitedHeight())
+
+	txPool.AssertExpectations(t)
+	exec.AssertExpectations(t)
+}
+
 func TestHsDriver_CreateQC(t *testing.T) {
 	hsd := setupTestHsDriver()
 	blk := core.NewBlock().Sign(hsd.resources.Signer)
@@ -79,7 +79,7 @@ func (pm *pacemaker) onBeat() {
 		return
 	default:
 	}
-	if !pm.state.isThisNodeLeader() {
+	if !pm.state.isThisNodeLeader() || !pm.state.canSign() {
 		return
 	}
 	pm.propose()
@@ -95,6 +95,9 @@ func (pm *pacemaker) nextBeatTimeout() *time.Timer {
 
 func (pm *pacemaker) propose() {
 	blk := pm.hotstuff.OnPropose()
+	if blk == nil {
+		return
+	}
 	logger.I().Debugw("proposed block", "height", blk.Height(), "qc", qcRefHeight(blk.Justify()))
 	vote := blk.(*hsBlock).block.ProposerVote()
 	pm.hotstuff.OnReceiveVote(newHsVote(vote, pm.state))
@@ -6,6 +6,7 @@ package consensus
 import (
 	"github.com/aungmawjj/juria-blockchain/core"
 	"github.com/aungmawjj/juria-blockchain/emitter"
+	"github.com/aungmawjj/juria-blockchain/execution"
 	"github.com/aungmawjj/juria-blockchain/storage"
 	"github.com/aungmawjj/juria-blockchain/txpool"
 )
@@ -24,9 +25,10 @@ type TxPool interface {
 type Storage interface {
 	GetMerkleRoot() []byte
 	Commit(data *storage.CommitData) error
+	CommitBatch(data []*storage.CommitData) error
 	GetBlock(hash []byte) (*core.Block, error)
-	GetLastBlock() (*core.Block, error)
-	GetLastQC() (*core.QuorumCert, error)
+	GetLastCommitted() (*core.Block, *core.QuorumCert, error)
+	GetGenesis() (*core.Genesis, error)
 	GetBlockHeight() uint64
 	HasTx(hash []byte) bool
 }
@@ -37,7 +39,10 @@ type MsgService interface {
 	SendVote(pubKey *core.PublicKey, vote *core.Vote) error
 	RequestBlock(pubKey *core.PublicKey, hash []byte) (*core.Block, error)
 	RequestBlockByHeight(pubKey *core.PublicKey, height uint64) (*core.Block, error)
+	RequestBlockWithQCByHeight(pubKey *core.PublicKey, height uint64) (*core.Block, *core.QuorumCert, error)
+	RequestBlocksByRange(pubKey *core.PublicKey, from, to uint64) ([]*core.Block, error)
 	SendNewView(pubKey *core.PublicKey, qc *core.QuorumCert) error
+	RequestSilentViews(pubKey *core.PublicKey, validator *core.PublicKey) (uint64, error)
 
 	SubscribeProposal(buffer int) *emitter.Subscription
 	SubscribeVote(buffer int) *emitter.Subscription
@@ -46,6 +51,9 @@ type MsgService interface {
 
 type Execution interface {
 	Execute(blk *core.Block, txs []*core.Transaction) (*core.BlockCommit, []*core.TxCommit)
+	ExecuteOn(state execution.StateStore, blk *core.Block, txs []*core.Transaction) (
+		*core.BlockCommit, []*core.TxCommit)
+	NewPendingState() *execution.PendingState
 }
 
 type Resources struct {
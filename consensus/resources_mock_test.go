@@ -6,6 +6,7 @@ package consensus
 import (
 	"github.com/aungmawjj/juria-blockchain/core"
 	"github.com/aungmawjj/juria-blockchain/emitter"
+	"github.com/aungmawjj/juria-blockchain/execution"
 	"github.com/aungmawjj/juria-blockchain/storage"
 	"github.com/aungmawjj/juria-blockchain/txpool"
 	"github.com/stretchr/testify/mock"
@@ -65,24 +66,32 @@ func (m *MockStorage) GetMerkleRoot() []byte {
 	return castBytes(args.Get(0))
 }
 
+func (m *MockStorage) GetGenesis() (*core.Genesis, error) {
+	args := m.Called()
+	if g := args.Get(0); g != nil {
+		return g.(*core.Genesis), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
 func (m *MockStorage) Commit(data *storage.CommitData) error {
 	args := m.Called(data)
 	return args.Error(0)
 }
 
-func (m *MockStorage) GetBlock(hash []byte) (*core.Block, error) {
-	args := m.Called(hash)
-	return castBlock(args.Get(0)), args.Error(1)
+func (m *MockStorage) CommitBatch(data []*storage.CommitData) error {
+	args := m.Called(data)
+	return args.Error(0)
 }
 
-func (m *MockStorage) GetLastBlock() (*core.Block, error) {
-	args := m.Called()
+func (m *MockStorage) GetBlock(hash []byte) (*core.Block, error) {
+	args := m.Called(hash)
 	return castBlock(args.Get(0)), args.Error(1)
 }
 
-func (m *MockStorage) GetLastQC() (*core.QuorumCert, error) {
+func (m *MockStorage) GetLastCommitted() (*core.Block, *core.QuorumCert, error) {
 	args := m.Called()
-	return castQC(args.Get(0)), args.Error(1)
+	return castBlock(args.Get(0)), castQC(args.Get(1)), args.Error(2)
 }
 
 func (m *MockStorage) GetBlockHeight() uint64 {
@@ -126,11 +135,32 @@ func (m *MockMsgService) RequestBlockByHeight(pubKey *core.PublicKey, height uin
 	return castBlock(args.Get(0)), args.Error(1)
 }
 
+func (m *MockMsgService) RequestBlockWithQCByHeight(
+	pubKey *core.PublicKey, height uint64,
+) (*core.Block, *core.QuorumCert, error) {
+	args := m.Called(pubKey, height)
+	return castBlock(args.Get(0)), castQC(args.Get(1)), args.Error(2)
+}
+
+func (m *MockMsgService) RequestBlocksByRange(
+	pubKey *core.PublicKey, from, to uint64,
+) ([]*core.Block, error) {
+	args := m.Called(pubKey, from, to)
+	return castBlocks(args.Get(0)), args.Error(1)
+}
+
 func (m *MockMsgService) SendNewView(pubKey *core.PublicKey, qc *core.QuorumCert) error {
 	args := m.Called(pubKey, qc)
 	return args.Error(0)
 }
 
+func (m *MockMsgService) RequestSilentViews(
+	pubKey *core.PublicKey, validator *core.PublicKey,
+) (uint64, error) {
+	args := m.Called(pubKey, validator)
+	return uint64(args.Int(0)), args.Error(1)
+}
+
 func (m *MockMsgService) SubscribeProposal(buffer int) *emitter.Subscription {
 	args := m.Called(buffer)
 	return castSubscription(args.Get(0))
@@ -159,6 +189,18 @@ func (m *MockExecution) Execute(
 	return castBlockCommit(args.Get(0)), castTxCommits(args.Get(1))
 }
 
+func (m *MockExecution) ExecuteOn(
+	state execution.StateStore, blk *core.Block, txs []*core.Transaction,
+) (*core.BlockCommit, []*core.TxCommit) {
+	args := m.Called(state, blk, txs)
+	return castBlockCommit(args.Get(0)), castTxCommits(args.Get(1))
+}
+
+func (m *MockExecution) NewPendingState() *execution.PendingState {
+	args := m.Called()
+	return args.Get(0).(*execution.PendingState)
+}
+
 func castBytes(val interface{}) []byte {
 	if val == nil {
 		return nil
@@ -180,6 +222,13 @@ func castBlock(val interface{}) *core.Block {
 	return val.(*core.Block)
 }
 
+func castBlocks(val interface{}) []*core.Block {
+	if val == nil {
+		return nil
+	}
+	return val.([]*core.Block)
+}
+
 func castQC(val interface{}) *core.QuorumCert {
 	if val == nil {
 		return nil
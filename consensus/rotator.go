@@ -122,6 +122,7 @@ func (rot *rotator) onViewTimeout() {
 func (rot *rotator) changeView() {
 	leaderIdx := rot.nextLeader()
 	rot.state.setLeaderIndex(leaderIdx)
+	rot.state.standby.onViewChange()
 	rot.setPendingViewChange(true)
 	rot.setViewStart()
 	leader := rot.resources.VldStore.GetValidator(rot.state.getLeaderIndex())
@@ -149,7 +150,7 @@ func (rot *rotator) onNewQCHigh(qc hotstuff.QC) {
 	if rot.isNewViewApproval(proposer) {
 		ltreset = true
 		vtreset = true
-		rot.approveViewLeader(proposer)
+		rot.approveViewLeader(proposer, qcRefHeight(qc))
 	}
 	if ltreset {
 		rot.drainResetTimer(rot.leaderTimer, rot.config.LeaderTimeout)
@@ -166,14 +167,24 @@ func (rot *rotator) isNewViewApproval(proposer int) bool {
 		(pending && proposer == leaderIdx) // expecting leader
 }
 
-func (rot *rotator) approveViewLeader(proposer int) {
+// approveViewLeader sets the proposer of the qc block at height as leader
+func (rot *rotator) approveViewLeader(proposer int, height uint64) {
 	rot.setPendingViewChange(false)
+	if proposer != rot.state.getLeaderIndex() { // view changed by other validators
+		rot.state.standby.onViewChange()
+	}
 	rot.state.setLeaderIndex(proposer)
+	rot.state.recordLeader(height, proposer)
 	rot.setViewStart()
 	logger.I().Infow("approved leader", "leader", rot.state.getLeaderIndex())
 	rot.leaderTimeoutCount = 0
 }
 
+// LeaderFor returns the approved leader at height, -1 if not known since node start
+func (rot *rotator) LeaderFor(height uint64) int {
+	return rot.state.getLeaderHistory().LeaderFor(height)
+}
+
 func (rot *rotator) setViewStart() {
 	rot.mtxVS.Lock()
 	defer rot.mtxVS.Unlock()
@@ -91,8 +91,10 @@ func TestRotator_resetViewTimer(t *testing.T) {
 	rot, _ := setupRotator()
 	rot.setPendingViewChange(true)
 
-	rot.approveViewLeader(1)
+	rot.approveViewLeader(1, 5)
 
 	assert.False(rot.getPendingViewChange())
 	assert.EqualValues(rot.state.getLeaderIndex(), 1)
+	assert.Equal(1, rot.LeaderFor(5))
+	assert.Equal(-1, rot.LeaderFor(4))
 }
@@ -0,0 +1,129 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package consensus
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// ErrSignedHeight is returned when the validator key already signed another block at the height
+var ErrSignedHeight = errors.New("height already signed")
+
+/*
+signWAL is the write-ahead log of the last block signed with the validator key.
+
+A proposal or vote is recorded before it's sent, and the key never signs a different block
+at or below the recorded height. The log survives restarts, so a restarted node
+can't sign twice at a height it signed before.
+
+While in standby mode, the blocks signed by the primary are recorded too.
+Once promoted, the standby continues above the primary's last signed height.
+*/
+type signWAL struct {
+	file string // kept in memory only if empty
+
+	mtx    sync.Mutex
+	height uint64
+	hash   []byte
+}
+
+func openSignWAL(file string) (*signWAL, error) {
+	wal := &signWAL{file: file}
+	if file == "" {
+		return wal, nil
+	}
+	b, err := ioutil.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return wal, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read consensus wal, %w", err)
+	}
+	if len(b) < 8 {
+		return nil, fmt.Errorf("invalid consensus wal, length %d", len(b))
+	}
+	wal.height = binary.BigEndian.Uint64(b)
+	wal.hash = b[8:]
+	return wal, nil
+}
+
+// lastSigned returns the height of the last signed block
+func (wal *signWAL) lastSigned() uint64 {
+	wal.mtx.Lock()
+	defer wal.mtx.Unlock()
+	return wal.height
+}
+
+// canSign returns true if no block is signed at or above the height
+func (wal *signWAL) canSign(height uint64) bool {
+	wal.mtx.Lock()
+	defer wal.mtx.Unlock()
+	return wal.hash == nil || height > wal.height
+}
+
+// record writes the block to sign, it fails if the key signed
+// at a higher height or another block at the same height
+func (wal *signWAL) record(height uint64, hash []byte) error {
+	wal.mtx.Lock()
+	defer wal.mtx.Unlock()
+	if wal.hash != nil && (height < wal.height ||
+		(height == wal.height && !bytes.Equal(hash, wal.hash))) {
+		return fmt.Errorf("%w, signed %d, signing %d", ErrSignedHeight, wal.height, height)
+	}
+	return wal.write(height, hash)
+}
+
+// observe records a block signed with the validator key by another node,
+// lower heights are ignored
+func (wal *signWAL) observe(height uint64, hash []byte) error {
+	wal.mtx.Lock()
+	defer wal.mtx.Unlock()
+	if wal.hash != nil && height <= wal.height {
+		return nil
+	}
+	return wal.write(height, hash)
+}
+
+func (wal *signWAL) write(height uint64, hash []byte) error {
+	if height == wal.height && bytes.Equal(hash, wal.hash) {
+		return nil
+	}
+	if wal.file != "" {
+		b := make([]byte, 8, 8+len(hash))
+		binary.BigEndian.PutUint64(b, height)
+		if err := writeFileSync(wal.file, append(b, hash...)); err != nil {
+			return fmt.Errorf("cannot write consensus wal, %w", err)
+		}
+	}
+	wal.height = height
+	wal.hash = hash
+	return nil
+}
+
+// writeFileSync replaces the file with b after it's flushed to disk
+func writeFileSync(file string, b []byte) error {
+	tmp := file + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
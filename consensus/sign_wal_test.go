@@ -0,0 +1,45 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package consensus
+
+import (
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignWAL(t *testing.T) {
+	assert := assert.New(t)
+
+	file := path.Join(t.TempDir(), "consensus.wal")
+	wal, err := openSignWAL(file)
+	assert.NoError(err)
+	assert.True(wal.canSign(0), "nothing signed")
+
+	assert.NoError(wal.record(2, []byte("b2")))
+	assert.NoError(wal.record(2, []byte("b2")), "same block again")
+	assert.ErrorIs(wal.record(2, []byte("x2")), ErrSignedHeight)
+	assert.ErrorIs(wal.record(1, []byte("b1")), ErrSignedHeight)
+	assert.False(wal.canSign(2))
+	assert.True(wal.canSign(3))
+
+	assert.NoError(wal.observe(1, []byte("b1")), "lower height is ignored")
+	assert.EqualValues(2, wal.lastSigned())
+	assert.NoError(wal.observe(4, []byte("b4")))
+
+	// restarted node
+	wal, err = openSignWAL(file)
+	assert.NoError(err)
+	assert.EqualValues(4, wal.lastSigned())
+	assert.ErrorIs(wal.record(4, []byte("x4")), ErrSignedHeight)
+	assert.NoError(wal.record(4, []byte("b4")))
+	assert.NoError(wal.record(5, []byte("b5")))
+
+	// in memory
+	wal, err = openSignWAL("")
+	assert.NoError(err)
+	assert.NoError(wal.record(1, []byte("b1")))
+	assert.ErrorIs(wal.record(1, []byte("x1")), ErrSignedHeight)
+}
@@ -0,0 +1,195 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package consensus
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/aungmawjj/juria-blockchain/core"
+	"github.com/aungmawjj/juria-blockchain/logger"
+)
+
+// errors
+var (
+	ErrNotStandby        = errors.New("node is not in standby mode")
+	ErrInvalidLeaseToken = errors.New("invalid leadership lease token")
+	ErrPrimaryNotSilent  = errors.New("primary validator is not silent")
+)
+
+/*
+standby keeps a node holding the validator key from signing.
+
+The node syncs and follows the chain like other validators,
+but it never proposes or votes until promoted.
+Promotion requires the leadership lease token and that no message signed by
+this node's identity (from the primary) was seen for config.StandbySilentViews views.
+The primary may be cut off only from the standby, so the validators holding majority stake,
+with the standby for its identity, must confirm they haven't seen the identity sign for as many views.
+
+Every node records the view when it last saw each validator sign, to answer the standby.
+*/
+type standby struct {
+	enabled     int32 // 1 while signing is disabled
+	leaseToken  string
+	silentViews uint64
+
+	viewCount uint64 // view changes observed since start
+
+	mtxSeen  sync.RWMutex
+	lastSeen map[string]uint64 // view count when a validator's signature was last seen
+}
+
+func newStandby(config Config) *standby {
+	sb := &standby{
+		leaseToken:  config.StandbyLeaseToken,
+		silentViews: uint64(config.StandbySilentViews),
+		lastSeen:    make(map[string]uint64),
+	}
+	if config.Standby {
+		sb.enabled = 1
+	}
+	return sb
+}
+
+func (sb *standby) isEnabled() bool {
+	return atomic.LoadInt32(&sb.enabled) == 1
+}
+
+func (sb *standby) onViewChange() {
+	atomic.AddUint64(&sb.viewCount, 1)
+}
+
+func (sb *standby) onSeen(pubKey *core.PublicKey) {
+	sb.mtxSeen.Lock()
+	defer sb.mtxSeen.Unlock()
+	sb.lastSeen[pubKey.String()] = atomic.LoadUint64(&sb.viewCount)
+}
+
+// getSilentViews returns the views since the validator's signature was last seen,
+// or since start if it's not seen
+func (sb *standby) getSilentViews(pubKey *core.PublicKey) uint64 {
+	sb.mtxSeen.RLock()
+	defer sb.mtxSeen.RUnlock()
+	return atomic.LoadUint64(&sb.viewCount) - sb.lastSeen[pubKey.String()]
+}
+
+// checkPromote checks the token and that identity is silent locally
+func (sb *standby) checkPromote(token string, identity *core.PublicKey) error {
+	if !sb.isEnabled() {
+		return ErrNotStandby
+	}
+	if sb.leaseToken == "" ||
+		subtle.ConstantTimeCompare([]byte(token), []byte(sb.leaseToken)) != 1 {
+		return ErrInvalidLeaseToken
+	}
+	if silent := sb.getSilentViews(identity); silent < sb.silentViews {
+		return fmt.Errorf("%w, silent for %d views, required %d",
+			ErrPrimaryNotSilent, silent, sb.silentViews)
+	}
+	return nil
+}
+
+// promote enables signing once the peers confirmed the primary is silent
+func (sb *standby) promote() error {
+	if !atomic.CompareAndSwapInt32(&sb.enabled, 1, 0) {
+		return ErrNotStandby
+	}
+	return nil
+}
+
+// confirmSilent asks the validators whether they have seen the identity sign
+// for the silent views and returns nil if the validators confirming it hold majority stake.
+// The standby confirms for the identity.
+func (state *state) confirmSilent(silentViews uint64) error {
+	identity := state.resources.Signer.PublicKey()
+	vs := state.resources.VldStore
+	confirmed := make(chan *core.PublicKey)
+	count := 0
+	for i := 0; i < vs.ValidatorCount(); i++ {
+		pubKey := vs.GetValidator(i)
+		if pubKey.Equal(identity) {
+			continue
+		}
+		count++
+		go func() {
+			views, err := state.resources.MsgSvc.RequestSilentViews(pubKey, identity)
+			if err != nil || views < silentViews {
+				confirmed <- nil
+				return
+			}
+			confirmed <- pubKey
+		}()
+	}
+	stake := vs.GetStake(identity)
+	for i := 0; i < count; i++ {
+		if pubKey := <-confirmed; pubKey != nil {
+			stake += vs.GetStake(pubKey)
+		}
+	}
+	if !core.HasMajorityStake(vs, stake) {
+		return fmt.Errorf("%w, confirmed by stake %d, required %d",
+			ErrPrimaryNotSilent, stake, vs.MajorityStake())
+	}
+	return nil
+}
+
+// checkWAL checks the chain has moved past the last block signed with the validator key
+func (state *state) checkWAL(height uint64) error {
+	if !state.wal.canSign(height) {
+		return fmt.Errorf("%w, signed height %d in wal, block height %d",
+			ErrPrimaryNotSilent, state.wal.lastSigned(), height)
+	}
+	return nil
+}
+
+// observeBlock records the signatures of the proposer and the qc signers in the validated block
+func (state *state) observeBlock(blk *core.Block) {
+	if blk.Proposer() != nil {
+		state.observeSigner(blk.Proposer(), blk)
+		state.equivocations.onProposal(blk)
+	}
+	if blk.QuorumCert() == nil {
+		return
+	}
+	qcBlk := state.getBlockFromState(blk.QuorumCert().BlockHash())
+	for _, signer := range blk.QuorumCert().Signers() {
+		state.observeSigner(signer, qcBlk)
+		if qcBlk != nil {
+			state.equivocations.onVote(signer, qcBlk)
+		}
+	}
+}
+
+// observeVote records the signature of the validated vote
+func (state *state) observeVote(vote *core.Vote) {
+	if vote.Voter() == nil {
+		return
+	}
+	blk := state.getBlockFromState(vote.BlockHash())
+	state.observeSigner(vote.Voter(), blk)
+	if blk != nil {
+		state.equivocations.onVote(vote.Voter(), blk)
+	}
+}
+
+// observeSigner records the signer, blocks signed by the primary are written to wal in standby mode
+func (state *state) observeSigner(signer *core.PublicKey, blk *core.Block) {
+	state.standby.onSeen(signer)
+	if blk == nil || !state.standby.isEnabled() ||
+		!signer.Equal(state.resources.Signer.PublicKey()) {
+		return
+	}
+	if err := state.wal.observe(blk.Height(), blk.Hash()); err != nil {
+		logger.I().Errorw("record primary signature failed", "error", err)
+	}
+}
+
+// canSign returns false when node is in standby mode
+func (state *state) canSign() bool {
+	return !state.standby.isEnabled()
+}
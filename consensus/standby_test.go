@@ -0,0 +1,174 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package consensus
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aungmawjj/juria-blockchain/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStandby_checkPromote(t *testing.T) {
+	assert := assert.New(t)
+
+	identity := core.GenerateKey(nil).PublicKey()
+	sb := newStandby(Config{})
+	assert.False(sb.isEnabled())
+	assert.ErrorIs(sb.checkPromote("", identity), ErrNotStandby)
+
+	sb = newStandby(Config{
+		Standby:            true,
+		StandbySilentViews: 2,
+		StandbyLeaseToken:  "lease",
+	})
+	assert.True(sb.isEnabled())
+	assert.ErrorIs(sb.checkPromote("wrong", identity), ErrInvalidLeaseToken)
+
+	sb.onViewChange()
+	sb.onSeen(identity) // primary still signing
+	sb.onViewChange()
+	assert.ErrorIs(sb.checkPromote("lease", identity), ErrPrimaryNotSilent)
+
+	sb.onViewChange()
+	assert.NoError(sb.checkPromote("lease", identity))
+	assert.NoError(sb.promote())
+	assert.False(sb.isEnabled())
+	assert.ErrorIs(sb.checkPromote("lease", identity), ErrNotStandby)
+	assert.ErrorIs(sb.promote(), ErrNotStandby)
+
+	// empty token never promotes
+	sb = newStandby(Config{Standby: true})
+	assert.ErrorIs(sb.checkPromote("", identity), ErrInvalidLeaseToken)
+}
+
+func TestState_observeSigners(t *testing.T) {
+	assert := assert.New(t)
+
+	priv := core.GenerateKey(nil)
+	other := core.GenerateKey(nil)
+	state := newState(&Resources{Signer: priv})
+	state.standby = newStandby(Config{Standby: true})
+
+	state.standby.onViewChange()
+	state.observeBlock(core.NewBlock().Sign(other))
+	state.observeVote(core.NewBlock().Sign(other).Vote(other))
+	assert.EqualValues(1, state.standby.getSilentViews(priv.PublicKey()))
+	assert.EqualValues(0, state.standby.getSilentViews(other.PublicKey()))
+
+	state.observeBlock(core.NewBlock().Sign(priv))
+	assert.EqualValues(0, state.standby.getSilentViews(priv.PublicKey()))
+
+	state.standby.onViewChange()
+	state.observeVote(core.NewBlock().Sign(other).Vote(priv))
+	assert.EqualValues(0, state.standby.getSilentViews(priv.PublicKey()))
+	assert.EqualValues(1, state.standby.getSilentViews(other.PublicKey()))
+
+	// signature inside qc of other proposer's block
+	state.standby.onViewChange()
+	parent := core.NewBlock().SetHeight(1).Sign(other)
+	qc := core.NewQuorumCert().Build([]*core.Vote{parent.Vote(priv)})
+	state.observeBlock(core.NewBlock().SetHeight(2).SetQuorumCert(qc).Sign(other))
+	assert.EqualValues(0, state.standby.getSilentViews(priv.PublicKey()))
+}
+
+func TestState_confirmSilent(t *testing.T) {
+	assert := assert.New(t)
+
+	privs := make([]*core.PrivateKey, 4)
+	vlds := make([]*core.PublicKey, 4)
+	for i := range privs {
+		privs[i] = core.GenerateKey(nil)
+		vlds[i] = privs[i].PublicKey()
+	}
+	identity := vlds[0]
+	msgSvc := new(MockMsgService)
+	state := newState(&Resources{
+		Signer:   privs[0],
+		VldStore: core.NewValidatorStore(vlds),
+		MsgSvc:   msgSvc,
+	})
+
+	// only one peer confirms, primary may be cut off from the standby only
+	msgSvc.On("RequestSilentViews", vlds[1], identity).Return(3, nil).Once()
+	msgSvc.On("RequestSilentViews", vlds[2], identity).Return(1, nil).Once()
+	msgSvc.On("RequestSilentViews", vlds[3], identity).Return(0, errors.New("timeout")).Once()
+	assert.ErrorIs(state.confirmSilent(3), ErrPrimaryNotSilent)
+	msgSvc.AssertExpectations(t)
+
+	msgSvc.On("RequestSilentViews", vlds[1], identity).Return(3, nil).Once()
+	msgSvc.On("RequestSilentViews", vlds[2], identity).Return(5, nil).Once()
+	msgSvc.On("RequestSilentViews", vlds[3], identity).Return(0, errors.New("timeout")).Once()
+	assert.NoError(state.confirmSilent(3))
+	msgSvc.AssertExpectations(t)
+}
+
+func TestHsDriver_VoteBlockStandby(t *testing.T) {
+	hsd := setupTestHsDriver()
+	hsd.state.standby = newStandby(Config{Standby: true})
+
+	blk := core.NewBlock().Sign(core.GenerateKey(nil))
+	txPool := new(MockTxPool)
+	txPool.On("SetTxsPending", blk.Transactions())
+	hsd.resources.TxPool = txPool
+
+	msgSvc := new(MockMsgService) // must not be called
+	hsd.resources.MsgSvc = msgSvc
+
+	hsd.VoteBlock(newHsBlock(blk, hsd.state))
+
+	txPool.AssertExpectations(t)
+	msgSvc.AssertNotCalled(t, "SendVote")
+}
+
+func TestPacemaker_onBeatStandby(t *testing.T) {
+	priv := core.GenerateKey(nil)
+	resources := &Resources{
+		Signer:   priv,
+		VldStore: core.NewValidatorStore([]*core.PublicKey{priv.PublicKey()}),
+	}
+	state := newState(resources)
+	state.standby = newStandby(Config{Standby: true})
+	pm := &pacemaker{
+		resources: resources,
+		state:     state,
+		stopCh:    make(chan struct{}),
+	}
+	assert.True(t, state.isThisNodeLeader())
+	// hotstuff is nil, onBeat would panic if it proposed
+	assert.NotPanics(t, pm.onBeat)
+}
+
+func TestState_checkWAL(t *testing.T) {
+	assert := assert.New(t)
+
+	priv := core.GenerateKey(nil)
+	other := core.GenerateKey(nil)
+	state := newState(&Resources{Signer: priv})
+	state.standby = newStandby(Config{Standby: true})
+	assert.NoError(state.checkWAL(1), "primary never seen")
+
+	// other's block doesn't change wal
+	state.observeBlock(core.NewBlock().SetHeight(2).Sign(other))
+	assert.NoError(state.checkWAL(2))
+
+	b3 := core.NewBlock().SetHeight(3).Sign(priv)
+	state.setBlock(b3)
+	state.observeBlock(b3)
+	assert.ErrorIs(state.checkWAL(3), ErrPrimaryNotSilent)
+
+	// primary's vote inside the qc of next block
+	b4 := core.NewBlock().SetHeight(4).Sign(other)
+	state.setBlock(b4)
+	qc := core.NewQuorumCert().Build([]*core.Vote{b4.Vote(priv)})
+	state.observeBlock(core.NewBlock().SetHeight(5).SetQuorumCert(qc).Sign(other))
+	assert.ErrorIs(state.checkWAL(4), ErrPrimaryNotSilent)
+	assert.NoError(state.checkWAL(5))
+
+	// promoted node continues above primary's last signed height
+	assert.NoError(state.standby.promote())
+	assert.ErrorIs(state.wal.record(4, []byte("other block")), ErrSignedHeight)
+	assert.NoError(state.wal.record(5, []byte("block")))
+}
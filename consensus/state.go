@@ -12,6 +12,10 @@ import (
 
 type state struct {
 	resources *Resources
+	standby   *standby
+	wal       *signWAL
+
+	equivocations *equivocations
 
 	blocks    map[string]*core.Block
 	mtxBlocks sync.RWMutex
@@ -26,6 +30,10 @@ type state struct {
 
 	leaderIndex int64
 
+	// approved leaders since node start
+	leaderHistory    core.LeaderHistory
+	mtxLeaderHistory sync.RWMutex
+
 	// commited block height. on node restart, it's zero until a block is commited
 	commitedHeight uint64
 
@@ -36,9 +44,13 @@ type state struct {
 func newState(resources *Resources) *state {
 	return &state{
 		resources: resources,
+		standby:   newStandby(Config{}),
+		wal:       &signWAL{},
 		blocks:    make(map[string]*core.Block),
 		commited:  make(map[string]struct{}),
 		qcs:       make(map[string]*core.QuorumCert),
+
+		equivocations: newEquivocations(),
 	}
 }
 
@@ -165,6 +177,34 @@ func (state *state) getLeaderIndex() int {
 	return int(atomic.LoadInt64(&state.leaderIndex))
 }
 
+// maximum entries kept in leader history, the oldest entries are dropped
+const leaderHistoryLimit = 10000
+
+// recordLeader records the leader in effect from block height
+func (state *state) recordLeader(height uint64, idx int) {
+	state.mtxLeaderHistory.Lock()
+	defer state.mtxLeaderHistory.Unlock()
+
+	n := len(state.leaderHistory)
+	for n > 0 && state.leaderHistory[n-1].Height >= height { // replaced by a fork
+		n--
+	}
+	state.leaderHistory = state.leaderHistory[:n]
+	if n > 0 && state.leaderHistory[n-1].Leader == idx {
+		return
+	}
+	state.leaderHistory = append(state.leaderHistory, core.LeaderEntry{Height: height, Leader: idx})
+	if len(state.leaderHistory) > leaderHistoryLimit {
+		state.leaderHistory = state.leaderHistory[1:]
+	}
+}
+
+func (state *state) getLeaderHistory() core.LeaderHistory {
+	state.mtxLeaderHistory.RLock()
+	defer state.mtxLeaderHistory.RUnlock()
+	return append(core.LeaderHistory{}, state.leaderHistory...)
+}
+
 func (state *state) getFaultyCount() int {
 	return state.resources.VldStore.ValidatorCount() - state.resources.VldStore.MajorityCount()
 }
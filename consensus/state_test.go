@@ -0,0 +1,35 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package consensus
+
+import (
+	"testing"
+
+	"github.com/aungmawjj/juria-blockchain/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestState_recordLeader(t *testing.T) {
+	assert := assert.New(t)
+
+	entry := func(height uint64, leader int) core.LeaderEntry {
+		return core.LeaderEntry{Height: height, Leader: leader}
+	}
+	state := newState(new(Resources))
+	state.recordLeader(0, 0)
+	state.recordLeader(3, 0) // same leader
+	state.recordLeader(5, 1)
+	state.recordLeader(9, 2)
+	assert.Equal(core.LeaderHistory{entry(0, 0), entry(5, 1), entry(9, 2)}, state.getLeaderHistory())
+
+	state.recordLeader(7, 3) // qc of a fork below the last entry
+	assert.Equal(core.LeaderHistory{entry(0, 0), entry(5, 1), entry(7, 3)}, state.getLeaderHistory())
+
+	for i := 0; i < leaderHistoryLimit; i++ {
+		state.recordLeader(uint64(10+i), i%2)
+	}
+	lh := state.getLeaderHistory()
+	assert.Len(lh, leaderHistoryLimit)
+	assert.EqualValues(10, lh[0].Height)
+}
@@ -19,6 +19,12 @@ type Status struct {
 	PendingViewChange bool
 	LeaderIndex       int
 
+	// node holds validator key but doesn't sign
+	Standby bool
+
+	// validators seen signing two blocks at the same height since node is up
+	Equivocations uint64
+
 	// hotstuff state (block heights)
 	BVote  uint64
 	BLock  uint64
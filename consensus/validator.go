@@ -16,8 +16,10 @@ import (
 
 type validator struct {
 	resources *Resources
+	config    Config
 	state     *state
 	hotstuff  *hotstuff.Hotstuff
+	hsDriver  *hsDriver
 
 	mtxProposal sync.Mutex
 
@@ -104,9 +106,10 @@ func (vld *validator) onReceiveProposal(proposal *core.Block) error {
 	vld.mtxProposal.Lock()
 	defer vld.mtxProposal.Unlock()
 
-	if err := proposal.Validate(vld.resources.VldStore); err != nil {
+	if err := proposal.Validate(vld.resources.VldStore, vld.blockValidateOptions()); err != nil {
 		return err
 	}
+	vld.state.observeBlock(proposal)
 	pidx := vld.resources.VldStore.GetValidatorIndex(proposal.Proposer())
 	logger.I().Debugw("received proposal", "proposer", pidx, "height", proposal.Height())
 	parent, err := vld.getParentBlock(proposal)
@@ -147,6 +150,9 @@ func (vld *validator) syncMissingCommitedBlocks(proposal *core.Block) error {
 			return err
 		}
 	}
+	if err := proposal.QuorumCert().CheckBlock(qcRef); err != nil {
+		return fmt.Errorf("invalid qc ref, %w", err)
+	}
 	if qcRef.Height() < commitHeight {
 		return fmt.Errorf("old qc ref %d", qcRef.Height())
 	}
@@ -154,24 +160,99 @@ func (vld *validator) syncMissingCommitedBlocks(proposal *core.Block) error {
 		proposal.Proposer(), commitHeight+1, proposal.ExecHeight())
 }
 
+// syncRangeSize is the max number of blocks requested at once while syncing commited blocks
+const syncRangeSize = 100
+
 func (vld *validator) syncForwardCommitedBlocks(peer *core.PublicKey, start, end uint64) error {
-	var blk *core.Block
-	for height := start; height < end; height++ { // end is exclusive
-		var err error
-		blk, err = vld.requestBlockByHeight(peer, height)
+	if vld.hsDriver != nil {
+		vld.hsDriver.startCommitBatch()
+		defer vld.hsDriver.endCommitBatch()
+	}
+	for height := start; height < end; { // end is exclusive
+		to := end - 1
+		if to-height >= syncRangeSize {
+			to = height + syncRangeSize - 1
+		}
+		blocks, qcs, err := vld.requestCommitedBlocks(peer, height, to)
 		if err != nil {
 			return err
 		}
-		parent := vld.state.getBlock(blk.ParentHash())
-		if parent == nil {
-			return fmt.Errorf("cannot connect chain, parent not found")
+		for i, blk := range blocks {
+			parent := vld.state.getBlock(blk.ParentHash())
+			if parent == nil {
+				return fmt.Errorf("cannot connect chain, parent not found")
+			}
+			err = vld.verifyWithParentAndUpdateHotstuff(peer, blk, parent, false)
+			if err != nil {
+				return err
+			}
+			if qcs[i] != nil { // commited with the block
+				vld.state.setQC(qcs[i])
+			}
+		}
+		height += uint64(len(blocks))
+	}
+	return nil
+}
+
+// requestCommitedBlocks requests the commited blocks of the height range with the qcs certifying them.
+// The qc of a block is taken from the next block if it certifies the block, otherwise it's requested.
+// A block without qc of its own is commited as the parent of the next commited block, its qc is nil.
+// If the last block can't be verified, it's left for the next range.
+// If the peer doesn't respond the range, it requests the first block with qc.
+func (vld *validator) requestCommitedBlocks(
+	peer *core.PublicKey, from, to uint64,
+) ([]*core.Block, []*core.QuorumCert, error) {
+	blocks, err := vld.requestBlocksByRange(peer, from, to)
+	if err != nil || len(blocks) == 0 {
+		logger.I().Debugw("request blocks by range failed", "from", from, "error", err)
+		blk, qc, err := vld.requestBlockWithQCByHeight(peer, from)
+		if err != nil {
+			return nil, nil, err
+		}
+		return []*core.Block{blk}, []*core.QuorumCert{qc}, nil
+	}
+	qcs := make([]*core.QuorumCert, len(blocks))
+	end := len(blocks) // blocks before end are verified
+	for i := len(blocks) - 1; i >= 0; i-- {
+		blk := blocks[i]
+		if i+1 < len(blocks) && blocks[i+1].QuorumCert().CheckBlock(blk) == nil {
+			qcs[i] = blocks[i+1].QuorumCert() // validated with the next block
+			continue
 		}
-		err = vld.verifyWithParentAndUpdateHotstuff(peer, blk, parent, false)
+		if i+1 < end && bytes.Equal(blocks[i+1].ParentHash(), blk.Hash()) {
+			continue // commited with the next block
+		}
+		qblk, qc, err := vld.requestBlockWithQCByHeight(peer, blk.Height())
 		if err != nil {
-			return err
+			if i > 0 && i == len(blocks)-1 {
+				end = i
+				continue
+			}
+			return nil, nil, err
 		}
+		if !bytes.Equal(qblk.Hash(), blk.Hash()) {
+			return nil, nil, fmt.Errorf("different block at height %d", blk.Height())
+		}
+		qcs[i] = qc
 	}
-	return nil
+	return blocks[:end], qcs[:end], nil
+}
+
+// requestBlocksByRange requests the blocks of the height range and validates them
+func (vld *validator) requestBlocksByRange(
+	peer *core.PublicKey, from, to uint64,
+) ([]*core.Block, error) {
+	blocks, err := vld.resources.MsgSvc.RequestBlocksByRange(peer, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("cannot request blocks by range %d to %d, %w", from, to, err)
+	}
+	for _, blk := range blocks {
+		if err := blk.Validate(vld.resources.VldStore, vld.blockValidateOptions()); err != nil {
+			return nil, fmt.Errorf("validate block error %w", err)
+		}
+	}
+	return blocks, nil
 }
 
 func (vld *validator) syncMissingParentBlocksRecursive(
@@ -201,21 +282,31 @@ func (vld *validator) requestBlock(peer *core.PublicKey, hash []byte) (*core.Blo
 	if err != nil {
 		return nil, fmt.Errorf("cannot request block %w", err)
 	}
-	if err := blk.Validate(vld.resources.VldStore); err != nil {
+	if err := blk.Validate(vld.resources.VldStore, vld.blockValidateOptions()); err != nil {
 		return nil, fmt.Errorf("validate block error %w", err)
 	}
 	return blk, nil
 }
 
-func (vld *validator) requestBlockByHeight(peer *core.PublicKey, height uint64) (*core.Block, error) {
-	blk, err := vld.resources.MsgSvc.RequestBlockByHeight(peer, height)
+// requestBlockWithQCByHeight requests a commited block with the qc certifying it,
+// so that the block is verified to be commited rather than trusting the peer
+func (vld *validator) requestBlockWithQCByHeight(
+	peer *core.PublicKey, height uint64,
+) (*core.Block, *core.QuorumCert, error) {
+	blk, qc, err := vld.resources.MsgSvc.RequestBlockWithQCByHeight(peer, height)
 	if err != nil {
-		return nil, fmt.Errorf("cannot get block by height %d, %w", height, err)
+		return nil, nil, fmt.Errorf("cannot get block with qc by height %d, %w", height, err)
 	}
-	if err := blk.Validate(vld.resources.VldStore); err != nil {
-		return nil, fmt.Errorf("validate block error %w", err)
+	if err := blk.Validate(vld.resources.VldStore, vld.blockValidateOptions()); err != nil {
+		return nil, nil, fmt.Errorf("validate block error %w", err)
 	}
-	return blk, nil
+	if err := qc.CheckBlock(blk); err != nil {
+		return nil, nil, fmt.Errorf("qc doesn't certify block %d, %w", height, err)
+	}
+	if err := qc.Validate(vld.resources.VldStore); err != nil {
+		return nil, nil, fmt.Errorf("validate qc error %w", err)
+	}
+	return blk, qc, nil
 }
 
 func (vld *validator) verifyWithParentAndUpdateHotstuff(
@@ -225,6 +316,9 @@ func (vld *validator) verifyWithParentAndUpdateHotstuff(
 		return fmt.Errorf("invalid block height %d, parent %d",
 			blk.Height(), parent.Height())
 	}
+	if err := vld.checkQCBlock(blk.QuorumCert()); err != nil {
+		return err
+	}
 	// must sync transactions before updating block to hotstuff
 	if err := vld.resources.TxPool.SyncTxs(peer, blk.Transactions()); err != nil {
 		return err
@@ -276,7 +370,7 @@ func (vld *validator) verifyMerkleRoot(proposal *core.Block) error {
 }
 
 func (vld *validator) verifyProposalTxs(proposal *core.Block) error {
-	for _, hash := range proposal.Transactions() {
+	for _, hash := range proposal.TransactionsRef() {
 		if vld.resources.Storage.HasTx(hash) {
 			return fmt.Errorf("already commited tx: %s", base64String(hash))
 		}
@@ -291,15 +385,29 @@ func (vld *validator) verifyProposalTxs(proposal *core.Block) error {
 	return nil
 }
 
+func (vld *validator) blockValidateOptions() core.BlockValidateOptions {
+	return core.BlockValidateOptions{
+		MaxTxPerBlock: vld.config.MaxTxPerBlock,
+	}
+}
+
 func (vld *validator) onReceiveVote(vote *core.Vote) error {
+	// drop invalid votes before they reach qc building
+	if err := vote.CheckBlock(vld.state.getBlock(vote.BlockHash())); err != nil {
+		return fmt.Errorf("invalid vote from %s, %w", vote.Voter(), err)
+	}
 	if err := vote.Validate(vld.resources.VldStore); err != nil {
-		return err
+		return fmt.Errorf("invalid vote from %s, %w", vote.Voter(), err)
 	}
+	vld.state.observeVote(vote)
 	vld.hotstuff.OnReceiveVote(newHsVote(vote, vld.state))
 	return nil
 }
 
 func (vld *validator) onReceiveNewView(qc *core.QuorumCert) error {
+	if err := vld.checkQCBlock(qc); err != nil {
+		return err
+	}
 	if err := qc.Validate(vld.resources.VldStore); err != nil {
 		return err
 	}
@@ -307,6 +415,14 @@ func (vld *validator) onReceiveNewView(qc *core.QuorumCert) error {
 	return nil
 }
 
+// checkQCBlock checks the height of qc with the block it certifies, the block must be known
+func (vld *validator) checkQCBlock(qc *core.QuorumCert) error {
+	if err := qc.CheckBlock(vld.state.getBlock(qc.BlockHash())); err != nil {
+		return fmt.Errorf("invalid qc ref, %w", err)
+	}
+	return nil
+}
+
 func base64String(b []byte) string {
 	return base64.StdEncoding.EncodeToString(b)
 }
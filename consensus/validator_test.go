@@ -4,10 +4,14 @@
 package consensus
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/aungmawjj/juria-blockchain/core"
+	"github.com/aungmawjj/juria-blockchain/core/core_pb"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"google.golang.org/protobuf/proto"
 )
 
 func TestValidator_verifyProposalToVote(t *testing.T) {
@@ -112,3 +116,171 @@ func TestValidator_verifyProposalToVote(t *testing.T) {
 		})
 	}
 }
+
+func TestValidator_requestBlockWithQCByHeight(t *testing.T) {
+	priv0 := core.GenerateKey(nil)
+	priv1 := core.GenerateKey(nil)
+	resources := &Resources{
+		VldStore: core.NewValidatorStore([]*core.PublicKey{priv0.PublicKey()}),
+	}
+	mMsgSvc := new(MockMsgService)
+	resources.MsgSvc = mMsgSvc
+	vld := &validator{
+		resources: resources,
+		state:     newState(resources),
+	}
+
+	parent := core.NewBlock().SetHeight(4).Sign(priv0)
+	pqc := core.NewQuorumCert().Build([]*core.Vote{parent.ProposerVote()})
+	blk := core.NewBlock().SetHeight(5).SetParentHash(parent.Hash()).SetQuorumCert(pqc).Sign(priv0)
+	qc := core.NewQuorumCert().Build([]*core.Vote{blk.ProposerVote()})
+	other := core.NewBlock().SetHeight(5).SetParentHash(parent.Hash()).SetQuorumCert(pqc).Sign(priv1)
+	otherQC := core.NewQuorumCert().Build([]*core.Vote{other.Vote(priv1)})
+	fakeQC := core.NewQuorumCert().Build([]*core.Vote{blk.Vote(priv1)})
+
+	tests := []struct {
+		name  string
+		valid bool
+		qc    *core.QuorumCert
+	}{
+		{"valid", true, qc},
+		{"qc of parent", false, pqc},
+		{"qc of other block", false, otherQC},
+		{"qc signed by non validator", false, fakeQC},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+			height := uint64(i)
+			mMsgSvc.On("RequestBlockWithQCByHeight", priv1.PublicKey(), height).Return(blk, tt.qc, nil)
+			recvBlk, recvQC, err := vld.requestBlockWithQCByHeight(priv1.PublicKey(), height)
+			if tt.valid {
+				assert.NoError(err)
+				assert.Equal(blk, recvBlk)
+				assert.Equal(qc, recvQC)
+			} else {
+				assert.Error(err)
+			}
+		})
+	}
+}
+
+func TestValidator_onReceiveVote(t *testing.T) {
+	assert := assert.New(t)
+
+	priv0 := core.GenerateKey(nil)
+	priv1 := core.GenerateKey(nil)
+	mStrg := new(MockStorage)
+	mStrg.On("GetBlock", mock.Anything).Return(nil, errors.New("not found"))
+	resources := &Resources{
+		VldStore: core.NewValidatorStore([]*core.PublicKey{priv0.PublicKey()}),
+		Storage:  mStrg,
+	}
+	vld := &validator{
+		resources: resources,
+		state:     newState(resources),
+	}
+	blk := core.NewBlock().SetHeight(1).Sign(priv0)
+	vld.state.setBlock(blk)
+
+	// vote from non validator is dropped before reaching hotstuff
+	vote := blk.Vote(priv1)
+	err := vld.onReceiveVote(vote)
+	assert.ErrorIs(err, core.ErrInvalidValidator)
+	assert.Contains(err.Error(), priv1.PublicKey().String())
+
+	tamper := func(fn func(data *core_pb.Vote)) *core.Vote {
+		b, _ := blk.Vote(priv0).Marshal()
+		data := new(core_pb.Vote)
+		assert.NoError(proto.Unmarshal(b, data))
+		fn(data)
+		b, _ = proto.Marshal(data)
+		vote := core.NewVote()
+		assert.NoError(vote.Unmarshal(b))
+		return vote
+	}
+
+	// vote with invalid signature
+	err = vld.onReceiveVote(tamper(func(data *core_pb.Vote) {
+		data.Signature.Value[0] ^= 1
+	}))
+	assert.ErrorIs(err, core.ErrInvalidSig)
+	assert.Contains(err.Error(), priv0.PublicKey().String())
+
+	// vote with a height other than the block height
+	err = vld.onReceiveVote(tamper(func(data *core_pb.Vote) {
+		data.BlockHeight = 0
+	}))
+	assert.ErrorIs(err, core.ErrBlockHeightMismatch)
+
+	// vote for unknown block
+	err = vld.onReceiveVote(tamper(func(data *core_pb.Vote) {
+		data.BlockHash = []byte("other block")
+	}))
+	assert.ErrorIs(err, core.ErrNilBlock)
+}
+
+func TestValidator_requestCommitedBlocks(t *testing.T) {
+	assert := assert.New(t)
+
+	priv := core.GenerateKey(nil)
+	resources := &Resources{
+		VldStore: core.NewValidatorStore([]*core.PublicKey{priv.PublicKey()}),
+	}
+	mMsgSvc := new(MockMsgService)
+	resources.MsgSvc = mMsgSvc
+	vld := &validator{
+		resources: resources,
+		state:     newState(resources),
+	}
+
+	chain := make([]*core.Block, 5)
+	qcs := make([]*core.QuorumCert, 5)
+	chain[0] = core.NewBlock().SetHeight(0).Sign(priv)
+	qcs[0] = core.NewQuorumCert().Build([]*core.Vote{chain[0].ProposerVote()})
+	for i := 1; i < len(chain); i++ {
+		chain[i] = core.NewBlock().SetHeight(uint64(i)).SetParentHash(chain[i-1].Hash()).
+			SetQuorumCert(qcs[i-1]).Sign(priv)
+		qcs[i] = core.NewQuorumCert().Build([]*core.Vote{chain[i].ProposerVote()})
+	}
+	peer := core.GenerateKey(nil).PublicKey()
+
+	// qcs from the next blocks, the last one is requested
+	mMsgSvc.On("RequestBlocksByRange", peer, uint64(1), uint64(4)).Return(chain[1:], nil).Once()
+	mMsgSvc.On("RequestBlockWithQCByHeight", peer, uint64(4)).Return(chain[4], qcs[4], nil).Once()
+	blocks, recvQCs, err := vld.requestCommitedBlocks(peer, 1, 4)
+	assert.NoError(err)
+	assert.Equal(chain[1:], blocks)
+	assert.Equal(qcs[1:], recvQCs)
+
+	// peer without range requests
+	mMsgSvc.On("RequestBlocksByRange", peer, uint64(2), uint64(4)).
+		Return(nil, errors.New("no handler for request")).Once()
+	mMsgSvc.On("RequestBlockWithQCByHeight", peer, uint64(2)).Return(chain[2], qcs[2], nil).Once()
+	blocks, recvQCs, err = vld.requestCommitedBlocks(peer, 2, 4)
+	assert.NoError(err)
+	assert.Equal(chain[2:3], blocks)
+	assert.Equal(qcs[2:3], recvQCs)
+
+	// qc request responds other block
+	mMsgSvc.On("RequestBlocksByRange", peer, uint64(3), uint64(4)).Return(chain[3:], nil).Once()
+	mMsgSvc.On("RequestBlockWithQCByHeight", peer, uint64(4)).Return(chain[3], qcs[3], nil).Once()
+	_, _, err = vld.requestCommitedBlocks(peer, 3, 4)
+	assert.Error(err)
+
+	// block 2 without qc of its own, block 3 carries the qc of block 1
+	fork := append([]*core.Block{}, chain[:3]...)
+	fork = append(fork, core.NewBlock().SetHeight(3).SetParentHash(fork[2].Hash()).
+		SetQuorumCert(qcs[1]).Sign(priv))
+	fork = append(fork, core.NewBlock().SetHeight(4).SetParentHash(fork[3].Hash()).
+		SetQuorumCert(core.NewQuorumCert().Build([]*core.Vote{fork[3].ProposerVote()})).Sign(priv))
+	mMsgSvc.On("RequestBlocksByRange", peer, uint64(1), uint64(5)).Return(fork[1:], nil).Once()
+	mMsgSvc.On("RequestBlockWithQCByHeight", peer, uint64(4)).
+		Return(nil, nil, errors.New("qc not found")).Once()
+	blocks, recvQCs, err = vld.requestCommitedBlocks(peer, 1, 5)
+	assert.NoError(err)
+	assert.Equal(fork[1:4], blocks, "last block without qc is left")
+	assert.Equal([]*core.QuorumCert{qcs[1], nil, fork[4].QuorumCert()}, recvQCs)
+
+	mMsgSvc.AssertExpectations(t)
+}
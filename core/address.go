@@ -0,0 +1,96 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package core
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// AddressSize is the size of address in bytes
+const AddressSize = 20
+
+// AddressHRP is the human readable prefix of bech32 address strings.
+// It must be set before any address is encoded or parsed.
+var AddressHRP = "juria"
+
+// errors
+var (
+	ErrInvalidAddress     = errors.New("invalid address")
+	ErrInvalidAddressSize = errors.New("invalid address size")
+	ErrAddressPrefix      = errors.New("invalid address prefix")
+	ErrAddressChecksum    = errors.New("invalid address checksum")
+)
+
+// Address type, the last 20 bytes of sha3 sum of the public key
+type Address [AddressSize]byte
+
+var _ encoding.TextMarshaler = Address{}
+var _ encoding.TextUnmarshaler = (*Address)(nil)
+
+// NewAddress creates Address from bytes
+func NewAddress(b []byte) (Address, error) {
+	var addr Address
+	if len(b) != AddressSize {
+		return addr, ErrInvalidAddressSize
+	}
+	copy(addr[:], b)
+	return addr, nil
+}
+
+// ParseAddress parses bech32 address string and validates its checksum
+func ParseAddress(s string) (Address, error) {
+	var addr Address
+	hrp, data, err := bech32Decode(s)
+	if err == errBech32Checksum {
+		return addr, fmt.Errorf("%w %q", ErrAddressChecksum, s)
+	}
+	if err != nil {
+		return addr, fmt.Errorf("%w %q, %v", ErrInvalidAddress, s, err)
+	}
+	if hrp != AddressHRP {
+		return addr, fmt.Errorf("%w %q, expected %q", ErrAddressPrefix, hrp, AddressHRP)
+	}
+	b, err := bech32ConvertBits(data, 5, 8, false)
+	if err != nil {
+		return addr, fmt.Errorf("%w %q, %v", ErrInvalidAddress, s, err)
+	}
+	return NewAddress(b)
+}
+
+func newAddressFromPublicKey(pub *PublicKey) Address {
+	var addr Address
+	sum := sha3.Sum256(pub.key)
+	copy(addr[:], sum[len(sum)-AddressSize:])
+	return addr
+}
+
+// Bytes return raw bytes
+func (addr Address) Bytes() []byte {
+	return addr[:]
+}
+
+// String returns bech32 encoded address
+func (addr Address) String() string {
+	data, _ := bech32ConvertBits(addr[:], 8, 5, true)
+	return bech32Encode(AddressHRP, data)
+}
+
+// MarshalText encodes address as bech32 string
+func (addr Address) MarshalText() ([]byte, error) {
+	return []byte(addr.String()), nil
+}
+
+// UnmarshalText decodes address from bech32 string
+func (addr *Address) UnmarshalText(b []byte) error {
+	val, err := ParseAddress(string(b))
+	if err != nil {
+		return err
+	}
+	*addr = val
+	return nil
+}
@@ -0,0 +1,106 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package core
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBech32Decode(t *testing.T) {
+	// valid test vectors from BIP-0173
+	valid := []string{
+		"A12UEL5L",
+		"a12uel5l",
+		"abcdef1qpzry9x8gf2tvdw0s3jn54khce6mua7lmqqqxw",
+		"split1checkupstagehandshakeupstreamerranterredcaperred2y9e3w",
+	}
+	for _, s := range valid {
+		_, _, err := bech32Decode(s)
+		assert.NoError(t, err, s)
+	}
+	invalid := []string{
+		"pzry9x0s0muk",    // no separator
+		"1pzry9x0s0muk",   // empty hrp
+		"x1b4n0q5v",       // invalid data character
+		"li1dgmt3",        // too short checksum
+		"A1G7SGD8",        // checksum calculated with uppercase hrp
+		"10a06t8",         // empty hrp
+		"1qzzfhee",        // empty hrp
+		"a12UEL5L",        // mixed case
+		"abcdef1qpzry9x8", // checksum mismatch
+	}
+	for _, s := range invalid {
+		_, _, err := bech32Decode(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestAddress(t *testing.T) {
+	assert := assert.New(t)
+
+	pub := GenerateKey(nil).PublicKey()
+	addr := pub.Address()
+
+	assert.Equal(AddressSize, len(addr.Bytes()))
+	assert.Equal(addr, pub.Address())
+	assert.NotEqual(addr, GenerateKey(nil).PublicKey().Address())
+	assert.True(strings.HasPrefix(addr.String(), AddressHRP+"1"))
+
+	addr1, err := ParseAddress(addr.String())
+	assert.NoError(err)
+	assert.Equal(addr, addr1)
+
+	addr1, err = ParseAddress(strings.ToUpper(addr.String()))
+	assert.NoError(err)
+	assert.Equal(addr, addr1)
+
+	addr1, err = NewAddress(addr.Bytes())
+	assert.NoError(err)
+	assert.Equal(addr, addr1)
+
+	_, err = NewAddress(pub.Bytes())
+	assert.ErrorIs(err, ErrInvalidAddressSize)
+
+	b, err := json.Marshal(addr)
+	assert.NoError(err)
+	assert.Equal(`"`+addr.String()+`"`, string(b))
+
+	var addr2 Address
+	assert.NoError(json.Unmarshal(b, &addr2))
+	assert.Equal(addr, addr2)
+}
+
+func TestParseAddress_Invalid(t *testing.T) {
+	addr := GenerateKey(nil).PublicKey().Address().String()
+
+	tampered := []byte(addr)
+	if tampered[len(tampered)-1] == 'q' {
+		tampered[len(tampered)-1] = 'p'
+	} else {
+		tampered[len(tampered)-1] = 'q'
+	}
+	data, _ := bech32ConvertBits([]byte{1, 2, 3}, 8, 5, true)
+
+	tests := []struct {
+		name string
+		s    string
+		err  error
+	}{
+		{"empty", "", ErrInvalidAddress},
+		{"bad checksum", string(tampered), ErrAddressChecksum},
+		{"other prefix", bech32Encode("other", data), ErrAddressPrefix},
+		{"short data", bech32Encode(AddressHRP, data), ErrInvalidAddressSize},
+		{"invalid character", addr[:len(addr)-1] + "b", ErrInvalidAddress},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseAddress(tt.s)
+			assert.ErrorIs(t, err, tt.err)
+		})
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package core
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// bech32 encoding as specified in BIP-0173
+
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+var bech32Gen = [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+
+var errBech32Checksum = errors.New("checksum mismatch")
+
+func bech32Polymod(values []byte) uint32 {
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (top>>uint(i))&1 == 1 {
+				chk ^= bech32Gen[i]
+			}
+		}
+	}
+	return chk
+}
+
+func bech32HrpExpand(hrp string) []byte {
+	ret := make([]byte, 0, len(hrp)*2+1)
+	for i := 0; i < len(hrp); i++ {
+		ret = append(ret, hrp[i]>>5)
+	}
+	ret = append(ret, 0)
+	for i := 0; i < len(hrp); i++ {
+		ret = append(ret, hrp[i]&31)
+	}
+	return ret
+}
+
+func bech32Checksum(hrp string, data []byte) []byte {
+	values := append(bech32HrpExpand(hrp), data...)
+	values = append(values, 0, 0, 0, 0, 0, 0)
+	mod := bech32Polymod(values) ^ 1
+	ret := make([]byte, 6)
+	for i := range ret {
+		ret[i] = byte(mod>>uint(5*(5-i))) & 31
+	}
+	return ret
+}
+
+func bech32VerifyChecksum(hrp string, data []byte) bool {
+	return bech32Polymod(append(bech32HrpExpand(hrp), data...)) == 1
+}
+
+// bech32Encode encodes 5-bit data with the human readable part
+func bech32Encode(hrp string, data []byte) string {
+	combined := make([]byte, 0, len(data)+6)
+	combined = append(combined, data...)
+	combined = append(combined, bech32Checksum(hrp, data)...)
+	var sb strings.Builder
+	sb.Grow(len(hrp) + 1 + len(combined))
+	sb.WriteString(hrp)
+	sb.WriteByte('1')
+	for _, b := range combined {
+		sb.WriteByte(bech32Charset[b])
+	}
+	return sb.String()
+}
+
+// bech32Decode decodes bech32 string and returns the human readable part and 5-bit data
+func bech32Decode(s string) (string, []byte, error) {
+	if len(s) < 8 || len(s) > 90 {
+		return "", nil, fmt.Errorf("invalid length %d", len(s))
+	}
+	if strings.ToLower(s) != s && strings.ToUpper(s) != s {
+		return "", nil, errors.New("mixed case")
+	}
+	s = strings.ToLower(s)
+	pos := strings.LastIndexByte(s, '1')
+	if pos < 1 || pos+7 > len(s) {
+		return "", nil, errors.New("invalid separator position")
+	}
+	hrp := s[:pos]
+	for i := 0; i < len(hrp); i++ {
+		if hrp[i] < 33 || hrp[i] > 126 {
+			return "", nil, fmt.Errorf("invalid character in prefix at position %d", i)
+		}
+	}
+	data := make([]byte, 0, len(s)-pos-1)
+	for i := pos + 1; i < len(s); i++ {
+		d := strings.IndexByte(bech32Charset, s[i])
+		if d == -1 {
+			return "", nil, fmt.Errorf("invalid character %q at position %d", s[i], i)
+		}
+		data = append(data, byte(d))
+	}
+	if !bech32VerifyChecksum(hrp, data) {
+		return "", nil, errBech32Checksum
+	}
+	return hrp, data[:len(data)-6], nil
+}
+
+// bech32ConvertBits regroups bits of data from one width to another
+func bech32ConvertBits(data []byte, fromBits, toBits uint, pad bool) ([]byte, error) {
+	acc := uint32(0)
+	bits := uint(0)
+	maxv := uint32(1)<<toBits - 1
+	ret := make([]byte, 0, len(data)*int(fromBits)/int(toBits)+1)
+	for _, v := range data {
+		if uint32(v)>>fromBits != 0 {
+			return nil, fmt.Errorf("invalid data range %d", v)
+		}
+		acc = acc<<fromBits | uint32(v)
+		bits += fromBits
+		for bits >= toBits {
+			bits -= toBits
+			ret = append(ret, byte(acc>>bits&maxv))
+		}
+	}
+	if pad {
+		if bits > 0 {
+			ret = append(ret, byte(acc<<(toBits-bits)&maxv))
+		}
+	} else if bits >= fromBits || acc<<(toBits-bits)&maxv != 0 {
+		return nil, errors.New("invalid padding")
+	}
+	return ret, nil
+}
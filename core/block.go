@@ -5,12 +5,12 @@ package core
 
 import (
 	"bytes"
-	"encoding/binary"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"sync"
 
 	"github.com/aungmawjj/juria-blockchain/core/core_pb"
-	"golang.org/x/crypto/sha3"
 	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/proto"
 )
@@ -19,13 +19,24 @@ import (
 var (
 	ErrInvalidBlockHash = errors.New("invalid block hash")
 	ErrNilBlock         = errors.New("nil block")
+	ErrTooManyTxs       = errors.New("too many txs in block")
+	ErrInvalidBlockBody = errors.New("txs don't match block")
+	ErrInvalidQCHeight  = errors.New("qc height must be lower than block height")
 )
 
+// BlockValidateOptions are optional limits checked by Block.Validate
+type BlockValidateOptions struct {
+	MaxTxPerBlock int // zero means no limit
+}
+
 // Block type
 type Block struct {
 	data       *core_pb.Block
 	proposer   *PublicKey
 	quorumCert *QuorumCert
+
+	sum    []byte // cached Sum, reset by setters
+	mtxSum sync.Mutex
 }
 
 var _ json.Marshaler = (*Block)(nil)
@@ -37,59 +48,136 @@ func NewBlock() *Block {
 	}
 }
 
-// Sum returns sha3 sum of block
+// Sum returns hash of block with the chain wide hash function, prefixed with domain tag from activation height.
+// The result is cached until block is modified by setters.
 func (blk *Block) Sum() []byte {
-	h := sha3.New256()
-	binary.Write(h, binary.BigEndian, blk.data.Height)
-	h.Write(blk.data.ParentHash)
-	h.Write(blk.data.Proposer)
+	blk.mtxSum.Lock()
+	defer blk.mtxSum.Unlock()
+
+	if blk.sum != nil {
+		return copyBytes(blk.sum)
+	}
+	hs := getHasher()
+	if GetDomainSeparation().Active(blk.data.Height) {
+		hs.write(domainBlock)
+	}
+	hs.writeUint64(blk.data.Height)
+	hs.write(blk.data.ParentHash)
+	hs.write(blk.data.Proposer)
 	if blk.data.QuorumCert != nil {
-		h.Write(blk.data.QuorumCert.BlockHash) // qc reference block hash
+		hs.write(blk.data.QuorumCert.BlockHash) // qc reference block hash
+	}
+	hs.writeUint64(blk.data.ExecHeight)
+	hs.write(blk.data.MerkleRoot)
+	hs.writeUint64(uint64(blk.data.Timestamp))
+	if blk.data.TxRoot != nil {
+		hs.write(blk.data.TxRoot) // header can be verified without txs
+	} else {
+		for _, txHash := range blk.data.Transactions {
+			hs.write(txHash)
+		}
 	}
-	binary.Write(h, binary.BigEndian, blk.data.ExecHeight)
-	h.Write(blk.data.MerkleRoot)
-	binary.Write(h, binary.BigEndian, blk.data.Timestamp)
-	for _, txHash := range blk.data.Transactions {
-		h.Write(txHash)
+	if blk.data.HashScheme != 0 { // default scheme keeps the digest unchanged
+		hs.writeUint64(uint64(blk.data.HashScheme))
 	}
-	return h.Sum(nil)
+	blk.sum = hs.sum()
+	return copyBytes(blk.sum)
+}
+
+func (blk *Block) resetSum() {
+	blk.mtxSum.Lock()
+	defer blk.mtxSum.Unlock()
+	blk.sum = nil
 }
 
 // Validate block
-func (blk *Block) Validate(vs ValidatorStore) error {
+func (blk *Block) Validate(vs ValidatorStore, opts ...BlockValidateOptions) error {
 	if blk.data == nil {
 		return ErrNilBlock
 	}
+	if blk.data.HashScheme != hashSchemeID(GetHashFunc()) {
+		return fmt.Errorf("%w, block %d, local %d",
+			ErrHashSchemeMismatch, blk.data.HashScheme, hashSchemeID(GetHashFunc()))
+	}
+	for _, opt := range opts {
+		if opt.MaxTxPerBlock > 0 && len(blk.data.Transactions) > opt.MaxTxPerBlock {
+			return ErrTooManyTxs
+		}
+	}
+	// tx root pairs odd last node with itself, duplicate txs don't change the root
+	if hasDuplicateTx(blk.data.Transactions) {
+		return fmt.Errorf("%w, duplicate tx", ErrInvalidBlockBody)
+	}
+	if blk.data.TxRoot != nil && !bytes.Equal(blk.data.TxRoot, TxRoot(blk.data.Transactions)) {
+		return fmt.Errorf("%w, tx root", ErrInvalidBlockBody)
+	}
 	if !blk.IsGenesis() { // skip quorum cert validation for genesis block
 		if err := blk.quorumCert.Validate(vs); err != nil {
 			return err
 		}
+		if blk.quorumCert.BlockHeight() >= blk.data.Height {
+			return ErrInvalidQCHeight
+		}
 	}
-	if !bytes.Equal(blk.Sum(), blk.Hash()) {
+	if !bytes.Equal(blk.Sum(), blk.data.Hash) {
 		return ErrInvalidBlockHash
 	}
 	sig, err := newSignature(&core_pb.Signature{
 		PubKey: blk.data.Proposer,
 		Value:  blk.data.Signature,
 	})
-	if !vs.IsValidator(sig.PublicKey()) {
+	if !validatorsAt(vs, blk.data.Height).IsValidator(sig.PublicKey()) {
 		return ErrInvalidValidator
 	}
 	if err != nil {
 		return err
 	}
-	if !sig.Verify(blk.data.Hash) {
+	if !sig.VerifyCached(voteDigest(blk.data.Hash, blk.data.Height)) {
 		return ErrInvalidSig
 	}
 	return nil
 }
 
+// VerifyBody checks that txs are the block's transactions in order
+func (blk *Block) VerifyBody(txs []*Transaction) error {
+	if len(txs) != len(blk.data.Transactions) {
+		return fmt.Errorf("%w, got %d txs, block has %d",
+			ErrInvalidBlockBody, len(txs), len(blk.data.Transactions))
+	}
+	if hasDuplicateTx(blk.data.Transactions) {
+		return fmt.Errorf("%w, duplicate tx", ErrInvalidBlockBody)
+	}
+	for i, tx := range txs {
+		if tx == nil {
+			return fmt.Errorf("%w, nil tx at %d", ErrInvalidBlockBody, i)
+		}
+		if !bytes.Equal(tx.data.Hash, blk.data.Transactions[i]) {
+			return fmt.Errorf("%w, tx %d hash %x, block has %x",
+				ErrInvalidBlockBody, i, tx.Hash(), blk.data.Transactions[i])
+		}
+	}
+	return nil
+}
+
+func hasDuplicateTx(txHashes [][]byte) bool {
+	dmap := make(map[string]struct{}, len(txHashes))
+	for _, txHash := range txHashes {
+		key := string(txHash)
+		if _, found := dmap[key]; found {
+			return true
+		}
+		dmap[key] = struct{}{}
+	}
+	return false
+}
+
 // Vote creates a vote for block
 func (blk *Block) Vote(signer Signer) *Vote {
 	vote := NewVote()
 	vote.setData(&core_pb.Vote{
-		BlockHash: blk.data.Hash,
-		Signature: signer.Sign(blk.data.Hash).data,
+		BlockHash:   blk.data.Hash,
+		BlockHeight: blk.data.Height,
+		Signature:   signer.Sign(voteDigest(blk.data.Hash, blk.data.Height)).data,
 	})
 	return vote
 }
@@ -97,7 +185,8 @@ func (blk *Block) Vote(signer Signer) *Vote {
 func (blk *Block) ProposerVote() *Vote {
 	vote := NewVote()
 	vote.setData(&core_pb.Vote{
-		BlockHash: blk.data.Hash,
+		BlockHash:   blk.data.Hash,
+		BlockHeight: blk.data.Height,
 		Signature: &core_pb.Signature{
 			PubKey: blk.data.Proposer,
 			Value:  blk.data.Signature,
@@ -108,6 +197,7 @@ func (blk *Block) ProposerVote() *Vote {
 
 func (blk *Block) setData(data *core_pb.Block) error {
 	blk.data = data
+	blk.resetSum()
 	if !blk.IsGenesis() { // every block contains qc except for genesis
 		blk.quorumCert = NewQuorumCert()
 		if err := blk.quorumCert.setData(data.QuorumCert); err != nil {
@@ -122,61 +212,106 @@ func (blk *Block) setData(data *core_pb.Block) error {
 	return nil
 }
 
+// Clone returns a deep copy of block.
+// Setters on the clone don't affect the original, which may be shared with other components.
+func (blk *Block) Clone() *Block {
+	clone := &Block{
+		data:     proto.Clone(blk.data).(*core_pb.Block),
+		proposer: blk.proposer, // public key is immutable
+	}
+	if blk.quorumCert != nil && clone.data.QuorumCert != nil {
+		clone.quorumCert = NewQuorumCert()
+		// qc signatures were parsed from the same data
+		clone.quorumCert.setData(clone.data.QuorumCert)
+	}
+	return clone
+}
+
 func (blk *Block) SetHeight(val uint64) *Block {
 	blk.data.Height = val
+	blk.resetSum()
 	return blk
 }
 
 func (blk *Block) SetParentHash(val []byte) *Block {
 	blk.data.ParentHash = val
+	blk.resetSum()
 	return blk
 }
 
 func (blk *Block) SetQuorumCert(val *QuorumCert) *Block {
 	blk.quorumCert = val
 	blk.data.QuorumCert = val.data
+	blk.resetSum()
 	return blk
 }
 
 func (blk *Block) SetExecHeight(val uint64) *Block {
 	blk.data.ExecHeight = val
+	blk.resetSum()
 	return blk
 }
 
 func (blk *Block) SetMerkleRoot(val []byte) *Block {
 	blk.data.MerkleRoot = val
+	blk.resetSum()
 	return blk
 }
 
 func (blk *Block) SetTimestamp(val int64) *Block {
 	blk.data.Timestamp = val
+	blk.resetSum()
 	return blk
 }
 
+// SetTransactions sets tx hashes and the tx root committed by block hash
 func (blk *Block) SetTransactions(val [][]byte) *Block {
 	blk.data.Transactions = val
+	blk.data.TxRoot = TxRoot(val)
+	blk.resetSum()
 	return blk
 }
 
 func (blk *Block) Sign(signer Signer) *Block {
 	blk.proposer = signer.PublicKey()
 	blk.data.Proposer = signer.PublicKey().key
+	blk.data.HashScheme = hashSchemeID(GetHashFunc())
+	blk.resetSum()
 	blk.data.Hash = blk.Sum()
-	blk.data.Signature = signer.Sign(blk.data.Hash).data.Value
+	// proposer signature is also the proposer's vote
+	blk.data.Signature = signer.Sign(voteDigest(blk.data.Hash, blk.data.Height)).data.Value
 	return blk
 }
 
-func (blk *Block) Hash() []byte            { return blk.data.Hash }
+func (blk *Block) Hash() []byte            { return copyBytes(blk.data.Hash) }
 func (blk *Block) Height() uint64          { return blk.data.Height }
-func (blk *Block) ParentHash() []byte      { return blk.data.ParentHash }
+func (blk *Block) ParentHash() []byte      { return copyBytes(blk.data.ParentHash) }
 func (blk *Block) Proposer() *PublicKey    { return blk.proposer }
 func (blk *Block) QuorumCert() *QuorumCert { return blk.quorumCert }
 func (blk *Block) ExecHeight() uint64      { return blk.data.ExecHeight }
-func (blk *Block) MerkleRoot() []byte      { return blk.data.MerkleRoot }
+func (blk *Block) MerkleRoot() []byte      { return copyBytes(blk.data.MerkleRoot) }
 func (blk *Block) Timestamp() int64        { return blk.data.Timestamp }
-func (blk *Block) Transactions() [][]byte  { return blk.data.Transactions }
+func (blk *Block) Transactions() [][]byte  { return copyBytesList(blk.data.Transactions) }
 func (blk *Block) IsGenesis() bool         { return blk.Height() == 0 }
 
+// HashScheme returns the hash function identifier of block digests, zero for sha3-256
+func (blk *Block) HashScheme() uint32 { return blk.data.HashScheme }
+
+// TransactionsRef returns tx hashes without copying, callers must not modify them
+func (blk *Block) TransactionsRef() [][]byte { return blk.data.Transactions }
+
+// TxRoot returns merkle root of block's tx hashes committed by block hash,
+// nil for the blocks hashing the tx hashes, made before tx root was committed, and empty blocks
+func (blk *Block) TxRoot() []byte { return copyBytes(blk.data.TxRoot) }
+
+// Header returns a copy of block without tx hashes,
+// its hash can still be verified if the block commits to tx root
+func (blk *Block) Header() *Block {
+	header := blk.Clone()
+	header.data.Transactions = nil
+	return header
+}
+
 // Marshal encodes blk as bytes
 func (blk *Block) Marshal() ([]byte, error) {
 	return proto.Marshal(blk.data)
@@ -197,7 +332,8 @@ func (blk *Block) MarshalJSON() ([]byte, error) {
 
 func (blk *Block) UnmarshalJSON(b []byte) error {
 	data := new(core_pb.Block)
-	if err := protojson.Unmarshal(b, data); err != nil {
+	// node api adds fields such as proposer address to block json
+	if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(b, data); err != nil {
 		return err
 	}
 	return blk.setData(data)
@@ -5,6 +5,7 @@ package core
 
 import (
 	"github.com/aungmawjj/juria-blockchain/core/core_pb"
+	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -57,6 +58,30 @@ func (bcm *BlockCommit) SetStateChanges(val []*StateChange) *BlockCommit {
 	return bcm
 }
 
+// MergeStateChanges merges state changes of other block commit, executed after bcm.
+// Last change wins per state key, the merged change keeps its position
+// and the previous value and tree index from bcm.
+// Keys only changed by other are appended in order.
+func (bcm *BlockCommit) MergeStateChanges(other *BlockCommit) *BlockCommit {
+	idxByKey := make(map[string]int, len(bcm.data.StateChanges))
+	for i, sc := range bcm.data.StateChanges {
+		idxByKey[string(StateKey(sc.CodeAddr, sc.Key))] = i
+	}
+	for _, sc := range other.data.StateChanges {
+		merged := proto.Clone(sc).(*core_pb.StateChange)
+		key := string(StateKey(sc.CodeAddr, sc.Key))
+		if i, found := idxByKey[key]; found {
+			merged.PrevValue = bcm.data.StateChanges[i].PrevValue
+			merged.PrevTreeIndex = bcm.data.StateChanges[i].PrevTreeIndex
+			bcm.data.StateChanges[i] = merged
+			continue
+		}
+		idxByKey[key] = len(bcm.data.StateChanges)
+		bcm.data.StateChanges = append(bcm.data.StateChanges, merged)
+	}
+	return bcm
+}
+
 func (bcm *BlockCommit) Hash() []byte           { return bcm.data.Hash }
 func (bcm *BlockCommit) OldBlockTxs() [][]byte  { return bcm.data.OldBlockTxs }
 func (bcm *BlockCommit) LeafCount() []byte      { return bcm.data.LeafCount }
@@ -91,3 +116,15 @@ func (bcm *BlockCommit) Unmarshal(b []byte) error {
 	}
 	return bcm.setData(data)
 }
+
+func (bcm *BlockCommit) MarshalJSON() ([]byte, error) {
+	return protojson.Marshal(bcm.data)
+}
+
+func (bcm *BlockCommit) UnmarshalJSON(b []byte) error {
+	data := new(core_pb.BlockCommit)
+	if err := protojson.Unmarshal(b, data); err != nil {
+		return err
+	}
+	return bcm.setData(data)
+}
@@ -0,0 +1,59 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlockCommit_MergeStateChanges(t *testing.T) {
+	assert := assert.New(t)
+
+	bcm := NewBlockCommit().SetStateChanges([]*StateChange{
+		NewStateChange().SetKey([]byte{1}).SetValue([]byte{10}).SetPrevValue([]byte{1}),
+		NewStateChange().SetKey([]byte{2}).SetValue([]byte{20}),
+	})
+	other := NewBlockCommit().SetStateChanges([]*StateChange{
+		NewStateChange().SetKey([]byte{3}).SetValue([]byte{30}),
+		NewStateChange().SetKey([]byte{1}).SetValue([]byte{11}).SetPrevValue([]byte{10}),
+	})
+	last := NewBlockCommit().SetStateChanges([]*StateChange{
+		NewStateChange().SetKey([]byte{3}).SetValue([]byte{31}),
+		NewStateChange().SetKey([]byte{4}).SetValue([]byte{40}),
+	})
+
+	scList := bcm.MergeStateChanges(other).MergeStateChanges(last).StateChanges()
+
+	keys := make([][]byte, len(scList))
+	values := make([][]byte, len(scList))
+	for i, sc := range scList {
+		keys[i] = sc.Key()
+		values[i] = sc.Value()
+	}
+	assert.Equal([][]byte{{1}, {2}, {3}, {4}}, keys, "first write position")
+	assert.Equal([][]byte{{11}, {20}, {31}, {40}}, values, "last write wins")
+	assert.Equal([]byte{1}, scList[0].PrevValue(), "prev value before batch")
+
+	assert.Equal([]byte{30}, other.StateChanges()[0].Value(), "other is not modified")
+
+	// deletes and tree indexes of the last change win, keys are namespaced by code address
+	bcm = NewBlockCommit().SetStateChanges([]*StateChange{
+		NewStateChange().SetCodeAddr([]byte{9}).SetKey([]byte{1}).SetValue([]byte{10}).
+			SetTreeIndex([]byte{5}),
+	})
+	other = NewBlockCommit().SetStateChanges([]*StateChange{
+		NewStateChange().SetCodeAddr([]byte{8}).SetKey([]byte{1}).SetValue([]byte{20}),
+		NewStateChange().SetCodeAddr([]byte{9}).SetKey([]byte{1}).SetDeleted(true).
+			SetTreeIndex([]byte{5}).SetPrevTreeIndex([]byte{5}),
+	})
+	scList = bcm.MergeStateChanges(other).StateChanges()
+	if assert.Len(scList, 2, "different code addresses") {
+		assert.True(scList[0].Deleted())
+		assert.Equal([]byte{5}, scList[0].TreeIndex())
+		assert.True(scList[0].IsNewKey(), "new key before the batch")
+		assert.Equal([]byte{8}, scList[1].CodeAddr())
+	}
+}
@@ -44,8 +44,10 @@ func TestBlock(t *testing.T) {
 	vs := new(MockValidatorStore)
 	vs.On("ValidatorCount").Return(1)
 	vs.On("MajorityCount").Return(1)
+	vs.On("MajorityStake").Return(uint64(1))
 	vs.On("IsValidator", privKey.PublicKey()).Return(true)
 	vs.On("IsValidator", mock.Anything).Return(false)
+	vs.On("GetStake", privKey.PublicKey()).Return(uint64(1))
 
 	bOk, err := blk.Marshal()
 	assertt.NoError(err)
@@ -112,3 +114,263 @@ func TestBlock_Vote(t *testing.T) {
 	assert.NoError(err)
 	vs.AssertExpectations(t)
 }
+
+func TestBlock_ValidateMaxTxPerBlock(t *testing.T) {
+	privKey := GenerateKey(nil)
+	vs := NewValidatorStore([]*PublicKey{privKey.PublicKey()})
+
+	newBlock := func(txCount int) *Block {
+		txs := make([][]byte, txCount)
+		for i := range txs {
+			txs[i] = []byte{byte(i)}
+		}
+		return NewBlock().SetTransactions(txs).Sign(privKey)
+	}
+	opts := BlockValidateOptions{MaxTxPerBlock: 3}
+
+	tests := []struct {
+		name string
+		blk  *Block
+		opts []BlockValidateOptions
+		err  error
+	}{
+		{"below limit", newBlock(2), []BlockValidateOptions{opts}, nil},
+		{"at limit", newBlock(3), []BlockValidateOptions{opts}, nil},
+		{"over limit", newBlock(4), []BlockValidateOptions{opts}, ErrTooManyTxs},
+		{"no limit", newBlock(4), []BlockValidateOptions{{}}, nil},
+		{"no options", newBlock(4), nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.blk.Validate(vs, tt.opts...)
+			if tt.err == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestBlock_TxRoot(t *testing.T) {
+	assert := assert.New(t)
+
+	privKey := GenerateKey(nil)
+	vs := NewValidatorStore([]*PublicKey{privKey.PublicKey()})
+	txs := [][]byte{[]byte("tx1"), []byte("tx2"), []byte("tx3")}
+	blk := NewBlock().SetTransactions(txs).Sign(privKey)
+	assert.Equal(TxRoot(txs), blk.TxRoot())
+	assert.NoError(blk.Validate(vs))
+
+	header := blk.Header()
+	assert.Empty(header.Transactions())
+	assert.Equal(blk.Hash(), header.Sum(), "header hash without txs")
+	assert.Len(blk.Transactions(), 3, "block is not modified")
+	assert.ErrorIs(header.Validate(vs), ErrInvalidBlockBody)
+
+	// legacy block hashes tx list
+	legacy := NewBlock().SetTransactions(txs)
+	legacy.data.TxRoot = nil
+	legacy.Sign(privKey)
+	assert.Nil(legacy.TxRoot())
+	assert.NotEqual(blk.Hash(), legacy.Hash())
+	assert.NoError(legacy.Validate(vs))
+
+	assert.Nil(NewBlock().SetTransactions(nil).TxRoot())
+}
+
+func TestBlock_DuplicateLastTx(t *testing.T) {
+	assert := assert.New(t)
+
+	priv := GenerateKey(nil)
+	vs := NewValidatorStore([]*PublicKey{priv.PublicKey()})
+	tx1 := NewTransaction().SetNonce(1).Sign(priv)
+	tx2 := NewTransaction().SetNonce(2).Sign(priv)
+	tx3 := NewTransaction().SetNonce(3).Sign(priv)
+	blk := NewBlock().SetTransactions([][]byte{tx1.Hash(), tx2.Hash(), tx3.Hash()}).Sign(priv)
+
+	// appending a copy of the last tx keeps tx root and block hash
+	altered := blk.Clone()
+	altered.data.Transactions = append(altered.data.Transactions, tx3.Hash())
+	altered.resetSum()
+	assert.Equal(blk.TxRoot(), TxRoot(altered.Transactions()))
+	assert.Equal(blk.Hash(), altered.Sum())
+
+	assert.ErrorIs(altered.Validate(vs), ErrInvalidBlockBody)
+	assert.ErrorIs(altered.VerifyBody([]*Transaction{tx1, tx2, tx3, tx3}), ErrInvalidBlockBody)
+	assert.NoError(blk.Validate(vs))
+}
+
+func TestBlock_SumCache(t *testing.T) {
+	assert := assert.New(t)
+
+	privKey := GenerateKey(nil)
+	blk := NewBlock().SetHeight(0).Sign(privKey)
+	sum := blk.Sum()
+	assert.Equal(blk.Hash(), sum)
+
+	freshSum := func() []byte {
+		b, err := blk.Marshal()
+		assert.NoError(err)
+		fresh := NewBlock()
+		assert.NoError(fresh.Unmarshal(b))
+		return fresh.Sum()
+	}
+
+	mutations := []func(){
+		func() { blk.SetParentHash([]byte{1}) },
+		func() { blk.SetExecHeight(1) },
+		func() { blk.SetMerkleRoot([]byte{2}) },
+		func() { blk.SetTimestamp(3) },
+		func() { blk.SetTransactions([][]byte{{4}}) },
+		func() { blk.Sign(GenerateKey(nil)) },
+		func() {
+			blk.SetQuorumCert(NewQuorumCert().Build([]*Vote{blk.ProposerVote()}))
+			blk.SetHeight(1)
+		},
+	}
+	for _, mutate := range mutations {
+		mutate()
+		assert.NotEqual(sum, blk.Sum())
+		assert.Equal(freshSum(), blk.Sum())
+		sum = blk.Sum()
+	}
+}
+
+func newBenchmarkBlock(txCount int) (*Block, []*Transaction, ValidatorStore) {
+	privKey := GenerateKey(nil)
+	vs := NewValidatorStore([]*PublicKey{privKey.PublicKey()})
+	txs := make([]*Transaction, txCount)
+	hashes := make([][]byte, txCount)
+	for i := range txs {
+		txs[i] = NewTransaction().
+			SetNonce(int64(i)).
+			SetCodeAddr([]byte{1}).
+			SetInput([]byte("benchmark input")).
+			Sign(privKey)
+		hashes[i] = txs[i].Hash()
+	}
+	parent := NewBlock().SetHeight(1).Sign(privKey)
+	blk := NewBlock().
+		SetHeight(2).
+		SetParentHash(parent.Hash()).
+		SetQuorumCert(NewQuorumCert().Build([]*Vote{parent.ProposerVote()})).
+		SetTransactions(hashes).
+		Sign(privKey)
+	return blk, txs, vs
+}
+
+func BenchmarkBlock_Validate(b *testing.B) {
+	blk, txs, vs := newBenchmarkBlock(1000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := blk.Validate(vs); err != nil {
+			b.Fatal(err)
+		}
+		for _, tx := range txs {
+			if err := tx.Validate(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkBlock_Sum(b *testing.B) {
+	blk, _, _ := newBenchmarkBlock(1000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		blk.SetTimestamp(int64(i)).Sum()
+	}
+}
+
+func TestBlock_VerifyBody(t *testing.T) {
+	priv := GenerateKey(nil)
+	tx1 := NewTransaction().SetNonce(1).Sign(priv)
+	tx2 := NewTransaction().SetNonce(2).Sign(priv)
+	blk := NewBlock().SetTransactions([][]byte{tx1.Hash(), tx2.Hash()}).Sign(priv)
+
+	tests := []struct {
+		name  string
+		txs   []*Transaction
+		isErr bool
+	}{
+		{"valid", []*Transaction{tx1, tx2}, false},
+		{"empty", nil, true},
+		{"missing tx", []*Transaction{tx1}, true},
+		{"extra tx", []*Transaction{tx1, tx2, tx1}, true},
+		{"wrong order", []*Transaction{tx2, tx1}, true},
+		{"nil tx", []*Transaction{tx1, nil}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := blk.VerifyBody(tt.txs)
+			if tt.isErr {
+				assert.ErrorIs(t, err, ErrInvalidBlockBody)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+	assert.NoError(t, NewBlock().VerifyBody(nil), "block without txs")
+}
+
+func TestBlock_Clone(t *testing.T) {
+	assert := assert.New(t)
+
+	privKey := GenerateKey(nil)
+	qc := NewQuorumCert().Build([]*Vote{
+		NewBlock().SetHeight(1).Sign(privKey).ProposerVote(),
+	})
+	blk := NewBlock().
+		SetHeight(2).
+		SetParentHash([]byte{1}).
+		SetQuorumCert(qc).
+		SetTransactions([][]byte{{1}}).
+		Sign(privKey)
+	sum := blk.Sum()
+
+	clone := blk.Clone()
+	assert.Equal(blk.Hash(), clone.Hash())
+	assert.Equal(sum, clone.Sum())
+	assert.Equal(blk.Proposer(), clone.Proposer())
+	assert.Equal(blk.QuorumCert().BlockHash(), clone.QuorumCert().BlockHash())
+	assert.Equal(blk.QuorumCert().Signers(), clone.QuorumCert().Signers())
+
+	clone.SetHeight(3)
+	clone.data.Transactions[0][0] = 2
+	clone.data.QuorumCert.BlockHash[0] = 0
+	assert.NotEqual(sum, clone.Sum())
+	assert.Equal(sum, blk.Sum())
+	assert.Equal([][]byte{{1}}, blk.Transactions())
+	assert.NotEqual(clone.QuorumCert().BlockHash(), blk.QuorumCert().BlockHash())
+
+	genesis := NewBlock().SetHeight(0).Sign(privKey).Clone()
+	assert.Nil(genesis.QuorumCert())
+}
+
+func TestBlock_CopyOnAccess(t *testing.T) {
+	assert := assert.New(t)
+
+	priv := GenerateKey(nil)
+	vs := NewValidatorStore([]*PublicKey{priv.PublicKey()})
+	qc := NewQuorumCert().Build([]*Vote{NewBlock().Sign(priv).ProposerVote()})
+	blk := NewBlock().
+		SetHeight(1).
+		SetParentHash([]byte{1}).
+		SetQuorumCert(qc).
+		SetTransactions([][]byte{{1}, {2}}).
+		Sign(priv)
+	hash := blk.Hash()
+
+	blk.Hash()[0] ^= 1
+	blk.Sum()[0] ^= 1
+	blk.Transactions()[0][0] = 9
+	blk.ParentHash()[0] = 9
+
+	assert.Equal(hash, blk.Hash())
+	assert.Equal([][]byte{{1}, {2}}, blk.Transactions())
+	assert.NoError(blk.Validate(vs))
+	assert.Equal(blk.Transactions(), blk.TransactionsRef())
+}
@@ -0,0 +1,169 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package core
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+
+	"github.com/aungmawjj/juria-blockchain/core/core_pb"
+	bls12381 "github.com/kilic/bls12-381"
+)
+
+// bls12-381 sizes, public keys in G1 and signatures in G2
+const (
+	BLSPrivateKeySize = 32
+	BLSPublicKeySize  = 48
+	BLSSignatureSize  = 96
+)
+
+// errors
+var (
+	ErrInvalidBLSKey = errors.New("invalid bls key")
+	ErrInvalidBLSSig = errors.New("invalid bls signature")
+	ErrInvalidBLSPoP = errors.New("invalid bls proof of possession")
+)
+
+var (
+	blsDomain    = []byte("BLS_SIG_BLS12381G2_XMD:SHA-256_SSWU_RO_NUL_")
+	blsPoPDomain = []byte("BLS_POP_BLS12381G2_XMD:SHA-256_SSWU_RO_POP_")
+)
+
+// BLSPrivateKey is bls12-381 private key.
+// Signatures of the same message from BLSPrivateKeys can be aggregated into one.
+type BLSPrivateKey struct {
+	key    *bls12381.Fr
+	pubKey *PublicKey
+}
+
+var _ Signer = (*BLSPrivateKey)(nil)
+
+// NewBLSPrivateKey creates BLSPrivateKey from bytes
+func NewBLSPrivateKey(b []byte) (*BLSPrivateKey, error) {
+	if len(b) != BLSPrivateKeySize {
+		return nil, ErrInvalidKeySize
+	}
+	key := bls12381.NewFr().FromBytes(b)
+	if key.IsZero() || !bytes.Equal(key.ToBytes(), b) {
+		return nil, ErrInvalidBLSKey
+	}
+	return newBLSPrivateKey(key), nil
+}
+
+func newBLSPrivateKey(key *bls12381.Fr) *BLSPrivateKey {
+	g1 := bls12381.NewG1()
+	pub := g1.MulScalar(g1.New(), g1.One(), key)
+	pubKey, _ := NewPublicKey(g1.ToCompressed(pub))
+	return &BLSPrivateKey{key, pubKey}
+}
+
+// GenerateBLSKey generates bls private key, crypto/rand is used if rand is nil
+func GenerateBLSKey(rd io.Reader) *BLSPrivateKey {
+	if rd == nil {
+		rd = rand.Reader
+	}
+	for {
+		key, err := bls12381.NewFr().Rand(rd)
+		if err != nil {
+			panic(err)
+		}
+		if !key.IsZero() {
+			return newBLSPrivateKey(key)
+		}
+	}
+}
+
+// Bytes return raw bytes
+func (priv *BLSPrivateKey) Bytes() []byte {
+	return priv.key.ToBytes()
+}
+
+// PublicKey returns corresponding public key
+func (priv *BLSPrivateKey) PublicKey() *PublicKey {
+	return priv.pubKey
+}
+
+// Sign signs the message
+func (priv *BLSPrivateKey) Sign(msg []byte) *Signature {
+	return &Signature{
+		data: &core_pb.Signature{
+			Value:  priv.sign(msg, blsDomain),
+			PubKey: priv.pubKey.Bytes(),
+		},
+		pubKey: priv.pubKey,
+	}
+}
+
+// ProvePossession signs the public key with the proof of possession domain,
+// so that the key can be registered as validator
+func (priv *BLSPrivateKey) ProvePossession() []byte {
+	return priv.sign(priv.pubKey.Bytes(), blsPoPDomain)
+}
+
+func (priv *BLSPrivateKey) sign(msg, domain []byte) []byte {
+	g2 := bls12381.NewG2()
+	hm, err := g2.HashToCurve(msg, domain)
+	if err != nil {
+		panic(err) // fails only for oversized domain
+	}
+	return g2.ToCompressed(g2.MulScalar(g2.New(), hm, priv.key))
+}
+
+// VerifyPossession verifies the proof of possession of bls public key.
+// Aggregate signatures are verified only with the keys proven,
+// otherwise a rogue key derived from the others can forge a qc.
+func VerifyPossession(pubKey *PublicKey, proof []byte) bool {
+	if !pubKey.IsBLS() {
+		return false
+	}
+	return blsVerifyDomain(pubKey.blsKey, pubKey.Bytes(), proof, blsPoPDomain)
+}
+
+func blsVerify(pubKey *bls12381.PointG1, msg, sig []byte) bool {
+	return blsVerifyDomain(pubKey, msg, sig, blsDomain)
+}
+
+func blsVerifyDomain(pubKey *bls12381.PointG1, msg, sig, domain []byte) bool {
+	g2 := bls12381.NewG2()
+	sigPoint, err := g2.FromCompressed(sig)
+	if err != nil {
+		return false
+	}
+	hm, err := g2.HashToCurve(msg, domain)
+	if err != nil {
+		return false
+	}
+	// e(pk, H(m)) == e(g1, sig)
+	engine := bls12381.NewEngine()
+	engine.AddPair(pubKey, hm)
+	engine.AddPairInv(engine.G1.One(), sigPoint)
+	return engine.Check()
+}
+
+// blsAggregateSigs adds signatures of the same message into one
+func blsAggregateSigs(sigs [][]byte) ([]byte, error) {
+	g2 := bls12381.NewG2()
+	agg := g2.Zero()
+	for _, sig := range sigs {
+		p, err := g2.FromCompressed(sig)
+		if err != nil {
+			return nil, ErrInvalidBLSSig
+		}
+		g2.Add(agg, agg, p)
+	}
+	return g2.ToCompressed(agg), nil
+}
+
+// blsAggregateKeys adds public keys to verify aggregated signature of the same message.
+// Validator keys must be registered with proof of possession to prevent rogue key attack.
+func blsAggregateKeys(pubKeys []*PublicKey) *bls12381.PointG1 {
+	g1 := bls12381.NewG1()
+	agg := g1.Zero()
+	for _, pub := range pubKeys {
+		g1.Add(agg, agg, pub.blsKey)
+	}
+	return agg
+}
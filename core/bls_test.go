@@ -0,0 +1,75 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package core
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBLSPrivateKey(t *testing.T) {
+	assert := assert.New(t)
+
+	priv := GenerateBLSKey(nil)
+	assert.Len(priv.Bytes(), BLSPrivateKeySize)
+	assert.Len(priv.PublicKey().Bytes(), BLSPublicKeySize)
+	assert.True(priv.PublicKey().IsBLS())
+
+	priv1, err := NewBLSPrivateKey(priv.Bytes())
+	assert.NoError(err)
+	assert.True(priv.PublicKey().Equal(priv1.PublicKey()))
+
+	_, err = NewBLSPrivateKey([]byte{1})
+	assert.ErrorIs(err, ErrInvalidKeySize)
+	_, err = NewBLSPrivateKey(make([]byte, BLSPrivateKeySize))
+	assert.ErrorIs(err, ErrInvalidBLSKey)
+	_, err = NewBLSPrivateKey(bytes.Repeat([]byte{0xff}, BLSPrivateKeySize))
+	assert.ErrorIs(err, ErrInvalidBLSKey, "key must be less than group order")
+
+	pub, err := NewPublicKey(priv.PublicKey().Bytes())
+	assert.NoError(err)
+	assert.True(pub.IsBLS())
+	_, err = NewPublicKey(bytes.Repeat([]byte{0xff}, BLSPublicKeySize))
+	assert.ErrorIs(err, ErrInvalidBLSKey)
+
+	assert.False(GenerateKey(nil).PublicKey().IsBLS())
+}
+
+func TestBLSSignature(t *testing.T) {
+	assert := assert.New(t)
+
+	priv := GenerateBLSKey(nil)
+	msg := []byte("message")
+	sig := priv.Sign(msg)
+	assert.Len(sig.data.Value, BLSSignatureSize)
+
+	sig1, err := newSignature(sig.data)
+	assert.NoError(err)
+	assert.True(sig1.Verify(msg))
+	assert.False(sig1.Verify([]byte("other message")))
+
+	other := GenerateBLSKey(nil).Sign(msg)
+	sig1.data.Value = other.data.Value
+	assert.False(sig1.Verify(msg), "signature from other key")
+
+	sig1.data.Value = []byte("invalid")
+	assert.False(sig1.Verify(msg))
+}
+
+func TestBLSPossession(t *testing.T) {
+	assert := assert.New(t)
+
+	priv := GenerateBLSKey(nil)
+	proof := priv.ProvePossession()
+	assert.Len(proof, BLSSignatureSize)
+	assert.True(VerifyPossession(priv.PublicKey(), proof))
+
+	// signature of the public key in the signing domain is not a proof
+	assert.False(VerifyPossession(priv.PublicKey(), priv.Sign(priv.PublicKey().Bytes()).data.Value))
+	assert.False(VerifyPossession(GenerateBLSKey(nil).PublicKey(), proof))
+	assert.False(VerifyPossession(GenerateKey(nil).PublicKey(), proof))
+	assert.False(VerifyPossession(priv.PublicKey(), []byte("invalid")))
+}
@@ -0,0 +1,24 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package core
+
+// getters of core types return copies, so that callers cannot modify signed data
+
+func copyBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	return append(make([]byte, 0, len(b)), b...)
+}
+
+func copyBytesList(list [][]byte) [][]byte {
+	if list == nil {
+		return nil
+	}
+	ret := make([][]byte, len(list))
+	for i, b := range list {
+		ret[i] = copyBytes(b)
+	}
+	return ret
+}
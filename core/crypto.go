@@ -4,12 +4,15 @@
 package core
 
 import (
+	"bytes"
 	"crypto/ed25519"
 	"encoding/base64"
 	"errors"
+	"fmt"
 	"io"
 
 	"github.com/aungmawjj/juria-blockchain/core/core_pb"
+	bls12381 "github.com/kilic/bls12-381"
 )
 
 // errors
@@ -22,37 +25,67 @@ type Signer interface {
 	PublicKey() *PublicKey
 }
 
-// PublicKey type
+// PublicKey type, either ed25519 or bls12-381 public key
 type PublicKey struct {
-	key    ed25519.PublicKey
+	key    []byte
 	keyStr string
+	blsKey *bls12381.PointG1 // nil for ed25519 key
 }
 
-// NewPublicKey creates PublicKey from bytes
+// NewPublicKey creates PublicKey from bytes.
+// Key type is determined by key size.
 func NewPublicKey(b []byte) (*PublicKey, error) {
-	if len(b) != ed25519.PublicKeySize {
-		return nil, ErrInvalidKeySize
-	}
-	return &PublicKey{
+	pub := &PublicKey{
 		key:    b,
 		keyStr: base64.StdEncoding.EncodeToString(b),
-	}, nil
+	}
+	switch len(b) {
+	case ed25519.PublicKeySize:
+		return pub, nil
+
+	case BLSPublicKeySize:
+		blsKey, err := bls12381.NewG1().FromCompressed(b)
+		if err != nil {
+			return nil, fmt.Errorf("%w, %v", ErrInvalidBLSKey, err)
+		}
+		pub.blsKey = blsKey
+		return pub, nil
+
+	default:
+		return nil, ErrInvalidKeySize
+	}
 }
 
-// Equal checks whether pub and x has the same value
+// Equal checks whether pub and x has the same value.
+// Two nil keys are equal and nil key is not equal to any non-nil key.
 func (pub *PublicKey) Equal(x *PublicKey) bool {
-	return pub.key.Equal(x.key)
+	if pub == nil || x == nil {
+		return pub == x
+	}
+	return bytes.Equal(pub.key, x.key)
 }
 
-// Bytes return raw bytes
+// IsBLS returns true for bls12-381 public key
+func (pub *PublicKey) IsBLS() bool {
+	return pub.blsKey != nil
+}
+
+// Bytes return raw bytes, keys are Equal if and only if their bytes are equal
 func (pub *PublicKey) Bytes() []byte {
 	return pub.key
 }
 
+// String returns base64 encoded bytes.
+// It is stable for the key value, so that it can be used as map key to index public keys.
 func (pub *PublicKey) String() string {
 	return pub.keyStr
 }
 
+// Address returns the address derived from public key
+func (pub *PublicKey) Address() Address {
+	return newAddressFromPublicKey(pub)
+}
+
 // PrivateKey type
 type PrivateKey struct {
 	key    ed25519.PrivateKey
@@ -119,6 +152,9 @@ func newSignature(data *core_pb.Signature) (*Signature, error) {
 
 // Verify verifies the signature
 func (sig *Signature) Verify(msg []byte) bool {
+	if sig.pubKey.IsBLS() {
+		return blsVerify(sig.pubKey.blsKey, msg, sig.data.Value)
+	}
 	return ed25519.Verify(sig.pubKey.key, msg, sig.data.Value)
 }
 
@@ -153,6 +189,24 @@ func (sigs sigList) hasDuplicate() bool {
 	return false
 }
 
+// signers returns distinct public keys in order of signatures
+func (sigs sigList) signers() []*PublicKey {
+	dmap := make(map[string]struct{}, len(sigs))
+	ret := make([]*PublicKey, 0, len(sigs))
+	for _, sig := range sigs {
+		if sig == nil {
+			continue
+		}
+		key := sig.PublicKey().String()
+		if _, found := dmap[key]; found {
+			continue
+		}
+		dmap[key] = struct{}{}
+		ret = append(ret, sig.PublicKey())
+	}
+	return ret
+}
+
 func (sigs sigList) hasInvalidValidator(vs ValidatorStore) bool {
 	for _, sig := range sigs {
 		if !vs.IsValidator(sig.PublicKey()) {
@@ -162,9 +216,17 @@ func (sigs sigList) hasInvalidValidator(vs ValidatorStore) bool {
 	return false
 }
 
+func (sigs sigList) totalStake(vs ValidatorStore) uint64 {
+	var stake uint64
+	for _, sig := range sigs {
+		stake += vs.GetStake(sig.PublicKey())
+	}
+	return stake
+}
+
 func (sigs sigList) hasInvalidSig(msg []byte) bool {
 	for _, sig := range sigs {
-		if !sig.Verify(msg) {
+		if !sig.VerifyCached(msg) {
 			return true
 		}
 	}
@@ -26,3 +26,33 @@ func TestSignVerify(t *testing.T) {
 
 	assert.Equal(privKey.PublicKey(), sig.PublicKey())
 }
+
+func TestPublicKey_Equal(t *testing.T) {
+	pub1 := GenerateKey(nil).PublicKey()
+	pub1Copy, _ := NewPublicKey(append([]byte{}, pub1.Bytes()...))
+	pub2 := GenerateKey(nil).PublicKey()
+	blsPub := GenerateBLSKey(nil).PublicKey()
+	var nilKey *PublicKey
+
+	tests := []struct {
+		name string
+		a, b *PublicKey
+		want bool
+	}{
+		{"same pointer", pub1, pub1, true},
+		{"same value", pub1, pub1Copy, true},
+		{"different keys", pub1, pub2, false},
+		{"different key types", pub1, blsPub, false},
+		{"nil and key", nilKey, pub1, false},
+		{"key and nil", pub1, nilKey, false},
+		{"both nil", nilKey, nilKey, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.a.Equal(tt.b))
+			if tt.a != nil && tt.b != nil {
+				assert.Equal(t, tt.want, tt.a.String() == tt.b.String(), "string as map key")
+			}
+		})
+	}
+}
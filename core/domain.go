@@ -0,0 +1,66 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package core
+
+import (
+	"sync"
+)
+
+// FormatVersion is the version of marshaled bytes and digests of core types.
+// Any change to them must bump it and regenerate core/testvectors golden files.
+const FormatVersion = 4
+
+// domain tags are written into digests, so that a signature on one object type
+// cannot be replayed as a signature on another type
+var (
+	domainBlock = []byte("juria/block")
+	domainTx    = []byte("juria/tx")
+	domainVote  = []byte("juria/vote")
+)
+
+// DomainSeparation enables domain tags in block hashes, tx hashes and vote digests.
+// An existing chain opts in from the activation Height, older digests are accepted below it.
+type DomainSeparation struct {
+	Enabled bool
+	Height  uint64
+}
+
+// Active returns true if domain tags are used at block height
+func (ds DomainSeparation) Active(height uint64) bool {
+	return ds.Enabled && height >= ds.Height
+}
+
+var (
+	domainSep    DomainSeparation
+	mtxDomainSep sync.RWMutex
+)
+
+// SetDomainSeparation sets the chain wide domain separation config.
+// It must be set before creating or validating any block, tx or vote.
+func SetDomainSeparation(ds DomainSeparation) {
+	mtxDomainSep.Lock()
+	defer mtxDomainSep.Unlock()
+	domainSep = ds
+}
+
+// GetDomainSeparation returns the chain wide domain separation config
+func GetDomainSeparation() DomainSeparation {
+	mtxDomainSep.RLock()
+	defer mtxDomainSep.RUnlock()
+	return domainSep
+}
+
+// voteDigest is the message signed by voters and block proposer for the block hash.
+// From activation height, the height is signed with the hash. The height of a vote or qc
+// selects the digest, so it must be checked against the block, see Vote.CheckBlock.
+func voteDigest(blockHash []byte, height uint64) []byte {
+	if !GetDomainSeparation().Active(height) {
+		return blockHash
+	}
+	hs := getHasher()
+	hs.write(domainVote)
+	hs.writeUint64(height)
+	hs.write(blockHash)
+	return hs.sum()
+}
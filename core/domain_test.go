@@ -0,0 +1,148 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestDomainSeparation_Active(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.False(DomainSeparation{}.Active(10))
+	ds := DomainSeparation{Enabled: true, Height: 5}
+	assert.False(ds.Active(4))
+	assert.True(ds.Active(5))
+	assert.True(ds.Active(6))
+}
+
+func TestDomainSeparation_Block(t *testing.T) {
+	assert := assert.New(t)
+
+	priv := GenerateKey(nil)
+	vs := new(MockValidatorStore)
+	vs.On("MajorityStake").Return(uint64(1))
+	vs.On("IsValidator", priv.PublicKey()).Return(true)
+	vs.On("IsValidator", mock.Anything).Return(false)
+	vs.On("GetStake", priv.PublicKey()).Return(uint64(1))
+
+	// blocks signed by a node without domain separation
+	legacy4 := NewBlock().SetHeight(4).Sign(priv)
+	vote4 := legacy4.Vote(priv)
+	legacy5 := NewBlock().SetHeight(5).
+		SetQuorumCert(NewQuorumCert().Build([]*Vote{vote4})).Sign(priv)
+	vote5 := legacy5.Vote(priv)
+
+	SetDomainSeparation(DomainSeparation{Enabled: true, Height: 5})
+	defer SetDomainSeparation(DomainSeparation{})
+
+	b4 := NewBlock().SetHeight(4).Sign(priv)
+	b5 := NewBlock().SetHeight(5).Sign(priv)
+	assert.Equal(legacy4.Hash(), b4.Hash(), "below activation")
+	assert.NotEqual(legacy5.Hash(), b5.Hash(), "at activation")
+
+	assert.NoError(vote4.Validate(vs), "old vote digest below activation")
+	assert.ErrorIs(vote5.Validate(vs), ErrInvalidSig, "old vote digest at activation")
+	assert.NoError(b4.Vote(priv).Validate(vs))
+	assert.NoError(b5.Vote(priv).Validate(vs))
+	assert.NoError(b5.ProposerVote().Validate(vs))
+	assert.NoError(b5.Vote(priv).CheckBlock(b5))
+
+	// the height is signed from activation, a lower height is rejected with the block
+	raised := b5.Vote(priv)
+	raised.data.BlockHeight = 6
+	assert.ErrorIs(raised.Validate(vs), ErrInvalidSig)
+	lowered := b5.Vote(priv)
+	lowered.data.BlockHeight = 4
+	assert.ErrorIs(lowered.CheckBlock(b5), ErrBlockHeightMismatch)
+	assert.ErrorIs(lowered.CheckBlock(b4), ErrInvalidBlockHash)
+
+	// qc at activation height
+	qc := NewQuorumCert().Build([]*Vote{b5.Vote(priv)})
+	assert.EqualValues(5, qc.BlockHeight())
+	assert.NoError(qc.Validate(vs))
+	assert.NoError(qc.CheckBlock(b5))
+	assert.ErrorIs(qc.CheckBlock(nil), ErrNilBlock)
+	assert.ErrorIs(NewQuorumCert().Build([]*Vote{vote5}).Validate(vs), ErrInvalidSig)
+
+	b6 := NewBlock().SetHeight(6).SetQuorumCert(qc).Sign(priv)
+	assert.NoError(b6.Validate(vs))
+
+	// qc below activation in the first active block
+	qc4 := NewQuorumCert().Build([]*Vote{vote4})
+	assert.NoError(NewBlock().SetHeight(5).SetQuorumCert(qc4).Sign(priv).Validate(vs))
+
+	// qc must refer to a lower block
+	bInvalidQC := NewBlock().SetHeight(5).SetQuorumCert(qc).Sign(priv)
+	assert.ErrorIs(bInvalidQC.Validate(vs), ErrInvalidQCHeight)
+
+	// block hash without domain tag at activation height
+	bOld, err := legacy5.Marshal()
+	assert.NoError(err)
+	blk := NewBlock()
+	assert.NoError(blk.Unmarshal(bOld))
+	assert.ErrorIs(blk.Validate(vs), ErrInvalidBlockHash)
+}
+
+func TestDomainSeparation_Tx(t *testing.T) {
+	assert := assert.New(t)
+
+	priv := GenerateKey(nil)
+	legacy := NewTransaction().SetNonce(1).Sign(priv)
+
+	SetDomainSeparation(DomainSeparation{Enabled: true, Height: 5})
+	defer SetDomainSeparation(DomainSeparation{})
+
+	tx := NewTransaction().SetNonce(1).Sign(priv)
+	assert.NotEqual(legacy.Hash(), tx.Hash())
+
+	b, err := legacy.Marshal()
+	assert.NoError(err)
+	legacy = NewTransaction()
+	assert.NoError(legacy.Unmarshal(b))
+
+	assert.NoError(legacy.Validate(TxValidateOptions{Height: 4}))
+	assert.ErrorIs(legacy.Validate(TxValidateOptions{Height: 5}), ErrInvalidTxHash)
+	assert.NoError(legacy.Validate(), "default height 0")
+	assert.NoError(tx.Validate(TxValidateOptions{Height: 4}))
+	assert.NoError(tx.Validate(TxValidateOptions{Height: 5}))
+}
+
+func TestDomainSeparation_TxSum(t *testing.T) {
+	assert := assert.New(t)
+
+	priv := GenerateKey(nil)
+	tx := NewTransaction().SetNonce(1).Sign(priv)
+	legacy := tx.Sum()
+
+	SetDomainSeparation(DomainSeparation{Enabled: true, Height: 5})
+	defer SetDomainSeparation(DomainSeparation{})
+
+	tagged := tx.Sum()
+	assert.NotEqual(legacy, tagged, "tagged below activation height")
+
+	SetDomainSeparation(DomainSeparation{})
+	assert.Equal(legacy, tx.Sum(), "cached sum is not reused for another config")
+}
+
+func TestDomainSeparation_TxBoundary(t *testing.T) {
+	assert := assert.New(t)
+
+	priv := GenerateKey(nil)
+	legacy := NewTransaction().SetNonce(1).Sign(priv)
+
+	SetDomainSeparation(DomainSeparation{Enabled: true, Height: 5})
+	defer SetDomainSeparation(DomainSeparation{})
+
+	// signed while the chain is at height 4, included at 5
+	tx := NewTransaction().SetNonce(1).Sign(priv)
+	assert.NoError(tx.Validate(TxValidateOptions{Height: 4}))
+	assert.NoError(tx.Validate(TxValidateOptions{Height: 5}))
+
+	assert.NoError(legacy.Validate(TxValidateOptions{Height: 4}))
+	assert.ErrorIs(legacy.Validate(TxValidateOptions{Height: 5}), ErrInvalidTxHash)
+}
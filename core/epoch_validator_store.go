@@ -0,0 +1,312 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// ValidatorSetKey is the reserved system state key, without code address,
+// holding the validator set written by a system chaincode
+var ValidatorSetKey = []byte("__validator_set__")
+
+// errors
+var (
+	ErrInvalidValidatorSet = errors.New("invalid validator set")
+	ErrInvalidEpochLength  = errors.New("epoch length must be greater than zero")
+)
+
+// HeightValidatorStore returns the validators responsible for a block height.
+// Votes, qcs and blocks are validated against the store of their own height.
+type HeightValidatorStore interface {
+	ValidatorStore
+	ForHeight(height uint64) ValidatorStore
+}
+
+// validatorsAt resolves the validator store for height when vs changes over heights
+func validatorsAt(vs ValidatorStore, height uint64) ValidatorStore {
+	if hvs, ok := vs.(HeightValidatorStore); ok {
+		return hvs.ForHeight(height)
+	}
+	return vs
+}
+
+// ValidatorSet is the value stored at ValidatorSetKey.
+// Stakes are optional, every validator has one stake if empty.
+// Proofs are the proofs of possession of bls validators, see BLSPrivateKey.ProvePossession,
+// required if any validator has bls key.
+type ValidatorSet struct {
+	Validators [][]byte `json:"validators"`
+	Stakes     []uint64 `json:"stakes,omitempty"`
+	Proofs     [][]byte `json:"proofs,omitempty"`
+}
+
+// UnmarshalValidatorSet decodes the state value of ValidatorSetKey
+func UnmarshalValidatorSet(b []byte) (*ValidatorSet, error) {
+	set := new(ValidatorSet)
+	if err := json.Unmarshal(b, set); err != nil {
+		return nil, fmt.Errorf("%w, %v", ErrInvalidValidatorSet, err)
+	}
+	return set, nil
+}
+
+func (set *ValidatorSet) Marshal() ([]byte, error) {
+	return json.Marshal(set)
+}
+
+// Store creates validator store of the set
+func (set *ValidatorSet) Store() (ValidatorStore, error) {
+	if len(set.Validators) == 0 {
+		return nil, fmt.Errorf("%w, no validators", ErrInvalidValidatorSet)
+	}
+	if len(set.Stakes) != 0 && len(set.Stakes) != len(set.Validators) {
+		return nil, fmt.Errorf("%w, %d stakes for %d validators",
+			ErrInvalidValidatorSet, len(set.Stakes), len(set.Validators))
+	}
+	validators := make([]*PublicKey, len(set.Validators))
+	for i, v := range set.Validators {
+		pubKey, err := NewPublicKey(v)
+		if err != nil {
+			return nil, fmt.Errorf("%w, validator %d, %v", ErrInvalidValidatorSet, i, err)
+		}
+		if pubKey.IsBLS() && (i >= len(set.Proofs) || !VerifyPossession(pubKey, set.Proofs[i])) {
+			return nil, fmt.Errorf("%w, validator %d, %v", ErrInvalidValidatorSet, i, ErrInvalidBLSPoP)
+		}
+		validators[i] = pubKey
+	}
+	if len(set.Stakes) == 0 {
+		return NewValidatorStore(validators), nil
+	}
+	return NewWeightedValidatorStore(validators, set.Stakes), nil
+}
+
+// EpochSnapshotStore persists the validator set values snapshotted by EpochValidatorStore,
+// by the epoch they become active. An empty value at epoch zero marks the initial validators.
+type EpochSnapshotStore interface {
+	SetEpochValidatorSet(epoch uint64, value []byte) error
+	GetEpochValidatorSets() (map[uint64][]byte, error)
+}
+
+/*
+EpochValidatorStore snapshots the validator set in state once per epoch of EpochLength blocks.
+
+The set committed at the end of epoch (e) becomes active at epoch (e + 2).
+A block of epoch (e + 1) may be validated before the last block of epoch (e)
+is committed, since a block is committed only after three chain,
+so the snapshot is delayed by one more epoch to be known by every replica in time.
+The snapshots are persisted to the snapshot store if set, so that they are restored on restart.
+*/
+type EpochValidatorStore struct {
+	epochLength uint64
+
+	mtx     sync.RWMutex
+	epochs  []uint64 // activation epochs, ascending
+	stores  map[uint64]ValidatorStore
+	value   []byte // latest committed value of ValidatorSetKey
+	current ValidatorStore
+
+	snapshots EpochSnapshotStore
+}
+
+var _ HeightValidatorStore = (*EpochValidatorStore)(nil)
+
+// NewEpochValidatorStore creates epoch store, initial store is active from epoch zero
+func NewEpochValidatorStore(epochLength uint64, initial ValidatorStore) (*EpochValidatorStore, error) {
+	if epochLength == 0 {
+		return nil, ErrInvalidEpochLength
+	}
+	store := &EpochValidatorStore{
+		epochLength: epochLength,
+		stores:      make(map[uint64]ValidatorStore),
+	}
+	store.setStore(0, initial)
+	store.current = initial
+	return store, nil
+}
+
+// SetSnapshotStore persists the snapshots, it must be set before Restore
+func (store *EpochValidatorStore) SetSnapshotStore(snapshots EpochSnapshotStore) *EpochValidatorStore {
+	store.mtx.Lock()
+	defer store.mtx.Unlock()
+	store.snapshots = snapshots
+	return store
+}
+
+func (store *EpochValidatorStore) Epoch(height uint64) uint64 {
+	return height / store.epochLength
+}
+
+// ForHeight returns the snapshot active at height.
+// For an epoch not yet snapshotted, the latest earlier snapshot is returned.
+func (store *EpochValidatorStore) ForHeight(height uint64) ValidatorStore {
+	store.mtx.RLock()
+	defer store.mtx.RUnlock()
+	return store.forEpoch(store.Epoch(height))
+}
+
+func (store *EpochValidatorStore) forEpoch(epoch uint64) ValidatorStore {
+	i := sort.Search(len(store.epochs), func(i int) bool {
+		return store.epochs[i] > epoch
+	})
+	return store.stores[store.epochs[i-1]]
+}
+
+// Restore sets the committed height and state value, used when the node restarts.
+// The persisted snapshots are loaded, so the sets of the active and pending epochs are the same
+// as before the restart. The snapshot of the committed height is taken again, in case the node stopped
+// before persisting it. Without persisted snapshots, the set is taken as active
+// for the current and next epochs, as snapshots of past epochs are not kept in state.
+func (store *EpochValidatorStore) Restore(height uint64, value []byte) error {
+	store.mtx.Lock()
+	defer store.mtx.Unlock()
+
+	store.value = value
+	defer func() {
+		store.current = store.forEpoch(store.Epoch(height + 1))
+	}()
+	recorded, err := store.loadSnapshots()
+	if err != nil {
+		return err
+	}
+	if !recorded {
+		if err := store.persist(0, nil); err != nil {
+			return err
+		}
+		if value != nil { // snapshots were not persisted before
+			if err := store.snapshot(store.Epoch(height)); err != nil {
+				return err
+			}
+		}
+	}
+	if (height+1)%store.epochLength != 0 || value == nil {
+		return nil
+	}
+	return store.snapshot(store.Epoch(height) + 2)
+}
+
+// loadSnapshots returns false if the snapshots are not persisted
+func (store *EpochValidatorStore) loadSnapshots() (bool, error) {
+	if store.snapshots == nil {
+		return false, nil
+	}
+	values, err := store.snapshots.GetEpochValidatorSets()
+	if err != nil {
+		return false, err
+	}
+	for epoch, value := range values {
+		if len(value) == 0 {
+			continue // initial validators
+		}
+		vs, err := store.decode(value)
+		if err != nil {
+			return false, fmt.Errorf("epoch %d, %w", epoch, err)
+		}
+		store.setStore(epoch, vs)
+	}
+	return len(values) > 0, nil
+}
+
+// Commit tracks the state changes of a committed block and
+// snapshots the validator set at the last block of an epoch.
+// Blocks must be committed in order of height.
+func (store *EpochValidatorStore) Commit(height uint64, changes []*StateChange) error {
+	store.mtx.Lock()
+	defer store.mtx.Unlock()
+
+	for _, sc := range changes {
+		if bytes.Equal(sc.StateKey(), ValidatorSetKey) {
+			store.value = sc.Value()
+		}
+	}
+	defer func() {
+		store.current = store.forEpoch(store.Epoch(height + 1))
+	}()
+	if (height+1)%store.epochLength != 0 || store.value == nil {
+		return nil
+	}
+	return store.snapshot(store.Epoch(height) + 2)
+}
+
+// snapshot sets the store of the committed value active from epoch and persists it
+func (store *EpochValidatorStore) snapshot(epoch uint64) error {
+	vs, err := store.decode(store.value)
+	if err != nil {
+		return err
+	}
+	if err := store.persist(epoch, store.value); err != nil {
+		return err
+	}
+	store.setStore(epoch, vs)
+	return nil
+}
+
+func (store *EpochValidatorStore) persist(epoch uint64, value []byte) error {
+	if store.snapshots == nil {
+		return nil
+	}
+	if err := store.snapshots.SetEpochValidatorSet(epoch, value); err != nil {
+		return fmt.Errorf("persist validator set of epoch %d, %w", epoch, err)
+	}
+	return nil
+}
+
+func (store *EpochValidatorStore) decode(value []byte) (ValidatorStore, error) {
+	set, err := UnmarshalValidatorSet(value)
+	if err != nil {
+		return nil, err
+	}
+	return set.Store()
+}
+
+func (store *EpochValidatorStore) setStore(epoch uint64, vs ValidatorStore) {
+	if _, ok := store.stores[epoch]; !ok {
+		i := sort.Search(len(store.epochs), func(i int) bool {
+			return store.epochs[i] > epoch
+		})
+		store.epochs = append(store.epochs, 0)
+		copy(store.epochs[i+1:], store.epochs[i:])
+		store.epochs[i] = epoch
+	}
+	store.stores[epoch] = vs
+}
+
+// getCurrent returns the store of the next block to commit
+func (store *EpochValidatorStore) getCurrent() ValidatorStore {
+	store.mtx.RLock()
+	defer store.mtx.RUnlock()
+	return store.current
+}
+
+func (store *EpochValidatorStore) ValidatorCount() int {
+	return store.getCurrent().ValidatorCount()
+}
+
+func (store *EpochValidatorStore) MajorityCount() int {
+	return store.getCurrent().MajorityCount()
+}
+
+func (store *EpochValidatorStore) IsValidator(pubKey *PublicKey) bool {
+	return store.getCurrent().IsValidator(pubKey)
+}
+
+func (store *EpochValidatorStore) GetValidator(idx int) *PublicKey {
+	return store.getCurrent().GetValidator(idx)
+}
+
+func (store *EpochValidatorStore) GetValidatorIndex(pubKey *PublicKey) int {
+	return store.getCurrent().GetValidatorIndex(pubKey)
+}
+
+func (store *EpochValidatorStore) GetStake(pubKey *PublicKey) uint64 {
+	return store.getCurrent().GetStake(pubKey)
+}
+
+func (store *EpochValidatorStore) MajorityStake() uint64 {
+	return store.getCurrent().MajorityStake()
+}
@@ -0,0 +1,225 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestValidatorSet(count int) ([]*PrivateKey, *ValidatorSet) {
+	privs := make([]*PrivateKey, count)
+	set := &ValidatorSet{Validators: make([][]byte, count)}
+	for i := range privs {
+		privs[i] = GenerateKey(nil)
+		set.Validators[i] = privs[i].PublicKey().Bytes()
+	}
+	return privs, set
+}
+
+func newTestQC(blk *Block, privs []*PrivateKey) *QuorumCert {
+	votes := make([]*Vote, len(privs))
+	for i, priv := range privs {
+		votes[i] = blk.Vote(priv)
+	}
+	return NewQuorumCert().Build(votes)
+}
+
+func TestValidatorSet_Store(t *testing.T) {
+	assert := assert.New(t)
+
+	privs, set := newTestValidatorSet(4)
+	b, err := set.Marshal()
+	assert.NoError(err)
+	set, err = UnmarshalValidatorSet(b)
+	assert.NoError(err)
+	vs, err := set.Store()
+	assert.NoError(err)
+	assert.Equal(4, vs.ValidatorCount())
+	assert.True(vs.IsValidator(privs[3].PublicKey()))
+	assert.EqualValues(1, vs.GetStake(privs[3].PublicKey()))
+
+	set.Stakes = []uint64{1, 2, 3, 4}
+	vs, err = set.Store()
+	assert.NoError(err)
+	assert.EqualValues(4, vs.GetStake(privs[3].PublicKey()))
+
+	set.Stakes = []uint64{1}
+	_, err = set.Store()
+	assert.ErrorIs(err, ErrInvalidValidatorSet)
+
+	_, err = (&ValidatorSet{}).Store()
+	assert.ErrorIs(err, ErrInvalidValidatorSet)
+
+	_, err = (&ValidatorSet{Validators: [][]byte{{1}}}).Store()
+	assert.ErrorIs(err, ErrInvalidValidatorSet)
+
+	_, err = UnmarshalValidatorSet([]byte("invalid"))
+	assert.ErrorIs(err, ErrInvalidValidatorSet)
+
+	// bls validators must prove possession of their keys
+	bls := GenerateBLSKey(nil)
+	rogue := GenerateBLSKey(nil)
+	set = &ValidatorSet{Validators: [][]byte{privs[0].PublicKey().Bytes(), bls.PublicKey().Bytes()}}
+	_, err = set.Store()
+	assert.ErrorIs(err, ErrInvalidValidatorSet, "missing proof")
+	set.Proofs = [][]byte{nil, rogue.ProvePossession()}
+	_, err = set.Store()
+	assert.ErrorIs(err, ErrInvalidValidatorSet, "proof of other key")
+	set.Proofs = [][]byte{nil, bls.PublicKey().Bytes()}
+	_, err = set.Store()
+	assert.ErrorIs(err, ErrInvalidValidatorSet, "invalid proof")
+	set.Proofs = [][]byte{nil, bls.ProvePossession()}
+	vs, err = set.Store()
+	assert.NoError(err)
+	assert.True(vs.IsValidator(bls.PublicKey()))
+}
+
+func TestEpochValidatorStore(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := NewEpochValidatorStore(0, nil)
+	assert.ErrorIs(err, ErrInvalidEpochLength)
+
+	privsA, setA := newTestValidatorSet(4)
+	privsB, setB := newTestValidatorSet(4)
+	vsA, _ := setA.Store()
+	valueB, _ := setB.Marshal()
+
+	store, err := NewEpochValidatorStore(4, vsA)
+	assert.NoError(err)
+	assert.Equal(0, store.GetValidatorIndex(privsA[0].PublicKey()))
+
+	changeB := NewStateChange().SetKey(ValidatorSetKey).SetValue(valueB)
+	other := NewStateChange().SetKey([]byte("other")).SetValue([]byte{1})
+	for h := uint64(0); h < 7; h++ {
+		var changes []*StateChange
+		if h == 2 {
+			changes = []*StateChange{other, changeB}
+		}
+		assert.NoError(store.Commit(h, changes))
+		// set changed in epoch 0 is active at epoch 2
+		assert.True(store.IsValidator(privsA[0].PublicKey()), "height %d", h)
+	}
+	assert.NoError(store.Commit(7, nil))
+	assert.True(store.IsValidator(privsB[0].PublicKey()), "next block is in epoch 2")
+	assert.False(store.IsValidator(privsA[0].PublicKey()))
+
+	for h := uint64(0); h < 8; h++ {
+		assert.True(store.ForHeight(h).IsValidator(privsA[1].PublicKey()))
+		assert.False(store.ForHeight(h).IsValidator(privsB[1].PublicKey()))
+	}
+	for _, h := range []uint64{8, 11, 100} {
+		assert.False(store.ForHeight(h).IsValidator(privsA[1].PublicKey()))
+		assert.True(store.ForHeight(h).IsValidator(privsB[1].PublicKey()))
+	}
+
+	// old qc of epoch 1 still validates after the set changed
+	blk5 := NewBlock().SetHeight(5).Sign(privsA[0])
+	qc5 := newTestQC(blk5, privsA[:3])
+	assert.NoError(qc5.Validate(store))
+	assert.ErrorIs(newTestQC(blk5, privsB[:3]).Validate(store), ErrInvalidValidator)
+
+	blk8 := NewBlock().SetHeight(8).SetQuorumCert(qc5).Sign(privsB[0])
+	assert.NoError(blk8.Validate(store), "qc of epoch 1, proposer of epoch 2")
+	assert.ErrorIs(NewBlock().SetHeight(8).SetQuorumCert(qc5).Sign(privsA[0]).Validate(store),
+		ErrInvalidValidator)
+
+	qc8 := newTestQC(blk8, privsB[:3])
+	assert.NoError(qc8.Validate(store))
+	assert.ErrorIs(newTestQC(blk8, privsA[:3]).Validate(store), ErrInvalidValidator)
+
+	assert.NoError(blk5.Vote(privsA[2]).Validate(store))
+	assert.ErrorIs(blk5.Vote(privsB[2]).Validate(store), ErrInvalidValidator)
+	assert.NoError(blk8.Vote(privsB[2]).Validate(store))
+	assert.ErrorIs(blk8.Vote(privsA[2]).Validate(store), ErrInvalidValidator)
+
+	// invalid set at epoch end
+	invalid := NewStateChange().SetKey(ValidatorSetKey).SetValue([]byte("invalid"))
+	assert.NoError(store.Commit(8, []*StateChange{invalid}))
+	assert.ErrorIs(store.Commit(11, nil), ErrInvalidValidatorSet)
+}
+
+func TestEpochValidatorStore_Restore(t *testing.T) {
+	assert := assert.New(t)
+
+	privsA, setA := newTestValidatorSet(4)
+	privsB, setB := newTestValidatorSet(4)
+	vsA, _ := setA.Store()
+	valueB, _ := setB.Marshal()
+
+	store, _ := NewEpochValidatorStore(4, vsA)
+	assert.NoError(store.Restore(9, nil))
+	assert.True(store.IsValidator(privsA[0].PublicKey()))
+
+	assert.NoError(store.Restore(9, valueB))
+	assert.True(store.IsValidator(privsB[0].PublicKey()))
+	assert.True(store.ForHeight(8).IsValidator(privsB[0].PublicKey()))
+	assert.True(store.ForHeight(7).IsValidator(privsA[0].PublicKey()))
+
+	assert.ErrorIs(store.Restore(9, []byte("invalid")), ErrInvalidValidatorSet)
+}
+
+type mapEpochSnapshots map[uint64][]byte
+
+func (m mapEpochSnapshots) SetEpochValidatorSet(epoch uint64, value []byte) error {
+	m[epoch] = value
+	return nil
+}
+
+func (m mapEpochSnapshots) GetEpochValidatorSets() (map[uint64][]byte, error) {
+	return m, nil
+}
+
+func TestEpochValidatorStore_RestoreSnapshots(t *testing.T) {
+	assert := assert.New(t)
+
+	privsA, setA := newTestValidatorSet(4)
+	privsB, setB := newTestValidatorSet(4)
+	privsC, setC := newTestValidatorSet(4)
+	vsA, _ := setA.Store()
+	valueB, _ := setB.Marshal()
+	valueC, _ := setC.Marshal()
+
+	snapshots := make(mapEpochSnapshots)
+	store, _ := NewEpochValidatorStore(4, vsA)
+	store.SetSnapshotStore(snapshots)
+	assert.NoError(store.Restore(0, nil))
+	for h := uint64(0); h < 10; h++ {
+		var changes []*StateChange
+		if h == 2 {
+			changes = []*StateChange{NewStateChange().SetKey(ValidatorSetKey).SetValue(valueB)}
+		}
+		if h == 9 {
+			changes = []*StateChange{NewStateChange().SetKey(ValidatorSetKey).SetValue(valueC)}
+		}
+		assert.NoError(store.Commit(h, changes))
+	}
+	assert.Equal(mapEpochSnapshots{0: nil, 2: valueB, 3: valueB}, snapshots)
+
+	// restarted in epoch 2, set C is pending until the end of the epoch
+	restarted := func(height uint64) *EpochValidatorStore {
+		store, _ := NewEpochValidatorStore(4, vsA)
+		assert.NoError(store.SetSnapshotStore(snapshots).Restore(height, valueC))
+		return store
+	}
+	store = restarted(9)
+	assert.True(store.IsValidator(privsB[0].PublicKey()))
+	assert.True(store.ForHeight(7).IsValidator(privsA[0].PublicKey()))
+	assert.True(store.ForHeight(15).IsValidator(privsB[0].PublicKey()))
+	assert.False(store.ForHeight(15).IsValidator(privsC[0].PublicKey()))
+
+	assert.NoError(store.Commit(10, nil))
+	assert.NoError(store.Commit(11, nil))
+	assert.True(store.ForHeight(16).IsValidator(privsC[0].PublicKey()))
+	assert.Equal(valueC, snapshots[4])
+
+	// stopped after committing the last block of epoch 2, before persisting the snapshot
+	delete(snapshots, 4)
+	store = restarted(11)
+	assert.True(store.ForHeight(11).IsValidator(privsB[0].PublicKey()))
+	assert.True(store.ForHeight(16).IsValidator(privsC[0].PublicKey()))
+	assert.Equal(valueC, snapshots[4])
+}
@@ -0,0 +1,119 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/aungmawjj/juria-blockchain/core/core_pb"
+	"google.golang.org/protobuf/proto"
+)
+
+// errors
+var (
+	ErrInvalidGenesis  = errors.New("invalid genesis")
+	ErrGenesisMismatch = errors.New("genesis mismatch")
+)
+
+// NewGenesisBlock creates deterministic height 0 block.
+// Parent hash of the block commits to chain id and validator public keys,
+// so that the block hash pins the initial validator set.
+// The first validator is the proposer, it signs the block to start the chain,
+// the signature is not part of the hash.
+func NewGenesisBlock(chainID []byte, validators [][]byte, stateRoot []byte) *Block {
+	blk := NewBlock().
+		SetHeight(0).
+		SetParentHash(genesisCommitment(chainID, validators)).
+		SetMerkleRoot(stateRoot)
+	if len(validators) > 0 {
+		blk.data.Proposer = validators[0]
+		blk.proposer, _ = NewPublicKey(validators[0])
+	}
+	blk.data.HashScheme = hashSchemeID(GetHashFunc())
+	blk.data.Hash = blk.Sum()
+	return blk
+}
+
+func genesisCommitment(chainID []byte, validators [][]byte) []byte {
+	hs := getHasher()
+	hs.writeUint64(uint64(len(chainID)))
+	hs.write(chainID)
+	hs.writeUint64(uint64(len(validators)))
+	for _, v := range validators {
+		hs.writeUint64(uint64(len(v)))
+		hs.write(v)
+	}
+	return hs.sum()
+}
+
+// Genesis is the genesis block with embedded chain id and validator set
+type Genesis struct {
+	data  *core_pb.Genesis
+	block *Block
+}
+
+// NewGenesis creates genesis with the deterministic genesis block
+func NewGenesis(chainID []byte, validators [][]byte, stateRoot []byte) *Genesis {
+	blk := NewGenesisBlock(chainID, validators, stateRoot)
+	return &Genesis{
+		data: &core_pb.Genesis{
+			ChainID:    chainID,
+			Validators: validators,
+			Block:      blk.data,
+		},
+		block: blk,
+	}
+}
+
+// UnmarshalGenesis decodes genesis and checks that block commits to its chain id and validators
+func UnmarshalGenesis(b []byte) (*Genesis, error) {
+	data := new(core_pb.Genesis)
+	if err := proto.Unmarshal(b, data); err != nil {
+		return nil, err
+	}
+	if data.Block == nil {
+		return nil, fmt.Errorf("%w, nil block", ErrInvalidGenesis)
+	}
+	if data.Block.HashScheme != hashSchemeID(GetHashFunc()) {
+		return nil, fmt.Errorf("%w, genesis %d, local %d",
+			ErrHashSchemeMismatch, data.Block.HashScheme, hashSchemeID(GetHashFunc()))
+	}
+	if len(data.Validators) == 0 {
+		return nil, fmt.Errorf("%w, no validators", ErrInvalidGenesis)
+	}
+	for _, v := range data.Validators {
+		if _, err := NewPublicKey(v); err != nil {
+			return nil, fmt.Errorf("%w, %v", ErrInvalidGenesis, err)
+		}
+	}
+	expected := NewGenesisBlock(data.ChainID, data.Validators, data.Block.MerkleRoot)
+	if !proto.Equal(expected.data, data.Block) {
+		return nil, fmt.Errorf("%w, block doesn't match chain id and validators", ErrInvalidGenesis)
+	}
+	return &Genesis{data, expected}, nil
+}
+
+// Verify checks that genesis is created for the chain id and validators
+func (g *Genesis) Verify(chainID []byte, validators [][]byte) error {
+	if !bytes.Equal(g.data.ChainID, chainID) {
+		return fmt.Errorf("%w, different chain id", ErrGenesisMismatch)
+	}
+	if !bytes.Equal(g.block.ParentHash(), genesisCommitment(chainID, validators)) {
+		return fmt.Errorf("%w, different validators", ErrGenesisMismatch)
+	}
+	return nil
+}
+
+func (g *Genesis) Block() *Block        { return g.block }
+func (g *Genesis) Hash() []byte         { return g.block.Hash() }
+func (g *Genesis) ChainID() []byte      { return copyBytes(g.data.ChainID) }
+func (g *Genesis) Validators() [][]byte { return copyBytesList(g.data.Validators) }
+func (g *Genesis) StateRoot() []byte    { return g.block.MerkleRoot() }
+
+// Marshal encodes genesis as bytes
+func (g *Genesis) Marshal() ([]byte, error) {
+	return proto.Marshal(g.data)
+}
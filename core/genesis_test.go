@@ -0,0 +1,129 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package core
+
+import (
+	"testing"
+
+	"github.com/aungmawjj/juria-blockchain/core/core_pb"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+)
+
+func newGenesisValidators(n int) [][]byte {
+	vlds := make([][]byte, n)
+	for i := range vlds {
+		vlds[i] = GenerateKey(nil).PublicKey().Bytes()
+	}
+	return vlds
+}
+
+func TestNewGenesisBlock(t *testing.T) {
+	assert := assert.New(t)
+
+	chainID := []byte{1}
+	vlds := newGenesisValidators(4)
+	b0 := NewGenesisBlock(chainID, vlds, []byte("root"))
+
+	assert.True(b0.IsGenesis())
+	assert.Equal(b0.Hash(), b0.Sum())
+	assert.Equal(b0.Hash(), NewGenesisBlock(chainID, vlds, []byte("root")).Hash())
+
+	reordered := [][]byte{vlds[1], vlds[0], vlds[2], vlds[3]}
+	assert.NotEqual(b0.Hash(), NewGenesisBlock(chainID, reordered, []byte("root")).Hash())
+	assert.NotEqual(b0.Hash(), NewGenesisBlock([]byte{2}, vlds, []byte("root")).Hash())
+	assert.NotEqual(b0.Hash(), NewGenesisBlock(chainID, vlds[:3], []byte("root")).Hash())
+	assert.NotEqual(b0.Hash(), NewGenesisBlock(chainID, vlds, nil).Hash())
+}
+
+func TestNewGenesisBlock_Sign(t *testing.T) {
+	assert := assert.New(t)
+
+	leader := GenerateKey(nil)
+	vlds := append([][]byte{leader.PublicKey().Bytes()}, newGenesisValidators(3)...)
+	b0 := NewGenesisBlock([]byte{1}, vlds, nil)
+	assert.True(b0.Proposer().Equal(leader.PublicKey()))
+
+	// the first validator signs the pinned block to start the chain
+	signed := b0.Clone().Sign(leader)
+	assert.Equal(b0.Hash(), signed.Hash())
+	vs := NewValidatorStore([]*PublicKey{leader.PublicKey()})
+	assert.NoError(signed.Validate(vs))
+
+	other := GenerateKey(nil)
+	assert.NotEqual(b0.Hash(), b0.Clone().Sign(other).Hash())
+}
+
+func TestUnmarshalGenesis(t *testing.T) {
+	chainID := []byte{1}
+	vlds := newGenesisValidators(4)
+	g := NewGenesis(chainID, vlds, []byte("root"))
+	bOk, _ := g.Marshal()
+
+	tamper := func(fn func(data *core_pb.Genesis)) []byte {
+		data := proto.Clone(g.data).(*core_pb.Genesis)
+		fn(data)
+		b, _ := proto.Marshal(data)
+		return b
+	}
+
+	tests := []struct {
+		name  string
+		b     []byte
+		isErr bool
+	}{
+		{"valid", bOk, false},
+		{"nil genesis", nil, true},
+		{"invalid bytes", []byte("invalid"), true},
+		{"nil block", tamper(func(data *core_pb.Genesis) {
+			data.Block = nil
+		}), true},
+		{"no validators", tamper(func(data *core_pb.Genesis) {
+			data.Validators = nil
+		}), true},
+		{"invalid validator key", tamper(func(data *core_pb.Genesis) {
+			data.Validators[0] = []byte("invalid key")
+		}), true},
+		{"different validators", tamper(func(data *core_pb.Genesis) {
+			data.Validators = newGenesisValidators(4)
+		}), true},
+		{"different chain id", tamper(func(data *core_pb.Genesis) {
+			data.ChainID = []byte{2}
+		}), true},
+		{"tampered block", tamper(func(data *core_pb.Genesis) {
+			data.Block.Timestamp = 1
+		}), true},
+		{"tampered hash", tamper(func(data *core_pb.Genesis) {
+			data.Block.Hash = []byte("invalid hash")
+		}), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+			g1, err := UnmarshalGenesis(tt.b)
+			if tt.isErr {
+				assert.Error(err)
+				return
+			}
+			assert.NoError(err)
+			assert.Equal(g.Hash(), g1.Hash())
+			assert.Equal(chainID, g1.ChainID())
+			assert.Equal(vlds, g1.Validators())
+			assert.Equal([]byte("root"), g1.StateRoot())
+		})
+	}
+}
+
+func TestGenesis_Verify(t *testing.T) {
+	assert := assert.New(t)
+
+	chainID := []byte{1}
+	vlds := newGenesisValidators(4)
+	g := NewGenesis(chainID, vlds, nil)
+
+	assert.NoError(g.Verify(chainID, vlds))
+	assert.ErrorIs(g.Verify([]byte{2}, vlds), ErrGenesisMismatch)
+	assert.ErrorIs(g.Verify(chainID, vlds[:3]), ErrGenesisMismatch)
+	assert.ErrorIs(g.Verify(chainID, newGenesisValidators(4)), ErrGenesisMismatch)
+}
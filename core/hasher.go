@@ -0,0 +1,121 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package core
+
+import (
+	"crypto"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"sync"
+
+	_ "golang.org/x/crypto/blake2b" // registers crypto.BLAKE2b_256
+	_ "golang.org/x/crypto/sha3"    // registers crypto.SHA3_256
+)
+
+// errors
+var (
+	ErrUnsupportedHash    = errors.New("unsupported hash function")
+	ErrHashSchemeMismatch = errors.New("hash scheme mismatch")
+)
+
+// hash functions which can be selected by SetHashFunc, by name
+var hashFuncs = map[string]crypto.Hash{
+	"sha3-256":    crypto.SHA3_256,
+	"sha256":      crypto.SHA256,
+	"blake2b-256": crypto.BLAKE2b_256,
+}
+
+// ParseHashFunc returns the hash function by name
+func ParseHashFunc(name string) (crypto.Hash, error) {
+	h, ok := hashFuncs[name]
+	if !ok {
+		return 0, fmt.Errorf("%w, %s", ErrUnsupportedHash, name)
+	}
+	return h, nil
+}
+
+var (
+	hashFunc    = crypto.SHA3_256
+	mtxHashFunc sync.RWMutex
+
+	hasherPools sync.Map // crypto.Hash -> *sync.Pool
+)
+
+// SetHashFunc sets the chain wide hash function of block, tx, genesis and vote digests.
+// It must be set before creating or validating any of them, sha3-256 is the default.
+func SetHashFunc(h crypto.Hash) error {
+	if !isSupportedHash(h) {
+		return fmt.Errorf("%w, %v", ErrUnsupportedHash, h)
+	}
+	mtxHashFunc.Lock()
+	defer mtxHashFunc.Unlock()
+	hashFunc = h
+	return nil
+}
+
+// GetHashFunc returns the chain wide hash function
+func GetHashFunc() crypto.Hash {
+	mtxHashFunc.RLock()
+	defer mtxHashFunc.RUnlock()
+	return hashFunc
+}
+
+func isSupportedHash(h crypto.Hash) bool {
+	for _, v := range hashFuncs {
+		if v == h {
+			return h.Available()
+		}
+	}
+	return false
+}
+
+// hashSchemeID is the identifier of hash function recorded in blocks.
+// sha3-256 is zero, so that blocks of the default scheme are encoded as before.
+func hashSchemeID(h crypto.Hash) uint32 {
+	if h == crypto.SHA3_256 {
+		return 0
+	}
+	return uint32(h)
+}
+
+// hasher is a reusable hash state with a scratch buffer for integer encodings
+type hasher struct {
+	h    hash.Hash
+	buf  [8]byte
+	pool *sync.Pool
+}
+
+func getHasher() *hasher {
+	h := GetHashFunc()
+	pool, ok := hasherPools.Load(h)
+	if !ok {
+		pool, _ = hasherPools.LoadOrStore(h, &sync.Pool{
+			New: func() interface{} {
+				return &hasher{h: h.New()}
+			},
+		})
+	}
+	hs := pool.(*sync.Pool).Get().(*hasher)
+	hs.pool = pool.(*sync.Pool)
+	return hs
+}
+
+func (hs *hasher) write(b []byte) {
+	hs.h.Write(b)
+}
+
+func (hs *hasher) writeUint64(v uint64) {
+	binary.BigEndian.PutUint64(hs.buf[:], v)
+	hs.h.Write(hs.buf[:])
+}
+
+// sum returns the hash and puts hasher back to pool, hs must not be used after sum
+func (hs *hasher) sum() []byte {
+	ret := hs.h.Sum(nil)
+	hs.h.Reset()
+	hs.pool.Put(hs)
+	return ret
+}
@@ -0,0 +1,102 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package core
+
+import (
+	"crypto"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseHashFunc(t *testing.T) {
+	assert := assert.New(t)
+
+	h, err := ParseHashFunc("sha256")
+	assert.NoError(err)
+	assert.Equal(crypto.SHA256, h)
+
+	_, err = ParseHashFunc("md5")
+	assert.ErrorIs(err, ErrUnsupportedHash)
+	assert.ErrorIs(SetHashFunc(crypto.MD5), ErrUnsupportedHash)
+	assert.Equal(crypto.SHA3_256, GetHashFunc())
+}
+
+func TestHashFunc_Stable(t *testing.T) {
+	tests := []struct {
+		name    string
+		h       crypto.Hash
+		txHash  string
+		blkHash string
+	}{
+		{"sha3-256", crypto.SHA3_256,
+			"5706ad3fc11dc305bca6c8a3bcc641879a01988e3715aec775026894aafbee34",
+			"155e40b5da0ad1cf381799bc437fccbc73e7bb29e6c75bec21e1c6db4d2face6"},
+		{"sha256", crypto.SHA256,
+			"ad7b0e8fea493542719bceac4e4705719c5387404009b19503df879b2ed368fc",
+			"3b6fdee8028f7c5adff792c344e2e2230a564c7fcec1a03662b7d10d510262c7"},
+		{"blake2b-256", crypto.BLAKE2b_256,
+			"2b64ef4b4ce2f7e4dd0e73189ad96b73299a565bc34d09bff169ea03864a3277",
+			"82320769c6b235e9003755bc87ac6ab1536f68ecd4bfbb8d6f008f9e357c9f71"},
+	}
+	seen := make(map[string]bool)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+			assert.NoError(SetHashFunc(tt.h))
+			defer SetHashFunc(crypto.SHA3_256)
+
+			tx := NewTransaction().SetNonce(1).SetInput([]byte("juria")).Sum()
+			blk := NewGenesisBlock([]byte("chain"), [][]byte{{1}}, []byte{2})
+			assert.Equal(tt.txHash, hex.EncodeToString(tx))
+			assert.Equal(tt.blkHash, hex.EncodeToString(blk.Hash()))
+			assert.False(seen[tt.txHash], "tx hash must differ per scheme")
+			assert.False(seen[tt.blkHash], "block hash must differ per scheme")
+			seen[tt.txHash], seen[tt.blkHash] = true, true
+		})
+	}
+}
+
+func TestHashFunc_Mismatch(t *testing.T) {
+	assert := assert.New(t)
+
+	priv := GenerateKey(nil)
+	vs := NewValidatorStore([]*PublicKey{priv.PublicKey()})
+	legacy := NewGenesis([]byte("chain"), [][]byte{priv.PublicKey().Bytes()}, nil)
+	bLegacy, _ := legacy.Marshal()
+
+	assert.NoError(SetHashFunc(crypto.SHA256))
+	defer SetHashFunc(crypto.SHA3_256)
+
+	g := NewGenesis([]byte("chain"), [][]byte{priv.PublicKey().Bytes()}, nil)
+	assert.EqualValues(crypto.SHA256, g.Block().HashScheme())
+	b, err := g.Marshal()
+	assert.NoError(err)
+	_, err = UnmarshalGenesis(b)
+	assert.NoError(err)
+	_, err = UnmarshalGenesis(bLegacy)
+	assert.ErrorIs(err, ErrHashSchemeMismatch)
+
+	blk := NewBlock().SetHeight(0).Sign(priv)
+	assert.NoError(blk.Validate(vs))
+	tx := NewTransaction().SetNonce(1).Sign(priv)
+	assert.NoError(tx.Validate())
+	bTx, _ := tx.Marshal()
+
+	SetHashFunc(crypto.SHA3_256)
+	assert.ErrorIs(blk.Validate(vs), ErrHashSchemeMismatch)
+	tx = NewTransaction()
+	assert.NoError(tx.Unmarshal(bTx))
+	assert.ErrorIs(tx.Validate(), ErrInvalidTxHash)
+	_, err = UnmarshalGenesis(b)
+	assert.ErrorIs(err, ErrHashSchemeMismatch)
+
+	// scheme is bound to block hash
+	blk = NewBlock().SetHeight(0).Sign(priv)
+	assert.EqualValues(0, blk.HashScheme())
+	blk.data.HashScheme = uint32(crypto.SHA256)
+	blk.resetSum()
+	assert.NotEqual(blk.data.Hash, blk.Sum())
+}
@@ -0,0 +1,76 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package core
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+)
+
+// errors
+var (
+	ErrInvalidSeedSize = errors.New("invalid seed size")
+)
+
+// HardenedKeyStart is the first hardened child index
+const HardenedKeyStart uint32 = 0x80000000
+
+var masterKeyHMACKey = []byte("ed25519 seed")
+
+// ExtendedKey is a private key with chain code for hierarchical deterministic derivation.
+// It follows SLIP-0010 for ed25519, where only hardened child keys are defined.
+type ExtendedKey struct {
+	key       []byte // ed25519 private key seed
+	chainCode []byte
+}
+
+// NewMasterKey creates the root key from a 16 to 64 bytes seed
+func NewMasterKey(seed []byte) (*ExtendedKey, error) {
+	if len(seed) < 16 || len(seed) > 64 {
+		return nil, ErrInvalidSeedSize
+	}
+	return newExtendedKey(hmacSHA512(masterKeyHMACKey, seed)), nil
+}
+
+func newExtendedKey(i []byte) *ExtendedKey {
+	return &ExtendedKey{
+		key:       i[:32],
+		chainCode: i[32:],
+	}
+}
+
+// DeriveChild returns the hardened child key at index.
+// Index below HardenedKeyStart is treated as hardened, so DeriveChild(0) is m/0'.
+func (k *ExtendedKey) DeriveChild(index uint32) *ExtendedKey {
+	data := make([]byte, 1+len(k.key)+4)
+	copy(data[1:], k.key)
+	binary.BigEndian.PutUint32(data[1+len(k.key):], index|HardenedKeyStart)
+	return newExtendedKey(hmacSHA512(k.chainCode, data))
+}
+
+// DerivePath derives descendant key by child indexes
+func (k *ExtendedKey) DerivePath(indexes ...uint32) *ExtendedKey {
+	for _, index := range indexes {
+		k = k.DeriveChild(index)
+	}
+	return k
+}
+
+// PrivateKey returns the signing key
+func (k *ExtendedKey) PrivateKey() *PrivateKey {
+	priv, _ := NewPrivateKey(ed25519.NewKeyFromSeed(k.key))
+	return priv
+}
+
+// ChainCode returns the chain code used to derive children
+func (k *ExtendedKey) ChainCode() []byte { return copyBytes(k.chainCode) }
+
+func hmacSHA512(key, data []byte) []byte {
+	mac := hmac.New(sha512.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,78 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package core
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// test vector 1 for ed25519 from SLIP-0010
+func TestExtendedKey_SLIP10Vectors(t *testing.T) {
+	seed, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+	master, err := NewMasterKey(seed)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	tests := []struct {
+		name      string
+		path      []uint32
+		chainCode string
+		priv      string
+		pub       string
+	}{
+		{
+			"m", nil,
+			"90046a93de5380a72b5e45010748567d5ea02bbf6522f979e05c0d8d8ca9fffb",
+			"2b4be7f19ee27bbf30c667b642d5f4aa69fd169872f8fc3059c08ebae2eb19e7",
+			"a4b2856bfec510abab89753fac1ac0e1112364e7d250545963f135f2a33188ed",
+		},
+		{
+			"m/0'", []uint32{0},
+			"8b59aa11380b624e81507a27fedda59fea6d0b779a778918a2fd3590e16e9c69",
+			"68e0fe46dfb67e368c75379acec591dad19df3cde26e63b93a8e704f1dade7a3",
+			"8c8a13df77a28f3445213a0f432fde644acaa215fc72dcdf300d5efaa85d350c",
+		},
+		{
+			"m/0'/1'", []uint32{0, 1},
+			"a320425f77d1b5c2505a6b1b27382b37368ee640e3557c315416801243552f14",
+			"b1d0bad404bf35da785a64ca1ac54b2617211d2777696fbffaf208f746ae84f2",
+			"1932a5270f335bed617d5b935c80aedb1a35bd9fc1e31acafd5372c30f5c1187",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+			k := master.DerivePath(tt.path...)
+			assert.Equal(tt.chainCode, hex.EncodeToString(k.ChainCode()))
+
+			priv := k.PrivateKey()
+			assert.Equal(tt.priv, hex.EncodeToString(ed25519.PrivateKey(priv.Bytes()).Seed()))
+			assert.Equal(tt.pub, hex.EncodeToString(priv.PublicKey().Bytes()))
+		})
+	}
+}
+
+func TestExtendedKey_DeriveChild(t *testing.T) {
+	assert := assert.New(t)
+
+	seed := make([]byte, 32)
+	m1, _ := NewMasterKey(seed)
+	m2, _ := NewMasterKey(seed)
+
+	assert.Equal(m1.DeriveChild(5).PrivateKey(), m2.DeriveChild(5).PrivateKey(), "deterministic")
+	assert.Equal(m1.DeriveChild(5).PrivateKey(), m1.DeriveChild(5|HardenedKeyStart).PrivateKey(),
+		"always hardened")
+	assert.NotEqual(m1.DeriveChild(5).PrivateKey(), m1.DeriveChild(6).PrivateKey())
+	assert.Equal(m1.DeriveChild(1).DeriveChild(2).PrivateKey(), m1.DerivePath(1, 2).PrivateKey())
+
+	_, err := NewMasterKey(make([]byte, 15))
+	assert.ErrorIs(err, ErrInvalidSeedSize)
+	_, err = NewMasterKey(make([]byte, 65))
+	assert.ErrorIs(err, ErrInvalidSeedSize)
+}
@@ -0,0 +1,142 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package core
+
+import (
+	"errors"
+	"fmt"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// errors
+var (
+	ErrMsgTooLarge = errors.New("message too large")
+	ErrTooManySigs = errors.New("too many signatures")
+)
+
+// protobuf field numbers of repeated fields, see core_pb/core.proto
+const (
+	fieldBlockQC       protowire.Number = 5
+	fieldBlockTxs      protowire.Number = 9
+	fieldQCSignatures  protowire.Number = 2
+	fieldTxListEntries protowire.Number = 1
+)
+
+// UnmarshalLimits bounds messages decoded from untrusted peers.
+// Limits are checked on the wire format before decoding. Zero value means no limit.
+type UnmarshalLimits struct {
+	MaxSize int // encoded bytes
+	MaxTxs  int // txs in a block or tx list
+	MaxSigs int // signatures in a qc
+}
+
+// UnmarshalWithLimits decodes block from bytes after checking limits
+func (blk *Block) UnmarshalWithLimits(b []byte, limits UnmarshalLimits) error {
+	if err := limits.checkSize(b); err != nil {
+		return err
+	}
+	if err := limits.checkBlock(b); err != nil {
+		return err
+	}
+	return blk.Unmarshal(b)
+}
+
+// UnmarshalWithLimits decodes quorum cert from bytes after checking limits
+func (qc *QuorumCert) UnmarshalWithLimits(b []byte, limits UnmarshalLimits) error {
+	if err := limits.checkSize(b); err != nil {
+		return err
+	}
+	if err := limits.checkQC(b); err != nil {
+		return err
+	}
+	return qc.Unmarshal(b)
+}
+
+// UnmarshalWithLimits decodes vote from bytes after checking size limit
+func (vote *Vote) UnmarshalWithLimits(b []byte, limits UnmarshalLimits) error {
+	if err := limits.checkSize(b); err != nil {
+		return err
+	}
+	return vote.Unmarshal(b)
+}
+
+// UnmarshalWithLimits decodes tx list from bytes after checking limits
+func (txs *TxList) UnmarshalWithLimits(b []byte, limits UnmarshalLimits) error {
+	if err := limits.checkSize(b); err != nil {
+		return err
+	}
+	err := limits.checkRepeated(b, fieldTxListEntries, limits.MaxTxs, ErrTooManyTxs)
+	if err != nil {
+		return err
+	}
+	return txs.Unmarshal(b)
+}
+
+func (limits UnmarshalLimits) checkSize(b []byte) error {
+	if limits.MaxSize > 0 && len(b) > limits.MaxSize {
+		return fmt.Errorf("%w, size %d, limit %d", ErrMsgTooLarge, len(b), limits.MaxSize)
+	}
+	return nil
+}
+
+func (limits UnmarshalLimits) checkBlock(b []byte) error {
+	if err := limits.checkRepeated(b, fieldBlockTxs, limits.MaxTxs, ErrTooManyTxs); err != nil {
+		return err
+	}
+	return scanFields(b, func(num protowire.Number, val []byte) error {
+		if num != fieldBlockQC {
+			return nil
+		}
+		return limits.checkQC(val)
+	})
+}
+
+func (limits UnmarshalLimits) checkQC(b []byte) error {
+	return limits.checkRepeated(b, fieldQCSignatures, limits.MaxSigs, ErrTooManySigs)
+}
+
+func (limits UnmarshalLimits) checkRepeated(
+	b []byte, field protowire.Number, max int, errOver error,
+) error {
+	if max <= 0 {
+		return nil
+	}
+	count := 0
+	return scanFields(b, func(num protowire.Number, val []byte) error {
+		if num == field {
+			count++
+			if count > max {
+				return fmt.Errorf("%w, limit %d", errOver, max)
+			}
+		}
+		return nil
+	})
+}
+
+// scanFields calls fn for each top level field of an encoded message.
+// val is the payload of length-delimited fields, nil for other wire types.
+func scanFields(b []byte, fn func(num protowire.Number, val []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		var val []byte
+		if typ == protowire.BytesType {
+			val, n = protowire.ConsumeBytes(b)
+		} else {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		if err := fn(num, val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
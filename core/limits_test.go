@@ -0,0 +1,128 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newLimitsTestQC(sigCount int) *QuorumCert {
+	blk := NewBlock().SetHeight(1).Sign(GenerateKey(nil))
+	votes := make([]*Vote, sigCount)
+	for i := range votes {
+		votes[i] = blk.Vote(GenerateKey(nil))
+	}
+	return NewQuorumCert().Build(votes)
+}
+
+func newLimitsTestBlock(txCount, sigCount int) []byte {
+	b, _ := NewBlock().
+		SetHeight(2).
+		SetQuorumCert(newLimitsTestQC(sigCount)).
+		SetTransactions(make([][]byte, txCount)).
+		Sign(GenerateKey(nil)).
+		Marshal()
+	return b
+}
+
+func TestBlock_UnmarshalWithLimits(t *testing.T) {
+	atLimit := newLimitsTestBlock(3, 2)
+
+	tests := []struct {
+		name   string
+		b      []byte
+		limits UnmarshalLimits
+		err    error
+	}{
+		{"no limits", newLimitsTestBlock(10, 10), UnmarshalLimits{}, nil},
+		{"at limits", atLimit, UnmarshalLimits{len(atLimit), 3, 2}, nil},
+		{"over size", atLimit, UnmarshalLimits{len(atLimit) - 1, 3, 2}, ErrMsgTooLarge},
+		{"over txs", newLimitsTestBlock(4, 2), UnmarshalLimits{MaxTxs: 3, MaxSigs: 2}, ErrTooManyTxs},
+		{"over qc sigs", newLimitsTestBlock(3, 3), UnmarshalLimits{MaxTxs: 3, MaxSigs: 2}, ErrTooManySigs},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+			blk := NewBlock()
+			err := blk.UnmarshalWithLimits(tt.b, tt.limits)
+			if tt.err != nil {
+				assert.ErrorIs(err, tt.err)
+				return
+			}
+			assert.NoError(err)
+			assert.NotNil(blk.Hash())
+		})
+	}
+}
+
+func TestQuorumCert_UnmarshalWithLimits(t *testing.T) {
+	atLimit, _ := newLimitsTestQC(3).Marshal()
+	overLimit, _ := newLimitsTestQC(4).Marshal()
+
+	tests := []struct {
+		name   string
+		b      []byte
+		limits UnmarshalLimits
+		err    error
+	}{
+		{"at limits", atLimit, UnmarshalLimits{MaxSize: len(atLimit), MaxSigs: 3}, nil},
+		{"over size", atLimit, UnmarshalLimits{MaxSize: len(atLimit) - 1}, ErrMsgTooLarge},
+		{"over sigs", overLimit, UnmarshalLimits{MaxSigs: 3}, ErrTooManySigs},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := NewQuorumCert().UnmarshalWithLimits(tt.b, tt.limits)
+			assert.ErrorIs(t, err, tt.err)
+		})
+	}
+	t.Run("malformed", func(t *testing.T) {
+		err := NewQuorumCert().UnmarshalWithLimits([]byte{0xff}, UnmarshalLimits{MaxSigs: 3})
+		assert.Error(t, err)
+	})
+}
+
+func TestTxList_UnmarshalWithLimits(t *testing.T) {
+	newTxList := func(count int) []byte {
+		txs := make(TxList, count)
+		for i := range txs {
+			txs[i] = NewTransaction().SetNonce(int64(i)).Sign(GenerateKey(nil))
+		}
+		b, _ := txs.Marshal()
+		return b
+	}
+	atLimit := newTxList(3)
+
+	tests := []struct {
+		name   string
+		b      []byte
+		limits UnmarshalLimits
+		err    error
+	}{
+		{"at limits", atLimit, UnmarshalLimits{MaxSize: len(atLimit), MaxTxs: 3}, nil},
+		{"over size", atLimit, UnmarshalLimits{MaxSize: len(atLimit) - 1}, ErrMsgTooLarge},
+		{"over txs", newTxList(4), UnmarshalLimits{MaxTxs: 3}, ErrTooManyTxs},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+			txs := NewTxList()
+			err := txs.UnmarshalWithLimits(tt.b, tt.limits)
+			assert.ErrorIs(err, tt.err)
+			if tt.err == nil {
+				assert.Len(*txs, 3)
+			}
+		})
+	}
+}
+
+func TestVote_UnmarshalWithLimits(t *testing.T) {
+	assert := assert.New(t)
+
+	b, _ := NewBlock().Sign(GenerateKey(nil)).Vote(GenerateKey(nil)).Marshal()
+
+	assert.NoError(NewVote().UnmarshalWithLimits(b, UnmarshalLimits{MaxSize: len(b)}))
+	assert.ErrorIs(NewVote().UnmarshalWithLimits(b, UnmarshalLimits{MaxSize: len(b) - 1}), ErrMsgTooLarge)
+}
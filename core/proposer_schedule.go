@@ -0,0 +1,86 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package core
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// errors
+var (
+	ErrUnexpectedProposer = errors.New("unexpected block proposer")
+)
+
+// LeaderSchedule gives the validator index expected to propose the block at height.
+// A negative index means the leader of the height is unknown.
+type LeaderSchedule interface {
+	LeaderFor(height uint64) int
+}
+
+// LeaderEntry is the leader in effect from a block height
+type LeaderEntry struct {
+	Height uint64 `json:"height"`
+	Leader int    `json:"leader"`
+}
+
+// LeaderHistory is a list of leader entries in ascending order of height
+type LeaderHistory []LeaderEntry
+
+var _ LeaderSchedule = LeaderHistory(nil)
+
+// LeaderFor returns the leader of the latest entry at or below height, -1 if there is none
+func (lh LeaderHistory) LeaderFor(height uint64) int {
+	i := sort.Search(len(lh), func(i int) bool {
+		return lh[i].Height > height
+	})
+	if i == 0 {
+		return -1
+	}
+	return lh[i-1].Leader
+}
+
+// ProposerMismatchError reports the first block not proposed by the scheduled leader
+type ProposerMismatchError struct {
+	Height   uint64
+	Expected int
+	Actual   int // -1 if proposer is not a validator
+}
+
+func (e *ProposerMismatchError) Error() string {
+	return fmt.Sprintf("%v at height %d, expected leader %d, got %d",
+		ErrUnexpectedProposer, e.Height, e.Expected, e.Actual)
+}
+
+func (e *ProposerMismatchError) Unwrap() error {
+	return ErrUnexpectedProposer
+}
+
+// VerifyProposerSchedule checks that each block is proposed by the scheduled leader of its height.
+// Genesis block and heights with unknown leader are skipped.
+// It returns *ProposerMismatchError for the first mismatched block.
+func VerifyProposerSchedule(blocks []*Block, vs ValidatorStore, schedule LeaderSchedule) error {
+	for _, blk := range blocks {
+		if blk == nil {
+			return ErrNilBlock
+		}
+		if blk.IsGenesis() {
+			continue
+		}
+		expected := schedule.LeaderFor(blk.Height())
+		if expected < 0 {
+			continue
+		}
+		vsh := validatorsAt(vs, blk.Height())
+		actual := -1
+		if vsh.IsValidator(blk.Proposer()) {
+			actual = vsh.GetValidatorIndex(blk.Proposer())
+		}
+		if actual != expected {
+			return &ProposerMismatchError{blk.Height(), expected, actual}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package core
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLeaderHistory_LeaderFor(t *testing.T) {
+	assert := assert.New(t)
+
+	lh := LeaderHistory{{Height: 3, Leader: 1}, {Height: 6, Leader: 2}}
+	assert.Equal(-1, lh.LeaderFor(2))
+	assert.Equal(1, lh.LeaderFor(3))
+	assert.Equal(1, lh.LeaderFor(5))
+	assert.Equal(2, lh.LeaderFor(6))
+	assert.Equal(2, lh.LeaderFor(100))
+	assert.Equal(-1, LeaderHistory(nil).LeaderFor(1))
+}
+
+func TestVerifyProposerSchedule(t *testing.T) {
+	assert := assert.New(t)
+
+	privs := make([]*PrivateKey, 3)
+	keys := make([]*PublicKey, 3)
+	for i := range privs {
+		privs[i] = GenerateKey(nil)
+		keys[i] = privs[i].PublicKey()
+	}
+	vs := NewValidatorStore(keys)
+	proposers := []*PrivateKey{nil, privs[0], privs[0], privs[1], privs[1], privs[2]}
+	blocks := make([]*Block, len(proposers))
+	blocks[0] = NewGenesisBlock([]byte("chain"), nil, nil)
+	for h := 1; h < len(blocks); h++ {
+		blocks[h] = NewBlock().SetHeight(uint64(h)).Sign(proposers[h])
+	}
+	lh := LeaderHistory{{Height: 2, Leader: 0}, {Height: 3, Leader: 1}, {Height: 5, Leader: 2}}
+	assert.NoError(VerifyProposerSchedule(blocks, vs, lh), "height 1 is unknown")
+
+	lh = LeaderHistory{{Height: 0, Leader: 0}, {Height: 4, Leader: 1}}
+	err := VerifyProposerSchedule(blocks, vs, lh)
+	assert.ErrorIs(err, ErrUnexpectedProposer)
+	var mismatch *ProposerMismatchError
+	assert.True(errors.As(err, &mismatch))
+	assert.Equal(&ProposerMismatchError{Height: 3, Expected: 0, Actual: 1}, mismatch)
+
+	outsider := NewBlock().SetHeight(6).Sign(GenerateKey(nil))
+	err = VerifyProposerSchedule([]*Block{outsider}, vs, lh)
+	assert.True(errors.As(err, &mismatch))
+	assert.Equal(-1, mismatch.Actual)
+
+	assert.ErrorIs(VerifyProposerSchedule([]*Block{nil}, vs, lh), ErrNilBlock)
+}
@@ -4,9 +4,13 @@
 package core
 
 import (
+	"bytes"
 	"errors"
+	"sort"
+	"sync"
 
 	"github.com/aungmawjj/juria-blockchain/core/core_pb"
+	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -18,12 +22,24 @@ var (
 	ErrDuplicateSig     = errors.New("duplicate signature in qc")
 	ErrInvalidSig       = errors.New("invalid signature")
 	ErrInvalidValidator = errors.New("voter is not a validator")
+	ErrInvalidBitmap    = errors.New("invalid signer bitmap")
 )
 
+// QCBuildOptions adds optional parameters to build qc
+type QCBuildOptions struct {
+	// if set and all votes are bls, qc is built with an aggregate signature
+	// and a signer bitmap over the validators
+	VldStore ValidatorStore
+}
+
 // QuorumCert type
 type QuorumCert struct {
-	data *core_pb.QuorumCert
-	sigs sigList
+	data    *core_pb.QuorumCert
+	sigs    sigList
+	signers []*PublicKey
+
+	// aggregate qc resolves signers on Validate, which may run concurrently with Signers
+	mtxSigners sync.RWMutex
 }
 
 func NewQuorumCert() *QuorumCert {
@@ -32,13 +48,15 @@ func NewQuorumCert() *QuorumCert {
 	}
 }
 
-// Validate godoc
+// Validate checks that qc is signed by distinct validators holding majority stake.
+// The height of qc is not checked, see CheckBlock.
 func (qc *QuorumCert) Validate(vs ValidatorStore) error {
 	if qc.data == nil {
 		return ErrNilQC
 	}
-	if len(qc.sigs) < vs.MajorityCount() {
-		return ErrNotEnoughSig
+	vs = validatorsAt(vs, qc.data.BlockHeight)
+	if qc.IsAggregate() {
+		return qc.validateAggregate(vs)
 	}
 	if qc.sigs.hasDuplicate() {
 		return ErrDuplicateSig
@@ -46,12 +64,78 @@ func (qc *QuorumCert) Validate(vs ValidatorStore) error {
 	if qc.sigs.hasInvalidValidator(vs) {
 		return ErrInvalidValidator
 	}
-	if qc.sigs.hasInvalidSig(qc.data.BlockHash) {
+	if !HasMajorityStake(vs, qc.sigs.totalStake(vs)) {
+		return ErrNotEnoughSig
+	}
+	if qc.sigs.hasInvalidSig(voteDigest(qc.data.BlockHash, qc.data.BlockHeight)) {
 		return ErrInvalidSig
 	}
 	return nil
 }
 
+// CheckBlock checks that qc certifies blk at the height of blk, like Vote.CheckBlock
+func (qc *QuorumCert) CheckBlock(blk *Block) error {
+	if qc.data == nil {
+		return ErrNilQC
+	}
+	return checkVotedBlock(qc.data.BlockHash, qc.data.BlockHeight, blk)
+}
+
+func (qc *QuorumCert) validateAggregate(vs ValidatorStore) error {
+	if len(qc.data.Signatures) != 0 {
+		return ErrInvalidBitmap
+	}
+	signers, err := qc.resolveSigners(vs)
+	if err != nil {
+		return err
+	}
+	var stake uint64
+	for _, signer := range signers {
+		if !signer.IsBLS() {
+			return ErrInvalidValidator
+		}
+		stake += vs.GetStake(signer)
+	}
+	if !HasMajorityStake(vs, stake) {
+		return ErrNotEnoughSig
+	}
+	digest := voteDigest(qc.data.BlockHash, qc.data.BlockHeight)
+	if !blsVerify(blsAggregateKeys(signers), digest, qc.data.AggSig) {
+		return ErrInvalidSig
+	}
+	qc.setSigners(signers)
+	return nil
+}
+
+// setSigners sets the signers resolved from bitmap if not known yet
+func (qc *QuorumCert) setSigners(signers []*PublicKey) {
+	qc.mtxSigners.Lock()
+	defer qc.mtxSigners.Unlock()
+	if qc.signers == nil {
+		qc.signers = signers
+	}
+}
+
+// resolveSigners returns validators marked in signer bitmap
+func (qc *QuorumCert) resolveSigners(vs ValidatorStore) ([]*PublicKey, error) {
+	count := vs.ValidatorCount()
+	bitmap := qc.data.SignerBitmap
+	if len(bitmap) != (count+7)/8 {
+		return nil, ErrInvalidBitmap
+	}
+	signers := make([]*PublicKey, 0, count)
+	for i := 0; i < len(bitmap)*8; i++ {
+		if bitmap[i/8]&(1<<(i%8)) == 0 {
+			continue
+		}
+		if i >= count {
+			return nil, ErrInvalidBitmap
+		}
+		signers = append(signers, vs.GetValidator(i))
+	}
+	return signers, nil
+}
+
 func (qc *QuorumCert) setData(data *core_pb.QuorumCert) error {
 	qc.data = data
 	sigs, err := newSigList(qc.data.Signatures)
@@ -59,28 +143,114 @@ func (qc *QuorumCert) setData(data *core_pb.QuorumCert) error {
 		return err
 	}
 	qc.sigs = sigs
+	qc.signers = nil
+	if !qc.IsAggregate() {
+		qc.signers = sigs.signers()
+	}
 	return nil
 }
 
-func (qc *QuorumCert) Build(votes []*Vote) *QuorumCert {
+// Build creates qc from votes.
+// Signatures are sorted by signer public key so that qc is canonical for the same set of votes.
+// With opts.VldStore, bls votes are aggregated into one signature.
+func (qc *QuorumCert) Build(votes []*Vote, opts ...QCBuildOptions) *QuorumCert {
+	if len(opts) > 0 && opts[0].VldStore != nil && allBLSVotes(votes) {
+		vs := validatorsAt(opts[0].VldStore, votes[0].BlockHeight())
+		if qc.buildAggregate(votes, vs) == nil {
+			return qc
+		}
+	}
+	votes = sortVotesBySigner(votes)
 	qc.data.Signatures = make([]*core_pb.Signature, len(votes))
 	qc.sigs = make(sigList, len(votes))
 	for i, vote := range votes {
 		if qc.data.BlockHash == nil {
 			qc.data.BlockHash = vote.data.BlockHash
+			qc.data.BlockHeight = vote.data.BlockHeight
 		}
+		qc.sigs[i] = vote.Signature()
 		qc.data.Signatures[i] = vote.data.Signature
-		qc.sigs[i] = &Signature{
-			data:   vote.data.Signature,
-			pubKey: vote.voter,
-		}
 	}
+	qc.signers = qc.sigs.signers()
 	return qc
 }
 
-func (qc *QuorumCert) BlockHash() []byte        { return qc.data.BlockHash }
+// buildAggregate leaves qc unchanged on error
+func (qc *QuorumCert) buildAggregate(votes []*Vote, vs ValidatorStore) error {
+	bitmap := make([]byte, (vs.ValidatorCount()+7)/8)
+	sigs := make([][]byte, 0, len(votes))
+	var blockHash []byte
+	var blockHeight uint64
+	for _, vote := range votes {
+		if !vs.IsValidator(vote.Voter()) {
+			return ErrInvalidValidator
+		}
+		idx := vs.GetValidatorIndex(vote.Voter())
+		if bitmap[idx/8]&(1<<(idx%8)) != 0 {
+			continue // duplicate vote
+		}
+		bitmap[idx/8] |= 1 << (idx % 8)
+		sigs = append(sigs, vote.data.Signature.Value)
+		if blockHash == nil {
+			blockHash = vote.data.BlockHash
+			blockHeight = vote.data.BlockHeight
+		}
+	}
+	aggSig, err := blsAggregateSigs(sigs)
+	if err != nil {
+		return err
+	}
+	qc.data.BlockHash = blockHash
+	qc.data.BlockHeight = blockHeight
+	qc.data.Type = core_pb.QCType_QC_BLS_AGGREGATE
+	qc.data.AggSig = aggSig
+	qc.data.SignerBitmap = bitmap
+	qc.sigs = nil
+	qc.signers, _ = qc.resolveSigners(vs)
+	return nil
+}
+
+func allBLSVotes(votes []*Vote) bool {
+	if len(votes) == 0 {
+		return false
+	}
+	for _, vote := range votes {
+		if vote.Voter() == nil || !vote.Voter().IsBLS() {
+			return false
+		}
+	}
+	return true
+}
+
+func sortVotesBySigner(votes []*Vote) []*Vote {
+	sorted := make([]*Vote, len(votes))
+	copy(sorted, votes)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return bytes.Compare(
+			sorted[i].data.Signature.GetPubKey(),
+			sorted[j].data.Signature.GetPubKey(),
+		) < 0
+	})
+	return sorted
+}
+
+func (qc *QuorumCert) BlockHash() []byte        { return copyBytes(qc.data.BlockHash) }
+func (qc *QuorumCert) BlockHeight() uint64      { return qc.data.BlockHeight }
 func (qc *QuorumCert) Signatures() []*Signature { return qc.sigs }
 
+// IsAggregate returns true for qc with aggregate bls signature
+func (qc *QuorumCert) IsAggregate() bool {
+	return qc.data.Type == core_pb.QCType_QC_BLS_AGGREGATE
+}
+
+// Signers returns distinct public keys of signatures in qc, signatures are not verified.
+// For aggregate qc, signers from bitmap are known after Build or Validate.
+func (qc *QuorumCert) Signers() []*PublicKey {
+	qc.mtxSigners.RLock()
+	defer qc.mtxSigners.RUnlock()
+	return qc.signers
+}
+
 // Marshal encodes quorum cert as bytes
 func (qc *QuorumCert) Marshal() ([]byte, error) {
 	return proto.Marshal(qc.data)
@@ -94,3 +264,15 @@ func (qc *QuorumCert) Unmarshal(b []byte) error {
 	}
 	return qc.setData(data)
 }
+
+func (qc *QuorumCert) MarshalJSON() ([]byte, error) {
+	return protojson.Marshal(qc.data)
+}
+
+func (qc *QuorumCert) UnmarshalJSON(b []byte) error {
+	data := new(core_pb.QuorumCert)
+	if err := protojson.Unmarshal(b, data); err != nil {
+		return err
+	}
+	return qc.setData(data)
+}
@@ -4,6 +4,9 @@
 package core
 
 import (
+	"bytes"
+	"fmt"
+	"math/rand"
 	"testing"
 
 	"github.com/aungmawjj/juria-blockchain/core/core_pb"
@@ -18,14 +21,17 @@ func TestQuorumCert(t *testing.T) {
 	vs := new(MockValidatorStore)
 	vs.On("ValidatorCount").Return(4)
 	vs.On("MajorityCount").Return(3)
+	vs.On("MajorityStake").Return(uint64(3))
 
 	for i := range privKeys {
 		privKeys[i] = GenerateKey(nil)
 		if i != 4 {
 			vs.On("IsValidator", privKeys[i].pubKey).Return(true)
+			vs.On("GetStake", privKeys[i].pubKey).Return(uint64(1))
 		}
 	}
 	vs.On("IsValidator", mock.Anything).Return(false)
+	vs.On("GetStake", mock.Anything).Return(uint64(0))
 
 	blockHash := []byte{1}
 	votes := make([]*Vote, len(privKeys))
@@ -108,3 +114,243 @@ func TestQuorumCert(t *testing.T) {
 		})
 	}
 }
+
+func TestQuorumCert_WeightedStake(t *testing.T) {
+	assert := assert.New(t)
+
+	privKeys := make([]*PrivateKey, 4)
+	pubKeys := make([]*PublicKey, len(privKeys))
+	for i := range privKeys {
+		privKeys[i] = GenerateKey(nil)
+		pubKeys[i] = privKeys[i].PublicKey()
+	}
+	// total 13, majority 9
+	vs := NewWeightedValidatorStore(pubKeys, []uint64{10, 1, 1, 1})
+
+	blockHash := []byte{1}
+	votes := make([]*Vote, len(privKeys))
+	for i, priv := range privKeys {
+		votes[i] = NewVote()
+		votes[i].setData(&core_pb.Vote{
+			BlockHash: blockHash,
+			Signature: priv.Sign(blockHash).data,
+		})
+	}
+
+	// minority of validators holding majority stake
+	qc := NewQuorumCert().Build([]*Vote{votes[0]})
+	assert.NoError(qc.Validate(vs))
+
+	// majority of validators holding minority stake
+	qc = NewQuorumCert().Build([]*Vote{votes[1], votes[2], votes[3]})
+	assert.ErrorIs(qc.Validate(vs), ErrNotEnoughSig)
+
+	// duplicate signatures can't add up stake
+	qc = NewQuorumCert().Build([]*Vote{votes[1], votes[1], votes[1], votes[2]})
+	assert.ErrorIs(qc.Validate(vs), ErrDuplicateSig)
+
+	qc = NewQuorumCert().Build([]*Vote{votes[0], votes[1]})
+	vs = NewWeightedValidatorStore(pubKeys[1:], []uint64{1, 1, 1})
+	assert.ErrorIs(qc.Validate(vs), ErrInvalidValidator)
+}
+
+func TestQuorumCert_ZeroStake(t *testing.T) {
+	assert := assert.New(t)
+
+	priv := GenerateKey(nil)
+	vs := NewWeightedValidatorStore([]*PublicKey{priv.PublicKey()}, nil)
+	assert.False(HasMajorityStake(vs, 0))
+
+	// empty qc must not reach the zero majority stake
+	qc := NewQuorumCert().Build(nil)
+	assert.ErrorIs(qc.Validate(vs), ErrNotEnoughSig)
+
+	vote := NewVote()
+	vote.setData(&core_pb.Vote{BlockHash: []byte{1}, Signature: priv.Sign([]byte{1}).data})
+	assert.False(HasMajorityVotes(vs, []*Vote{vote}))
+}
+
+func TestQuorumCert_BuildCanonical(t *testing.T) {
+	assert := assert.New(t)
+
+	blk := NewBlock().Sign(GenerateKey(nil))
+	votes := make([]*Vote, 7)
+	for i := range votes {
+		votes[i] = blk.Vote(GenerateKey(nil))
+	}
+	expected, err := NewQuorumCert().Build(votes).Marshal()
+	assert.NoError(err)
+
+	for i := 0; i < 10; i++ {
+		shuffled := make([]*Vote, len(votes))
+		copy(shuffled, votes)
+		rand.Shuffle(len(shuffled), func(i, j int) {
+			shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+		})
+		b, err := NewQuorumCert().Build(shuffled).Marshal()
+		assert.NoError(err)
+		assert.Equal(expected, b)
+	}
+
+	qc := NewQuorumCert().Build(votes)
+	for i := 1; i < len(qc.Signatures()); i++ {
+		assert.Equal(-1, bytes.Compare(
+			qc.Signatures()[i-1].PublicKey().Bytes(),
+			qc.Signatures()[i].PublicKey().Bytes(),
+		))
+	}
+}
+
+func TestQuorumCert_Signers(t *testing.T) {
+	assert := assert.New(t)
+
+	blk := NewBlock().Sign(GenerateKey(nil))
+	voters := make([]*PrivateKey, 4)
+	votes := make([]*Vote, len(voters))
+	for i := range voters {
+		voters[i] = GenerateKey(nil)
+		votes[i] = blk.Vote(voters[i])
+	}
+	expected := make([]string, len(voters))
+	for i, v := range voters {
+		expected[i] = v.PublicKey().String()
+	}
+	signerKeys := func(qc *QuorumCert) []string {
+		ret := make([]string, len(qc.Signers()))
+		for i, s := range qc.Signers() {
+			ret[i] = s.String()
+		}
+		return ret
+	}
+
+	qc := NewQuorumCert().Build(votes)
+	assert.ElementsMatch(expected, signerKeys(qc))
+
+	b, _ := qc.Marshal()
+	qc1 := NewQuorumCert()
+	assert.NoError(qc1.Unmarshal(b))
+	assert.Equal(signerKeys(qc), signerKeys(qc1))
+
+	// duplicate votes are listed once
+	qcDup := NewQuorumCert().Build(append(votes, blk.Vote(voters[0])))
+	assert.ElementsMatch(expected, signerKeys(qcDup))
+
+	assert.Empty(NewQuorumCert().Signers())
+}
+
+func newBLSTestVotes(n int) ([]*Vote, ValidatorStore) {
+	blk := NewBlock().Sign(GenerateKey(nil))
+	votes := make([]*Vote, n)
+	pubKeys := make([]*PublicKey, n)
+	for i := range votes {
+		priv := GenerateBLSKey(nil)
+		votes[i] = blk.Vote(priv)
+		pubKeys[i] = priv.PublicKey()
+	}
+	return votes, NewValidatorStore(pubKeys)
+}
+
+func TestQuorumCert_BLSAggregate(t *testing.T) {
+	assert := assert.New(t)
+
+	votes, vs := newBLSTestVotes(10) // majority 7
+	opts := QCBuildOptions{VldStore: vs}
+
+	qc := NewQuorumCert().Build(votes[:7], opts)
+	assert.True(qc.IsAggregate())
+	assert.Empty(qc.Signatures())
+	assert.Len(qc.data.AggSig, BLSSignatureSize)
+	assert.Len(qc.data.SignerBitmap, 2)
+	assert.NoError(qc.Validate(vs))
+	assert.Len(qc.Signers(), 7)
+
+	b, err := qc.Marshal()
+	assert.NoError(err)
+	qc1 := NewQuorumCert()
+	assert.NoError(qc1.Unmarshal(b))
+	assert.True(qc1.IsAggregate())
+	assert.Nil(qc1.Signers(), "signers are unknown before validate")
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		qc1.Signers() // concurrent with validate
+	}()
+	assert.NoError(qc1.Validate(vs))
+	<-done
+	assert.Equal(qc.Signers(), qc1.Signers())
+
+	// duplicate votes are aggregated once
+	qc = NewQuorumCert().Build(append(votes[:7], votes[0]), opts)
+	assert.NoError(qc.Validate(vs))
+
+	qc = NewQuorumCert().Build(votes[:6], opts)
+	assert.ErrorIs(qc.Validate(vs), ErrNotEnoughSig)
+
+	// bitmap claims a validator who didn't sign
+	qc = NewQuorumCert().Build(votes[:7], opts)
+	qc.data.SignerBitmap[1] |= 1 << 1
+	assert.ErrorIs(qc.Validate(vs), ErrInvalidSig)
+
+	qc = NewQuorumCert().Build(votes[:7], opts)
+	qc.data.SignerBitmap[1] |= 1 << 7 // out of validator range
+	assert.ErrorIs(qc.Validate(vs), ErrInvalidBitmap)
+
+	qc = NewQuorumCert().Build(votes[:7], opts)
+	qc.data.SignerBitmap = qc.data.SignerBitmap[:1]
+	assert.ErrorIs(qc.Validate(vs), ErrInvalidBitmap)
+
+	qc = NewQuorumCert().Build(votes[:7], opts)
+	qc.data.BlockHash = []byte("other block")
+	assert.ErrorIs(qc.Validate(vs), ErrInvalidSig)
+
+	// without validator store, bls votes are kept as signature list
+	qc = NewQuorumCert().Build(votes[:7])
+	assert.False(qc.IsAggregate())
+	assert.NoError(qc.Validate(vs))
+
+	// ed25519 votes are not aggregated
+	blk := NewBlock().Sign(GenerateKey(nil))
+	privKeys := []*PrivateKey{GenerateKey(nil), GenerateKey(nil)}
+	edVotes := []*Vote{blk.Vote(privKeys[0]), blk.Vote(privKeys[1])}
+	edVs := NewValidatorStore([]*PublicKey{privKeys[0].PublicKey(), privKeys[1].PublicKey()})
+	qc = NewQuorumCert().Build(edVotes, QCBuildOptions{VldStore: edVs})
+	assert.False(qc.IsAggregate())
+	assert.NoError(qc.Validate(edVs))
+}
+
+// compares qc size and verification time of signature list and bls aggregate
+func BenchmarkQuorumCert_Validate(b *testing.B) {
+	const n = 64
+	edVotes := make([]*Vote, n)
+	edKeys := make([]*PublicKey, n)
+	blk := NewBlock().Sign(GenerateKey(nil))
+	for i := range edVotes {
+		priv := GenerateKey(nil)
+		edVotes[i] = blk.Vote(priv)
+		edKeys[i] = priv.PublicKey()
+	}
+	edVs := NewValidatorStore(edKeys)
+	blsVotes, blsVs := newBLSTestVotes(n)
+
+	tests := []struct {
+		name string
+		qc   *QuorumCert
+		vs   ValidatorStore
+	}{
+		{"ed25519", NewQuorumCert().Build(edVotes), edVs},
+		{"bls", NewQuorumCert().Build(blsVotes, QCBuildOptions{VldStore: blsVs}), blsVs},
+	}
+	for _, tt := range tests {
+		b.Run(fmt.Sprintf("%s-%d", tt.name, n), func(b *testing.B) {
+			data, _ := tt.qc.Marshal()
+			for i := 0; i < b.N; i++ {
+				qc := NewQuorumCert()
+				qc.Unmarshal(data)
+				if err := qc.Validate(tt.vs); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(len(data)), "qc-bytes")
+		})
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package core
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/binary"
+	"sync"
+)
+
+// sigCache is a bounded lru set of verified signatures.
+// An entry is keyed by the hash of public key, message and signature value,
+// so that a cached entry only matches the exact signature which was verified.
+type sigCache struct {
+	mtx     sync.Mutex
+	size    int
+	entries map[[sha256.Size]byte]*list.Element
+	order   *list.List // front is most recently used
+}
+
+func newSigCache(size int) *sigCache {
+	return &sigCache{
+		size:    size,
+		entries: make(map[[sha256.Size]byte]*list.Element, size),
+		order:   list.New(),
+	}
+}
+
+func sigCacheKey(pubKey, msg, sig []byte) [sha256.Size]byte {
+	h := sha256.New()
+	for _, b := range [][]byte{pubKey, msg, sig} {
+		var l [4]byte
+		binary.BigEndian.PutUint32(l[:], uint32(len(b)))
+		h.Write(l[:])
+		h.Write(b)
+	}
+	var key [sha256.Size]byte
+	h.Sum(key[:0])
+	return key
+}
+
+func (c *sigCache) has(key [sha256.Size]byte) bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	e, ok := c.entries[key]
+	if ok {
+		c.order.MoveToFront(e)
+	}
+	return ok
+}
+
+func (c *sigCache) add(key [sha256.Size]byte) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if e, ok := c.entries[key]; ok {
+		c.order.MoveToFront(e)
+		return
+	}
+	c.entries[key] = c.order.PushFront(key)
+	for c.order.Len() > c.size {
+		last := c.order.Back()
+		c.order.Remove(last)
+		delete(c.entries, last.Value.([sha256.Size]byte))
+	}
+}
+
+func (c *sigCache) len() int {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.order.Len()
+}
+
+var (
+	verifiedSigs    *sigCache
+	mtxVerifiedSigs sync.RWMutex
+)
+
+// SetSignatureCacheSize sets the maximum entries of verified signature cache used by VerifyCached.
+// The cache is disabled if size is zero.
+func SetSignatureCacheSize(size int) {
+	mtxVerifiedSigs.Lock()
+	defer mtxVerifiedSigs.Unlock()
+	verifiedSigs = nil
+	if size > 0 {
+		verifiedSigs = newSigCache(size)
+	}
+}
+
+func getSigCache() *sigCache {
+	mtxVerifiedSigs.RLock()
+	defer mtxVerifiedSigs.RUnlock()
+	return verifiedSigs
+}
+
+// VerifyCached verifies the signature and memoizes successful verifications.
+// It is the same as Verify if the signature cache is disabled.
+func (sig *Signature) VerifyCached(msg []byte) bool {
+	cache := getSigCache()
+	if cache == nil {
+		return sig.Verify(msg)
+	}
+	key := sigCacheKey(sig.pubKey.key, msg, sig.data.Value)
+	if cache.has(key) {
+		return true
+	}
+	if !sig.Verify(msg) {
+		return false
+	}
+	cache.add(key)
+	return true
+}
@@ -0,0 +1,106 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package core
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSigCache_Evict(t *testing.T) {
+	assert := assert.New(t)
+
+	c := newSigCache(2)
+	k1 := sigCacheKey([]byte{1}, []byte{1}, []byte{1})
+	k2 := sigCacheKey([]byte{2}, []byte{1}, []byte{1})
+	k3 := sigCacheKey([]byte{3}, []byte{1}, []byte{1})
+	c.add(k1)
+	c.add(k2)
+	assert.True(c.has(k1)) // k2 becomes least recently used
+	c.add(k3)
+	assert.Equal(2, c.len())
+	assert.True(c.has(k1))
+	assert.False(c.has(k2))
+	assert.True(c.has(k3))
+
+	// length prefix separates fields
+	assert.NotEqual(sigCacheKey([]byte{1, 2}, []byte{3}, nil), sigCacheKey([]byte{1}, []byte{2, 3}, nil))
+}
+
+func TestSignature_VerifyCached(t *testing.T) {
+	assert := assert.New(t)
+
+	SetSignatureCacheSize(10)
+	defer SetSignatureCacheSize(0)
+
+	priv := GenerateKey(nil)
+	msg := []byte("message")
+	sig := priv.Sign(msg)
+	assert.True(sig.VerifyCached(msg))
+	assert.Equal(1, getSigCache().len())
+	assert.True(sig.VerifyCached(msg), "cache hit")
+
+	invalid := priv.Sign(msg)
+	invalid.data.Value = append([]byte{}, invalid.data.Value...)
+	invalid.data.Value[0] ^= 1
+	assert.False(invalid.VerifyCached(msg))
+	assert.False(sig.VerifyCached([]byte("other")))
+	assert.Equal(1, getSigCache().len(), "failed verifications are not cached")
+
+	other := GenerateKey(nil)
+	forged := &Signature{data: sig.data, pubKey: other.PublicKey()}
+	assert.False(forged.VerifyCached(msg), "same value with other key")
+}
+
+func TestSignature_VerifyCachedPoisoned(t *testing.T) {
+	assert := assert.New(t)
+
+	SetSignatureCacheSize(10)
+	defer SetSignatureCacheSize(0)
+
+	priv := GenerateKey(nil)
+	msg := []byte("message")
+	invalid := priv.Sign(msg)
+	invalid.data.Value = make([]byte, len(invalid.data.Value))
+
+	// entries which would match if the cache were keyed by public key and digest only
+	cache := getSigCache()
+	cache.add(sigCacheKey(priv.PublicKey().Bytes(), msg, nil))
+	cache.add(sigCacheKey(priv.PublicKey().Bytes(), msg, priv.Sign(msg).data.Value))
+	assert.False(invalid.VerifyCached(msg))
+
+	// qc with the invalid vote is rejected although a valid vote of the voter is cached
+	vs := NewValidatorStore([]*PublicKey{priv.PublicKey()})
+	blk := NewBlock().SetHeight(1).Sign(priv)
+	vote := blk.Vote(priv)
+	assert.NoError(vote.Validate(vs))
+	vote.data.Signature.Value = make([]byte, len(vote.data.Signature.Value))
+	qc := NewQuorumCert().Build([]*Vote{vote})
+	assert.ErrorIs(qc.Validate(vs), ErrInvalidSig)
+}
+
+func TestSignature_VerifyCachedConcurrent(t *testing.T) {
+	SetSignatureCacheSize(8)
+	defer SetSignatureCacheSize(0)
+
+	msg := []byte("message")
+	sigs := make([]*Signature, 20)
+	for i := range sigs {
+		sigs[i] = GenerateKey(nil).Sign(msg)
+	}
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				assert.True(t, sigs[i%len(sigs)].VerifyCached(msg))
+			}
+		}()
+	}
+	wg.Wait()
+	assert.LessOrEqual(t, getSigCache().len(), 8)
+}
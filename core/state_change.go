@@ -4,10 +4,24 @@
 package core
 
 import (
+	"math/big"
+
 	"github.com/aungmawjj/juria-blockchain/core/core_pb"
+	"golang.org/x/crypto/sha3"
 	"google.golang.org/protobuf/proto"
 )
 
+// StateKey returns the stored key of key in the namespace of codeAddr,
+// sha3-256 of codeAddr followed by key, so that chaincodes can't write each other's keys.
+// Keys without code address are system keys, stored as they are.
+func StateKey(codeAddr, key []byte) []byte {
+	if len(codeAddr) == 0 {
+		return key
+	}
+	h := sha3.Sum256(codeAddr)
+	return append(h[:], key...)
+}
+
 type StateChange struct {
 	data *core_pb.StateChange
 }
@@ -18,17 +32,49 @@ func NewStateChange() *StateChange {
 	}
 }
 
+func (sc *StateChange) CodeAddr() []byte      { return sc.data.CodeAddr }
 func (sc *StateChange) Key() []byte           { return sc.data.Key }
 func (sc *StateChange) Value() []byte         { return sc.data.Value }
 func (sc *StateChange) PrevValue() []byte     { return sc.data.PrevValue }
 func (sc *StateChange) TreeIndex() []byte     { return sc.data.TreeIndex }
 func (sc *StateChange) PrevTreeIndex() []byte { return sc.data.PrevTreeIndex }
+func (sc *StateChange) Deleted() bool         { return sc.data.Deleted }
+
+// StateKey returns the stored key of the change
+func (sc *StateChange) StateKey() []byte { return StateKey(sc.data.CodeAddr, sc.data.Key) }
+
+// IsNewKey returns true if the key has no tree index before the change
+func (sc *StateChange) IsNewKey() bool { return sc.data.PrevTreeIndex == nil }
+
+// LeafIndex returns tree index as merkle leaf index
+func (sc *StateChange) LeafIndex() *big.Int {
+	return big.NewInt(0).SetBytes(sc.data.TreeIndex)
+}
+
+// SetLeafIndex sets tree index from merkle leaf index, zero index is encoded as []byte{0}
+func (sc *StateChange) SetLeafIndex(idx *big.Int) *StateChange {
+	idxB := idx.Bytes()
+	if len(idxB) == 0 {
+		idxB = []byte{0}
+	}
+	return sc.SetTreeIndex(idxB)
+}
+
+// KeepTreeIndex reuses the previous tree index of the existing key
+func (sc *StateChange) KeepTreeIndex() *StateChange {
+	return sc.SetTreeIndex(sc.data.PrevTreeIndex)
+}
 
 func (sc *StateChange) setData(val *core_pb.StateChange) error {
 	sc.data = val
 	return nil
 }
 
+func (sc *StateChange) SetCodeAddr(val []byte) *StateChange {
+	sc.data.CodeAddr = val
+	return sc
+}
+
 func (sc *StateChange) SetKey(val []byte) *StateChange {
 	sc.data.Key = val
 	return sc
@@ -44,6 +90,12 @@ func (sc *StateChange) SetPrevValue(val []byte) *StateChange {
 	return sc
 }
 
+// SetDeleted marks the key deleted, the value is ignored
+func (sc *StateChange) SetDeleted(val bool) *StateChange {
+	sc.data.Deleted = val
+	return sc
+}
+
 func (sc *StateChange) SetTreeIndex(val []byte) *StateChange {
 	sc.data.TreeIndex = val
 	return sc
@@ -4,6 +4,7 @@
 package core
 
 import (
+	"math/big"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -13,6 +14,7 @@ func TestStateChange(t *testing.T) {
 	assert := assert.New(t)
 
 	sc := NewStateChange().
+		SetCodeAddr([]byte("code")).
 		SetKey([]byte("key")).
 		SetValue([]byte("value")).
 		SetPrevValue([]byte("prevValue")).
@@ -26,9 +28,48 @@ func TestStateChange(t *testing.T) {
 	err = sc.Unmarshal(b)
 	assert.NoError(err)
 
+	assert.Equal([]byte("code"), sc.CodeAddr())
 	assert.Equal([]byte("key"), sc.Key())
 	assert.Equal([]byte("value"), sc.Value())
 	assert.Equal([]byte("prevValue"), sc.PrevValue())
 	assert.Equal([]byte{1}, sc.TreeIndex())
 	assert.Nil(sc.PrevTreeIndex())
+	assert.False(sc.Deleted())
+
+	b, err = NewStateChange().SetKey([]byte("key")).SetDeleted(true).Marshal()
+	assert.NoError(err)
+	sc = NewStateChange()
+	assert.NoError(sc.Unmarshal(b))
+	assert.True(sc.Deleted())
+}
+
+func TestStateChange_LeafIndex(t *testing.T) {
+	assert := assert.New(t)
+
+	sc := NewStateChange()
+	assert.True(sc.IsNewKey())
+	sc.SetLeafIndex(big.NewInt(0))
+	assert.Equal([]byte{0}, sc.TreeIndex())
+	assert.EqualValues(0, sc.LeafIndex().Int64())
+
+	sc.SetLeafIndex(big.NewInt(300))
+	assert.Equal(big.NewInt(300).Bytes(), sc.TreeIndex())
+	assert.EqualValues(300, sc.LeafIndex().Int64())
+
+	sc.SetPrevTreeIndex([]byte{5})
+	assert.False(sc.IsNewKey())
+	assert.Equal([]byte{5}, sc.KeepTreeIndex().TreeIndex())
+}
+
+func TestStateKey(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal([]byte("key"), StateKey(nil, []byte("key")), "system key")
+	k1 := StateKey([]byte("code1"), []byte("key"))
+	k2 := StateKey([]byte("code2"), []byte("key"))
+	assert.Len(k1, 32+3)
+	assert.NotEqual(k1, k2)
+	// flat concatenation would collide
+	assert.NotEqual(StateKey([]byte("ab"), []byte("c")), StateKey([]byte("a"), []byte("bc")))
+	assert.Equal(k1, NewStateChange().SetCodeAddr([]byte("code1")).SetKey([]byte("key")).StateKey())
 }
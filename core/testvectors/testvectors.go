@@ -0,0 +1,126 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+// package testvectors builds core objects from fixed keys and inputs,
+// golden files pin their marshaled bytes and hashes to core.FormatVersion
+
+package testvectors
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+
+	"github.com/aungmawjj/juria-blockchain/core"
+)
+
+// Vector is the expected encoding of a core object
+type Vector struct {
+	Name  string `json:"name"`
+	Bytes string `json:"bytes"`          // hex of marshaled bytes
+	Hash  string `json:"hash,omitempty"` // hex of hash, empty for types without hash
+}
+
+// Golden is the content of a golden file
+type Golden struct {
+	FormatVersion int      `json:"formatVersion"`
+	Vectors       []Vector `json:"vectors"`
+}
+
+// Key returns a fixed private key derived from index
+func Key(index byte) *core.PrivateKey {
+	seed := make([]byte, ed25519.SeedSize)
+	for i := range seed {
+		seed[i] = index
+	}
+	priv, _ := core.NewPrivateKey(ed25519.NewKeyFromSeed(seed))
+	return priv
+}
+
+func fill(b byte, size int) []byte {
+	ret := make([]byte, size)
+	for i := range ret {
+		ret[i] = b
+	}
+	return ret
+}
+
+// Generate builds vectors with the current core implementation
+func Generate() (*Golden, error) {
+	tx := core.NewTransaction().
+		SetNonce(7).
+		SetCodeAddr(fill(1, 32)).
+		SetInput([]byte(`{"method":"transfer","value":10}`)).
+		SetExpiry(100).
+		Sign(Key(1))
+	txCoSigned := core.NewTransaction().
+		SetNonce(8).
+		SetCodeAddr(fill(1, 32)).
+		SetInput([]byte(`{"method":"mint"}`)).
+		SetCoSigners(Key(2).PublicKey()).
+		Sign(Key(1)).
+		AddCoSigner(Key(2))
+
+	parent := core.NewBlock().
+		SetParentHash(fill(2, 32)).
+		SetTimestamp(1600000000000000000).
+		Sign(Key(0))
+	votes := []*core.Vote{parent.ProposerVote(), parent.Vote(Key(1)), parent.Vote(Key(2))}
+	qc := core.NewQuorumCert().Build(votes)
+
+	blk := core.NewBlock().
+		SetHeight(10).
+		SetParentHash(parent.Hash()).
+		SetQuorumCert(qc).
+		SetExecHeight(8).
+		SetMerkleRoot(fill(3, 32)).
+		SetTimestamp(1600000001000000000).
+		SetTransactions([][]byte{tx.Hash(), txCoSigned.Hash()}).
+		Sign(Key(0))
+
+	bcm := core.NewBlockCommit().
+		SetHash(blk.Hash()).
+		SetOldBlockTxs([][]byte{txCoSigned.Hash()}).
+		SetLeafCount([]byte{2}).
+		SetMerkleRoot(fill(3, 32)).
+		SetElapsedExec(0.5).
+		SetElapsedMerkle(0.25).
+		SetStateChanges([]*core.StateChange{
+			core.NewStateChange().
+				SetKey([]byte("key")).
+				SetValue([]byte("value")).
+				SetPrevValue([]byte("prev")).
+				SetTreeIndex([]byte{1}).
+				SetPrevTreeIndex([]byte{1}),
+		})
+
+	items := []struct {
+		name string
+		m    marshaler
+		hash []byte
+	}{
+		{"transaction", tx, tx.Hash()},
+		{"transaction_cosigned", txCoSigned, txCoSigned.Hash()},
+		{"block_parent", parent, parent.Hash()},
+		{"vote", votes[1], nil},
+		{"quorum_cert", qc, nil},
+		{"block", blk, blk.Hash()},
+		{"block_commit", bcm, nil},
+	}
+	golden := &Golden{FormatVersion: core.FormatVersion}
+	for _, item := range items {
+		b, err := item.m.Marshal()
+		if err != nil {
+			return nil, err
+		}
+		golden.Vectors = append(golden.Vectors, Vector{
+			Name:  item.name,
+			Bytes: hex.EncodeToString(b),
+			Hash:  hex.EncodeToString(item.hash),
+		})
+	}
+	return golden, nil
+}
+
+type marshaler interface {
+	Marshal() ([]byte, error)
+}
@@ -0,0 +1,98 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package testvectors
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/aungmawjj/juria-blockchain/core"
+	"github.com/stretchr/testify/assert"
+)
+
+// after an intentional format change, bump core.FormatVersion and run
+// go test ./core/testvectors -update
+var update = flag.Bool("update", false, "regenerate golden files")
+
+var goldenFile = filepath.Join("testdata", "golden.json")
+
+func TestGolden(t *testing.T) {
+	assert := assert.New(t)
+
+	actual, err := Generate()
+	if !assert.NoError(err) {
+		return
+	}
+	if *update {
+		b, _ := json.MarshalIndent(actual, "", "  ")
+		assert.NoError(ioutil.WriteFile(goldenFile, append(b, '\n'), 0644))
+	}
+
+	b, err := ioutil.ReadFile(goldenFile)
+	if !assert.NoError(err) {
+		return
+	}
+	expected := new(Golden)
+	if !assert.NoError(json.Unmarshal(b, expected)) {
+		return
+	}
+	if expected.FormatVersion != core.FormatVersion {
+		t.Fatalf("golden format version %d, core.FormatVersion %d, regenerate with -update",
+			expected.FormatVersion, core.FormatVersion)
+	}
+	if !assert.Equal(len(expected.Vectors), len(actual.Vectors)) {
+		return
+	}
+	for i, want := range expected.Vectors {
+		got := actual.Vectors[i]
+		assert.Equal(want.Name, got.Name)
+		assert.Equal(want.Bytes, got.Bytes, "%s bytes changed, bump core.FormatVersion", want.Name)
+		assert.Equal(want.Hash, got.Hash, "%s hash changed, bump core.FormatVersion", want.Name)
+	}
+}
+
+// golden bytes must decode to objects producing the same hash
+func TestGolden_Decode(t *testing.T) {
+	b, err := ioutil.ReadFile(goldenFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden := new(Golden)
+	if err := json.Unmarshal(b, golden); err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range golden.Vectors {
+		t.Run(v.Name, func(t *testing.T) {
+			assert := assert.New(t)
+			data, err := hex.DecodeString(v.Bytes)
+			assert.NoError(err)
+
+			switch v.Name {
+			case "transaction", "transaction_cosigned":
+				tx := core.NewTransaction()
+				assert.NoError(tx.Unmarshal(data))
+				assert.NoError(tx.Validate())
+				assert.Equal(v.Hash, hex.EncodeToString(tx.Sum()))
+
+			case "block_parent", "block":
+				blk := core.NewBlock()
+				assert.NoError(blk.Unmarshal(data))
+				assert.Equal(v.Hash, hex.EncodeToString(blk.Sum()))
+
+			case "vote":
+				assert.NoError(core.NewVote().Unmarshal(data))
+
+			case "quorum_cert":
+				assert.NoError(core.NewQuorumCert().Unmarshal(data))
+
+			case "block_commit":
+				assert.NoError(core.NewBlockCommit().Unmarshal(data))
+			}
+		})
+	}
+}
@@ -5,12 +5,12 @@ package core
 
 import (
 	"bytes"
-	"encoding/binary"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"sync"
 
 	"github.com/aungmawjj/juria-blockchain/core/core_pb"
-	"golang.org/x/crypto/sha3"
 	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/proto"
 )
@@ -19,12 +19,41 @@ import (
 var (
 	ErrInvalidTxHash = errors.New("invalid tx hash")
 	ErrNilTx         = errors.New("nil tx")
+
+	ErrTxInputTooLarge = errors.New("tx input too large")
+	ErrInvalidCodeAddr = errors.New("invalid tx code address")
+	ErrNilSender       = errors.New("nil tx sender")
 )
 
+// CodeAddrSize is the size of a chaincode address, empty code address deploys a chaincode
+const CodeAddrSize = 32
+
+// TxLimits bounds tx fields checked by ValidateBasic
+type TxLimits struct {
+	// maximum input size in bytes, no limit if zero
+	MaxInputSize int
+}
+
+// DefaultTxLimits godoc
+var DefaultTxLimits = TxLimits{
+	MaxInputSize: 1 << 20, // 1 MB
+}
+
+// TxValidateOptions adds optional parameters to validate tx
+type TxValidateOptions struct {
+	// block height at which tx is validated, legacy tx hash is accepted below activation height
+	Height uint64
+}
+
 // Transaction type
 type Transaction struct {
-	data   *core_pb.Transaction
-	sender *PublicKey
+	data      *core_pb.Transaction
+	sender    *PublicKey
+	coSigners sigList
+
+	sum       []byte // cached Sum, reset by setters
+	sumDomain bool   // sum has domain tag
+	mtxSum    sync.Mutex
 }
 
 var _ json.Unmarshaler = (*Transaction)(nil)
@@ -36,23 +65,75 @@ func NewTransaction() *Transaction {
 	}
 }
 
-// Sum returns sha3 sum of transaction
+// Sum returns hash of transaction with the chain wide hash function,
+// prefixed with domain tag if domain separation is enabled for the chain.
+// The tag doesn't depend on the block height, which is not known when tx is signed.
+// The result is cached until transaction is modified by setters.
 func (tx *Transaction) Sum() []byte {
-	h := sha3.New256()
-	binary.Write(h, binary.BigEndian, tx.data.Nonce)
-	h.Write(tx.data.Sender)
-	h.Write(tx.data.CodeAddr)
-	h.Write(tx.data.Input)
-	binary.Write(h, binary.BigEndian, tx.data.Expiry)
-	return h.Sum(nil)
+	domain := GetDomainSeparation().Enabled
+
+	tx.mtxSum.Lock()
+	defer tx.mtxSum.Unlock()
+
+	if tx.sum == nil || tx.sumDomain != domain {
+		tx.sum = tx.sumDigest(domain)
+		tx.sumDomain = domain
+	}
+	return copyBytes(tx.sum)
 }
 
-// Validate transaction
-func (tx *Transaction) Validate() error {
+func (tx *Transaction) sumDigest(domain bool) []byte {
+	hs := getHasher()
+	if domain {
+		hs.write(domainTx)
+	}
+	hs.writeUint64(uint64(tx.data.Nonce))
+	hs.write(tx.data.Sender)
+	hs.write(tx.data.CodeAddr)
+	hs.write(tx.data.Input)
+	hs.writeUint64(tx.data.Expiry)
+	for _, sig := range tx.data.CoSigners { // no co-signers keeps the digest unchanged
+		hs.write(sig.GetPubKey())
+	}
+	return hs.sum()
+}
+
+func (tx *Transaction) resetSum() {
+	tx.mtxSum.Lock()
+	defer tx.mtxSum.Unlock()
+	tx.sum = nil
+}
+
+// ValidateBasic checks tx fields which don't need signature verification or state,
+// so that malformed txs are rejected before the expensive checks
+func (tx *Transaction) ValidateBasic(limits TxLimits) error {
+	if tx.data == nil {
+		return ErrNilTx
+	}
+	if limits.MaxInputSize > 0 && len(tx.data.Input) > limits.MaxInputSize {
+		return fmt.Errorf("%w, %d bytes, limit %d",
+			ErrTxInputTooLarge, len(tx.data.Input), limits.MaxInputSize)
+	}
+	if len(tx.data.CodeAddr) != 0 && len(tx.data.CodeAddr) != CodeAddrSize {
+		return fmt.Errorf("%w, %d bytes", ErrInvalidCodeAddr, len(tx.data.CodeAddr))
+	}
+	if len(tx.data.Sender) == 0 {
+		return ErrNilSender
+	}
+	return nil
+}
+
+// Validate transaction.
+// Tx hash without domain tag is accepted below the activation height given in opts.
+func (tx *Transaction) Validate(opts ...TxValidateOptions) error {
 	if tx.data == nil {
 		return ErrNilTx
 	}
-	if !bytes.Equal(tx.Sum(), tx.Hash()) {
+	var height uint64
+	for _, opt := range opts {
+		height = opt.Height
+	}
+	if !tx.validHash(height) {
 		return ErrInvalidTxHash
 	}
 	sig, err := newSignature(&core_pb.Signature{
@@ -65,51 +146,143 @@ func (tx *Transaction) Validate() error {
 	if !sig.Verify(tx.data.Hash) {
 		return ErrInvalidSig
 	}
+	return tx.validateCoSigners(sig)
+}
+
+func (tx *Transaction) validHash(height uint64) bool {
+	if bytes.Equal(tx.Sum(), tx.data.Hash) {
+		return true
+	}
+	// below activation height, tx hash of legacy clients is accepted too
+	ds := GetDomainSeparation()
+	return ds.Enabled && !ds.Active(height) &&
+		bytes.Equal(tx.sumDigest(false), tx.data.Hash)
+}
+
+func (tx *Transaction) validateCoSigners(senderSig *Signature) error {
+	if len(tx.coSigners) == 0 {
+		return nil
+	}
+	sigs := append(sigList{senderSig}, tx.coSigners...)
+	if sigs.hasDuplicate() {
+		return ErrDuplicateSig
+	}
+	if tx.coSigners.hasInvalidSig(tx.data.Hash) {
+		return ErrInvalidSig
+	}
 	return nil
 }
 
 func (tx *Transaction) setData(data *core_pb.Transaction) error {
 	tx.data = data
+	tx.resetSum()
 	var err error
 	tx.sender, err = NewPublicKey(tx.data.Sender)
+	if err != nil {
+		return err
+	}
+	tx.coSigners, err = newSigList(tx.data.CoSigners)
 	return err
 }
 
+// Clone returns a deep copy of transaction.
+// Setters on the clone don't affect the original, which may be shared with txpool.
+func (tx *Transaction) Clone() *Transaction {
+	clone := &Transaction{
+		data:   proto.Clone(tx.data).(*core_pb.Transaction),
+		sender: tx.sender, // public key is immutable
+	}
+	// co-signers were parsed from the same data
+	clone.coSigners, _ = newSigList(clone.data.CoSigners)
+	return clone
+}
+
 func (tx *Transaction) SetNonce(val int64) *Transaction {
 	tx.data.Nonce = val
+	tx.resetSum()
 	return tx
 }
 
 func (tx *Transaction) SetCodeAddr(val []byte) *Transaction {
 	tx.data.CodeAddr = val
+	tx.resetSum()
 	return tx
 }
 
 func (tx *Transaction) SetInput(val []byte) *Transaction {
 	tx.data.Input = val
+	tx.resetSum()
 	return tx
 }
 
 func (tx *Transaction) SetExpiry(val uint64) *Transaction {
 	tx.data.Expiry = val
+	tx.resetSum()
 	return tx
 }
 
 func (tx *Transaction) Sign(signer Signer) *Transaction {
 	tx.sender = signer.PublicKey()
 	tx.data.Sender = signer.PublicKey().key
+	tx.resetSum()
 	tx.data.Hash = tx.Sum()
 	tx.data.Signature = signer.Sign(tx.data.Hash).data.Value
 	return tx
 }
 
-func (tx *Transaction) Hash() []byte       { return tx.data.Hash }
+// SetCoSigners declares the co-signers, their public keys are part of tx hash.
+// It must be called before Sign, then each co-signer signs with AddCoSigner.
+func (tx *Transaction) SetCoSigners(pubKeys ...*PublicKey) *Transaction {
+	tx.coSigners = nil
+	tx.data.CoSigners = nil
+	for _, pubKey := range pubKeys {
+		data := &core_pb.Signature{PubKey: pubKey.Bytes()}
+		tx.coSigners = append(tx.coSigners, &Signature{data, pubKey})
+		tx.data.CoSigners = append(tx.data.CoSigners, data)
+	}
+	tx.resetSum()
+	return tx
+}
+
+// AddCoSigner adds signature of signer over tx hash for a co-signer declared with SetCoSigners,
+// it must be called after Sign. A signer not declared is appended, which changes the hash
+// and makes the tx invalid.
+func (tx *Transaction) AddCoSigner(signer Signer) *Transaction {
+	sig := signer.Sign(tx.data.Hash)
+	for _, cs := range tx.coSigners {
+		if len(cs.data.Value) == 0 && cs.PublicKey().Equal(signer.PublicKey()) {
+			cs.data.Value = sig.data.Value
+			return tx
+		}
+	}
+	tx.coSigners = append(tx.coSigners, sig)
+	tx.data.CoSigners = append(tx.data.CoSigners, sig.data)
+	tx.resetSum()
+	return tx
+}
+
+// Signers returns sender followed by co-signers
+func (tx *Transaction) Signers() []*PublicKey {
+	ret := make([]*PublicKey, 0, len(tx.coSigners)+1)
+	if tx.sender != nil {
+		ret = append(ret, tx.sender)
+	}
+	for _, sig := range tx.coSigners {
+		ret = append(ret, sig.PublicKey())
+	}
+	return ret
+}
+
+func (tx *Transaction) Hash() []byte       { return copyBytes(tx.data.Hash) }
 func (tx *Transaction) Nonce() int64       { return tx.data.Nonce }
 func (tx *Transaction) Sender() *PublicKey { return tx.sender }
-func (tx *Transaction) CodeAddr() []byte   { return tx.data.CodeAddr }
-func (tx *Transaction) Input() []byte      { return tx.data.Input }
+func (tx *Transaction) CodeAddr() []byte   { return copyBytes(tx.data.CodeAddr) }
+func (tx *Transaction) Input() []byte      { return copyBytes(tx.data.Input) }
 func (tx *Transaction) Expiry() uint64     { return tx.data.Expiry }
 
+// InputRef returns tx input without copying, callers must not modify it
+func (tx *Transaction) InputRef() []byte { return tx.data.Input }
+
 // Marshal encodes transaction as bytes
 func (tx *Transaction) Marshal() ([]byte, error) {
 	return proto.Marshal(tx.data)
@@ -149,8 +322,8 @@ func NewTxCommit() *TxCommit {
 	}
 }
 
-func (txc *TxCommit) Hash() []byte        { return txc.data.Hash }
-func (txc *TxCommit) BlockHash() []byte   { return txc.data.BlockHash }
+func (txc *TxCommit) Hash() []byte        { return copyBytes(txc.data.Hash) }
+func (txc *TxCommit) BlockHash() []byte   { return copyBytes(txc.data.BlockHash) }
 func (txc *TxCommit) BlockHeight() uint64 { return txc.data.BlockHeight }
 func (txc *TxCommit) Elapsed() float64    { return txc.data.Elapsed }
 func (txc *TxCommit) Error() string       { return txc.data.Error }
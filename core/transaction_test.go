@@ -51,6 +51,97 @@ func TestTransaction(t *testing.T) {
 	assert.NoError(tx.Validate())
 }
 
+func TestTransaction_CoSigners(t *testing.T) {
+	sender := GenerateKey(nil)
+	cosigner1 := GenerateKey(nil)
+	cosigner2 := GenerateKey(nil)
+
+	newTx := func(coSigners ...*PrivateKey) *Transaction {
+		tx := NewTransaction().SetNonce(1).SetInput([]byte{1})
+		pubKeys := make([]*PublicKey, len(coSigners))
+		for i, cs := range coSigners {
+			pubKeys[i] = cs.PublicKey()
+		}
+		return tx.SetCoSigners(pubKeys...).Sign(sender)
+	}
+	unmarshal := func(tx *Transaction) (*Transaction, error) {
+		b, _ := tx.Marshal()
+		ret := NewTransaction()
+		return ret, ret.Unmarshal(b)
+	}
+
+	t.Run("single signer format unchanged", func(t *testing.T) {
+		assert := assert.New(t)
+		tx := newTx()
+		b, _ := tx.Marshal()
+		tx1, err := unmarshal(tx)
+		assert.NoError(err)
+		b1, _ := tx1.Marshal()
+		assert.Equal(b, b1)
+		assert.Nil(tx1.data.CoSigners)
+		assert.Equal([]*PublicKey{sender.PublicKey()}, tx1.Signers())
+	})
+
+	t.Run("valid co-signers", func(t *testing.T) {
+		assert := assert.New(t)
+		tx := newTx(cosigner1, cosigner2).AddCoSigner(cosigner1).AddCoSigner(cosigner2)
+		assert.NotEqual(newTx().Hash(), tx.Hash(), "co-signer keys are part of hash")
+		assert.Equal(newTx(cosigner1, cosigner2).Hash(), tx.Hash(), "co-signatures are not")
+		assert.NoError(tx.Validate())
+
+		tx1, err := unmarshal(tx)
+		assert.NoError(err)
+		assert.NoError(tx1.Validate())
+		assert.Equal([]*PublicKey{
+			sender.PublicKey(), cosigner1.PublicKey(), cosigner2.PublicKey(),
+		}, tx1.Signers())
+	})
+
+	t.Run("co-signers changed", func(t *testing.T) {
+		assert := assert.New(t)
+		tx := newTx(cosigner1, cosigner2).AddCoSigner(cosigner1).AddCoSigner(cosigner2)
+		tx.data.CoSigners = tx.data.CoSigners[:1]
+		tx1, err := unmarshal(tx)
+		assert.NoError(err)
+		assert.ErrorIs(tx1.Validate(), ErrInvalidTxHash, "removed")
+
+		tx = newTx(cosigner1).AddCoSigner(cosigner1)
+		tx.data.CoSigners[0] = cosigner2.Sign(tx.data.Hash).data
+		tx1, err = unmarshal(tx)
+		assert.NoError(err)
+		assert.ErrorIs(tx1.Validate(), ErrInvalidTxHash, "swapped")
+
+		assert.ErrorIs(newTx().AddCoSigner(cosigner1).Validate(), ErrInvalidTxHash, "not declared")
+		assert.ErrorIs(newTx(cosigner1).Validate(), ErrInvalidSig, "not signed")
+	})
+
+	t.Run("invalid co-signature", func(t *testing.T) {
+		assert := assert.New(t)
+		tx := newTx(cosigner1).AddCoSigner(cosigner1)
+		tx.data.CoSigners[0].Value = sender.Sign([]byte("other")).data.Value
+		tx1, err := unmarshal(tx)
+		assert.NoError(err)
+		assert.ErrorIs(tx1.Validate(), ErrInvalidSig)
+	})
+
+	t.Run("duplicate co-signer", func(t *testing.T) {
+		assert := assert.New(t)
+		tx := newTx(cosigner1, cosigner1).AddCoSigner(cosigner1).AddCoSigner(cosigner1)
+		assert.ErrorIs(tx.Validate(), ErrDuplicateSig)
+
+		tx = newTx(sender).AddCoSigner(sender)
+		assert.ErrorIs(tx.Validate(), ErrDuplicateSig, "sender as co-signer")
+	})
+
+	t.Run("invalid co-signer key", func(t *testing.T) {
+		assert := assert.New(t)
+		tx := newTx(cosigner1).AddCoSigner(cosigner1)
+		tx.data.CoSigners[0].PubKey = []byte("invalid")
+		_, err := unmarshal(tx)
+		assert.Error(err)
+	})
+}
+
 func TestTxList(t *testing.T) {
 	privKey := GenerateKey(nil)
 
@@ -80,3 +171,122 @@ func TestTxList(t *testing.T) {
 	assert.Equal(tx1.Sum(), (*txs)[0].Sum())
 	assert.Equal(tx2.Sum(), (*txs)[1].Sum())
 }
+
+func TestTransaction_SumCache(t *testing.T) {
+	assert := assert.New(t)
+
+	tx := NewTransaction().SetNonce(1).Sign(GenerateKey(nil))
+	sum := tx.Sum()
+	assert.Equal(tx.Hash(), sum)
+
+	freshSum := func() []byte {
+		b, err := tx.Marshal()
+		assert.NoError(err)
+		fresh := NewTransaction()
+		assert.NoError(fresh.Unmarshal(b))
+		return fresh.Sum()
+	}
+
+	mutations := []func(){
+		func() { tx.SetNonce(2) },
+		func() { tx.SetCodeAddr([]byte{1}) },
+		func() { tx.SetInput([]byte{2}) },
+		func() { tx.SetExpiry(3) },
+		func() { tx.Sign(GenerateKey(nil)) },
+	}
+	for _, mutate := range mutations {
+		mutate()
+		assert.NotEqual(sum, tx.Sum())
+		assert.Equal(freshSum(), tx.Sum())
+		sum = tx.Sum()
+	}
+	assert.NoError(tx.Validate())
+
+	tx.SetNonce(10)
+	assert.ErrorIs(tx.Validate(), ErrInvalidTxHash)
+}
+
+func BenchmarkTransaction_Sum(b *testing.B) {
+	tx := NewTransaction().
+		SetCodeAddr([]byte{1}).
+		SetInput([]byte("benchmark input")).
+		Sign(GenerateKey(nil))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tx.SetNonce(int64(i)).Sum()
+	}
+}
+
+func TestTransaction_Clone(t *testing.T) {
+	assert := assert.New(t)
+
+	coSigner := GenerateKey(nil)
+	tx := NewTransaction().
+		SetNonce(1).
+		SetCodeAddr([]byte{1}).
+		SetInput([]byte{2}).
+		SetCoSigners(coSigner.PublicKey()).
+		Sign(GenerateKey(nil)).
+		AddCoSigner(coSigner)
+	sum := tx.Sum()
+
+	clone := tx.Clone()
+	assert.Equal(tx.Hash(), clone.Hash())
+	assert.Equal(sum, clone.Sum())
+	assert.Equal(tx.Sender(), clone.Sender())
+	assert.Equal(tx.Signers(), clone.Signers())
+	assert.NoError(clone.Validate())
+
+	clone.SetNonce(2).SetInput([]byte{3})
+	clone.data.CodeAddr[0] = 5
+	assert.NotEqual(sum, clone.Sum())
+	assert.Equal(sum, tx.Sum())
+	assert.Equal([]byte{1}, tx.CodeAddr())
+	assert.NoError(tx.Validate())
+}
+
+func TestTransaction_CopyOnAccess(t *testing.T) {
+	assert := assert.New(t)
+
+	tx := NewTransaction().SetInput([]byte("input")).Sign(GenerateKey(nil))
+	hash := tx.Hash()
+
+	tx.Hash()[0] ^= 1
+	tx.Sum()[0] ^= 1
+	tx.Input()[0] = 'x'
+
+	assert.Equal(hash, tx.Hash())
+	assert.Equal([]byte("input"), tx.Input())
+	assert.NoError(tx.Validate())
+}
+
+func TestTransaction_ValidateBasic(t *testing.T) {
+	priv := GenerateKey(nil)
+	limits := TxLimits{MaxInputSize: 10}
+	tests := []struct {
+		name   string
+		tx     *Transaction
+		limits TxLimits
+		err    error
+	}{
+		{"valid", NewTransaction().SetInput(make([]byte, 10)).Sign(priv), limits, nil},
+		{"code addr", NewTransaction().SetCodeAddr(make([]byte, CodeAddrSize)).Sign(priv), limits, nil},
+		{"no limit", NewTransaction().SetInput(make([]byte, 11)).Sign(priv), TxLimits{}, nil},
+		{"input too large", NewTransaction().SetInput(make([]byte, 11)).Sign(priv), limits, ErrTxInputTooLarge},
+		{"short code addr", NewTransaction().SetCodeAddr([]byte{1}).Sign(priv), limits, ErrInvalidCodeAddr},
+		{"long code addr", NewTransaction().SetCodeAddr(make([]byte, 33)).Sign(priv), limits, ErrInvalidCodeAddr},
+		{"nil sender", NewTransaction(), limits, ErrNilSender},
+		{"nil data", &Transaction{}, limits, ErrNilTx},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.tx.ValidateBasic(tt.limits)
+			if tt.err == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package core
+
+import (
+	"bytes"
+	"errors"
+)
+
+// errors
+var (
+	ErrTxNotInBlock = errors.New("tx not in block")
+)
+
+// prefixes separate leaf and node hashes in tx merkle tree
+const (
+	txLeafPrefix byte = 0
+	txNodePrefix byte = 1
+)
+
+/*
+TxRoot returns root of the binary merkle tree over tx hashes.
+Leaves are H(0x00 || txHash) and nodes are H(0x01 || left || right).
+If a level has odd number of nodes, the last one is paired with itself.
+A block with single tx has its leaf as root, and no txs gives nil root.
+*/
+func TxRoot(txHashes [][]byte) []byte {
+	if len(txHashes) == 0 {
+		return nil
+	}
+	level := txLeaves(txHashes)
+	for len(level) > 1 {
+		level = nextTxLevel(level)
+	}
+	return level[0]
+}
+
+// TxInclusionPath returns sibling hashes from leaf to root for tx at index
+func TxInclusionPath(txHashes [][]byte, index int) ([][]byte, error) {
+	if index < 0 || index >= len(txHashes) {
+		return nil, ErrTxNotInBlock
+	}
+	path := make([][]byte, 0)
+	level := txLeaves(txHashes)
+	for len(level) > 1 {
+		sibling := index ^ 1
+		if sibling >= len(level) { // odd last node, paired with itself
+			sibling = index
+		}
+		path = append(path, level[sibling])
+		level = nextTxLevel(level)
+		index /= 2
+	}
+	return path, nil
+}
+
+// VerifyTxInclusion checks that tx hash at index is included under txRoot
+func VerifyTxInclusion(txRoot []byte, txHash []byte, index int, path [][]byte) bool {
+	if index < 0 || len(txRoot) == 0 {
+		return false
+	}
+	node := sumTxLeaf(txHash)
+	for _, sibling := range path {
+		if index%2 == 0 {
+			node = sumTxNode(node, sibling)
+		} else {
+			// padding only pairs the last odd node on the right,
+			// equal left sibling means index is beyond the tx count
+			if bytes.Equal(sibling, node) {
+				return false
+			}
+			node = sumTxNode(sibling, node)
+		}
+		index /= 2
+	}
+	return index == 0 && bytes.Equal(node, txRoot)
+}
+
+func txLeaves(txHashes [][]byte) [][]byte {
+	leaves := make([][]byte, len(txHashes))
+	for i, txHash := range txHashes {
+		leaves[i] = sumTxLeaf(txHash)
+	}
+	return leaves
+}
+
+func nextTxLevel(level [][]byte) [][]byte {
+	next := make([][]byte, 0, (len(level)+1)/2)
+	for i := 0; i < len(level); i += 2 {
+		right := level[i]
+		if i+1 < len(level) {
+			right = level[i+1]
+		}
+		next = append(next, sumTxNode(level[i], right))
+	}
+	return next
+}
+
+func sumTxLeaf(txHash []byte) []byte {
+	hs := getHasher()
+	hs.write([]byte{txLeafPrefix})
+	hs.write(txHash)
+	return hs.sum()
+}
+
+func sumTxNode(left, right []byte) []byte {
+	hs := getHasher()
+	hs.write([]byte{txNodePrefix})
+	hs.write(left)
+	hs.write(right)
+	return hs.sum()
+}
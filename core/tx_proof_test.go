@@ -0,0 +1,73 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package core
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestTxHashes(n int) [][]byte {
+	hashes := make([][]byte, n)
+	for i := range hashes {
+		hashes[i] = NewTransaction().SetNonce(int64(i)).Sum()
+	}
+	return hashes
+}
+
+func TestTxInclusion(t *testing.T) {
+	for _, count := range []int{1, 2, 3, 101} {
+		hashes := newTestTxHashes(count)
+		root := TxRoot(hashes)
+		for _, index := range []int{0, count / 2, count - 1} {
+			t.Run(fmt.Sprintf("tx %d of %d", index, count), func(t *testing.T) {
+				assert := assert.New(t)
+				path, err := TxInclusionPath(hashes, index)
+				assert.NoError(err)
+				assert.True(VerifyTxInclusion(root, hashes[index], index, path))
+
+				assert.False(VerifyTxInclusion(root, []byte("other tx"), index, path))
+				assert.False(VerifyTxInclusion(root, hashes[index], index+1, path))
+				if len(path) > 0 {
+					assert.False(VerifyTxInclusion(root, hashes[index], index, path[1:]))
+				}
+			})
+		}
+	}
+}
+
+func TestTxRoot(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Nil(TxRoot(nil))
+
+	hashes := newTestTxHashes(3)
+	assert.Equal(sumTxLeaf(hashes[0]), TxRoot(hashes[:1]), "single tx")
+
+	path, err := TxInclusionPath(hashes[:1], 0)
+	assert.NoError(err)
+	assert.Empty(path)
+
+	// last odd leaf is paired with itself
+	n01 := sumTxNode(sumTxLeaf(hashes[0]), sumTxLeaf(hashes[1]))
+	n22 := sumTxNode(sumTxLeaf(hashes[2]), sumTxLeaf(hashes[2]))
+	assert.Equal(sumTxNode(n01, n22), TxRoot(hashes))
+
+	path, err = TxInclusionPath(hashes, 2)
+	assert.NoError(err)
+	assert.Equal([][]byte{sumTxLeaf(hashes[2]), n01}, path)
+
+	// leaf and node hashes are separated
+	assert.NotEqual(TxRoot([][]byte{n01, n22}), TxRoot(hashes))
+
+	_, err = TxInclusionPath(hashes, 3)
+	assert.ErrorIs(err, ErrTxNotInBlock)
+	_, err = TxInclusionPath(hashes, -1)
+	assert.ErrorIs(err, ErrTxNotInBlock)
+
+	blk := NewBlock().SetTransactions(hashes)
+	assert.Equal(TxRoot(hashes), blk.TxRoot())
+}
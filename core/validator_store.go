@@ -14,26 +14,46 @@ type ValidatorStore interface {
 	IsValidator(pubKey *PublicKey) bool
 	GetValidator(idx int) *PublicKey
 	GetValidatorIndex(pubKey *PublicKey) int
+	GetStake(pubKey *PublicKey) uint64
+	MajorityStake() uint64
 }
 
 type simpleValidatorStore struct {
 	validators []*PublicKey
+	stakes     []uint64
 	vMap       map[string]int
 
-	majority int
+	majority      int
+	majorityStake uint64
 }
 
 var _ ValidatorStore = (*simpleValidatorStore)(nil)
 
+// NewValidatorStore creates validator store where every validator has one stake
 func NewValidatorStore(validators []*PublicKey) ValidatorStore {
+	stakes := make([]uint64, len(validators))
+	for i := range stakes {
+		stakes[i] = 1
+	}
+	return NewWeightedValidatorStore(validators, stakes)
+}
+
+// NewWeightedValidatorStore creates validator store with voting power proportional to stake.
+// stakes[i] is the stake of validators[i], missing stakes are zero.
+func NewWeightedValidatorStore(validators []*PublicKey, stakes []uint64) ValidatorStore {
 	store := &simpleValidatorStore{
 		validators: validators,
+		stakes:     make([]uint64, len(validators)),
 	}
+	copy(store.stakes, stakes)
 	store.vMap = make(map[string]int, len(store.validators))
+	var totalStake uint64
 	for i, v := range store.validators {
 		store.vMap[v.String()] = i
+		totalStake += store.stakes[i]
 	}
 	store.majority = MajorityCount(len(validators))
+	store.majorityStake = MajorityStake(totalStake)
 	return store
 }
 
@@ -67,8 +87,40 @@ func (store *simpleValidatorStore) GetValidatorIndex(pubKey *PublicKey) int {
 	return store.vMap[pubKey.String()]
 }
 
+func (store *simpleValidatorStore) GetStake(pubKey *PublicKey) uint64 {
+	if pubKey == nil {
+		return 0
+	}
+	idx, ok := store.vMap[pubKey.String()]
+	if !ok {
+		return 0
+	}
+	return store.stakes[idx]
+}
+
+func (store *simpleValidatorStore) MajorityStake() uint64 {
+	return store.majorityStake
+}
+
 // MajorityCount returns 2f + 1 members
 func MajorityCount(validatorCount int) int {
 	// n=3f+1 -> f=floor((n-1)3) -> m=n-f -> m=ceil((2n+1)/3)
 	return int(math.Ceil(float64(2*validatorCount+1) / 3))
 }
+
+// HasMajorityStake checks that stake reaches the majority stake of vs.
+// Validators without stake cannot form a quorum, so zero majority stake is never reached.
+func HasMajorityStake(vs ValidatorStore, stake uint64) bool {
+	majority := vs.MajorityStake()
+	return majority > 0 && stake >= majority
+}
+
+// MajorityStake returns 2f + 1 stake, the same threshold as MajorityCount
+// when every validator has one stake, or zero if there is no stake
+func MajorityStake(totalStake uint64) uint64 {
+	if totalStake == 0 {
+		return 0
+	}
+	// m=n-f, f=floor((n-1)/3), avoids overflow of 2n
+	return totalStake - (totalStake-1)/3
+}
@@ -45,6 +45,16 @@ func (m *MockValidatorStore) GetValidatorIndex(pubKey *PublicKey) int {
 	return args.Int(0)
 }
 
+func (m *MockValidatorStore) GetStake(pubKey *PublicKey) uint64 {
+	args := m.Called(pubKey)
+	return args.Get(0).(uint64)
+}
+
+func (m *MockValidatorStore) MajorityStake() uint64 {
+	args := m.Called()
+	return args.Get(0).(uint64)
+}
+
 func TestMajorityCount(t *testing.T) {
 	type args struct {
 		validatorCount int
@@ -67,3 +77,32 @@ func TestMajorityCount(t *testing.T) {
 		})
 	}
 }
+
+func TestMajorityStake(t *testing.T) {
+	for n := 1; n < 100; n++ {
+		assert.Equal(t, uint64(MajorityCount(n)), MajorityStake(uint64(n)))
+	}
+	assert.Equal(t, uint64(0), MajorityStake(0))
+	assert.Equal(t, uint64(9), MajorityStake(13))
+}
+
+func TestWeightedValidatorStore(t *testing.T) {
+	keys := []*PublicKey{
+		GenerateKey(nil).PublicKey(),
+		GenerateKey(nil).PublicKey(),
+		GenerateKey(nil).PublicKey(),
+	}
+	vs := NewWeightedValidatorStore(keys, []uint64{10, 2})
+
+	assert.Equal(t, uint64(10), vs.GetStake(keys[0]))
+	assert.Equal(t, uint64(2), vs.GetStake(keys[1]))
+	assert.Equal(t, uint64(0), vs.GetStake(keys[2]))
+	assert.Equal(t, uint64(0), vs.GetStake(GenerateKey(nil).PublicKey()))
+	assert.Equal(t, uint64(0), vs.GetStake(nil))
+	assert.Equal(t, uint64(9), vs.MajorityStake())
+	assert.Equal(t, 3, vs.MajorityCount())
+
+	vs = NewValidatorStore(keys)
+	assert.Equal(t, uint64(1), vs.GetStake(keys[2]))
+	assert.Equal(t, uint64(3), vs.MajorityStake())
+}
@@ -4,7 +4,9 @@
 package core
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
 
 	"github.com/aungmawjj/juria-blockchain/core/core_pb"
 	"google.golang.org/protobuf/proto"
@@ -12,7 +14,8 @@ import (
 
 // errors
 var (
-	ErrNilVote = errors.New("nil vote")
+	ErrNilVote             = errors.New("nil vote")
+	ErrBlockHeightMismatch = errors.New("height doesn't match the block")
 )
 
 // Vote type
@@ -27,7 +30,8 @@ func NewVote() *Vote {
 	}
 }
 
-// Validate vote
+// Validate checks that vote is signed by a validator for the block hash.
+// The height of vote is not checked, see CheckBlock.
 func (vote *Vote) Validate(vs ValidatorStore) error {
 	if vote.data == nil {
 		return ErrNilVote
@@ -36,15 +40,61 @@ func (vote *Vote) Validate(vs ValidatorStore) error {
 	if err != nil {
 		return err
 	}
-	if !vs.IsValidator(sig.PublicKey()) {
+	if !validatorsAt(vs, vote.data.BlockHeight).IsValidator(sig.PublicKey()) {
 		return ErrInvalidValidator
 	}
-	if !sig.Verify(vote.data.BlockHash) {
+	if !sig.VerifyCached(voteDigest(vote.data.BlockHash, vote.data.BlockHeight)) {
 		return ErrInvalidSig
 	}
 	return nil
 }
 
+// CheckBlock checks that vote is for blk at the height of blk.
+// The height selects the signed digest and the validators, it must be checked before
+// the vote is accepted, since it is not signed below domain separation activation height.
+func (vote *Vote) CheckBlock(blk *Block) error {
+	if vote.data == nil {
+		return ErrNilVote
+	}
+	return checkVotedBlock(vote.data.BlockHash, vote.data.BlockHeight, blk)
+}
+
+func checkVotedBlock(blockHash []byte, height uint64, blk *Block) error {
+	if blk == nil {
+		return ErrNilBlock
+	}
+	if !bytes.Equal(blockHash, blk.data.Hash) {
+		return ErrInvalidBlockHash
+	}
+	if height != blk.data.Height {
+		return fmt.Errorf("%w, %d, block %d", ErrBlockHeightMismatch, height, blk.data.Height)
+	}
+	return nil
+}
+
+// HasMajorityVotes returns true if the distinct voters hold majority stake
+// of the validators at the height of the votes, the same threshold as QuorumCert.Validate.
+// The votes must be validated and for the same block.
+func HasMajorityVotes(vs ValidatorStore, votes []*Vote) bool {
+	if len(votes) == 0 {
+		return false
+	}
+	vs = validatorsAt(vs, votes[0].data.BlockHeight)
+	voted := make(map[string]struct{}, len(votes))
+	var stake uint64
+	for _, vote := range votes {
+		if vote.voter == nil {
+			continue
+		}
+		if _, found := voted[vote.voter.keyStr]; found {
+			continue
+		}
+		voted[vote.voter.keyStr] = struct{}{}
+		stake += vs.GetStake(vote.voter)
+	}
+	return HasMajorityStake(vs, stake)
+}
+
 func (vote *Vote) setData(data *core_pb.Vote) error {
 	vote.data = data
 	sig, err := newSignature(vote.data.Signature)
@@ -55,8 +105,20 @@ func (vote *Vote) setData(data *core_pb.Vote) error {
 	return nil
 }
 
-func (vote *Vote) BlockHash() []byte { return vote.data.BlockHash }
-func (vote *Vote) Voter() *PublicKey { return vote.voter }
+func (vote *Vote) BlockHash() []byte   { return copyBytes(vote.data.BlockHash) }
+func (vote *Vote) BlockHeight() uint64 { return vote.data.BlockHeight }
+func (vote *Vote) Voter() *PublicKey   { return vote.voter }
+
+// Signature returns voter's signature on block hash
+func (vote *Vote) Signature() *Signature {
+	if vote.data == nil || vote.voter == nil {
+		return nil
+	}
+	return &Signature{
+		data:   vote.data.Signature,
+		pubKey: vote.voter,
+	}
+}
 
 // Marshal encodes vote as bytes
 func (vote *Vote) Marshal() ([]byte, error) {
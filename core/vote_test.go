@@ -64,3 +64,43 @@ func TestVote(t *testing.T) {
 		})
 	}
 }
+
+func TestVote_Signature(t *testing.T) {
+	assert := assert.New(t)
+
+	validator := GenerateKey(nil)
+	blk := NewBlock().Sign(GenerateKey(nil))
+	vote := blk.Vote(validator)
+
+	assert.Equal(validator.PublicKey(), vote.Voter())
+	sig := vote.Signature()
+	assert.Equal(validator.PublicKey(), sig.PublicKey())
+	assert.True(sig.Verify(blk.Hash()))
+
+	assert.Nil(NewVote().Signature())
+}
+
+func TestVote_Validate(t *testing.T) {
+	validator := GenerateKey(nil)
+	vs := NewValidatorStore([]*PublicKey{validator.PublicKey()})
+	blk := NewBlock().Sign(GenerateKey(nil))
+
+	tampered := blk.Vote(validator)
+	tampered.data.BlockHash = NewBlock().SetHeight(1).Sign(validator).Hash()
+
+	tests := []struct {
+		name string
+		vote *Vote
+		err  error
+	}{
+		{"valid", blk.Vote(validator), nil},
+		{"non validator", blk.Vote(GenerateKey(nil)), ErrInvalidValidator},
+		{"tampered block hash", tampered, ErrInvalidSig},
+		{"nil data", &Vote{}, ErrNilVote},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.ErrorIs(t, tt.vote.Validate(vs), tt.err)
+		})
+	}
+}
@@ -38,6 +38,7 @@ func TestCallData(t *testing.T) {
 	mctx := new(chaincode.MockCallContext)
 	mctx.MockInput = []byte("input")
 	mctx.MockSender = []byte("sender")
+	mctx.MockSigners = [][]byte{[]byte("sender"), []byte("cosigner")}
 	mctx.MockBlockHash = []byte("blockHash")
 	mctx.MockBlockHeight = 10
 	r.callContext = mctx
@@ -50,6 +51,7 @@ func TestCallData(t *testing.T) {
 	assert.Equal(CallTypeInit, c.callData.CallType)
 	assert.Equal(mctx.Input(), c.Input())
 	assert.Equal(mctx.Sender(), c.Sender())
+	assert.Equal(mctx.Signers(), c.Signers())
 	assert.Equal(mctx.BlockHash(), c.BlockHash())
 	assert.Equal(mctx.BlockHeight(), c.BlockHeight())
 }
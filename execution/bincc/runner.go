@@ -99,6 +99,7 @@ func (r *Runner) sendCallData(callType CallType) error {
 		CallType:    callType,
 		Input:       r.callContext.Input(),
 		Sender:      r.callContext.Sender(),
+		Signers:     r.callContext.Signers(),
 		BlockHash:   r.callContext.BlockHash(),
 		BlockHeight: r.callContext.BlockHeight(),
 	}
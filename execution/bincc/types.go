@@ -14,6 +14,7 @@ const (
 type CallData struct {
 	Input       []byte
 	Sender      []byte
+	Signers     [][]byte
 	BlockHash   []byte
 	BlockHeight uint64
 	CallType    CallType
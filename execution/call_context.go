@@ -4,6 +4,8 @@
 package execution
 
 import (
+	"errors"
+
 	"github.com/aungmawjj/juria-blockchain/core"
 	"github.com/aungmawjj/juria-blockchain/execution/chaincode"
 )
@@ -27,6 +29,18 @@ func (ctx *callContextTx) Sender() []byte {
 	return ctx.tx.Sender().Bytes()
 }
 
+func (ctx *callContextTx) Signers() [][]byte {
+	if ctx.tx == nil {
+		return nil
+	}
+	signers := ctx.tx.Signers()
+	ret := make([][]byte, len(signers))
+	for i, s := range signers {
+		ret[i] = s.Bytes()
+	}
+	return ret
+}
+
 func (ctx *callContextTx) BlockHash() []byte {
 	if ctx.blk == nil {
 		return nil
@@ -48,9 +62,21 @@ func (ctx *callContextTx) Input() []byte {
 type callContextQuery struct {
 	input []byte
 	stateGetter
+
+	codeAddr    []byte
+	prefixStore PrefixStateStore // nil if not supported by the state store
 }
 
 var _ chaincode.CallContext = (*callContextQuery)(nil)
+var _ chaincode.StateScanner = (*callContextQuery)(nil)
+
+// GetStatesByPrefix scans the commited states, which are not verified with the merkle root like GetState
+func (ctx *callContextQuery) GetStatesByPrefix(prefix, start []byte, limit int) ([]chaincode.KeyValue, []byte, error) {
+	if ctx.prefixStore == nil {
+		return nil, nil, errors.New("state scan not supported")
+	}
+	return ctx.prefixStore.GetCodeStatesByPrefix(ctx.codeAddr, prefix, start, limit)
+}
 
 func (ctx *callContextQuery) Input() []byte {
 	return ctx.input
@@ -60,6 +86,10 @@ func (ctx *callContextQuery) Sender() []byte {
 	return nil
 }
 
+func (ctx *callContextQuery) Signers() [][]byte {
+	return nil
+}
+
 func (ctx *callContextQuery) BlockHash() []byte {
 	return nil
 }
@@ -5,6 +5,8 @@ package chaincode
 
 type CallContext interface {
 	Sender() []byte
+	// sender followed by co-signers of tx
+	Signers() [][]byte
 	BlockHash() []byte
 	BlockHeight() uint64
 	Input() []byte
@@ -13,6 +15,20 @@ type CallContext interface {
 	SetState(key, value []byte)
 }
 
+// KeyValue is a state key of the chaincode with its value
+type KeyValue struct {
+	Key   []byte
+	Value []byte
+}
+
+// StateScanner is implemented by the call context of queries.
+// Tx executions can't scan states as their reads are tracked by key.
+type StateScanner interface {
+	// GetStatesByPrefix returns up to limit commited states whose keys start with prefix, ordered by key from start,
+	// and the key to continue from, nil after the last state. Start is the prefix if nil.
+	GetStatesByPrefix(prefix, start []byte, limit int) ([]KeyValue, []byte, error)
+}
+
 // all chaincodes implements Chaincode interface
 type Chaincode interface {
 	// called when chaincode is deployed
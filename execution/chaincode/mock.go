@@ -3,6 +3,11 @@
 
 package chaincode
 
+import (
+	"sort"
+	"strings"
+)
+
 type MockState struct {
 	StateMap    map[string][]byte
 	VerifyError error
@@ -29,8 +34,33 @@ func (ms *MockState) SetState(key, value []byte) {
 	ms.StateMap[string(key)] = value
 }
 
+func (ms *MockState) GetStatesByPrefix(prefix, start []byte, limit int) ([]KeyValue, []byte, error) {
+	if start == nil {
+		start = prefix
+	}
+	if limit <= 0 {
+		return []KeyValue{}, nil, nil
+	}
+	keys := make([]string, 0)
+	for key := range ms.StateMap {
+		if strings.HasPrefix(key, string(prefix)) && key >= string(start) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	kvs := make([]KeyValue, 0)
+	for i, key := range keys {
+		if i == limit {
+			return kvs, []byte(key), nil
+		}
+		kvs = append(kvs, KeyValue{[]byte(key), ms.StateMap[key]})
+	}
+	return kvs, nil, nil
+}
+
 type MockCallContext struct {
 	MockSender      []byte
+	MockSigners     [][]byte
 	MockBlockHeight uint64
 	MockBlockHash   []byte
 	MockInput       []byte
@@ -43,6 +73,10 @@ func (wc *MockCallContext) Sender() []byte {
 	return wc.MockSender
 }
 
+func (wc *MockCallContext) Signers() [][]byte {
+	return wc.MockSigners
+}
+
 func (wc *MockCallContext) BlockHash() []byte {
 	return wc.MockBlockHash
 }
@@ -5,11 +5,13 @@ package execution
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/aungmawjj/juria-blockchain/core"
 	"github.com/aungmawjj/juria-blockchain/execution/bincc"
+	"github.com/aungmawjj/juria-blockchain/execution/chaincode"
 )
 
 type Config struct {
@@ -30,9 +32,21 @@ type Execution struct {
 	codeRegistry *codeRegistry
 }
 
+// StateStore gets the states of keys in the namespace of code address, nil code address for system keys
 type StateStore interface {
-	VerifyState(key []byte) []byte
-	GetState(key []byte) []byte
+	VerifyState(codeAddr, key []byte) []byte
+	GetState(codeAddr, key []byte) []byte
+}
+
+// PrefixStateStore is implemented by state stores which scan the states in the namespace of code address,
+// see chaincode.StateScanner
+type PrefixStateStore interface {
+	GetCodeStatesByPrefix(codeAddr, prefix, start []byte, limit int) ([]chaincode.KeyValue, []byte, error)
+}
+
+// HistoricalStateStore is implemented by state stores which keep state versions
+type HistoricalStateStore interface {
+	GetStateAtHeight(codeAddr, key []byte, height uint64) ([]byte, error)
 }
 
 func New(stateStore StateStore, config Config) *Execution {
@@ -49,21 +63,36 @@ func New(stateStore StateStore, config Config) *Execution {
 
 func (exec *Execution) Execute(blk *core.Block, txs []*core.Transaction) (
 	*core.BlockCommit, []*core.TxCommit,
+) {
+	return exec.ExecuteOn(exec.stateStore, blk, txs)
+}
+
+// ExecuteOn executes the block on the given state instead of the commited state
+func (exec *Execution) ExecuteOn(state StateStore, blk *core.Block, txs []*core.Transaction) (
+	*core.BlockCommit, []*core.TxCommit,
 ) {
 	bexe := &blkExecutor{
 		txTimeout:       exec.config.TxExecTimeout,
 		concurrentLimit: exec.config.ConcurrentLimit,
 		codeRegistry:    exec.codeRegistry,
-		state:           exec.stateStore,
+		state:           state,
 		blk:             blk,
 		txs:             txs,
 	}
 	return bexe.execute()
 }
 
+// NewPendingState creates a pending state on top of the commited state
+func (exec *Execution) NewPendingState() *PendingState {
+	return NewPendingState(exec.stateStore)
+}
+
 type QueryData struct {
 	CodeAddr []byte
 	Input    []byte
+
+	// query the state after the block at height is commited, latest state if nil
+	Height *uint64 `json:",omitempty"`
 }
 
 func (exec *Execution) Query(query *QueryData) (val []byte, err error) {
@@ -72,15 +101,28 @@ func (exec *Execution) Query(query *QueryData) (val []byte, err error) {
 			err = fmt.Errorf("%v", r)
 		}
 	}()
+	store := exec.stateStore
+	if query.Height != nil {
+		hs, ok := exec.stateStore.(HistoricalStateStore)
+		if !ok {
+			return nil, errors.New("state history not supported")
+		}
+		store = &stateAtHeight{hs, *query.Height}
+	}
 	cc, err := exec.codeRegistry.getInstance(
-		query.CodeAddr, newStateVerifier(exec.stateStore, codeRegistryAddr))
+		query.CodeAddr, newStateVerifier(store, codeRegistryAddr))
 	if err != nil {
 		return nil, err
 	}
-	return cc.Query(&callContextQuery{
+	ctx := &callContextQuery{
 		input:       query.Input,
-		stateGetter: newStateVerifier(exec.stateStore, query.CodeAddr),
-	})
+		stateGetter: newStateVerifier(store, query.CodeAddr),
+		codeAddr:    query.CodeAddr,
+	}
+	if ps, ok := store.(PrefixStateStore); ok {
+		ctx.prefixStore = ps
+	}
+	return cc.Query(ctx)
 }
 
 func (exec *Execution) VerifyTx(tx *core.Transaction) error {
@@ -89,7 +131,7 @@ func (exec *Execution) VerifyTx(tx *core.Transaction) error {
 	}
 	// deployment tx
 	input := new(DeploymentInput)
-	err := json.Unmarshal(tx.Input(), input)
+	err := json.Unmarshal(tx.InputRef(), input)
 	if err != nil {
 		return err
 	}
@@ -5,10 +5,12 @@ package execution
 
 import (
 	"encoding/json"
+	"errors"
 	"testing"
 	"time"
 
 	"github.com/aungmawjj/juria-blockchain/core"
+	"github.com/aungmawjj/juria-blockchain/execution/chaincode"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -94,3 +96,82 @@ func TestExecution(t *testing.T) {
 	// assert.NoError(err)
 	// assert.Equal(priv.PublicKey().Bytes(), minter)
 }
+
+type historyStateStore struct {
+	*mapStateStore
+	versions map[uint64]map[string][]byte
+}
+
+func (store *historyStateStore) GetStateAtHeight(codeAddr, key []byte, height uint64) ([]byte, error) {
+	state, ok := store.versions[height]
+	if !ok {
+		return nil, errors.New("pruned")
+	}
+	return state[trackKey(codeAddr, key)], nil
+}
+
+func TestExecution_QueryAtHeight(t *testing.T) {
+	assert := assert.New(t)
+
+	height := uint64(1)
+	execution := &Execution{stateStore: newMapStateStore(), codeRegistry: newCodeRegistry()}
+	_, err := execution.Query(&QueryData{CodeAddr: []byte{1}, Height: &height})
+	assert.Error(err, "state history not supported")
+
+	store := &historyStateStore{newMapStateStore(), map[uint64]map[string][]byte{
+		1: {trackKey(nil, []byte("a")): {1}},
+	}}
+	store.SetState([]byte("a"), []byte{2})
+	sh := &stateAtHeight{store, 1}
+	assert.Equal([]byte{1}, sh.GetState(nil, []byte("a")))
+	assert.Equal([]byte{1}, sh.VerifyState(nil, []byte("a")))
+	assert.Panics(func() { (&stateAtHeight{store, 0}).GetState(nil, []byte("a")) })
+
+	execution.stateStore = store
+	_, err = execution.Query(&QueryData{CodeAddr: []byte{1}, Height: new(uint64)})
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "pruned", "panic is recovered as error")
+	}
+}
+
+type prefixStateStore struct {
+	*chaincode.MockState
+	codeAddr []byte
+}
+
+func (store *prefixStateStore) GetCodeStatesByPrefix(
+	codeAddr, prefix, start []byte, limit int,
+) ([]chaincode.KeyValue, []byte, error) {
+	store.codeAddr = codeAddr
+	return store.GetStatesByPrefix(prefix, start, limit)
+}
+
+func TestCallContextQuery_GetStatesByPrefix(t *testing.T) {
+	assert := assert.New(t)
+
+	ctx := &callContextQuery{codeAddr: []byte{1}}
+	_, _, err := ctx.GetStatesByPrefix([]byte("a"), nil, 10)
+	assert.Error(err, "not supported")
+
+	store := &prefixStateStore{MockState: chaincode.NewMockState()}
+	store.SetState([]byte("a/1"), []byte{1})
+	store.SetState([]byte("a/2"), []byte{2})
+	store.SetState([]byte("b/1"), []byte{3})
+	ctx.prefixStore = store
+
+	kvs, next, err := ctx.GetStatesByPrefix([]byte("a/"), nil, 1)
+	assert.NoError(err)
+	assert.Equal([]byte{1}, store.codeAddr)
+	assert.Equal([]chaincode.KeyValue{{Key: []byte("a/1"), Value: []byte{1}}}, kvs)
+	assert.Equal([]byte("a/2"), next)
+
+	kvs, next, err = ctx.GetStatesByPrefix([]byte("a/"), next, 1)
+	assert.NoError(err)
+	assert.Equal([]chaincode.KeyValue{{Key: []byte("a/2"), Value: []byte{2}}}, kvs)
+	assert.Nil(next)
+
+	kvs, next, err = ctx.GetStatesByPrefix([]byte("c/"), nil, 1)
+	assert.NoError(err)
+	assert.Empty(kvs)
+	assert.Nil(next)
+}
@@ -0,0 +1,67 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package execution
+
+import (
+	"sync"
+
+	"github.com/aungmawjj/juria-blockchain/core"
+)
+
+// PendingState reads the state changes of executed blocks which are not commited yet,
+// on top of the commited state. It lets consecutive blocks be executed before
+// they are commited together.
+type PendingState struct {
+	base   StateStore
+	values map[string][]byte
+	mtx    sync.RWMutex
+}
+
+var _ StateStore = (*PendingState)(nil)
+
+func NewPendingState(base StateStore) *PendingState {
+	return &PendingState{
+		base:   base,
+		values: make(map[string][]byte),
+	}
+}
+
+// Apply adds the state changes of an executed block
+func (ps *PendingState) Apply(bcm *core.BlockCommit) {
+	ps.mtx.Lock()
+	defer ps.mtx.Unlock()
+	for _, sc := range bcm.StateChanges() {
+		ps.values[trackKey(sc.CodeAddr(), sc.Key())] = sc.Value()
+	}
+}
+
+// Reset drops the pending changes after they are commited
+func (ps *PendingState) Reset() {
+	ps.mtx.Lock()
+	defer ps.mtx.Unlock()
+	ps.values = make(map[string][]byte)
+}
+
+func (ps *PendingState) GetState(codeAddr, key []byte) []byte {
+	if value, ok := ps.getPending(codeAddr, key); ok {
+		return value
+	}
+	return ps.base.GetState(codeAddr, key)
+}
+
+// VerifyState verifies only the commited values,
+// pending values are not in the merkle tree yet
+func (ps *PendingState) VerifyState(codeAddr, key []byte) []byte {
+	if value, ok := ps.getPending(codeAddr, key); ok {
+		return value
+	}
+	return ps.base.VerifyState(codeAddr, key)
+}
+
+func (ps *PendingState) getPending(codeAddr, key []byte) ([]byte, bool) {
+	ps.mtx.RLock()
+	defer ps.mtx.RUnlock()
+	value, ok := ps.values[trackKey(codeAddr, key)]
+	return value, ok
+}
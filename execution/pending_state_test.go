@@ -0,0 +1,37 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package execution
+
+import (
+	"testing"
+
+	"github.com/aungmawjj/juria-blockchain/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPendingState(t *testing.T) {
+	assert := assert.New(t)
+
+	ms := newMapStateStore()
+	ms.SetState([]byte{1}, []byte{10})
+	ms.SetState([]byte{2}, []byte{20})
+	ps := NewPendingState(ms)
+
+	ps.Apply(core.NewBlockCommit().SetStateChanges([]*core.StateChange{
+		core.NewStateChange().SetKey([]byte{1}).SetValue([]byte{11}),
+	}))
+	ps.Apply(core.NewBlockCommit().SetStateChanges([]*core.StateChange{
+		core.NewStateChange().SetKey([]byte{1}).SetValue([]byte{12}),
+		core.NewStateChange().SetKey([]byte{3}).SetValue([]byte{30}),
+	}))
+
+	assert.Equal([]byte{12}, ps.GetState(nil, []byte{1}), "latest pending value")
+	assert.Equal([]byte{12}, ps.VerifyState(nil, []byte{1}))
+	assert.Equal([]byte{20}, ps.GetState(nil, []byte{2}), "commited value")
+	assert.Equal([]byte{30}, ps.GetState(nil, []byte{3}))
+
+	ps.Reset()
+	assert.Equal([]byte{10}, ps.GetState(nil, []byte{1}))
+	assert.Nil(ps.GetState(nil, []byte{3}))
+}
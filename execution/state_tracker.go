@@ -14,35 +14,51 @@ type stateGetter interface {
 	GetState(key []byte) []byte
 }
 
-// stateTracker tracks state changes in key order
+// codeStateGetter gets the state of key in the namespace of codeAddr
+type codeStateGetter interface {
+	getCodeState(codeAddr, key []byte) []byte
+}
+
+// storeGetter gets the states from state store
+type storeGetter struct {
+	store StateStore
+}
+
+func (sg storeGetter) getCodeState(codeAddr, key []byte) []byte {
+	return sg.store.GetState(codeAddr, key)
+}
+
+// stateTracker tracks state changes of keys in the namespace of codeAddr
 // get latest changed state for each key
 // get state from base state getter if no changes occured for a key
 type stateTracker struct {
-	keyPrefix []byte
-	baseState stateGetter
+	codeAddr  []byte
+	baseState codeStateGetter
 
 	trackDep     bool
-	dependencies map[string]struct{} // getState calls
-	changes      map[string][]byte   // setState calls
+	dependencies map[string]struct{}          // getState calls
+	changes      map[string]*core.StateChange // setState calls
 
 	mtxChg sync.RWMutex
 	mtxDep sync.RWMutex
 }
 
-func newStateTracker(state stateGetter, keyPrefix []byte) *stateTracker {
+func newStateTracker(state StateStore, codeAddr []byte) *stateTracker {
+	return newStateTrackerWithBase(storeGetter{state}, codeAddr)
+}
+
+func newStateTrackerWithBase(base codeStateGetter, codeAddr []byte) *stateTracker {
 	return &stateTracker{
-		keyPrefix: keyPrefix,
-		baseState: state,
+		codeAddr:  codeAddr,
+		baseState: base,
 
 		dependencies: make(map[string]struct{}),
-		changes:      make(map[string][]byte),
+		changes:      make(map[string]*core.StateChange),
 	}
 }
 
 func (trk *stateTracker) GetState(key []byte) []byte {
-	trk.mtxChg.RLock()
-	defer trk.mtxChg.RUnlock()
-	return trk.getState(key)
+	return trk.getCodeState(trk.codeAddr, key)
 }
 
 func (trk *stateTracker) SetState(key, value []byte) {
@@ -51,9 +67,9 @@ func (trk *stateTracker) SetState(key, value []byte) {
 	trk.setState(key, value)
 }
 
-// spawn creates a new tracker with current tracker as base StateGetter
-func (trk *stateTracker) spawn(keyPrefix []byte) *stateTracker {
-	child := newStateTracker(trk, keyPrefix)
+// spawn creates a new tracker with current tracker as base state getter
+func (trk *stateTracker) spawn(codeAddr []byte) *stateTracker {
+	child := newStateTrackerWithBase(trk, codeAddr)
 	child.trackDep = true
 	return child
 }
@@ -65,10 +81,7 @@ func (trk *stateTracker) hasDependencyChanges(child *stateTracker) bool {
 	child.mtxDep.RLock()
 	defer child.mtxDep.RUnlock()
 
-	prefixStr := string(trk.keyPrefix)
-
 	for key := range child.dependencies {
-		key = prefixStr + key
 		if _, changed := trk.changes[key]; changed {
 			return true
 		}
@@ -83,8 +96,8 @@ func (trk *stateTracker) merge(child *stateTracker) {
 	child.mtxChg.RLock()
 	defer child.mtxChg.RUnlock()
 
-	for key, value := range child.changes {
-		trk.setState([]byte(key), value)
+	for key, sc := range child.changes {
+		trk.changes[key] = sc
 	}
 }
 
@@ -93,34 +106,42 @@ func (trk *stateTracker) getStateChanges() []*core.StateChange {
 	defer trk.mtxChg.RUnlock()
 
 	scList := make([]*core.StateChange, 0, len(trk.changes))
-	for key, value := range trk.changes {
-		scList = append(scList, core.NewStateChange().SetKey([]byte(key)).SetValue(value))
+	for _, sc := range trk.changes {
+		scList = append(scList, core.NewStateChange().
+			SetCodeAddr(sc.CodeAddr()).SetKey(sc.Key()).SetValue(sc.Value()))
 	}
 	return scList
 }
 
-func (trk *stateTracker) getState(key []byte) []byte {
-	key = concatBytes(trk.keyPrefix, key)
-	trk.setDependency(key)
-	if value, ok := trk.changes[string(key)]; ok {
-		return value
+func (trk *stateTracker) getCodeState(codeAddr, key []byte) []byte {
+	trk.mtxChg.RLock()
+	defer trk.mtxChg.RUnlock()
+
+	tkey := trackKey(codeAddr, key)
+	trk.setDependency(tkey)
+	if sc, ok := trk.changes[tkey]; ok {
+		return sc.Value()
 	}
-	return trk.baseState.GetState(key)
+	return trk.baseState.getCodeState(codeAddr, key)
 }
 
-func (trk *stateTracker) setDependency(key []byte) {
+func (trk *stateTracker) setDependency(key string) {
 	if !trk.trackDep {
 		return
 	}
 	trk.mtxDep.Lock()
 	defer trk.mtxDep.Unlock()
-	trk.dependencies[string(key)] = struct{}{}
+	trk.dependencies[key] = struct{}{}
 }
 
 func (trk *stateTracker) setState(key, value []byte) {
-	key = concatBytes(trk.keyPrefix, key)
-	keyStr := string(key)
-	trk.changes[keyStr] = value
+	trk.changes[trackKey(trk.codeAddr, key)] = core.NewStateChange().
+		SetCodeAddr(trk.codeAddr).SetKey(key).SetValue(value)
+}
+
+// trackKey identifies key in the namespace of codeAddr
+func trackKey(codeAddr, key []byte) string {
+	return string(concatBytes([]byte{byte(len(codeAddr))}, codeAddr, key))
 }
 
 func concatBytes(srcs ...[]byte) []byte {
@@ -19,16 +19,21 @@ func newMapStateStore() *mapStateStore {
 	}
 }
 
-func (store *mapStateStore) VerifyState(key []byte) []byte {
-	return store.stateMap[string(key)]
+func (store *mapStateStore) VerifyState(codeAddr, key []byte) []byte {
+	return store.stateMap[trackKey(codeAddr, key)]
 }
 
-func (store *mapStateStore) GetState(key []byte) []byte {
-	return store.stateMap[string(key)]
+func (store *mapStateStore) GetState(codeAddr, key []byte) []byte {
+	return store.stateMap[trackKey(codeAddr, key)]
 }
 
+// SetState sets the state of system key
 func (store *mapStateStore) SetState(key, value []byte) {
-	store.stateMap[string(key)] = value
+	store.setCodeState(nil, key, value)
+}
+
+func (store *mapStateStore) setCodeState(codeAddr, key, value []byte) {
+	store.stateMap[trackKey(codeAddr, key)] = value
 }
 
 func TestStateTracker_GetState(t *testing.T) {
@@ -103,15 +108,16 @@ func TestStateTracker_Merge(t *testing.T) {
 	assert.Equal(2, len(scList))
 }
 
-func TestStateTracker_WithPrefix(t *testing.T) {
+func TestStateTracker_CodeAddr(t *testing.T) {
 	assert := assert.New(t)
 
 	ms := newMapStateStore()
+	ms.setCodeState([]byte{1}, []byte{1}, []byte{50})
 	trk := newStateTracker(ms, nil)
-	trk.SetState([]byte{1, 1}, []byte{50})
+	trk.SetState([]byte{1, 1}, []byte{60})
 
 	trkChild := trk.spawn([]byte{1})
-	assert.Equal([]byte{50}, trkChild.GetState([]byte{1}))
+	assert.Equal([]byte{50}, trkChild.GetState([]byte{1}), "not a flat key of parent")
 
 	trkChild.SetState([]byte{1}, []byte{10})
 	trkChild.SetState([]byte{2}, []byte{20})
@@ -120,8 +126,18 @@ func TestStateTracker_WithPrefix(t *testing.T) {
 
 	scList := trkChild.getStateChanges()
 	assert.Equal(2, len(scList))
+	for _, sc := range scList {
+		assert.Equal([]byte{1}, sc.CodeAddr())
+	}
+
+	// another code writes the same key
+	trkOther := trk.spawn([]byte{2})
+	trkOther.SetState([]byte{1}, []byte{30})
 
 	trk.merge(trkChild)
-	assert.Equal([]byte{10}, trk.GetState([]byte{1, 1}))
-	assert.Equal([]byte{20}, trk.GetState([]byte{1, 2}))
+	trk.merge(trkOther)
+	assert.Equal([]byte{60}, trk.GetState([]byte{1, 1}))
+	assert.Equal([]byte{10}, trk.getCodeState([]byte{1}, []byte{1}))
+	assert.Equal([]byte{30}, trk.getCodeState([]byte{2}, []byte{1}))
+	assert.Equal(4, len(trk.getStateChanges()))
 }
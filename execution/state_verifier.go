@@ -7,18 +7,39 @@ package execution
 // it calls the VerifyState of state store instead of GetState
 // to verify the state value with the merkle root
 type stateVerifier struct {
-	store     StateStore
-	keyPrefix []byte
+	store    StateStore
+	codeAddr []byte
 }
 
-func newStateVerifier(store StateStore, prefix []byte) *stateVerifier {
+func newStateVerifier(store StateStore, codeAddr []byte) *stateVerifier {
 	return &stateVerifier{
-		store:     store,
-		keyPrefix: prefix,
+		store:    store,
+		codeAddr: codeAddr,
 	}
 }
 
 func (sv *stateVerifier) GetState(key []byte) []byte {
-	key = concatBytes(sv.keyPrefix, key)
-	return sv.store.VerifyState(key)
+	return sv.store.VerifyState(sv.codeAddr, key)
+}
+
+// stateAtHeight reads the state versions for historical queries,
+// they can't be verified with the current merkle root.
+// Errors are panics, which are recovered as the query error.
+type stateAtHeight struct {
+	store  HistoricalStateStore
+	height uint64
+}
+
+var _ StateStore = (*stateAtHeight)(nil)
+
+func (sh *stateAtHeight) GetState(codeAddr, key []byte) []byte {
+	value, err := sh.store.GetStateAtHeight(codeAddr, key, sh.height)
+	if err != nil {
+		panic(err)
+	}
+	return value
+}
+
+func (sh *stateAtHeight) VerifyState(codeAddr, key []byte) []byte {
+	return sh.GetState(codeAddr, key)
 }
@@ -75,7 +75,7 @@ func (txe *txExecutor) executeChaincode() (err error) {
 
 func (txe *txExecutor) executeDeployment() error {
 	input := new(DeploymentInput)
-	err := json.Unmarshal(txe.tx.Input(), input)
+	err := json.Unmarshal(txe.tx.InputRef(), input)
 	if err != nil {
 		return err
 	}
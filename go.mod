@@ -7,11 +7,13 @@ require (
 	github.com/fatih/color v1.12.0
 	github.com/gin-gonic/gin v1.7.2
 	github.com/go-playground/validator/v10 v10.6.1 // indirect
-	github.com/golang/protobuf v1.5.2 // indirect
+	github.com/golang/snappy v0.0.1
 	github.com/json-iterator/go v1.1.11 // indirect
+	github.com/kilic/bls12-381 v0.1.0
 	github.com/leodido/go-urn v1.2.1 // indirect
 	github.com/libp2p/go-libp2p v0.13.0
 	github.com/libp2p/go-libp2p-core v0.8.5
+	github.com/libp2p/go-libp2p-noise v0.1.1
 	github.com/mattn/go-isatty v0.0.13 // indirect
 	github.com/multiformats/go-multiaddr v0.3.1
 	github.com/spf13/cobra v1.1.3
@@ -22,6 +24,8 @@ require (
 	golang.org/x/crypto v0.0.0-20210513164829-c07d793c2f9a
 	golang.org/x/sys v0.0.0-20210525143221-35b2ab0089ea // indirect
 	golang.org/x/text v0.3.6 // indirect
-	google.golang.org/protobuf v1.26.0
+	google.golang.org/grpc v1.46.2
+	google.golang.org/protobuf v1.27.1
+	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c
 	gotest.tools v2.2.0+incompatible
 )
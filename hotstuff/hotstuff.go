@@ -7,6 +7,8 @@ package hotstuff
 
 import (
 	"log"
+
+	"github.com/aungmawjj/juria-blockchain/hotstuff/rules"
 )
 
 // Hotstuff consensus engine
@@ -41,8 +43,7 @@ func (hs *Hotstuff) OnReceiveVote(v Vote) {
 	if err != nil {
 		return
 	}
-	if hs.GetVoteCount() >= hs.driver.MajorityCount() {
-		votes := hs.GetVotes()
+	if votes := hs.GetVotes(); hs.driver.HasMajority(votes) {
 		hs.endProposal()
 		hs.UpdateQCHigh(hs.driver.CreateQC(votes))
 	}
@@ -59,53 +60,38 @@ func (hs *Hotstuff) OnReceiveProposal(bNew Block) {
 
 // CanVote returns true if the hotstuff instance can vote the given block
 func (hs *Hotstuff) CanVote(bNew Block) bool {
-	if CmpBlockHeight(bNew, hs.GetBVote()) == 1 {
-		return hs.CheckSafetyRule(bNew) || hs.CheckLivenessRule(bNew)
-	}
-	return false
+	return rules.CanVote(rules.State{
+		BVote: hs.GetBVote(),
+		BLock: hs.GetBLock(),
+	}, bNew)
 }
 
 // CheckSafetyRule returns true if the given block extends from b_Lock
 func (hs *Hotstuff) CheckSafetyRule(bNew Block) bool {
-	bLock := hs.GetBLock()
-	for b := bNew; CmpBlockHeight(b, bLock) != -1; b = b.Parent() {
-		if bLock.Equal(b) {
-			return true
-		}
-	}
-	return false
+	return rules.CheckSafetyRule(hs.GetBLock(), bNew)
 }
 
 // CheckLivenessRule returns true if the qc referenced block of the given block is higher than b_Lock
 func (hs *Hotstuff) CheckLivenessRule(bNew Block) bool {
-	return CmpBlockHeight(bNew.Justify().Block(), hs.GetBLock()) == 1
+	return rules.CheckLivenessRule(hs.GetBLock(), bNew)
 }
 
 // Update perform three chain consensus phases
 func (hs *Hotstuff) Update(bNew Block) {
-	b, b1, b2 := GetJustifyBlocks(bNew)
 	hs.UpdateQCHigh(bNew.Justify()) // precommit phase for b2
 
-	if CmpBlockHeight(b1, hs.GetBLock()) == 1 {
-		hs.setBLock(b1) // commit phase for b1
-
-		if IsThreeChain(b, b1, b2) {
-			hs.onCommit(b) // decide phase for b
-			hs.setBExec(b)
-		}
+	d, err := rules.Update(rules.State{
+		BLock: hs.GetBLock(),
+		BExec: hs.GetBExec(),
+	}, bNew)
+	if err != nil {
+		log.Fatalf("%+v, block: %d", err, bNew.Height())
 	}
-}
-
-func (hs *Hotstuff) onCommit(b Block) {
-	if CmpBlockHeight(b, hs.GetBExec()) == 1 {
-		// commit parent blocks recurrsively
-		hs.onCommit(b.Parent())
+	hs.setBLock(d.BLock)
+	for _, b := range d.Commits {
 		hs.driver.Commit(b)
-
-	} else if !hs.GetBExec().Equal(b) {
-		log.Fatalf("hotstuff safety breached b-recurrsive: %+v\n bexec: %d",
-			b, hs.GetBExec().Height())
 	}
+	hs.setBExec(d.BExec)
 }
 
 // UpdateQCHigh replaces qcHigh if the block of given qc is higher than the qcHigh block
@@ -119,20 +105,10 @@ func (hs *Hotstuff) UpdateQCHigh(qc QC) {
 
 // GetJustifyBlocks returns justify referenced blocks
 func GetJustifyBlocks(bNew Block) (b, b1, b2 Block) {
-	if b2 = bNew.Justify().Block(); b2 == nil {
-		return b, b1, b2
-	}
-	if b1 = b2.Justify().Block(); b1 == nil {
-		return b, b1, b2
-	}
-	b = b1.Justify().Block()
-	return b, b1, b2
+	return rules.GetJustifyBlocks(bNew)
 }
 
 // IsThreeChain checks whether the blocks satisfy three chain rule
 func IsThreeChain(b, b1, b2 Block) bool {
-	if b == nil || b1 == nil || b2 == nil {
-		return false
-	}
-	return b1.Equal(b2.Parent()) && b.Equal(b1.Parent())
+	return rules.IsThreeChain(b, b1, b2)
 }
@@ -92,7 +92,7 @@ func TestHotstuff_SuccessfulPropose(t *testing.T) {
 	assert.Equal(b1, hs.GetBLeaf())
 	assert.True(hs.IsProposing())
 
-	driver.On("MajorityCount").Return(3)
+	driver.On("HasMajority", mock.Anything).Return(false)
 
 	v1 := newMockVote(b1, "r1")
 	hs.OnReceiveVote(v1)
@@ -133,7 +133,7 @@ func TestHotstuff_OnReceiveVote(t *testing.T) {
 	driver.On("CreateLeaf", b0, q0, b0.Height()+1).Once().Return(b1)
 	driver.On("BroadcastProposal", b1).Once()
 	hs.OnPropose()
-	driver.On("MajorityCount").Return(2)
+	driver.On("HasMajority", mock.Anything).Once().Return(false)
 
 	v1 := newMockVote(b1, "r1")
 	hs.OnReceiveVote(v1)
@@ -147,6 +147,7 @@ func TestHotstuff_OnReceiveVote(t *testing.T) {
 	driver.AssertNotCalled(t, "CreateQC")
 	assert.Equal(1, hs.GetVoteCount())
 
+	driver.On("HasMajority", mock.Anything).Once().Return(true)
 	driver.On("CreateQC", mock.Anything).Return(q1)
 
 	v2 := newMockVote(b1, "r2")
@@ -0,0 +1,155 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+// package rules implements the hotstuff voting, locking and commit rules
+// as pure functions over a block tree, without any storage or networking
+
+package rules
+
+import (
+	"errors"
+	"fmt"
+)
+
+// errors
+var (
+	ErrSafetyBreach = errors.New("hotstuff safety breached")
+)
+
+// Block type
+type Block interface {
+	Height() uint64
+	Parent() Block
+	Equal(blk Block) bool
+	Justify() QC
+}
+
+// QC type
+type QC interface {
+	Block() Block
+}
+
+// State is the part of replica state the rules depend on
+type State struct {
+	BVote Block // last voted block
+	BLock Block // locked block
+	BExec Block // last committed block
+}
+
+// Decision is the result of processing a new block
+type Decision struct {
+	BLock Block // lock after the update, same as before if not changed
+
+	BExec Block // last committed block after the update
+
+	// Commits are the newly committed blocks in ascending height order
+	Commits []Block
+}
+
+// CmpBlockHeight compares two blocks by height
+func CmpBlockHeight(b1, b2 Block) int {
+	if b1 == nil && b2 == nil {
+		return 0
+	}
+	if b1 == nil {
+		return -1
+	}
+	if b2 == nil {
+		return 1
+	}
+	if b1.Height() == b2.Height() {
+		return 0
+	}
+	if b1.Height() > b2.Height() {
+		return 1
+	}
+	return -1
+}
+
+// CanVote returns true if a replica with the given state can vote the new block
+func CanVote(s State, bNew Block) bool {
+	if CmpBlockHeight(bNew, s.BVote) == 1 {
+		return CheckSafetyRule(s.BLock, bNew) || CheckLivenessRule(s.BLock, bNew)
+	}
+	return false
+}
+
+// CheckSafetyRule returns true if the given block extends from bLock
+func CheckSafetyRule(bLock, bNew Block) bool {
+	return Extends(bNew, bLock)
+}
+
+// CheckLivenessRule returns true if the qc referenced block of the given block is higher than bLock
+func CheckLivenessRule(bLock, bNew Block) bool {
+	return CmpBlockHeight(bNew.Justify().Block(), bLock) == 1
+}
+
+// Extends returns true if b is the same as or a descendant of ancestor
+func Extends(b, ancestor Block) bool {
+	for ; CmpBlockHeight(b, ancestor) != -1; b = b.Parent() {
+		if ancestor.Equal(b) {
+			return true
+		}
+	}
+	return false
+}
+
+// Update decides the lock and commits after receiving the new block.
+// The new block's qc must have been processed by the caller (precommit phase).
+func Update(s State, bNew Block) (Decision, error) {
+	d := Decision{BLock: s.BLock, BExec: s.BExec}
+	b, b1, b2 := GetJustifyBlocks(bNew)
+
+	if CmpBlockHeight(b1, s.BLock) != 1 {
+		return d, nil
+	}
+	d.BLock = b1 // commit phase for b1
+
+	if !IsThreeChain(b, b1, b2) {
+		return d, nil
+	}
+	commits, err := CommitChain(s.BExec, b) // decide phase for b
+	if err != nil {
+		return d, err
+	}
+	d.BExec = b
+	d.Commits = commits
+	return d, nil
+}
+
+// CommitChain returns the blocks from bExec (exclusive) to b (inclusive) in ascending order.
+// It fails if b does not extend bExec.
+func CommitChain(bExec, b Block) ([]Block, error) {
+	var commits []Block
+	for ; CmpBlockHeight(b, bExec) == 1; b = b.Parent() {
+		commits = append(commits, b)
+	}
+	if b == nil || !bExec.Equal(b) {
+		return nil, fmt.Errorf("%w, block does not extend bExec %d",
+			ErrSafetyBreach, bExec.Height())
+	}
+	for i, j := 0, len(commits)-1; i < j; i, j = i+1, j-1 {
+		commits[i], commits[j] = commits[j], commits[i]
+	}
+	return commits, nil
+}
+
+// GetJustifyBlocks returns justify referenced blocks
+func GetJustifyBlocks(bNew Block) (b, b1, b2 Block) {
+	if b2 = bNew.Justify().Block(); b2 == nil {
+		return b, b1, b2
+	}
+	if b1 = b2.Justify().Block(); b1 == nil {
+		return b, b1, b2
+	}
+	b = b1.Justify().Block()
+	return b, b1, b2
+}
+
+// IsThreeChain checks whether the blocks satisfy three chain rule
+func IsThreeChain(b, b1, b2 Block) bool {
+	if b == nil || b1 == nil || b2 == nil {
+		return false
+	}
+	return b1.Equal(b2.Parent()) && b.Equal(b1.Parent())
+}
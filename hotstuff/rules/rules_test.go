@@ -0,0 +1,313 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package rules
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testBlock struct {
+	height uint64
+	parent *testBlock
+	qc     *testQC
+}
+
+var _ Block = (*testBlock)(nil)
+
+func (b *testBlock) Height() uint64 { return b.height }
+
+func (b *testBlock) Parent() Block {
+	if b.parent == nil {
+		return nil
+	}
+	return b.parent
+}
+
+func (b *testBlock) Equal(blk Block) bool {
+	other, ok := blk.(*testBlock)
+	return ok && other == b
+}
+
+func (b *testBlock) Justify() QC { return b.qc }
+
+type testQC struct {
+	blk *testBlock
+}
+
+var _ QC = (*testQC)(nil)
+
+func (q *testQC) Block() Block {
+	if q.blk == nil {
+		return nil
+	}
+	return q.blk
+}
+
+func newGenesis() (*testBlock, *testQC) {
+	b0 := &testBlock{height: 0}
+	q0 := &testQC{b0}
+	b0.qc = q0
+	return b0, q0
+}
+
+func newTestBlock(parent *testBlock, qc *testQC) *testBlock {
+	return &testBlock{height: parent.height + 1, parent: parent, qc: qc}
+}
+
+// chain with a qc for every block
+type testChain struct {
+	blocks []*testBlock
+	qcs    []*testQC
+}
+
+// extend appends count blocks, each justified by the qc of its parent
+func (c *testChain) extend(count int) *testChain {
+	for i := 0; i < count; i++ {
+		last := len(c.blocks) - 1
+		b := newTestBlock(c.blocks[last], c.qcs[last])
+		c.blocks = append(c.blocks, b)
+		c.qcs = append(c.qcs, &testQC{b})
+	}
+	return c
+}
+
+func newTestChain(b0 *testBlock, q0 *testQC, count int) *testChain {
+	c := &testChain{
+		blocks: []*testBlock{b0},
+		qcs:    []*testQC{q0},
+	}
+	return c.extend(count)
+}
+
+// fork returns a new chain sharing blocks up to height
+func (c *testChain) fork(height int) *testChain {
+	return &testChain{
+		blocks: append([]*testBlock{}, c.blocks[:height+1]...),
+		qcs:    append([]*testQC{}, c.qcs[:height+1]...),
+	}
+}
+
+func TestCmpBlockHeight(t *testing.T) {
+	b0, _ := newGenesis()
+	b1 := newTestBlock(b0, nil)
+
+	tests := []struct {
+		name   string
+		b1, b2 Block
+		want   int
+	}{
+		{"both nil", nil, nil, 0},
+		{"b1 nil", nil, b0, -1},
+		{"b2 nil", b0, nil, 1},
+		{"equal", b0, b0, 0},
+		{"higher", b1, b0, 1},
+		{"lower", b0, b1, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, CmpBlockHeight(tt.b1, tt.b2))
+		})
+	}
+}
+
+func TestExtends(t *testing.T) {
+	b0, q0 := newGenesis()
+	main := newTestChain(b0, q0, 4)
+	fork := main.fork(1).extend(3)
+
+	tests := []struct {
+		name     string
+		b        Block
+		ancestor Block
+		want     bool
+	}{
+		{"same block", main.blocks[2], main.blocks[2], true},
+		{"parent", main.blocks[3], main.blocks[2], true},
+		{"genesis", main.blocks[4], b0, true},
+		{"common ancestor", fork.blocks[4], main.blocks[1], true},
+		{"conflicting same height", fork.blocks[2], main.blocks[2], false},
+		{"conflicting lower", fork.blocks[4], main.blocks[2], false},
+		{"descendant", main.blocks[2], main.blocks[3], false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Extends(tt.b, tt.ancestor))
+		})
+	}
+}
+
+func TestCanVote(t *testing.T) {
+	b0, q0 := newGenesis()
+	main := newTestChain(b0, q0, 5)
+	mb := main.blocks
+
+	// fork below the lock, justified by old qc
+	forkLow := main.fork(1).extend(4)
+	forkLow.blocks[5].qc = main.qcs[1]
+
+	// fork with a qc higher than the lock
+	forkHigh := main.fork(1)
+	forkHigh.blocks = append(forkHigh.blocks, newTestBlock(mb[1], main.qcs[3]))
+
+	// competing block with equal height
+	competing := newTestBlock(mb[4], main.qcs[3])
+
+	tests := []struct {
+		name  string
+		state State
+		bNew  Block
+		want  bool
+	}{
+		{"extends lock", State{BVote: mb[2], BLock: mb[1]}, mb[3], true},
+		{"extends lock after gap", State{BVote: mb[1], BLock: mb[1]}, mb[5], true},
+		{"not higher than bVote", State{BVote: mb[3], BLock: mb[1]}, mb[3], false},
+		{"lower than bVote", State{BVote: mb[4], BLock: mb[1]}, mb[3], false},
+		{"equal height competing", State{BVote: mb[5], BLock: mb[3]}, competing, false},
+		{"competing before vote", State{BVote: mb[4], BLock: mb[3]}, competing, true},
+		{"fork below lock", State{BVote: mb[4], BLock: mb[2]}, forkLow.blocks[5], false},
+		{"fork with higher qc", State{BVote: mb[1], BLock: mb[2]}, forkHigh.blocks[2], true},
+		{"fork with qc equal to lock", State{BVote: mb[1], BLock: mb[3]}, forkHigh.blocks[2], false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, CanVote(tt.state, tt.bNew))
+		})
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	b0, q0 := newGenesis()
+	main := newTestChain(b0, q0, 6)
+	mb, mq := main.blocks, main.qcs
+
+	// b3 skips qc for b2, so b1 <- b3 is not a direct chain
+	gap := main.fork(2)
+	gb3 := newTestBlock(gap.blocks[2], mq[1])
+	gap.blocks = append(gap.blocks, gb3)
+	gap.qcs = append(gap.qcs, &testQC{gb3})
+	gap.extend(3)
+
+	// conflicting chain forked from b1
+	fork := main.fork(1).extend(5)
+
+	tests := []struct {
+		name    string
+		state   State
+		bNew    Block
+		bLock   Block
+		bExec   Block
+		commits []Block
+		err     error
+	}{
+		{"one chain", State{BLock: b0, BExec: b0}, mb[2], b0, b0, nil, nil},
+		{"two chain locks", State{BLock: b0, BExec: b0}, mb[3], mb[1], b0, nil, nil},
+		{"three chain commits", State{BLock: mb[1], BExec: b0}, mb[4], mb[2], mb[1], []Block{mb[1]}, nil},
+		{"commits skipped ancestors", State{BLock: mb[1], BExec: b0}, mb[6], mb[4], mb[3],
+			[]Block{mb[1], mb[2], mb[3]}, nil},
+		{"qc out of order", State{BLock: mb[3], BExec: mb[2]}, mb[4], mb[3], mb[2], nil, nil},
+		{"qc equal to lock", State{BLock: mb[2], BExec: mb[1]}, mb[4], mb[2], mb[1], nil, nil},
+		{"not a direct chain", State{BLock: mb[1], BExec: b0}, gap.blocks[5], gap.blocks[3], b0, nil, nil},
+		{"direct chain after gap", State{BLock: mb[1], BExec: b0}, gap.blocks[6], gap.blocks[4],
+			gap.blocks[3], []Block{mb[1], mb[2], gap.blocks[3]}, nil},
+		{"lock switch to fork", State{BLock: mb[1], BExec: b0}, fork.blocks[4], fork.blocks[2], mb[1],
+			[]Block{mb[1]}, nil},
+		{"conflicting commit", State{BLock: mb[1], BExec: mb[2]}, fork.blocks[5], fork.blocks[3],
+			mb[2], nil, ErrSafetyBreach},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+			d, err := Update(tt.state, tt.bNew)
+			assert.ErrorIs(err, tt.err)
+			assert.Equal(tt.bLock, d.BLock)
+			assert.Equal(tt.bExec, d.BExec)
+			assert.Equal(tt.commits, d.Commits)
+		})
+	}
+}
+
+func TestCommitChain(t *testing.T) {
+	b0, q0 := newGenesis()
+	main := newTestChain(b0, q0, 3)
+	fork := main.fork(1).extend(2)
+	orphan := &testBlock{height: 2, qc: q0}
+
+	tests := []struct {
+		name    string
+		bExec   Block
+		b       Block
+		commits []Block
+		err     error
+	}{
+		{"same block", main.blocks[2], main.blocks[2], nil, nil},
+		{"one block", main.blocks[2], main.blocks[3], []Block{main.blocks[3]}, nil},
+		{"many blocks", b0, main.blocks[3], []Block{main.blocks[1], main.blocks[2], main.blocks[3]}, nil},
+		{"lower block", main.blocks[3], main.blocks[2], nil, ErrSafetyBreach},
+		{"conflicting block", main.blocks[2], fork.blocks[3], nil, ErrSafetyBreach},
+		{"conflicting same height", main.blocks[2], fork.blocks[2], nil, ErrSafetyBreach},
+		{"missing parent", b0, orphan, nil, ErrSafetyBreach},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+			commits, err := CommitChain(tt.bExec, tt.b)
+			assert.ErrorIs(err, tt.err)
+			assert.Equal(tt.commits, commits)
+		})
+	}
+}
+
+func TestGetJustifyBlocks(t *testing.T) {
+	b0, q0 := newGenesis()
+	main := newTestChain(b0, q0, 3)
+	mb := main.blocks
+	noQC := &testBlock{height: 1, parent: b0, qc: &testQC{}}
+	qcNoQC := newTestBlock(noQC, &testQC{noQC})
+
+	tests := []struct {
+		name      string
+		bNew      Block
+		b, b1, b2 Block
+	}{
+		{"nil qc block", noQC, nil, nil, nil},
+		{"nil qc block of b2", qcNoQC, nil, nil, noQC},
+		{"three blocks", mb[3], b0, mb[1], mb[2]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+			b, b1, b2 := GetJustifyBlocks(tt.bNew)
+			assert.Equal(tt.b, b)
+			assert.Equal(tt.b1, b1)
+			assert.Equal(tt.b2, b2)
+		})
+	}
+}
+
+func TestIsThreeChain(t *testing.T) {
+	b0, q0 := newGenesis()
+	main := newTestChain(b0, q0, 3)
+	mb := main.blocks
+	fork := main.fork(1).extend(2)
+
+	tests := []struct {
+		name      string
+		b, b1, b2 Block
+		want      bool
+	}{
+		{"nil b", nil, mb[1], mb[2], false},
+		{"nil b1", b0, nil, mb[2], false},
+		{"nil b2", b0, mb[1], nil, false},
+		{"direct chain", mb[1], mb[2], mb[3], true},
+		{"b2 not child of b1", mb[1], mb[2], fork.blocks[3], false},
+		{"b1 not child of b", b0, mb[2], mb[3], false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, IsThreeChain(tt.b, tt.b1, tt.b2))
+		})
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package rules
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// simulation of n replicas where f replicas are byzantine and vote for any block.
+// proposals are made by arbitrary (possibly byzantine) leaders
+// and delivered to honest replicas randomly, out of order or never.
+type safetySim struct {
+	t      *testing.T
+	rnd    *rand.Rand
+	faulty int
+	quorum int
+
+	blocks []*testBlock
+	qcs    []*testQC
+	votes  map[*testBlock]int // honest votes
+
+	replicas  []State
+	inboxes   [][]*testBlock
+	committed map[uint64]Block // oracle of committed blocks by height
+}
+
+func newSafetySim(t *testing.T, seed int64, honest, faulty int) *safetySim {
+	b0, q0 := newGenesis()
+	sim := &safetySim{
+		t:         t,
+		rnd:       rand.New(rand.NewSource(seed)),
+		faulty:    faulty,
+		quorum:    2*faulty + 1,
+		blocks:    []*testBlock{b0},
+		qcs:       []*testQC{q0},
+		votes:     make(map[*testBlock]int),
+		replicas:  make([]State, honest),
+		inboxes:   make([][]*testBlock, honest),
+		committed: map[uint64]Block{0: b0},
+	}
+	for i := range sim.replicas {
+		sim.replicas[i] = State{BVote: b0, BLock: b0, BExec: b0}
+	}
+	return sim
+}
+
+// propose creates a block justified by a random qc, extending the qc block or one of its descendants
+func (sim *safetySim) propose() {
+	qc := sim.pickQC()
+	var candidates []*testBlock
+	for _, b := range sim.blocks {
+		if Extends(b, qc.blk) {
+			candidates = append(candidates, b)
+		}
+	}
+	var parent *testBlock
+	switch sim.rnd.Intn(3) {
+	case 0:
+		parent = qc.blk
+	case 1:
+		parent = candidates[sim.rnd.Intn(len(candidates))]
+	default:
+		// highest descendant, possibly on a fork conflicting with the locks
+		for _, b := range candidates {
+			if parent == nil || b.height > parent.height ||
+				b.height == parent.height && sim.rnd.Intn(2) == 0 {
+				parent = b
+			}
+		}
+	}
+	bNew := newTestBlock(parent, qc)
+	sim.blocks = append(sim.blocks, bNew)
+	// deliver to a random subset of replicas, which may be enough for a qc
+	for _, i := range sim.rnd.Perm(len(sim.inboxes))[:1+sim.rnd.Intn(len(sim.inboxes))] {
+		sim.inboxes[i] = append(sim.inboxes[i], bNew)
+	}
+}
+
+// pickQC returns a random qc, mostly from the tips of qc chains to keep competing forks growing
+func (sim *safetySim) pickQC() *testQC {
+	if sim.rnd.Intn(3) == 0 {
+		return sim.qcs[sim.rnd.Intn(len(sim.qcs))]
+	}
+	var tips []*testQC
+	for _, qc := range sim.qcs {
+		tip := true
+		for _, other := range sim.qcs {
+			if other != qc && Extends(other.blk, qc.blk) {
+				tip = false
+				break
+			}
+		}
+		if tip {
+			tips = append(tips, qc)
+		}
+	}
+	return tips[sim.rnd.Intn(len(tips))]
+}
+
+// deliver processes a random pending proposal of a random replica
+func (sim *safetySim) deliver() {
+	i := sim.rnd.Intn(len(sim.replicas))
+	inbox := sim.inboxes[i]
+	if len(inbox) == 0 {
+		return
+	}
+	j := 0 // mostly in order
+	if sim.rnd.Intn(3) == 0 {
+		j = sim.rnd.Intn(len(inbox))
+	}
+	bNew := inbox[j]
+	sim.inboxes[i] = append(inbox[:j], inbox[j+1:]...)
+
+	s := sim.replicas[i]
+	if CanVote(s, bNew) {
+		s.BVote = bNew
+		sim.vote(bNew)
+	}
+	d, err := Update(s, bNew)
+	if err != nil {
+		sim.t.Fatalf("replica %d: %+v", i, err)
+	}
+	for _, b := range d.Commits {
+		sim.commit(b)
+	}
+	s.BLock, s.BExec = d.BLock, d.BExec
+	sim.replicas[i] = s
+}
+
+func (sim *safetySim) vote(b *testBlock) {
+	sim.votes[b]++
+	if sim.votes[b]+sim.faulty == sim.quorum {
+		sim.qcs = append(sim.qcs, &testQC{b})
+	}
+}
+
+func (sim *safetySim) commit(b Block) {
+	if prev, found := sim.committed[b.Height()]; found && !prev.Equal(b) {
+		sim.t.Fatalf("conflicting blocks committed at height %d", b.Height())
+	}
+	if !Extends(b.Parent(), sim.committed[b.Height()-1]) {
+		sim.t.Fatalf("committed block %d does not extend committed parent", b.Height())
+	}
+	sim.committed[b.Height()] = b
+}
+
+func (sim *safetySim) run(steps int) {
+	for i := 0; i < steps; i++ {
+		if sim.rnd.Intn(6) == 0 {
+			sim.propose()
+		} else {
+			sim.deliver()
+		}
+	}
+}
+
+func TestSafety_RandomSequences(t *testing.T) {
+	seeds := 300
+	if testing.Short() {
+		seeds = 30
+	}
+	commits := 0
+	for seed := int64(0); seed < int64(seeds); seed++ {
+		for _, faulty := range []int{1, 2} {
+			sim := newSafetySim(t, seed, 2*faulty+1, faulty)
+			sim.run(500)
+			commits += len(sim.committed) - 1
+		}
+	}
+	if commits == 0 {
+		t.Fatal("no block committed in simulations")
+	}
+	t.Logf("%d blocks committed", commits)
+}
@@ -3,18 +3,13 @@
 
 package hotstuff
 
+import "github.com/aungmawjj/juria-blockchain/hotstuff/rules"
+
 // Block type
-type Block interface {
-	Height() uint64
-	Parent() Block
-	Equal(blk Block) bool
-	Justify() QC
-}
+type Block = rules.Block
 
 // QC type
-type QC interface {
-	Block() Block
-}
+type QC = rules.QC
 
 // Vote type
 type Vote interface {
@@ -24,7 +19,7 @@ type Vote interface {
 
 // Driver godoc
 type Driver interface {
-	MajorityCount() int
+	HasMajority(votes []Vote) bool
 	CreateLeaf(parent Block, qc QC, height uint64) Block
 	CreateQC(votes []Vote) QC
 	BroadcastProposal(blk Block)
@@ -34,20 +29,5 @@ type Driver interface {
 
 // CmpBlockHeight compares two blocks by height
 func CmpBlockHeight(b1, b2 Block) int {
-	if b1 == nil && b2 == nil {
-		return 0
-	}
-	if b1 == nil {
-		return -1
-	}
-	if b2 == nil {
-		return 1
-	}
-	if b1.Height() == b2.Height() {
-		return 0
-	}
-	if b1.Height() > b2.Height() {
-		return 1
-	}
-	return -1
+	return rules.CmpBlockHeight(b1, b2)
 }
@@ -133,9 +133,9 @@ type MockDriver struct {
 
 var _ Driver = (*MockDriver)(nil)
 
-func (m *MockDriver) MajorityCount() int {
-	args := m.Called()
-	return args.Int(0)
+func (m *MockDriver) HasMajority(votes []Vote) bool {
+	args := m.Called(votes)
+	return args.Bool(0)
 }
 
 func (m *MockDriver) CreateLeaf(parent Block, qc QC, height uint64) Block {
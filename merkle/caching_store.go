@@ -0,0 +1,241 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package merkle
+
+import (
+	"container/list"
+	"io"
+	"math/big"
+	"sync"
+)
+
+// UpdateCommitter is implemented by stores which commit tree updates by themselves, e.g MapStore.
+// CommitUpdate is atomic, the readers and snapshots of the store see all or none of the update.
+// Updates are computed and commited by one writer at a time.
+type UpdateCommitter interface {
+	CommitUpdate(res *UpdateResult)
+}
+
+// CachingStore keeps recently read nodes of the inner store in a bounded lru cache.
+// Updates must go through CommitUpdate to keep the cache consistent with the inner store.
+type CachingStore struct {
+	inner    Store
+	capacity int
+
+	mtx     sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // front is most recently used
+
+	// increased on every commit, nodes read before a commit are not cached after it
+	generation uint64
+
+	hits   uint64
+	misses uint64
+}
+
+type cacheEntry struct {
+	key  string
+	data []byte
+}
+
+var (
+	_ Store           = (*CachingStore)(nil)
+	_ RootStore       = (*CachingStore)(nil)
+	_ UpdateCommitter = (*CachingStore)(nil)
+	_ SnapshotStore   = (*CachingStore)(nil)
+)
+
+// NewCachingStore creates a new CachingStore, nothing is cached if capacity is not positive
+func NewCachingStore(inner Store, capacity int) *CachingStore {
+	return &CachingStore{
+		inner:    inner,
+		capacity: capacity,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// GetLeafCount implement Store
+func (cs *CachingStore) GetLeafCount() *big.Int {
+	return cs.inner.GetLeafCount()
+}
+
+// GetHeight implement Store
+func (cs *CachingStore) GetHeight() uint8 {
+	return cs.inner.GetHeight()
+}
+
+// GetRoot implement RootStore, it returns nil if the inner store doesn't keep roots
+func (cs *CachingStore) GetRoot(leafCount *big.Int) []byte {
+	if rs, ok := cs.inner.(RootStore); ok {
+		return rs.GetRoot(leafCount)
+	}
+	return nil
+}
+
+// GetNode implement Store
+func (cs *CachingStore) GetNode(p *Position) []byte {
+	return cs.GetNodes([]*Position{p})[0]
+}
+
+// GetNodes implement Store, only the missing nodes in cache are read from the inner store
+func (cs *CachingStore) GetNodes(positions []*Position) [][]byte {
+	cs.mtx.Lock()
+	generation := cs.generation
+	cs.mtx.Unlock()
+	return cs.getNodes(cs.inner, generation, positions)
+}
+
+// getNodes reads the cached nodes while the cache is at the generation and the others from the reader,
+// the nodes read are cached if no update is commited meanwhile
+func (cs *CachingStore) getNodes(reader NodeReader, generation uint64, positions []*Position) [][]byte {
+	values := make([][]byte, len(positions))
+	missing := make([]*Position, 0)
+	missingIdx := make([]int, 0)
+
+	cs.mtx.Lock()
+	for i, p := range positions {
+		if cs.generation == generation {
+			if e, ok := cs.entries[p.String()]; ok {
+				cs.order.MoveToFront(e)
+				values[i] = e.Value.(*cacheEntry).data
+				continue
+			}
+		}
+		missing = append(missing, p)
+		missingIdx = append(missingIdx, i)
+	}
+	cs.hits += uint64(len(positions) - len(missing))
+	cs.misses += uint64(len(missing))
+	cs.mtx.Unlock()
+
+	if len(missing) == 0 {
+		return values
+	}
+	loaded := reader.GetNodes(missing)
+	for i, data := range loaded {
+		values[missingIdx[i]] = data
+	}
+
+	cs.mtx.Lock()
+	defer cs.mtx.Unlock()
+	if generation != cs.generation {
+		return values // a commit may have changed the loaded nodes
+	}
+	for i, data := range loaded {
+		if data != nil {
+			cs.put(missing[i].String(), data)
+		}
+	}
+	return values
+}
+
+// CommitUpdate commits the update to the inner store if it is an UpdateCommitter,
+// and writes the updated nodes through the cache.
+// If the inner store is written elsewhere, call it after the write succeeds.
+func (cs *CachingStore) CommitUpdate(res *UpdateResult) {
+	cs.mtx.Lock()
+	defer cs.mtx.Unlock()
+
+	if c, ok := cs.inner.(UpdateCommitter); ok {
+		c.CommitUpdate(res)
+	}
+	cs.generation++
+	for _, n := range res.Leaves {
+		cs.update(n)
+	}
+	for _, n := range res.Branches {
+		cs.update(n)
+	}
+}
+
+// Snapshot implement SnapshotStore with the snapshot of the inner store,
+// the store itself is returned if the inner store doesn't take snapshots.
+// The cached nodes are read until the next commit.
+// If the inner store is written elsewhere, the snapshots taken between the write and CommitUpdate
+// would read the cached nodes with the written ones, so the writer excludes the readers until CommitUpdate.
+func (cs *CachingStore) Snapshot() NodeReader {
+	ss, ok := cs.inner.(SnapshotStore)
+	if !ok {
+		return cs
+	}
+	cs.mtx.Lock()
+	defer cs.mtx.Unlock()
+	return &cachingSnapshot{
+		NodeReader: ss.Snapshot(),
+		cache:      cs,
+		generation: cs.generation,
+	}
+}
+
+// cachingSnapshot reads the cache while no update is commited after the snapshot is taken
+type cachingSnapshot struct {
+	NodeReader
+	cache      *CachingStore
+	generation uint64
+}
+
+func (s *cachingSnapshot) GetNode(p *Position) []byte {
+	return s.GetNodes([]*Position{p})[0]
+}
+
+func (s *cachingSnapshot) GetNodes(positions []*Position) [][]byte {
+	return s.cache.getNodes(s.NodeReader, s.generation, positions)
+}
+
+// Close closes the inner snapshot if it is an io.Closer
+func (s *cachingSnapshot) Close() error {
+	if c, ok := s.NodeReader.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// update replaces the cached node, uncached nodes are not added
+func (cs *CachingStore) update(n *Node) {
+	if e, ok := cs.entries[n.Position.String()]; ok {
+		e.Value.(*cacheEntry).data = n.Data
+		cs.order.MoveToFront(e)
+	}
+}
+
+func (cs *CachingStore) put(key string, data []byte) {
+	if cs.capacity <= 0 {
+		return
+	}
+	if e, ok := cs.entries[key]; ok {
+		e.Value.(*cacheEntry).data = data
+		cs.order.MoveToFront(e)
+		return
+	}
+	cs.entries[key] = cs.order.PushFront(&cacheEntry{key, data})
+	for cs.order.Len() > cs.capacity {
+		last := cs.order.Back()
+		cs.order.Remove(last)
+		delete(cs.entries, last.Value.(*cacheEntry).key)
+	}
+}
+
+// Purge drops all cached nodes, call it after the inner store is written elsewhere
+func (cs *CachingStore) Purge() {
+	cs.mtx.Lock()
+	defer cs.mtx.Unlock()
+	cs.generation++
+	cs.entries = make(map[string]*list.Element)
+	cs.order.Init()
+}
+
+// Len returns the number of cached nodes
+func (cs *CachingStore) Len() int {
+	cs.mtx.Lock()
+	defer cs.mtx.Unlock()
+	return cs.order.Len()
+}
+
+// Stats returns the number of nodes found and not found in cache
+func (cs *CachingStore) Stats() (hits, misses uint64) {
+	cs.mtx.Lock()
+	defer cs.mtx.Unlock()
+	return cs.hits, cs.misses
+}
@@ -0,0 +1,107 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package merkle
+
+import (
+	"crypto"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCachingStore(t *testing.T) {
+	assert := assert.New(t)
+
+	inner := NewMapStore()
+	store := NewCachingStore(inner, 100)
+	config := Config{Hash: crypto.SHA1, BranchFactor: 3}
+	tree := NewTree(store, config)
+
+	store.CommitUpdate(tree.Update(makeLeaves(0, 20), big.NewInt(20)))
+	assert.Equal(0, store.Len(), "commit doesn't add uncached nodes")
+	assert.Equal(inner.GetLeafCount(), store.GetLeafCount())
+	assert.Equal(inner.GetHeight(), store.GetHeight())
+
+	// update reads the stored branches to compare
+	hits0, misses0 := store.Stats()
+	p := NewPosition(1, big.NewInt(2))
+	assert.Equal(inner.GetNode(p), store.GetNode(p))
+	assert.Equal(inner.GetNode(p), store.GetNode(p))
+	hits, misses := store.Stats()
+	assert.EqualValues(1, hits-hits0)
+	assert.EqualValues(1, misses-misses0)
+
+	assert.Nil(store.GetNode(NewPosition(0, big.NewInt(50))))
+	assert.Equal(1, store.Len(), "missing node is not cached")
+
+	assert.True(tree.Verify(makeLeaves(4, 8)))
+	assert.True(tree.Verify(makeLeaves(4, 8)))
+
+	// cached nodes must not be stale after update
+	leaf := &Node{NewPosition(0, big.NewInt(7)), []byte{100}}
+	cachedRoot := store.GetNode(tree.Root().Position)
+	store.CommitUpdate(tree.Update([]*Node{leaf}, big.NewInt(20)))
+	assert.Equal([]byte{100}, store.GetNode(leaf.Position))
+	assert.Equal(inner.GetNode(p), store.GetNode(p))
+	assert.NotEqual(cachedRoot, store.GetNode(tree.Root().Position))
+	assert.True(tree.Verify([]*Node{leaf}))
+	assert.False(tree.Verify(makeLeaves(7, 8)))
+
+	uncached := NewTree(NewMapStore(), config)
+	leaves := makeLeaves(0, 20)
+	leaves[7] = leaf
+	assert.Equal(uncached.Update(leaves, big.NewInt(20)).Root.Data, tree.Root().Data)
+}
+
+func TestCachingStore_ExternalCommit(t *testing.T) {
+	assert := assert.New(t)
+
+	inner := NewMapStore()
+	// inner store is committed outside of caching store
+	store := NewCachingStore(storeWithoutRoots{inner}, 100)
+	tree := NewTree(store, Config{Hash: crypto.SHA1, BranchFactor: 2})
+
+	inner.CommitUpdate(tree.Update(makeLeaves(0, 8), big.NewInt(8)))
+	p := NewPosition(0, big.NewInt(3))
+	assert.Equal([]byte{3}, store.GetNode(p))
+
+	res := tree.Update([]*Node{{p, []byte{30}}}, big.NewInt(8))
+	inner.CommitUpdate(res)
+	assert.Equal([]byte{3}, store.GetNode(p), "stale until commit through cache")
+	store.CommitUpdate(res)
+	assert.Equal([]byte{30}, store.GetNode(p))
+	assert.Nil(store.GetRoot(big.NewInt(8)), "inner store doesn't keep roots")
+
+	inner.CommitUpdate(tree.Update([]*Node{{p, []byte{31}}}, big.NewInt(8)))
+	store.Purge()
+	assert.Equal(0, store.Len())
+	assert.Equal([]byte{31}, store.GetNode(p))
+}
+
+func TestCachingStore_Capacity(t *testing.T) {
+	assert := assert.New(t)
+
+	inner := NewMapStore()
+	tree := NewTree(inner, Config{Hash: crypto.SHA1, BranchFactor: 2})
+	inner.CommitUpdate(tree.Update(makeLeaves(0, 10), big.NewInt(10)))
+
+	store := NewCachingStore(inner, 3)
+	positions := make([]*Position, 10)
+	for i := range positions {
+		positions[i] = NewPosition(0, big.NewInt(int64(i)))
+	}
+	assert.Equal(inner.GetNodes(positions), store.GetNodes(positions))
+	assert.Equal(3, store.Len())
+
+	// most recently used are kept
+	store.GetNodes(positions[7:])
+	hits, _ := store.Stats()
+	assert.EqualValues(3, hits)
+
+	disabled := NewCachingStore(inner, 0)
+	assert.Equal(inner.GetNodes(positions), disabled.GetNodes(positions))
+	assert.Equal(0, disabled.Len())
+	assert.Equal(inner.GetRoot(big.NewInt(10)), disabled.GetRoot(big.NewInt(10)))
+}
@@ -0,0 +1,155 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package merkle
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"sort"
+)
+
+// errors
+var (
+	ErrInvalidLeafCount = errors.New("invalid leaf count")
+	ErrNodeNotFound     = errors.New("node not found")
+)
+
+// ConsistencyProof proves that the tree with NewLeafCount leaves
+// is an append-only extension of the tree with OldLeafCount leaves.
+//
+// OldNodes are the largest complete subtrees covering the old leaves,
+// they are the same in both trees when the old leaves are unchanged.
+// NewNodes are the largest complete subtrees covering the appended leaves.
+type ConsistencyProof struct {
+	OldLeafCount *big.Int `json:"oldLeafCount"`
+	NewLeafCount *big.Int `json:"newLeafCount"`
+	OldNodes     []*Node  `json:"oldNodes"`
+	NewNodes     []*Node  `json:"newNodes"`
+}
+
+// ConsistencyProof creates the proof between the trees of the given leaf counts.
+// The nodes are loaded from the current tree, so the new leaf count must not exceed the current one.
+func (tree *Tree) ConsistencyProof(oldLeafCount, newLeafCount *big.Int) (*ConsistencyProof, error) {
+	if oldLeafCount == nil || newLeafCount == nil || oldLeafCount.Sign() != 1 {
+		return nil, ErrInvalidLeafCount
+	}
+	if oldLeafCount.Cmp(newLeafCount) == 1 || newLeafCount.Cmp(tree.store.GetLeafCount()) == 1 {
+		return nil, ErrInvalidLeafCount
+	}
+	proof := &ConsistencyProof{
+		OldLeafCount: oldLeafCount,
+		NewLeafCount: newLeafCount,
+	}
+	var err error
+	proof.OldNodes, err = tree.loadNodes(tree.calc.SubtreeCover(big.NewInt(0), oldLeafCount))
+	if err != nil {
+		return nil, err
+	}
+	proof.NewNodes, err = tree.loadNodes(tree.calc.SubtreeCover(oldLeafCount, newLeafCount))
+	if err != nil {
+		return nil, err
+	}
+	return proof, nil
+}
+
+func (tree *Tree) loadNodes(positions []*Position) ([]*Node, error) {
+	nodes := make([]*Node, len(positions))
+	for i, p := range positions {
+		data := tree.store.GetNode(p)
+		if data == nil {
+			return nil, ErrNodeNotFound
+		}
+		nodes[i] = &Node{p, data}
+	}
+	return nodes, nil
+}
+
+// VerifyConsistencyProof checks that the old nodes of the proof compute the old root,
+// and together with the new nodes compute the new root.
+func VerifyConsistencyProof(config Config, oldRoot, newRoot []byte, proof *ConsistencyProof) bool {
+	if len(oldRoot) == 0 || len(newRoot) == 0 || proof == nil {
+		return false
+	}
+	if proof.OldLeafCount == nil || proof.NewLeafCount == nil {
+		return false
+	}
+	if proof.OldLeafCount.Sign() != 1 || proof.OldLeafCount.Cmp(proof.NewLeafCount) == 1 {
+		return false
+	}
+	if config.BranchFactor < 2 {
+		config.BranchFactor = 2
+	}
+	if !config.Hash.Available() {
+		return false
+	}
+	calc := NewTreeCalc(config.BranchFactor)
+	if !matchPositions(proof.OldNodes, calc.SubtreeCover(big.NewInt(0), proof.OldLeafCount)) {
+		return false
+	}
+	if !matchPositions(proof.NewNodes, calc.SubtreeCover(proof.OldLeafCount, proof.NewLeafCount)) {
+		return false
+	}
+	root := rootFromSubtrees(config, calc, proof.OldNodes, proof.OldLeafCount)
+	if !bytes.Equal(oldRoot, root) {
+		return false
+	}
+	nodes := make([]*Node, 0, len(proof.OldNodes)+len(proof.NewNodes))
+	nodes = append(nodes, proof.OldNodes...)
+	nodes = append(nodes, proof.NewNodes...)
+	root = rootFromSubtrees(config, calc, nodes, proof.NewLeafCount)
+	return bytes.Equal(newRoot, root)
+}
+
+func matchPositions(nodes []*Node, positions []*Position) bool {
+	if len(nodes) != len(positions) {
+		return false
+	}
+	for i, n := range nodes {
+		if n == nil || n.Position == nil {
+			return false
+		}
+		if n.Position.String() != positions[i].String() {
+			return false
+		}
+	}
+	return true
+}
+
+// rootFromSubtrees computes the root of the tree from the subtree nodes covering all of its leaves
+func rootFromSubtrees(config Config, calc *TreeCalc, subtrees []*Node, leafCount *big.Int) []byte {
+	levels := make(map[uint8][]*Node)
+	for _, n := range subtrees {
+		levels[n.Position.Level()] = append(levels[n.Position.Level()], n)
+	}
+	height := calc.Height(leafCount)
+	var nodes []*Node
+	for i := uint8(0); i < height-1; i++ {
+		nodes = append(nodes, levels[i]...)
+		sort.Slice(nodes, func(a, b int) bool {
+			return nodes[a].Position.Index().Cmp(nodes[b].Position.Index()) == -1
+		})
+		parents := make([]*Node, 0, len(nodes))
+		var g *Group
+		for _, n := range nodes {
+			pIdx := calc.GroupOfNode(n.Position.Index())
+			if g == nil || g.parentPosition.Index().Cmp(pIdx) != 0 {
+				if g != nil {
+					parents = append(parents, g.MakeParent())
+				}
+				g = NewGroup(config.Hash, calc, nil, NewPosition(i+1, pIdx))
+			}
+			g.SetNode(n)
+		}
+		if g != nil {
+			parents = append(parents, g.MakeParent())
+		}
+		nodes = parents
+	}
+	nodes = append(nodes, levels[height-1]...)
+	if len(nodes) != 1 {
+		return nil
+	}
+	return nodes[0].Data
+}
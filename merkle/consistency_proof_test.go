@@ -0,0 +1,124 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package merkle
+
+import (
+	"crypto"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func makeLeaves(from, to int) []*Node {
+	leaves := make([]*Node, 0, to-from)
+	for i := from; i < to; i++ {
+		leaves = append(leaves, &Node{NewPosition(0, big.NewInt(int64(i))), []byte{uint8(i)}})
+	}
+	return leaves
+}
+
+func TestTree_ConsistencyProof(t *testing.T) {
+	tests := []struct {
+		name     string
+		bf       uint8
+		oldCount int
+		newCount int
+	}{
+		{"same tree", 2, 5, 5},
+		{"single old leaf", 2, 1, 9},
+		{"full old tree", 2, 4, 11},
+		{"partial old tree", 3, 10, 30},
+		{"one more leaf", 3, 9, 10},
+		{"large branch factor", 8, 70, 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+			store := NewMapStore()
+			config := Config{Hash: crypto.SHA1, BranchFactor: tt.bf}
+			tree := NewTree(store, config)
+
+			oldCount := big.NewInt(int64(tt.oldCount))
+			newCount := big.NewInt(int64(tt.newCount))
+			store.CommitUpdate(tree.Update(makeLeaves(0, tt.oldCount), oldCount))
+			oldRoot := tree.Root().Data
+
+			if tt.newCount > tt.oldCount {
+				store.CommitUpdate(tree.Update(makeLeaves(tt.oldCount, tt.newCount), newCount))
+			}
+			newRoot := tree.Root().Data
+
+			proof, err := tree.ConsistencyProof(oldCount, newCount)
+			assert.NoError(err)
+			assert.True(VerifyConsistencyProof(config, oldRoot, newRoot, proof))
+
+			if tt.newCount > tt.oldCount {
+				assert.False(VerifyConsistencyProof(config, newRoot, oldRoot, proof), "swapped roots")
+			}
+			assert.False(VerifyConsistencyProof(Config{Hash: crypto.SHA256, BranchFactor: tt.bf},
+				oldRoot, newRoot, proof), "wrong hash")
+		})
+	}
+}
+
+func TestVerifyConsistencyProof(t *testing.T) {
+	assert := assert.New(t)
+
+	store := NewMapStore()
+	config := Config{Hash: crypto.SHA1, BranchFactor: 3}
+	tree := NewTree(store, config)
+
+	store.CommitUpdate(tree.Update(makeLeaves(0, 10), big.NewInt(10)))
+	oldRoot := tree.Root().Data
+	store.CommitUpdate(tree.Update(makeLeaves(10, 25), big.NewInt(25)))
+	newRoot := tree.Root().Data
+
+	proof, err := tree.ConsistencyProof(big.NewInt(10), big.NewInt(25))
+	assert.NoError(err)
+	assert.True(VerifyConsistencyProof(config, oldRoot, newRoot, proof))
+
+	clone := func() *ConsistencyProof {
+		p := *proof
+		p.OldNodes = append([]*Node{}, proof.OldNodes...)
+		p.NewNodes = append([]*Node{}, proof.NewNodes...)
+		return &p
+	}
+
+	assert.False(VerifyConsistencyProof(config, []byte{1}, newRoot, proof), "wrong old root")
+	assert.False(VerifyConsistencyProof(config, oldRoot, []byte{1}, proof), "wrong new root")
+	assert.False(VerifyConsistencyProof(Config{Hash: crypto.SHA1, BranchFactor: 4},
+		oldRoot, newRoot, proof), "wrong branch factor")
+	assert.False(VerifyConsistencyProof(config, oldRoot, newRoot, nil))
+
+	p := clone()
+	p.OldNodes[0] = &Node{p.OldNodes[0].Position, []byte{1}}
+	assert.False(VerifyConsistencyProof(config, oldRoot, newRoot, p), "tampered old node")
+
+	p = clone()
+	p.NewNodes[1] = &Node{p.NewNodes[1].Position, []byte{1}}
+	assert.False(VerifyConsistencyProof(config, oldRoot, newRoot, p), "tampered new node")
+
+	p = clone()
+	p.NewNodes = p.NewNodes[1:]
+	assert.False(VerifyConsistencyProof(config, oldRoot, newRoot, p), "missing node")
+
+	p = clone()
+	p.OldLeafCount = big.NewInt(9)
+	assert.False(VerifyConsistencyProof(config, oldRoot, newRoot, p), "wrong leaf count")
+
+	// modifying an old leaf breaks the append-only extension
+	store.CommitUpdate(tree.Update(makeLeaves(25, 26), big.NewInt(26)))
+	store.CommitUpdate(tree.Update([]*Node{{NewPosition(0, big.NewInt(3)), []byte{100}}}, big.NewInt(26)))
+	proof, err = tree.ConsistencyProof(big.NewInt(10), big.NewInt(26))
+	assert.NoError(err)
+	assert.False(VerifyConsistencyProof(config, oldRoot, tree.Root().Data, proof), "modified old leaf")
+
+	_, err = tree.ConsistencyProof(big.NewInt(0), big.NewInt(10))
+	assert.ErrorIs(err, ErrInvalidLeafCount)
+	_, err = tree.ConsistencyProof(big.NewInt(11), big.NewInt(10))
+	assert.ErrorIs(err, ErrInvalidLeafCount)
+	_, err = tree.ConsistencyProof(big.NewInt(10), big.NewInt(27))
+	assert.ErrorIs(err, ErrInvalidLeafCount)
+}
@@ -0,0 +1,287 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package merkle
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+)
+
+// errors
+var (
+	ErrInvalidExport  = errors.New("invalid tree export")
+	ErrExportMismatch = errors.New("exports of different branch factors or hashes")
+)
+
+// ExportVersion is the first byte of tree export
+const ExportVersion = 1
+
+/*
+Export writes all nodes of the tree level by level, from the leaves to the root.
+
+	version (1 byte), branch factor (1 byte), hash
+	leaf count
+	each level: level (1 byte), node count, data of each node by index
+
+Byte fields are prefixed with their length as uvarint, the hash and the node count are uvarints.
+Unlike Tree.Snapshot, the export is read without the tree config and two exports can be compared
+node by node, see Diff.
+*/
+func Export(store Store, config Config, leafCount *big.Int, w io.Writer) error {
+	if leafCount == nil || leafCount.Sign() != 1 {
+		return ErrInvalidLeafCount
+	}
+	tree := NewTree(store, config)
+	st, release := tree.snapshot()
+	defer release()
+
+	bw := bufio.NewWriter(w)
+	b := []byte{ExportVersion, tree.calc.BranchFactor()}
+	b = appendUvarint(b, uint64(config.Hash))
+	b = appendBytes(b, leafCount.Bytes())
+	if _, err := bw.Write(b); err != nil {
+		return err
+	}
+	rowSize := leafCount
+	height := tree.calc.Height(leafCount)
+	for level := uint8(0); level < height; level++ {
+		b := appendUvarint([]byte{level}, rowSize.Uint64())
+		if _, err := bw.Write(b); err != nil {
+			return err
+		}
+		positions := make([]*Position, 0, snapshotBatchSize)
+		for idx := big.NewInt(0); idx.Cmp(rowSize) == -1; idx = big.NewInt(0).Add(idx, big.NewInt(1)) {
+			positions = append(positions, NewPosition(level, idx))
+			if len(positions) == snapshotBatchSize {
+				if err := exportNodes(bw, st.store, positions); err != nil {
+					return err
+				}
+				positions = positions[:0]
+			}
+		}
+		if err := exportNodes(bw, st.store, positions); err != nil {
+			return err
+		}
+		rowSize = tree.calc.GroupCount(rowSize)
+	}
+	return bw.Flush()
+}
+
+func exportNodes(w io.Writer, store NodeReader, positions []*Position) error {
+	if len(positions) == 0 {
+		return nil
+	}
+	for i, data := range store.GetNodes(positions) {
+		if data == nil {
+			return fmt.Errorf("%w, %s", ErrNodeNotFound, positions[i])
+		}
+		if _, err := w.Write(appendBytes(nil, data)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Import reads the export written by Export and checks every branch is the hash of its children
+// with the hash and branch factor of the export. It returns the tree as an update, which is commited
+// to the store if the store is an UpdateCommitter, otherwise the caller writes it.
+// The whole tree is kept in memory. The root is not trusted by the check, compare it with the expected root.
+func Import(store Store, r io.Reader) (*UpdateResult, error) {
+	er, err := newExportReader(r)
+	if err != nil {
+		return nil, err
+	}
+	res := &UpdateResult{
+		LeafCount: er.leafCount,
+		Height:    er.height,
+		Leaves:    make([]*Node, 0),
+		Branches:  make([]*Node, 0),
+	}
+	var children []*Node
+	level := make([]*Node, 0)
+	for {
+		n, err := er.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if n.Position.Level() != 0 && n.Position.Index().Sign() == 0 {
+			children, level = level, make([]*Node, 0, len(level)/int(er.calc.BranchFactor())+1)
+		}
+		if n.Position.Level() != 0 {
+			if err := er.checkParent(n, children); err != nil {
+				return nil, err
+			}
+			res.Branches = append(res.Branches, n)
+		} else {
+			res.Leaves = append(res.Leaves, n)
+		}
+		level = append(level, n)
+	}
+	res.Root = level[0]
+	if c, ok := store.(UpdateCommitter); ok {
+		c.CommitUpdate(res)
+	}
+	return res, nil
+}
+
+// Diff compares two exports node by node and returns the first position with different data,
+// from the leaves to the root and by index in each level, nil if the exports are the same.
+// A node missing in one export differs. The exports must have the same branch factor and hash.
+func Diff(a, b io.Reader) (*Position, error) {
+	ra, err := newExportReader(a)
+	if err != nil {
+		return nil, fmt.Errorf("first export, %w", err)
+	}
+	rb, err := newExportReader(b)
+	if err != nil {
+		return nil, fmt.Errorf("second export, %w", err)
+	}
+	if ra.calc.BranchFactor() != rb.calc.BranchFactor() || ra.hash != rb.hash {
+		return nil, ErrExportMismatch
+	}
+	na, errA := ra.next()
+	nb, errB := rb.next()
+	for errA == nil && errB == nil {
+		cmp := comparePositions(na.Position, nb.Position)
+		if cmp < 0 {
+			return na.Position, nil // missing in b
+		}
+		if cmp > 0 {
+			return nb.Position, nil
+		}
+		if !bytes.Equal(na.Data, nb.Data) {
+			return na.Position, nil
+		}
+		na, errA = ra.next()
+		nb, errB = rb.next()
+	}
+	if errA != nil && errA != io.EOF {
+		return nil, fmt.Errorf("first export, %w", errA)
+	}
+	if errB != nil && errB != io.EOF {
+		return nil, fmt.Errorf("second export, %w", errB)
+	}
+	if errA == nil {
+		return na.Position, nil
+	}
+	if errB == nil {
+		return nb.Position, nil
+	}
+	return nil, nil
+}
+
+// comparePositions orders positions by level, then by index
+func comparePositions(a, b *Position) int {
+	if a.Level() != b.Level() {
+		if a.Level() < b.Level() {
+			return -1
+		}
+		return 1
+	}
+	return a.Index().Cmp(b.Index())
+}
+
+// exportReader reads the nodes of an export in order and checks the levels
+type exportReader struct {
+	sr        *snapshotReader
+	hash      crypto.Hash
+	calc      *TreeCalc
+	leafCount *big.Int
+	height    uint8
+
+	level     uint8
+	rowSize   *big.Int
+	index     *big.Int
+	remaining uint64
+}
+
+func newExportReader(r io.Reader) (*exportReader, error) {
+	sr := &snapshotReader{r: bufio.NewReader(r), invalid: ErrInvalidExport}
+	header := sr.read(2)
+	hash := crypto.Hash(sr.uvarint())
+	leafCount := big.NewInt(0).SetBytes(sr.bytes())
+	if sr.err != nil {
+		return nil, sr.err
+	}
+	if header[0] != ExportVersion {
+		return nil, fmt.Errorf("%w, unsupported version %d", ErrInvalidExport, header[0])
+	}
+	if header[1] < 2 {
+		return nil, fmt.Errorf("%w, branch factor %d", ErrInvalidExport, header[1])
+	}
+	if !hash.Available() {
+		return nil, fmt.Errorf("%w, hash %d not available", ErrInvalidExport, hash)
+	}
+	if leafCount.Sign() != 1 {
+		return nil, fmt.Errorf("%w, no leaves", ErrInvalidExport)
+	}
+	calc := NewTreeCalc(header[1])
+	return &exportReader{
+		sr:        sr,
+		hash:      hash,
+		calc:      calc,
+		leafCount: leafCount,
+		height:    calc.Height(leafCount),
+		rowSize:   leafCount,
+		index:     big.NewInt(0),
+	}, nil
+}
+
+// next returns the next node, io.EOF after the root
+func (er *exportReader) next() (*Node, error) {
+	if er.remaining == 0 && er.index.Sign() == 1 {
+		// the level is read
+		if er.level+1 >= er.height {
+			return nil, io.EOF
+		}
+		er.level++
+		er.rowSize = er.calc.GroupCount(er.rowSize)
+		er.index = big.NewInt(0)
+	}
+	if er.index.Sign() == 0 {
+		level := er.sr.read(1)
+		count := er.sr.uvarint()
+		if er.sr.err != nil {
+			return nil, er.sr.err
+		}
+		if level[0] != er.level || big.NewInt(0).SetUint64(count).Cmp(er.rowSize) != 0 {
+			return nil, fmt.Errorf("%w, level %d with %d nodes, expected level %d with %d",
+				ErrInvalidExport, level[0], count, er.level, er.rowSize)
+		}
+		er.remaining = count
+	}
+	data := er.sr.bytes()
+	if er.sr.err != nil {
+		return nil, er.sr.err
+	}
+	n := &Node{NewPosition(er.level, er.index), data}
+	er.index = big.NewInt(0).Add(er.index, big.NewInt(1))
+	er.remaining--
+	return n, nil
+}
+
+// checkParent checks the branch is the hash of its children in the level below
+func (er *exportReader) checkParent(n *Node, children []*Node) error {
+	first := er.calc.FirstNodeOfGroup(n.Position.Index()).Int64()
+	last := first + int64(er.calc.BranchFactor())
+	if last > int64(len(children)) {
+		last = int64(len(children))
+	}
+	h := er.hash.New()
+	for _, c := range children[first:last] {
+		h.Write(c.Data)
+	}
+	if !bytes.Equal(n.Data, h.Sum(nil)) {
+		return fmt.Errorf("%w, node %s is not the hash of its children", ErrInvalidExport, n.Position)
+	}
+	return nil
+}
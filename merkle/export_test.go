@@ -0,0 +1,103 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package merkle
+
+import (
+	"bytes"
+	"crypto"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportImport(t *testing.T) {
+	assert := assert.New(t)
+
+	store := NewMapStore()
+	config := Config{Hash: crypto.SHA1, BranchFactor: 3}
+	tree := NewTree(store, config)
+	assert.ErrorIs(Export(store, config, big.NewInt(0), new(bytes.Buffer)), ErrInvalidLeafCount)
+
+	leaves := makeLeaves(0, 1100) // more than a batch in the leaf level
+	store.CommitUpdate(tree.Update(leaves, big.NewInt(1100)))
+
+	buf := new(bytes.Buffer)
+	assert.NoError(Export(store, config, big.NewInt(1100), buf))
+	data := buf.Bytes()
+
+	importStore := NewMapStore()
+	res, err := Import(importStore, bytes.NewReader(data))
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal(tree.Root(), res.Root)
+	assert.Len(res.Leaves, 1100)
+	imported := NewTree(importStore, config)
+	assert.Equal(tree.Root(), imported.Root(), "commited to the store")
+	assert.True(imported.Verify(leaves))
+
+	_, err = Import(NewMapStore(), bytes.NewReader(data[:len(data)-3]))
+	assert.ErrorIs(err, ErrInvalidExport, "truncated")
+
+	// the root is the last node
+	tampered := append([]byte{}, data...)
+	tampered[len(tampered)-1]++
+	_, err = Import(NewMapStore(), bytes.NewReader(tampered))
+	assert.ErrorIs(err, ErrInvalidExport, "root")
+
+	tampered = append([]byte{}, data...)
+	tampered[0] = ExportVersion + 1
+	_, err = Import(NewMapStore(), bytes.NewReader(tampered))
+	assert.ErrorIs(err, ErrInvalidExport, "version")
+
+	_, err = Import(NewMapStore(), bytes.NewReader(nil))
+	assert.ErrorIs(err, ErrInvalidExport, "empty")
+}
+
+func TestExport_MissingNode(t *testing.T) {
+	assert := assert.New(t)
+
+	store := NewMapStore()
+	config := Config{Hash: crypto.SHA1, BranchFactor: 3}
+	store.CommitUpdate(NewTree(store, config).Update(makeLeaves(0, 10), big.NewInt(10)))
+	assert.ErrorIs(Export(store, config, big.NewInt(11), new(bytes.Buffer)), ErrNodeNotFound)
+}
+
+func TestDiff(t *testing.T) {
+	assert := assert.New(t)
+
+	config := Config{Hash: crypto.SHA1, BranchFactor: 3}
+	export := func(leaves []*Node, leafCount int64) []byte {
+		store := NewMapStore()
+		store.CommitUpdate(NewTree(store, config).Update(leaves, big.NewInt(leafCount)))
+		buf := new(bytes.Buffer)
+		assert.NoError(Export(store, config, big.NewInt(leafCount), buf))
+		return buf.Bytes()
+	}
+	leaves := makeLeaves(0, 20)
+	a := export(leaves, 20)
+
+	p, err := Diff(bytes.NewReader(a), bytes.NewReader(export(leaves, 20)))
+	assert.NoError(err)
+	assert.Nil(p, "same tree")
+
+	changed := append([]*Node{}, leaves...)
+	changed[13] = &Node{NewPosition(0, big.NewInt(13)), []byte{0xff}}
+	p, err = Diff(bytes.NewReader(a), bytes.NewReader(export(changed, 20)))
+	assert.NoError(err)
+	assert.Equal(NewPosition(0, big.NewInt(13)), p)
+
+	p, err = Diff(bytes.NewReader(a), bytes.NewReader(export(makeLeaves(0, 18), 18)))
+	assert.NoError(err)
+	assert.Equal(NewPosition(0, big.NewInt(18)), p, "missing in the second")
+
+	store := NewMapStore()
+	bfactor4 := Config{Hash: crypto.SHA1, BranchFactor: 4}
+	store.CommitUpdate(NewTree(store, bfactor4).Update(leaves, big.NewInt(20)))
+	buf := new(bytes.Buffer)
+	assert.NoError(Export(store, bfactor4, big.NewInt(20), buf))
+	_, err = Diff(bytes.NewReader(a), buf)
+	assert.ErrorIs(err, ErrExportMismatch)
+}
@@ -0,0 +1,236 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package merkle
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// errors
+var (
+	ErrLeafNotFound    = errors.New("leaf not found")
+	ErrInvalidPosition = errors.New("invalid position")
+)
+
+// MultiProof proves a set of leaves against the root node.
+// Branches are the sibling nodes needed to recompute the root,
+// nodes shared by the proven leaves are included only once.
+type MultiProof struct {
+	LeafCount *big.Int `json:"leafCount"`
+	Leaves    []*Node  `json:"leaves"`
+	Branches  []*Node  `json:"branches"`
+}
+
+// Prove creates the multiproof for the leaves at the given indexes
+func (tree *Tree) Prove(indexes []*big.Int) (*MultiProof, error) {
+	leafCount := tree.store.GetLeafCount()
+	proof := &MultiProof{
+		LeafCount: leafCount,
+		Leaves:    make([]*Node, 0, len(indexes)),
+		Branches:  make([]*Node, 0),
+	}
+	pmap := make(map[string]struct{}, len(indexes))
+	for _, idx := range indexes {
+		p := NewPosition(0, idx)
+		if _, found := pmap[p.String()]; found {
+			continue
+		}
+		data := tree.store.GetNode(p)
+		if leafCount.Cmp(idx) != 1 || data == nil {
+			return nil, ErrLeafNotFound
+		}
+		pmap[p.String()] = struct{}{}
+		proof.Leaves = append(proof.Leaves, &Node{p, data})
+	}
+	nodes := proof.Leaves
+	rowSize := leafCount
+	height := tree.calc.Height(leafCount)
+	for i := uint8(0); i < height-1; i++ {
+		nodes = tree.proveOneLevel(nodes, rowSize, proof)
+		rowSize = tree.calc.GroupCount(rowSize)
+	}
+	return proof, nil
+}
+
+// ProveLeaves creates the multiproof for the leaves.
+// The leaf data must be the same as the data in the tree.
+func (tree *Tree) ProveLeaves(leaves []*Node) (*MultiProof, error) {
+	indexes := make([]*big.Int, len(leaves))
+	for i, n := range leaves {
+		if n == nil || n.Position == nil || n.Position.Level() != 0 {
+			return nil, ErrInvalidPosition
+		}
+		indexes[i] = n.Position.Index()
+	}
+	proof, err := tree.Prove(indexes)
+	if err != nil {
+		return nil, err
+	}
+	data := make(map[string][]byte, len(proof.Leaves))
+	for _, n := range proof.Leaves {
+		data[n.Position.String()] = n.Data
+	}
+	for _, n := range leaves {
+		if !bytes.Equal(n.Data, data[n.Position.String()]) {
+			return nil, ErrLeafNotFound
+		}
+	}
+	return proof, nil
+}
+
+// proveOneLevel collects the siblings of nodes and returns their parents
+func (tree *Tree) proveOneLevel(nodes []*Node, rowSize *big.Int, proof *MultiProof) []*Node {
+	groups := tree.groupNodesByParent(nodes)
+	known := make([][]bool, len(groups))
+	for i, g := range groups {
+		known[i] = make([]bool, len(g.nodes))
+		for j, n := range g.nodes {
+			known[i][j] = n != nil
+		}
+	}
+	tree.loadGroups(groups, rowSize, nil)
+	parents := make([]*Node, 0, len(groups))
+	for k, g := range groups {
+		for i, n := range g.nodes {
+			if n != nil && !known[k][i] {
+				proof.Branches = append(proof.Branches, n)
+			}
+		}
+		parents = append(parents, g.MakeParent())
+	}
+	return parents
+}
+
+// VerifyMultiProof recomputes the root from the proof and compares it with the given root.
+// It doesn't need the tree store, so that the proof can be verified by the clients
+// which only know the root.
+func VerifyMultiProof(config Config, root []byte, proof *MultiProof) bool {
+	if len(root) == 0 || proof == nil || proof.LeafCount == nil {
+		return false
+	}
+	if !config.Hash.Available() {
+		return false
+	}
+	if len(proof.Leaves) == 0 || proof.LeafCount.Sign() != 1 {
+		return false
+	}
+	for _, n := range proof.Leaves {
+		if n == nil || n.Position == nil || n.Position.Level() != 0 {
+			return false
+		}
+		if proof.LeafCount.Cmp(n.Position.Index()) != 1 {
+			return false
+		}
+	}
+	store := NewMapStore()
+	for _, n := range proof.Branches {
+		if n == nil || n.Position == nil {
+			return false
+		}
+		store.nodes[n.Position.String()] = n.Data
+	}
+	tree := NewTree(store, config)
+	store.leafCount = proof.LeafCount
+	store.height = tree.calc.Height(proof.LeafCount)
+
+	res := tree.update(proof.Leaves, proof.LeafCount, false)
+	return res.Root != nil && bytes.Equal(root, res.Root.Data)
+}
+
+// MultiProofVersion is the first byte of marshaled multiproof
+const MultiProofVersion = 2
+
+// legacyMultiProofVersion encodes positions with Position.LegacyBytes, it's still decoded
+const legacyMultiProofVersion = 1
+
+/*
+Marshal encodes multiproof as deterministic bytes.
+
+	version (1 byte)
+	leaf count
+	proven leaf count (uvarint)
+	position, data of each proven leaf
+	branch count (uvarint)
+	position, data of each branch
+
+Byte fields are prefixed with their length as uvarint, the same as Proof.
+*/
+func (proof *MultiProof) Marshal() ([]byte, error) {
+	if proof.LeafCount == nil {
+		return nil, ErrInvalidProof
+	}
+	b := []byte{MultiProofVersion}
+	b = appendBytes(b, proof.LeafCount.Bytes())
+	for _, nodes := range [][]*Node{proof.Leaves, proof.Branches} {
+		b = appendUvarint(b, uint64(len(nodes)))
+		for _, n := range nodes {
+			if n == nil || n.Position == nil {
+				return nil, ErrInvalidProof
+			}
+			b = appendBytes(b, n.Position.Bytes())
+			b = appendBytes(b, n.Data)
+		}
+	}
+	return b, nil
+}
+
+// UnmarshalMultiProof decodes multiproof from bytes
+func UnmarshalMultiProof(b []byte) (*MultiProof, error) {
+	if len(b) == 0 {
+		return nil, fmt.Errorf("%w, empty", ErrInvalidProof)
+	}
+	if b[0] != MultiProofVersion && b[0] != legacyMultiProofVersion {
+		return nil, fmt.Errorf("%w, %d", ErrUnsupportedProofVersion, b[0])
+	}
+	r := &proofReader{b: b[1:], legacy: b[0] == legacyMultiProofVersion}
+	proof := &MultiProof{LeafCount: big.NewInt(0).SetBytes(r.bytes())}
+	proof.Leaves = r.nodes()
+	proof.Branches = r.nodes()
+	if r.err == nil && len(r.b) != 0 {
+		r.err = fmt.Errorf("%w, %d trailing bytes", ErrInvalidProof, len(r.b))
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return proof, nil
+}
+
+// nodes reads the node count and the nodes
+func (r *proofReader) nodes() []*Node {
+	count := r.uvarint()
+	if r.err == nil && count > uint64(len(r.b))/2 { // each node has two length prefixes
+		r.err = fmt.Errorf("%w, %d nodes", ErrInvalidProof, count)
+	}
+	if r.err != nil {
+		return nil
+	}
+	nodes := make([]*Node, count)
+	for i := range nodes {
+		nodes[i] = r.node()
+	}
+	return nodes
+}
+
+// MarshalJSON encodes position as its serialized bytes
+func (p *Position) MarshalJSON() ([]byte, error) {
+	return json.Marshal(p.bytes)
+}
+
+// UnmarshalJSON decodes position from its serialized bytes
+func (p *Position) UnmarshalJSON(data []byte) error {
+	var b []byte
+	if err := json.Unmarshal(data, &b); err != nil {
+		return err
+	}
+	pos, err := ParsePosition(b)
+	if err != nil {
+		return err
+	}
+	*p = *pos
+	return nil
+}
@@ -0,0 +1,184 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package merkle
+
+import (
+	"crypto"
+	"encoding/json"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTree_Prove(t *testing.T) {
+	assert := assert.New(t)
+
+	store := NewMapStore()
+	config := Config{Hash: crypto.SHA1, BranchFactor: 3}
+	tree := NewTree(store, config)
+
+	leaves := make([]*Node, 10)
+	for i := range leaves {
+		leaves[i] = &Node{NewPosition(0, big.NewInt(int64(i))), []byte{uint8(i)}}
+	}
+	store.CommitUpdate(tree.Update(leaves, big.NewInt(10)))
+	root := tree.Root().Data
+
+	proof, err := tree.Prove([]*big.Int{big.NewInt(0), big.NewInt(2), big.NewInt(9)})
+	assert.NoError(err)
+	assert.Len(proof.Leaves, 3)
+	// leaf 1, nodes (1,1) (1,2) at level 1, node (2,0) is computed from proven leaves
+	assert.Len(proof.Branches, 3)
+	assert.True(VerifyMultiProof(config, root, proof))
+
+	proof, err = tree.Prove([]*big.Int{big.NewInt(4), big.NewInt(4)})
+	assert.NoError(err)
+	assert.Len(proof.Leaves, 1, "duplicate index")
+	assert.True(VerifyMultiProof(config, root, proof))
+
+	_, err = tree.Prove([]*big.Int{big.NewInt(10)})
+	assert.ErrorIs(err, ErrLeafNotFound)
+
+	b, err := json.Marshal(proof)
+	assert.NoError(err)
+	decoded := new(MultiProof)
+	assert.NoError(json.Unmarshal(b, decoded))
+	assert.True(VerifyMultiProof(config, root, decoded))
+}
+
+func TestTree_ProveLeaves(t *testing.T) {
+	store := NewMapStore()
+	config := Config{Hash: crypto.SHA1, BranchFactor: 3}
+	tree := NewTree(store, config)
+	leaves := makeLeaves(0, 20)
+	store.CommitUpdate(tree.Update(leaves, big.NewInt(20)))
+	root := tree.Root().Data
+
+	tests := []struct {
+		name     string
+		leaves   []*Node
+		branches int
+	}{
+		// (0,4) (0,5), (1,0) (1,2), (2,1) (2,2)
+		{"single leaf", leaves[3:4], 6},
+		// the group of (1,1) is proven, (1,0) (1,2), (2,1) (2,2)
+		{"adjacent leaves", leaves[3:6], 4},
+		// (0,1) (0,2) (0,18), (1,1) (1,2), (2,1)
+		{"different subtrees", []*Node{leaves[0], leaves[19]}, 6},
+		{"all leaves", leaves, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+			proof, err := tree.ProveLeaves(tt.leaves)
+			assert.NoError(err)
+			assert.Len(proof.Branches, tt.branches)
+			assert.True(VerifyMultiProof(config, root, proof))
+			assert.Equal(tree.Verify(tt.leaves), VerifyMultiProof(config, root, proof))
+
+			b, err := proof.Marshal()
+			assert.NoError(err)
+			b2, err := proof.Marshal()
+			assert.NoError(err)
+			assert.Equal(b, b2, "deterministic")
+			decoded, err := UnmarshalMultiProof(b)
+			assert.NoError(err)
+			b2, err = decoded.Marshal()
+			assert.NoError(err)
+			assert.Equal(b, b2)
+			assert.True(VerifyMultiProof(config, root, decoded))
+		})
+	}
+
+	assert := assert.New(t)
+	changed := &Node{leaves[3].Position, []byte{100}}
+	_, err := tree.ProveLeaves([]*Node{leaves[2], changed})
+	assert.ErrorIs(err, ErrLeafNotFound, "different data")
+	assert.False(tree.Verify([]*Node{changed}))
+	_, err = tree.ProveLeaves([]*Node{{NewPosition(1, big.NewInt(0)), nil}})
+	assert.ErrorIs(err, ErrInvalidPosition)
+
+	assert.False(VerifyMultiProof(Config{BranchFactor: 3}, root, &MultiProof{
+		LeafCount: big.NewInt(20), Leaves: leaves[:1],
+	}), "unavailable hash")
+}
+
+func TestUnmarshalMultiProof(t *testing.T) {
+	assert := assert.New(t)
+
+	store := NewMapStore()
+	tree := NewTree(store, Config{Hash: crypto.SHA1, BranchFactor: 3})
+	store.CommitUpdate(tree.Update(makeLeaves(0, 10), big.NewInt(10)))
+	proof, err := tree.Prove([]*big.Int{big.NewInt(1), big.NewInt(7)})
+	assert.NoError(err)
+	b, err := proof.Marshal()
+	assert.NoError(err)
+
+	_, err = UnmarshalMultiProof(nil)
+	assert.ErrorIs(err, ErrInvalidProof)
+	_, err = UnmarshalMultiProof(append([]byte{MultiProofVersion + 1}, b[1:]...))
+	assert.ErrorIs(err, ErrUnsupportedProofVersion)
+	_, err = UnmarshalMultiProof(b[:len(b)-1])
+	assert.ErrorIs(err, ErrInvalidProof, "truncated")
+	_, err = UnmarshalMultiProof(append(b, 0))
+	assert.ErrorIs(err, ErrInvalidProof, "trailing bytes")
+
+	_, err = (&MultiProof{LeafCount: big.NewInt(10), Branches: []*Node{nil}}).Marshal()
+	assert.ErrorIs(err, ErrInvalidProof)
+}
+
+func TestVerifyMultiProof(t *testing.T) {
+	store := NewMapStore()
+	config := Config{Hash: crypto.SHA1, BranchFactor: 3}
+	tree := NewTree(store, config)
+
+	leaves := make([]*Node, 10)
+	for i := range leaves {
+		leaves[i] = &Node{NewPosition(0, big.NewInt(int64(i))), []byte{uint8(i)}}
+	}
+	store.CommitUpdate(tree.Update(leaves, big.NewInt(10)))
+	root := tree.Root().Data
+
+	newProof := func() *MultiProof {
+		proof, _ := tree.Prove([]*big.Int{big.NewInt(1), big.NewInt(5)})
+		return proof
+	}
+
+	tests := []struct {
+		name   string
+		root   []byte
+		modify func(proof *MultiProof)
+		want   bool
+	}{
+		{"valid", root, func(proof *MultiProof) {}, true},
+		{"nil root", nil, func(proof *MultiProof) {}, false},
+		{"different root", []byte{1}, func(proof *MultiProof) {}, false},
+		{"tampered leaf", root, func(proof *MultiProof) {
+			proof.Leaves[0] = &Node{proof.Leaves[0].Position, []byte{100}}
+		}, false},
+		{"tampered branch", root, func(proof *MultiProof) {
+			proof.Branches[0] = &Node{proof.Branches[0].Position, []byte{100}}
+		}, false},
+		{"missing branch", root, func(proof *MultiProof) {
+			proof.Branches = proof.Branches[1:]
+		}, false},
+		{"leaf out of range", root, func(proof *MultiProof) {
+			proof.Leaves[0] = &Node{NewPosition(0, big.NewInt(10)), proof.Leaves[0].Data}
+		}, false},
+		{"no leaves", root, func(proof *MultiProof) {
+			proof.Leaves = nil
+		}, false},
+		{"different leaf count", root, func(proof *MultiProof) {
+			proof.LeafCount = big.NewInt(30)
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proof := newProof()
+			tt.modify(proof)
+			assert.Equal(t, tt.want, VerifyMultiProof(config, tt.root, proof))
+		})
+	}
+}
@@ -0,0 +1,265 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package merkle
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// errors
+var (
+	ErrInvalidProof            = errors.New("invalid proof")
+	ErrUnsupportedProofVersion = errors.New("unsupported proof version")
+)
+
+// Proof is the inclusion proof of a single leaf.
+// Siblings are the other nodes in the leaf's group at each level, from the leaf level up to the root.
+type Proof struct {
+	LeafCount *big.Int `json:"leafCount"`
+	Leaf      *Node    `json:"leaf"`
+	Siblings  []*Node  `json:"siblings"`
+}
+
+// GenerateProof creates the inclusion proof of the leaf against the current root.
+// The leaf data must be the same as the data in the tree.
+func (tree *Tree) GenerateProof(leaf *Node) (*Proof, error) {
+	if leaf == nil || leaf.Position == nil || leaf.Position.Level() != 0 {
+		return nil, ErrInvalidPosition
+	}
+	leafCount := tree.store.GetLeafCount()
+	if leafCount.Cmp(leaf.Position.Index()) != 1 {
+		return nil, ErrLeafNotFound
+	}
+	data := tree.store.GetNode(leaf.Position)
+	if data == nil || !bytes.Equal(data, leaf.Data) {
+		return nil, ErrLeafNotFound
+	}
+	proof := &Proof{
+		LeafCount: leafCount,
+		Leaf:      &Node{leaf.Position, data},
+		Siblings:  make([]*Node, 0),
+	}
+	node := proof.Leaf
+	rowSize := leafCount
+	height := tree.calc.Height(leafCount)
+	for i := uint8(0); i < height-1; i++ {
+		pPos := NewPosition(i+1, tree.calc.GroupOfNode(node.Position.Index()))
+		g := NewGroup(tree.config.Hash, tree.calc, tree.store, pPos).SetNode(node)
+		tree.loadGroups([]*Group{g}, rowSize, nil)
+		for _, n := range g.nodes {
+			if n != nil && n != node {
+				proof.Siblings = append(proof.Siblings, n)
+			}
+		}
+		node = g.MakeParent()
+		rowSize = tree.calc.GroupCount(rowSize)
+	}
+	return proof, nil
+}
+
+// VerifyProof recomputes the root from the leaf and siblings of the proof without a store.
+// hashFunc and branchFactor must be the same as the config of the tree.
+func VerifyProof(root []byte, proof *Proof, hashFunc crypto.Hash, branchFactor uint8) bool {
+	if len(root) == 0 || proof == nil || proof.LeafCount == nil || proof.Leaf == nil {
+		return false
+	}
+	if branchFactor < 2 || !hashFunc.Available() {
+		return false
+	}
+	leaf := proof.Leaf
+	if leaf.Position == nil || leaf.Position.Level() != 0 || leaf.Position.Index().Sign() < 0 {
+		return false
+	}
+	if proof.LeafCount.Cmp(leaf.Position.Index()) != 1 {
+		return false
+	}
+	calc := NewTreeCalc(branchFactor)
+	node := leaf
+	rowSize := proof.LeafCount
+	height := calc.Height(proof.LeafCount)
+	k := 0
+	for i := uint8(0); i < height-1; i++ {
+		pPos := NewPosition(i+1, calc.GroupOfNode(node.Position.Index()))
+		g := NewGroup(hashFunc, calc, nil, pPos).SetNode(node)
+		for ; k < len(proof.Siblings); k++ {
+			sib := proof.Siblings[k]
+			if sib == nil || sib.Position == nil {
+				return false
+			}
+			if sib.Position.Level() != i {
+				break
+			}
+			if !isSiblingInGroup(calc, g, sib, rowSize) {
+				return false
+			}
+			g.SetNode(sib)
+		}
+		node = g.MakeParent()
+		rowSize = calc.GroupCount(rowSize)
+	}
+	if k != len(proof.Siblings) {
+		return false
+	}
+	return bytes.Equal(root, node.Data)
+}
+
+// VerifyMarshaledProof decodes the proof bytes and verifies them with VerifyProof.
+// It's for the clients which receive the marshaled proof and know only the root.
+func VerifyMarshaledProof(root, b []byte, hashFunc crypto.Hash, branchFactor uint8) bool {
+	proof, err := UnmarshalProof(b)
+	if err != nil {
+		return false
+	}
+	return VerifyProof(root, proof, hashFunc, branchFactor)
+}
+
+// isSiblingInGroup checks that the node belongs to the group at an empty slot
+func isSiblingInGroup(calc *TreeCalc, g *Group, n *Node, rowSize *big.Int) bool {
+	idx := n.Position.Index()
+	if idx.Sign() < 0 || rowSize.Cmp(idx) != 1 {
+		return false
+	}
+	if calc.GroupOfNode(idx).Cmp(g.parentPosition.Index()) != 0 {
+		return false
+	}
+	return g.nodes[calc.NodeIndexInGroup(idx)] == nil
+}
+
+// ProofVersion is the first byte of marshaled proof
+const ProofVersion = 2
+
+// legacyProofVersion encodes positions with Position.LegacyBytes, it's still decoded
+const legacyProofVersion = 1
+
+/*
+Marshal encodes proof as deterministic bytes.
+
+	version (1 byte)
+	leaf count, leaf position, leaf data
+	sibling count (uvarint)
+	position, data of each sibling
+
+Byte fields are prefixed with their length as uvarint.
+*/
+func (proof *Proof) Marshal() ([]byte, error) {
+	if proof.LeafCount == nil || proof.Leaf == nil || proof.Leaf.Position == nil {
+		return nil, ErrInvalidProof
+	}
+	b := []byte{ProofVersion}
+	b = appendBytes(b, proof.LeafCount.Bytes())
+	b = appendBytes(b, proof.Leaf.Position.Bytes())
+	b = appendBytes(b, proof.Leaf.Data)
+	b = appendUvarint(b, uint64(len(proof.Siblings)))
+	for _, n := range proof.Siblings {
+		if n == nil || n.Position == nil {
+			return nil, ErrInvalidProof
+		}
+		b = appendBytes(b, n.Position.Bytes())
+		b = appendBytes(b, n.Data)
+	}
+	return b, nil
+}
+
+func appendBytes(b, v []byte) []byte {
+	b = appendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	return append(b, buf[:n]...)
+}
+
+// UnmarshalProof decodes proof from bytes
+func UnmarshalProof(b []byte) (*Proof, error) {
+	if len(b) == 0 {
+		return nil, fmt.Errorf("%w, empty", ErrInvalidProof)
+	}
+	if b[0] != ProofVersion && b[0] != legacyProofVersion {
+		return nil, fmt.Errorf("%w, %d", ErrUnsupportedProofVersion, b[0])
+	}
+	r := &proofReader{b: b[1:], legacy: b[0] == legacyProofVersion}
+	proof := &Proof{LeafCount: big.NewInt(0).SetBytes(r.bytes())}
+	proof.Leaf = r.node()
+	count := r.uvarint()
+	if r.err == nil && count > uint64(len(r.b))/2 { // each sibling has two length prefixes
+		r.err = fmt.Errorf("%w, %d siblings", ErrInvalidProof, count)
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	proof.Siblings = make([]*Node, count)
+	for i := range proof.Siblings {
+		proof.Siblings[i] = r.node()
+	}
+	if r.err == nil && len(r.b) != 0 {
+		r.err = fmt.Errorf("%w, %d trailing bytes", ErrInvalidProof, len(r.b))
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return proof, nil
+}
+
+// proofReader keeps the first error, later reads return zero values
+type proofReader struct {
+	b      []byte
+	err    error
+	legacy bool // positions are encoded with Position.LegacyBytes
+}
+
+func (r *proofReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.b)
+	if n <= 0 {
+		r.err = fmt.Errorf("%w, invalid length", ErrInvalidProof)
+		return 0
+	}
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *proofReader) bytes() []byte {
+	l := r.uvarint()
+	if r.err != nil {
+		return nil
+	}
+	if l > uint64(len(r.b)) {
+		r.err = fmt.Errorf("%w, unexpected end", ErrInvalidProof)
+		return nil
+	}
+	v := make([]byte, l)
+	copy(v, r.b)
+	r.b = r.b[l:]
+	return v
+}
+
+func (r *proofReader) node() *Node {
+	pos := r.bytes()
+	data := r.bytes()
+	if r.err != nil {
+		return nil
+	}
+	if r.legacy {
+		if len(pos) < 2 {
+			r.err = fmt.Errorf("%w, invalid position", ErrInvalidProof)
+			return nil
+		}
+		return &Node{UnmarshalPosition(pos), data}
+	}
+	p, err := ParsePosition(pos)
+	if err != nil {
+		r.err = fmt.Errorf("%w, %s", ErrInvalidProof, err)
+		return nil
+	}
+	return &Node{p, data}
+}
@@ -0,0 +1,263 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package merkle
+
+import (
+	"crypto"
+	"fmt"
+	"math/big"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTree_GenerateProof(t *testing.T) {
+	store := NewMapStore()
+	config := Config{Hash: crypto.SHA1, BranchFactor: 3}
+	tree := NewTree(store, config)
+
+	leaves := make([]*Node, 10)
+	for i := range leaves {
+		leaves[i] = &Node{NewPosition(0, big.NewInt(int64(i))), []byte{uint8(i)}}
+	}
+	store.CommitUpdate(tree.Update(leaves, big.NewInt(10)))
+	root := tree.Root().Data
+
+	tests := []struct {
+		name     string
+		leaf     *Node
+		siblings int
+		err      error
+	}{
+		// siblings (0,3) (0,5), (1,0) (1,2), (2,1)
+		{"full groups", leaves[4], 5, nil},
+		// last leaf and level 1 node are alone in their groups, sibling (2,0)
+		{"last leaf", leaves[9], 1, nil},
+		{"wrong data", &Node{leaves[4].Position, []byte{1}}, 0, ErrLeafNotFound},
+		{"out of range", &Node{NewPosition(0, big.NewInt(10)), []byte{10}}, 0, ErrLeafNotFound},
+		{"not leaf", &Node{NewPosition(1, big.NewInt(0)), nil}, 0, ErrInvalidPosition},
+		{"nil leaf", nil, 0, ErrInvalidPosition},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+			proof, err := tree.GenerateProof(tt.leaf)
+			if tt.err != nil {
+				assert.ErrorIs(err, tt.err)
+				return
+			}
+			assert.NoError(err)
+			assert.Len(proof.Siblings, tt.siblings)
+			assert.Equal(tt.leaf.Data, proof.Leaf.Data)
+
+			// siblings are enough to recompute root without the store
+			assert.True(VerifyMultiProof(config, root, &MultiProof{
+				LeafCount: proof.LeafCount,
+				Leaves:    []*Node{proof.Leaf},
+				Branches:  proof.Siblings,
+			}))
+
+			b, err := proof.Marshal()
+			assert.NoError(err)
+			decoded, err := UnmarshalProof(b)
+			assert.NoError(err)
+			assert.Equal(proof.LeafCount, decoded.LeafCount)
+			assert.Equal(proof.Leaf.Position.Bytes(), decoded.Leaf.Position.Bytes())
+			assert.Equal(len(proof.Siblings), len(decoded.Siblings))
+			for i, n := range proof.Siblings {
+				assert.Equal(n.Position.Bytes(), decoded.Siblings[i].Position.Bytes())
+				assert.Equal(n.Data, decoded.Siblings[i].Data)
+			}
+		})
+	}
+}
+
+func TestTree_GenerateProofSingleLeaf(t *testing.T) {
+	assert := assert.New(t)
+
+	store := NewMapStore()
+	tree := NewTree(store, Config{Hash: crypto.SHA1, BranchFactor: 4})
+	leaf := &Node{NewPosition(0, big.NewInt(0)), []byte{1}}
+	store.CommitUpdate(tree.Update([]*Node{leaf}, big.NewInt(1)))
+
+	proof, err := tree.GenerateProof(leaf)
+	assert.NoError(err)
+	assert.Empty(proof.Siblings)
+}
+
+func TestVerifyProof(t *testing.T) {
+	assert := assert.New(t)
+
+	store := NewMapStore()
+	tree := NewTree(store, Config{Hash: crypto.SHA1, BranchFactor: 3})
+	leaves := make([]*Node, 10)
+	for i := range leaves {
+		leaves[i] = &Node{NewPosition(0, big.NewInt(int64(i))), []byte{uint8(i)}}
+	}
+	store.CommitUpdate(tree.Update(leaves, big.NewInt(10)))
+	root := tree.Root().Data
+
+	for _, leaf := range leaves {
+		proof, err := tree.GenerateProof(leaf)
+		assert.NoError(err)
+		b, _ := proof.Marshal()
+		decoded, err := UnmarshalProof(b)
+		assert.NoError(err)
+		assert.True(VerifyProof(root, decoded, crypto.SHA1, 3), "leaf %s", leaf.Position)
+	}
+
+	proof, _ := tree.GenerateProof(leaves[4])
+	clone := func() *Proof {
+		b, _ := proof.Marshal()
+		p, _ := UnmarshalProof(b)
+		return p
+	}
+
+	assert.False(VerifyProof([]byte{1}, proof, crypto.SHA1, 3), "wrong root")
+	assert.False(VerifyProof(root, proof, crypto.SHA256, 3), "wrong hash")
+	assert.False(VerifyProof(root, proof, crypto.SHA1, 4), "wrong branch factor")
+	assert.False(VerifyProof(root, proof, crypto.SHA1, 1))
+	assert.False(VerifyProof(root, nil, crypto.SHA1, 3))
+
+	p := clone()
+	p.Leaf.Data = []byte{5}
+	assert.False(VerifyProof(root, p, crypto.SHA1, 3), "tampered leaf")
+
+	p = clone()
+	p.Siblings[2].Data = []byte{1}
+	assert.False(VerifyProof(root, p, crypto.SHA1, 3), "tampered sibling")
+
+	p = clone()
+	p.Siblings = p.Siblings[1:]
+	assert.False(VerifyProof(root, p, crypto.SHA1, 3), "missing sibling")
+
+	p = clone()
+	p.Siblings = append(p.Siblings, &Node{NewPosition(2, big.NewInt(1)), []byte{1}})
+	assert.False(VerifyProof(root, p, crypto.SHA1, 3), "duplicate sibling")
+
+	p = clone()
+	p.Siblings[0] = &Node{NewPosition(0, big.NewInt(0)), leaves[0].Data}
+	assert.False(VerifyProof(root, p, crypto.SHA1, 3), "sibling of other group")
+
+	p = clone()
+	p.LeafCount = big.NewInt(4)
+	assert.False(VerifyProof(root, p, crypto.SHA1, 3), "wrong leaf count")
+}
+
+func TestProof_MarshalMalformed(t *testing.T) {
+	assert := assert.New(t)
+
+	store := NewMapStore()
+	tree := NewTree(store, Config{Hash: crypto.SHA1, BranchFactor: 3})
+	leaves := make([]*Node, 5)
+	for i := range leaves {
+		leaves[i] = &Node{NewPosition(0, big.NewInt(int64(i))), []byte{uint8(i)}}
+	}
+	store.CommitUpdate(tree.Update(leaves, big.NewInt(5)))
+	proof, _ := tree.GenerateProof(leaves[1])
+	b, err := proof.Marshal()
+	assert.NoError(err)
+	assert.Equal(byte(ProofVersion), b[0])
+
+	b2, _ := proof.Marshal()
+	assert.Equal(b, b2, "deterministic")
+
+	_, err = (&Proof{}).Marshal()
+	assert.ErrorIs(err, ErrInvalidProof)
+
+	tests := []struct {
+		name string
+		b    []byte
+		err  error
+	}{
+		{"empty", nil, ErrInvalidProof},
+		{"version", append([]byte{ProofVersion + 1}, b[1:]...), ErrUnsupportedProofVersion},
+		{"truncated", b[:len(b)-1], ErrInvalidProof},
+		{"trailing", append(append([]byte{}, b...), 0), ErrInvalidProof},
+		{"only version", []byte{ProofVersion}, ErrInvalidProof},
+		{"invalid length", []byte{ProofVersion, 0xff, 0xff}, ErrInvalidProof},
+		{"too many siblings", []byte{ProofVersion, 1, 5, 2, 0, 0, 0, 0xff, 0x01}, ErrInvalidProof},
+		{"short position", []byte{ProofVersion, 1, 5, 1, 0, 0, 0}, ErrInvalidProof},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := UnmarshalProof(tt.b)
+			assert.ErrorIs(err, tt.err)
+		})
+	}
+}
+
+func TestUnmarshalProof_Legacy(t *testing.T) {
+	assert := assert.New(t)
+
+	store := NewMapStore()
+	tree := NewTree(store, Config{Hash: crypto.SHA1, BranchFactor: 3})
+	store.CommitUpdate(tree.Update(makeLeaves(0, 300), big.NewInt(300)))
+	leaf := makeLeaves(280, 281)[0]
+	proof, err := tree.GenerateProof(leaf)
+	assert.NoError(err)
+
+	// version 1 proof with legacy positions
+	b := []byte{legacyProofVersion}
+	b = appendBytes(b, proof.LeafCount.Bytes())
+	b = appendBytes(b, proof.Leaf.Position.LegacyBytes())
+	b = appendBytes(b, proof.Leaf.Data)
+	b = appendUvarint(b, uint64(len(proof.Siblings)))
+	for _, n := range proof.Siblings {
+		b = appendBytes(b, n.Position.LegacyBytes())
+		b = appendBytes(b, n.Data)
+	}
+	decoded, err := UnmarshalProof(b)
+	if assert.NoError(err) {
+		assert.Equal(proof.Leaf.Position.Bytes(), decoded.Leaf.Position.Bytes())
+		assert.True(VerifyProof(tree.Root().Data, decoded, crypto.SHA1, 3))
+	}
+	assert.True(VerifyMarshaledProof(tree.Root().Data, b, crypto.SHA1, 3))
+
+	b[0] = ProofVersion
+	_, err = UnmarshalProof(b)
+	assert.ErrorIs(err, ErrInvalidProof, "legacy positions of a version 2 proof")
+}
+
+// TestVerifyProof_Random checks VerifyProof agrees with Tree.Verify on random trees
+func TestVerifyProof_Random(t *testing.T) {
+	assert := assert.New(t)
+
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 200; i++ {
+		bfactor := uint8(2 + rnd.Intn(7))
+		leafCount := 1 + rnd.Intn(300)
+		store := NewMapStore()
+		tree := NewTree(store, Config{Hash: crypto.SHA256, BranchFactor: bfactor})
+		leaves := make([]*Node, leafCount)
+		for j := range leaves {
+			data := make([]byte, 1+rnd.Intn(8))
+			rnd.Read(data)
+			leaves[j] = &Node{NewPosition(0, big.NewInt(int64(j))), data}
+		}
+		store.CommitUpdate(tree.Update(leaves, big.NewInt(int64(leafCount))))
+		root := tree.Root().Data
+
+		// the last leaf is in the partially filled groups
+		for _, leaf := range []*Node{leaves[rnd.Intn(leafCount)], leaves[leafCount-1]} {
+			proof, err := tree.GenerateProof(leaf)
+			if !assert.NoError(err) {
+				return
+			}
+			b, err := proof.Marshal()
+			assert.NoError(err)
+			msg := fmt.Sprintf("branch factor %d, leaves %d, leaf %s", bfactor, leafCount, leaf.Position.Index())
+			assert.Equal(tree.Verify([]*Node{leaf}), VerifyMarshaledProof(root, b, crypto.SHA256, bfactor), msg)
+			assert.True(VerifyMarshaledProof(root, b, crypto.SHA256, bfactor), msg)
+
+			tampered := &Node{leaf.Position, append([]byte{}, leaf.Data...)}
+			tampered.Data[rnd.Intn(len(tampered.Data))]++
+			proof.Leaf = tampered
+			assert.Equal(tree.Verify([]*Node{tampered}), VerifyProof(root, proof, crypto.SHA256, bfactor), msg)
+			assert.False(VerifyProof(root, proof, crypto.SHA256, bfactor), msg)
+		}
+	}
+	assert.False(VerifyMarshaledProof([]byte{1}, nil, crypto.SHA256, 2), "malformed")
+}
@@ -0,0 +1,73 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package merkle
+
+import (
+	"math/big"
+)
+
+// RangeProof proves the contiguous leaves from Start against the root node.
+// Leaf positions are implied by Start, so only their data is included.
+// Branches are the sibling nodes outside the range, shared ancestors are included only once.
+type RangeProof struct {
+	LeafCount *big.Int `json:"leafCount"`
+	Start     *big.Int `json:"start"`
+	Leaves    [][]byte `json:"leaves"`
+	Branches  []*Node  `json:"branches"`
+}
+
+// End returns the index after the last proven leaf
+func (proof *RangeProof) End() *big.Int {
+	return big.NewInt(0).Add(proof.Start, big.NewInt(int64(len(proof.Leaves))))
+}
+
+// RangeProof creates the proof for the leaves from start to end (exclusive)
+func (tree *Tree) RangeProof(start, end *big.Int) (*RangeProof, error) {
+	if start == nil || end == nil || start.Sign() < 0 || start.Cmp(end) != -1 {
+		return nil, ErrInvalidPosition
+	}
+	if end.Cmp(tree.store.GetLeafCount()) == 1 {
+		return nil, ErrLeafNotFound
+	}
+	indexes := make([]*big.Int, 0)
+	for idx := big.NewInt(0).Set(start); idx.Cmp(end) == -1; idx = big.NewInt(0).Add(idx, big.NewInt(1)) {
+		indexes = append(indexes, idx)
+	}
+	mp, err := tree.Prove(indexes)
+	if err != nil {
+		return nil, err
+	}
+	proof := &RangeProof{
+		LeafCount: mp.LeafCount,
+		Start:     big.NewInt(0).Set(start),
+		Leaves:    make([][]byte, len(mp.Leaves)),
+		Branches:  mp.Branches,
+	}
+	for i, n := range mp.Leaves {
+		proof.Leaves[i] = n.Data
+	}
+	return proof, nil
+}
+
+// VerifyRangeProof recomputes the root from the leaves of the range and the branches,
+// and compares it with the given root. It doesn't need the tree store.
+func VerifyRangeProof(config Config, root []byte, proof *RangeProof) bool {
+	if proof == nil || proof.Start == nil || proof.Start.Sign() < 0 || len(proof.Leaves) == 0 {
+		return false
+	}
+	if proof.LeafCount == nil || proof.End().Cmp(proof.LeafCount) == 1 {
+		return false
+	}
+	leaves := make([]*Node, len(proof.Leaves))
+	idx := big.NewInt(0).Set(proof.Start)
+	for i, data := range proof.Leaves {
+		leaves[i] = &Node{NewPosition(0, idx), data}
+		idx = big.NewInt(0).Add(idx, big.NewInt(1))
+	}
+	return VerifyMultiProof(config, root, &MultiProof{
+		LeafCount: proof.LeafCount,
+		Leaves:    leaves,
+		Branches:  proof.Branches,
+	})
+}
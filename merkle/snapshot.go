@@ -0,0 +1,213 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package merkle
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+)
+
+// errors
+var (
+	ErrInvalidSnapshot = errors.New("invalid snapshot")
+)
+
+// SnapshotVersion is the first byte of tree snapshot
+const SnapshotVersion = 2
+
+// legacySnapshotVersion encodes positions with Position.LegacyBytes, it's still loaded
+const legacySnapshotVersion = 1
+
+// nodes read from store at once
+const snapshotBatchSize = 1000
+
+// maximum length of node data and position, so that a corrupted length doesn't allocate too much
+const maxSnapshotFieldSize = 1 << 16
+
+/*
+Snapshot writes all nodes of the tree in a deterministic order,
+from the leaves to the root and by index in each level.
+
+	version (1 byte), branch factor (1 byte)
+	leaf count
+	position, data of each node
+
+Byte fields are prefixed with their length as uvarint.
+*/
+func (tree *Tree) Snapshot(w io.Writer) error {
+	leafCount := tree.store.GetLeafCount()
+	if leafCount.Sign() != 1 {
+		return ErrInvalidLeafCount
+	}
+	bw := bufio.NewWriter(w)
+	b := []byte{SnapshotVersion, tree.calc.BranchFactor()}
+	b = appendBytes(b, leafCount.Bytes())
+	if _, err := bw.Write(b); err != nil {
+		return err
+	}
+	rowSize := leafCount
+	height := tree.calc.Height(leafCount)
+	for level := uint8(0); level < height; level++ {
+		positions := make([]*Position, 0, snapshotBatchSize)
+		for idx := big.NewInt(0); idx.Cmp(rowSize) == -1; idx = big.NewInt(0).Add(idx, big.NewInt(1)) {
+			positions = append(positions, NewPosition(level, idx))
+			if len(positions) == snapshotBatchSize {
+				if err := tree.writeSnapshotNodes(bw, positions); err != nil {
+					return err
+				}
+				positions = positions[:0]
+			}
+		}
+		if err := tree.writeSnapshotNodes(bw, positions); err != nil {
+			return err
+		}
+		rowSize = tree.calc.GroupCount(rowSize)
+	}
+	return bw.Flush()
+}
+
+func (tree *Tree) writeSnapshotNodes(w io.Writer, positions []*Position) error {
+	if len(positions) == 0 {
+		return nil
+	}
+	for i, data := range tree.store.GetNodes(positions) {
+		if data == nil {
+			return fmt.Errorf("%w, %s", ErrNodeNotFound, positions[i])
+		}
+		b := appendBytes(nil, positions[i].Bytes())
+		b = appendBytes(b, data)
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadSnapshot reads the snapshot written by Snapshot and returns it as an update,
+// to be commited to the store of the tree. The branches are recomputed from the leaves
+// and must be the same as the snapshot. The whole tree is kept in memory.
+func (tree *Tree) LoadSnapshot(r io.Reader) (*UpdateResult, error) {
+	sr := &snapshotReader{r: bufio.NewReader(r), invalid: ErrInvalidSnapshot}
+	header := sr.read(2)
+	if sr.err != nil {
+		return nil, sr.err
+	}
+	if header[0] != SnapshotVersion && header[0] != legacySnapshotVersion {
+		return nil, fmt.Errorf("%w, unsupported version %d", ErrInvalidSnapshot, header[0])
+	}
+	if header[1] != tree.calc.BranchFactor() {
+		return nil, fmt.Errorf("%w, branch factor %d, tree has %d",
+			ErrInvalidSnapshot, header[1], tree.calc.BranchFactor())
+	}
+	sr.legacy = header[0] == legacySnapshotVersion
+	leafCount := big.NewInt(0).SetBytes(sr.bytes())
+	if sr.err != nil {
+		return nil, sr.err
+	}
+	if leafCount.Sign() != 1 {
+		return nil, fmt.Errorf("%w, no leaves", ErrInvalidSnapshot)
+	}
+	leaves, err := sr.nodes(0, leafCount)
+	if err != nil {
+		return nil, err
+	}
+	res := NewTree(NewMapStore(), tree.config).update(leaves, leafCount, false)
+	rowSize := tree.calc.GroupCount(leafCount)
+	branches := res.Branches
+	for level := uint8(1); level < res.Height; level++ {
+		nodes, err := sr.nodes(level, rowSize)
+		if err != nil {
+			return nil, err
+		}
+		if len(nodes) > len(branches) {
+			return nil, fmt.Errorf("%w, too many nodes at level %d", ErrInvalidSnapshot, level)
+		}
+		for i, n := range nodes {
+			if !bytes.Equal(n.Position.Bytes(), branches[i].Position.Bytes()) ||
+				!bytes.Equal(n.Data, branches[i].Data) {
+				return nil, fmt.Errorf("%w, node %s doesn't match leaves", ErrInvalidSnapshot, n.Position)
+			}
+		}
+		branches = branches[len(nodes):]
+		rowSize = tree.calc.GroupCount(rowSize)
+	}
+	return res, nil
+}
+
+// snapshotReader keeps the first error, later reads return zero values
+type snapshotReader struct {
+	r       *bufio.Reader
+	err     error
+	invalid error // error of malformed input
+	legacy  bool  // positions are encoded with Position.LegacyBytes
+}
+
+func (sr *snapshotReader) fail(err error) {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		err = fmt.Errorf("%w, unexpected end", sr.invalid)
+	}
+	sr.err = err
+}
+
+func (sr *snapshotReader) read(n int) []byte {
+	if sr.err != nil {
+		return nil
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(sr.r, b); err != nil {
+		sr.fail(err)
+		return nil
+	}
+	return b
+}
+
+func (sr *snapshotReader) uvarint() uint64 {
+	if sr.err != nil {
+		return 0
+	}
+	v, err := binary.ReadUvarint(sr.r)
+	if err != nil {
+		sr.fail(err)
+	}
+	return v
+}
+
+func (sr *snapshotReader) bytes() []byte {
+	l := sr.uvarint()
+	if sr.err != nil {
+		return nil
+	}
+	if l > maxSnapshotFieldSize {
+		sr.err = fmt.Errorf("%w, field of %d bytes", sr.invalid, l)
+		return nil
+	}
+	return sr.read(int(l))
+}
+
+// nodes reads the nodes of a level and checks their positions
+func (sr *snapshotReader) nodes(level uint8, rowSize *big.Int) ([]*Node, error) {
+	nodes := make([]*Node, 0)
+	for idx := big.NewInt(0); idx.Cmp(rowSize) == -1; idx = big.NewInt(0).Add(idx, big.NewInt(1)) {
+		pos := sr.bytes()
+		data := sr.bytes()
+		if sr.err != nil {
+			return nil, sr.err
+		}
+		p := NewPosition(level, idx)
+		expected := p.Bytes()
+		if sr.legacy {
+			expected = p.LegacyBytes()
+		}
+		if !bytes.Equal(pos, expected) {
+			return nil, fmt.Errorf("%w, expected node %s", ErrInvalidSnapshot, p)
+		}
+		nodes = append(nodes, &Node{p, data})
+	}
+	return nodes, nil
+}
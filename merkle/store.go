@@ -8,11 +8,33 @@ import (
 	"sync"
 )
 
-// Store is merkle tree store
-type Store interface {
+// NodeReader reads the tree nodes
+type NodeReader interface {
 	GetLeafCount() *big.Int
 	GetHeight() uint8
 	GetNode(p *Position) []byte
+
+	// GetNodes reads the nodes at once, the value is nil for a missing node
+	GetNodes(positions []*Position) [][]byte
+}
+
+// Store is merkle tree store
+type Store interface {
+	NodeReader
+}
+
+// SnapshotStore is optionally implemented by Store to read a consistent tree while updates are commited.
+// The snapshot reads the tree of the last update commited before it is taken, see UpdateCommitter.
+// Tree.Root and Tree.Verify read the snapshot. A snapshot holding resources implements io.Closer,
+// it is closed once read.
+type SnapshotStore interface {
+	Snapshot() NodeReader
+}
+
+// RootStore is optionally implemented by Store to keep the roots of older trees.
+// Updates which keep the same leaf count replace the root of that leaf count.
+type RootStore interface {
+	GetRoot(leafCount *big.Int) []byte
 }
 
 // MapStore is simple Store implementation
@@ -20,16 +42,26 @@ type MapStore struct {
 	leafCount *big.Int
 	height    uint8
 	nodes     map[string][]byte
+	roots     map[string][]byte
 	mtx       sync.RWMutex
+
+	// nodes are read by snapshots, the next commit writes a copy
+	shared bool
 }
 
-var _ Store = (*MapStore)(nil)
+var (
+	_ Store           = (*MapStore)(nil)
+	_ RootStore       = (*MapStore)(nil)
+	_ SnapshotStore   = (*MapStore)(nil)
+	_ UpdateCommitter = (*MapStore)(nil)
+)
 
 // NewMapStore create a new MapStore
 func NewMapStore() *MapStore {
 	return &MapStore{
 		leafCount: big.NewInt(0),
 		nodes:     make(map[string][]byte),
+		roots:     make(map[string][]byte),
 	}
 }
 
@@ -57,11 +89,52 @@ func (ms *MapStore) GetNode(p *Position) []byte {
 	return ms.nodes[p.String()]
 }
 
-// CommitUpdate commits tree node updates
+// GetNodes implement Store
+func (ms *MapStore) GetNodes(positions []*Position) [][]byte {
+	ms.mtx.RLock()
+	defer ms.mtx.RUnlock()
+
+	values := make([][]byte, len(positions))
+	for i, p := range positions {
+		values[i] = ms.nodes[p.String()]
+	}
+	return values
+}
+
+// GetRoot implement RootStore
+func (ms *MapStore) GetRoot(leafCount *big.Int) []byte {
+	ms.mtx.RLock()
+	defer ms.mtx.RUnlock()
+
+	return ms.roots[leafCount.String()]
+}
+
+// Snapshot implement SnapshotStore, the nodes are copied on the next commit instead
+func (ms *MapStore) Snapshot() NodeReader {
+	ms.mtx.Lock()
+	defer ms.mtx.Unlock()
+
+	ms.shared = true
+	return &mapSnapshot{
+		leafCount: ms.leafCount,
+		height:    ms.height,
+		nodes:     ms.nodes,
+	}
+}
+
+// CommitUpdate implement UpdateCommitter
 func (ms *MapStore) CommitUpdate(res *UpdateResult) {
 	ms.mtx.Lock()
 	defer ms.mtx.Unlock()
 
+	if ms.shared {
+		nodes := make(map[string][]byte, len(ms.nodes))
+		for key, data := range ms.nodes {
+			nodes[key] = data
+		}
+		ms.nodes = nodes
+		ms.shared = false
+	}
 	ms.leafCount = res.LeafCount
 	ms.height = res.Height
 	for _, n := range res.Leaves {
@@ -70,4 +143,34 @@ func (ms *MapStore) CommitUpdate(res *UpdateResult) {
 	for _, n := range res.Branches {
 		ms.nodes[n.Position.String()] = n.Data
 	}
+	if res.Root != nil {
+		ms.roots[res.LeafCount.String()] = res.Root.Data
+	}
+}
+
+// mapSnapshot reads the nodes map which MapStore no longer writes
+type mapSnapshot struct {
+	leafCount *big.Int
+	height    uint8
+	nodes     map[string][]byte
+}
+
+func (s *mapSnapshot) GetLeafCount() *big.Int {
+	return s.leafCount
+}
+
+func (s *mapSnapshot) GetHeight() uint8 {
+	return s.height
+}
+
+func (s *mapSnapshot) GetNode(p *Position) []byte {
+	return s.nodes[p.String()]
+}
+
+func (s *mapSnapshot) GetNodes(positions []*Position) [][]byte {
+	values := make([][]byte, len(positions))
+	for i, p := range positions {
+		values[i] = s.nodes[p.String()]
+	}
+	return values
 }
@@ -31,6 +31,7 @@ func TestMapStore(t *testing.T) {
 		},
 	}
 
+	upd.Root = upd.Branches[0]
 	ms.CommitUpdate(upd)
 
 	assert.Equal(upd.Height, ms.GetHeight())
@@ -38,4 +39,45 @@ func TestMapStore(t *testing.T) {
 	assert.Equal([]byte{1, 1}, ms.GetNode(NewPosition(0, big.NewInt(0))))
 	assert.Equal([]byte{2, 2}, ms.GetNode(NewPosition(0, big.NewInt(1))))
 	assert.Equal([]byte{3, 3}, ms.GetNode(NewPosition(1, big.NewInt(0))))
+	assert.Equal([]byte{3, 3}, ms.GetRoot(upd.LeafCount))
+
+	assert.Equal([][]byte{{2, 2}, nil, {3, 3}}, ms.GetNodes([]*Position{
+		NewPosition(0, big.NewInt(1)),
+		NewPosition(0, big.NewInt(2)),
+		NewPosition(1, big.NewInt(0)),
+	}))
+	assert.Nil(ms.GetRoot(big.NewInt(1)))
+}
+
+func TestMapStore_Snapshot(t *testing.T) {
+	assert := assert.New(t)
+
+	ms := NewMapStore()
+	p0 := NewPosition(0, big.NewInt(0))
+	p1 := NewPosition(0, big.NewInt(1))
+	ms.CommitUpdate(&UpdateResult{
+		LeafCount: big.NewInt(1),
+		Height:    1,
+		Leaves:    []*Node{{p0, []byte{1}}},
+	})
+	snap := ms.Snapshot()
+	ms.CommitUpdate(&UpdateResult{
+		LeafCount: big.NewInt(2),
+		Height:    2,
+		Leaves:    []*Node{{p0, []byte{2}}, {p1, []byte{3}}},
+	})
+
+	assert.Equal(big.NewInt(1), snap.GetLeafCount())
+	assert.Equal(uint8(1), snap.GetHeight())
+	assert.Equal([][]byte{{1}, nil}, snap.GetNodes([]*Position{p0, p1}))
+	assert.Equal([][]byte{{2}, {3}}, ms.GetNodes([]*Position{p0, p1}))
+
+	// commits without snapshots write in place
+	ms.CommitUpdate(&UpdateResult{
+		LeafCount: big.NewInt(2),
+		Height:    2,
+		Leaves:    []*Node{{p1, []byte{4}}},
+	})
+	assert.Equal([]byte{4}, ms.GetNode(p1))
+	assert.Nil(snap.GetNode(p1))
 }
@@ -6,12 +6,25 @@ package merkle
 import (
 	"bytes"
 	"crypto"
+	"errors"
+	"fmt"
+	"io"
 	"math/big"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+// errors
+var (
+	ErrRootNotFound = errors.New("root not found")
 )
 
 type Config struct {
-	Hash            crypto.Hash
-	BranchFactor    uint8
+	Hash         crypto.Hash
+	BranchFactor uint8
+
+	// workers to compute parent nodes of a level, GOMAXPROCS if zero
 	ConcurrentLimit int
 }
 
@@ -30,15 +43,21 @@ func NewTree(store Store, config Config) *Tree {
 	if tree.config.BranchFactor < 2 {
 		tree.config.BranchFactor = 2
 	}
-	if tree.config.ConcurrentLimit == 0 {
-		tree.config.ConcurrentLimit = 20
+	if tree.config.ConcurrentLimit <= 0 {
+		tree.config.ConcurrentLimit = runtime.GOMAXPROCS(0)
 	}
 	tree.calc = NewTreeCalc(tree.config.BranchFactor)
 	return tree
 }
 
-// Root returns the root node of the tree
+// Root returns the root node of the tree, read from a snapshot if the store is a SnapshotStore
 func (tree *Tree) Root() *Node {
+	st, release := tree.snapshot()
+	defer release()
+	return st.root()
+}
+
+func (tree *Tree) root() *Node {
 	p := NewPosition(tree.store.GetHeight()-1, big.NewInt(0))
 	if data := tree.store.GetNode(p); data != nil {
 		return &Node{p, data}
@@ -46,9 +65,228 @@ func (tree *Tree) Root() *Node {
 	return nil
 }
 
+// RootAtLeafCount returns the root of the latest tree committed with the given leaf count.
+// The store must implement RootStore to find the roots of older trees.
+func (tree *Tree) RootAtLeafCount(leafCount *big.Int) (*Node, error) {
+	if leafCount == nil || leafCount.Sign() != 1 {
+		return nil, ErrInvalidLeafCount
+	}
+	p := NewPosition(tree.calc.Height(leafCount)-1, big.NewInt(0))
+	if rs, ok := tree.store.(RootStore); ok {
+		if data := rs.GetRoot(leafCount); data != nil {
+			return &Node{p, data}, nil
+		}
+	}
+	if leafCount.Cmp(tree.store.GetLeafCount()) == 0 {
+		if root := tree.Root(); root != nil {
+			return root, nil
+		}
+	}
+	return nil, ErrRootNotFound
+}
+
+// AtVersion returns the read-only tree at the version to find the root, verify and prove leaves.
+// The store must implement VersionedStore. It returns ErrVersionPruned if the version is no longer kept,
+// the nodes pruned while the returned tree is read are missing.
+func (tree *Tree) AtVersion(version uint64) (*Tree, error) {
+	vs, ok := tree.store.(VersionedStore)
+	if !ok {
+		return nil, ErrVersionNotSupported
+	}
+	leafCount, err := vs.GetLeafCountAt(version)
+	if err != nil {
+		return nil, err
+	}
+	view := &versionView{
+		store:     vs,
+		version:   version,
+		leafCount: leafCount,
+		height:    tree.calc.Height(leafCount),
+	}
+	return NewTree(view, tree.config), nil
+}
+
+// RootAt returns the root of the tree at the version, nil if the tree is empty
+func (tree *Tree) RootAt(version uint64) (*Node, error) {
+	vt, err := tree.AtVersion(version)
+	if err != nil {
+		return nil, err
+	}
+	if vt.store.GetLeafCount().Sign() == 0 {
+		return nil, nil
+	}
+	root := vt.Root()
+	if root == nil {
+		return nil, fmt.Errorf("%w, version %d", ErrRootNotFound, version)
+	}
+	return root, nil
+}
+
+// VerifyAt verifies leaves with the root of the tree at the version
+func (tree *Tree) VerifyAt(leaves []*Node, version uint64) (bool, error) {
+	vt, err := tree.AtVersion(version)
+	if err != nil {
+		return false, err
+	}
+	return vt.Verify(leaves), nil
+}
+
+// versionView is the Store of the tree at a version,
+// the nodes are missing if the version is pruned after the view is created
+type versionView struct {
+	store     VersionedStore
+	version   uint64
+	leafCount *big.Int
+	height    uint8
+}
+
+func (v *versionView) GetLeafCount() *big.Int {
+	return v.leafCount
+}
+
+func (v *versionView) GetHeight() uint8 {
+	return v.height
+}
+
+func (v *versionView) GetNode(p *Position) []byte {
+	data, _ := v.store.GetNodeAt(p, v.version)
+	return data
+}
+
+func (v *versionView) GetNodes(positions []*Position) [][]byte {
+	values, err := v.store.GetNodesAt(positions, v.version)
+	if err != nil {
+		return make([][]byte, len(positions))
+	}
+	return values
+}
+
+// snapshot returns the tree reading a snapshot of the store, or the tree itself if the store doesn't take snapshots.
+// The snapshot is closed by release.
+func (tree *Tree) snapshot() (*Tree, func()) {
+	ss, ok := tree.store.(SnapshotStore)
+	if !ok {
+		return tree, func() {}
+	}
+	snap := ss.Snapshot()
+	release := func() {
+		if c, ok := snap.(io.Closer); ok {
+			c.Close()
+		}
+	}
+	return &Tree{store: snap, config: tree.config, calc: tree.calc}, release
+}
+
+// TreeStats summarizes the size of the tree
+type TreeStats struct {
+	Height    uint8    `json:"height"`
+	LeafCount *big.Int `json:"leafCount"`
+	NodeCount *big.Int `json:"nodeCount"` // leaves and branches of the current tree
+}
+
+// Stats computes the node count from the row sizes of each level, without scanning the store.
+// Nodes left beyond the current leaf count after the tree shrinks are not counted.
+func (tree *Tree) Stats() TreeStats {
+	stats := TreeStats{
+		Height:    tree.store.GetHeight(),
+		LeafCount: tree.store.GetLeafCount(),
+		NodeCount: big.NewInt(0),
+	}
+	rowSize := stats.LeafCount
+	for i := uint8(0); i < stats.Height; i++ {
+		stats.NodeCount.Add(stats.NodeCount, rowSize)
+		rowSize = tree.calc.GroupCount(rowSize)
+	}
+	return stats
+}
+
 // Update accepts new/modified tree leaves,
 // recompute the corresponding nodes until root node.
+// Branches which hash to the same data as in the store are left out of the result,
+// the root is always included.
 func (tree *Tree) Update(leaves []*Node, newLeafCount *big.Int) *UpdateResult {
+	return tree.update(leaves, newLeafCount, true)
+}
+
+// UpdateAt is Update with the result tagged with the version,
+// a VersionedStore commits the update as the tree at the version, see AtVersion
+func (tree *Tree) UpdateAt(leaves []*Node, newLeafCount *big.Int, version uint64) *UpdateResult {
+	res := tree.update(leaves, newLeafCount, true)
+	res.Version = version
+	return res
+}
+
+// Delete removes the leaves at the positions and shrinks the tree to newLeafCount leaves.
+// The remaining leaves beyond newLeafCount are moved into the holes in order of index,
+// the moves are given in the result to reindex them.
+// The height shrinks with the leaf count, nodes beyond the new tree are left in the store.
+func (tree *Tree) Delete(positions []*Position, newLeafCount *big.Int) (*UpdateResult, error) {
+	leafCount := tree.store.GetLeafCount()
+	deleted := make(map[string]struct{}, len(positions))
+	holes := make([]*Position, 0)
+	for _, p := range positions {
+		if p == nil || p.Level() != 0 || leafCount.Cmp(p.Index()) != 1 {
+			return nil, ErrInvalidPosition
+		}
+		if _, found := deleted[p.String()]; found {
+			continue
+		}
+		deleted[p.String()] = struct{}{}
+		if newLeafCount != nil && newLeafCount.Cmp(p.Index()) == 1 {
+			holes = append(holes, p)
+		}
+	}
+	if newLeafCount == nil || newLeafCount.Sign() == -1 ||
+		big.NewInt(0).Sub(leafCount, newLeafCount).Cmp(big.NewInt(int64(len(deleted)))) != 0 {
+		return nil, ErrInvalidLeafCount
+	}
+	if newLeafCount.Sign() == 0 {
+		return &UpdateResult{
+			LeafCount: newLeafCount,
+			Leaves:    make([]*Node, 0),
+			Branches:  make([]*Node, 0),
+			Moves:     make([]*LeafMove, 0),
+		}, nil
+	}
+	sort.Slice(holes, func(i, j int) bool {
+		return holes[i].Index().Cmp(holes[j].Index()) == -1
+	})
+
+	// the remaining trailing leaves fill the holes
+	from := make([]*Position, 0, len(holes)+1)
+	for idx := big.NewInt(0).Set(newLeafCount); idx.Cmp(leafCount) == -1; idx.Add(idx, big.NewInt(1)) {
+		p := NewPosition(0, big.NewInt(0).Set(idx))
+		if _, found := deleted[p.String()]; !found {
+			from = append(from, p)
+		}
+	}
+	// the last leaf recomputes the right edge of the smaller tree
+	last := NewPosition(0, big.NewInt(0).Sub(newLeafCount, big.NewInt(1)))
+	if _, found := deleted[last.String()]; !found {
+		from = append(from, last)
+	}
+	values := tree.store.GetNodes(from)
+	leaves := make([]*Node, 0, len(from))
+	moves := make([]*LeafMove, len(holes))
+	for i, p := range from {
+		if values[i] == nil {
+			return nil, ErrLeafNotFound
+		}
+		to := p
+		if i < len(holes) {
+			to = holes[i]
+			moves[i] = &LeafMove{From: p, To: to}
+		}
+		leaves = append(leaves, &Node{to, values[i]})
+	}
+	res := tree.update(leaves, newLeafCount, true)
+	res.Moves = moves
+	return res, nil
+}
+
+// update computes the branches of the leaves, skipUnchanged leaves out the branches
+// with the same data in the store
+func (tree *Tree) update(leaves []*Node, newLeafCount *big.Int, skipUnchanged bool) *UpdateResult {
 	res := &UpdateResult{
 		LeafCount: newLeafCount,
 		Height:    tree.calc.Height(newLeafCount),
@@ -59,55 +297,110 @@ func (tree *Tree) Update(leaves []*Node, newLeafCount *big.Int) *UpdateResult {
 	rowSize := newLeafCount
 
 	for i := uint8(0); i < res.Height-1; i++ {
-		nodes = tree.updateOneLevel(nodes, rowSize)
-		res.Branches = append(res.Branches, nodes...)
+		// leaves are not compared, the branches of the previous level are
+		compare := skipUnchanged && i > 0
+		parents, changed := tree.updateOneLevel(nodes, rowSize, compare)
+		if i > 0 {
+			res.Branches = append(res.Branches, changed...)
+		}
+		nodes = parents
 		rowSize = tree.calc.GroupCount(rowSize)
 	}
 	if res.Height > 1 {
-		res.Root = res.Branches[len(res.Branches)-1]
+		res.Root = nodes[0]
+		res.Branches = append(res.Branches, res.Root)
 	} else {
 		res.Root = res.Leaves[0]
 	}
 	return res
 }
 
-func (tree *Tree) updateOneLevel(nodes []*Node, rowSize *big.Int) []*Node {
-	groups, gnodes := tree.groupNodesByParent(nodes)
-	parents := make([]*Node, 0, len(groups))
-	jobs, out := tree.spawnWorkers(rowSize, len(groups))
-	defer close(jobs) // to stop workers
-	for _, g := range groups {
-		for _, n := range gnodes[g.parentPosition.String()] {
-			g.SetNode(n) // set updated nodes in blocks
+// updateOneLevel computes the parents of nodes in order of parent index.
+// Groups of the same level are independent, they are computed concurrently.
+// It also returns the nodes, without the ones having the same data in the store if compare is set.
+func (tree *Tree) updateOneLevel(nodes []*Node, rowSize *big.Int, compare bool) ([]*Node, []*Node) {
+	groups := tree.groupNodesByParent(nodes)
+	changed := nodes
+	if compare {
+		positions := make([]*Position, len(nodes))
+		for i, n := range nodes {
+			positions[i] = n.Position
+		}
+		stored := tree.loadGroups(groups, rowSize, positions)
+		changed = make([]*Node, 0, len(nodes))
+		for i, n := range nodes {
+			if !bytes.Equal(n.Data, stored[i]) {
+				changed = append(changed, n)
+			}
 		}
-		jobs <- g
+	} else {
+		tree.loadGroups(groups, rowSize, nil)
 	}
-	for i := 0; i < len(groups); i++ {
-		p := <-out
-		parents = append(parents, p)
+	parents := make([]*Node, len(groups))
+	workers := tree.config.ConcurrentLimit
+	if workers > len(groups) {
+		workers = len(groups)
 	}
-	return parents
-}
-
-func (tree *Tree) spawnWorkers(rowSize *big.Int, nResult int) (chan *Group, chan *Node) {
-	jobs := make(chan *Group, tree.config.ConcurrentLimit)
-	out := make(chan *Node, nResult)
-	for i := 0; i < tree.config.ConcurrentLimit; i++ {
-		go tree.worker(rowSize, jobs, out)
+	if workers <= 1 {
+		for i, g := range groups {
+			parents[i] = g.MakeParent()
+		}
+		return parents, changed
+	}
+	jobs := make(chan int, workers)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				parents[i] = groups[i].MakeParent()
+			}
+		}()
 	}
-	return jobs, out
+	for i := range groups {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return parents, changed
 }
 
-func (tree *Tree) worker(rowSize *big.Int, jobs <-chan *Group, out chan<- *Node) {
-	for g := range jobs {
-		g.Load(rowSize)
-		out <- g.MakeParent()
+// loadGroups loads the child nodes of the groups with one store read.
+// The stored data of the extra positions are read together and returned.
+func (tree *Tree) loadGroups(groups []*Group, rowSize *big.Int, extra []*Position) [][]byte {
+	positions := make([]*Position, 0)
+	owners := make([]*Group, 0)
+	for _, g := range groups {
+		for _, p := range g.missingPositions(rowSize) {
+			positions = append(positions, p)
+			owners = append(owners, g)
+		}
+	}
+	missing := len(positions)
+	positions = append(positions, extra...)
+	if len(positions) == 0 {
+		return nil
+	}
+	values := tree.store.GetNodes(positions)
+	for i, data := range values[:missing] {
+		if data != nil {
+			owners[i].SetNode(&Node{positions[i], data})
+		}
 	}
+	return values[missing:]
 }
 
-// Verify verifies leaves with the current root-node.
+// Verify verifies leaves with the current root-node,
+// the tree is read from a snapshot if the store is a SnapshotStore.
 func (tree *Tree) Verify(leaves []*Node) bool {
-	root := tree.Root()
+	st, release := tree.snapshot()
+	defer release()
+	return st.verify(leaves)
+}
+
+func (tree *Tree) verify(leaves []*Node) bool {
+	root := tree.root()
 	if root == nil {
 		return false
 	}
@@ -126,22 +419,25 @@ func (tree *Tree) Verify(leaves []*Node) bool {
 			return false
 		}
 	}
-	res := tree.Update(leaves, leafCount)
+	res := tree.update(leaves, leafCount, false)
 	return bytes.Equal(root.Data, res.Root.Data)
 }
 
-func (tree *Tree) groupNodesByParent(nodes []*Node) (map[string]*Group, map[string][]*Node) {
+// groupNodesByParent returns groups with updated nodes set, sorted by parent index
+func (tree *Tree) groupNodesByParent(nodes []*Node) []*Group {
 	ngmap := tree.getGroupPositions(nodes)
-	bpos := ngmap.UniqueMap()
-	groups := tree.makeGroups(bpos)
-	gnodes := make(map[string][]*Node, len(bpos))
-	for key := range bpos {
-		gnodes[key] = make([]*Node, 0)
-	}
+	gmap := tree.makeGroups(ngmap.UniqueMap())
 	for i, p := range ngmap {
-		gnodes[p.String()] = append(gnodes[p.String()], nodes[i])
+		gmap[p.String()].SetNode(nodes[i])
 	}
-	return groups, gnodes
+	groups := make([]*Group, 0, len(gmap))
+	for _, g := range gmap {
+		groups = append(groups, g)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].parentPosition.Index().Cmp(groups[j].parentPosition.Index()) == -1
+	})
+	return groups
 }
 
 func (tree *Tree) getGroupPositions(nodes []*Node) Positions {
@@ -80,3 +80,35 @@ func (tc *TreeCalc) NodeIndexInGroup(nodeIdx *big.Int) int {
 	idx := big.NewInt(0)
 	return int(idx.Mod(nodeIdx, tc.bfactor).Int64())
 }
+
+// SubtreeCover gives the positions of the largest complete subtrees
+// which cover the leaves from index start to end (exclusive), in order of leaf index.
+//
+// e.g branch factor 2, leaves [0, 7)
+//
+//	[0     ]
+//	[0   ] [1 ]
+//	[0] [1] [2] [3]
+//	0 1 2 3 4 5 6 _	// positions are (2, 0), (1, 2), (0, 6)
+func (tc *TreeCalc) SubtreeCover(start, end *big.Int) []*Position {
+	positions := make([]*Position, 0)
+	idx := big.NewInt(0).Set(start)
+	for idx.Cmp(end) == -1 {
+		var level uint8
+		size := big.NewInt(1)
+		for {
+			next := big.NewInt(0).Mul(size, tc.bfactor)
+			if big.NewInt(0).Mod(idx, next).Sign() != 0 {
+				break
+			}
+			if big.NewInt(0).Add(idx, next).Cmp(end) == 1 {
+				break
+			}
+			level++
+			size = next
+		}
+		positions = append(positions, NewPosition(level, big.NewInt(0).Div(idx, size)))
+		idx.Add(idx, size)
+	}
+	return positions
+}
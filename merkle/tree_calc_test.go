@@ -108,3 +108,33 @@ func TestTreeCalc_NodeIndexInGroup(t *testing.T) {
 		})
 	}
 }
+
+func TestTreeCalc_SubtreeCover(t *testing.T) {
+	tests := []struct {
+		name   string
+		bf     uint8
+		start  int64
+		end    int64
+		expect []*Position
+	}{
+		{"empty", 2, 3, 3, []*Position{}},
+		{"single", 2, 0, 1, []*Position{NewPosition(0, big.NewInt(0))}},
+		{"full tree", 2, 0, 8, []*Position{NewPosition(3, big.NewInt(0))}},
+		{"from zero", 2, 0, 7, []*Position{
+			NewPosition(2, big.NewInt(0)), NewPosition(1, big.NewInt(2)), NewPosition(0, big.NewInt(6)),
+		}},
+		{"from middle", 2, 3, 9, []*Position{
+			NewPosition(0, big.NewInt(3)), NewPosition(2, big.NewInt(1)), NewPosition(0, big.NewInt(8)),
+		}},
+		{"branch factor 3", 3, 2, 13, []*Position{
+			NewPosition(0, big.NewInt(2)), NewPosition(1, big.NewInt(1)),
+			NewPosition(1, big.NewInt(2)), NewPosition(1, big.NewInt(3)), NewPosition(0, big.NewInt(12)),
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := NewTreeCalc(tt.bf)
+			assert.Equal(t, tt.expect, tc.SubtreeCover(big.NewInt(tt.start), big.NewInt(tt.end)))
+		})
+	}
+}
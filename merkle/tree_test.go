@@ -4,8 +4,12 @@
 package merkle
 
 import (
+	"bytes"
 	"crypto"
+	"fmt"
 	"math/big"
+	"math/rand"
+	"runtime"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -54,6 +58,49 @@ func TestTree_Root(t *testing.T) {
 	assert.Equal(upd.Branches[2], tree.Root())
 }
 
+// storeWithoutRoots hides RootStore of the embedded store
+type storeWithoutRoots struct {
+	Store
+}
+
+func TestTree_RootAtLeafCount(t *testing.T) {
+	assert := assert.New(t)
+
+	store := NewMapStore()
+	tree := NewTree(store, Config{Hash: crypto.SHA1, BranchFactor: 3})
+
+	store.CommitUpdate(tree.Update(makeLeaves(0, 5), big.NewInt(5)))
+	root5 := tree.Root()
+	store.CommitUpdate(tree.Update(makeLeaves(5, 12), big.NewInt(12)))
+	store.CommitUpdate(tree.Update([]*Node{{NewPosition(0, big.NewInt(1)), []byte{100}}}, big.NewInt(12)))
+	root12 := tree.Root()
+
+	root, err := tree.RootAtLeafCount(big.NewInt(5))
+	assert.NoError(err)
+	assert.Equal(root5, root)
+
+	// rebuild the older tree to check the root
+	rebuilt := NewTree(NewMapStore(), Config{Hash: crypto.SHA1, BranchFactor: 3})
+	assert.Equal(root5, rebuilt.Update(makeLeaves(0, 5), big.NewInt(5)).Root)
+
+	root, err = tree.RootAtLeafCount(big.NewInt(12))
+	assert.NoError(err)
+	assert.Equal(root12, root, "latest root of the same leaf count")
+
+	_, err = tree.RootAtLeafCount(big.NewInt(7))
+	assert.ErrorIs(err, ErrRootNotFound)
+	_, err = tree.RootAtLeafCount(big.NewInt(0))
+	assert.ErrorIs(err, ErrInvalidLeafCount)
+
+	// only the current root without RootStore
+	tree = NewTree(storeWithoutRoots{store}, Config{Hash: crypto.SHA1, BranchFactor: 3})
+	root, err = tree.RootAtLeafCount(big.NewInt(12))
+	assert.NoError(err)
+	assert.Equal(root12, root)
+	_, err = tree.RootAtLeafCount(big.NewInt(5))
+	assert.ErrorIs(err, ErrRootNotFound)
+}
+
 func TestTree_Update(t *testing.T) {
 	assert := assert.New(t)
 
@@ -76,6 +123,7 @@ func TestTree_Update(t *testing.T) {
 	n20 := sha1Sum(append(n10, append(n11, n12...)...))
 
 	assert.Equal(11, len(store.nodes))
+	assert.Equal(TreeStats{3, big.NewInt(7), big.NewInt(11)}, tree.Stats())
 	assert.Equal(n10, store.GetNode(NewPosition(1, big.NewInt(0))))
 	assert.Equal(n11, store.GetNode(NewPosition(1, big.NewInt(1))))
 	assert.Equal(n20, store.GetNode(NewPosition(2, big.NewInt(0))))
@@ -101,6 +149,7 @@ func TestTree_Update(t *testing.T) {
 	n30 := sha1Sum(append(n20, n21...))
 
 	assert.Equal(17, len(store.nodes))
+	assert.Equal(TreeStats{4, big.NewInt(10), big.NewInt(17)}, tree.Stats())
 	assert.Equal(n10, store.GetNode(NewPosition(1, big.NewInt(0))))
 	assert.Equal(n11, store.GetNode(NewPosition(1, big.NewInt(1))))
 	assert.Equal(n12, store.GetNode(NewPosition(1, big.NewInt(2))))
@@ -122,6 +171,9 @@ func TestTree_Update(t *testing.T) {
 	n12 = sha1Sum([]byte{2})
 	n20 = sha1Sum(append(n10, append(n11, n12...)...))
 
+	// nodes beyond the leaf count are left in store
+	assert.Equal(TreeStats{3, big.NewInt(7), big.NewInt(11)}, tree.Stats())
+
 	assert.Equal(n12, store.GetNode(NewPosition(1, big.NewInt(2))))
 	assert.Equal(n20, store.GetNode(NewPosition(2, big.NewInt(0))))
 }
@@ -160,3 +212,519 @@ func TestTree_Verify(t *testing.T) {
 		{leaves[5].Position, []byte{1}},
 	}))
 }
+
+func TestTree_RangeProof(t *testing.T) {
+	store := NewMapStore()
+	config := Config{Hash: crypto.SHA1, BranchFactor: 3}
+	tree := NewTree(store, config)
+
+	leaves := make([]*Node, 7)
+	for i := range leaves {
+		leaves[i] = &Node{NewPosition(0, big.NewInt(int64(i))), []byte{uint8(i)}}
+	}
+	store.CommitUpdate(tree.Update(leaves, big.NewInt(7)))
+	root := tree.Root().Data
+
+	assert := assert.New(t)
+	proof, err := tree.RangeProof(big.NewInt(2), big.NewInt(5))
+	assert.NoError(err)
+	assert.Equal([][]byte{{2}, {3}, {4}}, proof.Leaves)
+	assert.EqualValues(5, proof.End().Int64())
+	assert.True(VerifyRangeProof(config, root, proof))
+
+	// shared ancestors make it smaller than the single leaf proofs
+	singleSize := 0
+	for _, n := range leaves[2:5] {
+		p, err := tree.GenerateProof(n)
+		assert.NoError(err)
+		singleSize += len(p.Siblings)
+	}
+	assert.Less(len(proof.Branches), singleSize)
+
+	tampered := *proof
+	tampered.Leaves = [][]byte{{2}, {4}, {3}}
+	assert.False(VerifyRangeProof(config, root, &tampered), "reordered leaves")
+
+	tampered = *proof
+	tampered.Start = big.NewInt(3)
+	assert.False(VerifyRangeProof(config, root, &tampered), "shifted range")
+
+	tampered = *proof
+	tampered.Leaves = proof.Leaves[:2]
+	assert.False(VerifyRangeProof(config, root, &tampered), "omitted leaf")
+
+	proof, err = tree.RangeProof(big.NewInt(0), big.NewInt(7))
+	assert.NoError(err)
+	assert.Empty(proof.Branches, "whole tree")
+	assert.True(VerifyRangeProof(config, root, proof))
+
+	_, err = tree.RangeProof(big.NewInt(3), big.NewInt(3))
+	assert.ErrorIs(err, ErrInvalidPosition)
+	_, err = tree.RangeProof(big.NewInt(5), big.NewInt(8))
+	assert.ErrorIs(err, ErrLeafNotFound)
+}
+
+func newUpdateTestTree(concurrentLimit int, leafCount, updateCount int) (*Tree, []*Node, *big.Int) {
+	store := NewMapStore()
+	config := Config{Hash: crypto.SHA256, BranchFactor: 8, ConcurrentLimit: concurrentLimit}
+	tree := NewTree(store, config)
+	leaves := make([]*Node, leafCount)
+	for i := range leaves {
+		leaves[i] = &Node{NewPosition(0, big.NewInt(int64(i))), sha1Sum([]byte{byte(i), byte(i >> 8)})}
+	}
+	store.CommitUpdate(tree.Update(leaves, big.NewInt(int64(leafCount))))
+
+	// update every (leafCount / updateCount)th leaf, in reverse order
+	updates := make([]*Node, 0, updateCount)
+	step := leafCount / updateCount
+	for i := leafCount - 1; i >= 0 && len(updates) < updateCount; i -= step {
+		updates = append(updates, &Node{NewPosition(0, big.NewInt(int64(i))), sha1Sum([]byte{byte(i), 1})})
+	}
+	return tree, updates, big.NewInt(int64(leafCount))
+}
+
+func TestTree_UpdateConcurrent(t *testing.T) {
+	assert := assert.New(t)
+
+	serial, updates, leafCount := newUpdateTestTree(1, 5000, 1000)
+	concurrent, _, _ := newUpdateTestTree(8, 5000, 1000)
+	assert.Equal(runtime.GOMAXPROCS(0), NewTree(nil, Config{}).config.ConcurrentLimit)
+
+	want := serial.Update(updates, leafCount)
+	got := concurrent.Update(updates, leafCount)
+	assert.Equal(want.Root.Data, got.Root.Data)
+	assert.Equal(len(want.Branches), len(got.Branches))
+	for i := range want.Branches {
+		assert.Equal(want.Branches[i].Position.Bytes(), got.Branches[i].Position.Bytes())
+		assert.Equal(want.Branches[i].Data, got.Branches[i].Data)
+	}
+	for i := 1; i < len(got.Branches); i++ {
+		prev, cur := got.Branches[i-1].Position, got.Branches[i].Position
+		if prev.Level() == cur.Level() {
+			assert.Equal(-1, prev.Index().Cmp(cur.Index()), "sorted by index in a level")
+		}
+	}
+}
+
+// TestTree_UpdateConcurrentRandom checks serial and concurrent updates give the same branches
+// on random updates, which also grow the tree
+func TestTree_UpdateConcurrentRandom(t *testing.T) {
+	assert := assert.New(t)
+
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 20; i++ {
+		config := Config{Hash: crypto.SHA256, BranchFactor: uint8(2 + rnd.Intn(15))}
+		serialStore, concurrentStore := NewMapStore(), NewMapStore()
+		config.ConcurrentLimit = 1
+		serial := NewTree(serialStore, config)
+		config.ConcurrentLimit = 8
+		concurrent := NewTree(concurrentStore, config)
+
+		leafCount := 0
+		for round := 0; round < 5; round++ {
+			grow := rnd.Intn(500)
+			updates := make([]*Node, 0)
+			for idx := 0; idx < leafCount+grow; idx++ {
+				if idx < leafCount && rnd.Intn(4) != 0 {
+					continue
+				}
+				data := make([]byte, 8)
+				rnd.Read(data)
+				updates = append(updates, &Node{NewPosition(0, big.NewInt(int64(idx))), data})
+			}
+			if len(updates) == 0 {
+				continue
+			}
+			leafCount += grow
+			want := serial.Update(updates, big.NewInt(int64(leafCount)))
+			got := concurrent.Update(updates, big.NewInt(int64(leafCount)))
+			assert.Equal(want.Root.Data, got.Root.Data)
+			if assert.Equal(len(want.Branches), len(got.Branches)) {
+				for j := range want.Branches {
+					assert.Equal(want.Branches[j].Position.Bytes(), got.Branches[j].Position.Bytes())
+					assert.Equal(want.Branches[j].Data, got.Branches[j].Data)
+				}
+			}
+			serialStore.CommitUpdate(want)
+			concurrentStore.CommitUpdate(got)
+		}
+	}
+}
+
+func BenchmarkTree_Update(b *testing.B) {
+	for _, leafCount := range []int{10000, 100000, 1000000} {
+		for _, bm := range []struct {
+			name            string
+			concurrentLimit int
+		}{
+			{"serial", 1},
+			{"gomaxprocs", 0},
+		} {
+			// a tenth of the leaves are updated
+			b.Run(fmt.Sprintf("%d/%s", leafCount, bm.name), func(b *testing.B) {
+				tree, updates, count := newUpdateTestTree(bm.concurrentLimit, leafCount, leafCount/10)
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					tree.Update(updates, count)
+				}
+			})
+		}
+	}
+}
+
+// countingStore counts the store reads, GetNodes is one read of many nodes
+type countingStore struct {
+	*MapStore
+	reads int
+	nodes int
+}
+
+func (cs *countingStore) GetNode(p *Position) []byte {
+	cs.reads++
+	cs.nodes++
+	return cs.MapStore.GetNode(p)
+}
+
+func (cs *countingStore) GetNodes(positions []*Position) [][]byte {
+	cs.reads++
+	cs.nodes += len(positions)
+	return cs.MapStore.GetNodes(positions)
+}
+
+func TestTree_UpdateBatchedReads(t *testing.T) {
+	assert := assert.New(t)
+
+	tree, updates, leafCount := newUpdateTestTree(4, 5000, 100)
+	want := tree.Update(updates, leafCount)
+
+	store := &countingStore{MapStore: tree.store.(*MapStore)}
+	tree.store = store
+	got := tree.Update(updates, leafCount)
+	assert.Equal(want.Root.Data, got.Root.Data)
+	// levels with all nodes updated don't need to read
+	assert.LessOrEqual(store.reads, int(got.Height)-1, "at most one read per level")
+	assert.Greater(store.reads, 0)
+	assert.Greater(store.nodes, 100)
+
+	store.CommitUpdate(got)
+	store.reads = 0
+	assert.True(tree.Verify(updates[:10]))
+	assert.LessOrEqual(store.reads, int(got.Height)) // and the root
+}
+
+func BenchmarkTree_UpdateReads(b *testing.B) {
+	tree, updates, leafCount := newUpdateTestTree(0, 100000, 10000)
+	store := &countingStore{MapStore: tree.store.(*MapStore)}
+	tree.store = store
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tree.Update(updates, leafCount)
+	}
+	// nodes/op is the number of reads before batching
+	b.ReportMetric(float64(store.reads)/float64(b.N), "reads/op")
+	b.ReportMetric(float64(store.nodes)/float64(b.N), "nodes/op")
+}
+
+func TestTree_UpdateSkipsUnchanged(t *testing.T) {
+	assert := assert.New(t)
+
+	store := NewMapStore()
+	tree := NewTree(store, Config{Hash: crypto.SHA1, BranchFactor: 3})
+	leaves := makeLeaves(0, 20)
+	res := tree.Update(leaves, big.NewInt(20))
+	assert.Len(res.Branches, 7+3+1, "all branches of a new tree")
+	store.CommitUpdate(res)
+	root := tree.Root()
+
+	// rewriting the same leaves changes only the root, which is kept
+	res = tree.Update(makeLeaves(4, 8), big.NewInt(20))
+	assert.Len(res.Branches, 1)
+	assert.Equal(root, res.Root)
+	assert.Equal(res.Root, res.Branches[0])
+
+	// the branches above a changed leaf
+	leaf := &Node{NewPosition(0, big.NewInt(7)), []byte{100}}
+	res = tree.Update(append(makeLeaves(4, 7), leaf), big.NewInt(20))
+	assert.Len(res.Branches, 3)
+	for i, n := range res.Branches {
+		assert.EqualValues(i+1, n.Position.Level())
+	}
+	store.CommitUpdate(res)
+	assert.True(tree.Verify([]*Node{leaf}))
+
+	uncached := NewTree(NewMapStore(), tree.config)
+	leaves[7] = leaf
+	assert.Equal(uncached.Update(leaves, big.NewInt(20)).Root.Data, tree.Root().Data)
+}
+
+// BenchmarkTree_UpdateUnchanged updates 10 leaves of 1M leaves, half of them with the same data
+// and the others with new data each time. nodes/op is the number of nodes written.
+func BenchmarkTree_UpdateUnchanged(b *testing.B) {
+	tree, updates, leafCount := newUpdateTestTree(0, 1000000, 10)
+	store := tree.store.(*MapStore)
+	for i := 0; i < len(updates); i += 2 {
+		updates[i] = &Node{updates[i].Position, store.GetNode(updates[i].Position)}
+	}
+	for _, bm := range []struct {
+		name          string
+		skipUnchanged bool
+	}{
+		{"all", false},
+		{"skip", true},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			nodes := 0
+			for i := 0; i < b.N; i++ {
+				for j := 1; j < len(updates); j += 2 {
+					updates[j] = &Node{updates[j].Position, sha1Sum([]byte{byte(i), byte(i >> 8), byte(j)})}
+				}
+				res := tree.update(updates, leafCount, bm.skipUnchanged)
+				store.CommitUpdate(res)
+				nodes += len(res.Leaves) + len(res.Branches)
+			}
+			b.ReportMetric(float64(nodes)/float64(b.N), "nodes/op")
+		})
+	}
+}
+
+func positionsOf(indexes ...int64) []*Position {
+	positions := make([]*Position, len(indexes))
+	for i, idx := range indexes {
+		positions[i] = NewPosition(0, big.NewInt(idx))
+	}
+	return positions
+}
+
+func TestTree_Delete(t *testing.T) {
+	tests := []struct {
+		name      string
+		leafCount int
+		deleted   []int64
+		moves     [][2]int64
+		remaining []int // data of the remaining leaves in order of index
+		height    uint8
+	}{
+		{"middle", 10, []int64{2, 5}, [][2]int64{{8, 2}, {9, 5}},
+			[]int{0, 1, 8, 3, 4, 9, 6, 7}, 4},
+		{"middle and end", 10, []int64{9, 3, 8}, [][2]int64{{7, 3}},
+			[]int{0, 1, 2, 7, 4, 5, 6}, 4},
+		{"end", 10, []int64{8, 9}, nil, []int{0, 1, 2, 3, 4, 5, 6, 7}, 4},
+		{"shrink height", 10, []int64{0, 4, 5, 6, 7, 8}, [][2]int64{{9, 0}},
+			[]int{9, 1, 2, 3}, 3},
+		{"one left", 10, []int64{0, 1, 2, 3, 4, 5, 6, 7, 8}, [][2]int64{{9, 0}},
+			[]int{9}, 1},
+		{"none", 10, nil, nil, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			store := NewMapStore()
+			tree := NewTree(store, Config{Hash: crypto.SHA1, BranchFactor: 2})
+			store.CommitUpdate(tree.Update(makeLeaves(0, tt.leafCount), big.NewInt(int64(tt.leafCount))))
+
+			newLeafCount := big.NewInt(int64(len(tt.remaining)))
+			res, err := tree.Delete(positionsOf(tt.deleted...), newLeafCount)
+			if !assert.NoError(err) {
+				return
+			}
+			assert.Equal(newLeafCount, res.LeafCount)
+			assert.Equal(tt.height, res.Height)
+			if assert.Len(res.Moves, len(tt.moves)) {
+				for i, m := range tt.moves {
+					assert.EqualValues(m[0], res.Moves[i].From.Index().Int64())
+					assert.EqualValues(m[1], res.Moves[i].To.Index().Int64())
+				}
+			}
+			store.CommitUpdate(res)
+
+			expected := make([]*Node, len(tt.remaining))
+			for i, data := range tt.remaining {
+				expected[i] = &Node{NewPosition(0, big.NewInt(int64(i))), []byte{uint8(data)}}
+			}
+			fresh := NewTree(NewMapStore(), tree.config)
+			assert.Equal(fresh.Update(expected, newLeafCount).Root, tree.Root())
+			assert.True(tree.Verify(expected))
+			assert.Equal(tt.height, tree.Stats().Height)
+
+			// nodes left beyond the smaller tree don't affect the tree growing again
+			grown := append(expected, makeLeaves(len(expected), len(expected)+3)...)
+			store.CommitUpdate(tree.Update(grown[len(expected):], big.NewInt(int64(len(grown)))))
+			fresh = NewTree(NewMapStore(), tree.config)
+			assert.Equal(fresh.Update(grown, big.NewInt(int64(len(grown)))).Root, tree.Root())
+		})
+	}
+}
+
+func TestTree_DeleteAll(t *testing.T) {
+	assert := assert.New(t)
+
+	store := NewMapStore()
+	tree := NewTree(store, Config{Hash: crypto.SHA1, BranchFactor: 3})
+	store.CommitUpdate(tree.Update(makeLeaves(0, 5), big.NewInt(5)))
+
+	res, err := tree.Delete(positionsOf(4, 0, 1, 3, 2), big.NewInt(0))
+	assert.NoError(err)
+	assert.EqualValues(0, res.LeafCount.Int64())
+	assert.EqualValues(0, res.Height)
+	assert.Nil(res.Root)
+	assert.Empty(res.Moves)
+	store.CommitUpdate(res)
+	assert.Nil(tree.Root())
+	assert.False(tree.Verify(makeLeaves(0, 1)))
+
+	store.CommitUpdate(tree.Update(makeLeaves(0, 2), big.NewInt(2)))
+	fresh := NewTree(NewMapStore(), tree.config)
+	assert.Equal(fresh.Update(makeLeaves(0, 2), big.NewInt(2)).Root, tree.Root())
+}
+
+func TestTree_DeleteInvalid(t *testing.T) {
+	assert := assert.New(t)
+
+	store := NewMapStore()
+	tree := NewTree(store, Config{Hash: crypto.SHA1, BranchFactor: 2})
+	store.CommitUpdate(tree.Update(makeLeaves(0, 5), big.NewInt(5)))
+
+	_, err := tree.Delete(positionsOf(1), big.NewInt(3))
+	assert.ErrorIs(err, ErrInvalidLeafCount, "leaf count mismatch")
+	_, err = tree.Delete(positionsOf(1, 1), big.NewInt(3))
+	assert.ErrorIs(err, ErrInvalidLeafCount, "duplicates")
+	_, err = tree.Delete(positionsOf(1), nil)
+	assert.ErrorIs(err, ErrInvalidLeafCount)
+	_, err = tree.Delete(positionsOf(5), big.NewInt(4))
+	assert.ErrorIs(err, ErrInvalidPosition, "out of range")
+	_, err = tree.Delete([]*Position{NewPosition(1, big.NewInt(0))}, big.NewInt(4))
+	assert.ErrorIs(err, ErrInvalidPosition, "not leaf")
+	_, err = tree.Delete([]*Position{nil}, big.NewInt(4))
+	assert.ErrorIs(err, ErrInvalidPosition)
+
+	missing := NewMapStore()
+	missing.CommitUpdate(&UpdateResult{LeafCount: big.NewInt(5), Height: 4})
+	_, err = NewTree(missing, tree.config).Delete(positionsOf(1), big.NewInt(4))
+	assert.ErrorIs(err, ErrLeafNotFound)
+}
+
+func TestTree_Snapshot(t *testing.T) {
+	store := NewMapStore()
+	config := Config{Hash: crypto.SHA1, BranchFactor: 3}
+	tree := NewTree(store, config)
+
+	assert := assert.New(t)
+	assert.ErrorIs(tree.Snapshot(new(bytes.Buffer)), ErrInvalidLeafCount)
+
+	leaves := make([]*Node, 11)
+	for i := range leaves {
+		leaves[i] = &Node{NewPosition(0, big.NewInt(int64(i))), []byte{uint8(i)}}
+	}
+	store.CommitUpdate(tree.Update(leaves, big.NewInt(11)))
+
+	buf := new(bytes.Buffer)
+	assert.NoError(tree.Snapshot(buf))
+	data := buf.Bytes()
+
+	buf2 := new(bytes.Buffer)
+	assert.NoError(tree.Snapshot(buf2))
+	assert.Equal(data, buf2.Bytes(), "deterministic")
+
+	loadStore := NewMapStore()
+	loaded := NewTree(loadStore, config)
+	res, err := loaded.LoadSnapshot(bytes.NewReader(data))
+	assert.NoError(err)
+	loadStore.CommitUpdate(res)
+	assert.Equal(tree.Root(), loaded.Root())
+	assert.True(loaded.Verify(leaves))
+
+	_, err = NewTree(NewMapStore(), Config{Hash: crypto.SHA1, BranchFactor: 4}).
+		LoadSnapshot(bytes.NewReader(data))
+	assert.ErrorIs(err, ErrInvalidSnapshot, "branch factor")
+
+	_, err = loaded.LoadSnapshot(bytes.NewReader(data[:len(data)-3]))
+	assert.ErrorIs(err, ErrInvalidSnapshot, "truncated")
+
+	// the root is the last node
+	tampered := append([]byte{}, data...)
+	tampered[len(tampered)-1]++
+	_, err = loaded.LoadSnapshot(bytes.NewReader(tampered))
+	assert.ErrorIs(err, ErrInvalidSnapshot, "tampered root")
+}
+
+func TestTree_LoadLegacySnapshot(t *testing.T) {
+	assert := assert.New(t)
+
+	store := NewMapStore()
+	config := Config{Hash: crypto.SHA1, BranchFactor: 3}
+	tree := NewTree(store, config)
+	store.CommitUpdate(tree.Update(makeLeaves(0, 300), big.NewInt(300)))
+
+	// version 1 snapshot with legacy positions
+	b := []byte{legacySnapshotVersion, 3}
+	b = appendBytes(b, big.NewInt(300).Bytes())
+	rowSize := big.NewInt(300)
+	for level := uint8(0); level < tree.calc.Height(big.NewInt(300)); level++ {
+		for idx := int64(0); idx < rowSize.Int64(); idx++ {
+			p := NewPosition(level, big.NewInt(idx))
+			b = appendBytes(b, p.LegacyBytes())
+			b = appendBytes(b, store.GetNode(p))
+		}
+		rowSize = tree.calc.GroupCount(rowSize)
+	}
+	res, err := NewTree(NewMapStore(), config).LoadSnapshot(bytes.NewReader(b))
+	if assert.NoError(err) {
+		assert.Equal(tree.Root(), res.Root)
+	}
+
+	// legacy positions of a version 2 snapshot
+	b[0] = SnapshotVersion
+	_, err = NewTree(NewMapStore(), config).LoadSnapshot(bytes.NewReader(b))
+	assert.ErrorIs(err, ErrInvalidSnapshot)
+}
+
+// TestTree_VerifyDuringUpdates verifies the leaves which never change while the tree grows,
+// torn reads of the root, leaf count and nodes fail the verification. Run it with -race.
+func TestTree_VerifyDuringUpdates(t *testing.T) {
+	config := Config{Hash: crypto.SHA1, BranchFactor: 3}
+	stores := map[string]Store{
+		"map":     NewMapStore(),
+		"caching": NewCachingStore(NewMapStore(), 20),
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			tree := NewTree(store, config)
+			leaves := make([]*Node, 10)
+			for i := range leaves {
+				leaves[i] = &Node{NewPosition(0, big.NewInt(int64(i))), []byte{byte(i)}}
+			}
+			store.(UpdateCommitter).CommitUpdate(tree.Update(leaves, big.NewInt(int64(len(leaves)))))
+
+			done := make(chan struct{})
+			failed := make(chan *Node, 4)
+			for i := 0; i < 4; i++ {
+				go func() {
+					for {
+						select {
+						case <-done:
+							failed <- nil
+							return
+						default:
+						}
+						leaf := leaves[rand.Intn(len(leaves))]
+						if !tree.Verify([]*Node{leaf}) || tree.Root() == nil {
+							failed <- leaf
+							return
+						}
+					}
+				}()
+			}
+			for count := len(leaves); count < 500; count++ {
+				n := &Node{NewPosition(0, big.NewInt(int64(count))), []byte{byte(count), 1}}
+				store.(UpdateCommitter).CommitUpdate(tree.Update([]*Node{n}, big.NewInt(int64(count+1))))
+			}
+			close(done)
+			for i := 0; i < 4; i++ {
+				if leaf := <-failed; leaf != nil {
+					assert.Fail(t, "verify failed during updates", "leaf %s", leaf.Position)
+				}
+			}
+		})
+	}
+}
@@ -5,10 +5,14 @@ package merkle
 
 import (
 	"crypto"
+	"fmt"
 	"math/big"
 )
 
-// Position of a node in the tree
+// Position of a node in the tree.
+//
+// It's encoded as the level (1 byte), the length of the index (1 byte) and the index in big-endian
+// without leading zeros, so that positions sort by level and index under bytewise comparison.
 type Position struct {
 	level uint8
 	index *big.Int
@@ -16,17 +20,31 @@ type Position struct {
 	str   string
 }
 
-// UnmarshalPosition unmarshals position from raw bytes
-func UnmarshalPosition(b []byte) *Position {
+// maxIndexLength is the maximum length of index bytes in the encoding
+const maxIndexLength = 255
+
+// ParsePosition decodes position from the bytes of Position.Bytes
+func ParsePosition(b []byte) (*Position, error) {
+	if len(b) < 2 || len(b) != 2+int(b[1]) {
+		return nil, fmt.Errorf("%w, %d bytes", ErrInvalidPosition, len(b))
+	}
+	if b[1] > 0 && b[2] == 0 {
+		return nil, fmt.Errorf("%w, leading zero", ErrInvalidPosition)
+	}
 	p := new(Position)
-	p.bytes = b
+	p.bytes = copyBytes(b)
 	p.level = b[0]
-	p.index = big.NewInt(0).SetBytes(b[1:])
+	p.index = big.NewInt(0).SetBytes(b[2:])
 	p.setString()
-	return p
+	return p, nil
 }
 
-// NewPosition create a new position
+// UnmarshalPosition unmarshals position from the legacy encoding, see LegacyBytes
+func UnmarshalPosition(b []byte) *Position {
+	return NewPosition(b[0], big.NewInt(0).SetBytes(b[1:]))
+}
+
+// NewPosition create a new position, the index must not be longer than 255 bytes
 func NewPosition(level uint8, index *big.Int) *Position {
 	p := new(Position)
 	p.level = level
@@ -38,11 +56,11 @@ func NewPosition(level uint8, index *big.Int) *Position {
 
 func (p *Position) setBytes() {
 	ib := p.index.Bytes()
-	if len(ib) == 0 {
-		ib = []byte{0}
+	if len(ib) > maxIndexLength {
+		panic("merkle: position index too large")
 	}
-	p.bytes = make([]byte, 0, 1+len(ib))
-	p.bytes = append(p.bytes, p.level)
+	p.bytes = make([]byte, 0, 2+len(ib))
+	p.bytes = append(p.bytes, p.level, uint8(len(ib)))
 	p.bytes = append(p.bytes, ib...)
 }
 
@@ -66,6 +84,16 @@ func (p *Position) Bytes() []byte {
 	return p.bytes
 }
 
+// LegacyBytes returns the encoding of version 1 proofs and stores,
+// the level and the index bytes with one zero byte for index zero. It doesn't sort by index.
+func (p *Position) LegacyBytes() []byte {
+	ib := p.index.Bytes()
+	if len(ib) == 0 {
+		ib = []byte{0}
+	}
+	return append([]byte{p.level}, ib...)
+}
+
 func (p *Position) String() string {
 	return p.str
 }
@@ -90,6 +118,35 @@ type Node struct {
 	Data     []byte
 }
 
+// Marshal encodes node as the position bytes followed by the data
+func (n *Node) Marshal() ([]byte, error) {
+	if n.Position == nil {
+		return nil, ErrInvalidPosition
+	}
+	b := make([]byte, 0, len(n.Position.Bytes())+len(n.Data))
+	b = append(b, n.Position.Bytes()...)
+	return append(b, n.Data...), nil
+}
+
+// UnmarshalNode decodes node from the bytes of Node.Marshal
+func UnmarshalNode(b []byte) (*Node, error) {
+	if len(b) < 2 || len(b) < 2+int(b[1]) {
+		return nil, fmt.Errorf("%w, %d bytes", ErrInvalidPosition, len(b))
+	}
+	l := 2 + int(b[1])
+	p, err := ParsePosition(b[:l])
+	if err != nil {
+		return nil, err
+	}
+	return &Node{p, copyBytes(b[l:])}, nil
+}
+
+func copyBytes(b []byte) []byte {
+	c := make([]byte, len(b))
+	copy(c, b)
+	return c
+}
+
 // Group is a Group of child nodes under the same parent node
 type Group struct {
 	hashFunc       crypto.Hash
@@ -121,6 +178,17 @@ func (b *Group) SetNode(n *Node) *Group {
 
 // Load loads the child nodes from the store
 func (b *Group) Load(rowSize *big.Int) *Group {
+	for _, p := range b.missingPositions(rowSize) {
+		if data := b.store.GetNode(p); data != nil {
+			b.SetNode(&Node{p, data})
+		}
+	}
+	return b
+}
+
+// missingPositions gives the positions of the child nodes which are not set yet
+func (b *Group) missingPositions(rowSize *big.Int) []*Position {
+	positions := make([]*Position, 0, len(b.nodes))
 	offset := b.tc.FirstNodeOfGroup(b.parentPosition.Index())
 	for i, n := range b.nodes {
 		if n != nil {
@@ -130,13 +198,9 @@ func (b *Group) Load(rowSize *big.Int) *Group {
 		if rowSize.Cmp(index) != 1 {
 			break
 		}
-		p := NewPosition(b.parentPosition.level-1, index)
-
-		if data := b.store.GetNode(p); data != nil {
-			b.nodes[i] = &Node{p, data}
-		}
+		positions = append(positions, NewPosition(b.parentPosition.level-1, index))
 	}
-	return b
+	return positions
 }
 
 // MakeParent compute the sum of the child nodes and returns the parent node
@@ -178,4 +242,16 @@ type UpdateResult struct {
 	Leaves    []*Node
 	Branches  []*Node
 	Root      *Node
+
+	// Version tagged by Tree.UpdateAt, a VersionedStore keeps the tree at the version
+	Version uint64
+
+	// Moves are the leaves moved by Delete
+	Moves []*LeafMove
+}
+
+// LeafMove is a leaf moved to another index when the tree shrinks
+type LeafMove struct {
+	From *Position
+	To   *Position
 }
@@ -4,9 +4,12 @@
 package merkle
 
 import (
+	"bytes"
 	"crypto"
 	"crypto/sha1"
 	"math/big"
+	"math/rand"
+	"sort"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -14,15 +17,16 @@ import (
 
 func TestPosition(t *testing.T) {
 	tests := []struct {
-		name  string
-		level uint8
-		index *big.Int
-		want  []byte
+		name   string
+		level  uint8
+		index  *big.Int
+		want   []byte
+		legacy []byte
 	}{
-		{"level 0, index 0", 0, big.NewInt(0), []byte{0, 0}},
-		{"index 0", 1, big.NewInt(0), []byte{1, 0}},
-		{"index max 8 bit", 1, big.NewInt(255), []byte{1, 255}},
-		{"index first 16 bit", 1, big.NewInt(256), []byte{1, 1, 0}},
+		{"level 0, index 0", 0, big.NewInt(0), []byte{0, 0}, []byte{0, 0}},
+		{"index 0", 1, big.NewInt(0), []byte{1, 0}, []byte{1, 0}},
+		{"index max 8 bit", 1, big.NewInt(255), []byte{1, 1, 255}, []byte{1, 255}},
+		{"index first 16 bit", 1, big.NewInt(256), []byte{1, 2, 1, 0}, []byte{1, 1, 0}},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -31,15 +35,124 @@ func TestPosition(t *testing.T) {
 
 			assert.EqualValues(tt.want, p.Bytes())
 			assert.Equal(string(tt.want), p.String())
+			assert.EqualValues(tt.legacy, p.LegacyBytes())
 
-			p1 := UnmarshalPosition(p.Bytes())
-
+			p1, err := ParsePosition(p.Bytes())
+			assert.NoError(err)
 			assert.Equal(p.Level(), p1.Level())
 			assert.Equal(0, p.Index().Cmp(p1.Index()))
+
+			p1 = UnmarshalPosition(p.LegacyBytes())
+			assert.Equal(p.Bytes(), p1.Bytes())
 		})
 	}
 }
 
+func randomPositions(count int) []*Position {
+	positions := make([]*Position, 0, count)
+	max := big.NewInt(0).Lsh(big.NewInt(1), 128)
+	for level := 0; level < 256; level += 15 {
+		for _, idx := range []*big.Int{
+			big.NewInt(0), big.NewInt(1), big.NewInt(255), big.NewInt(256), max,
+			big.NewInt(0).Sub(max, big.NewInt(1)),
+		} {
+			positions = append(positions, NewPosition(uint8(level), idx))
+		}
+	}
+	positions = append(positions, NewPosition(255, max))
+	for len(positions) < count {
+		idx := big.NewInt(0).Rand(rand.New(rand.NewSource(int64(len(positions)))), max)
+		idx.Rsh(idx, uint(rand.Intn(128))) // indexes of all lengths
+		positions = append(positions, NewPosition(uint8(rand.Intn(256)), idx))
+	}
+	return positions
+}
+
+func TestPosition_RoundTrip(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, p := range randomPositions(2000) {
+		p1, err := ParsePosition(p.Bytes())
+		if !assert.NoError(err) {
+			return
+		}
+		assert.Equal(p.Level(), p1.Level())
+		assert.Equal(0, p.Index().Cmp(p1.Index()), p.Index())
+		assert.Equal(p.String(), p1.String())
+	}
+}
+
+func TestPosition_Order(t *testing.T) {
+	assert := assert.New(t)
+
+	positions := randomPositions(2000)
+	compare := func(a, b *Position) int {
+		if a.Level() != b.Level() {
+			if a.Level() < b.Level() {
+				return -1
+			}
+			return 1
+		}
+		return a.Index().Cmp(b.Index())
+	}
+	for i := 1; i < len(positions); i++ {
+		a, b := positions[i-1], positions[i]
+		assert.Equal(compare(a, b), bytes.Compare(a.Bytes(), b.Bytes()),
+			"level %d index %s, level %d index %s", a.Level(), a.Index(), b.Level(), b.Index())
+	}
+	sorted := make([]*Position, len(positions))
+	copy(sorted, positions)
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i].Bytes(), sorted[j].Bytes()) == -1
+	})
+	for i := 1; i < len(sorted); i++ {
+		assert.NotEqual(1, compare(sorted[i-1], sorted[i]))
+	}
+}
+
+func TestParsePosition_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		b    []byte
+	}{
+		{"empty", nil},
+		{"no length", []byte{1}},
+		{"short", []byte{1, 2, 1}},
+		{"long", []byte{1, 1, 1, 0}},
+		{"leading zero", []byte{1, 2, 0, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParsePosition(tt.b)
+			assert.ErrorIs(t, err, ErrInvalidPosition)
+		})
+	}
+}
+
+func TestNode_Marshal(t *testing.T) {
+	assert := assert.New(t)
+
+	for i, p := range randomPositions(300) {
+		n := &Node{p, bytes.Repeat([]byte{byte(i)}, i%40)}
+		b, err := n.Marshal()
+		assert.NoError(err)
+		n1, err := UnmarshalNode(b)
+		if !assert.NoError(err) {
+			return
+		}
+		assert.Equal(n.Position.Bytes(), n1.Position.Bytes())
+		assert.Equal(len(n.Data), len(n1.Data))
+		assert.True(bytes.Equal(n.Data, n1.Data))
+	}
+
+	_, err := (&Node{Data: []byte{1}}).Marshal()
+	assert.ErrorIs(err, ErrInvalidPosition)
+	for _, b := range [][]byte{nil, {1}, {1, 3, 1, 1}, {1, 1, 0, 5}} {
+		_, err = UnmarshalNode(b)
+		assert.ErrorIs(err, ErrInvalidPosition, b)
+	}
+}
+
 func TestGroup_Load_Sum(t *testing.T) {
 	s1 := NewMapStore()
 	s2 := storeWith3Nodes()
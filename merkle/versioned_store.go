@@ -0,0 +1,222 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package merkle
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+)
+
+// errors
+var (
+	ErrVersionPruned       = errors.New("version pruned")
+	ErrVersionNotFound     = errors.New("version not found")
+	ErrVersionNotSupported = errors.New("store doesn't keep versions")
+)
+
+// VersionedStore is optionally implemented by Store to read the tree at the versions
+// tagged to the updates, see Tree.UpdateAt. The store keeps a bounded number of recent versions,
+// the reads of an older version fail with ErrVersionPruned.
+type VersionedStore interface {
+	Store
+
+	// GetLeafCountAt returns the leaf count at the version,
+	// ErrVersionNotFound if the version is not commited
+	GetLeafCountAt(version uint64) (*big.Int, error)
+
+	// GetNodeAt returns the node at the version, nil if the node is missing
+	GetNodeAt(p *Position, version uint64) ([]byte, error)
+
+	// GetNodesAt reads the nodes at the version at once, the value is nil for a missing node
+	GetNodesAt(positions []*Position, version uint64) ([][]byte, error)
+}
+
+// VersionedMapStore is a MapStore which keeps the changed nodes of the last versions.
+// The versions of the updates must not decrease, an update with the latest version replaces it.
+type VersionedMapStore struct {
+	*MapStore
+	keepVersions uint64
+
+	// versions are written while holding both, so the readers see each update at once
+	mtx sync.RWMutex
+
+	committed  bool
+	latest     uint64
+	leafCounts []versionedValue            // by version
+	history    map[string][]versionedValue // node data by position and version
+
+	// nodes replaced by a newer version, deleted once the version is the oldest kept
+	replaced map[uint64][]replacedNode
+}
+
+type versionedValue struct {
+	version   uint64
+	data      []byte
+	leafCount *big.Int
+}
+
+type replacedNode struct {
+	key     string
+	version uint64
+}
+
+var (
+	_ VersionedStore  = (*VersionedMapStore)(nil)
+	_ UpdateCommitter = (*VersionedMapStore)(nil)
+)
+
+// NewVersionedMapStore creates a VersionedMapStore keeping the tree at the last keepVersions versions,
+// which is at least one
+func NewVersionedMapStore(keepVersions uint64) *VersionedMapStore {
+	if keepVersions < 1 {
+		keepVersions = 1
+	}
+	return &VersionedMapStore{
+		MapStore:     NewMapStore(),
+		keepVersions: keepVersions,
+		leafCounts:   make([]versionedValue, 0),
+		history:      make(map[string][]versionedValue),
+		replaced:     make(map[uint64][]replacedNode),
+	}
+}
+
+// CommitUpdate commits tree node updates at the version of the update and prunes the versions
+// older than the kept ones. It panics if the version is older than the latest version.
+func (vs *VersionedMapStore) CommitUpdate(res *UpdateResult) {
+	vs.mtx.Lock()
+	defer vs.mtx.Unlock()
+
+	if vs.committed && res.Version < vs.latest {
+		panic(fmt.Sprintf("merkle: update version %d is older than %d", res.Version, vs.latest))
+	}
+	vs.MapStore.CommitUpdate(res)
+	vs.committed = true
+	vs.latest = res.Version
+	vs.leafCounts = appendVersion(vs.leafCounts, versionedValue{
+		version:   res.Version,
+		leafCount: res.LeafCount,
+	})
+	for _, nodes := range [][]*Node{res.Leaves, res.Branches} {
+		for _, n := range nodes {
+			vs.setVersion(n, res.Version)
+		}
+	}
+	vs.prune()
+}
+
+func (vs *VersionedMapStore) setVersion(n *Node, version uint64) {
+	key := n.Position.String()
+	values := vs.history[key]
+	if len(values) > 0 && values[len(values)-1].version < version {
+		prev := values[len(values)-1].version
+		vs.replaced[version] = append(vs.replaced[version], replacedNode{key, prev})
+	}
+	vs.history[key] = appendVersion(values, versionedValue{version: version, data: n.Data})
+}
+
+// appendVersion appends the value, or replaces the last value of the same version
+func appendVersion(values []versionedValue, v versionedValue) []versionedValue {
+	if len(values) > 0 && values[len(values)-1].version == v.version {
+		values[len(values)-1] = v
+		return values
+	}
+	return append(values, v)
+}
+
+// prune deletes the values replaced at or below the oldest kept version,
+// the newest value below it is the value at the oldest version
+func (vs *VersionedMapStore) prune() {
+	oldest := vs.oldestVersion()
+	for version, nodes := range vs.replaced {
+		if version > oldest {
+			continue
+		}
+		for _, rn := range nodes {
+			vs.history[rn.key] = deleteVersion(vs.history[rn.key], rn.version)
+		}
+		delete(vs.replaced, version)
+	}
+	i := findVersion(vs.leafCounts, oldest)
+	if i > 0 {
+		vs.leafCounts = vs.leafCounts[i:]
+	}
+}
+
+func deleteVersion(values []versionedValue, version uint64) []versionedValue {
+	for i, v := range values {
+		if v.version == version {
+			return append(values[:i], values[i+1:]...)
+		}
+	}
+	return values
+}
+
+// findVersion returns the index of the newest value at or below version, -1 if not found
+func findVersion(values []versionedValue, version uint64) int {
+	return sort.Search(len(values), func(i int) bool {
+		return values[i].version > version
+	}) - 1
+}
+
+func (vs *VersionedMapStore) oldestVersion() uint64 {
+	if vs.latest < vs.keepVersions {
+		return 0
+	}
+	return vs.latest - vs.keepVersions + 1
+}
+
+func (vs *VersionedMapStore) checkVersion(version uint64) error {
+	if !vs.committed || version > vs.latest {
+		return fmt.Errorf("%w, version %d", ErrVersionNotFound, version)
+	}
+	if oldest := vs.oldestVersion(); version < oldest {
+		return fmt.Errorf("%w, version %d is older than %d", ErrVersionPruned, version, oldest)
+	}
+	return nil
+}
+
+// GetLeafCountAt implement VersionedStore
+func (vs *VersionedMapStore) GetLeafCountAt(version uint64) (*big.Int, error) {
+	vs.mtx.RLock()
+	defer vs.mtx.RUnlock()
+
+	if err := vs.checkVersion(version); err != nil {
+		return nil, err
+	}
+	i := findVersion(vs.leafCounts, version)
+	if i < 0 {
+		return nil, fmt.Errorf("%w, version %d", ErrVersionNotFound, version)
+	}
+	return vs.leafCounts[i].leafCount, nil
+}
+
+// GetNodeAt implement VersionedStore
+func (vs *VersionedMapStore) GetNodeAt(p *Position, version uint64) ([]byte, error) {
+	values, err := vs.GetNodesAt([]*Position{p}, version)
+	if err != nil {
+		return nil, err
+	}
+	return values[0], nil
+}
+
+// GetNodesAt implement VersionedStore
+func (vs *VersionedMapStore) GetNodesAt(positions []*Position, version uint64) ([][]byte, error) {
+	vs.mtx.RLock()
+	defer vs.mtx.RUnlock()
+
+	if err := vs.checkVersion(version); err != nil {
+		return nil, err
+	}
+	values := make([][]byte, len(positions))
+	for i, p := range positions {
+		history := vs.history[p.String()]
+		if j := findVersion(history, version); j >= 0 {
+			values[i] = history[j].data
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,175 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package merkle
+
+import (
+	"crypto"
+	"math/big"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVersionedMapStore(t *testing.T) {
+	assert := assert.New(t)
+
+	vs := NewVersionedMapStore(2)
+	p0 := NewPosition(0, big.NewInt(0))
+	p1 := NewPosition(0, big.NewInt(1))
+
+	_, err := vs.GetNodeAt(p0, 0)
+	assert.ErrorIs(err, ErrVersionNotFound, "nothing commited")
+
+	vs.CommitUpdate(&UpdateResult{
+		LeafCount: big.NewInt(1),
+		Height:    1,
+		Leaves:    []*Node{{p0, []byte{1}}},
+		Version:   1,
+	})
+	_, err = vs.GetLeafCountAt(0)
+	assert.ErrorIs(err, ErrVersionNotFound, "before the first update")
+	vs.CommitUpdate(&UpdateResult{
+		LeafCount: big.NewInt(2),
+		Height:    2,
+		Leaves:    []*Node{{p1, []byte{2}}},
+		Version:   2,
+	})
+	assert.Equal([]byte{2}, vs.GetNode(p1), "latest nodes")
+
+	values, err := vs.GetNodesAt([]*Position{p0, p1}, 1)
+	assert.NoError(err)
+	assert.Equal([][]byte{{1}, nil}, values)
+	lc, err := vs.GetLeafCountAt(1)
+	assert.NoError(err)
+	assert.Equal(big.NewInt(1), lc)
+
+	_, err = vs.GetNodeAt(p0, 3)
+	assert.ErrorIs(err, ErrVersionNotFound)
+
+	// version 4 keeps 3 and 4, the tree at 3 is the tree at 2
+	vs.CommitUpdate(&UpdateResult{
+		LeafCount: big.NewInt(2),
+		Height:    2,
+		Leaves:    []*Node{{p0, []byte{3}}},
+		Version:   4,
+	})
+	_, err = vs.GetNodeAt(p0, 2)
+	assert.ErrorIs(err, ErrVersionPruned)
+	_, err = vs.GetLeafCountAt(1)
+	assert.ErrorIs(err, ErrVersionPruned)
+
+	values, err = vs.GetNodesAt([]*Position{p0, p1}, 3)
+	assert.NoError(err)
+	assert.Equal([][]byte{{1}, {2}}, values)
+	lc, err = vs.GetLeafCountAt(3)
+	assert.NoError(err)
+	assert.Equal(big.NewInt(2), lc)
+	values, err = vs.GetNodesAt([]*Position{p0, p1}, 4)
+	assert.NoError(err)
+	assert.Equal([][]byte{{3}, {2}}, values)
+
+	// the same version replaces it
+	vs.CommitUpdate(&UpdateResult{
+		LeafCount: big.NewInt(2),
+		Height:    2,
+		Leaves:    []*Node{{p1, []byte{4}}},
+		Version:   4,
+	})
+	values, err = vs.GetNodesAt([]*Position{p0, p1}, 4)
+	assert.NoError(err)
+	assert.Equal([][]byte{{3}, {4}}, values)
+
+	// version 10 keeps 9 and 10, p0 keeps only the value at 4
+	vs.CommitUpdate(&UpdateResult{
+		LeafCount: big.NewInt(2),
+		Height:    2,
+		Leaves:    []*Node{{p1, []byte{5}}},
+		Version:   10,
+	})
+	assert.Len(vs.history[p0.String()], 1)
+	assert.Len(vs.history[p1.String()], 2)
+	assert.Len(vs.leafCounts, 2)
+	values, err = vs.GetNodesAt([]*Position{p0, p1}, 9)
+	assert.NoError(err)
+	assert.Equal([][]byte{{3}, {4}}, values)
+
+	assert.Panics(func() {
+		vs.CommitUpdate(&UpdateResult{LeafCount: big.NewInt(2), Height: 2, Version: 9})
+	}, "older version")
+}
+
+func TestTree_AtVersion(t *testing.T) {
+	assert := assert.New(t)
+
+	config := Config{Hash: crypto.SHA1, BranchFactor: 3}
+	store := NewVersionedMapStore(3)
+	tree := NewTree(store, config)
+
+	_, err := NewTree(NewMapStore(), config).AtVersion(0)
+	assert.ErrorIs(err, ErrVersionNotSupported)
+
+	// leaf values and roots by version
+	leaves := make([][]*Node, 0)
+	roots := make([][]byte, 0)
+	current := make([]*Node, 0)
+	for version := uint64(0); version < 6; version++ {
+		upd := make([]*Node, 0)
+		for i := range current {
+			if rand.Intn(3) == 0 {
+				current[i] = &Node{current[i].Position, []byte{byte(version), byte(i), 1}}
+				upd = append(upd, current[i])
+			}
+		}
+		for i := 0; i < 1+rand.Intn(20); i++ {
+			n := &Node{NewPosition(0, big.NewInt(int64(len(current)))), []byte{byte(version), byte(i)}}
+			current = append(current, n)
+			upd = append(upd, n)
+		}
+		res := tree.UpdateAt(upd, big.NewInt(int64(len(current))), version)
+		assert.Equal(version, res.Version)
+		store.CommitUpdate(res)
+		leaves = append(leaves, append([]*Node{}, current...))
+		roots = append(roots, res.Root.Data)
+	}
+
+	for version := uint64(3); version < 6; version++ {
+		root, err := tree.RootAt(version)
+		assert.NoError(err)
+		assert.Equal(roots[version], root.Data)
+
+		vt, err := tree.AtVersion(version)
+		assert.NoError(err)
+		assert.EqualValues(len(leaves[version]), vt.Stats().LeafCount.Int64())
+		for _, leaf := range leaves[version] {
+			ok, err := tree.VerifyAt([]*Node{leaf}, version)
+			assert.NoError(err)
+			assert.True(ok, "version %d leaf %s", version, leaf.Position)
+
+			proof, err := vt.GenerateProof(leaf)
+			if assert.NoError(err) {
+				assert.True(VerifyProof(roots[version], proof, config.Hash, config.BranchFactor))
+			}
+		}
+		latest := leaves[len(leaves)-1]
+		for i, leaf := range leaves[version] {
+			if string(leaf.Data) != string(latest[i].Data) {
+				ok, err := tree.VerifyAt([]*Node{latest[i]}, version)
+				assert.NoError(err)
+				assert.False(ok, "changed after the version")
+			}
+		}
+	}
+
+	for version := uint64(0); version < 3; version++ {
+		_, err := tree.RootAt(version)
+		assert.ErrorIs(err, ErrVersionPruned)
+		_, err = tree.VerifyAt(leaves[version][:1], version)
+		assert.ErrorIs(err, ErrVersionPruned)
+		_, err = tree.AtVersion(version)
+		assert.ErrorIs(err, ErrVersionPruned)
+	}
+	_, err = tree.RootAt(6)
+	assert.ErrorIs(err, ErrVersionNotFound)
+}
@@ -4,42 +4,157 @@
 package node
 
 import (
+	"bytes"
+	"crypto/subtle"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"strconv"
 
+	"github.com/aungmawjj/juria-blockchain/consensus"
 	"github.com/aungmawjj/juria-blockchain/core"
 	"github.com/aungmawjj/juria-blockchain/execution"
 	"github.com/aungmawjj/juria-blockchain/execution/bincc"
 	"github.com/aungmawjj/juria-blockchain/logger"
+	"github.com/aungmawjj/juria-blockchain/merkle"
+	"github.com/aungmawjj/juria-blockchain/storage"
+	"github.com/aungmawjj/juria-blockchain/txpool"
 	"github.com/gin-gonic/gin"
 )
 
+// limits of state proof request and response
+const (
+	MaxStateProofKeys       = 100
+	MaxStateProofValueBytes = 1 << 20
+)
+
+// page size of txs by sender
+const (
+	DefaultTxsBySenderLimit = 100
+	MaxTxsBySenderLimit     = 1000
+)
+
+// page size of states by prefix
+const (
+	DefaultStatesByPrefixLimit = 100
+	MaxStatesByPrefixLimit     = 1000
+)
+
 type nodeAPI struct {
-	node *Node
+	node       *Node
+	svc        service
+	txLimits   core.TxLimits
+	adminToken string
+}
+
+// ErrorResponse renders a rejected request with a machine readable reason
+type ErrorResponse struct {
+	Reason string `json:"reason"`
+	Error  string `json:"error"`
+}
+
+// reasons of rejected txs
+const (
+	TxRejectInputTooLarge = "input_too_large"
+	TxRejectCodeAddr      = "invalid_code_addr"
+	TxRejectNilSender     = "nil_sender"
+)
+
+// txRejectReason returns the reason of ValidateBasic errors, empty for other errors
+func txRejectReason(err error) string {
+	switch {
+	case errors.Is(err, core.ErrTxInputTooLarge):
+		return TxRejectInputTooLarge
+	case errors.Is(err, core.ErrInvalidCodeAddr):
+		return TxRejectCodeAddr
+	case errors.Is(err, core.ErrNilSender):
+		return TxRejectNilSender
+	}
+	return ""
+}
+
+// BlockResponse renders block json with the proposer address added to it,
+// txs and tx commits are included for a commited block if requested with ?txs=true
+type BlockResponse struct {
+	Block        *core.Block
+	Proposer     core.Address
+	Transactions []*core.Transaction
+	TxCommits    []*core.TxCommit
+}
+
+// MarshalJSON keeps the fields of block json at the top level for existing clients
+func (resp *BlockResponse) MarshalJSON() ([]byte, error) {
+	b, err := json.Marshal(resp.Block)
+	if err != nil {
+		return nil, err
+	}
+	fields := make(map[string]interface{})
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return nil, err
+	}
+	fields["proposerAddress"] = resp.Proposer
+	if resp.Transactions != nil {
+		fields["txs"] = resp.Transactions
+	}
+	if resp.TxCommits != nil {
+		fields["txCommits"] = resp.TxCommits
+	}
+	return json.Marshal(fields)
+}
+
+// LeaderScheduleResponse renders the leaders approved by the node since start.
+// Leader indexes refer to Validators.
+type LeaderScheduleResponse struct {
+	Validators [][]byte           `json:"validators"`
+	Leaders    core.LeaderHistory `json:"leaders"`
+}
+
+// TxResponse renders transaction with the sender address
+type TxResponse struct {
+	Transaction *core.Transaction `json:"transaction"`
+	Sender      core.Address      `json:"sender"`
 }
 
 func serveNodeAPI(node *Node) {
-	api := &nodeAPI{node}
+	api := &nodeAPI{node, &nodeService{node}, node.config.TxLimits, node.config.AdminToken}
 
 	gin.SetMode(gin.ReleaseMode)
 	gin.DefaultWriter = io.Discard
 	r := gin.New()
 	r.Use(gin.Recovery())
 
+	r.GET("/health", api.getHealth)
+	r.GET("/storage/stats", api.getStorageStats)
+	r.GET("/storage/metrics", api.getStorageMetrics)
+	r.GET("/storage/info", api.getStorageInfo)
+	r.GET("/metrics", api.getPrometheusMetrics)
 	r.GET("/consensus", api.getConsensusStatus)
+	r.GET("/peers/scores", api.getPeerScores)
+	r.GET("/consensus/leaders", api.getLeaderSchedule)
+
+	admin := r.Group("/admin", api.authorizeAdmin)
+	admin.POST("/promote", api.promoteStandby)
+	admin.GET("/backup", api.backupStorage)
 
 	r.GET("/txpool", api.getTxPoolStatus)
 	r.POST("/transactions", api.submitTX)
+	r.GET("/transactions/:hash", api.getTx)
 	r.GET("/transactions/:hash/status", api.getTxStatus)
 	r.GET("/transactions/:hash/commit", api.getTxCommit)
+	r.GET("/transactions/:hash/proof", api.getTxProof)
+	r.GET("/senders/:sender/transactions", api.getTxsBySender)
 
 	r.GET("/blocks/:hash", api.getBlock)
+	r.GET("/blocks/:hash/commit", api.getBlockCommit)
 	r.GET("/blocksbyh/:height", api.getBlockByHeight)
 
 	r.POST("/querystate", api.queryState)
+	r.POST("/querystate/proof", api.getStateProof)
+	r.POST("/querystate/proofs", api.getStateProofs)
+	r.POST("/querystate/prefix", api.getStatesByPrefix)
 
 	r.POST("/bincc", api.uploadBinChainCode)
 	r.Static("/bincc", node.config.ExecutionConfig.BinccDir)
@@ -52,10 +167,111 @@ func serveNodeAPI(node *Node) {
 	}()
 }
 
+// authorizeAdmin rejects admin requests without "Bearer <token>" in authorization header,
+// admin api is disabled if the node has no admin token
+func (api *nodeAPI) authorizeAdmin(c *gin.Context) {
+	if api.adminToken == "" {
+		c.String(http.StatusForbidden, "admin api disabled")
+		c.Abort()
+		return
+	}
+	expected := []byte("Bearer " + api.adminToken)
+	if subtle.ConstantTimeCompare([]byte(c.GetHeader("Authorization")), expected) != 1 {
+		c.String(http.StatusUnauthorized, "invalid admin token")
+		c.Abort()
+		return
+	}
+	c.Next()
+}
+
+// HealthResponse reports resources which can degrade the node
+type HealthResponse struct {
+	Disk DiskStatus `json:"disk"`
+}
+
+func (api *nodeAPI) getHealth(c *gin.Context) {
+	c.JSON(http.StatusOK, &HealthResponse{
+		Disk: api.node.diskMonitor.getStatus(),
+	})
+}
+
+// StorageStatsResponse reports the size of storage for capacity planning
+type StorageStatsResponse struct {
+	BlockHeight uint64           `json:"blockHeight"`
+	Merkle      merkle.TreeStats `json:"merkle"`
+}
+
+func (api *nodeAPI) getStorageStats(c *gin.Context) {
+	c.JSON(http.StatusOK, &StorageStatsResponse{
+		BlockHeight: api.node.storage.GetBlockHeight(),
+		Merkle:      api.node.storage.GetMerkleStats(),
+	})
+}
+
+func (api *nodeAPI) getStorageInfo(c *gin.Context) {
+	c.JSON(http.StatusOK, api.node.storage.Info())
+}
+
+func (api *nodeAPI) getStorageMetrics(c *gin.Context) {
+	c.JSON(http.StatusOK, api.node.storage.Metrics())
+}
+
+func (api *nodeAPI) getPrometheusMetrics(c *gin.Context) {
+	c.Header("Content-Type", prometheusContentType)
+	c.Status(http.StatusOK)
+	if err := writeStorageMetrics(c.Writer, api.node.storage.Metrics()); err != nil {
+		logger.I().Warnw("write prometheus metrics failed", "error", err)
+		return
+	}
+	if err := writeDiskMetrics(c.Writer, api.node.diskMonitor.getStatus()); err != nil {
+		logger.I().Warnw("write prometheus metrics failed", "error", err)
+	}
+}
+
+func (api *nodeAPI) getPeerScores(c *gin.Context) {
+	c.JSON(http.StatusOK, api.node.host.PeerStore().Scores())
+}
+
 func (api *nodeAPI) getConsensusStatus(c *gin.Context) {
 	c.JSON(http.StatusOK, api.node.consensus.GetStatus())
 }
 
+// PromoteRequest carries the leadership lease token to promote standby node
+type PromoteRequest struct {
+	Token string `json:"token"`
+}
+
+func (api *nodeAPI) getLeaderSchedule(c *gin.Context) {
+	vs := api.node.vldStore
+	validators := make([][]byte, vs.ValidatorCount())
+	for i := range validators {
+		validators[i] = vs.GetValidator(i).Bytes()
+	}
+	c.JSON(http.StatusOK, &LeaderScheduleResponse{
+		Validators: validators,
+		Leaders:    api.node.consensus.GetLeaderHistory(),
+	})
+}
+
+func (api *nodeAPI) promoteStandby(c *gin.Context) {
+	var req PromoteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.String(http.StatusBadRequest, "cannot parse request")
+		return
+	}
+	err := api.node.consensus.Promote(req.Token)
+	switch {
+	case err == nil:
+		c.String(http.StatusOK, "promoted")
+	case errors.Is(err, consensus.ErrInvalidLeaseToken):
+		c.String(http.StatusForbidden, err.Error())
+	case errors.Is(err, consensus.ErrPrimaryNotSilent):
+		c.String(http.StatusConflict, err.Error())
+	default:
+		c.String(http.StatusBadRequest, err.Error())
+	}
+}
+
 func (api *nodeAPI) getTxPoolStatus(c *gin.Context) {
 	c.JSON(http.StatusOK, api.node.txpool.GetStatus())
 }
@@ -66,8 +282,20 @@ func (api *nodeAPI) submitTX(c *gin.Context) {
 		c.String(http.StatusBadRequest, "cannot parse tx")
 		return
 	}
-	if err := api.node.txpool.SubmitTx(tx); err != nil {
+	if err := tx.ValidateBasic(api.txLimits); err != nil {
+		c.JSON(http.StatusBadRequest, &ErrorResponse{txRejectReason(err), err.Error()})
+		return
+	}
+	if err := api.svc.SubmitTx(tx); err != nil {
 		logger.I().Warnf("submit tx failed %+v", err)
+		if reason := txRejectReason(err); reason != "" {
+			c.JSON(http.StatusBadRequest, &ErrorResponse{reason, err.Error()})
+			return
+		}
+		if errors.Is(err, txpool.ErrLowDiskSpace) {
+			c.String(http.StatusInsufficientStorage, err.Error())
+			return
+		}
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -80,7 +308,7 @@ func (api *nodeAPI) queryState(c *gin.Context) {
 		c.String(http.StatusBadRequest, "cannot parse request")
 		return
 	}
-	result, err := api.node.execution.Query(query)
+	result, err := api.svc.QueryChaincode(query)
 	if err != nil {
 		c.String(http.StatusInternalServerError, err.Error())
 		return
@@ -88,6 +316,125 @@ func (api *nodeAPI) queryState(c *gin.Context) {
 	c.JSON(http.StatusOK, result)
 }
 
+// StateKeyProofRequest carries the state key to prove in the namespace of code address,
+// empty code address for system keys
+type StateKeyProofRequest struct {
+	CodeAddr []byte `json:"codeAddr"`
+	Key      []byte `json:"key"`
+}
+
+func (api *nodeAPI) getStateProof(c *gin.Context) {
+	var req StateKeyProofRequest
+	if err := c.ShouldBindJSON(&req); err != nil || len(req.Key) == 0 {
+		c.String(http.StatusBadRequest, "cannot parse request")
+		return
+	}
+	proof, err := api.node.storage.GetStateKeyProof(core.StateKey(req.CodeAddr, req.Key))
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if len(proof.Value) > MaxStateProofValueBytes {
+		c.String(http.StatusRequestEntityTooLarge,
+			"state value %d bytes, exceed %d bytes per proof", len(proof.Value), MaxStateProofValueBytes)
+		return
+	}
+	c.JSON(http.StatusOK, proof)
+}
+
+// StatesByPrefixRequest carries the key prefix in the namespace of code address,
+// start is the next key of the previous page
+type StatesByPrefixRequest struct {
+	CodeAddr []byte `json:"codeAddr"`
+	Prefix   []byte `json:"prefix"`
+	Start    []byte `json:"start"`
+	Limit    int    `json:"limit"`
+}
+
+// StatesByPrefixResponse is a page of states, Next is nil after the last page
+type StatesByPrefixResponse struct {
+	States []storage.KeyValue `json:"states"`
+	Next   []byte             `json:"next"`
+}
+
+func (api *nodeAPI) getStatesByPrefix(c *gin.Context) {
+	var req StatesByPrefixRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.String(http.StatusBadRequest, "cannot parse request")
+		return
+	}
+	if req.Limit == 0 {
+		req.Limit = DefaultStatesByPrefixLimit
+	}
+	if req.Limit < 0 || req.Limit > MaxStatesByPrefixLimit {
+		c.String(http.StatusBadRequest, "limit must be 1 to %d", MaxStatesByPrefixLimit)
+		return
+	}
+	if req.Start != nil && !bytes.HasPrefix(req.Start, req.Prefix) {
+		c.String(http.StatusBadRequest, "start must have the prefix")
+		return
+	}
+	kvs, next, err := api.node.storage.GetCodeStatesByPrefix(req.CodeAddr, req.Prefix, req.Start, req.Limit)
+	if err != nil {
+		c.String(storageErrorStatus(err), err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, &StatesByPrefixResponse{States: kvs, Next: next})
+}
+
+// StateProofRequest carries the state keys to prove in the namespace of code address
+type StateProofRequest struct {
+	CodeAddr []byte   `json:"codeAddr"`
+	Keys     [][]byte `json:"keys"`
+}
+
+func (api *nodeAPI) getStateProofs(c *gin.Context) {
+	var req StateProofRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.String(http.StatusBadRequest, "cannot parse request")
+		return
+	}
+	if len(req.Keys) == 0 {
+		c.String(http.StatusBadRequest, "no keys to prove")
+		return
+	}
+	if len(req.Keys) > MaxStateProofKeys {
+		c.String(http.StatusBadRequest,
+			"too many keys %d, at most %d keys per proof", len(req.Keys), MaxStateProofKeys)
+		return
+	}
+	keys := make([][]byte, len(req.Keys))
+	for i, key := range req.Keys {
+		keys[i] = core.StateKey(req.CodeAddr, key)
+	}
+	proof, err := api.node.storage.GetStateProof(keys)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	size := 0
+	for _, v := range proof.Values {
+		size += len(v)
+	}
+	if size > MaxStateProofValueBytes {
+		c.String(http.StatusRequestEntityTooLarge,
+			"state values %d bytes, exceed %d bytes per proof", size, MaxStateProofValueBytes)
+		return
+	}
+	c.JSON(http.StatusOK, proof)
+}
+
+// storageErrorStatus responds gone for the data of pruned blocks
+func storageErrorStatus(err error) int {
+	if errors.Is(err, storage.ErrPruned) {
+		return http.StatusGone
+	}
+	if errors.Is(err, storage.ErrSenderIndexDisabled) || errors.Is(err, storage.ErrNoTxRoot) {
+		return http.StatusNotImplemented
+	}
+	return http.StatusInternalServerError
+}
+
 func (api *nodeAPI) getTxStatus(c *gin.Context) {
 	hash, err := api.getHash(c)
 	if err != nil {
@@ -98,6 +445,26 @@ func (api *nodeAPI) getTxStatus(c *gin.Context) {
 	c.JSON(http.StatusOK, status)
 }
 
+func (api *nodeAPI) getTx(c *gin.Context) {
+	hash, err := api.getHash(c)
+	if err != nil {
+		c.String(http.StatusBadRequest, "cannot parse hash")
+		return
+	}
+	tx := api.node.txpool.GetTx(hash)
+	if tx == nil {
+		tx, err = api.node.storage.GetTx(hash)
+		if err != nil {
+			c.String(storageErrorStatus(err), err.Error())
+			return
+		}
+	}
+	c.JSON(http.StatusOK, &TxResponse{
+		Transaction: tx,
+		Sender:      tx.Sender().Address(),
+	})
+}
+
 func (api *nodeAPI) getTxCommit(c *gin.Context) {
 	hash, err := api.getHash(c)
 	if err != nil {
@@ -106,12 +473,90 @@ func (api *nodeAPI) getTxCommit(c *gin.Context) {
 	}
 	txc, err := api.node.storage.GetTxCommit(hash)
 	if err != nil {
-		c.String(http.StatusInternalServerError, err.Error())
+		c.String(storageErrorStatus(err), err.Error())
 		return
 	}
 	c.JSON(http.StatusOK, txc)
 }
 
+func (api *nodeAPI) getTxProof(c *gin.Context) {
+	hash, err := api.getHash(c)
+	if err != nil {
+		c.String(http.StatusBadRequest, "cannot parse hash")
+		return
+	}
+	proof, err := api.node.storage.GetTxProof(hash)
+	if err != nil {
+		c.String(storageErrorStatus(err), err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, proof)
+}
+
+// TxsBySenderResponse is a page of txs by sender,
+// LastHeight is the block height of the last tx to request the next page
+type TxsBySenderResponse struct {
+	Transactions []*core.Transaction `json:"transactions"`
+	LastHeight   uint64              `json:"lastHeight"`
+}
+
+// parseSender parses bech32 address of the sender, or falls back to its hex encoded public key
+func parseSender(s string) ([]byte, error) {
+	addr, err := core.ParseAddress(s)
+	if err == nil {
+		return addr.Bytes(), nil
+	}
+	b, hexErr := hex.DecodeString(s)
+	if hexErr != nil {
+		return nil, err
+	}
+	pubKey, err := core.NewPublicKey(b)
+	if err != nil {
+		return nil, err
+	}
+	return pubKey.Address().Bytes(), nil
+}
+
+// getTxsBySender responds the txs of sender in the blocks above query param after,
+// or the latest txs first if query param latest is true
+func (api *nodeAPI) getTxsBySender(c *gin.Context) {
+	sender, err := parseSender(c.Param("sender"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "cannot parse sender, %v", err)
+		return
+	}
+	after, err := strconv.ParseUint(c.DefaultQuery("after", "0"), 10, 64)
+	if err != nil {
+		c.String(http.StatusBadRequest, "cannot parse after")
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(DefaultTxsBySenderLimit)))
+	if err != nil || limit < 0 || limit > MaxTxsBySenderLimit {
+		c.String(http.StatusBadRequest, "limit must be 0 to %d", MaxTxsBySenderLimit)
+		return
+	}
+	var txs []*core.Transaction
+	if c.Query("latest") == "true" {
+		txs, err = api.node.storage.GetLatestTxsBySender(sender, limit)
+	} else {
+		txs, err = api.node.storage.GetTxsBySender(sender, after, limit)
+	}
+	if err != nil {
+		c.String(storageErrorStatus(err), err.Error())
+		return
+	}
+	resp := &TxsBySenderResponse{Transactions: txs, LastHeight: after}
+	if len(txs) > 0 {
+		txc, err := api.node.storage.GetTxCommit(txs[len(txs)-1].Hash())
+		if err != nil {
+			c.String(storageErrorStatus(err), err.Error())
+			return
+		}
+		resp.LastHeight = txc.BlockHeight()
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
 func (api *nodeAPI) getBlock(c *gin.Context) {
 	hash, err := api.getHash(c)
 	if err != nil {
@@ -120,10 +565,35 @@ func (api *nodeAPI) getBlock(c *gin.Context) {
 	}
 	blk, err := api.node.GetBlock(hash)
 	if err != nil {
-		c.String(http.StatusInternalServerError, err.Error())
+		c.String(storageErrorStatus(err), err.Error())
 		return
 	}
-	c.JSON(http.StatusOK, blk)
+	resp := newBlockResponse(blk)
+	if c.Query("txs") == "true" {
+		if resp.Transactions, err = api.node.storage.GetBlockTxs(hash); err != nil {
+			c.String(storageErrorStatus(err), err.Error())
+			return
+		}
+		if resp.TxCommits, err = api.node.storage.GetTxCommitsByBlock(hash); err != nil {
+			c.String(storageErrorStatus(err), err.Error())
+			return
+		}
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func (api *nodeAPI) getBlockCommit(c *gin.Context) {
+	hash, err := api.getHash(c)
+	if err != nil {
+		c.String(http.StatusBadRequest, "cannot parse hash")
+		return
+	}
+	bcm, err := api.node.storage.GetBlockCommit(hash)
+	if err != nil {
+		c.String(storageErrorStatus(err), err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, bcm)
 }
 
 func (api *nodeAPI) getHash(c *gin.Context) ([]byte, error) {
@@ -138,12 +608,19 @@ func (api *nodeAPI) getBlockByHeight(c *gin.Context) {
 		c.String(http.StatusBadRequest, "cannot parse height")
 		return
 	}
-	blk, err := api.node.storage.GetBlockByHeight(height)
+	blk, err := api.svc.GetBlockByHeight(height)
 	if err != nil {
-		c.String(http.StatusInternalServerError, err.Error())
+		c.String(storageErrorStatus(err), err.Error())
 		return
 	}
-	c.JSON(http.StatusOK, blk)
+	c.JSON(http.StatusOK, newBlockResponse(blk))
+}
+
+func newBlockResponse(blk *core.Block) *BlockResponse {
+	return &BlockResponse{
+		Block:    blk,
+		Proposer: blk.Proposer().Address(),
+	}
 }
 
 func (api *nodeAPI) uploadBinChainCode(c *gin.Context) {
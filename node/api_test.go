@@ -0,0 +1,259 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package node
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aungmawjj/juria-blockchain/core"
+	"github.com/aungmawjj/juria-blockchain/emitter"
+	"github.com/aungmawjj/juria-blockchain/execution"
+	"github.com/aungmawjj/juria-blockchain/storage"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// testService commits each submitted tx in a new block
+type testService struct {
+	priv    *core.PrivateKey
+	height  uint64
+	commits *emitter.Emitter
+}
+
+var _ service = (*testService)(nil)
+
+func (svc *testService) GetState(codeAddr, key []byte) []byte {
+	return append([]byte("value of "), core.StateKey(codeAddr, key)...)
+}
+
+func (svc *testService) QueryChaincode(query *execution.QueryData) ([]byte, error) {
+	return query.Input, nil
+}
+
+func (svc *testService) GetBlockByHeight(height uint64) (*core.Block, error) {
+	return svc.newBlock(height).Sign(svc.priv), nil
+}
+
+func (svc *testService) newBlock(height uint64) *core.Block {
+	parent := core.NewBlock().SetHeight(height - 1).Sign(svc.priv)
+	return core.NewBlock().
+		SetHeight(height).
+		SetParentHash(parent.Hash()).
+		SetQuorumCert(core.NewQuorumCert().Build([]*core.Vote{parent.ProposerVote()}))
+}
+
+func (svc *testService) SubmitTx(tx *core.Transaction) error {
+	if err := tx.Validate(); err != nil {
+		return err
+	}
+	svc.height++
+	blk := svc.newBlock(svc.height).
+		SetTransactions([][]byte{tx.Hash()}).
+		Sign(svc.priv)
+	go svc.commits.Emit(&storage.CommitData{
+		Block:        blk,
+		Transactions: []*core.Transaction{tx},
+		TxCommits: []*core.TxCommit{
+			core.NewTxCommit().SetHash(tx.Hash()).
+				SetBlockHash(blk.Hash()).SetBlockHeight(blk.Height()),
+		},
+	})
+	return nil
+}
+
+func (svc *testService) SubscribeCommits(buffer int) *emitter.Subscription {
+	return svc.commits.Subscribe(buffer)
+}
+
+func TestNodeAPI_SubmitTxLimits(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	api := &nodeAPI{
+		svc: &testService{
+			priv:    core.GenerateKey(nil),
+			commits: emitter.New(),
+		},
+		txLimits: core.TxLimits{MaxInputSize: 4},
+	}
+	priv := core.GenerateKey(nil)
+
+	tests := []struct {
+		name   string
+		tx     *core.Transaction
+		code   int
+		reason string
+	}{
+		{"valid", core.NewTransaction().SetInput([]byte{1}).Sign(priv), http.StatusOK, ""},
+		{"input too large", core.NewTransaction().SetInput(make([]byte, 5)).Sign(priv),
+			http.StatusBadRequest, TxRejectInputTooLarge},
+		{"invalid code addr", core.NewTransaction().SetCodeAddr([]byte{1}).Sign(priv),
+			http.StatusBadRequest, TxRejectCodeAddr},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+			b, err := json.Marshal(tt.tx)
+			assert.NoError(err)
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodPost, "/transactions", bytes.NewReader(b))
+			c.Request.Header.Set("Content-Type", "application/json")
+			api.submitTX(c)
+
+			assert.Equal(tt.code, w.Code)
+			if tt.reason == "" {
+				return
+			}
+			res := new(ErrorResponse)
+			assert.NoError(json.Unmarshal(w.Body.Bytes(), res))
+			assert.Equal(tt.reason, res.Reason)
+			assert.NotEmpty(res.Error)
+		})
+	}
+}
+
+func TestNodeAPI_StatesByPrefix(t *testing.T) {
+	assert := assert.New(t)
+	gin.SetMode(gin.TestMode)
+
+	config := storage.DefaultConfig
+	config.InMemory = true
+	strg, err := storage.Open("", config)
+	assert.NoError(err)
+	defer strg.Close()
+	codeAddr := []byte{1}
+	blk := core.NewBlock().SetHeight(0).Sign(core.GenerateKey(nil))
+	assert.NoError(strg.Commit(&storage.CommitData{
+		Block: blk,
+		QC:    core.NewQuorumCert(),
+		BlockCommit: core.NewBlockCommit().SetHash(blk.Hash()).SetStateChanges([]*core.StateChange{
+			core.NewStateChange().SetCodeAddr(codeAddr).SetKey([]byte("a/1")).SetValue([]byte{1}),
+			core.NewStateChange().SetCodeAddr(codeAddr).SetKey([]byte("a/2")).SetValue([]byte{2}),
+			core.NewStateChange().SetCodeAddr(codeAddr).SetKey([]byte("a/3")).SetValue([]byte{3}),
+		}),
+	}))
+	api := &nodeAPI{node: &Node{storage: strg}}
+
+	request := func(req *StatesByPrefixRequest) (int, *StatesByPrefixResponse) {
+		b, err := json.Marshal(req)
+		assert.NoError(err)
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/querystate/prefix", bytes.NewReader(b))
+		c.Request.Header.Set("Content-Type", "application/json")
+		api.getStatesByPrefix(c)
+		resp := new(StatesByPrefixResponse)
+		if w.Code == http.StatusOK {
+			assert.NoError(json.Unmarshal(w.Body.Bytes(), resp))
+		}
+		return w.Code, resp
+	}
+
+	keys := make([][]byte, 0)
+	req := &StatesByPrefixRequest{CodeAddr: codeAddr, Prefix: []byte("a/"), Limit: 2}
+	for page := 0; ; page++ {
+		code, resp := request(req)
+		assert.Equal(http.StatusOK, code)
+		for _, kv := range resp.States {
+			keys = append(keys, kv.Key)
+		}
+		if resp.Next == nil || page > 2 {
+			break
+		}
+		req.Start = resp.Next
+	}
+	assert.Equal([][]byte{[]byte("a/1"), []byte("a/2"), []byte("a/3")}, keys)
+
+	code, resp := request(&StatesByPrefixRequest{CodeAddr: codeAddr, Prefix: []byte("b/")})
+	assert.Equal(http.StatusOK, code)
+	assert.Empty(resp.States)
+	assert.Nil(resp.Next)
+
+	code, _ = request(&StatesByPrefixRequest{Prefix: []byte("a/"), Limit: MaxStatesByPrefixLimit + 1})
+	assert.Equal(http.StatusBadRequest, code)
+	code, _ = request(&StatesByPrefixRequest{Prefix: []byte("a/"), Start: []byte("b/1")})
+	assert.Equal(http.StatusBadRequest, code)
+}
+
+func TestNodeAPI_TxsBySender(t *testing.T) {
+	assert := assert.New(t)
+	gin.SetMode(gin.TestMode)
+
+	config := storage.DefaultConfig
+	config.InMemory = true
+	strg, err := storage.Open("", config)
+	assert.NoError(err)
+	defer strg.Close()
+	priv := core.GenerateKey(nil)
+	tx := core.NewTransaction().SetNonce(1).Sign(priv)
+	blk := core.NewBlock().SetHeight(0).SetTransactions([][]byte{tx.Hash()}).Sign(priv)
+	assert.NoError(strg.Commit(&storage.CommitData{
+		Block:        blk,
+		QC:           core.NewQuorumCert(),
+		Transactions: []*core.Transaction{tx},
+		BlockCommit:  core.NewBlockCommit().SetHash(blk.Hash()),
+		TxCommits: []*core.TxCommit{
+			core.NewTxCommit().SetHash(tx.Hash()).SetBlockHash(blk.Hash()),
+		},
+	}))
+	api := &nodeAPI{node: &Node{storage: strg}}
+
+	request := func(sender string) (int, []*core.Transaction) {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/senders/"+sender+"/transactions?latest=true", nil)
+		c.Params = gin.Params{{Key: "sender", Value: sender}}
+		api.getTxsBySender(c)
+		resp := new(TxsBySenderResponse)
+		if w.Code == http.StatusOK {
+			assert.NoError(json.Unmarshal(w.Body.Bytes(), resp))
+		}
+		return w.Code, resp.Transactions
+	}
+
+	for _, sender := range []string{
+		priv.PublicKey().Address().String(),
+		hex.EncodeToString(priv.PublicKey().Bytes()),
+	} {
+		code, txs := request(sender)
+		if assert.Equal(http.StatusOK, code, sender) && assert.Len(txs, 1, sender) {
+			assert.Equal(tx.Hash(), txs[0].Hash())
+		}
+	}
+
+	code, _ := request("invalid")
+	assert.Equal(http.StatusBadRequest, code)
+}
+
+func TestNodeAPI_BlockByHeight(t *testing.T) {
+	assert := assert.New(t)
+	gin.SetMode(gin.TestMode)
+
+	svc := &testService{priv: core.GenerateKey(nil), commits: emitter.New()}
+	api := &nodeAPI{svc: svc}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/blocksbyh/5", nil)
+	c.Params = gin.Params{{Key: "height", Value: "5"}}
+	api.getBlockByHeight(c)
+	assert.Equal(http.StatusOK, w.Code)
+
+	// existing clients decode the response as block
+	blk := core.NewBlock()
+	assert.NoError(json.Unmarshal(w.Body.Bytes(), blk))
+	assert.EqualValues(5, blk.Height())
+	assert.Equal(svc.priv.PublicKey(), blk.Proposer())
+
+	resp := struct {
+		ProposerAddress core.Address `json:"proposerAddress"`
+	}{}
+	assert.NoError(json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(svc.priv.PublicKey().Address(), resp.ProposerAddress)
+}
@@ -0,0 +1,52 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package node
+
+import (
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+
+	"github.com/aungmawjj/juria-blockchain/logger"
+	"github.com/aungmawjj/juria-blockchain/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// BackupVersionTrailer carries the version to pass as since for the next incremental backup,
+// it's known only after the backup is written
+const BackupVersionTrailer = "X-Backup-Version"
+
+// backupStorage streams a hot backup of the storage, keys changed since the ?since= version
+func (api *nodeAPI) backupStorage(c *gin.Context) {
+	var since uint64
+	if s := c.Query("since"); s != "" {
+		var err error
+		if since, err = strconv.ParseUint(s, 10, 64); err != nil {
+			c.String(http.StatusBadRequest, "invalid since")
+			return
+		}
+	}
+	c.Header("Content-Type", "application/octet-stream")
+	c.Header("Trailer", BackupVersionTrailer)
+	c.Status(http.StatusOK)
+	version, err := api.node.storage.Backup(c.Writer, since)
+	if err != nil {
+		// the response is already started, the missing trailer tells the client
+		logger.I().Warnw("backup storage failed", "error", err)
+		return
+	}
+	c.Writer.Header().Set(BackupVersionTrailer, strconv.FormatUint(version, 10))
+}
+
+// RestoreBackup restores a storage backup into the datadir of a stopped node.
+// A full backup needs a fresh datadir, an incremental one is restored on top of the previous backup.
+func RestoreBackup(config Config, r io.Reader) error {
+	strg, err := storage.Open(path.Join(config.Datadir, "db"), config.StorageConfig)
+	if err != nil {
+		return err
+	}
+	defer strg.Close()
+	return strg.Restore(r)
+}
@@ -0,0 +1,94 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package node
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"testing"
+
+	"github.com/aungmawjj/juria-blockchain/core"
+	"github.com/aungmawjj/juria-blockchain/storage"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNodeAPI_BackupRestore(t *testing.T) {
+	assert := assert.New(t)
+	gin.SetMode(gin.TestMode)
+
+	db, err := storage.NewDB(t.TempDir())
+	assert.NoError(err)
+	defer db.Close()
+	strg := storage.New(db, storage.DefaultConfig)
+	defer strg.Close()
+	blk := core.NewBlock().SetHeight(0).Sign(core.GenerateKey(nil))
+	assert.NoError(strg.Commit(&storage.CommitData{
+		Block: blk,
+		QC:    core.NewQuorumCert(),
+		BlockCommit: core.NewBlockCommit().SetHash(blk.Hash()).SetStateChanges([]*core.StateChange{
+			core.NewStateChange().SetKey([]byte("key")).SetValue([]byte("value")),
+		}),
+	}))
+	api := &nodeAPI{node: &Node{storage: strg}}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/admin/backup?since=x", nil)
+	api.backupStorage(c)
+	assert.Equal(http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/admin/backup", nil)
+	api.backupStorage(c)
+	assert.Equal(http.StatusOK, w.Code)
+	assert.NotEmpty(w.Header().Get(BackupVersionTrailer))
+
+	config := DefaultConfig
+	config.Datadir = t.TempDir()
+	assert.NoError(RestoreBackup(config, bytes.NewReader(w.Body.Bytes())))
+
+	db2, err := storage.NewDB(path.Join(config.Datadir, "db"))
+	assert.NoError(err)
+	defer db2.Close()
+	restored := storage.New(db2, storage.DefaultConfig)
+	restoredBlk, err := restored.GetLastBlock()
+	assert.NoError(err)
+	assert.Equal(blk.Hash(), restoredBlk.Hash())
+	assert.Equal(strg.GetMerkleRoot(), restored.GetMerkleRoot())
+	assert.Equal([]byte("value"), restored.VerifyState(nil, []byte("key")))
+}
+
+func TestNodeAPI_BackupRequiresAdminToken(t *testing.T) {
+	assert := assert.New(t)
+	gin.SetMode(gin.TestMode)
+
+	db, err := storage.NewDB(t.TempDir())
+	assert.NoError(err)
+	defer db.Close()
+	strg := storage.New(db, storage.DefaultConfig)
+	defer strg.Close()
+
+	backup := func(adminToken, auth string) int {
+		api := &nodeAPI{node: &Node{storage: strg}, adminToken: adminToken}
+		r := gin.New()
+		r.Group("/admin", api.authorizeAdmin).GET("/backup", api.backupStorage)
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/admin/backup", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(http.StatusForbidden, backup("", ""), "admin api disabled")
+	assert.Equal(http.StatusForbidden, backup("", "Bearer "), "admin api disabled")
+	assert.Equal(http.StatusUnauthorized, backup("secret", ""), "missing token")
+	assert.Equal(http.StatusUnauthorized, backup("secret", "Bearer wrong"), "wrong token")
+	assert.Equal(http.StatusOK, backup("secret", "Bearer secret"))
+}
@@ -4,8 +4,12 @@
 package node
 
 import (
+	"time"
+
 	"github.com/aungmawjj/juria-blockchain/consensus"
+	"github.com/aungmawjj/juria-blockchain/core"
 	"github.com/aungmawjj/juria-blockchain/execution"
+	"github.com/aungmawjj/juria-blockchain/p2p"
 	"github.com/aungmawjj/juria-blockchain/storage"
 )
 
@@ -15,14 +19,92 @@ type Config struct {
 	Port    int
 	APIPort int
 
+	// grpc server address, grpc is disabled if empty
+	GRPCAddr string
+
+	// if set, grpc clients must send "Bearer <token>" in authorization metadata
+	GRPCToken string
+
+	// admin api clients must send "Bearer <token>" in authorization header, admin api is disabled if empty
+	AdminToken string
+
+	// free space thresholds of data directory disk in bytes
+	DiskSoftLimit uint64
+	DiskHardLimit uint64
+
+	// interval to sample free disk space
+	DiskCheckInterval time.Duration
+
+	// maximum size in bytes of a decoded p2p message
+	MaxMsgSize int
+
+	// compress p2p messages with snappy on the connections with the peers supporting it
+	MsgCompression bool
+
+	// inbound messages of each peer, excess messages are dropped
+	PeerRateLimit p2p.RateLimit
+
+	// peers sending malformed or forged messages are banned when the penalty score reaches the ban score
+	PeerScore p2p.ScoreConfig
+
+	// hashes of gossiped messages to drop the duplicates received from multiple peers
+	SeenMsgCache p2p.SeenCacheConfig
+
+	// answer peer exchanges and add the nodes bootstrapping from this node as peers
+	PeerExchange bool
+
+	// multiaddrs with /p2p/<peer id> of the seed nodes to discover peers from, peer exchange is enabled if set.
+	// The peers file is optional with seeds.
+	Seeds []string
+
+	// limits of tx fields, txs exceeding them are rejected by api and txpool
+	TxLimits core.TxLimits
+
+	// maximum entries of verified vote and proposal signatures, cache is disabled if zero
+	SigCacheSize int
+
+	// blocks per epoch, the validator set in state is snapshotted once per epoch.
+	// validators are fixed to genesis if zero
+	EpochLength uint64
+
+	// hash function of block, tx and vote digests by name, must be the same for all nodes of the chain
+	HashFunc string
+
+	// domain tags in block, tx and vote digests, must be the same for all nodes of the chain
+	DomainSeparation core.DomainSeparation
+
 	StorageConfig   storage.Config
 	ExecutionConfig execution.Config
 	ConsensusConfig consensus.Config
 }
 
 var DefaultConfig = Config{
-	Port:            15150,
-	APIPort:         9040,
+	Port:    15150,
+	APIPort: 9040,
+
+	DiskSoftLimit:     1 << 30, // 1 GB
+	DiskHardLimit:     256 << 20,
+	DiskCheckInterval: 10 * time.Second,
+
+	MaxMsgSize: 32 << 20, // 32 MB
+
+	PeerScore: p2p.ScoreConfig{
+		BanScore:    100,
+		BanDuration: 5 * time.Minute,
+		DecayPerSec: 0.1,
+	},
+
+	SeenMsgCache: p2p.SeenCacheConfig{
+		Size: 10000,
+		TTL:  2 * time.Minute,
+	},
+
+	SigCacheSize: 10000,
+
+	HashFunc: "sha3-256",
+
+	TxLimits: core.DefaultTxLimits,
+
 	StorageConfig:   storage.DefaultConfig,
 	ExecutionConfig: execution.DefaultConfig,
 	ConsensusConfig: consensus.DefaultConfig,
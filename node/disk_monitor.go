@@ -0,0 +1,170 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package node
+
+import (
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/aungmawjj/juria-blockchain/logger"
+)
+
+type DiskLevel uint8
+
+const (
+	DiskOK DiskLevel = iota
+	// below soft limit, new txs are rejected and value log gc is triggered
+	DiskLow
+	// below hard limit, node proposes empty blocks but keeps voting
+	DiskCritical
+)
+
+func (level DiskLevel) String() string {
+	switch level {
+	case DiskLow:
+		return "low"
+	case DiskCritical:
+		return "critical"
+	default:
+		return "ok"
+	}
+}
+
+func (level DiskLevel) MarshalText() ([]byte, error) {
+	return []byte(level.String()), nil
+}
+
+// DiskStats is the space of the filesystem holding data directory in bytes
+type DiskStats struct {
+	Total uint64 `json:"total"`
+	Free  uint64 `json:"free"`
+}
+
+// DiskStatsProvider samples disk space for the directory
+type DiskStatsProvider func(dir string) (DiskStats, error)
+
+func statDisk(dir string) (DiskStats, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return DiskStats{}, err
+	}
+	return DiskStats{
+		Total: uint64(st.Blocks) * uint64(st.Bsize),
+		Free:  uint64(st.Bavail) * uint64(st.Bsize),
+	}, nil
+}
+
+type DiskStatus struct {
+	Level     DiskLevel `json:"level"`
+	Total     uint64    `json:"total"`
+	Free      uint64    `json:"free"`
+	SoftLimit uint64    `json:"softLimit"`
+	HardLimit uint64    `json:"hardLimit"`
+	Updated   int64     `json:"updated"`
+}
+
+type diskMonitor struct {
+	dir       string
+	softLimit uint64
+	hardLimit uint64
+	interval  time.Duration
+
+	statDisk DiskStatsProvider
+
+	// degradation hooks
+	setLowDiskSpace  func(val bool)
+	setEmptyProposal func(val bool)
+	runValueLogGC    func() error
+
+	status    DiskStatus
+	mtxStatus sync.RWMutex
+
+	startOnce sync.Once
+	stopOnce  sync.Once
+	stopCh    chan struct{} // made by the owner, closed once by stop
+}
+
+func (dm *diskMonitor) start() {
+	dm.startOnce.Do(func() {
+		dm.check()
+		go dm.run()
+		logger.I().Info("started disk monitor")
+	})
+}
+
+// stop can be called before start or more than once, the monitor can't be restarted
+func (dm *diskMonitor) stop() {
+	dm.stopOnce.Do(func() {
+		close(dm.stopCh)
+		logger.I().Info("stopped disk monitor")
+	})
+}
+
+func (dm *diskMonitor) run() {
+	ticker := time.NewTicker(dm.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-dm.stopCh:
+			return
+		case <-ticker.C:
+			dm.check()
+		}
+	}
+}
+
+func (dm *diskMonitor) check() {
+	stats, err := dm.statDisk(dm.dir)
+	if err != nil {
+		logger.I().Warnw("sample disk space failed", "error", err)
+		return
+	}
+	level := dm.levelOf(stats.Free)
+	prev := dm.setStatus(stats, level)
+	if level == prev {
+		return
+	}
+	logger.I().Warnw("disk level changed",
+		"from", prev, "to", level, "free", stats.Free)
+	dm.setLowDiskSpace(level >= DiskLow)
+	dm.setEmptyProposal(level >= DiskCritical)
+	if prev < DiskLow && level >= DiskLow {
+		if err := dm.runValueLogGC(); err != nil {
+			logger.I().Warnw("value log gc failed", "error", err)
+		}
+	}
+}
+
+func (dm *diskMonitor) levelOf(free uint64) DiskLevel {
+	if free < dm.hardLimit {
+		return DiskCritical
+	}
+	if free < dm.softLimit {
+		return DiskLow
+	}
+	return DiskOK
+}
+
+// setStatus returns the previous level
+func (dm *diskMonitor) setStatus(stats DiskStats, level DiskLevel) DiskLevel {
+	dm.mtxStatus.Lock()
+	defer dm.mtxStatus.Unlock()
+	prev := dm.status.Level
+	dm.status = DiskStatus{
+		Level:     level,
+		Total:     stats.Total,
+		Free:      stats.Free,
+		SoftLimit: dm.softLimit,
+		HardLimit: dm.hardLimit,
+		Updated:   time.Now().UnixNano(),
+	}
+	return prev
+}
+
+func (dm *diskMonitor) getStatus() DiskStatus {
+	dm.mtxStatus.RLock()
+	defer dm.mtxStatus.RUnlock()
+	return dm.status
+}
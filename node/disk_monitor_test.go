@@ -0,0 +1,134 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package node
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeDisk struct {
+	free uint64
+	err  error
+}
+
+func (fd *fakeDisk) stat(dir string) (DiskStats, error) {
+	return DiskStats{Total: 100, Free: fd.free}, fd.err
+}
+
+type diskHooks struct {
+	lowDiskSpace  bool
+	emptyProposal bool
+	gcCount       int
+}
+
+func setupTestDiskMonitor(disk *fakeDisk, hooks *diskHooks) *diskMonitor {
+	return &diskMonitor{
+		softLimit:        30,
+		hardLimit:        10,
+		statDisk:         disk.stat,
+		setLowDiskSpace:  func(val bool) { hooks.lowDiskSpace = val },
+		setEmptyProposal: func(val bool) { hooks.emptyProposal = val },
+		runValueLogGC: func() error {
+			hooks.gcCount++
+			return nil
+		},
+		stopCh: make(chan struct{}),
+	}
+}
+
+func TestDiskMonitor_check(t *testing.T) {
+	disk := &fakeDisk{free: 50}
+	hooks := new(diskHooks)
+	dm := setupTestDiskMonitor(disk, hooks)
+
+	tests := []struct {
+		name          string
+		free          uint64
+		level         DiskLevel
+		lowDiskSpace  bool
+		emptyProposal bool
+		gcCount       int
+	}{
+		{"enough space", 50, DiskOK, false, false, 0},
+		{"below soft limit", 20, DiskLow, true, false, 1},
+		{"still low", 25, DiskLow, true, false, 1},
+		{"below hard limit", 5, DiskCritical, true, true, 1},
+		{"freed to soft", 15, DiskLow, true, false, 1},
+		{"recovered", 40, DiskOK, false, false, 1},
+		{"low again", 29, DiskLow, true, false, 2},
+		{"recovered again", 40, DiskOK, false, false, 2},
+		{"critical from ok", 0, DiskCritical, true, true, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+			disk.free = tt.free
+			dm.check()
+
+			status := dm.getStatus()
+			assert.Equal(tt.level, status.Level)
+			assert.Equal(tt.free, status.Free)
+			assert.Equal(tt.lowDiskSpace, hooks.lowDiskSpace)
+			assert.Equal(tt.emptyProposal, hooks.emptyProposal)
+			assert.Equal(tt.gcCount, hooks.gcCount)
+		})
+	}
+}
+
+func TestDiskMonitor_checkError(t *testing.T) {
+	assert := assert.New(t)
+
+	disk := &fakeDisk{free: 5}
+	hooks := new(diskHooks)
+	dm := setupTestDiskMonitor(disk, hooks)
+
+	dm.check()
+	assert.Equal(DiskCritical, dm.getStatus().Level)
+
+	disk.free = 50
+	disk.err = errors.New("stat failed")
+	dm.check()
+	assert.Equal(DiskCritical, dm.getStatus().Level, "should keep last level")
+	assert.True(hooks.emptyProposal)
+}
+
+func TestDiskMonitor_startStop(t *testing.T) {
+	assert := assert.New(t)
+
+	disk := &fakeDisk{free: 50}
+	dm := setupTestDiskMonitor(disk, new(diskHooks))
+	dm.interval = time.Millisecond
+
+	dm.stop() // before start
+	dm.start()
+	dm.start()
+	assert.Equal(DiskOK, dm.getStatus().Level, "checked on start")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			dm.stop()
+		}()
+	}
+	wg.Wait()
+}
+
+func TestStatDisk(t *testing.T) {
+	assert := assert.New(t)
+
+	stats, err := statDisk(t.TempDir())
+	assert.NoError(err)
+	assert.NotZero(stats.Total)
+	assert.LessOrEqual(stats.Free, stats.Total)
+
+	_, err = statDisk("/not/existing/dir")
+	assert.Error(err)
+}
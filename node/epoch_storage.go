@@ -0,0 +1,38 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package node
+
+import (
+	"github.com/aungmawjj/juria-blockchain/consensus"
+	"github.com/aungmawjj/juria-blockchain/core"
+	"github.com/aungmawjj/juria-blockchain/storage"
+)
+
+// epochStorage feeds committed state changes to epoch validator store,
+// in the same order and goroutine as consensus commits blocks, one by one or in batches
+type epochStorage struct {
+	*storage.Storage
+	vldStore *core.EpochValidatorStore
+}
+
+var _ consensus.Storage = (*epochStorage)(nil)
+
+func (strg *epochStorage) Commit(data *storage.CommitData) error {
+	if err := strg.Storage.Commit(data); err != nil {
+		return err
+	}
+	return strg.vldStore.Commit(data.Block.Height(), data.BlockCommit.StateChanges())
+}
+
+func (strg *epochStorage) CommitBatch(data []*storage.CommitData) error {
+	if err := strg.Storage.CommitBatch(data); err != nil {
+		return err
+	}
+	for _, d := range data {
+		if err := strg.vldStore.Commit(d.Block.Height(), d.BlockCommit.StateChanges()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package node
+
+import (
+	"testing"
+
+	"github.com/aungmawjj/juria-blockchain/core"
+	"github.com/aungmawjj/juria-blockchain/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEpochStorage_CommitBatch(t *testing.T) {
+	assert := assert.New(t)
+
+	db, err := storage.NewDB(t.TempDir())
+	assert.NoError(err)
+	defer db.Close()
+	strg := storage.New(db, storage.DefaultConfig)
+	defer strg.Close()
+
+	privA := core.GenerateKey(nil)
+	privB := core.GenerateKey(nil)
+	vldStore, err := core.NewEpochValidatorStore(2, core.NewValidatorStore([]*core.PublicKey{privA.PublicKey()}))
+	assert.NoError(err)
+	assert.NoError(vldStore.SetSnapshotStore(strg).Restore(0, nil))
+	valueB, _ := (&core.ValidatorSet{Validators: [][]byte{privB.PublicKey().Bytes()}}).Marshal()
+
+	data := make([]*storage.CommitData, 4)
+	var parent *core.Block
+	for i := range data {
+		blk := core.NewBlock().SetHeight(uint64(i))
+		if parent != nil {
+			blk.SetParentHash(parent.Hash())
+		}
+		blk.Sign(privA)
+		var changes []*core.StateChange
+		if i == 0 {
+			changes = []*core.StateChange{core.NewStateChange().SetKey(core.ValidatorSetKey).SetValue(valueB)}
+		}
+		data[i] = &storage.CommitData{
+			Block:       blk,
+			QC:          core.NewQuorumCert(),
+			BlockCommit: core.NewBlockCommit().SetHash(blk.Hash()).SetStateChanges(changes),
+		}
+		parent = blk
+	}
+	epochStrg := &epochStorage{strg, vldStore}
+	assert.NoError(epochStrg.CommitBatch(data))
+
+	assert.True(vldStore.IsValidator(privB.PublicKey()), "next block is in epoch 2")
+	assert.True(vldStore.ForHeight(3).IsValidator(privA.PublicKey()))
+	values, err := strg.GetEpochValidatorSets()
+	assert.NoError(err)
+	assert.Equal(valueB, values[2])
+}
@@ -0,0 +1,33 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package node
+
+import (
+	"github.com/aungmawjj/juria-blockchain/execution"
+	"github.com/aungmawjj/juria-blockchain/execution/chaincode"
+	"github.com/aungmawjj/juria-blockchain/storage"
+)
+
+// execStorage is the state store of execution, chaincode queries scan states through it
+type execStorage struct {
+	*storage.Storage
+}
+
+var _ execution.StateStore = (*execStorage)(nil)
+var _ execution.HistoricalStateStore = (*execStorage)(nil)
+var _ execution.PrefixStateStore = (*execStorage)(nil)
+
+func (strg *execStorage) GetCodeStatesByPrefix(
+	codeAddr, prefix, start []byte, limit int,
+) ([]chaincode.KeyValue, []byte, error) {
+	kvs, next, err := strg.Storage.GetCodeStatesByPrefix(codeAddr, prefix, start, limit)
+	if err != nil {
+		return nil, nil, err
+	}
+	ret := make([]chaincode.KeyValue, len(kvs))
+	for i, kv := range kvs {
+		ret[i] = chaincode.KeyValue{Key: kv.Key, Value: kv.Value}
+	}
+	return ret, next, nil
+}
@@ -4,6 +4,7 @@
 package node
 
 import (
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -20,14 +21,21 @@ type Peer struct {
 	Addr   string
 }
 
+// Genesis lists the initial validators,
+// with the proofs of possession of bls validators in Proofs, see core.ValidatorSet
 type Genesis struct {
 	Validators [][]byte
+	Proofs     [][]byte `json:",omitempty"`
 }
 
 const (
 	NodekeyFile = "nodekey"
 	GenesisFile = "genesis.json"
-	PeersFile   = "peers.json"
+	// optional, derived from genesis.json and chain id if not exists
+	GenesisBlockFile = "genesis.bin"
+	PeersFile        = "peers.json"
+	// last block signed with the node key, see consensus.Config.WALFile
+	ConsensusWALFile = "consensus.wal"
 )
 
 func readNodeKey(datadir string) (*core.PrivateKey, error) {
@@ -53,6 +61,29 @@ func readGenesis(datadir string) (*Genesis, error) {
 	return genesis, nil
 }
 
+// readGenesisBlock returns nil genesis if the file doesn't exist
+func readGenesisBlock(datadir string) (*core.Genesis, error) {
+	b, err := ioutil.ReadFile(path.Join(datadir, GenesisBlockFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read %s, %w", GenesisBlockFile, err)
+	}
+	g, err := core.UnmarshalGenesis(b)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse %s, %w", GenesisBlockFile, err)
+	}
+	return g, nil
+}
+
+// ChainIDBytes encodes chain id for core.NewGenesis
+func ChainIDBytes(chainID int64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(chainID))
+	return b
+}
+
 func readPeers(datadir string) ([]*p2p.Peer, error) {
 	f, err := os.Open(path.Join(datadir, PeersFile))
 	if err != nil {
@@ -0,0 +1,198 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package node
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net"
+
+	"github.com/aungmawjj/juria-blockchain/client/client_pb"
+	"github.com/aungmawjj/juria-blockchain/core"
+	"github.com/aungmawjj/juria-blockchain/execution"
+	"github.com/aungmawjj/juria-blockchain/logger"
+	"github.com/aungmawjj/juria-blockchain/storage"
+	"github.com/aungmawjj/juria-blockchain/txpool"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// grpc metadata key carrying "Bearer <token>"
+const grpcAuthKey = "authorization"
+
+const defaultCommitBuffer = 100
+
+type grpcServer struct {
+	client_pb.UnimplementedNodeServer
+
+	svc   service
+	token string
+}
+
+var _ client_pb.NodeServer = (*grpcServer)(nil)
+
+func serveGRPC(node *Node) {
+	ln, err := net.Listen("tcp", node.config.GRPCAddr)
+	if err != nil {
+		logger.I().Fatalw("cannot listen grpc", "addr", node.config.GRPCAddr, "error", err)
+	}
+	srv := newGRPCServer(&nodeService{node}, node.config.GRPCToken)
+	go func() {
+		if err := srv.Serve(ln); err != nil {
+			logger.I().Fatalf("failed to serve grpc %+v", err)
+		}
+	}()
+}
+
+func newGRPCServer(svc service, token string) *grpc.Server {
+	gs := &grpcServer{svc: svc, token: token}
+	srv := grpc.NewServer(
+		grpc.UnaryInterceptor(gs.authUnary),
+		grpc.StreamInterceptor(gs.authStream),
+	)
+	client_pb.RegisterNodeServer(srv, gs)
+	return srv
+}
+
+func (gs *grpcServer) authUnary(
+	ctx context.Context, req interface{},
+	info *grpc.UnaryServerInfo, handler grpc.UnaryHandler,
+) (interface{}, error) {
+	if err := gs.authorize(ctx); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (gs *grpcServer) authStream(
+	srv interface{}, ss grpc.ServerStream,
+	info *grpc.StreamServerInfo, handler grpc.StreamHandler,
+) error {
+	if err := gs.authorize(ss.Context()); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
+
+func (gs *grpcServer) authorize(ctx context.Context) error {
+	if gs.token == "" {
+		return nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	expected := []byte("Bearer " + gs.token)
+	for _, val := range md.Get(grpcAuthKey) {
+		if subtle.ConstantTimeCompare([]byte(val), expected) == 1 {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "invalid api token")
+}
+
+func (gs *grpcServer) GetState(
+	ctx context.Context, req *client_pb.GetStateRequest,
+) (*client_pb.GetStateResponse, error) {
+	return &client_pb.GetStateResponse{Value: gs.svc.GetState(req.CodeAddr, req.Key)}, nil
+}
+
+func (gs *grpcServer) QueryChaincode(
+	ctx context.Context, req *client_pb.QueryRequest,
+) (*client_pb.QueryResponse, error) {
+	val, err := gs.svc.QueryChaincode(&execution.QueryData{
+		CodeAddr: req.CodeAddr,
+		Input:    req.Input,
+	})
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &client_pb.QueryResponse{Value: val}, nil
+}
+
+func (gs *grpcServer) GetBlockByHeight(
+	ctx context.Context, req *client_pb.GetBlockByHeightRequest,
+) (*client_pb.BlockResponse, error) {
+	blk, err := gs.svc.GetBlockByHeight(req.Height)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	b, err := blk.Marshal()
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &client_pb.BlockResponse{Block: b}, nil
+}
+
+func (gs *grpcServer) SubmitTransaction(
+	ctx context.Context, req *client_pb.SubmitTxRequest,
+) (*client_pb.SubmitTxResponse, error) {
+	tx := core.NewTransaction()
+	if err := tx.Unmarshal(req.Transaction); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "cannot parse tx")
+	}
+	if err := gs.svc.SubmitTx(tx); err != nil {
+		logger.I().Warnf("submit tx failed %+v", err)
+		if errors.Is(err, txpool.ErrLowDiskSpace) {
+			return nil, status.Error(codes.ResourceExhausted, err.Error())
+		}
+		if txRejectReason(err) != "" {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &client_pb.SubmitTxResponse{Hash: tx.Hash()}, nil
+}
+
+func (gs *grpcServer) SubscribeCommits(
+	req *client_pb.SubscribeCommitsRequest, stream client_pb.Node_SubscribeCommitsServer,
+) error {
+	buffer := int(req.Buffer)
+	if buffer == 0 {
+		buffer = defaultCommitBuffer
+	}
+	sub := gs.svc.SubscribeCommits(buffer)
+	defer sub.Unsubscribe()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+
+		case e, ok := <-sub.Events():
+			if !ok {
+				return nil
+			}
+			data, ok := e.(*storage.CommitData)
+			if !ok {
+				logger.I().Warnw("unexpected commit event", "event", e)
+				continue
+			}
+			event, err := newCommitEvent(data)
+			if err != nil {
+				return status.Error(codes.Internal, err.Error())
+			}
+			if err := stream.Send(event); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func newCommitEvent(data *storage.CommitData) (*client_pb.CommitEvent, error) {
+	b, err := data.Block.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	event := &client_pb.CommitEvent{
+		Block:     b,
+		TxCommits: make([][]byte, len(data.TxCommits)),
+	}
+	for i, txc := range data.TxCommits {
+		if event.TxCommits[i], err = txc.Marshal(); err != nil {
+			return nil, err
+		}
+	}
+	return event, nil
+}
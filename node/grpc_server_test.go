@@ -0,0 +1,236 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package node
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/aungmawjj/juria-blockchain/chaincodes/juriacoin"
+	"github.com/aungmawjj/juria-blockchain/client/client_pb"
+	"github.com/aungmawjj/juria-blockchain/core"
+	"github.com/aungmawjj/juria-blockchain/emitter"
+	"github.com/aungmawjj/juria-blockchain/execution"
+	"github.com/aungmawjj/juria-blockchain/storage"
+	"github.com/aungmawjj/juria-blockchain/txpool"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// testMsgService has no peers, txs broadcast by tx pool are dropped
+type testMsgService struct {
+	txLists *emitter.Emitter
+}
+
+func (svc *testMsgService) SubscribeTxList(buffer int) *emitter.Subscription {
+	return svc.txLists.Subscribe(buffer)
+}
+
+func (svc *testMsgService) BroadcastTxList(txList *core.TxList) error {
+	return nil
+}
+
+func (svc *testMsgService) RequestTxList(pubKey *core.PublicKey, hashes [][]byte) (*core.TxList, error) {
+	return nil, errors.New("no peers")
+}
+
+// testChain is a node with storage, execution and tx pool,
+// whose blocks are proposed and commited by commitBlock instead of consensus
+type testChain struct {
+	node *Node
+	priv *core.PrivateKey
+	last *core.Block
+}
+
+func newTestChain(t *testing.T) *testChain {
+	config := storage.DefaultConfig
+	config.InMemory = true
+	strg, err := storage.Open("", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { strg.Close() })
+
+	priv := core.GenerateKey(nil)
+	genesis := core.NewBlock().SetHeight(0).Sign(priv)
+	err = strg.Commit(&storage.CommitData{
+		Block:       genesis,
+		QC:          core.NewQuorumCert().Build([]*core.Vote{genesis.ProposerVote()}),
+		BlockCommit: core.NewBlockCommit().SetHash(genesis.Hash()),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := &Node{storage: strg}
+	node.execution = execution.New(&execStorage{strg}, execution.DefaultConfig)
+	node.txpool = txpool.New(strg, node.execution, &testMsgService{emitter.New()})
+	return &testChain{node: node, priv: priv, last: genesis}
+}
+
+// commitBlock executes the queued txs in a new block and commits it as consensus does
+func (tc *testChain) commitBlock(t *testing.T) *core.Block {
+	blk := core.NewBlock().
+		SetHeight(tc.last.Height() + 1).
+		SetParentHash(tc.last.Hash()).
+		SetQuorumCert(core.NewQuorumCert().Build([]*core.Vote{tc.last.ProposerVote()})).
+		SetTransactions(tc.node.txpool.PopTxsFromQueue(100)).
+		Sign(tc.priv)
+	txs, old := tc.node.txpool.GetTxsToExecute(blk.Transactions())
+	bcm, txcs := tc.node.execution.Execute(blk, txs)
+	bcm.SetOldBlockTxs(old)
+	err := tc.node.storage.Commit(&storage.CommitData{
+		Block:        blk,
+		QC:           core.NewQuorumCert().Build([]*core.Vote{blk.ProposerVote()}),
+		Transactions: txs,
+		BlockCommit:  bcm,
+		TxCommits:    txcs,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc.node.txpool.RemoveTxs(blk.Transactions())
+	tc.last = blk
+	return blk
+}
+
+func dialTestGRPC(t *testing.T, svc service, token string) client_pb.NodeClient {
+	srv := newGRPCServer(svc, token)
+	ln := bufconn.Listen(1 << 20)
+	go srv.Serve(ln)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, s string) (net.Conn, error) {
+			return ln.Dial()
+		}),
+		grpc.WithInsecure(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return client_pb.NewNodeClient(conn)
+}
+
+func TestGRPCServer_JuriaCoin(t *testing.T) {
+	assert := assert.New(t)
+
+	tc := newTestChain(t)
+	client := dialTestGRPC(t, &nodeService{tc.node}, "")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, err := client.SubscribeCommits(ctx, &client_pb.SubscribeCommitsRequest{})
+	assert.NoError(err)
+	time.Sleep(50 * time.Millisecond) // wait subscription on server
+
+	// submits tx and commits it in the next block, the commit event is streamed
+	submitAndCommit := func(tx *core.Transaction) {
+		b, err := tx.Marshal()
+		assert.NoError(err)
+		res, err := client.SubmitTransaction(ctx, &client_pb.SubmitTxRequest{Transaction: b})
+		if !assert.NoError(err) {
+			return
+		}
+		assert.Equal(tx.Hash(), res.Hash)
+		committed := tc.commitBlock(t)
+
+		event, err := stream.Recv()
+		if !assert.NoError(err) {
+			return
+		}
+		blk := core.NewBlock()
+		assert.NoError(blk.Unmarshal(event.Block))
+		assert.Equal(committed.Hash(), blk.Hash())
+		assert.Equal([][]byte{tx.Hash()}, blk.Transactions())
+		if !assert.Len(event.TxCommits, 1) {
+			return
+		}
+		txc := core.NewTxCommit()
+		assert.NoError(txc.Unmarshal(event.TxCommits[0]))
+		assert.Equal(tx.Hash(), txc.Hash())
+		assert.Equal(blk.Hash(), txc.BlockHash())
+		assert.Empty(txc.Error())
+	}
+
+	minter := core.GenerateKey(nil)
+	input, _ := json.Marshal(&execution.DeploymentInput{
+		CodeInfo: execution.CodeInfo{
+			DriverType: execution.DriverTypeNative,
+			CodeID:     execution.NativeCodeIDJuriaCoin,
+		},
+	})
+	deployTx := core.NewTransaction().SetNonce(time.Now().UnixNano()).SetInput(input).Sign(minter)
+	submitAndCommit(deployTx)
+
+	dest := core.GenerateKey(nil).PublicKey()
+	input, _ = json.Marshal(&juriacoin.Input{
+		Method: "mint",
+		Dest:   dest.Bytes(),
+		Value:  100,
+	})
+	submitAndCommit(core.NewTransaction().
+		SetNonce(time.Now().UnixNano()).
+		SetCodeAddr(deployTx.Hash()).
+		SetInput(input).
+		Sign(minter))
+
+	input, _ = json.Marshal(&juriacoin.Input{
+		Method:   "balance",
+		DestAddr: dest.Address().String(),
+	})
+	query, err := client.QueryChaincode(ctx, &client_pb.QueryRequest{CodeAddr: deployTx.Hash(), Input: input})
+	assert.NoError(err)
+	var balance int64
+	assert.NoError(json.Unmarshal(query.Value, &balance))
+	assert.EqualValues(100, balance)
+
+	state, err := client.GetState(ctx, &client_pb.GetStateRequest{
+		CodeAddr: deployTx.Hash(),
+		Key:      dest.Address().Bytes(),
+	})
+	assert.NoError(err)
+	assert.Equal(tc.node.storage.GetState(deployTx.Hash(), dest.Address().Bytes()), state.Value)
+	assert.NotEmpty(state.Value)
+
+	res, err := client.GetBlockByHeight(ctx, &client_pb.GetBlockByHeightRequest{Height: 2})
+	assert.NoError(err)
+	blk := core.NewBlock()
+	assert.NoError(blk.Unmarshal(res.Block))
+	assert.Equal(tc.last.Hash(), blk.Hash())
+
+	_, err = client.SubmitTransaction(ctx, &client_pb.SubmitTxRequest{Transaction: []byte("invalid")})
+	assert.Equal(codes.InvalidArgument, status.Code(err))
+}
+
+func TestGRPCServer_Auth(t *testing.T) {
+	assert := assert.New(t)
+
+	client := dialTestGRPC(t, &nodeService{newTestChain(t).node}, "secret")
+	req := &client_pb.GetStateRequest{Key: []byte("k")}
+
+	_, err := client.GetState(context.Background(), req)
+	assert.Equal(codes.Unauthenticated, status.Code(err))
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), grpcAuthKey, "Bearer wrong")
+	_, err = client.GetState(ctx, req)
+	assert.Equal(codes.Unauthenticated, status.Code(err))
+
+	ctx = metadata.AppendToOutgoingContext(context.Background(), grpcAuthKey, "Bearer secret")
+	_, err = client.GetState(ctx, req)
+	assert.NoError(err)
+
+	stream, err := client.SubscribeCommits(context.Background(), &client_pb.SubscribeCommitsRequest{})
+	assert.NoError(err)
+	_, err = stream.Recv()
+	assert.Equal(codes.Unauthenticated, status.Code(err))
+}
@@ -4,6 +4,10 @@
 package node
 
 import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
 	"net"
@@ -30,6 +34,8 @@ type Node struct {
 	peers   []*p2p.Peer
 	genesis *Genesis
 
+	genesisBlock *core.Genesis
+
 	vldStore  core.ValidatorStore
 	storage   *storage.Storage
 	host      *p2p.Host
@@ -37,15 +43,21 @@ type Node struct {
 	txpool    *txpool.TxPool
 	execution *execution.Execution
 	consensus *consensus.Consensus
+
+	diskMonitor *diskMonitor
 }
 
 func Run(config Config) {
 	node := new(Node)
 	node.config = config
+	core.SetDomainSeparation(config.DomainSeparation)
+	core.SetSignatureCacheSize(config.SigCacheSize)
 	node.setupBinccDir()
 	node.setupLogger()
+	node.setupHashFunc()
 	node.readFiles()
 	node.setupComponents()
+	node.diskMonitor.start()
 	logger.I().Infow("node setup done, starting consensus...")
 	node.consensus.Start()
 	status := node.consensus.GetStatus()
@@ -56,7 +68,9 @@ func Run(config Config) {
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	<-c
 	logger.I().Info("node killed")
+	node.diskMonitor.stop()
 	node.consensus.Stop()
+	node.storage.Close()
 }
 
 func (node *Node) setupLogger() {
@@ -73,6 +87,16 @@ func (node *Node) setupLogger() {
 	logger.Set(inst.Sugar())
 }
 
+func (node *Node) setupHashFunc() {
+	h, err := core.ParseHashFunc(node.config.HashFunc)
+	if err == nil {
+		err = core.SetHashFunc(h)
+	}
+	if err != nil {
+		logger.I().Fatalw("setup hash function failed", "error", err)
+	}
+}
+
 func (node *Node) setupBinccDir() {
 	node.config.ExecutionConfig.BinccDir = path.Join(node.config.Datadir, "bincc")
 	os.Mkdir(node.config.ExecutionConfig.BinccDir, 0755)
@@ -91,7 +115,15 @@ func (node *Node) readFiles() {
 		logger.I().Fatalw("read genesis failed", "error", err)
 	}
 
+	node.genesisBlock, err = readGenesisBlock(node.config.Datadir)
+	if err != nil {
+		logger.I().Fatalw("read genesis block failed", "error", err)
+	}
+
 	node.peers, err = readPeers(node.config.Datadir)
+	if err != nil && len(node.config.Seeds) > 0 && errors.Is(err, os.ErrNotExist) {
+		err = nil // peers are discovered from seeds
+	}
 	if err != nil {
 		logger.I().Fatalw("read peers failed", "error", err)
 	}
@@ -101,34 +133,81 @@ func (node *Node) readFiles() {
 func (node *Node) setupComponents() {
 	node.setupValidatorStore()
 	node.setupStorage()
+	node.setupGenesis()
+	node.setupEpochValidators()
 	node.setupHost()
 	logger.I().Infow("setup p2p host", "port", node.config.Port)
-	node.msgSvc = p2p.NewMsgService(node.host)
-	node.execution = execution.New(node.storage, node.config.ExecutionConfig)
+	node.msgSvc = p2p.NewMsgService(node.host, node.unmarshalLimits())
+	node.msgSvc.SetValidatorStore(node.vldStore)
+	node.msgSvc.SetSeenCache(node.config.SeenMsgCache)
+	node.bootstrapPeers()
+	node.execution = execution.New(&execStorage{node.storage}, node.config.ExecutionConfig)
 	node.txpool = txpool.New(node.storage, node.execution, node.msgSvc)
+	node.txpool.SetTxLimits(node.config.TxLimits)
 	node.setupConsensus()
+	node.setupDiskMonitor()
 	node.setReqHandlers()
 	serveNodeAPI(node)
+	if node.config.GRPCAddr != "" {
+		serveGRPC(node)
+		logger.I().Infow("serving grpc", "addr", node.config.GRPCAddr)
+	}
 }
 
 func (node *Node) setupValidatorStore() {
-	validators := make([]*core.PublicKey, len(node.genesis.Validators))
-	for i, v := range node.genesis.Validators {
-		pubKey, err := core.NewPublicKey(v)
-		if err != nil {
-			logger.I().Fatalw("parse validator failed", "error", err)
-		}
-		validators[i] = pubKey
+	set := &core.ValidatorSet{
+		Validators: node.genesis.Validators,
+		Proofs:     node.genesis.Proofs,
+	}
+	vldStore, err := set.Store()
+	if err != nil {
+		logger.I().Fatalw("parse validator failed", "error", err)
 	}
-	node.vldStore = core.NewValidatorStore(validators)
+	node.vldStore = vldStore
+}
+
+// setupEpochValidators replaces the genesis validators with
+// the validator set in state when epoch length is set
+func (node *Node) setupEpochValidators() {
+	if node.config.EpochLength == 0 {
+		return
+	}
+	vldStore, err := core.NewEpochValidatorStore(node.config.EpochLength, node.vldStore)
+	if err != nil {
+		logger.I().Fatalw("setup epoch validators failed", "error", err)
+	}
+	vldStore.SetSnapshotStore(node.storage)
+	err = vldStore.Restore(node.storage.GetBlockHeight(), node.storage.GetState(nil, core.ValidatorSetKey))
+	if err != nil {
+		logger.I().Fatalw("restore validator set failed", "error", err)
+	}
+	node.vldStore = vldStore
 }
 
 func (node *Node) setupStorage() {
-	db, err := storage.NewDB(path.Join(node.config.Datadir, "db"))
+	strg, err := storage.Open(path.Join(node.config.Datadir, "db"), node.config.StorageConfig)
 	if err != nil {
 		logger.I().Fatalw("setup storage failed", "error", err)
 	}
-	node.storage = storage.New(db, node.config.StorageConfig)
+	node.storage = strg
+	if node.config.StorageConfig.GCInterval > 0 {
+		node.storage.StartGC(context.Background(),
+			node.config.StorageConfig.GCInterval, node.config.StorageConfig.GCDiscardRatio)
+	}
+}
+
+func (node *Node) setupGenesis() {
+	chainID := ChainIDBytes(node.config.ConsensusConfig.ChainID)
+	if node.genesisBlock == nil {
+		node.genesisBlock = core.NewGenesis(chainID, node.genesis.Validators, nil)
+	}
+	if err := node.genesisBlock.Verify(chainID, node.genesis.Validators); err != nil {
+		logger.I().Fatalw("genesis doesn't match configured validators", "error", err)
+	}
+	if err := node.storage.InitGenesis(node.genesisBlock); err != nil {
+		logger.I().Fatalw("init genesis failed", "error", err)
+	}
+	logger.I().Infow("verified genesis", "hash", hex.EncodeToString(node.genesisBlock.Hash()))
 }
 
 func (node *Node) setupHost() {
@@ -142,6 +221,12 @@ func (node *Node) setupHost() {
 	if err != nil {
 		logger.I().Fatalw("cannot create p2p host", "error", err)
 	}
+	host.SetCompression(node.config.MsgCompression)
+	host.SetRateLimit(node.config.PeerRateLimit)
+	host.SetMaxMsgSize(uint32(node.config.MaxMsgSize))
+	host.PeerStore().SetScoreConfig(node.config.PeerScore)
+	host.SetPeerExchange(node.config.PeerExchange || len(node.config.Seeds) > 0)
+	host.SetAllowedPeers(node.isAllowedPeer)
 	for _, p := range node.peers {
 		if !p.PublicKey().Equal(node.privKey.PublicKey()) {
 			host.AddPeer(p)
@@ -150,11 +235,60 @@ func (node *Node) setupHost() {
 	node.host = host
 }
 
+// isAllowedPeer allows the validators and the peers of the peers file to be discovered
+func (node *Node) isAllowedPeer(pubKey *core.PublicKey) bool {
+	if node.vldStore.IsValidator(pubKey) {
+		return true
+	}
+	for _, p := range node.peers {
+		if p.PublicKey().Equal(pubKey) {
+			return true
+		}
+	}
+	return false
+}
+
+// bootstrapPeers discovers peers from the seeds in the background
+func (node *Node) bootstrapPeers() {
+	if len(node.config.Seeds) == 0 {
+		return
+	}
+	seeds := make([]multiaddr.Multiaddr, len(node.config.Seeds))
+	for i, s := range node.config.Seeds {
+		addr, err := multiaddr.NewMultiaddr(s)
+		if err != nil {
+			logger.I().Fatalw("invalid seed address", "addr", s, "error", err)
+		}
+		seeds[i] = addr
+	}
+	go func() {
+		if err := node.host.Bootstrap(seeds); err != nil {
+			logger.I().Errorw("bootstrap peers failed", "error", err)
+			return
+		}
+		logger.I().Infow("bootstrapped peers", "count", len(node.host.PeerStore().List()))
+	}()
+}
+
+// unmarshalLimits bounds messages from peers, signatures in a qc cannot exceed validator count
+func (node *Node) unmarshalLimits() core.UnmarshalLimits {
+	return core.UnmarshalLimits{
+		MaxSize: node.config.MaxMsgSize,
+		MaxTxs:  node.config.ConsensusConfig.MaxTxPerBlock,
+		MaxSigs: node.vldStore.ValidatorCount(),
+	}
+}
+
 func (node *Node) setupConsensus() {
+	var strg consensus.Storage = node.storage
+	if vldStore, ok := node.vldStore.(*core.EpochValidatorStore); ok {
+		strg = &epochStorage{node.storage, vldStore}
+	}
+	node.config.ConsensusConfig.WALFile = path.Join(node.config.Datadir, ConsensusWALFile)
 	node.consensus = consensus.New(&consensus.Resources{
 		Signer:    node.privKey,
 		VldStore:  node.vldStore,
-		Storage:   node.storage,
+		Storage:   strg,
 		MsgSvc:    node.msgSvc,
 		TxPool:    node.txpool,
 		Execution: node.execution,
@@ -162,6 +296,22 @@ func (node *Node) setupConsensus() {
 
 }
 
+func (node *Node) setupDiskMonitor() {
+	node.diskMonitor = &diskMonitor{
+		dir:              node.config.Datadir,
+		softLimit:        node.config.DiskSoftLimit,
+		hardLimit:        node.config.DiskHardLimit,
+		interval:         node.config.DiskCheckInterval,
+		statDisk:         statDisk,
+		setLowDiskSpace:  node.txpool.SetLowDiskSpace,
+		setEmptyProposal: node.consensus.SetEmptyProposal,
+		runValueLogGC: func() error {
+			return node.storage.RunValueLogGC(node.config.StorageConfig.GCDiscardRatio)
+		},
+		stopCh: make(chan struct{}),
+	}
+}
+
 func (node *Node) setReqHandlers() {
 	node.msgSvc.SetReqHandler(&p2p.BlockReqHandler{
 		GetBlock: node.GetBlock,
@@ -169,9 +319,19 @@ func (node *Node) setReqHandlers() {
 	node.msgSvc.SetReqHandler(&p2p.BlockByHeightReqHandler{
 		GetBlockByHeight: node.storage.GetBlockByHeight,
 	})
+	node.msgSvc.SetReqHandler(&p2p.BlockWithQCByHeightReqHandler{
+		GetBlockByHeight: node.storage.GetBlockByHeight,
+		GetQC:            node.GetQC,
+	})
+	node.msgSvc.SetReqHandler(&p2p.BlocksByRangeReqHandler{
+		GetBlocksByRange: node.storage.GetBlocksByRange,
+	})
 	node.msgSvc.SetReqHandler(&p2p.TxListReqHandler{
 		GetTxList: node.GetTxList,
 	})
+	node.msgSvc.SetReqHandler(&p2p.SilentViewsReqHandler{
+		GetSilentViews: node.consensus.GetSilentViews,
+	})
 }
 
 func (node *Node) GetBlock(hash []byte) (*core.Block, error) {
@@ -181,6 +341,27 @@ func (node *Node) GetBlock(hash []byte) (*core.Block, error) {
 	return node.storage.GetBlock(hash)
 }
 
+// GetQC returns the qc certifying the commited block,
+// or the qc of its child for blocks commited without qc stored
+func (node *Node) GetQC(hash []byte) (*core.QuorumCert, error) {
+	qc, err := node.storage.GetQC(hash)
+	if err == nil {
+		return qc, nil
+	}
+	blk, err := node.storage.GetBlockHeader(hash)
+	if err != nil {
+		return nil, err
+	}
+	child, err := node.storage.GetBlockHeaderByHeight(blk.Height() + 1)
+	if err != nil {
+		return nil, fmt.Errorf("qc not found for block %d", blk.Height())
+	}
+	if !bytes.Equal(child.QuorumCert().BlockHash(), hash) {
+		return nil, fmt.Errorf("qc not found for block %d", blk.Height())
+	}
+	return child.QuorumCert(), nil
+}
+
 func (node *Node) GetTxList(hashes [][]byte) (*core.TxList, error) {
 	ret := make(core.TxList, len(hashes))
 	for i, hash := range hashes {
@@ -0,0 +1,80 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package node
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+
+	"github.com/aungmawjj/juria-blockchain/storage"
+)
+
+const prometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// writeStorageMetrics writes storage metrics in prometheus text format
+func writeStorageMetrics(w io.Writer, m storage.Metrics) error {
+	bw := bufio.NewWriter(w)
+	counter := func(name, help string, value uint64) {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", name, help, name, name, value)
+	}
+	gauge := func(name, help string, value uint64) {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s gauge\n%s %d\n", name, help, name, name, value)
+	}
+	histogram := func(name, help string, stats storage.LatencyStats) {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+		var cumulative uint64
+		for i, bound := range storage.LatencyBuckets {
+			cumulative += stats.Buckets[i]
+			fmt.Fprintf(bw, "%s_bucket{le=\"%s\"} %d\n",
+				name, strconv.FormatFloat(bound.Seconds(), 'g', -1, 64), cumulative)
+		}
+		fmt.Fprintf(bw, "%s_bucket{le=\"+Inf\"} %d\n", name, stats.Count)
+		fmt.Fprintf(bw, "%s_sum %s\n", name, strconv.FormatFloat(stats.TotalSeconds, 'g', -1, 64))
+		fmt.Fprintf(bw, "%s_count %d\n", name, stats.Count)
+	}
+
+	counter("juria_storage_commits_total", "Blocks commited.", m.Commits)
+	counter("juria_storage_commit_bytes_total", "Key and value bytes written by commits.", m.CommitBytes)
+	gauge("juria_storage_last_commit_bytes", "Key and value bytes written by the last commit.", m.LastCommitBytes)
+
+	histogram("juria_storage_commit_seconds", "Commit latency.", m.Commit)
+	histogram("juria_storage_merkle_seconds", "Merkle update computation latency.", m.Merkle)
+	histogram("juria_storage_chain_seconds", "Chain data write latency.", m.Chain)
+	histogram("juria_storage_state_seconds", "State and merkle tree write latency.", m.State)
+	histogram("juria_storage_get_state_seconds", "State read latency.", m.GetState)
+	histogram("juria_storage_get_block_seconds", "Block read latency.", m.GetBlock)
+
+	gauge("juria_storage_lsm_bytes", "Size of LSM tree.", uint64(m.LSMSize))
+	gauge("juria_storage_vlog_bytes", "Size of value log.", uint64(m.VlogSize))
+	gauge("juria_storage_block_height", "Last commited block height.", m.BlockHeight)
+	gauge("juria_storage_merkle_leaves", "Leaves of state merkle tree.", m.LeafCount)
+
+	namespaces := make([]string, 0, len(m.KeyCounts))
+	for ns := range m.KeyCounts {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+	fmt.Fprintf(bw, "# HELP juria_storage_keys Approximate keys by namespace.\n# TYPE juria_storage_keys gauge\n")
+	for _, ns := range namespaces {
+		fmt.Fprintf(bw, "juria_storage_keys{namespace=%q} %d\n", ns, m.KeyCounts[ns])
+	}
+	return bw.Flush()
+}
+
+// writeDiskMetrics writes the last disk sample in prometheus text format
+func writeDiskMetrics(w io.Writer, status DiskStatus) error {
+	bw := bufio.NewWriter(w)
+	gauge := func(name, help string, value uint64) {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s gauge\n%s %d\n", name, help, name, name, value)
+	}
+	gauge("juria_disk_total_bytes", "Size of the filesystem holding data directory.", status.Total)
+	gauge("juria_disk_free_bytes", "Free space of the filesystem holding data directory.", status.Free)
+	gauge("juria_disk_soft_limit_bytes", "Free space below which new txs are rejected.", status.SoftLimit)
+	gauge("juria_disk_hard_limit_bytes", "Free space below which empty blocks are proposed.", status.HardLimit)
+	gauge("juria_disk_level", "Disk degradation level, 0 ok, 1 low, 2 critical.", uint64(status.Level))
+	return bw.Flush()
+}
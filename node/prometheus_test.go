@@ -0,0 +1,61 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package node
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/aungmawjj/juria-blockchain/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteStorageMetrics(t *testing.T) {
+	assert := assert.New(t)
+
+	m := storage.Metrics{
+		Commits:     3,
+		BlockHeight: 2,
+		KeyCounts:   map[string]uint64{storage.NamespaceState: 10, storage.NamespaceChain: 20},
+		Commit: storage.LatencyStats{
+			Count:        3,
+			TotalSeconds: 0.5,
+			Buckets:      []uint64{1, 0, 1, 0, 1, 0},
+		},
+	}
+	for _, stats := range []*storage.LatencyStats{&m.Merkle, &m.Chain, &m.State, &m.GetState, &m.GetBlock} {
+		stats.Buckets = make([]uint64, len(storage.LatencyBuckets)+1)
+	}
+	buf := new(bytes.Buffer)
+	assert.NoError(writeStorageMetrics(buf, m))
+	out := buf.String()
+
+	assert.Contains(out, "# TYPE juria_storage_commits_total counter\njuria_storage_commits_total 3\n")
+	assert.Contains(out, "juria_storage_block_height 2\n")
+	assert.Contains(out, "juria_storage_commit_seconds_bucket{le=\"0.0001\"} 1\n")
+	assert.Contains(out, "juria_storage_commit_seconds_bucket{le=\"0.01\"} 2\n", "cumulative")
+	assert.Contains(out, "juria_storage_commit_seconds_bucket{le=\"+Inf\"} 3\n")
+	assert.Contains(out, "juria_storage_commit_seconds_sum 0.5\n")
+	assert.Contains(out, "juria_storage_keys{namespace=\"chain\"} 20\njuria_storage_keys{namespace=\"state\"} 10\n")
+}
+
+func TestWriteDiskMetrics(t *testing.T) {
+	assert := assert.New(t)
+
+	buf := new(bytes.Buffer)
+	assert.NoError(writeDiskMetrics(buf, DiskStatus{
+		Level:     DiskLow,
+		Total:     100,
+		Free:      20,
+		SoftLimit: 30,
+		HardLimit: 10,
+	}))
+	out := buf.String()
+
+	assert.Contains(out, "# TYPE juria_disk_free_bytes gauge\njuria_disk_free_bytes 20\n")
+	assert.Contains(out, "juria_disk_total_bytes 100\n")
+	assert.Contains(out, "juria_disk_soft_limit_bytes 30\n")
+	assert.Contains(out, "juria_disk_hard_limit_bytes 10\n")
+	assert.Contains(out, "juria_disk_level 1\n")
+}
@@ -0,0 +1,35 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package node
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+
+	"github.com/aungmawjj/juria-blockchain/storage"
+)
+
+// RebuildMerkleTree rebuilds the state tree in the datadir of a stopped node
+// and checks the new root against the last block commit which records a state root.
+func RebuildMerkleTree(config Config) ([]byte, error) {
+	strg, err := storage.Open(path.Join(config.Datadir, "db"), config.StorageConfig)
+	if err != nil {
+		return nil, err
+	}
+	defer strg.Close()
+
+	root, err := strg.RebuildMerkleTree()
+	if err != nil {
+		return nil, err
+	}
+	want, err := strg.GetLastCommitedRoot()
+	if err != nil {
+		return root, err
+	}
+	if !bytes.Equal(root, want) {
+		return root, fmt.Errorf("rebuilt root %x, last block commit has %x", root, want)
+	}
+	return root, nil
+}
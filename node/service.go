@@ -0,0 +1,47 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package node
+
+import (
+	"github.com/aungmawjj/juria-blockchain/core"
+	"github.com/aungmawjj/juria-blockchain/emitter"
+	"github.com/aungmawjj/juria-blockchain/execution"
+)
+
+// service is shared by node apis, so that rest and grpc behave the same
+type service interface {
+	GetState(codeAddr, key []byte) []byte
+	QueryChaincode(query *execution.QueryData) ([]byte, error)
+	GetBlockByHeight(height uint64) (*core.Block, error)
+	SubmitTx(tx *core.Transaction) error
+
+	// events are *storage.CommitData
+	SubscribeCommits(buffer int) *emitter.Subscription
+}
+
+type nodeService struct {
+	node *Node
+}
+
+var _ service = (*nodeService)(nil)
+
+func (svc *nodeService) GetState(codeAddr, key []byte) []byte {
+	return svc.node.storage.GetState(codeAddr, key)
+}
+
+func (svc *nodeService) QueryChaincode(query *execution.QueryData) ([]byte, error) {
+	return svc.node.execution.Query(query)
+}
+
+func (svc *nodeService) GetBlockByHeight(height uint64) (*core.Block, error) {
+	return svc.node.storage.GetBlockByHeight(height)
+}
+
+func (svc *nodeService) SubmitTx(tx *core.Transaction) error {
+	return svc.node.txpool.SubmitTx(tx)
+}
+
+func (svc *nodeService) SubscribeCommits(buffer int) *emitter.Subscription {
+	return svc.node.storage.SubscribeCommitted(buffer)
+}
@@ -6,18 +6,33 @@ package p2p
 import (
 	"context"
 	"errors"
+	"fmt"
+	"sync"
 
 	"github.com/aungmawjj/juria-blockchain/core"
+	"github.com/aungmawjj/juria-blockchain/logger"
 	"github.com/libp2p/go-libp2p"
 	"github.com/libp2p/go-libp2p-core/crypto"
 	"github.com/libp2p/go-libp2p-core/host"
 	"github.com/libp2p/go-libp2p-core/network"
 	"github.com/libp2p/go-libp2p-core/peer"
 	"github.com/libp2p/go-libp2p-core/peerstore"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	noise "github.com/libp2p/go-libp2p-noise"
 	"github.com/multiformats/go-multiaddr"
 )
 
-const protocolID = "/single_pid"
+// errors
+var (
+	ErrUnexpectedPeerKey = errors.New("unexpected peer public key")
+)
+
+const (
+	protocolID = "/single_pid"
+
+	// messages are compressed with snappy on the connections of this protocol
+	compressedProtocolID = "/single_pid/snappy"
+)
 
 type Host struct {
 	privKey   *core.PrivateKey
@@ -25,6 +40,13 @@ type Host struct {
 
 	peerStore *PeerStore
 	libHost   host.Host
+
+	compression  bool
+	rateLimit    RateLimit
+	maxMsgSize   uint32
+	peerExchange bool
+	allowPeer    func(pubKey *core.PublicKey) bool // nil allows any peer
+	mtxConfig    sync.RWMutex
 }
 
 func NewHost(privKey *core.PrivateKey, localAddr multiaddr.Multiaddr) (*Host, error) {
@@ -42,6 +64,9 @@ func NewHost(privKey *core.PrivateKey, localAddr multiaddr.Multiaddr) (*Host, er
 	return host, nil
 }
 
+// newLibHost accepts only noise connections, which are encrypted and authenticated.
+// The handshake proves the remote holds the private key of its peer ID,
+// and the dialer aborts it if the remote is not the dialed peer ID.
 func (host *Host) newLibHost() (host.Host, error) {
 	priv, err := crypto.UnmarshalEd25519PrivateKey(host.privKey.Bytes())
 	if err != nil {
@@ -51,21 +76,92 @@ func (host *Host) newLibHost() (host.Host, error) {
 		context.Background(),
 		libp2p.Identity(priv),
 		libp2p.ListenAddrs(host.localAddr),
+		libp2p.Security(noise.ID, noise.New),
 	)
 }
 
+// SetCompression enables snappy compression of messages.
+// The protocol is negotiated per connection, the connections with the peers
+// which don't support it are not compressed.
+// It applies to the connections made after it's set.
+func (host *Host) SetCompression(enabled bool) *Host {
+	host.mtxConfig.Lock()
+	defer host.mtxConfig.Unlock()
+
+	host.compression = enabled
+	if enabled {
+		host.libHost.SetStreamHandler(compressedProtocolID, host.handleStream)
+	} else {
+		host.libHost.RemoveStreamHandler(compressedProtocolID)
+	}
+	return host
+}
+
+// SetRateLimit limits the inbound messages of each peer.
+// The excess messages are dropped before they are decoded.
+func (host *Host) SetRateLimit(limit RateLimit) *Host {
+	host.mtxConfig.Lock()
+	defer host.mtxConfig.Unlock()
+
+	host.rateLimit = limit
+	for _, peer := range host.peerStore.List() {
+		peer.setRateLimit(limit)
+	}
+	return host
+}
+
+func (host *Host) getRateLimit() RateLimit {
+	host.mtxConfig.RLock()
+	defer host.mtxConfig.RUnlock()
+	return host.rateLimit
+}
+
+// SetMaxMsgSize limits the size of inbound messages of each peer in bytes.
+// The connection is closed on a bigger length prefix, before the message is allocated.
+// MessageSizeLimit is used if it's zero.
+func (host *Host) SetMaxMsgSize(size uint32) *Host {
+	host.mtxConfig.Lock()
+	defer host.mtxConfig.Unlock()
+
+	host.maxMsgSize = size
+	for _, peer := range host.peerStore.List() {
+		peer.setMaxMsgSize(size)
+	}
+	return host
+}
+
+func (host *Host) getMaxMsgSize() uint32 {
+	host.mtxConfig.RLock()
+	defer host.mtxConfig.RUnlock()
+	return host.maxMsgSize
+}
+
+func (host *Host) protocols() []protocol.ID {
+	host.mtxConfig.RLock()
+	defer host.mtxConfig.RUnlock()
+
+	if host.compression {
+		return []protocol.ID{compressedProtocolID, protocolID}
+	}
+	return []protocol.ID{protocolID}
+}
+
 func (host *Host) handleStream(s network.Stream) {
 	pubKey, err := getRemotePublicKey(s)
 	if err != nil {
 		return
 	}
-	if peer := host.peerStore.Load(pubKey); peer != nil {
+	if peer := host.peerStore.Load(pubKey); peer != nil && !host.peerStore.IsBanned(pubKey) {
 		if err := peer.setConnecting(); err == nil {
-			peer.onConnected(s)
+			peer.onConnected(s, isCompressed(s))
 			return
 		}
 	}
-	s.Close() // cannot find peer in the store (peer not allowed to connect)
+	s.Close() // cannot find peer in the store (peer not allowed to connect) or banned
+}
+
+func isCompressed(s network.Stream) bool {
+	return s.Protocol() == compressedProtocolID
 }
 
 func (host *Host) connectPeer(peer *Peer) {
@@ -78,7 +174,13 @@ func (host *Host) connectPeer(peer *Peer) {
 		peer.disconnect()
 		return
 	}
-	peer.onConnected(s)
+	if err := checkRemotePublicKey(s, peer.PublicKey()); err != nil {
+		logger.I().Warnw("peer authentication failed", "addr", peer.Addr(), "error", err)
+		s.Reset()
+		peer.disconnect()
+		return
+	}
+	peer.onConnected(s, isCompressed(s))
 }
 
 func (host *Host) newStream(peer *Peer) (network.Stream, error) {
@@ -87,12 +189,17 @@ func (host *Host) newStream(peer *Peer) (network.Stream, error) {
 		return nil, err
 	}
 	host.libHost.Peerstore().AddAddr(id, peer.Addr(), peerstore.PermanentAddrTTL)
-	return host.libHost.NewStream(context.Background(), id, protocolID)
+	// the remote host selects the first protocol it supports
+	return host.libHost.NewStream(context.Background(), id, host.protocols()...)
 }
 
 func (host *Host) AddPeer(peer *Peer) {
 	peer.host = host
-	peer, _ = host.peerStore.LoadOrStore(peer)
+	peer, loaded := host.peerStore.LoadOrStore(peer)
+	if !loaded {
+		peer.setRateLimit(host.getRateLimit())
+		peer.setMaxMsgSize(host.getMaxMsgSize())
+	}
 	go host.connectPeer(peer)
 }
 
@@ -111,6 +218,18 @@ func getRemotePublicKey(s network.Stream) (*core.PublicKey, error) {
 	return core.NewPublicKey(b)
 }
 
+// checkRemotePublicKey checks that the authenticated remote key of the stream is the expected one
+func checkRemotePublicKey(s network.Stream, expected *core.PublicKey) error {
+	pubKey, err := getRemotePublicKey(s)
+	if err != nil {
+		return err
+	}
+	if !pubKey.Equal(expected) {
+		return fmt.Errorf("%w, remote %s", ErrUnexpectedPeerKey, pubKey)
+	}
+	return nil
+}
+
 func getIDFromPublicKey(pubKey *core.PublicKey) (peer.ID, error) {
 	var id peer.ID
 	if pubKey == nil {
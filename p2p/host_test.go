@@ -4,10 +4,14 @@
 package p2p
 
 import (
+	"context"
 	"testing"
 	"time"
 
 	"github.com/aungmawjj/juria-blockchain/core"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peerstore"
 	"github.com/multiformats/go-multiaddr"
 	"github.com/stretchr/testify/assert"
 )
@@ -100,3 +104,179 @@ func TestHost(t *testing.T) {
 		assert.Equal(PeerStatusDisconnected, p4.Status())
 	}
 }
+
+func TestHost_Compression(t *testing.T) {
+	assert := assert.New(t)
+
+	priv1 := core.GenerateKey(nil)
+	priv2 := core.GenerateKey(nil)
+	priv3 := core.GenerateKey(nil)
+
+	addr1, _ := multiaddr.NewMultiaddr("/ip4/127.0.0.1/tcp/25011")
+	addr2, _ := multiaddr.NewMultiaddr("/ip4/127.0.0.1/tcp/25012")
+	addr3, _ := multiaddr.NewMultiaddr("/ip4/127.0.0.1/tcp/25013")
+
+	host1, err := NewHost(priv1, addr1)
+	if !assert.NoError(err) {
+		return
+	}
+	host2, err := NewHost(priv2, addr2)
+	if !assert.NoError(err) {
+		return
+	}
+	host3, err := NewHost(priv3, addr3)
+	if !assert.NoError(err) {
+		return
+	}
+	host1.SetCompression(true)
+	host2.SetCompression(true)
+
+	// only host1 connects, host3 doesn't support compression
+	for _, host := range []*Host{host2, host3} {
+		peer := NewPeer(priv1.PublicKey(), addr1)
+		peer.host = host
+		host.peerStore.Store(peer)
+	}
+	host1.AddPeer(NewPeer(priv2.PublicKey(), addr2))
+	host1.AddPeer(NewPeer(priv3.PublicKey(), addr3))
+
+	time.Sleep(50 * time.Millisecond)
+
+	for _, tt := range []struct {
+		name     string
+		local    *Peer
+		remote   *Peer
+		compress bool
+	}{
+		{"both support", host1.PeerStore().Load(priv2.PublicKey()), host2.PeerStore().Load(priv1.PublicKey()), true},
+		{"fallback", host1.PeerStore().Load(priv3.PublicKey()), host3.PeerStore().Load(priv1.PublicKey()), false},
+	} {
+		if !assert.Equal(PeerStatusConnected, tt.local.Status(), tt.name) ||
+			!assert.Equal(PeerStatusConnected, tt.remote.Status(), tt.name) {
+			continue
+		}
+		assert.Equal(tt.compress, tt.local.isCompressed(), tt.name)
+		assert.Equal(tt.compress, tt.remote.isCompressed(), tt.name)
+
+		sub := tt.remote.SubscribeMsg()
+		msg := []byte("hello")
+		assert.NoError(tt.local.WriteMsg(msg))
+		select {
+		case e := <-sub.Events():
+			assert.Equal(msg, e.([]byte), tt.name)
+		case <-time.After(time.Second):
+			t.Errorf("%s: message not received", tt.name)
+		}
+		sub.Unsubscribe()
+	}
+}
+
+func TestHost_Authentication(t *testing.T) {
+	assert := assert.New(t)
+
+	priv1 := core.GenerateKey(nil)
+	priv2 := core.GenerateKey(nil)
+	priv3 := core.GenerateKey(nil)
+
+	addr1, _ := multiaddr.NewMultiaddr("/ip4/127.0.0.1/tcp/25021")
+	addr2, _ := multiaddr.NewMultiaddr("/ip4/127.0.0.1/tcp/25022")
+	addr3, _ := multiaddr.NewMultiaddr("/ip4/127.0.0.1/tcp/25023")
+
+	host1, err := NewHost(priv1, addr1)
+	if !assert.NoError(err) {
+		return
+	}
+	host2, err := NewHost(priv2, addr2)
+	if !assert.NoError(err) {
+		return
+	}
+	host3, err := NewHost(priv3, addr3)
+	if !assert.NoError(err) {
+		return
+	}
+
+	// authenticated loopback
+	host1.AddPeer(NewPeer(priv2.PublicKey(), addr2))
+	peer := NewPeer(priv1.PublicKey(), addr1)
+	peer.host = host2
+	host2.peerStore.Store(peer)
+
+	time.Sleep(50 * time.Millisecond)
+
+	local := host1.PeerStore().Load(priv2.PublicKey())
+	remote := host2.PeerStore().Load(priv1.PublicKey())
+	if assert.Equal(PeerStatusConnected, local.Status()) &&
+		assert.Equal(PeerStatusConnected, remote.Status()) {
+		sub := remote.SubscribeMsg()
+		defer sub.Unsubscribe()
+		msg := []byte("hello")
+		assert.NoError(local.WriteMsg(msg))
+		select {
+		case e := <-sub.Events():
+			assert.Equal(msg, e.([]byte))
+		case <-time.After(time.Second):
+			t.Error("message not received")
+		}
+	}
+
+	// host3 answers for a validator key it doesn't hold
+	wrongKey := core.GenerateKey(nil).PublicKey()
+	host1.AddPeer(NewPeer(wrongKey, addr3))
+	peer = NewPeer(priv1.PublicKey(), addr1)
+	peer.host = host3
+	host3.peerStore.Store(peer)
+
+	time.Sleep(50 * time.Millisecond)
+
+	assert.Equal(PeerStatusDisconnected, host1.PeerStore().Load(wrongKey).Status(), "handshake aborted")
+	assert.Equal(PeerStatusDisconnected, host3.PeerStore().Load(priv1.PublicKey()).Status())
+
+	// plaintext connections are refused
+	priv4 := core.GenerateKey(nil)
+	key4, err := crypto.UnmarshalEd25519PrivateKey(priv4.Bytes())
+	if !assert.NoError(err) {
+		return
+	}
+	plain, err := libp2p.New(context.Background(), libp2p.Identity(key4), libp2p.NoSecurity)
+	if !assert.NoError(err) {
+		return
+	}
+	defer plain.Close()
+	host1.peerStore.Store(NewPeer(priv4.PublicKey(), nil))
+	id1, err := getIDFromPublicKey(priv1.PublicKey())
+	assert.NoError(err)
+	plain.Peerstore().AddAddr(id1, addr1, peerstore.PermanentAddrTTL)
+	_, err = plain.NewStream(context.Background(), id1, protocolID)
+	assert.Error(err)
+	assert.Equal(PeerStatusDisconnected, host1.PeerStore().Load(priv4.PublicKey()).Status())
+}
+
+func TestCheckRemotePublicKey(t *testing.T) {
+	assert := assert.New(t)
+
+	priv1 := core.GenerateKey(nil)
+	priv2 := core.GenerateKey(nil)
+
+	addr1, _ := multiaddr.NewMultiaddr("/ip4/127.0.0.1/tcp/25031")
+	addr2, _ := multiaddr.NewMultiaddr("/ip4/127.0.0.1/tcp/25032")
+
+	host1, err := NewHost(priv1, addr1)
+	if !assert.NoError(err) {
+		return
+	}
+	host2, err := NewHost(priv2, addr2)
+	if !assert.NoError(err) {
+		return
+	}
+	peer := NewPeer(priv1.PublicKey(), addr1)
+	peer.host = host2
+	host2.peerStore.Store(peer)
+
+	s, err := host1.newStream(NewPeer(priv2.PublicKey(), addr2))
+	if !assert.NoError(err) {
+		return
+	}
+	defer s.Close()
+	assert.NoError(checkRemotePublicKey(s, priv2.PublicKey()))
+	assert.ErrorIs(checkRemotePublicKey(s, priv1.PublicKey()), ErrUnexpectedPeerKey)
+}
@@ -8,6 +8,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -43,14 +44,30 @@ type MsgService struct {
 	reqHandlers map[p2p_pb.Request_Type]ReqHandler
 
 	reqClientSeq uint32
+
+	// limits of received messages and responses
+	limits core.UnmarshalLimits
+
+	// validates received consensus messages to penalize the senders, not validated if nil
+	vldStore core.ValidatorStore
+	mtxVld   sync.RWMutex
+
+	// hashes of received and broadcast gossip messages, duplicates from other peers are dropped, disabled if nil
+	seen    *seenCache
+	mtxSeen sync.RWMutex
 }
 
-func NewMsgService(host *Host) *MsgService {
+func NewMsgService(host *Host, limits core.UnmarshalLimits) *MsgService {
 	svc := new(MsgService)
 	svc.host = host
+	svc.limits = limits
+	sub := svc.host.PeerStore().SubscribeAdded()
+	listening := make(map[*Peer]struct{})
 	for _, peer := range svc.host.PeerStore().List() {
+		listening[peer] = struct{}{}
 		go svc.listenPeer(peer)
 	}
+	go svc.listenPeersAdded(sub, listening)
 
 	svc.reqHandlers = make(map[p2p_pb.Request_Type]ReqHandler)
 	svc.setEmitters()
@@ -58,6 +75,64 @@ func NewMsgService(host *Host) *MsgService {
 	return svc
 }
 
+// SetValidatorStore validates the received proposals, votes and new views with the validator store.
+// The messages proven forged or corrupted are dropped and their senders are penalized,
+// see PeerStore.Penalize. The others are left to the consensus to validate.
+func (svc *MsgService) SetValidatorStore(vs core.ValidatorStore) *MsgService {
+	svc.mtxVld.Lock()
+	defer svc.mtxVld.Unlock()
+	svc.vldStore = vs
+	return svc
+}
+
+func (svc *MsgService) getValidatorStore() core.ValidatorStore {
+	svc.mtxVld.RLock()
+	defer svc.mtxVld.RUnlock()
+	return svc.vldStore
+}
+
+// SetSeenCache remembers the gossiped proposals and tx lists. A message received again
+// from any peer, or echoed back after broadcast, is dropped before decoding.
+// Genesis proposals are not remembered, see onReceiveProposal.
+// It resets the remembered messages.
+func (svc *MsgService) SetSeenCache(config SeenCacheConfig) *MsgService {
+	svc.mtxSeen.Lock()
+	defer svc.mtxSeen.Unlock()
+	svc.seen = newSeenCache(config)
+	return svc
+}
+
+func (svc *MsgService) getSeenCache() *seenCache {
+	svc.mtxSeen.RLock()
+	defer svc.mtxSeen.RUnlock()
+	return svc.seen
+}
+
+// isGossip checks whether the messages of the type are broadcast and deduplicated.
+// New views are sent to the leader again on each view change with the same qc, they are not deduplicated.
+func isGossip(msgType MsgType) bool {
+	return msgType == MsgTypeProposal || msgType == MsgTypeTxList
+}
+
+// isSeen checks whether the gossip message is already received or broadcast
+func (svc *MsgService) isSeen(msgType MsgType, data []byte) bool {
+	cache := svc.getSeenCache()
+	if cache == nil || !isGossip(msgType) {
+		return false
+	}
+	return cache.has(seenKey(msgType, data))
+}
+
+// markSeen records the gossip message and returns false if it's already seen,
+// so that the message received from multiple peers at once is emitted only once
+func (svc *MsgService) markSeen(msgType MsgType, data []byte) bool {
+	cache := svc.getSeenCache()
+	if cache == nil || !isGossip(msgType) {
+		return true
+	}
+	return cache.add(seenKey(msgType, data))
+}
+
 func (svc *MsgService) SubscribeProposal(buffer int) *emitter.Subscription {
 	return svc.proposalEmitter.Subscribe(buffer)
 }
@@ -120,9 +195,13 @@ func (svc *MsgService) RequestBlock(pubKey *core.PublicKey, hash []byte) (*core.
 		return nil, err
 	}
 	blk := core.NewBlock()
-	if err := blk.Unmarshal(respData); err != nil {
+	if err := blk.UnmarshalWithLimits(respData, svc.limits); err != nil {
 		return nil, err
 	}
+	// the block is validated by the caller, which doesn't know the requested hash
+	if !bytes.Equal(blk.Hash(), hash) {
+		return nil, fmt.Errorf("block hash mismatch %x", blk.Hash())
+	}
 	return blk, nil
 }
 
@@ -136,12 +215,106 @@ func (svc *MsgService) RequestBlockByHeight(
 		return nil, err
 	}
 	blk := core.NewBlock()
-	if err := blk.Unmarshal(respData); err != nil {
+	if err := blk.UnmarshalWithLimits(respData, svc.limits); err != nil {
 		return nil, err
 	}
 	return blk, nil
 }
 
+// RequestBlockWithQCByHeight requests the commited block with the qc certifying it
+func (svc *MsgService) RequestBlockWithQCByHeight(
+	pubKey *core.PublicKey, height uint64,
+) (*core.Block, *core.QuorumCert, error) {
+	buf := bytes.NewBuffer(nil)
+	binary.Write(buf, binary.BigEndian, height)
+	respData, err := svc.requestData(pubKey, p2p_pb.Request_BlockWithQCByHeight, buf.Bytes())
+	if err != nil {
+		return nil, nil, err
+	}
+	resp := new(p2p_pb.BlockWithQC)
+	if err := proto.Unmarshal(respData, resp); err != nil {
+		return nil, nil, err
+	}
+	blk := core.NewBlock()
+	if err := blk.UnmarshalWithLimits(resp.Block, svc.limits); err != nil {
+		return nil, nil, err
+	}
+	qc := core.NewQuorumCert()
+	if err := qc.UnmarshalWithLimits(resp.Qc, svc.limits); err != nil {
+		return nil, nil, err
+	}
+	return blk, qc, nil
+}
+
+// RequestSilentViews requests the views since the peer last saw a signature of the validator
+func (svc *MsgService) RequestSilentViews(pubKey *core.PublicKey, validator *core.PublicKey) (uint64, error) {
+	respData, err := svc.requestData(pubKey, p2p_pb.Request_SilentViews, validator.Bytes())
+	if err != nil {
+		return 0, err
+	}
+	if len(respData) != 8 {
+		return 0, fmt.Errorf("invalid silent views response size %d", len(respData))
+	}
+	return binary.BigEndian.Uint64(respData), nil
+}
+
+// RequestBlocksByRange requests the commited blocks from height to height (inclusive).
+// The peer responds a limited number of blocks at once, they are requested page by page.
+// It returns the blocks in order of height up to the last block of the peer.
+func (svc *MsgService) RequestBlocksByRange(
+	pubKey *core.PublicKey, from, to uint64,
+) ([]*core.Block, error) {
+	blocks := make([]*core.Block, 0)
+	for from <= to {
+		page, err := svc.requestBlocksByRange(pubKey, from, to)
+		if err != nil {
+			return nil, err
+		}
+		if len(page) == 0 {
+			break // no more blocks
+		}
+		for _, blk := range page {
+			if blk.Height() != from {
+				return nil, fmt.Errorf("unexpected block height %d, expected %d", blk.Height(), from)
+			}
+			if len(blocks) > 0 && !bytes.Equal(blk.ParentHash(), blocks[len(blocks)-1].Hash()) {
+				return nil, fmt.Errorf("block %d doesn't connect to parent", blk.Height())
+			}
+			blocks = append(blocks, blk)
+			from++
+		}
+		if from == 0 {
+			break // overflow after the max height
+		}
+	}
+	return blocks, nil
+}
+
+func (svc *MsgService) requestBlocksByRange(
+	pubKey *core.PublicKey, from, to uint64,
+) ([]*core.Block, error) {
+	reqData, _ := proto.Marshal(&p2p_pb.HeightRange{From: from, To: to})
+	respData, err := svc.requestData(pubKey, p2p_pb.Request_BlocksByRange, reqData)
+	if err != nil {
+		return nil, err
+	}
+	resp := new(p2p_pb.BlockList)
+	if err := proto.Unmarshal(respData, resp); err != nil {
+		return nil, err
+	}
+	if len(resp.List) > 0 && uint64(len(resp.List)-1) > to-from {
+		return nil, fmt.Errorf("too many blocks %d", len(resp.List))
+	}
+	blocks := make([]*core.Block, len(resp.List))
+	for i, b := range resp.List {
+		blocks[i] = core.NewBlock()
+		if err := blocks[i].UnmarshalWithLimits(b, svc.limits); err != nil {
+			return nil, err
+		}
+	}
+	return blocks, nil
+}
+
 func (svc *MsgService) RequestTxList(pubKey *core.PublicKey, hashes [][]byte) (*core.TxList, error) {
 	hl := new(p2p_pb.HashList)
 	hl.List = hashes
@@ -151,7 +324,7 @@ func (svc *MsgService) RequestTxList(pubKey *core.PublicKey, hashes [][]byte) (*
 		return nil, err
 	}
 	txList := core.NewTxList()
-	if err := txList.Unmarshal(respData); err != nil {
+	if err := txList.UnmarshalWithLimits(respData, svc.limits); err != nil {
 		return nil, err
 	}
 	return txList, nil
@@ -181,22 +354,80 @@ func (svc *MsgService) setMsgReceivers() {
 	svc.receivers[MsgTypeRequest] = svc.onReceiveRequest
 }
 
+// listenPeersAdded listens the peers added after the service is created, e.g. discovered peers
+func (svc *MsgService) listenPeersAdded(sub *emitter.Subscription, listening map[*Peer]struct{}) {
+	for e := range sub.Events() {
+		peer := e.(*Peer)
+		if _, ok := listening[peer]; ok {
+			continue // added while the existing peers are listed
+		}
+		go svc.listenPeer(peer)
+	}
+}
+
 func (svc *MsgService) listenPeer(peer *Peer) {
 	sub := peer.SubscribeMsg()
 	for e := range sub.Events() {
 		msg := e.([]byte)
 		if len(msg) < 2 {
-			continue // invalid message
+			svc.penalize(peer, PenaltyMalformedMsg)
+			continue
+		}
+		if MsgType(msg[0]) == MsgTypeResponse {
+			continue // read by the request
+		}
+		if svc.isSeen(MsgType(msg[0]), msg[1:]) {
+			continue
 		}
 		if receiver, found := svc.receivers[MsgType(msg[0])]; found {
 			receiver(peer, msg[1:])
+		} else {
+			svc.penalize(peer, PenaltyMalformedMsg)
 		}
 	}
 }
 
+func (svc *MsgService) penalize(peer *Peer, penalty float64) {
+	svc.host.PeerStore().Penalize(peer.PublicKey(), penalty)
+}
+
+// checkValid returns false if the validation error proves the message is forged or corrupted,
+// the peer is penalized. Errors which an outdated validator set can cause, e.g. unknown validator,
+// are left to the consensus.
+func (svc *MsgService) checkValid(peer *Peer, err error, aggregateQC bool) bool {
+	if err == nil {
+		return true
+	}
+	switch {
+	case errors.Is(err, core.ErrInvalidBlockHash),
+		errors.Is(err, core.ErrInvalidQCHeight),
+		errors.Is(err, core.ErrDuplicateSig),
+		// signers of an aggregate qc are resolved with the validator set
+		errors.Is(err, core.ErrInvalidSig) && !aggregateQC:
+		svc.penalize(peer, PenaltyInvalidMsg)
+		return false
+	}
+	return true
+}
+
+func isAggregateQC(qc *core.QuorumCert) bool {
+	return qc != nil && qc.IsAggregate()
+}
+
 func (svc *MsgService) onReceiveProposal(peer *Peer, data []byte) {
 	blk := core.NewBlock()
-	if err := blk.Unmarshal(data); err != nil {
+	if err := blk.UnmarshalWithLimits(data, svc.limits); err != nil {
+		svc.penalize(peer, PenaltyMalformedMsg)
+		return
+	}
+	if vs := svc.getValidatorStore(); vs != nil {
+		if !svc.checkValid(peer, blk.Validate(vs), isAggregateQC(blk.QuorumCert())) {
+			return
+		}
+	}
+	// the genesis proposal is rebroadcast until the genesis qc is formed,
+	// so that the votes lost before the validators are connected are sent again
+	if !blk.IsGenesis() && !svc.markSeen(MsgTypeProposal, data) {
 		return
 	}
 	svc.proposalEmitter.Emit(blk)
@@ -204,23 +435,39 @@ func (svc *MsgService) onReceiveProposal(peer *Peer, data []byte) {
 
 func (svc *MsgService) onReceiveVote(peer *Peer, data []byte) {
 	vote := core.NewVote()
-	if err := vote.Unmarshal(data); err != nil {
+	if err := vote.UnmarshalWithLimits(data, svc.limits); err != nil {
+		svc.penalize(peer, PenaltyMalformedMsg)
 		return
 	}
+	if vs := svc.getValidatorStore(); vs != nil {
+		if !svc.checkValid(peer, vote.Validate(vs), false) {
+			return
+		}
+	}
 	svc.voteEmitter.Emit(vote)
 }
 
 func (svc *MsgService) onReceiveNewView(peer *Peer, data []byte) {
 	qc := core.NewQuorumCert()
-	if err := qc.Unmarshal(data); err != nil {
+	if err := qc.UnmarshalWithLimits(data, svc.limits); err != nil {
+		svc.penalize(peer, PenaltyMalformedMsg)
 		return
 	}
+	if vs := svc.getValidatorStore(); vs != nil {
+		if !svc.checkValid(peer, qc.Validate(vs), qc.IsAggregate()) {
+			return
+		}
+	}
 	svc.newViewEmitter.Emit(qc)
 }
 
 func (svc *MsgService) onReceiveTxList(peer *Peer, data []byte) {
 	txList := core.NewTxList()
-	if err := txList.Unmarshal(data); err != nil {
+	if err := txList.UnmarshalWithLimits(data, svc.limits); err != nil {
+		svc.penalize(peer, PenaltyMalformedMsg)
+		return
+	}
+	if !svc.markSeen(MsgTypeTxList, data) {
 		return
 	}
 	svc.txListEmitter.Emit(txList)
@@ -229,6 +476,7 @@ func (svc *MsgService) onReceiveTxList(peer *Peer, data []byte) {
 func (svc *MsgService) onReceiveRequest(peer *Peer, data []byte) {
 	req := new(p2p_pb.Request)
 	if err := proto.Unmarshal(data, req); err != nil {
+		svc.penalize(peer, PenaltyMalformedMsg)
 		return
 	}
 	resp := new(p2p_pb.Response)
@@ -249,6 +497,7 @@ func (svc *MsgService) onReceiveRequest(peer *Peer, data []byte) {
 }
 
 func (svc *MsgService) broadcastData(msgType MsgType, data []byte) error {
+	svc.markSeen(msgType, data)
 	for _, peer := range svc.host.PeerStore().List() {
 		peer.WriteMsg(append([]byte{byte(msgType)}, data...))
 	}
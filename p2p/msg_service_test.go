@@ -38,12 +38,12 @@ func setupMsgServiceWithLoopBackPeers() (*MsgService, [][]byte, []*Peer) {
 	host := new(Host)
 	host.peerStore = NewPeerStore()
 
-	peers[0].onConnected(newRWCLoopBack())
-	peers[1].onConnected(newRWCLoopBack())
+	peers[0].onConnected(newRWCLoopBack(), false)
+	peers[1].onConnected(newRWCLoopBack(), false)
 	host.peerStore.Store(peers[0])
 	host.peerStore.Store(peers[1])
 
-	svc := NewMsgService(host)
+	svc := NewMsgService(host, core.UnmarshalLimits{})
 	time.Sleep(time.Millisecond)
 	return svc, raws, peers
 }
@@ -210,6 +210,99 @@ func TestMsgService_RequestBlock(t *testing.T) {
 
 	_, err = svc.RequestBlock(peers[0].PublicKey(), []byte{1})
 	assert.Error(err)
+
+	// a peer responding with other block
+	other := core.NewBlock().SetHeight(11).SetQuorumCert(qc).Sign(core.GenerateKey(nil))
+	blkReqHandler.GetBlock = func(hash []byte) (*core.Block, error) {
+		return other, nil
+	}
+	_, err = svc.RequestBlock(peers[0].PublicKey(), blk.Hash())
+	assert.Error(err)
+}
+
+func TestMsgService_RequestBlockWithQCByHeight(t *testing.T) {
+	assert := assert.New(t)
+
+	priv := core.GenerateKey(nil)
+	parentQC := core.NewQuorumCert().Build(
+		[]*core.Vote{core.NewBlock().SetHeight(9).Vote(priv)})
+	blk := core.NewBlock().SetHeight(10).SetQuorumCert(parentQC).Sign(priv)
+	qc := core.NewQuorumCert().Build([]*core.Vote{blk.ProposerVote()})
+
+	svc, _, peers := setupMsgServiceWithLoopBackPeers()
+	svc.SetReqHandler(&BlockWithQCByHeightReqHandler{
+		GetBlockByHeight: func(height uint64) (*core.Block, error) {
+			if height == blk.Height() {
+				return blk, nil
+			}
+			return nil, errors.New("block not found")
+		},
+		GetQC: func(blockHash []byte) (*core.QuorumCert, error) {
+			return qc, nil
+		},
+	})
+
+	recvBlk, recvQC, err := svc.RequestBlockWithQCByHeight(peers[0].PublicKey(), 10)
+	if assert.NoError(err) {
+		assert.Equal(blk.Hash(), recvBlk.Hash())
+		assert.Equal(blk.Hash(), recvQC.BlockHash())
+		assert.Len(recvQC.Signatures(), 1)
+	}
+
+	_, _, err = svc.RequestBlockWithQCByHeight(peers[0].PublicKey(), 11)
+	assert.Error(err)
+}
+
+func TestMsgService_RequestBlocksByRange(t *testing.T) {
+	assert := assert.New(t)
+
+	priv := core.GenerateKey(nil)
+	chain := make([]*core.Block, 15)
+	chain[0] = core.NewBlock().SetHeight(0).Sign(priv)
+	for i := 1; i < len(chain); i++ {
+		qc := core.NewQuorumCert().Build([]*core.Vote{chain[i-1].ProposerVote()})
+		chain[i] = core.NewBlock().SetHeight(uint64(i)).SetParentHash(chain[i-1].Hash()).
+			SetQuorumCert(qc).Sign(priv)
+	}
+	hdlr := &BlocksByRangeReqHandler{
+		GetBlocksByRange: func(from, to uint64) ([]*core.Block, error) {
+			if to >= uint64(len(chain)) {
+				to = uint64(len(chain)) - 1
+			}
+			if from > to {
+				return nil, nil
+			}
+			return chain[from : to+1], nil
+		},
+		MaxBlocks: 2,
+	}
+	svc, _, peers := setupMsgServiceWithLoopBackPeers()
+	svc.SetReqHandler(hdlr)
+
+	// a gap of 5 blocks, 3 pages
+	blocks, err := svc.RequestBlocksByRange(peers[0].PublicKey(), 10, 14)
+	assert.NoError(err)
+	if assert.Len(blocks, 5) {
+		for i, blk := range blocks {
+			assert.EqualValues(10+i, blk.Height())
+			assert.Equal(chain[10+i].Hash(), blk.Hash())
+		}
+	}
+
+	blocks, err = svc.RequestBlocksByRange(peers[0].PublicKey(), 13, 20)
+	assert.NoError(err)
+	assert.Len(blocks, 2, "up to the last block")
+
+	blocks, err = svc.RequestBlocksByRange(peers[0].PublicKey(), 20, 30)
+	assert.NoError(err)
+	assert.Empty(blocks)
+
+	// a peer responding a broken chain
+	hdlr.GetBlocksByRange = func(from, to uint64) ([]*core.Block, error) {
+		return []*core.Block{chain[from], chain[from+2]}, nil
+	}
+	_, err = svc.RequestBlocksByRange(peers[0].PublicKey(), 1, 5)
+	assert.Error(err)
 }
 
 func TestMsgService_RequestTxList(t *testing.T) {
@@ -234,3 +327,32 @@ func TestMsgService_RequestTxList(t *testing.T) {
 		assert.Equal((*txs)[1].Nonce(), (*recvTxs)[1].Nonce())
 	}
 }
+
+func TestMsgService_ReceiveWithLimits(t *testing.T) {
+	assert := assert.New(t)
+
+	host := &Host{peerStore: NewPeerStore()}
+	svc := &MsgService{host: host, limits: core.UnmarshalLimits{MaxTxs: 2}}
+	svc.setEmitters()
+	sub := svc.SubscribeProposal(5)
+	peer := NewPeer(core.GenerateKey(nil).PublicKey(), nil)
+
+	newBlock := func(txCount int) []byte {
+		b, _ := core.NewBlock().
+			SetTransactions(make([][]byte, txCount)).
+			Sign(core.GenerateKey(nil)).
+			Marshal()
+		return b
+	}
+	svc.onReceiveProposal(peer, newBlock(3))
+	svc.onReceiveProposal(peer, newBlock(2))
+
+	select {
+	case e := <-sub.Events():
+		assert.Len(e.(*core.Block).Transactions(), 2)
+	case <-time.After(time.Second):
+		assert.Fail("proposal at limit not received")
+	}
+	assert.Empty(sub.Events(), "proposal over limit must be dropped")
+	assert.EqualValues(1, host.peerStore.Scores()[0].Infractions)
+}
@@ -9,11 +9,13 @@ import (
 	"io"
 	"math/rand"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/aungmawjj/juria-blockchain/core"
 	"github.com/aungmawjj/juria-blockchain/emitter"
 	"github.com/aungmawjj/juria-blockchain/logger"
+	"github.com/golang/snappy"
 	"github.com/multiformats/go-multiaddr"
 )
 
@@ -29,7 +31,7 @@ const (
 )
 
 const (
-	// message size limit in bytes (~100 MB)
+	// message size limit in bytes (~100 MB) if the host doesn't set it,
 	// to avoid out of memory allocation for reading next message
 	MessageSizeLimit uint32 = 100000000
 )
@@ -40,8 +42,9 @@ type Peer struct {
 	addr   multiaddr.Multiaddr
 	status PeerStatus
 
-	rwc     io.ReadWriteCloser
-	emitter *emitter.Emitter
+	rwc      io.ReadWriteCloser
+	compress bool // messages are compressed with snappy
+	emitter  *emitter.Emitter
 
 	mtxRWC    sync.RWMutex
 	mtxStatus sync.RWMutex
@@ -50,6 +53,11 @@ type Peer struct {
 	reconnectInterval time.Duration
 	mtxRecon          sync.RWMutex
 
+	limiter    *rateLimiter // nil if disabled
+	dropped    uint64
+	maxMsgSize uint32 // MessageSizeLimit if zero
+	mtxLimit   sync.RWMutex
+
 	host *Host
 }
 
@@ -90,12 +98,43 @@ func (p *Peer) disconnect() {
 	if p.status == PeerStatusConnected {
 		logger.I().Infow("peer disconnected", "addr", p.addr)
 	}
+	p.closeRWC()
+	if p.status == PeerStatusBlocked {
+		return // reconnects when unblocked
+	}
 	p.status = PeerStatusDisconnected
+	p.reconnectAfterInterval()
+}
+
+func (p *Peer) closeRWC() {
 	rwc := p.getRWC()
 	if rwc != nil {
 		rwc.Close()
 	}
-	p.reconnectAfterInterval()
+}
+
+// block disconnects the peer and refuses the connections for the duration
+func (p *Peer) block(d time.Duration) {
+	p.mtxStatus.Lock()
+	defer p.mtxStatus.Unlock()
+
+	p.status = PeerStatusBlocked
+	p.closeRWC()
+	time.AfterFunc(d, p.unblock)
+}
+
+func (p *Peer) unblock() {
+	p.mtxStatus.Lock()
+	defer p.mtxStatus.Unlock()
+
+	if p.status != PeerStatusBlocked {
+		return
+	}
+	logger.I().Infow("peer unblocked", "addr", p.addr)
+	p.status = PeerStatusDisconnected
+	if p.host != nil {
+		go p.host.connectPeer(p)
+	}
 }
 
 func (p *Peer) reconnectAfterInterval() {
@@ -119,13 +158,13 @@ func (p *Peer) setConnecting() error {
 	return nil
 }
 
-func (p *Peer) onConnected(rwc io.ReadWriteCloser) {
+func (p *Peer) onConnected(rwc io.ReadWriteCloser, compress bool) {
 	p.mtxStatus.Lock()
 	defer p.mtxStatus.Unlock()
 
-	logger.I().Infow("peer connected", "addr", p.addr)
+	logger.I().Infow("peer connected", "addr", p.addr, "compress", compress)
 	p.status = PeerStatusConnected
-	p.setRWC(rwc)
+	p.setRWC(rwc, compress)
 	p.resetReconnectInterval()
 	go p.listen()
 }
@@ -137,6 +176,10 @@ func (p *Peer) listen() {
 		if err != nil {
 			return
 		}
+		if !p.allowMsg(len(msg)) {
+			atomic.AddUint64(&p.dropped, 1)
+			continue
+		}
 		p.emitter.Emit(msg)
 	}
 }
@@ -146,11 +189,27 @@ func (p *Peer) read() ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+	maxSize := p.getMaxMsgSize()
+	wireLimit := uint64(maxSize)
+	if p.isCompressed() {
+		wireLimit = uint64(snappy.MaxEncodedLen(int(maxSize)))
+	}
 	size := binary.BigEndian.Uint32(b)
-	if size > MessageSizeLimit {
+	if uint64(size) > wireLimit {
 		return nil, fmt.Errorf("big message size %d", size)
 	}
-	return p.readFixedSize(size)
+	b, err = p.readFixedSize(size)
+	if err != nil || !p.isCompressed() {
+		return b, err
+	}
+	n, err := snappy.DecodedLen(b)
+	if err != nil {
+		return nil, err
+	}
+	if n > int(maxSize) {
+		return nil, fmt.Errorf("big decompressed message size %d", n)
+	}
+	return snappy.Decode(nil, b)
 }
 
 func (p *Peer) readFixedSize(size uint32) ([]byte, error) {
@@ -171,6 +230,9 @@ func (p *Peer) WriteMsg(msg []byte) error {
 }
 
 func (p *Peer) write(b []byte) error {
+	if p.isCompressed() {
+		b = snappy.Encode(nil, b)
+	}
 	payload := make([]byte, 4, 4+len(b))
 	binary.BigEndian.PutUint32(payload, uint32(len(b)))
 	payload = append(payload, b...)
@@ -184,10 +246,11 @@ func (p *Peer) SubscribeMsg() *emitter.Subscription {
 	return p.emitter.Subscribe(10)
 }
 
-func (p *Peer) setRWC(rwc io.ReadWriteCloser) {
+func (p *Peer) setRWC(rwc io.ReadWriteCloser, compress bool) {
 	p.mtxRWC.Lock()
 	defer p.mtxRWC.Unlock()
 	p.rwc = rwc
+	p.compress = compress
 }
 
 func (p *Peer) getRWC() io.ReadWriteCloser {
@@ -196,6 +259,53 @@ func (p *Peer) getRWC() io.ReadWriteCloser {
 	return p.rwc
 }
 
+func (p *Peer) isCompressed() bool {
+	p.mtxRWC.RLock()
+	defer p.mtxRWC.RUnlock()
+	return p.compress
+}
+
+// setRateLimit limits the inbound messages, the tokens are refilled
+func (p *Peer) setRateLimit(limit RateLimit) {
+	p.mtxLimit.Lock()
+	defer p.mtxLimit.Unlock()
+
+	if limit.Enabled() {
+		p.limiter = newRateLimiter(limit)
+	} else {
+		p.limiter = nil
+	}
+}
+
+// setMaxMsgSize limits the size of inbound messages, checked before they are read
+func (p *Peer) setMaxMsgSize(size uint32) {
+	p.mtxLimit.Lock()
+	defer p.mtxLimit.Unlock()
+	p.maxMsgSize = size
+}
+
+func (p *Peer) getMaxMsgSize() uint32 {
+	p.mtxLimit.RLock()
+	defer p.mtxLimit.RUnlock()
+
+	if p.maxMsgSize == 0 {
+		return MessageSizeLimit
+	}
+	return p.maxMsgSize
+}
+
+func (p *Peer) allowMsg(size int) bool {
+	p.mtxLimit.RLock()
+	defer p.mtxLimit.RUnlock()
+
+	return p.limiter == nil || p.limiter.allow(size)
+}
+
+// DroppedMsgs returns the number of inbound messages dropped by the rate limit
+func (p *Peer) DroppedMsgs() uint64 {
+	return atomic.LoadUint64(&p.dropped)
+}
+
 func (p *Peer) resetReconnectInterval() {
 	p.mtxRecon.Lock()
 	defer p.mtxRecon.Unlock()
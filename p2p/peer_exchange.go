@@ -0,0 +1,368 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package p2p
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/aungmawjj/juria-blockchain/core"
+	"github.com/aungmawjj/juria-blockchain/logger"
+	"github.com/aungmawjj/juria-blockchain/p2p/p2p_pb"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/peerstore"
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"google.golang.org/protobuf/proto"
+)
+
+// errors
+var (
+	ErrPeerExchangeDisabled = errors.New("peer exchange disabled")
+	ErrInvalidSeedAddr      = errors.New("seed address must end with /p2p/<peer id>")
+)
+
+const (
+	peerExchangeProtocolID = "/single_pid/peers"
+
+	// known peers sent in an exchange at most
+	maxExchangePeers = 200
+
+	// peers in the store at most, discovered peers are not added beyond it
+	maxDiscoveredPeers = 1000
+
+	// size limit of an exchange message in bytes
+	peerExchangeSizeLimit = 1 << 20
+
+	peerExchangeTimeout = 10 * time.Second
+)
+
+/*
+Peer exchange lets a node join by knowing a seed instead of all the peers.
+
+Bootstrap dials each seed on the peer exchange protocol and both sides send
+a PeerExchange with the sender info and its known peers. The seed adds the dialer to its store,
+and the dialer adds the seed and exchanges with each peer learned, so that they add it too.
+Only the hosts with peer exchange enabled answer, the sender key must be the key
+authenticated by the connection. Each peer added is dialed, so only the allowed peers
+are exchanged with and added, up to maxDiscoveredPeers in the store. The seeds are trusted.
+*/
+
+// SetPeerExchange enables answering peer exchanges and adding the peers discovered to the store.
+// It applies to the exchanges started after it's set.
+func (host *Host) SetPeerExchange(enabled bool) *Host {
+	host.mtxConfig.Lock()
+	defer host.mtxConfig.Unlock()
+
+	host.peerExchange = enabled
+	if enabled {
+		host.libHost.SetStreamHandler(peerExchangeProtocolID, host.handlePeerExchange)
+	} else {
+		host.libHost.RemoveStreamHandler(peerExchangeProtocolID)
+	}
+	return host
+}
+
+func (host *Host) isPeerExchangeEnabled() bool {
+	host.mtxConfig.RLock()
+	defer host.mtxConfig.RUnlock()
+	return host.peerExchange
+}
+
+// SetAllowedPeers limits the peers exchanged with and discovered to the keys allowed by fn,
+// e.g. the validators. Any peer is allowed if fn is nil.
+func (host *Host) SetAllowedPeers(fn func(pubKey *core.PublicKey) bool) *Host {
+	host.mtxConfig.Lock()
+	defer host.mtxConfig.Unlock()
+	host.allowPeer = fn
+	return host
+}
+
+func (host *Host) isAllowedPeer(pubKey *core.PublicKey) bool {
+	host.mtxConfig.RLock()
+	allow := host.allowPeer
+	host.mtxConfig.RUnlock()
+	return allow == nil || allow(pubKey)
+}
+
+// SeedAddr returns the local address with the peer id, which other hosts bootstrap from
+func (host *Host) SeedAddr() (multiaddr.Multiaddr, error) {
+	id, err := getIDFromPublicKey(host.privKey.PublicKey())
+	if err != nil {
+		return nil, err
+	}
+	p2pAddr, err := multiaddr.NewMultiaddr("/p2p/" + id.Pretty())
+	if err != nil {
+		return nil, err
+	}
+	return host.localAddr.Encapsulate(p2pAddr), nil
+}
+
+// Bootstrap exchanges peers with the seeds and the peers learned from them, and adds the peers to the store.
+// The seed addresses end with the peer id, see SeedAddr. It fails only if no seed answers.
+func (host *Host) Bootstrap(seeds []multiaddr.Multiaddr) error {
+	if !host.isPeerExchangeEnabled() {
+		return ErrPeerExchangeDisabled
+	}
+	exchanged := make(map[string]struct{})
+	pending := make([]*Peer, 0)
+	var lastErr error
+	for _, addr := range seeds {
+		seed, err := seedPeer(addr)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		peers, err := host.exchangePeers(seed)
+		if err != nil {
+			logger.I().Warnw("peer exchange with seed failed", "addr", addr, "error", err)
+			lastErr = err
+			continue
+		}
+		exchanged[seed.PublicKey().String()] = struct{}{}
+		host.addPeerIfNew(seed)
+		pending = append(pending, peers...)
+	}
+	if len(exchanged) == 0 && lastErr != nil {
+		return fmt.Errorf("bootstrap failed, %w", lastErr)
+	}
+	// the peers learned from seeds learn this host in turn
+	for len(pending) > 0 && len(exchanged) < maxDiscoveredPeers {
+		p := pending[0]
+		pending = pending[1:]
+		if _, ok := exchanged[p.PublicKey().String()]; ok || p.PublicKey().Equal(host.privKey.PublicKey()) {
+			continue
+		}
+		exchanged[p.PublicKey().String()] = struct{}{}
+		peers, err := host.exchangePeers(p)
+		if err != nil {
+			logger.I().Warnw("peer exchange failed", "addr", p.Addr(), "error", err)
+			continue
+		}
+		host.addDiscoveredPeer(p)
+		pending = append(pending, peers...)
+	}
+	return nil
+}
+
+// seedPeer splits the peer id from the seed address
+func seedPeer(addr multiaddr.Multiaddr) (*Peer, error) {
+	transport, id := peer.SplitAddr(addr)
+	if transport == nil || id == "" {
+		return nil, fmt.Errorf("%w, %s", ErrInvalidSeedAddr, addr)
+	}
+	key, err := id.ExtractPublicKey()
+	if err != nil {
+		return nil, fmt.Errorf("%w, %s", ErrInvalidSeedAddr, err)
+	}
+	b, err := key.Raw()
+	if err != nil {
+		return nil, err
+	}
+	pubKey, err := core.NewPublicKey(b)
+	if err != nil {
+		return nil, err
+	}
+	return NewPeer(pubKey, transport), nil
+}
+
+// exchangePeers sends the known peers to the remote and returns the peers it knows
+func (host *Host) exchangePeers(remote *Peer) ([]*Peer, error) {
+	id, err := getIDFromPublicKey(remote.PublicKey())
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), peerExchangeTimeout)
+	defer cancel()
+	host.libHost.Peerstore().AddAddr(id, remote.Addr(), peerstore.TempAddrTTL)
+	s, err := host.libHost.NewStream(ctx, id, peerExchangeProtocolID)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+	s.SetDeadline(time.Now().Add(peerExchangeTimeout))
+
+	if err := checkRemotePublicKey(s, remote.PublicKey()); err != nil {
+		s.Reset()
+		return nil, err
+	}
+	if err := writePeerExchange(s, host.newPeerExchange(remote.PublicKey())); err != nil {
+		return nil, err
+	}
+	msg, err := readPeerExchange(s)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := host.checkExchangeSender(s, msg); err != nil {
+		return nil, err
+	}
+	return host.peersFromExchange(msg), nil
+}
+
+// handlePeerExchange answers the exchange and adds the remote to the store
+func (host *Host) handlePeerExchange(s network.Stream) {
+	defer s.Close()
+	s.SetDeadline(time.Now().Add(peerExchangeTimeout))
+
+	msg, err := readPeerExchange(s)
+	if err != nil {
+		s.Reset()
+		return
+	}
+	sender, err := host.checkExchangeSender(s, msg)
+	if err != nil {
+		logger.I().Warnw("invalid peer exchange", "error", err)
+		s.Reset()
+		return
+	}
+	if host.peerStore.IsBanned(sender.PublicKey()) || !host.isAllowedPeer(sender.PublicKey()) {
+		s.Reset()
+		return
+	}
+	if err := writePeerExchange(s, host.newPeerExchange(sender.PublicKey())); err != nil {
+		return
+	}
+	host.addDiscoveredPeer(sender)
+}
+
+// checkExchangeSender returns the sender peer, its key must be the authenticated remote key.
+// The sender address with an unspecified ip is resolved to the ip the connection comes from.
+func (host *Host) checkExchangeSender(s network.Stream, msg *p2p_pb.PeerExchange) (*Peer, error) {
+	sender, err := peerFromInfo(msg.Sender)
+	if err != nil {
+		return nil, fmt.Errorf("sender, %w", err)
+	}
+	if err := checkRemotePublicKey(s, sender.PublicKey()); err != nil {
+		return nil, err
+	}
+	sender.addr = resolveUnspecifiedIP(sender.addr, s.Conn().RemoteMultiaddr())
+	return sender, nil
+}
+
+func (host *Host) newPeerExchange(remote *core.PublicKey) *p2p_pb.PeerExchange {
+	msg := &p2p_pb.PeerExchange{
+		Sender: &p2p_pb.PeerInfo{
+			PublicKey: host.privKey.PublicKey().Bytes(),
+			Addr:      host.localAddr.Bytes(),
+		},
+	}
+	for _, p := range host.peerStore.List() {
+		if len(msg.Peers) == maxExchangePeers {
+			break
+		}
+		if p.PublicKey().Equal(remote) || host.peerStore.IsBanned(p.PublicKey()) {
+			continue
+		}
+		msg.Peers = append(msg.Peers, &p2p_pb.PeerInfo{
+			PublicKey: p.PublicKey().Bytes(),
+			Addr:      p.Addr().Bytes(),
+		})
+	}
+	return msg
+}
+
+// peersFromExchange skips the invalid peer infos and the peers not allowed
+func (host *Host) peersFromExchange(msg *p2p_pb.PeerExchange) []*Peer {
+	peers := make([]*Peer, 0, len(msg.Peers))
+	for i, info := range msg.Peers {
+		if i == maxExchangePeers {
+			break
+		}
+		p, err := peerFromInfo(info)
+		if err != nil || !host.isAllowedPeer(p.PublicKey()) {
+			continue
+		}
+		peers = append(peers, p)
+	}
+	return peers
+}
+
+// addDiscoveredPeer adds the allowed peer while the store is not full
+func (host *Host) addDiscoveredPeer(p *Peer) {
+	if !host.isAllowedPeer(p.PublicKey()) {
+		return
+	}
+	if len(host.peerStore.List()) >= maxDiscoveredPeers {
+		logger.I().Warnw("peer store is full, discovered peer skipped", "addr", p.Addr())
+		return
+	}
+	host.addPeerIfNew(p)
+}
+
+// addPeerIfNew adds the peer unless it is the local host, known or banned
+func (host *Host) addPeerIfNew(p *Peer) {
+	if p.PublicKey().Equal(host.privKey.PublicKey()) || host.peerStore.IsBanned(p.PublicKey()) {
+		return
+	}
+	if host.peerStore.Load(p.PublicKey()) != nil {
+		return
+	}
+	logger.I().Infow("discovered peer", "addr", p.Addr())
+	host.AddPeer(p)
+}
+
+func peerFromInfo(info *p2p_pb.PeerInfo) (*Peer, error) {
+	if info == nil {
+		return nil, errors.New("missing peer info")
+	}
+	pubKey, err := core.NewPublicKey(info.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	addr, err := multiaddr.NewMultiaddrBytes(info.Addr)
+	if err != nil {
+		return nil, err
+	}
+	return NewPeer(pubKey, addr), nil
+}
+
+// resolveUnspecifiedIP replaces the unspecified ip of addr, e.g. 0.0.0.0, with the ip of observed
+func resolveUnspecifiedIP(addr, observed multiaddr.Multiaddr) multiaddr.Multiaddr {
+	if !manet.IsIPUnspecified(addr) || observed == nil {
+		return addr
+	}
+	ip, _ := multiaddr.SplitFirst(observed)
+	_, rest := multiaddr.SplitFirst(addr)
+	if ip == nil || rest == nil {
+		return addr
+	}
+	return ip.Encapsulate(rest)
+}
+
+func writePeerExchange(w io.Writer, msg *p2p_pb.PeerExchange) error {
+	b, err := proto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	payload := make([]byte, 4, 4+len(b))
+	binary.BigEndian.PutUint32(payload, uint32(len(b)))
+	payload = append(payload, b...)
+	_, err = w.Write(payload)
+	return err
+}
+
+func readPeerExchange(r io.Reader) (*p2p_pb.PeerExchange, error) {
+	b := make([]byte, 4)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(b)
+	if size > peerExchangeSizeLimit {
+		return nil, fmt.Errorf("big peer exchange size %d", size)
+	}
+	b = make([]byte, size)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	msg := new(p2p_pb.PeerExchange)
+	if err := proto.Unmarshal(b, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
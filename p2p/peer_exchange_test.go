@@ -0,0 +1,157 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package p2p
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aungmawjj/juria-blockchain/core"
+	"github.com/aungmawjj/juria-blockchain/p2p/p2p_pb"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHost_Bootstrap(t *testing.T) {
+	assert := assert.New(t)
+
+	privs := make([]*core.PrivateKey, 3)
+	hosts := make([]*Host, 3)
+	for i := range hosts {
+		privs[i] = core.GenerateKey(nil)
+		addr, _ := multiaddr.NewMultiaddr(
+			[]string{"/ip4/127.0.0.1/tcp/25041", "/ip4/127.0.0.1/tcp/25042", "/ip4/127.0.0.1/tcp/25043"}[i])
+		host, err := NewHost(privs[i], addr)
+		if !assert.NoError(err) {
+			return
+		}
+		hosts[i] = host.SetPeerExchange(true)
+	}
+	seed, member, joiner := hosts[0], hosts[1], hosts[2]
+
+	// the seed and the member are wired explicitly
+	seed.AddPeer(NewPeer(privs[1].PublicKey(), member.localAddr))
+	member.AddPeer(NewPeer(privs[0].PublicKey(), seed.localAddr))
+	assert.Eventually(func() bool { return allConnected(hosts[:2], privs[:2]) }, 5*time.Second, 20*time.Millisecond)
+	added := member.PeerStore().SubscribeAdded()
+
+	seedAddr, err := seed.SeedAddr()
+	assert.NoError(err)
+	assert.NoError(joiner.Bootstrap([]multiaddr.Multiaddr{seedAddr}))
+
+	// simultaneous dials of both sides are refused once and reconnected
+	if !assert.Eventually(func() bool { return allConnected(hosts, privs) }, 5*time.Second, 20*time.Millisecond) {
+		return
+	}
+	for i, host := range hosts {
+		assert.Len(host.PeerStore().List(), 2, "host %d", i)
+	}
+	select {
+	case e := <-added.Events():
+		assert.True(e.(*Peer).PublicKey().Equal(privs[2].PublicKey()), "joiner added to member")
+	default:
+		assert.Fail("peer added not emitted")
+	}
+
+	// messages flow on the discovered connections
+	recv := joiner.PeerStore().Load(privs[1].PublicKey()).SubscribeMsg()
+	msg := []byte("hello")
+	assert.NoError(member.PeerStore().Load(privs[2].PublicKey()).WriteMsg(msg))
+	select {
+	case e := <-recv.Events():
+		assert.Equal(msg, e.([]byte))
+	case <-time.After(time.Second):
+		assert.Fail("message not received")
+	}
+}
+
+// allConnected checks whether each host is connected to the others
+func allConnected(hosts []*Host, privs []*core.PrivateKey) bool {
+	for i, host := range hosts {
+		for j := range hosts {
+			if i == j {
+				continue
+			}
+			p := host.PeerStore().Load(privs[j].PublicKey())
+			if p == nil || p.Status() != PeerStatusConnected {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func TestHost_BootstrapErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	priv := core.GenerateKey(nil)
+	addr, _ := multiaddr.NewMultiaddr("/ip4/127.0.0.1/tcp/25044")
+	host, err := NewHost(priv, addr)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.ErrorIs(host.Bootstrap(nil), ErrPeerExchangeDisabled)
+
+	host.SetPeerExchange(true)
+	assert.ErrorIs(host.Bootstrap([]multiaddr.Multiaddr{addr}), ErrInvalidSeedAddr, "missing peer id")
+
+	// the seed doesn't answer exchanges
+	seedPriv := core.GenerateKey(nil)
+	seedAddr, _ := multiaddr.NewMultiaddr("/ip4/127.0.0.1/tcp/25045")
+	seed, err := NewHost(seedPriv, seedAddr)
+	if !assert.NoError(err) {
+		return
+	}
+	p2pAddr, err := seed.SeedAddr()
+	assert.NoError(err)
+	assert.Error(host.Bootstrap([]multiaddr.Multiaddr{p2pAddr}))
+	assert.Empty(seed.PeerStore().List())
+	assert.Empty(host.PeerStore().List())
+}
+
+func TestResolveUnspecifiedIP(t *testing.T) {
+	assert := assert.New(t)
+
+	addr, _ := multiaddr.NewMultiaddr("/ip4/0.0.0.0/tcp/15150")
+	observed, _ := multiaddr.NewMultiaddr("/ip4/10.0.0.5/tcp/43210")
+	assert.Equal("/ip4/10.0.0.5/tcp/15150", resolveUnspecifiedIP(addr, observed).String())
+
+	addr, _ = multiaddr.NewMultiaddr("/ip4/10.0.0.6/tcp/15150")
+	assert.Equal(addr, resolveUnspecifiedIP(addr, observed))
+}
+
+func TestHost_AllowedPeers(t *testing.T) {
+	assert := assert.New(t)
+
+	privs := make([]*core.PrivateKey, 2)
+	hosts := make([]*Host, 2)
+	for i := range hosts {
+		privs[i] = core.GenerateKey(nil)
+		addr, _ := multiaddr.NewMultiaddr([]string{"/ip4/127.0.0.1/tcp/25046", "/ip4/127.0.0.1/tcp/25047"}[i])
+		host, err := NewHost(privs[i], addr)
+		if !assert.NoError(err) {
+			return
+		}
+		hosts[i] = host.SetPeerExchange(true)
+	}
+	seed, joiner := hosts[0], hosts[1]
+	allowed := core.GenerateKey(nil).PublicKey()
+	seed.SetAllowedPeers(func(pubKey *core.PublicKey) bool { return pubKey.Equal(allowed) })
+
+	seedAddr, err := seed.SeedAddr()
+	assert.NoError(err)
+	assert.Error(joiner.Bootstrap([]multiaddr.Multiaddr{seedAddr}), "joiner is not allowed")
+	assert.Empty(seed.PeerStore().List())
+
+	// the peers not allowed are dropped from the exchange
+	addr, _ := multiaddr.NewMultiaddr("/ip4/127.0.0.1/tcp/25048")
+	msg := &p2p_pb.PeerExchange{}
+	for _, pubKey := range []*core.PublicKey{privs[1].PublicKey(), allowed} {
+		msg.Peers = append(msg.Peers, &p2p_pb.PeerInfo{PublicKey: pubKey.Bytes(), Addr: addr.Bytes()})
+	}
+	peers := seed.peersFromExchange(msg)
+	if assert.Len(peers, 1) {
+		assert.True(peers[0].PublicKey().Equal(allowed))
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package p2p
+
+import (
+	"time"
+
+	"github.com/aungmawjj/juria-blockchain/core"
+	"github.com/aungmawjj/juria-blockchain/logger"
+)
+
+// penalties of the infractions reported by MsgService
+const (
+	PenaltyMalformedMsg float64 = 10 // cannot be decoded
+	PenaltyInvalidMsg   float64 = 25 // decoded but fails validation, e.g. invalid signature
+)
+
+// ScoreConfig bans the peers whose penalty score reaches BanScore, banning is disabled if zero.
+// The score decays over time so that occasional infractions don't add up to a ban.
+type ScoreConfig struct {
+	BanScore    float64
+	BanDuration time.Duration
+	DecayPerSec float64
+}
+
+// Enabled checks whether banning is enabled
+func (sc ScoreConfig) Enabled() bool {
+	return sc.BanScore > 0
+}
+
+// PeerScore reports the infractions of a peer
+type PeerScore struct {
+	PubKey      string    `json:"pubKey"`
+	Score       float64   `json:"score"`
+	Infractions uint64    `json:"infractions"`
+	Bans        uint64    `json:"bans"`
+	BannedUntil time.Time `json:"bannedUntil,omitempty"`
+}
+
+// peerScore is the penalty score of a peer, kept when the peer is replaced in the store
+type peerScore struct {
+	score       float64
+	last        time.Time
+	infractions uint64
+	bans        uint64
+	bannedUntil time.Time
+}
+
+func (ps *peerScore) decay(now time.Time, rate float64) {
+	ps.score -= rate * now.Sub(ps.last).Seconds()
+	if ps.score < 0 {
+		ps.score = 0
+	}
+	ps.last = now
+}
+
+// SetScoreConfig sets the ban threshold and duration of the penalized peers
+func (s *PeerStore) SetScoreConfig(config ScoreConfig) *PeerStore {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.scoreConfig = config
+	return s
+}
+
+// Penalize adds the penalty to the score of the peer.
+// The peer is disconnected and banned when the score reaches the ban score,
+// the score is reset once banned. It returns true if the peer gets banned.
+// Penalties of a banned peer are ignored.
+func (s *PeerStore) Penalize(pubKey *core.PublicKey, penalty float64) bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	now := time.Now()
+	ps := s.getScore(pubKey)
+	if now.Before(ps.bannedUntil) {
+		return false
+	}
+	ps.decay(now, s.scoreConfig.DecayPerSec)
+	ps.score += penalty
+	ps.infractions++
+	if !s.scoreConfig.Enabled() || ps.score < s.scoreConfig.BanScore {
+		return false
+	}
+	ps.score = 0
+	ps.bans++
+	ps.bannedUntil = now.Add(s.scoreConfig.BanDuration)
+	logger.I().Warnw("peer banned", "pubKey", pubKey.String(),
+		"infractions", ps.infractions, "duration", s.scoreConfig.BanDuration)
+	if p := s.peers[pubKey.String()]; p != nil {
+		p.block(s.scoreConfig.BanDuration)
+	}
+	return true
+}
+
+func (s *PeerStore) getScore(pubKey *core.PublicKey) *peerScore {
+	ps, found := s.scores[pubKey.String()]
+	if !found {
+		ps = &peerScore{last: time.Now()}
+		s.scores[pubKey.String()] = ps
+	}
+	return ps
+}
+
+// IsBanned checks whether the peer is banned from connecting
+func (s *PeerStore) IsBanned(pubKey *core.PublicKey) bool {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	ps, found := s.scores[pubKey.String()]
+	return found && time.Now().Before(ps.bannedUntil)
+}
+
+// Scores returns the scores of the penalized peers with the decay applied
+func (s *PeerStore) Scores() []PeerScore {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	now := time.Now()
+	scores := make([]PeerScore, 0, len(s.scores))
+	for key, ps := range s.scores {
+		ps.decay(now, s.scoreConfig.DecayPerSec)
+		score := PeerScore{
+			PubKey:      key,
+			Score:       ps.score,
+			Infractions: ps.infractions,
+			Bans:        ps.bans,
+		}
+		if now.Before(ps.bannedUntil) {
+			score.BannedUntil = ps.bannedUntil
+		}
+		scores = append(scores, score)
+	}
+	return scores
+}
@@ -0,0 +1,150 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package p2p
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aungmawjj/juria-blockchain/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPeerStore_Penalize(t *testing.T) {
+	assert := assert.New(t)
+
+	s := NewPeerStore().SetScoreConfig(ScoreConfig{
+		BanScore:    30,
+		BanDuration: 100 * time.Millisecond,
+	})
+	p := NewPeer(core.GenerateKey(nil).PublicKey(), nil)
+	p.onConnected(newPipeLoopBack(), false)
+	s.Store(p)
+
+	assert.False(s.Penalize(p.PublicKey(), 10))
+	assert.False(s.Penalize(p.PublicKey(), 10))
+	assert.False(s.IsBanned(p.PublicKey()))
+	assert.Equal(PeerStatusConnected, p.Status())
+
+	assert.True(s.Penalize(p.PublicKey(), 10))
+	assert.True(s.IsBanned(p.PublicKey()))
+	assert.Equal(PeerStatusBlocked, p.Status())
+	assert.Error(p.WriteMsg([]byte{1, 1}), "disconnected")
+	assert.Error(p.setConnecting(), "refuses connections")
+
+	assert.False(s.Penalize(p.PublicKey(), 100), "ignored while banned")
+	scores := s.Scores()
+	if assert.Len(scores, 1) {
+		assert.Equal(p.PublicKey().String(), scores[0].PubKey)
+		assert.EqualValues(0, scores[0].Score, "reset once banned")
+		assert.EqualValues(3, scores[0].Infractions)
+		assert.EqualValues(1, scores[0].Bans)
+		assert.False(scores[0].BannedUntil.IsZero())
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	assert.False(s.IsBanned(p.PublicKey()))
+	assert.Equal(PeerStatusDisconnected, p.Status())
+	assert.True(s.Scores()[0].BannedUntil.IsZero())
+
+	// decay
+	s.SetScoreConfig(ScoreConfig{BanScore: 30, DecayPerSec: 1})
+	assert.False(s.Penalize(p.PublicKey(), 20))
+	s.scores[p.PublicKey().String()].last = time.Now().Add(-15 * time.Second)
+	assert.False(s.Penalize(p.PublicKey(), 20), "15 points decayed")
+	assert.InDelta(25, s.Scores()[0].Score, 0.1)
+
+	// disabled
+	s.SetScoreConfig(ScoreConfig{})
+	assert.False(s.Penalize(p.PublicKey(), 1000))
+	assert.False(s.IsBanned(p.PublicKey()))
+}
+
+func setupMsgServiceWithPipePeer(config ScoreConfig) (*MsgService, *Peer) {
+	host := new(Host)
+	host.peerStore = NewPeerStore().SetScoreConfig(config)
+	peer := NewPeer(core.GenerateKey(nil).PublicKey(), nil)
+	peer.onConnected(newPipeLoopBack(), false) // written messages are received from the peer
+	host.peerStore.Store(peer)
+	svc := NewMsgService(host, core.UnmarshalLimits{})
+	time.Sleep(time.Millisecond)
+	return svc, peer
+}
+
+func TestMsgService_BanMalformedMsgs(t *testing.T) {
+	assert := assert.New(t)
+
+	svc, peer := setupMsgServiceWithPipePeer(ScoreConfig{
+		BanScore:    5 * PenaltyMalformedMsg,
+		BanDuration: time.Minute,
+	})
+	msgs := [][]byte{
+		{byte(MsgTypeProposal), 0xff, 0xff},
+		{byte(MsgTypeVote), 0xff, 0xff},
+		{byte(MsgTypeTxList), 0xff, 0xff},
+		{100, 1}, // unknown type
+	}
+	for _, msg := range msgs {
+		assert.NoError(peer.WriteMsg(msg))
+		time.Sleep(5 * time.Millisecond)
+	}
+	assert.False(svc.host.PeerStore().IsBanned(peer.PublicKey()))
+	assert.Equal(PeerStatusConnected, peer.Status())
+
+	assert.NoError(peer.WriteMsg([]byte{byte(MsgTypeNewView)}))
+	time.Sleep(5 * time.Millisecond)
+	assert.True(svc.host.PeerStore().IsBanned(peer.PublicKey()), "banned after 5 infractions")
+	assert.Equal(PeerStatusBlocked, peer.Status())
+}
+
+func TestMsgService_PenalizeInvalidProposal(t *testing.T) {
+	assert := assert.New(t)
+
+	svc, peer := setupMsgServiceWithPipePeer(ScoreConfig{
+		BanScore:    2 * PenaltyInvalidMsg,
+		BanDuration: time.Minute,
+	})
+	key := core.GenerateKey(nil)
+	svc.SetValidatorStore(core.NewValidatorStore([]*core.PublicKey{key.PublicKey()}))
+	sub := svc.SubscribeProposal(5)
+	defer sub.Unsubscribe()
+
+	qc := core.NewQuorumCert().Build([]*core.Vote{core.NewBlock().SetHeight(9).Vote(key)})
+	valid := core.NewBlock().SetHeight(10).SetQuorumCert(qc).Sign(key)
+	unknownProposer := core.NewBlock().SetHeight(10).SetQuorumCert(qc).Sign(core.GenerateKey(nil))
+	invalidQCHeight := core.NewBlock().SetHeight(9).SetQuorumCert(qc).Sign(key)
+
+	send := func(blk *core.Block) {
+		data, err := blk.Marshal()
+		assert.NoError(err)
+		assert.NoError(peer.WriteMsg(append([]byte{byte(MsgTypeProposal)}, data...)))
+		time.Sleep(5 * time.Millisecond)
+	}
+	received := func() int {
+		count := 0
+		for {
+			select {
+			case <-sub.Events():
+				count++
+			default:
+				return count
+			}
+		}
+	}
+
+	send(valid)
+	send(unknownProposer)
+	assert.Equal(2, received(), "an unknown proposer is left to the consensus")
+	assert.Empty(svc.host.PeerStore().Scores())
+
+	send(invalidQCHeight)
+	assert.Equal(0, received(), "dropped")
+	scores := svc.host.PeerStore().Scores()
+	if assert.Len(scores, 1) {
+		assert.Equal(PenaltyInvalidMsg, scores[0].Score)
+	}
+
+	send(invalidQCHeight)
+	assert.True(svc.host.PeerStore().IsBanned(peer.PublicKey()))
+}
@@ -7,19 +7,33 @@ import (
 	"sync"
 
 	"github.com/aungmawjj/juria-blockchain/core"
+	"github.com/aungmawjj/juria-blockchain/emitter"
 )
 
 type PeerStore struct {
 	peers map[string]*Peer
-	mtx   sync.RWMutex
+
+	scores      map[string]*peerScore
+	scoreConfig ScoreConfig
+
+	addedEmitter *emitter.Emitter
+
+	mtx sync.RWMutex
 }
 
 func NewPeerStore() *PeerStore {
 	return &PeerStore{
-		peers: make(map[string]*Peer),
+		peers:        make(map[string]*Peer),
+		scores:       make(map[string]*peerScore),
+		addedEmitter: emitter.New(),
 	}
 }
 
+// SubscribeAdded emits the peers added to the store after subscribing, e.g. discovered peers
+func (s *PeerStore) SubscribeAdded() *emitter.Subscription {
+	return s.addedEmitter.Subscribe(100)
+}
+
 func (s *PeerStore) Load(pubKey *core.PublicKey) *Peer {
 	s.mtx.RLock()
 	defer s.mtx.RUnlock()
@@ -28,8 +42,13 @@ func (s *PeerStore) Load(pubKey *core.PublicKey) *Peer {
 
 func (s *PeerStore) Store(p *Peer) *Peer {
 	s.mtx.Lock()
-	defer s.mtx.Unlock()
+	_, found := s.peers[p.PublicKey().String()]
 	s.peers[p.PublicKey().String()] = p
+	s.mtx.Unlock()
+
+	if !found {
+		s.addedEmitter.Emit(p)
+	}
 	return p
 }
 
@@ -54,10 +73,13 @@ func (s *PeerStore) List() []*Peer {
 
 func (s *PeerStore) LoadOrStore(p *Peer) (actual *Peer, loaded bool) {
 	s.mtx.Lock()
-	defer s.mtx.Unlock()
 	if actual, loaded = s.peers[p.PublicKey().String()]; loaded {
+		s.mtx.Unlock()
 		return actual, loaded
 	}
 	s.peers[p.PublicKey().String()] = p
+	s.mtx.Unlock()
+
+	s.addedEmitter.Emit(p)
 	return p, false
 }
@@ -5,11 +5,15 @@ package p2p
 
 import (
 	"bytes"
+	"encoding/binary"
 	"io"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/aungmawjj/juria-blockchain/core"
 	"github.com/aungmawjj/juria-blockchain/emitter"
+	"github.com/golang/snappy"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
@@ -109,13 +113,14 @@ func TestPeer_ReadWrite(t *testing.T) {
 	p := NewPeer(nil, nil)
 
 	rwc := newRWCLoopBack()
-	p.onConnected(rwc)
+	p.onConnected(rwc, false)
 	sub := p.SubscribeMsg()
 
 	msg := []byte("hello")
 
+	received := make(chan struct{})
 	mln := new(MockListener)
-	mln.On("CB", msg).Once()
+	mln.On("CB", msg).Once().Run(func(mock.Arguments) { close(received) })
 
 	go func() {
 		for event := range sub.Events() {
@@ -125,11 +130,42 @@ func TestPeer_ReadWrite(t *testing.T) {
 
 	assert.NoError(p.WriteMsg(msg))
 
-	time.Sleep(time.Millisecond)
-
+	select {
+	case <-received:
+	case <-time.After(time.Second):
+	}
 	mln.AssertExpectations(t)
 }
 
+func TestPeer_MaxMsgSize(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, compress := range []bool{false, true} {
+		p := NewPeer(nil, nil)
+		p.setMaxMsgSize(16)
+		rwc := newRWCLoopBack()
+		p.onConnected(rwc, compress)
+		sub := p.SubscribeMsg()
+
+		msg := bytes.Repeat([]byte{1}, 16)
+		assert.NoError(p.WriteMsg(msg))
+		select {
+		case e := <-sub.Events():
+			assert.Equal(msg, e.([]byte))
+		case <-time.After(time.Second):
+			assert.Fail("message not received", "compress %v", compress)
+		}
+
+		// the length prefix is checked before the message is allocated
+		header := make([]byte, 4)
+		binary.BigEndian.PutUint32(header, MessageSizeLimit)
+		rwc.Write(header)
+		assert.Eventually(func() bool {
+			return p.Status() == PeerStatusDisconnected
+		}, time.Second, time.Millisecond, "compress %v", compress)
+	}
+}
+
 func TestPeer_ConnStatus(t *testing.T) {
 	assert := assert.New(t)
 	p := NewPeer(nil, nil)
@@ -137,7 +173,7 @@ func TestPeer_ConnStatus(t *testing.T) {
 	assert.Equal(PeerStatusDisconnected, p.Status())
 
 	rwc := newRWCLoopBack()
-	p.onConnected(rwc)
+	p.onConnected(rwc, false)
 
 	assert.Equal(PeerStatusConnected, p.Status())
 
@@ -155,9 +191,73 @@ func TestPeer_ConnStatus(t *testing.T) {
 	p.disconnect()
 	assert.Equal(PeerStatusDisconnected, p.Status())
 
-	p.onConnected(newRWCLoopBack())
+	p.onConnected(newRWCLoopBack(), false)
 	err = p.setConnecting()
 
 	assert.Error(err)
 	assert.Equal(PeerStatusConnected, p.Status())
 }
+
+// rwcCounter counts the bytes written to the connection
+type rwcCounter struct {
+	*rwcLoopBack
+	written int64
+}
+
+func (rwc *rwcCounter) Write(b []byte) (int, error) {
+	atomic.AddInt64(&rwc.written, int64(len(b)))
+	return rwc.rwcLoopBack.Write(b)
+}
+
+func TestPeer_ReadWriteCompressed(t *testing.T) {
+	assert := assert.New(t)
+
+	// a proposal with many txs and a full qc
+	parent := core.NewBlock().SetHeight(9).Sign(core.GenerateKey(nil))
+	votes := make([]*core.Vote, 10)
+	for i := range votes {
+		votes[i] = parent.Vote(core.GenerateKey(nil))
+	}
+	priv := core.GenerateKey(nil)
+	txs := make([][]byte, 2000)
+	for i := range txs {
+		txs[i] = core.NewTransaction().SetNonce(int64(i)).Sign(priv).Hash()
+	}
+	blk := core.NewBlock().SetHeight(10).SetParentHash(parent.Hash()).
+		SetQuorumCert(core.NewQuorumCert().Build(votes)).
+		SetTransactions(txs).Sign(priv)
+	msg, err := blk.Marshal()
+	assert.NoError(err)
+
+	receive := func(p *Peer) []byte {
+		sub := p.SubscribeMsg()
+		defer sub.Unsubscribe()
+		assert.NoError(p.WriteMsg(msg))
+		select {
+		case e := <-sub.Events():
+			return e.([]byte)
+		case <-time.After(time.Second):
+			t.Fatal("message not received")
+			return nil
+		}
+	}
+
+	rwc := &rwcCounter{rwcLoopBack: newRWCLoopBack()}
+	p := NewPeer(nil, nil)
+	p.onConnected(rwc, true)
+	recv := receive(p)
+	assert.Equal(msg, recv)
+	decoded := core.NewBlock()
+	if assert.NoError(decoded.Unmarshal(recv)) {
+		assert.Equal(blk.Hash(), decoded.Hash())
+		assert.Equal(txs, decoded.Transactions())
+	}
+	compressed := atomic.LoadInt64(&rwc.written)
+	assert.Equal(int64(4+len(snappy.Encode(nil, msg))), compressed)
+
+	rwc = &rwcCounter{rwcLoopBack: newRWCLoopBack()}
+	p = NewPeer(nil, nil)
+	p.onConnected(rwc, false)
+	assert.Equal(msg, receive(p))
+	assert.Equal(int64(4+len(msg)), atomic.LoadInt64(&rwc.written))
+}
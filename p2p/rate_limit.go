@@ -0,0 +1,77 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package p2p
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimit limits the inbound messages of each peer, the limit is disabled if zero.
+// Each peer can send a burst of one second of the rates.
+type RateLimit struct {
+	MsgPerSec   float64
+	BytesPerSec float64
+}
+
+// Enabled checks whether any limit is set
+func (rl RateLimit) Enabled() bool {
+	return rl.MsgPerSec > 0 || rl.BytesPerSec > 0
+}
+
+// rateLimiter is a token bucket of messages and bytes
+type rateLimiter struct {
+	limit      RateLimit
+	msgTokens  float64
+	byteTokens float64
+	last       time.Time
+	mtx        sync.Mutex
+}
+
+func newRateLimiter(limit RateLimit) *rateLimiter {
+	return &rateLimiter{
+		limit:      limit,
+		msgTokens:  limit.MsgPerSec,
+		byteTokens: limit.BytesPerSec,
+		last:       time.Now(),
+	}
+}
+
+// allow takes the tokens of a message with the given size if available.
+// A message larger than the byte rate is allowed when the bucket is full,
+// the following messages wait until the bucket refills.
+func (rl *rateLimiter) allow(size int) bool {
+	rl.mtx.Lock()
+	defer rl.mtx.Unlock()
+
+	now := time.Now()
+	elapsed := now.Sub(rl.last).Seconds()
+	rl.last = now
+	rl.msgTokens = refill(rl.msgTokens, rl.limit.MsgPerSec, elapsed)
+	rl.byteTokens = refill(rl.byteTokens, rl.limit.BytesPerSec, elapsed)
+
+	if rl.limit.MsgPerSec > 0 && rl.msgTokens < 1 {
+		return false
+	}
+	if rl.limit.BytesPerSec > 0 {
+		need := float64(size)
+		if need > rl.limit.BytesPerSec {
+			need = rl.limit.BytesPerSec
+		}
+		if rl.byteTokens < need {
+			return false
+		}
+	}
+	rl.msgTokens--
+	rl.byteTokens -= float64(size)
+	return true
+}
+
+func refill(tokens, rate, elapsed float64) float64 {
+	tokens += rate * elapsed
+	if tokens > rate {
+		return rate
+	}
+	return tokens
+}
@@ -0,0 +1,101 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package p2p
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter(t *testing.T) {
+	assert := assert.New(t)
+
+	rl := newRateLimiter(RateLimit{MsgPerSec: 10, BytesPerSec: 100})
+	for i := 0; i < 5; i++ {
+		assert.True(rl.allow(20))
+	}
+	assert.False(rl.allow(1), "no bytes left")
+
+	rl.last = rl.last.Add(-100 * time.Millisecond) // refills 1 msg and 10 bytes
+	assert.True(rl.allow(10))
+	assert.False(rl.allow(1))
+
+	rl.last = rl.last.Add(-time.Hour)
+	assert.True(rl.allow(500), "full bucket allows a large message")
+	assert.False(rl.allow(1))
+
+	rl = newRateLimiter(RateLimit{MsgPerSec: 3})
+	for i := 0; i < 3; i++ {
+		assert.True(rl.allow(1000))
+	}
+	assert.False(rl.allow(1), "no msgs left")
+
+	assert.False(RateLimit{}.Enabled())
+	assert.True(RateLimit{BytesPerSec: 1}.Enabled())
+}
+
+// pipeLoopBack reads the writes, the writes wait for the reads
+type pipeLoopBack struct {
+	*io.PipeReader
+	*io.PipeWriter
+}
+
+func newPipeLoopBack() *pipeLoopBack {
+	r, w := io.Pipe()
+	return &pipeLoopBack{r, w}
+}
+
+func (rwc *pipeLoopBack) Close() error {
+	rwc.PipeWriter.Close()
+	return rwc.PipeReader.Close()
+}
+
+func TestPeer_RateLimit(t *testing.T) {
+	assert := assert.New(t)
+
+	p := NewPeer(nil, nil)
+	p.setRateLimit(RateLimit{MsgPerSec: 20})
+	p.onConnected(newPipeLoopBack(), false)
+	sub := p.emitter.Subscribe(200) // received all
+	defer sub.Unsubscribe()
+
+	received := func() int {
+		count := 0
+		for {
+			select {
+			case <-sub.Events():
+				count++
+			case <-time.After(50 * time.Millisecond):
+				return count
+			}
+		}
+	}
+
+	// burst
+	for i := 0; i < 100; i++ {
+		assert.NoError(p.WriteMsg([]byte{1, byte(i)}))
+	}
+	count := received()
+	assert.GreaterOrEqual(count, 20)
+	assert.Less(count, 30)
+	assert.EqualValues(100-count, p.DroppedMsgs())
+
+	// normal traffic
+	dropped := p.DroppedMsgs()
+	for i := 0; i < 5; i++ {
+		time.Sleep(100 * time.Millisecond)
+		assert.NoError(p.WriteMsg([]byte{2, byte(i)}))
+	}
+	assert.Equal(5, received())
+	assert.Equal(dropped, p.DroppedMsgs())
+
+	p.setRateLimit(RateLimit{})
+	for i := 0; i < 100; i++ {
+		assert.NoError(p.WriteMsg([]byte{3, byte(i)}))
+	}
+	assert.Equal(100, received(), "disabled")
+}
@@ -2,6 +2,7 @@ package p2p
 
 import (
 	"encoding/binary"
+	"fmt"
 
 	"github.com/aungmawjj/juria-blockchain/core"
 	"github.com/aungmawjj/juria-blockchain/p2p/p2p_pb"
@@ -71,3 +72,103 @@ func (hdlr *BlockByHeightReqHandler) HandleReq(sender *core.PublicKey, data []by
 	}
 	return block.Marshal()
 }
+
+// BlockWithQCByHeightReqHandler responds the commited block with the qc certifying it
+type BlockWithQCByHeightReqHandler struct {
+	GetBlockByHeight func(height uint64) (*core.Block, error)
+	GetQC            func(blockHash []byte) (*core.QuorumCert, error)
+}
+
+var _ ReqHandler = (*BlockWithQCByHeightReqHandler)(nil)
+
+func (hdlr *BlockWithQCByHeightReqHandler) Type() p2p_pb.Request_Type {
+	return p2p_pb.Request_BlockWithQCByHeight
+}
+
+func (hdlr *BlockWithQCByHeightReqHandler) HandleReq(sender *core.PublicKey, data []byte) ([]byte, error) {
+	height := binary.BigEndian.Uint64(data)
+	block, err := hdlr.GetBlockByHeight(height)
+	if err != nil {
+		return nil, err
+	}
+	qc, err := hdlr.GetQC(block.Hash())
+	if err != nil {
+		return nil, err
+	}
+	resp := new(p2p_pb.BlockWithQC)
+	if resp.Block, err = block.Marshal(); err != nil {
+		return nil, err
+	}
+	if resp.Qc, err = qc.Marshal(); err != nil {
+		return nil, err
+	}
+	return proto.Marshal(resp)
+}
+
+// DefaultMaxBlocksPerRange is the number of blocks responded for a range request by default
+const DefaultMaxBlocksPerRange = 100
+
+// BlocksByRangeReqHandler responds the commited blocks of a height range in ascending order.
+// The blocks after MaxBlocks from the start are not responded, the client requests them next.
+type BlocksByRangeReqHandler struct {
+	GetBlocksByRange func(from, to uint64) ([]*core.Block, error)
+
+	// DefaultMaxBlocksPerRange if zero
+	MaxBlocks uint64
+}
+
+var _ ReqHandler = (*BlocksByRangeReqHandler)(nil)
+
+func (hdlr *BlocksByRangeReqHandler) Type() p2p_pb.Request_Type {
+	return p2p_pb.Request_BlocksByRange
+}
+
+func (hdlr *BlocksByRangeReqHandler) HandleReq(sender *core.PublicKey, data []byte) ([]byte, error) {
+	req := new(p2p_pb.HeightRange)
+	if err := proto.Unmarshal(data, req); err != nil {
+		return nil, err
+	}
+	if req.From > req.To {
+		return nil, fmt.Errorf("invalid range %d to %d", req.From, req.To)
+	}
+	maxBlocks := hdlr.MaxBlocks
+	if maxBlocks == 0 {
+		maxBlocks = DefaultMaxBlocksPerRange
+	}
+	if req.To-req.From >= maxBlocks {
+		req.To = req.From + maxBlocks - 1
+	}
+	blocks, err := hdlr.GetBlocksByRange(req.From, req.To)
+	if err != nil {
+		return nil, err
+	}
+	resp := &p2p_pb.BlockList{List: make([][]byte, len(blocks))}
+	for i, blk := range blocks {
+		if resp.List[i], err = blk.Marshal(); err != nil {
+			return nil, err
+		}
+	}
+	return proto.Marshal(resp)
+}
+
+// SilentViewsReqHandler responds the views since a validator's signature was last seen,
+// used by a standby node to confirm the primary is silent before it's promoted
+type SilentViewsReqHandler struct {
+	GetSilentViews func(pubKey *core.PublicKey) uint64
+}
+
+var _ ReqHandler = (*SilentViewsReqHandler)(nil)
+
+func (hdlr *SilentViewsReqHandler) Type() p2p_pb.Request_Type {
+	return p2p_pb.Request_SilentViews
+}
+
+func (hdlr *SilentViewsReqHandler) HandleReq(sender *core.PublicKey, data []byte) ([]byte, error) {
+	pubKey, err := core.NewPublicKey(data)
+	if err != nil {
+		return nil, err
+	}
+	resp := make([]byte, 8)
+	binary.BigEndian.PutUint64(resp, hdlr.GetSilentViews(pubKey))
+	return resp, nil
+}
@@ -0,0 +1,111 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package p2p
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"sync"
+	"time"
+)
+
+// SeenCacheConfig bounds the hashes of gossiped messages which MsgService remembers,
+// the cache is disabled if Size is zero. The entries expire after TTL, never if zero.
+type SeenCacheConfig struct {
+	Size int
+	TTL  time.Duration
+}
+
+// Enabled checks whether the cache is enabled
+func (sc SeenCacheConfig) Enabled() bool {
+	return sc.Size > 0
+}
+
+// seenCache is a bounded lru set of message hashes.
+// A message is seen once it's added, whoever sends it again.
+type seenCache struct {
+	config SeenCacheConfig
+
+	mtx     sync.Mutex
+	entries map[[sha256.Size]byte]*list.Element
+	order   *list.List // front is most recently seen
+}
+
+type seenEntry struct {
+	key    [sha256.Size]byte
+	expiry time.Time
+}
+
+// newSeenCache returns nil if the config is not enabled, nil cache is disabled
+func newSeenCache(config SeenCacheConfig) *seenCache {
+	if !config.Enabled() {
+		return nil
+	}
+	return &seenCache{
+		config:  config,
+		entries: make(map[[sha256.Size]byte]*list.Element, config.Size),
+		order:   list.New(),
+	}
+}
+
+func seenKey(msgType MsgType, data []byte) [sha256.Size]byte {
+	h := sha256.New()
+	h.Write([]byte{byte(msgType)})
+	h.Write(data)
+	var key [sha256.Size]byte
+	h.Sum(key[:0])
+	return key
+}
+
+// has checks whether the key is seen and not expired
+func (c *seenCache) has(key [sha256.Size]byte) bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.get(key, time.Now()) != nil
+}
+
+// add records the key and returns false if it's already seen
+func (c *seenCache) add(key [sha256.Size]byte) bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	now := time.Now()
+	if c.get(key, now) != nil {
+		return false
+	}
+	entry := &seenEntry{key: key}
+	if c.config.TTL > 0 {
+		entry.expiry = now.Add(c.config.TTL)
+	}
+	c.entries[key] = c.order.PushFront(entry)
+	for c.order.Len() > c.config.Size {
+		c.remove(c.order.Back())
+	}
+	return true
+}
+
+// get returns the element of the key, the expired element is removed
+func (c *seenCache) get(key [sha256.Size]byte, now time.Time) *list.Element {
+	e, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	expiry := e.Value.(*seenEntry).expiry
+	if !expiry.IsZero() && !now.Before(expiry) {
+		c.remove(e)
+		return nil
+	}
+	return e
+}
+
+func (c *seenCache) remove(e *list.Element) {
+	c.order.Remove(e)
+	delete(c.entries, e.Value.(*seenEntry).key)
+}
+
+func (c *seenCache) len() int {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.order.Len()
+}
@@ -0,0 +1,110 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package p2p
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aungmawjj/juria-blockchain/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSeenCache(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Nil(newSeenCache(SeenCacheConfig{}))
+
+	c := newSeenCache(SeenCacheConfig{Size: 2})
+	k1 := seenKey(MsgTypeProposal, []byte{1})
+	k2 := seenKey(MsgTypeProposal, []byte{2})
+	k3 := seenKey(MsgTypeProposal, []byte{3})
+	assert.NotEqual(seenKey(MsgTypeTxList, []byte{1}), k1, "keyed by type")
+
+	assert.True(c.add(k1))
+	assert.False(c.add(k1))
+	assert.True(c.has(k1))
+	assert.False(c.has(k2))
+
+	assert.True(c.add(k2))
+	assert.True(c.add(k3))
+	assert.Equal(2, c.len())
+	assert.False(c.has(k1), "evicted")
+	assert.True(c.has(k2))
+
+	c = newSeenCache(SeenCacheConfig{Size: 10, TTL: 20 * time.Millisecond})
+	assert.True(c.add(k1))
+	assert.True(c.has(k1))
+	time.Sleep(30 * time.Millisecond)
+	assert.False(c.has(k1), "expired")
+	assert.Equal(0, c.len())
+	assert.True(c.add(k1))
+}
+
+func TestMsgService_DropSeenProposal(t *testing.T) {
+	assert := assert.New(t)
+
+	host := new(Host)
+	host.peerStore = NewPeerStore()
+	peers := make([]*Peer, 2)
+	for i := range peers {
+		peers[i] = NewPeer(core.GenerateKey(nil).PublicKey(), nil)
+		peers[i].onConnected(newPipeLoopBack(), false) // written messages are received from the peer
+		host.peerStore.Store(peers[i])
+	}
+	svc := NewMsgService(host, core.UnmarshalLimits{})
+	svc.SetSeenCache(SeenCacheConfig{Size: 100, TTL: time.Minute})
+	time.Sleep(time.Millisecond)
+	sub := svc.SubscribeProposal(5)
+	defer sub.Unsubscribe()
+
+	send := func(peer *Peer, blk *core.Block) {
+		data, err := blk.Marshal()
+		assert.NoError(err)
+		assert.NoError(peer.WriteMsg(append([]byte{byte(MsgTypeProposal)}, data...)))
+		time.Sleep(5 * time.Millisecond)
+	}
+	received := func() int {
+		count := 0
+		for {
+			select {
+			case <-sub.Events():
+				count++
+			default:
+				return count
+			}
+		}
+	}
+
+	key := core.GenerateKey(nil)
+	qc := core.NewQuorumCert().Build([]*core.Vote{core.NewBlock().SetHeight(9).Vote(key)})
+	blk1 := core.NewBlock().SetHeight(10).SetQuorumCert(qc).Sign(key)
+	blk2 := core.NewBlock().SetHeight(11).SetQuorumCert(qc).Sign(key)
+
+	send(peers[0], blk1)
+	send(peers[1], blk1)
+	assert.Equal(1, received(), "duplicate dropped")
+
+	send(peers[1], blk2)
+	assert.Equal(1, received())
+	send(peers[1], blk2)
+	assert.Equal(0, received(), "resent by the same peer")
+
+	// the genesis proposal is rebroadcast until the genesis qc is formed
+	b0 := core.NewBlock().SetHeight(0).Sign(key)
+	send(peers[0], b0)
+	send(peers[0], b0)
+	assert.Equal(2, received(), "genesis resent")
+
+	// the pipe peers echo the broadcast back
+	blk3 := core.NewBlock().SetHeight(12).SetQuorumCert(qc).Sign(key)
+	assert.NoError(svc.BroadcastProposal(blk3))
+	time.Sleep(5 * time.Millisecond)
+	assert.Equal(0, received(), "own broadcast dropped")
+
+	svc.SetSeenCache(SeenCacheConfig{})
+	send(peers[0], blk1)
+	send(peers[1], blk1)
+	assert.Equal(2, received(), "disabled")
+}
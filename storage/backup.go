@@ -0,0 +1,335 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+
+	"github.com/aungmawjj/juria-blockchain/logger"
+	"github.com/aungmawjj/juria-blockchain/merkle"
+	"github.com/dgraph-io/badger/v3"
+	"github.com/dgraph-io/badger/v3/pb"
+)
+
+// errors
+var (
+	ErrInvalidBackup  = errors.New("invalid backup")
+	ErrBackupMismatch = errors.New("backup mismatch")
+)
+
+var backupMagic = []byte("juriabak")
+
+// BackupVersion follows the magic bytes of backup
+const BackupVersion = 1
+
+// key values per list of the backup stream
+const backupListSize = 1000
+
+// same as badger, which doesn't export it
+const badgerBitDelete byte = 1
+
+/*
+backup of the whole db
+
+	magic, version (1 byte)
+	manifest (length prefixed)
+		has blocks (uvarint), block height (uvarint)
+		merkle root, leaf count
+		since version, next version (uvarint)
+	badger backup stream, loaded with badger.DB.Load
+
+Byte fields are prefixed with their length as uvarint.
+The db is read in one transaction, so that the manifest is consistent with the stream.
+*/
+type backupManifest struct {
+	hasBlocks   bool
+	height      uint64
+	merkleRoot  []byte
+	leafCount   *big.Int
+	since       uint64
+	nextVersion uint64
+}
+
+// Backup writes the keys changed since sinceVersion while the node is running.
+// Pass zero for a full backup, or the returned version of the previous backup for an incremental one.
+// A commit in progress is included partially and rolled back when the restored storage is opened.
+func (strg *Storage) Backup(w io.Writer, sinceVersion uint64) (uint64, error) {
+	var nextVersion uint64
+	err := strg.db.View(func(txn *badger.Txn) error {
+		manifest := readBackupManifest(&txnGetter{txn})
+		manifest.since = sinceVersion
+		manifest.nextVersion = txn.ReadTs() + 1
+		nextVersion = manifest.nextVersion
+
+		bw := bufio.NewWriter(w)
+		sw := &snapshotWriter{w: bw}
+		sw.write(backupMagic)
+		sw.write([]byte{BackupVersion})
+		sw.bytes(manifest.marshal())
+		if sw.err != nil {
+			return sw.err
+		}
+		if err := writeBackupStream(txn, bw, sinceVersion); err != nil {
+			return err
+		}
+		return bw.Flush()
+	})
+	if err != nil {
+		return 0, err
+	}
+	return nextVersion, nil
+}
+
+func readBackupManifest(getter getter) *backupManifest {
+	manifest := new(backupManifest)
+	cs := &chainStore{getter}
+	ms := &merkleStore{getter, newLegacyNodes(true)} // a restored db may have legacy nodes
+	height, err := cs.getBlockHeight()
+	if err == nil {
+		manifest.hasBlocks = true
+		manifest.height = height
+	}
+	manifest.leafCount = ms.getLeafCount()
+	if manifest.leafCount.Sign() == 1 {
+		manifest.merkleRoot = ms.GetNode(merkle.NewPosition(ms.GetHeight()-1, big.NewInt(0)))
+	}
+	return manifest
+}
+
+// writeBackupStream writes the newest version of each key changed since sinceVersion,
+// in the format of badger backup. Deleted keys are written as delete markers for incremental backup.
+func writeBackupStream(txn *badger.Txn, w io.Writer, sinceVersion uint64) error {
+	it := txn.NewIterator(badger.IteratorOptions{AllVersions: true, PrefetchValues: true, PrefetchSize: 100})
+	defer it.Close()
+	list := &pb.KVList{}
+	var lastKey []byte
+	for it.Rewind(); it.Valid(); it.Next() {
+		item := it.Item()
+		if bytes.Equal(item.Key(), lastKey) {
+			continue // older versions
+		}
+		lastKey = item.KeyCopy(nil)
+		if item.Version() < sinceVersion {
+			continue
+		}
+		kv := &pb.KV{Key: lastKey, Version: item.Version()}
+		if item.IsDeletedOrExpired() {
+			if sinceVersion == 0 {
+				continue
+			}
+			kv.Meta = []byte{badgerBitDelete}
+		} else {
+			var err error
+			if kv.Value, err = item.ValueCopy(nil); err != nil {
+				return err
+			}
+		}
+		list.Kv = append(list.Kv, kv)
+		if len(list.Kv) == backupListSize {
+			if err := writeBackupList(w, list); err != nil {
+				return err
+			}
+			list = &pb.KVList{}
+		}
+	}
+	if len(list.Kv) == 0 {
+		return nil
+	}
+	return writeBackupList(w, list)
+}
+
+// writeBackupList writes the list as badger backup does
+func writeBackupList(w io.Writer, list *pb.KVList) error {
+	b, err := list.Marshal()
+	if err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, uint64(len(b))); err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// Restore loads the backup into the db at dir, which must be empty for a full backup.
+// An incremental backup is restored on top of the previous backup restored at dir.
+// The state root and block height of the restored db must match the backup manifest.
+func Restore(dir string, r io.Reader) error {
+	br := bufio.NewReader(r)
+	manifest, err := readBackupHeader(br)
+	if err != nil {
+		return err
+	}
+	db, err := NewDB(dir)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return restoreDB(db, br, manifest)
+}
+
+// Restore loads the backup into the db of the storage like the package Restore,
+// e.g. into a fresh node before it starts syncing. A commit in progress in the backup is rolled back.
+func (strg *Storage) Restore(r io.Reader) error {
+	if strg.readOnly {
+		return ErrReadOnly
+	}
+	br := bufio.NewReader(r)
+	manifest, err := readBackupHeader(br)
+	if err != nil {
+		return err
+	}
+	strg.mtxCommit.Lock()
+	defer strg.mtxCommit.Unlock()
+
+	err = func() error {
+		strg.mtxWriteState.Lock()
+		defer strg.mtxWriteState.Unlock()
+		defer strg.purgeCaches()
+		return restoreDB(strg.db, br, manifest)
+	}()
+	if err != nil {
+		return err
+	}
+	// the backup may come from a db with another config or an older version
+	if err := strg.checkMetadata(); err != nil && !errors.Is(err, ErrMigrationRequired) {
+		return err
+	}
+	if err := strg.recoverCommit(); err != nil {
+		return err
+	}
+	return strg.migrate()
+}
+
+// purgeCaches drops the cached values after the db is written elsewhere
+func (strg *Storage) purgeCaches() {
+	if strg.stateCache != nil {
+		strg.stateCache.purge()
+	}
+	if strg.blockCache != nil {
+		strg.blockCache.purge()
+	}
+	if strg.merkleCache != nil {
+		strg.merkleCache.Purge()
+	}
+}
+
+func readBackupHeader(br *bufio.Reader) (*backupManifest, error) {
+	sr := &snapshotReader{r: br}
+	magic := sr.read(len(backupMagic))
+	version := sr.read(1)
+	mb := sr.bytes()
+	if sr.err != nil {
+		return nil, fmt.Errorf("%w, %v", ErrInvalidBackup, sr.err)
+	}
+	if !bytes.Equal(magic, backupMagic) {
+		return nil, fmt.Errorf("%w, unknown format", ErrInvalidBackup)
+	}
+	if version[0] != BackupVersion {
+		return nil, fmt.Errorf("%w, unsupported version %d", ErrInvalidBackup, version[0])
+	}
+	return unmarshalBackupManifest(mb)
+}
+
+// restoreDB loads the badger backup stream after the header and checks it with the manifest
+func restoreDB(db *badger.DB, br *bufio.Reader, manifest *backupManifest) error {
+	if err := checkRestoreBase(db, manifest.since); err != nil {
+		return err
+	}
+	if err := db.Load(br, 256); err != nil {
+		return err
+	}
+	restored := readBackupManifest(&badgerGetter{db})
+	if restored.hasBlocks != manifest.hasBlocks || restored.height != manifest.height {
+		return fmt.Errorf("%w, restored height %d, manifest has %d",
+			ErrBackupMismatch, restored.height, manifest.height)
+	}
+	if !bytes.Equal(restored.merkleRoot, manifest.merkleRoot) {
+		return fmt.Errorf("%w, restored state root %x, manifest has %x",
+			ErrBackupMismatch, restored.merkleRoot, manifest.merkleRoot)
+	}
+	err := updateBadgerDB(db, []updateFunc{func(setter setter) error {
+		return setter.Set([]byte{colRestoredVersion}, uint64BEBytes(manifest.nextVersion))
+	}})
+	if err != nil {
+		return err
+	}
+	logger.I().Infow("restored backup", "height", manifest.height, "since", manifest.since)
+	return nil
+}
+
+// checkRestoreBase checks that the db is empty for a full backup,
+// or the previous backup is restored for an incremental one
+func checkRestoreBase(db *badger.DB, since uint64) error {
+	getter := &badgerGetter{db}
+	val, err := getter.Get([]byte{colRestoredVersion})
+	if since == 0 {
+		empty := true
+		err := db.View(func(txn *badger.Txn) error {
+			it := txn.NewIterator(badger.IteratorOptions{})
+			defer it.Close()
+			it.Rewind()
+			empty = !it.Valid()
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if !empty {
+			return fmt.Errorf("%w, full backup", ErrStorageNotEmpty)
+		}
+		return nil
+	}
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return fmt.Errorf("%w, incremental backup since %d on a db not restored", ErrBackupMismatch, since)
+	}
+	if err != nil {
+		return err
+	}
+	if restored := binary.BigEndian.Uint64(val); restored != since {
+		return fmt.Errorf("%w, incremental backup since %d, restored to %d", ErrBackupMismatch, since, restored)
+	}
+	return nil
+}
+
+func (manifest *backupManifest) marshal() []byte {
+	buf := new(bytes.Buffer)
+	sw := &snapshotWriter{w: bufio.NewWriter(buf)}
+	hasBlocks := uint64(0)
+	if manifest.hasBlocks {
+		hasBlocks = 1
+	}
+	sw.uvarint(hasBlocks)
+	sw.uvarint(manifest.height)
+	sw.bytes(manifest.merkleRoot)
+	sw.bytes(manifest.leafCount.Bytes())
+	sw.uvarint(manifest.since)
+	sw.uvarint(manifest.nextVersion)
+	sw.flush() // no error from bytes.Buffer
+	return buf.Bytes()
+}
+
+func unmarshalBackupManifest(b []byte) (*backupManifest, error) {
+	sr := &snapshotReader{r: bufio.NewReader(bytes.NewReader(b))}
+	manifest := new(backupManifest)
+	manifest.hasBlocks = sr.uvarint() == 1
+	manifest.height = sr.uvarint()
+	manifest.merkleRoot = sr.bytes()
+	manifest.leafCount = big.NewInt(0).SetBytes(sr.bytes())
+	manifest.since = sr.uvarint()
+	manifest.nextVersion = sr.uvarint()
+	if sr.err != nil {
+		return nil, fmt.Errorf("%w, manifest, %v", ErrInvalidBackup, sr.err)
+	}
+	if len(manifest.merkleRoot) == 0 {
+		manifest.merkleRoot = nil
+	}
+	return manifest, nil
+}
@@ -0,0 +1,115 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package storage
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func openRestored(t *testing.T, dir string) *Storage {
+	db, err := NewDB(dir)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	t.Cleanup(func() { db.Close() })
+	return New(db, DefaultConfig)
+}
+
+func TestStorage_Backup(t *testing.T) {
+	assert := assert.New(t)
+
+	src := newTestStorage()
+	commitTestStates(t, src, 3, 200)
+
+	// commits continue during backup
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		commitTestStates(t, src, 5, 200)
+	}()
+	buf := new(bytes.Buffer)
+	version, err := src.Backup(buf, 0)
+	assert.NoError(err)
+	<-done
+
+	dir := t.TempDir()
+	assert.NoError(Restore(dir, bytes.NewReader(buf.Bytes())))
+	dst := openRestored(t, dir)
+	height := dst.GetBlockHeight()
+	assert.GreaterOrEqual(height, uint64(2))
+	srcLeafCount, err := src.merkleStore.getLeafCountAtHeight(height)
+	assert.NoError(err)
+	srcRoot, err := src.merkleTree.RootAtLeafCount(srcLeafCount)
+	assert.NoError(err)
+	assert.Equal(srcRoot.Data, dst.GetMerkleRoot(), "state at restored height")
+	for i := 0; i < 50; i++ {
+		k := rand.Intn(200)
+		key := []byte{uint8(k >> 8), uint8(k)}
+		assert.Equal(dst.GetState(nil, key), dst.VerifyState(nil, key))
+	}
+	dst.db.Close()
+
+	assert.ErrorIs(Restore(dir, bytes.NewReader(buf.Bytes())), ErrStorageNotEmpty)
+
+	// incremental backup on top of the restored one
+	buf2 := new(bytes.Buffer)
+	version2, err := src.Backup(buf2, version)
+	assert.NoError(err)
+	assert.Greater(version2, version)
+	assert.NoError(Restore(dir, buf2))
+	assertSameState(t, src, openRestored(t, dir), 200)
+}
+
+func TestStorage_Restore(t *testing.T) {
+	assert := assert.New(t)
+
+	src := newTestStorage()
+	commitTestStates(t, src, 4, 100)
+	buf := new(bytes.Buffer)
+	version, err := src.Backup(buf, 0)
+	assert.NoError(err)
+
+	dst := newTestStorage()
+	assert.NoError(dst.Restore(buf))
+	assertSameState(t, src, dst, 100)
+
+	commitTestStates(t, src, 2, 100)
+	buf.Reset()
+	_, err = src.Backup(buf, version)
+	assert.NoError(err)
+	assert.NoError(dst.Restore(buf), "incremental")
+	assertSameState(t, src, dst, 100)
+
+	buf.Reset()
+	_, err = src.Backup(buf, 0)
+	assert.NoError(err)
+	assert.ErrorIs(dst.Restore(buf), ErrStorageNotEmpty)
+}
+
+func TestStorage_BackupInvalid(t *testing.T) {
+	assert := assert.New(t)
+
+	src := newTestStorage()
+	commitTestStates(t, src, 2, 50)
+	buf := new(bytes.Buffer)
+	version, err := src.Backup(buf, 0)
+	assert.NoError(err)
+	data := buf.Bytes()
+
+	commitTestStates(t, src, 1, 50)
+	incr := new(bytes.Buffer)
+	_, err = src.Backup(incr, version)
+	assert.NoError(err)
+	assert.ErrorIs(Restore(t.TempDir(), incr), ErrBackupMismatch, "incremental on empty db")
+
+	tampered := append([]byte{}, data...)
+	tampered[0]++
+	assert.ErrorIs(Restore(t.TempDir(), bytes.NewReader(tampered)), ErrInvalidBackup)
+
+	assert.ErrorIs(Restore(t.TempDir(), bytes.NewReader(data[:5])), ErrInvalidBackup)
+}
@@ -0,0 +1,259 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package storage
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aungmawjj/juria-blockchain/core"
+	"github.com/aungmawjj/juria-blockchain/logger"
+	"github.com/aungmawjj/juria-blockchain/merkle"
+	"github.com/dgraph-io/badger/v3"
+)
+
+// CommitBatch commits the blocks in order in one db transaction, nothing is written if any fails.
+// State changes of the blocks are merged into a single merkle update for the batch.
+// Block commits keep their own state changes, tx commits and leaf counts,
+// but only the last one records the merkle root, so consistency proofs
+// are not available at the other heights of the batch. Likewise the merkle history
+// keeps the tree only from the last block of the batch changing it, see KeepMerkleVersions.
+// A batch too large for one transaction fails with badger.ErrTxnTooBig.
+func (strg *Storage) CommitBatch(data []*CommitData) error {
+	if strg.readOnly {
+		return ErrReadOnly
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	strg.mtxCommit.Lock()
+	defer strg.mtxCommit.Unlock()
+
+	start := time.Now()
+	ov := newOverlayGetter(strg.stateStore.getter)
+	batch := strg.withGetter(ov)
+	merged := core.NewBlockCommit()
+	var mergedHeight uint64 // last block height with state changes
+	for _, d := range data {
+		if err := batch.verifyBody(d); err != nil {
+			return fmt.Errorf("block %d, %w", d.Block.Height(), err)
+		}
+		if scList := d.BlockCommit.StateChanges(); len(scList) > 0 {
+			leafCount := batch.setTreeIndexes(scList)
+			d.BlockCommit.SetLeafCount(leafCount.Bytes())
+			merged.MergeStateChanges(d.BlockCommit)
+			mergedHeight = d.Block.Height()
+			// next blocks assign new leaf indexes after this one
+			if err := ov.apply([]updateFunc{strg.merkleStore.setLeafCount(leafCount)}); err != nil {
+				return err
+			}
+		}
+		updFns := batch.chainDataFns(d)
+		stateFns, err := batch.stateMerkleTreeFns(d)
+		if err != nil {
+			return err
+		}
+		if err := ov.apply(append(updFns, stateFns...)); err != nil {
+			return err
+		}
+	}
+	mergedList := merged.StateChanges()
+	upd, err := strg.computeBatchMerkleUpdate(batch, mergedList, mergedHeight, data[len(data)-1], ov)
+	if err != nil {
+		return err
+	}
+
+	strg.mtxWriteState.Lock()
+	defer strg.mtxWriteState.Unlock()
+
+	for _, d := range data {
+		strg.invalidateBlock(d.Block.Hash())
+		defer strg.invalidateBlock(d.Block.Hash())
+	}
+	strg.invalidateStates(mergedList)
+	defer strg.invalidateStates(mergedList)
+	var written uint64
+	if err := updateBadgerDB(strg.db, countWrites([]updateFunc{ov.write}, &written)); err != nil {
+		return err
+	}
+	strg.metaRecorded = true
+	if strg.merkleCache != nil && upd != nil {
+		strg.merkleCache.CommitUpdate(upd)
+	}
+	strg.metrics.commit.since(start)
+	strg.metrics.addCommits(uint64(len(data)), written)
+	logger.I().Debugw("commit batch", "blocks", len(data), "elapsed", time.Since(start), "bytes", written)
+	for _, d := range data {
+		strg.commitEmitter.Emit(d)
+	}
+	return nil
+}
+
+// computeBatchMerkleUpdate updates the state tree with the merged state changes of the batch
+// and records the root in the block commit of the last block
+func (strg *Storage) computeBatchMerkleUpdate(
+	batch *Storage, merged []*core.StateChange, height uint64, last *CommitData, ov *overlayGetter,
+) (*merkle.UpdateResult, error) {
+	start := time.Now()
+	nodes := strg.stateStore.computeUpdatedTreeNodes(merged)
+	if len(nodes) == 0 {
+		return nil, nil
+	}
+	upd := strg.merkleTree.Update(nodes, batch.merkleStore.getLeafCount())
+	elapsed := time.Since(start)
+	strg.metrics.merkle.observe(elapsed)
+	logger.I().Debugw("compute batch merkle update",
+		"leaf nodes", len(upd.Leaves), "elapsed", elapsed)
+
+	last.BlockCommit.
+		SetLeafCount(upd.LeafCount.Bytes()).
+		SetMerkleRoot(upd.Root.Data).
+		SetElapsedMerkle(elapsed.Seconds())
+	updFns := make([]updateFunc, 0)
+	if strg.keepMerkleVersions > 0 {
+		// versions read the nodes before the update, the tree is kept from the last block changing it
+		verFns, err := batch.writeMerkleVersions(upd, height, true)
+		if err != nil {
+			return nil, err
+		}
+		updFns = append(updFns, verFns...)
+	}
+	updFns = append(updFns, strg.merkleStore.commitUpdate(upd)...)
+	updFns = append(updFns, strg.chainStore.setBlockCommit(last.BlockCommit))
+	return upd, ov.apply(updFns)
+}
+
+// withGetter returns a copy of storage which reads with the getter,
+// merkle tree nodes are not cached
+func (strg *Storage) withGetter(getter getter) *Storage {
+	s := &Storage{
+		db:                 strg.db,
+		chainStore:         &chainStore{getter},
+		stateStore:         &stateStore{getter, strg.stateStore.hashFunc, strg.stateStore.concurrentLimit},
+		merkleStore:        &merkleStore{getter, strg.merkleStore.legacy},
+		merkleConfig:       strg.merkleConfig,
+		keepStateVersions:  strg.keepStateVersions,
+		keepMerkleVersions: strg.keepMerkleVersions,
+		requireCodeAddr:    strg.requireCodeAddr,
+		indexSender:        strg.indexSender,
+		metaRecorded:       strg.metaRecorded,
+		metrics:            strg.metrics,
+	}
+	s.merkleTree = merkle.NewTree(s.merkleStore, s.merkleConfig)
+	return s
+}
+
+// overlayGetter reads the pending writes of a batch on top of the db
+type overlayGetter struct {
+	base   getter
+	writes map[string]*overlayValue
+
+	// prefixes of state and merkle node versions written, to find keys changed in the batch
+	versioned map[string]struct{}
+}
+
+type overlayValue struct {
+	value   []byte
+	deleted bool
+}
+
+var (
+	_ getter = (*overlayGetter)(nil)
+	_ setter = (*overlayGetter)(nil)
+)
+
+func newOverlayGetter(base getter) *overlayGetter {
+	return &overlayGetter{
+		base:      base,
+		writes:    make(map[string]*overlayValue),
+		versioned: make(map[string]struct{}),
+	}
+}
+
+func (ov *overlayGetter) Get(key []byte) ([]byte, error) {
+	if w, ok := ov.writes[string(key)]; ok {
+		if w.deleted {
+			return nil, badger.ErrKeyNotFound
+		}
+		return w.value, nil
+	}
+	return ov.base.Get(key)
+}
+
+func (ov *overlayGetter) HasKey(key []byte) bool {
+	if w, ok := ov.writes[string(key)]; ok {
+		return !w.deleted
+	}
+	return ov.base.HasKey(key)
+}
+
+func (ov *overlayGetter) GetValues(keys [][]byte) ([][]byte, error) {
+	values := make([][]byte, len(keys))
+	baseKeys := make([][]byte, 0)
+	baseIdx := make([]int, 0)
+	for i, key := range keys {
+		if w, ok := ov.writes[string(key)]; ok {
+			if !w.deleted {
+				values[i] = w.value
+			}
+			continue
+		}
+		baseKeys = append(baseKeys, key)
+		baseIdx = append(baseIdx, i)
+	}
+	if len(baseKeys) == 0 {
+		return values, nil
+	}
+	baseValues, err := ov.base.GetValues(baseKeys)
+	if err != nil {
+		return nil, err
+	}
+	for i, v := range baseValues {
+		values[baseIdx[i]] = v
+	}
+	return values, nil
+}
+
+func (ov *overlayGetter) Set(key, value []byte) error {
+	ov.writes[string(key)] = &overlayValue{value: value}
+	if len(key) > 8 && (key[0] == colStateVersion || key[0] == colMerkleNodeVersion) {
+		ov.versioned[string(key[:len(key)-8])] = struct{}{}
+	}
+	return nil
+}
+
+func (ov *overlayGetter) Delete(key []byte) error {
+	ov.writes[string(key)] = &overlayValue{deleted: true}
+	return nil
+}
+
+func (ov *overlayGetter) hasVersions(prefix []byte) bool {
+	_, ok := ov.versioned[string(prefix)]
+	return ok
+}
+
+func (ov *overlayGetter) apply(fns []updateFunc) error {
+	for _, fn := range fns {
+		if err := fn(ov); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// write is the updateFunc writing all pending writes
+func (ov *overlayGetter) write(setter setter) error {
+	for key, w := range ov.writes {
+		var err error
+		if w.deleted {
+			err = setter.Delete([]byte(key))
+		} else {
+			err = setter.Set([]byte(key), w.value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package storage
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/aungmawjj/juria-blockchain/core"
+	"github.com/stretchr/testify/assert"
+)
+
+// newBatchTestData creates commit data of blocks after parent, each with one tx and the state changes
+func newBatchTestData(priv *core.PrivateKey, parent *core.Block, blocks [][][2]string) []*CommitData {
+	data := make([]*CommitData, len(blocks))
+	for i, kvs := range blocks {
+		height := uint64(0)
+		if parent != nil {
+			height = parent.Height() + 1
+		}
+		tx := core.NewTransaction().SetNonce(int64(height)).Sign(priv)
+		blk := core.NewBlock().SetHeight(height).SetTransactions([][]byte{tx.Hash()})
+		if parent != nil {
+			blk.SetParentHash(parent.Hash()).
+				SetQuorumCert(core.NewQuorumCert().Build([]*core.Vote{parent.ProposerVote()}))
+		}
+		blk.Sign(priv)
+		scList := make([]*core.StateChange, len(kvs))
+		for j, kv := range kvs {
+			scList[j] = core.NewStateChange().SetKey([]byte(kv[0])).SetValue([]byte(kv[1]))
+		}
+		data[i] = &CommitData{
+			Block:        blk,
+			QC:           core.NewQuorumCert(),
+			Transactions: []*core.Transaction{tx},
+			BlockCommit:  core.NewBlockCommit().SetHash(blk.Hash()).SetStateChanges(scList),
+			TxCommits: []*core.TxCommit{
+				core.NewTxCommit().SetHash(tx.Hash()).SetBlockHash(blk.Hash()).SetBlockHeight(height),
+			},
+		}
+		parent = blk
+	}
+	return data
+}
+
+func TestStorage_CommitBatch(t *testing.T) {
+	assert := assert.New(t)
+
+	config := DefaultConfig
+	config.KeepStateVersions = 10
+	batched := New(createOnMemoryDB(), config)
+	serial := New(createOnMemoryDB(), config)
+	assert.NoError(batched.CommitBatch(nil))
+
+	priv := core.GenerateKey(nil)
+	blocks := [][][2]string{
+		{{"a", "1"}, {"b", "1"}},
+		{{"a", "2"}, {"c", "1"}},
+		{},
+		{{"b", "2"}, {"d", "1"}},
+	}
+	for _, d := range newBatchTestData(priv, nil, blocks[:1]) {
+		assert.NoError(serial.Commit(d))
+	}
+	assert.NoError(batched.CommitBatch(newBatchTestData(priv, nil, blocks[:1])))
+
+	parent, _ := serial.GetLastBlock()
+	for _, d := range newBatchTestData(priv, parent, blocks[1:]) {
+		assert.NoError(serial.Commit(d))
+	}
+	data := newBatchTestData(priv, parent, blocks[1:])
+	assert.NoError(batched.CommitBatch(data))
+
+	assert.EqualValues(3, batched.GetBlockHeight())
+	assert.Equal(serial.GetMerkleRoot(), batched.GetMerkleRoot())
+	assert.Equal(serial.merkleStore.getLeafCount(), batched.merkleStore.getLeafCount())
+	for _, key := range []string{"a", "b", "c", "d"} {
+		assert.Equal(serial.GetState(nil, []byte(key)), batched.VerifyState(nil, []byte(key)))
+	}
+	for i, d := range data {
+		_, err := batched.GetTx(d.Transactions[0].Hash())
+		assert.NoError(err)
+		txc, err := batched.GetTxCommit(d.Transactions[0].Hash())
+		assert.NoError(err)
+		assert.Equal(d.Block.Hash(), txc.BlockHash())
+		bcm, err := batched.GetBlockCommit(d.Block.Hash())
+		assert.NoError(err)
+		serialBcm, _ := serial.GetBlockCommit(d.Block.Hash())
+		assert.Equal(serialBcm.LeafCount(), bcm.LeafCount())
+		assert.Equal(len(serialBcm.StateChanges()), len(bcm.StateChanges()))
+		if i == len(data)-1 {
+			assert.Equal(serialBcm.MerkleRoot(), bcm.MerkleRoot())
+		} else {
+			assert.Nil(bcm.MerkleRoot(), "single merkle update for the batch")
+		}
+	}
+	value, err := batched.GetStateAtHeight(nil, []byte("a"), 0)
+	assert.NoError(err)
+	assert.Equal([]byte("1"), value, "versions of previous blocks in batch")
+	value, err = batched.GetStateAtHeight(nil, []byte("b"), 2)
+	assert.NoError(err)
+	assert.Equal([]byte("1"), value)
+}
+
+func TestStorage_CommitBatchRollback(t *testing.T) {
+	assert := assert.New(t)
+
+	strg := newTestStorage()
+	priv := core.GenerateKey(nil)
+	assert.NoError(strg.CommitBatch(newBatchTestData(priv, nil, [][][2]string{{{"a", "1"}}})))
+	root := strg.GetMerkleRoot()
+
+	parent, _ := strg.GetLastBlock()
+	data := newBatchTestData(priv, parent, [][][2]string{{{"a", "2"}}, {{"b", "1"}}, {{"c", "1"}}})
+	data[2].Transactions = nil // invalid body
+	assert.ErrorIs(strg.CommitBatch(data), core.ErrInvalidBlockBody)
+
+	assert.EqualValues(0, strg.GetBlockHeight())
+	assert.Equal(root, strg.GetMerkleRoot())
+	assert.Equal([]byte("1"), strg.GetState(nil, []byte("a")))
+	assert.Nil(strg.GetState(nil, []byte("b")))
+	_, err := strg.GetBlock(data[0].Block.Hash())
+	assert.Error(err)
+	assert.False(strg.HasTx(data[0].Transactions[0].Hash()))
+}
+
+func benchmarkCommitBlocks(b *testing.B, batchSize int) {
+	priv := core.GenerateKey(nil)
+	blocks := make([][][2]string, 1000)
+	for i := range blocks {
+		key := fmt.Sprintf("key%d", i%100)
+		blocks[i] = [][2]string{{key, fmt.Sprint(i)}}
+	}
+	for n := 0; n < b.N; n++ {
+		b.StopTimer()
+		strg := New(createOnMemoryDB(), DefaultConfig)
+		data := newBatchTestData(priv, nil, blocks)
+		b.StartTimer()
+		for i := 0; i < len(data); i += batchSize {
+			end := i + batchSize
+			if end > len(data) {
+				end = len(data)
+			}
+			var err error
+			if batchSize == 1 {
+				err = strg.Commit(data[i])
+			} else {
+				err = strg.CommitBatch(data[i:end])
+			}
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkStorage_CommitSequential(b *testing.B) {
+	benchmarkCommitBlocks(b, 1)
+}
+
+func BenchmarkStorage_CommitBatch(b *testing.B) {
+	benchmarkCommitBlocks(b, 100)
+}
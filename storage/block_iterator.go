@@ -0,0 +1,62 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/aungmawjj/juria-blockchain/core"
+	"github.com/dgraph-io/badger/v3"
+)
+
+// errors
+var (
+	// ErrStopIteration is returned by the callback of IterateBlocks to stop without error
+	ErrStopIteration = errors.New("stop iteration")
+)
+
+// IterateBlocks calls fn with the commited blocks from fromHeight up to the last block in order of height.
+// It reads one snapshot of the db, blocks commited during the iteration are not included.
+// A pruned block has no txs and its block commit is nil, see Prune.
+// It stops at the first error of fn, which is returned unless it's ErrStopIteration.
+func (strg *Storage) IterateBlocks(fromHeight uint64, fn func(*core.Block, *core.BlockCommit) error) error {
+	return strg.db.View(func(txn *badger.Txn) error {
+		cs := &chainStore{&txnGetter{txn}}
+		last, err := cs.getBlockHeight()
+		if err != nil {
+			return nil // no blocks yet
+		}
+		prefix := []byte{colBlockHashByHeight}
+		it := txn.NewIterator(badger.IteratorOptions{PrefetchValues: true, Prefix: prefix})
+		defer it.Close()
+		for it.Seek(concatBytes(prefix, uint64BEBytes(fromHeight))); it.Valid(); it.Next() {
+			if binary.BigEndian.Uint64(it.Item().Key()[len(prefix):]) > last {
+				return nil
+			}
+			hash, err := it.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			blk, err := cs.getBlock(hash)
+			if err != nil {
+				return fmt.Errorf("cannot load block %x, %w", hash, err)
+			}
+			bcm, err := cs.getBlockCommit(hash)
+			if errors.Is(err, badger.ErrKeyNotFound) {
+				bcm = nil // pruned
+			} else if err != nil {
+				return fmt.Errorf("cannot load block commit %d, %w", blk.Height(), err)
+			}
+			if err := fn(blk, bcm); err != nil {
+				if errors.Is(err, ErrStopIteration) {
+					return nil
+				}
+				return err
+			}
+		}
+		return nil
+	})
+}
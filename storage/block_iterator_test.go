@@ -0,0 +1,91 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package storage
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aungmawjj/juria-blockchain/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStorage_IterateBlocks(t *testing.T) {
+	assert := assert.New(t)
+
+	strg := newTestStorage()
+	called := false
+	assert.NoError(strg.IterateBlocks(0, func(*core.Block, *core.BlockCommit) error {
+		called = true
+		return nil
+	}))
+	assert.False(called, "no blocks")
+
+	commitTestBlocks(t, strg, 6)
+	assert.NoError(strg.PruneBefore(2))
+
+	heights := make([]uint64, 0)
+	assert.NoError(strg.IterateBlocks(1, func(blk *core.Block, bcm *core.BlockCommit) error {
+		heights = append(heights, blk.Height())
+		if blk.Height() < 2 {
+			assert.Nil(bcm, "pruned")
+		} else if assert.NotNil(bcm) {
+			assert.Equal(blk.Hash(), bcm.Hash())
+		}
+		return nil
+	}))
+	assert.Equal([]uint64{1, 2, 3, 4, 5}, heights)
+
+	heights = heights[:0]
+	assert.NoError(strg.IterateBlocks(3, func(blk *core.Block, bcm *core.BlockCommit) error {
+		heights = append(heights, blk.Height())
+		if blk.Height() == 4 {
+			return ErrStopIteration
+		}
+		return nil
+	}))
+	assert.Equal([]uint64{3, 4}, heights)
+
+	errFn := errors.New("fn error")
+	err := strg.IterateBlocks(0, func(*core.Block, *core.BlockCommit) error {
+		return errFn
+	})
+	assert.ErrorIs(err, errFn)
+
+	called = false
+	assert.NoError(strg.IterateBlocks(6, func(*core.Block, *core.BlockCommit) error {
+		called = true
+		return nil
+	}))
+	assert.False(called, "above the last block")
+}
+
+func TestStorage_SubscribeCommitted(t *testing.T) {
+	assert := assert.New(t)
+
+	strg := newTestStorage()
+	sub := strg.SubscribeCommitted(10)
+	defer sub.Unsubscribe()
+
+	commitTestBlocks(t, strg, 2)
+	parent, err := strg.GetLastBlock()
+	assert.NoError(err)
+	assert.NoError(strg.CommitBatch(newBatchTestData(core.GenerateKey(nil), parent,
+		[][][2]string{{{"a", "1"}}, {{"b", "2"}}})))
+
+	for h := uint64(0); h < 4; h++ {
+		select {
+		case e := <-sub.Events():
+			data := e.(*CommitData)
+			assert.Equal(h, data.Block.Height())
+			// written when emitted
+			blk, err := strg.GetBlockByHeight(h)
+			assert.NoError(err)
+			assert.Equal(data.Block.Hash(), blk.Hash())
+		case <-time.After(time.Second):
+			t.Fatalf("no commit event for block %d", h)
+		}
+	}
+}
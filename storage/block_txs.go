@@ -0,0 +1,123 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/aungmawjj/juria-blockchain/core"
+	"github.com/dgraph-io/badger/v3"
+)
+
+// errors
+var (
+	ErrBlockTxsNotFound = errors.New("block txs not found")
+)
+
+// MissingTxsError lists the txs of a block which are not found in storage.
+// It wraps ErrPruned if the block is pruned, ErrBlockTxsNotFound otherwise.
+type MissingTxsError struct {
+	BlockHash []byte
+	Hashes    [][]byte
+	Pruned    bool
+}
+
+func (e *MissingTxsError) Error() string {
+	return fmt.Sprintf("%v, %d txs of block %x: %x", e.Unwrap(), len(e.Hashes), e.BlockHash, e.Hashes)
+}
+
+func (e *MissingTxsError) Unwrap() error {
+	if e.Pruned {
+		return ErrPruned
+	}
+	return ErrBlockTxsNotFound
+}
+
+// GetBlockTxs returns the txs of the block in block order, read in one transaction.
+// Txs commited by older blocks are included. It returns *MissingTxsError if any tx is not found.
+func (strg *Storage) GetBlockTxs(blockHash []byte) ([]*core.Transaction, error) {
+	var txs []*core.Transaction
+	err := strg.viewBlockTxs(blockHash, func(cs *chainStore, blk *core.Block) ([][]byte, error) {
+		txs = make([]*core.Transaction, len(blk.TransactionsRef()))
+		missing := make([][]byte, 0)
+		for i, hash := range blk.TransactionsRef() {
+			tx, err := cs.getTx(hash)
+			if isNotFound(err) {
+				missing = append(missing, hash)
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			txs[i] = tx
+		}
+		return missing, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return txs, nil
+}
+
+// GetTxCommitsByBlock returns the tx commits of the block in block order, read in one transaction.
+// The commits of txs commited by older blocks are included.
+// It returns *MissingTxsError if any tx commit is not found.
+func (strg *Storage) GetTxCommitsByBlock(blockHash []byte) ([]*core.TxCommit, error) {
+	var txcs []*core.TxCommit
+	err := strg.viewBlockTxs(blockHash, func(cs *chainStore, blk *core.Block) ([][]byte, error) {
+		txcs = make([]*core.TxCommit, len(blk.TransactionsRef()))
+		missing := make([][]byte, 0)
+		for i, hash := range blk.TransactionsRef() {
+			txc, err := cs.getTxCommit(hash)
+			if isNotFound(err) {
+				missing = append(missing, hash)
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			txcs[i] = txc
+		}
+		return missing, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return txcs, nil
+}
+
+// viewBlockTxs reads the block and calls read with it in one db transaction,
+// read returns the hashes of the txs not found
+func (strg *Storage) viewBlockTxs(
+	blockHash []byte, read func(cs *chainStore, blk *core.Block) ([][]byte, error),
+) error {
+	return strg.db.View(func(txn *badger.Txn) error {
+		cs := &chainStore{&txnGetter{txn}}
+		blk, err := cs.getBlock(blockHash)
+		if err != nil {
+			return err
+		}
+		if _, err := cs.checkPruned(blk); err != nil {
+			return err // tx hashes are pruned with the block body
+		}
+		missing, err := read(cs, blk)
+		if err != nil {
+			return err
+		}
+		if len(missing) == 0 {
+			return nil
+		}
+		// txs commited by older blocks may be pruned before the block
+		pruned := false
+		for _, hash := range missing {
+			pruned = pruned || cs.isTxPruned(hash)
+		}
+		return &MissingTxsError{BlockHash: blockHash, Hashes: missing, Pruned: pruned}
+	})
+}
+
+func isNotFound(err error) bool {
+	return errors.Is(err, badger.ErrKeyNotFound) || errors.Is(err, ErrPruned)
+}
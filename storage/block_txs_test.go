@@ -0,0 +1,134 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package storage
+
+import (
+	"testing"
+
+	"github.com/aungmawjj/juria-blockchain/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStorage_GetBlockTxs(t *testing.T) {
+	assert := assert.New(t)
+
+	strg := newTestStorage()
+	priv := core.GenerateKey(nil)
+	txs := make([]*core.Transaction, 3)
+	hashes := make([][]byte, len(txs))
+	for i := range txs {
+		txs[i] = core.NewTransaction().SetNonce(int64(i)).Sign(priv)
+		hashes[i] = txs[i].Hash()
+	}
+	blk := core.NewBlock().SetHeight(0).SetTransactions(hashes).Sign(priv)
+	txcs := make([]*core.TxCommit, len(txs))
+	for i, tx := range txs {
+		txcs[i] = core.NewTxCommit().SetHash(tx.Hash()).SetBlockHash(blk.Hash()).
+			SetBlockHeight(blk.Height()).SetElapsed(float64(i+1) / 10)
+	}
+	assert.NoError(strg.Commit(&CommitData{
+		Block:        blk,
+		QC:           core.NewQuorumCert(),
+		Transactions: txs,
+		BlockCommit:  core.NewBlockCommit().SetHash(blk.Hash()),
+		TxCommits:    txcs,
+	}))
+
+	gotTxs, err := strg.GetBlockTxs(blk.Hash())
+	assert.NoError(err)
+	gotTxcs, err := strg.GetTxCommitsByBlock(blk.Hash())
+	assert.NoError(err)
+	if assert.Len(gotTxs, 3) && assert.Len(gotTxcs, 3) {
+		for i := range txs {
+			assert.Equal(hashes[i], gotTxs[i].Hash(), "block order")
+			assert.Equal(hashes[i], gotTxcs[i].Hash())
+			assert.Equal(blk.Height(), gotTxcs[i].BlockHeight())
+			assert.Equal(txcs[i].Elapsed(), gotTxcs[i].Elapsed())
+		}
+	}
+
+	_, err = strg.GetBlockTxs([]byte("unknown"))
+	assert.Error(err)
+
+	// block with a tx missing in storage
+	missing := core.NewTransaction().SetNonce(10).Sign(priv)
+	blk1 := core.NewBlock().SetHeight(1).SetParentHash(blk.Hash()).
+		SetQuorumCert(core.NewQuorumCert().Build([]*core.Vote{blk.ProposerVote()})).
+		SetTransactions([][]byte{missing.Hash()}).Sign(priv)
+	assert.NoError(updateBadgerDB(strg.db, strg.chainStore.setBlock(blk1)))
+	_, err = strg.GetBlockTxs(blk1.Hash())
+	var mErr *MissingTxsError
+	if assert.ErrorAs(err, &mErr) {
+		assert.Equal([][]byte{missing.Hash()}, mErr.Hashes)
+	}
+	assert.ErrorIs(err, ErrBlockTxsNotFound)
+	assert.NotErrorIs(err, ErrPruned)
+}
+
+func TestStorage_GetBlockTxsPruned(t *testing.T) {
+	assert := assert.New(t)
+
+	strg := newTestStorage()
+	commitTestBlocks(t, strg, 4)
+	assert.NoError(strg.PruneBefore(2))
+
+	blk, _ := strg.GetBlockHeaderByHeight(1)
+	_, err := strg.GetBlockTxs(blk.Hash())
+	assert.ErrorIs(err, ErrPruned)
+	_, err = strg.GetTxCommitsByBlock(blk.Hash())
+	assert.ErrorIs(err, ErrPruned)
+
+	blk, _ = strg.GetBlockByHeight(2)
+	txs, err := strg.GetBlockTxs(blk.Hash())
+	assert.NoError(err)
+	assert.Len(txs, 1)
+}
+
+func TestStorage_GetTxCommitsByBlock(t *testing.T) {
+	assert := assert.New(t)
+
+	strg := newTestStorage()
+	priv := core.GenerateKey(nil)
+	hashes := make([][]byte, 4)
+	txs := make([]*core.Transaction, len(hashes))
+	for i := range txs {
+		txs[i] = core.NewTransaction().SetNonce(int64(i)).Sign(priv)
+		hashes[i] = txs[i].Hash()
+	}
+	blk := core.NewBlock().SetHeight(0).SetTransactions(hashes).Sign(priv)
+	// tx commits are stored in another order than the block
+	txcs := make([]*core.TxCommit, len(txs))
+	for i := range txs {
+		tx := txs[len(txs)-1-i]
+		txcs[i] = core.NewTxCommit().SetHash(tx.Hash()).SetBlockHash(blk.Hash()).
+			SetBlockHeight(blk.Height()).SetElapsed(float64(i+1) / 10)
+	}
+	assert.NoError(strg.Commit(&CommitData{
+		Block:        blk,
+		QC:           core.NewQuorumCert(),
+		Transactions: txs,
+		BlockCommit:  core.NewBlockCommit().SetHash(blk.Hash()),
+		TxCommits:    txcs,
+	}))
+
+	got, err := strg.GetTxCommitsByBlock(blk.Hash())
+	assert.NoError(err)
+	if assert.Len(got, len(hashes)) {
+		for i, hash := range hashes {
+			assert.Equal(hash, got[i].Hash(), "block order")
+			assert.Equal(blk.Height(), got[i].BlockHeight())
+			assert.Equal(txcs[len(txcs)-1-i].Elapsed(), got[i].Elapsed())
+		}
+	}
+
+	_, err = strg.GetTxCommitsByBlock([]byte("unknown"))
+	assert.Error(err, "unknown block")
+
+	pruned := newTestStorage()
+	commitTestBlocks(t, pruned, 4)
+	assert.NoError(pruned.PruneBefore(2))
+	blk, _ = pruned.GetBlockHeaderByHeight(1)
+	_, err = pruned.GetTxCommitsByBlock(blk.Hash())
+	assert.ErrorIs(err, ErrPruned)
+}
@@ -6,8 +6,11 @@ package storage
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
+	"fmt"
 
 	"github.com/aungmawjj/juria-blockchain/core"
+	"github.com/dgraph-io/badger/v3"
 )
 
 type chainStore struct {
@@ -66,6 +69,18 @@ func (cs *chainStore) getLastQC() (*core.QuorumCert, error) {
 	return qc, nil
 }
 
+func (cs *chainStore) getQC(blockHash []byte) (*core.QuorumCert, error) {
+	b, err := cs.getter.Get(concatBytes([]byte{colQCByBlockHash}, blockHash))
+	if err != nil {
+		return nil, err
+	}
+	qc := core.NewQuorumCert()
+	if err := qc.Unmarshal(b); err != nil {
+		return nil, err
+	}
+	return qc, nil
+}
+
 func (cs *chainStore) getBlockCommit(hash []byte) (*core.BlockCommit, error) {
 	b, err := cs.getter.Get(concatBytes([]byte{colBlockCommitByHash}, hash))
 	if err != nil {
@@ -83,6 +98,9 @@ func (cs *chainStore) getTx(hash []byte) (*core.Transaction, error) {
 	if err != nil {
 		return nil, err
 	}
+	if len(b) == 0 {
+		return nil, fmt.Errorf("%w, tx %x", ErrPruned, hash)
+	}
 	tx := core.NewTransaction()
 	if err := tx.Unmarshal(b); err != nil {
 		return nil, err
@@ -96,6 +114,9 @@ func (cs *chainStore) hasTx(hash []byte) bool {
 
 func (cs *chainStore) getTxCommit(hash []byte) (*core.TxCommit, error) {
 	val, err := cs.getter.Get(concatBytes([]byte{colTxCommitByHash}, hash))
+	if errors.Is(err, badger.ErrKeyNotFound) && cs.isTxPruned(hash) {
+		return nil, fmt.Errorf("%w, tx commit %x", ErrPruned, hash)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -106,6 +127,24 @@ func (cs *chainStore) getTxCommit(hash []byte) (*core.TxCommit, error) {
 	return txc, nil
 }
 
+func (cs *chainStore) getGenesis() (*core.Genesis, error) {
+	b, err := cs.getter.Get([]byte{colGenesis})
+	if err != nil {
+		return nil, err
+	}
+	return core.UnmarshalGenesis(b)
+}
+
+func (cs *chainStore) setGenesis(g *core.Genesis) updateFunc {
+	return func(setter setter) error {
+		val, err := g.Marshal()
+		if err != nil {
+			return err
+		}
+		return setter.Set([]byte{colGenesis}, val)
+	}
+}
+
 func (cs *chainStore) setBlockHeight(height uint64) updateFunc {
 	return func(setter setter) error {
 		return setter.Set([]byte{colBlockHeight}, uint64BEBytes(height))
@@ -132,6 +171,19 @@ func (cs *chainStore) setLastQC(qc *core.QuorumCert) updateFunc {
 	}
 }
 
+func (cs *chainStore) setQC(blockHash []byte, qc *core.QuorumCert) updateFunc {
+	return func(setter setter) error {
+		if qc == nil {
+			return nil
+		}
+		val, err := qc.Marshal()
+		if err != nil {
+			return err
+		}
+		return setter.Set(concatBytes([]byte{colQCByBlockHash}, blockHash), val)
+	}
+}
+
 func (cs *chainStore) setBlockByHash(blk *core.Block) updateFunc {
 	return func(setter setter) error {
 		val, err := blk.Marshal()
@@ -163,10 +215,19 @@ func (cs *chainStore) setBlockCommit(bcm *core.BlockCommit) updateFunc {
 	}
 }
 
-func (cs *chainStore) setTxs(txs []*core.Transaction) []updateFunc {
-	ret := make([]updateFunc, len(txs))
-	for i, tx := range txs {
-		ret[i] = cs.setTx(tx)
+// setTxs writes the txs commited by the block with their sender index entries if indexSender
+func (cs *chainStore) setTxs(blk *core.Block, txs []*core.Transaction, indexSender bool) []updateFunc {
+	txIdx := make(map[string]uint32, len(blk.TransactionsRef()))
+	for i, hash := range blk.TransactionsRef() {
+		txIdx[string(hash)] = uint32(i)
+	}
+	ret := make([]updateFunc, 0, 2*len(txs))
+	for _, tx := range txs {
+		ret = append(ret, cs.setTx(tx))
+		if indexSender && tx.Sender() != nil {
+			key := txBySenderKey(tx.Sender(), blk.Height(), txIdx[string(tx.Hash())])
+			ret = append(ret, cs.setTxBySender(key, tx.Hash()))
+		}
 	}
 	return ret
 }
@@ -191,6 +252,19 @@ func (cs *chainStore) setTx(tx *core.Transaction) updateFunc {
 	}
 }
 
+func (cs *chainStore) setTxBySender(key, txHash []byte) updateFunc {
+	return func(setter setter) error {
+		return setter.Set(key, txHash)
+	}
+}
+
+// txBySenderKey orders the txs of a sender address by block height and index in block
+func txBySenderKey(sender *core.PublicKey, height uint64, txIdx uint32) []byte {
+	idx := make([]byte, 4)
+	binary.BigEndian.PutUint32(idx, txIdx)
+	return concatBytes([]byte{colTxHashBySender}, sender.Address().Bytes(), uint64BEBytes(height), idx)
+}
+
 func (cs *chainStore) setTxCommit(txc *core.TxCommit) updateFunc {
 	return func(setter setter) error {
 		val, err := txc.Marshal()
@@ -0,0 +1,107 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/aungmawjj/juria-blockchain/logger"
+	"github.com/dgraph-io/badger/v3"
+)
+
+// commitStage is a part of the commit written in its own transaction
+type commitStage int
+
+const (
+	stageMarker commitStage = iota
+	stageBlockCommit
+	stageChainData
+)
+
+func (strg *Storage) setCommitInProgress(blkHash []byte) error {
+	return updateBadgerDB(strg.db, []updateFunc{
+		func(setter setter) error {
+			return setter.Set([]byte{colCommitInProgress}, blkHash)
+		},
+	})
+}
+
+func deleteCommitInProgress() updateFunc {
+	return func(setter setter) error {
+		return setter.Delete([]byte{colCommitInProgress})
+	}
+}
+
+// recoverCommit rolls back the chain data of a half-applied commit.
+// State, merkle tree and block height are written together with the marker removal,
+// so they are still at the previous block and only the chain data needs to be removed.
+func (strg *Storage) recoverCommit() error {
+	blkHash, err := strg.chainStore.getter.Get([]byte{colCommitInProgress})
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	updFns, err := strg.rollbackChainData(blkHash)
+	if err != nil {
+		return fmt.Errorf("rollback block %x, %w", blkHash, err)
+	}
+	updFns = append(updFns, deleteCommitInProgress())
+	if err := updateBadgerDB(strg.db, updFns); err != nil {
+		return err
+	}
+	logger.I().Warnw("rolled back half-applied commit", "block", blkHash)
+	return nil
+}
+
+func (strg *Storage) rollbackChainData(blkHash []byte) ([]updateFunc, error) {
+	updFns := make([]updateFunc, 0)
+	bcm, err := strg.chainStore.getBlockCommit(blkHash)
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return updFns, nil // interrupted before block commit
+	}
+	if err != nil {
+		return nil, err
+	}
+	updFns = append(updFns, deleteKey(concatBytes([]byte{colBlockCommitByHash}, blkHash)))
+	blk, err := strg.chainStore.getBlock(blkHash)
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return updFns, nil // interrupted before chain data
+	}
+	if err != nil {
+		return nil, err
+	}
+	hash, err := strg.chainStore.getBlockHashByHeight(blk.Height())
+	if err == nil && bytes.Equal(hash, blkHash) {
+		updFns = append(updFns, deleteKey(
+			concatBytes([]byte{colBlockHashByHeight}, uint64BEBytes(blk.Height()))))
+	}
+	updFns = append(updFns, deleteKey(concatBytes([]byte{colBlockByHash}, blkHash)))
+	updFns = append(updFns, deleteKey(concatBytes([]byte{colQCByBlockHash}, blkHash)))
+	oldTxs := make(map[string]struct{}, len(bcm.OldBlockTxs()))
+	for _, hash := range bcm.OldBlockTxs() {
+		oldTxs[string(hash)] = struct{}{}
+	}
+	for i, txHash := range blk.TransactionsRef() {
+		if _, found := oldTxs[string(txHash)]; found {
+			continue // commited by older block
+		}
+		if tx, err := strg.chainStore.getTx(txHash); err == nil && tx.Sender() != nil {
+			updFns = append(updFns, deleteKey(
+				txBySenderKey(tx.Sender(), blk.Height(), uint32(i))))
+		}
+		updFns = append(updFns, deleteKey(concatBytes([]byte{colTxByHash}, txHash)))
+		updFns = append(updFns, deleteKey(concatBytes([]byte{colTxCommitByHash}, txHash)))
+	}
+	return updFns, nil
+}
+
+func deleteKey(key []byte) updateFunc {
+	return func(setter setter) error {
+		return setter.Delete(key)
+	}
+}
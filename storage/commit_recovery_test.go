@@ -0,0 +1,146 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package storage
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aungmawjj/juria-blockchain/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStorage_RecoverCommit(t *testing.T) {
+	errCrash := errors.New("crash")
+	stages := []struct {
+		name  string
+		stage commitStage
+	}{
+		{"after marker", stageMarker},
+		{"after block commit", stageBlockCommit},
+		{"after chain data", stageChainData},
+	}
+	for _, tt := range stages {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			db := createOnMemoryDB()
+			strg := New(db, DefaultConfig)
+			priv := core.GenerateKey(nil)
+
+			tx1 := core.NewTransaction().SetNonce(1).Sign(priv)
+			tx2 := core.NewTransaction().SetNonce(2).Sign(priv)
+
+			b0 := core.NewBlock().SetHeight(0).SetTransactions([][]byte{tx1.Hash()}).Sign(priv)
+			q0 := core.NewQuorumCert().Build([]*core.Vote{b0.ProposerVote()})
+			assert.NoError(strg.Commit(&CommitData{
+				Block:        b0,
+				QC:           q0,
+				Transactions: []*core.Transaction{tx1},
+				TxCommits:    []*core.TxCommit{core.NewTxCommit().SetHash(tx1.Hash()).SetBlockHash(b0.Hash())},
+				BlockCommit: core.NewBlockCommit().SetHash(b0.Hash()).
+					SetStateChanges([]*core.StateChange{
+						core.NewStateChange().SetKey([]byte{1}).SetValue([]byte{10}),
+					}),
+			}))
+			root0 := strg.GetMerkleRoot()
+
+			// b1 includes tx1 commited by b0
+			b1 := core.NewBlock().
+				SetHeight(1).
+				SetQuorumCert(q0).
+				SetParentHash(b0.Hash()).
+				SetTransactions([][]byte{tx1.Hash(), tx2.Hash()}).
+				Sign(priv)
+			q1 := core.NewQuorumCert().Build([]*core.Vote{b1.ProposerVote()})
+			newData := func() *CommitData {
+				return &CommitData{
+					Block:        b1,
+					QC:           q1,
+					Transactions: []*core.Transaction{tx2},
+					TxCommits:    []*core.TxCommit{core.NewTxCommit().SetHash(tx2.Hash()).SetBlockHash(b1.Hash())},
+					BlockCommit: core.NewBlockCommit().SetHash(b1.Hash()).
+						SetOldBlockTxs([][]byte{tx1.Hash()}).
+						SetStateChanges([]*core.StateChange{
+							core.NewStateChange().SetKey([]byte{1}).SetValue([]byte{20}),
+							core.NewStateChange().SetKey([]byte{2}).SetValue([]byte{30}),
+						}),
+				}
+			}
+			strg.afterStage = func(stage commitStage) error {
+				if stage == tt.stage {
+					return errCrash
+				}
+				return nil
+			}
+			assert.ErrorIs(strg.Commit(newData()), errCrash)
+
+			// reopen
+			strg = New(db, DefaultConfig)
+			assert.False(strg.chainStore.getter.HasKey([]byte{colCommitInProgress}))
+
+			assert.EqualValues(0, strg.GetBlockHeight())
+			blk, err := strg.GetLastBlock()
+			assert.NoError(err)
+			assert.Equal(b0.Hash(), blk.Hash())
+			qc, err := strg.GetLastQC()
+			assert.NoError(err)
+			assert.Equal(b0.Hash(), qc.BlockHash())
+
+			_, err = strg.GetBlock(b1.Hash())
+			assert.Error(err)
+			_, err = strg.GetBlockByHeight(1)
+			assert.Error(err)
+			_, err = strg.GetBlockCommit(b1.Hash())
+			assert.Error(err)
+			_, err = strg.GetQC(b1.Hash())
+			assert.Error(err)
+			assert.True(strg.HasTx(tx1.Hash()), "tx of older block must be kept")
+			assert.False(strg.HasTx(tx2.Hash()))
+			assert.False(strg.chainStore.getter.HasKey(
+				txBySenderKey(priv.PublicKey(), 1, 1)), "sender index of rolled back tx")
+			_, err = strg.GetTxCommit(tx2.Hash())
+			assert.Error(err)
+
+			assert.Equal(root0, strg.GetMerkleRoot())
+			assert.Equal([]byte{10}, strg.GetState(nil, []byte{1}))
+			assert.Nil(strg.GetState(nil, []byte{2}))
+
+			// commit again after recovery
+			assert.NoError(strg.Commit(newData()))
+			assert.EqualValues(1, strg.GetBlockHeight())
+			assert.True(strg.HasTx(tx2.Hash()))
+			txs, err := strg.GetTxsBySender(priv.PublicKey().Address().Bytes(), 0, 10)
+			assert.NoError(err)
+			assert.Len(txs, 1, "tx1 at height 0 is indexed once")
+			assert.Equal([]byte{20}, strg.GetState(nil, []byte{1}))
+			assert.Equal([]byte{30}, strg.GetState(nil, []byte{2}))
+			qc, err = strg.GetLastQC()
+			assert.NoError(err)
+			assert.Equal(b1.Hash(), qc.BlockHash())
+		})
+	}
+}
+
+func TestStorage_RecoverCommitNothingToDo(t *testing.T) {
+	assert := assert.New(t)
+
+	db := createOnMemoryDB()
+	strg := New(db, DefaultConfig)
+	priv := core.GenerateKey(nil)
+	b0 := core.NewBlock().SetHeight(0).Sign(priv)
+	assert.NoError(strg.Commit(&CommitData{
+		Block:       b0,
+		QC:          core.NewQuorumCert(),
+		BlockCommit: core.NewBlockCommit().SetHash(b0.Hash()),
+	}))
+	assert.False(strg.chainStore.getter.HasKey([]byte{colCommitInProgress}))
+
+	strg = New(db, DefaultConfig)
+	blk, err := strg.GetBlock(b0.Hash())
+	assert.NoError(err)
+	assert.Equal(b0.Hash(), blk.Hash())
+	_, err = strg.GetBlockCommit(b0.Hash())
+	assert.NoError(err)
+}
@@ -5,33 +5,99 @@ package storage
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 
 	"github.com/dgraph-io/badger/v3"
+	"github.com/dgraph-io/badger/v3/options"
 )
 
 // data collection prefixes for different data collections
 const (
-	colBlockByHash           byte = iota + 1 // block by hash
-	colBlockHashByHeight                     // block hash by height
-	colBlockHeight                           // last block height
-	colLastQC                                // qc for last commited block to be used on restart
-	colBlockCommitByHash                     // block commit by block hash
-	colTxCount                               // total commited tx count
-	colTxByHash                              // tx by hash
-	colTxCommitByHash                        // tx commit info by tx hash
-	colStateValueByKey                       // state value by state key
-	colMerkleIndexByStateKey                 // tree leaf index by state key
-	colMerkleTreeHeight                      // tree height
-	colMerkleLeafCount                       // tree leaf count
-	colMerkleNodeByPosition                  // tree node value by position
+	colBlockByHash             byte = iota + 1 // block by hash
+	colBlockHashByHeight                       // block hash by height
+	colBlockHeight                             // last block height
+	colLastQC                                  // qc for last commited block to be used on restart
+	colBlockCommitByHash                       // block commit by block hash
+	colTxCount                                 // total commited tx count
+	colTxByHash                                // tx by hash
+	colTxCommitByHash                          // tx commit info by tx hash
+	colStateValueByKey                         // state value by state key
+	colMerkleIndexByStateKey                   // tree leaf index by state key
+	colMerkleTreeHeight                        // tree height
+	colMerkleLeafCount                         // tree leaf count
+	colLegacyMerkleNode                        // tree node value by legacy position, see MigrateMerkleNodes
+	colGenesis                                 // genesis stored on first boot
+	colMerkleLeafCountByHeight                 // tree leaf count by block height
+	colCommitInProgress                        // hash of the block being commited
+	colMerkleRootByLeafCount                   // tree root by leaf count
+	colPrunedHeight                            // lowest height of blocks not pruned
+	colSnapshotImport                          // digest and imported entries of snapshot in progress
+	colTxHashBySender                          // tx hash by sender, block height and tx index
+	colStateVersion                            // state value by key and block height
+	colStateHistoryStart                       // lowest block height of state history
+	colRestoredVersion                         // next version of the last backup restored
+	colQCByBlockHash                           // qc by hash of the block it certifies
+	colStateNamespaces                         // state keys migration to namespaces
+	colMerkleRebuild                           // leaf by padded leaf index while rebuilding the tree
+	colMetadata                                // format version, branch factor and state hash of the db
+	colMerkleNodeByPosition                    // tree node value by position
+	colMerkleNodeVersion                       // tree node value by position and block height
+	colMerkleHistoryStart                      // lowest block height of merkle tree history
+	colMerkleCheckpoint                        // whether the tree is kept at the block height which changed it
+	colEpochValidatorSet                       // validator set value by activation epoch
 )
 
 func NewDB(path string) (*badger.DB, error) {
 	return badger.Open(badger.DefaultOptions(path))
 }
 
+// Compression of db blocks
+type Compression string
+
+// compressions
+const (
+	CompressionNone   Compression = "none"
+	CompressionSnappy Compression = "snappy"
+	CompressionZSTD   Compression = "zstd"
+)
+
+func (c Compression) badgerType() (options.CompressionType, error) {
+	switch c {
+	case CompressionNone:
+		return options.None, nil
+	case CompressionSnappy:
+		return options.Snappy, nil
+	case CompressionZSTD:
+		return options.ZSTD, nil
+	}
+	return 0, fmt.Errorf("unknown compression %q", c)
+}
+
+func (config Config) badgerOptions(dir string) (badger.Options, error) {
+	opts := badger.DefaultOptions(dir).WithSyncWrites(config.SyncWrites)
+	if config.InMemory {
+		opts = badger.DefaultOptions("").WithInMemory(true) // nothing to sync
+	}
+	if config.ValueLogFileSize > 0 {
+		opts = opts.WithValueLogFileSize(config.ValueLogFileSize)
+	}
+	if config.NumCompactors > 0 {
+		opts = opts.WithNumCompactors(config.NumCompactors)
+	}
+	if config.Compression != "" {
+		c, err := config.Compression.badgerType()
+		if err != nil {
+			return opts, err
+		}
+		opts = opts.WithCompression(c)
+	}
+	return opts, nil
+}
+
 type setter interface {
 	Set(key, value []byte) error
+	Delete(key []byte) error
 }
 
 type updateFunc func(setter setter) error
@@ -39,6 +105,9 @@ type updateFunc func(setter setter) error
 type getter interface {
 	Get(key []byte) ([]byte, error)
 	HasKey(key []byte) bool
+
+	// GetValues reads the keys in one transaction, the value is nil for a missing key
+	GetValues(keys [][]byte) ([][]byte, error)
 }
 
 type badgerGetter struct {
@@ -57,6 +126,16 @@ func (bg *badgerGetter) Get(key []byte) ([]byte, error) {
 	return val, err
 }
 
+func (bg *badgerGetter) GetValues(keys [][]byte) ([][]byte, error) {
+	var values [][]byte
+	err := bg.db.View(func(txn *badger.Txn) error {
+		var err error
+		values, err = (&txnGetter{txn}).GetValues(keys)
+		return err
+	})
+	return values, err
+}
+
 func (bg *badgerGetter) HasKey(key []byte) bool {
 	err := bg.db.View(func(txn *badger.Txn) error {
 		_, err := txn.Get(key)
@@ -65,6 +144,39 @@ func (bg *badgerGetter) HasKey(key []byte) bool {
 	return err == nil
 }
 
+// txnGetter reads in one transaction, so that the values of many reads are consistent
+type txnGetter struct {
+	txn *badger.Txn
+}
+
+func (tg *txnGetter) Get(key []byte) ([]byte, error) {
+	item, err := tg.txn.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return item.ValueCopy(nil)
+}
+
+func (tg *txnGetter) HasKey(key []byte) bool {
+	_, err := tg.txn.Get(key)
+	return err == nil
+}
+
+func (tg *txnGetter) GetValues(keys [][]byte) ([][]byte, error) {
+	values := make([][]byte, len(keys))
+	for i, key := range keys {
+		val, err := tg.Get(key)
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		values[i] = val
+	}
+	return values, nil
+}
+
 func updateBadgerDB(db *badger.DB, fns []updateFunc) error {
 	return db.Update(func(txn *badger.Txn) error {
 		for _, fn := range fns {
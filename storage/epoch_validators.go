@@ -0,0 +1,46 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package storage
+
+import (
+	"encoding/binary"
+
+	"github.com/aungmawjj/juria-blockchain/core"
+	"github.com/dgraph-io/badger/v3"
+)
+
+var _ core.EpochSnapshotStore = (*Storage)(nil)
+
+// SetEpochValidatorSet records the validator set value snapshotted for the epoch it becomes active.
+// The snapshots are kept outside the state, as the set is overwritten in state.
+func (strg *Storage) SetEpochValidatorSet(epoch uint64, value []byte) error {
+	if strg.readOnly {
+		return ErrReadOnly
+	}
+	return updateBadgerDB(strg.db, []updateFunc{func(setter setter) error {
+		return setter.Set(concatBytes([]byte{colEpochValidatorSet}, uint64BEBytes(epoch)), value)
+	}})
+}
+
+// GetEpochValidatorSets returns the recorded validator set values by activation epoch
+func (strg *Storage) GetEpochValidatorSets() (map[uint64][]byte, error) {
+	values := make(map[uint64][]byte)
+	err := strg.db.View(func(txn *badger.Txn) error {
+		prefix := []byte{colEpochValidatorSet}
+		it := txn.NewIterator(badger.IteratorOptions{PrefetchValues: true, Prefix: prefix})
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			val, err := it.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			values[binary.BigEndian.Uint64(it.Item().Key()[1:])] = val
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
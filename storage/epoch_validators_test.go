@@ -0,0 +1,31 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package storage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStorage_EpochValidatorSets(t *testing.T) {
+	assert := assert.New(t)
+
+	strg := New(createOnMemoryDB(), DefaultConfig)
+	values, err := strg.GetEpochValidatorSets()
+	assert.NoError(err)
+	assert.Empty(values)
+
+	assert.NoError(strg.SetEpochValidatorSet(0, nil))
+	assert.NoError(strg.SetEpochValidatorSet(2, []byte("set 2")))
+	assert.NoError(strg.SetEpochValidatorSet(300, []byte("set 300")))
+	assert.NoError(strg.SetEpochValidatorSet(2, []byte("set 2 again")))
+
+	values, err = strg.GetEpochValidatorSets()
+	assert.NoError(err)
+	assert.Len(values, 3)
+	assert.Empty(values[0])
+	assert.Equal([]byte("set 2 again"), values[2])
+	assert.Equal([]byte("set 300"), values[300])
+}
@@ -0,0 +1,91 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/aungmawjj/juria-blockchain/logger"
+	"github.com/dgraph-io/badger/v3"
+)
+
+// StartGC runs value log gc every interval until ctx is done or the storage is closed.
+// Gc waits for the commit in progress, and commits wait for gc, so that gc never races a write.
+func (strg *Storage) StartGC(ctx context.Context, interval time.Duration, discardRatio float64) {
+	if strg.readOnly {
+		return
+	}
+	strg.wgLoops.Add(1)
+	go func() {
+		defer strg.wgLoops.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-strg.closeCh:
+				return
+			case <-ticker.C:
+				if err := strg.RunValueLogGC(discardRatio); err != nil {
+					logger.I().Errorw("value log gc failed", "error", err)
+				}
+			}
+		}
+	}()
+	logger.I().Infow("started value log gc", "interval", interval, "discardRatio", discardRatio)
+}
+
+// RunValueLogGC rewrites value log files until nothing is left to reclaim
+func (strg *Storage) RunValueLogGC(discardRatio float64) error {
+	if strg.readOnly {
+		return ErrReadOnly
+	}
+	strg.mtxCommit.Lock()
+	defer strg.mtxCommit.Unlock()
+
+	_, before := strg.db.Size()
+	rewrites := 0
+	for {
+		err := strg.db.RunValueLogGC(discardRatio)
+		if errors.Is(err, badger.ErrNoRewrite) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		rewrites++
+	}
+	if rewrites > 0 {
+		_, after := strg.db.Size()
+		logger.I().Infow("value log gc done", "rewrites", rewrites, "reclaimed", before-after)
+	}
+	return nil
+}
+
+// Close stops the gc and prune loops and waits for them to return.
+// The db is closed only if it is opened by OpenReadOnly, otherwise it is owned by the caller.
+func (strg *Storage) Close() error {
+	var err error
+	strg.closeOnce.Do(func() {
+		close(strg.closeCh)
+		strg.wgLoops.Wait()
+		if strg.readOnly || strg.ownsDB {
+			err = strg.db.Close()
+		}
+	})
+	return err
+}
+
+// isClosed reports whether Close is called, long running loops check it to return early
+func (strg *Storage) isClosed() bool {
+	select {
+	case <-strg.closeCh:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/stretchr/testify/assert"
+)
+
+func countVlogFiles(t *testing.T, dir string) int {
+	files, err := filepath.Glob(filepath.Join(dir, "*.vlog"))
+	assert.NoError(t, err)
+	return len(files)
+}
+
+func TestStorage_StartGC(t *testing.T) {
+	if testing.Short() {
+		t.Skip("long running")
+	}
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	// small value log files with values stored in them, so that overwrites leave garbage
+	opts := badger.DefaultOptions(dir).
+		WithLogger(nil).
+		WithValueLogFileSize(1 << 20).
+		WithValueThreshold(64).
+		WithMemTableSize(1 << 20).
+		WithNumLevelZeroTables(1).
+		WithNumLevelZeroTablesStall(2)
+	db, err := badger.Open(opts)
+	assert.NoError(err)
+	defer db.Close()
+
+	strg := New(db, DefaultConfig)
+	defer strg.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	strg.StartGC(ctx, 50*time.Millisecond, 0.5)
+
+	value := bytes.Repeat([]byte("v"), 1024)
+	const keys = 100
+	commit := func(round int) {
+		kvs := make([][2]string, keys)
+		for i := range kvs {
+			kvs[i] = [2]string{fmt.Sprintf("key-%d", i), fmt.Sprintf("%d-%s", round, value)}
+		}
+		commitStateBlocks(t, strg, [][][2]string{kvs})
+		// block commits have the values too
+		assert.NoError(strg.Prune(10))
+	}
+
+	// gc needs discard stats from compaction
+	files := make([]int, 0)
+	for round := 0; round < 400; round++ {
+		commit(round)
+		if round%50 == 49 {
+			assert.NoError(db.Flatten(1))
+			time.Sleep(200 * time.Millisecond)
+			files = append(files, countVlogFiles(t, dir))
+		}
+	}
+	t.Log("vlog files", files, "written", strg.Metrics().CommitBytes)
+	half := len(files) / 2
+	maxEarly := 0
+	for _, n := range files[:half] {
+		if n > maxEarly {
+			maxEarly = n
+		}
+	}
+	for _, n := range files[half:] {
+		assert.LessOrEqual(n, 2*maxEarly, "vlog files stop growing")
+	}
+	written := int(strg.Metrics().CommitBytes / uint64(opts.ValueLogFileSize))
+	assert.Less(files[len(files)-1], written/2)
+	assert.Equal(fmt.Sprintf("399-%s", value), string(strg.GetState(nil, []byte("key-1"))))
+}
@@ -0,0 +1,341 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/aungmawjj/juria-blockchain/core"
+	"github.com/aungmawjj/juria-blockchain/logger"
+	"github.com/aungmawjj/juria-blockchain/merkle"
+	"github.com/dgraph-io/badger/v3"
+)
+
+/*
+Merkle node versions are written with the tree updates of each block while KeepMerkleVersions is set,
+so that the tree can be read at the recent heights to prove the state at them.
+
+	version key: column, position, block height
+
+The history works like the state history. A node without versions has not changed since
+the history start, its current value is the value at every height of the history.
+When a node changes for the first time after the start, its previous value is recorded
+as the version at the start height.
+
+A checkpoint is recorded at each height which changes the tree. A batch writes the tree
+once at the last block changing it, the checkpoints of the other blocks changing it
+mark the tree is not kept at their heights.
+
+The history is dropped when the storage is opened with KeepMerkleVersions unset,
+as the versions are missing for the blocks commited meanwhile.
+*/
+
+// merkleHistory is the merkle.VersionedStore of the tree at block heights
+type merkleHistory struct {
+	*merkleStore
+	db *badger.DB
+}
+
+var _ merkle.VersionedStore = (*merkleHistory)(nil)
+
+// GetMerkleRootAtHeight returns the state root after the block at height is commited, nil if the state is empty.
+// It returns merkle.ErrVersionPruned if the height is before the tree history kept, see KeepMerkleVersions.
+func (strg *Storage) GetMerkleRootAtHeight(height uint64) ([]byte, error) {
+	strg.mtxWriteState.RLock()
+	defer strg.mtxWriteState.RUnlock()
+
+	root, err := strg.merkleHistoryTree().RootAt(height)
+	if err != nil || root == nil {
+		return nil, err
+	}
+	return root.Data, nil
+}
+
+// ProveStateAtHeight returns the inclusion proof of the state leaf of key against the state root at height,
+// see GetMerkleRootAtHeight. The leaf is the hash of the key and its value at height, see GetStateAtHeight,
+// or the cleared leaf if the key was deleted. Keys are the stored keys, see core.StateKey.
+// It returns merkle.ErrVersionPruned if the height is before the tree history kept.
+func (strg *Storage) ProveStateAtHeight(codeAddr, key []byte, height uint64) (*merkle.Proof, error) {
+	strg.mtxWriteState.RLock()
+	defer strg.mtxWriteState.RUnlock()
+
+	key = core.StateKey(codeAddr, key)
+	mh := &merkleHistory{strg.merkleStore, strg.db}
+	tree, err := merkle.NewTree(mh, strg.merkleConfig).AtVersion(height)
+	if err != nil {
+		return nil, err
+	}
+	merkleIdx, err := strg.stateStore.getMerkleIndex(key)
+	if err != nil {
+		return nil, fmt.Errorf("state not found %x, %w", key, err)
+	}
+	p := merkle.NewPosition(0, big.NewInt(0).SetBytes(merkleIdx))
+	if tree.Stats().LeafCount.Cmp(p.Index()) != 1 {
+		return nil, fmt.Errorf("state not found %x at height %d", key, height)
+	}
+	data, err := mh.GetNodeAt(p, height)
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return nil, fmt.Errorf("state leaf %x, %w", key, merkle.ErrLeafNotFound)
+	}
+	proof, err := tree.GenerateProof(&merkle.Node{Position: p, Data: data})
+	if err != nil {
+		return nil, fmt.Errorf("state leaf %x, %w", key, err)
+	}
+	return proof, nil
+}
+
+func (strg *Storage) merkleHistoryTree() *merkle.Tree {
+	return merkle.NewTree(&merkleHistory{strg.merkleStore, strg.db}, strg.merkleConfig)
+}
+
+// GetLeafCountAt implement merkle.VersionedStore
+func (mh *merkleHistory) GetLeafCountAt(height uint64) (*big.Int, error) {
+	if err := mh.checkHeight(height); err != nil {
+		return nil, err
+	}
+	return mh.getLeafCountAtHeight(height)
+}
+
+// GetNodeAt implement merkle.VersionedStore
+func (mh *merkleHistory) GetNodeAt(p *merkle.Position, height uint64) ([]byte, error) {
+	values, err := mh.GetNodesAt([]*merkle.Position{p}, height)
+	if err != nil {
+		return nil, err
+	}
+	return values[0], nil
+}
+
+// GetNodesAt implement merkle.VersionedStore
+func (mh *merkleHistory) GetNodesAt(positions []*merkle.Position, height uint64) ([][]byte, error) {
+	if err := mh.checkHeight(height); err != nil {
+		return nil, err
+	}
+	values := make([][]byte, len(positions))
+	unchanged := make([]*merkle.Position, 0)
+	unchangedIdx := make([]int, 0)
+	err := mh.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{Reverse: true, Prefix: []byte{colMerkleNodeVersion}})
+		defer it.Close()
+		for i, p := range positions {
+			prefix := merkleNodeVersionPrefix(p)
+			// reverse seek finds the newest version at or below height
+			it.Seek(concatBytes(prefix, uint64BEBytes(height)))
+			if it.ValidForPrefix(prefix) {
+				val, err := it.Item().ValueCopy(nil)
+				if err != nil {
+					return err
+				}
+				values[i] = val
+				continue
+			}
+			if !hasPrefixKey(txn, prefix) {
+				unchanged = append(unchanged, p)
+				unchangedIdx = append(unchangedIdx, i)
+			} // else created after height
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i, val := range mh.GetNodes(unchanged) {
+		values[unchangedIdx[i]] = val
+	}
+	return values, nil
+}
+
+// checkHeight checks whether the tree is kept at height
+func (mh *merkleHistory) checkHeight(height uint64) error {
+	lastHeight, err := (&chainStore{mh.getter}).getBlockHeight()
+	if err != nil || height > lastHeight {
+		return fmt.Errorf("%w, height %d", merkle.ErrVersionNotFound, height)
+	}
+	start, err := mh.getHistoryStart()
+	if err != nil || height < start {
+		return fmt.Errorf("%w, merkle history at %d", merkle.ErrVersionPruned, height)
+	}
+	return mh.db.View(func(txn *badger.Txn) error {
+		prefix := []byte{colMerkleCheckpoint}
+		it := txn.NewIterator(badger.IteratorOptions{Reverse: true, Prefix: prefix})
+		defer it.Close()
+		it.Seek(concatBytes(prefix, uint64BEBytes(height)))
+		if !it.Valid() {
+			return nil
+		}
+		return it.Item().Value(func(val []byte) error {
+			if !bytes.Equal(val, []byte{1}) {
+				return fmt.Errorf("%w, merkle tree at %d is commited in a batch", merkle.ErrVersionNotFound, height)
+			}
+			return nil
+		})
+	})
+}
+
+// writeMerkleVersions records the nodes of the tree update at height,
+// and the previous values of the nodes changed for the first time since history start.
+// changed is set if the block changes the tree, the update is nil for the blocks of a batch.
+func (strg *Storage) writeMerkleVersions(upd *merkle.UpdateResult, height uint64, changed bool) ([]updateFunc, error) {
+	updFns := make([]updateFunc, 0)
+	start, err := strg.merkleStore.getHistoryStart()
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		start = height
+		updFns = append(updFns, strg.merkleStore.setHistoryStart(height))
+	} else if err != nil {
+		return nil, err
+	}
+	if upd == nil {
+		if changed {
+			updFns = append(updFns, strg.merkleStore.setCheckpoint(height, false))
+		}
+		return updFns, nil
+	}
+	nodes := make([]*merkle.Node, 0, len(upd.Leaves)+len(upd.Branches))
+	nodes = append(nodes, upd.Leaves...)
+	nodes = append(nodes, upd.Branches...)
+	first := make([]*merkle.Position, 0)
+	if height > start {
+		err = strg.db.View(func(txn *badger.Txn) error {
+			for _, n := range nodes {
+				if !strg.hasVersions(txn, merkleNodeVersionPrefix(n.Position)) {
+					first = append(first, n.Position)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	for i, val := range strg.merkleStore.GetNodes(first) {
+		if val != nil {
+			updFns = append(updFns, strg.merkleStore.setNodeVersion(first[i], start, val))
+		}
+	}
+	for _, n := range nodes {
+		updFns = append(updFns, strg.merkleStore.setNodeVersion(n.Position, height, n.Data))
+	}
+	updFns = append(updFns, strg.merkleStore.setCheckpoint(height, true))
+	return updFns, nil
+}
+
+// PruneMerkleVersions deletes the merkle node versions which are not needed to read
+// the tree at the last keepVersions blocks, like PruneStateVersions.
+// It scans all versions, so it is meant for background pruning.
+func (strg *Storage) PruneMerkleVersions(keepVersions uint64) error {
+	if strg.readOnly {
+		return ErrReadOnly
+	}
+	strg.mtxPrune.Lock()
+	defer strg.mtxPrune.Unlock()
+
+	height, err := strg.chainStore.getBlockHeight()
+	if err != nil || height < keepVersions {
+		return nil
+	}
+	target := height - keepVersions
+	start, err := strg.merkleStore.getHistoryStart()
+	if err != nil || start >= target {
+		return nil
+	}
+	keys := make([][]byte, 0)
+	err = strg.db.View(func(txn *badger.Txn) error {
+		for _, col := range []byte{colMerkleNodeVersion, colMerkleCheckpoint} {
+			it := txn.NewIterator(badger.IteratorOptions{Prefix: []byte{col}})
+			var prev []byte // newest version at or below target of the previous node
+			for it.Rewind(); it.Valid(); it.Next() {
+				key := it.Item().KeyCopy(nil)
+				if binary.BigEndian.Uint64(key[len(key)-8:]) > target {
+					continue
+				}
+				if prev != nil && bytes.Equal(prev[:len(prev)-8], key[:len(key)-8]) {
+					keys = append(keys, prev)
+				}
+				prev = key
+			}
+			it.Close()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// reads below target would miss the deleted versions
+	if err := updateBadgerDB(strg.db, []updateFunc{strg.merkleStore.setHistoryStart(target)}); err != nil {
+		return err
+	}
+	for len(keys) > 0 {
+		n := stateVersionPruneBatch
+		if n > len(keys) {
+			n = len(keys)
+		}
+		updFns := make([]updateFunc, n)
+		for i, key := range keys[:n] {
+			updFns[i] = deleteKey(key)
+		}
+		if err := updateBadgerDB(strg.db, updFns); err != nil {
+			return err
+		}
+		keys = keys[n:]
+	}
+	logger.I().Infow("pruned merkle versions", "from", start, "to", target)
+	return nil
+}
+
+// dropMerkleHistory deletes the merkle node versions, which are not recorded any more
+func (strg *Storage) dropMerkleHistory() error {
+	if !strg.merkleStore.getter.HasKey([]byte{colMerkleHistoryStart}) {
+		return nil
+	}
+	if err := updateBadgerDB(strg.db, []updateFunc{deleteKey([]byte{colMerkleHistoryStart})}); err != nil {
+		return err
+	}
+	for _, col := range []byte{colMerkleNodeVersion, colMerkleCheckpoint} {
+		if err := strg.db.DropPrefix([]byte{col}); err != nil {
+			return err
+		}
+	}
+	logger.I().Info("dropped merkle history")
+	return nil
+}
+
+func merkleNodeVersionPrefix(p *merkle.Position) []byte {
+	return concatBytes([]byte{colMerkleNodeVersion}, p.Bytes())
+}
+
+func (ms *merkleStore) setNodeVersion(p *merkle.Position, height uint64, data []byte) updateFunc {
+	return func(setter setter) error {
+		return setter.Set(concatBytes(merkleNodeVersionPrefix(p), uint64BEBytes(height)), data)
+	}
+}
+
+func (ms *merkleStore) setCheckpoint(height uint64, kept bool) updateFunc {
+	val := []byte{0}
+	if kept {
+		val = []byte{1}
+	}
+	return func(setter setter) error {
+		return setter.Set(concatBytes([]byte{colMerkleCheckpoint}, uint64BEBytes(height)), val)
+	}
+}
+
+func (ms *merkleStore) getHistoryStart() (uint64, error) {
+	b, err := ms.getter.Get([]byte{colMerkleHistoryStart})
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(b), nil
+}
+
+func (ms *merkleStore) setHistoryStart(height uint64) updateFunc {
+	return func(setter setter) error {
+		return setter.Set([]byte{colMerkleHistoryStart}, uint64BEBytes(height))
+	}
+}
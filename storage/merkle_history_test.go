@@ -0,0 +1,154 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package storage
+
+import (
+	"testing"
+
+	"github.com/aungmawjj/juria-blockchain/core"
+	"github.com/aungmawjj/juria-blockchain/merkle"
+	"github.com/stretchr/testify/assert"
+)
+
+func assertStateProofAtHeight(t *testing.T, strg *Storage, height uint64, want map[string]string) {
+	root, err := strg.GetMerkleRootAtHeight(height)
+	if !assert.NoError(t, err) {
+		return
+	}
+	for key, value := range want {
+		proof, err := strg.ProveStateAtHeight(nil, []byte(key), height)
+		if !assert.NoError(t, err, "key %s at %d", key, height) {
+			continue
+		}
+		assert.Equal(t, strg.stateStore.sumState([]byte(key), []byte(value)), proof.Leaf.Data, "key %s at %d", key, height)
+		assert.True(t, merkle.VerifyProof(root, proof, strg.merkleConfig.Hash, strg.merkleConfig.BranchFactor),
+			"key %s at %d", key, height)
+	}
+}
+
+func TestStorage_ProveStateAtHeight(t *testing.T) {
+	assert := assert.New(t)
+
+	config := DefaultConfig
+	config.MerkleBranchFactor = 2
+	config.KeepMerkleVersions = 100
+	strg := New(createOnMemoryDB(), config)
+
+	blocks := [][][2]string{
+		{{"a", "1"}},
+		{{"b", "1"}},
+		{},
+		{{"a", "2"}, {"c", "1"}},
+		{{"a", "3"}, {"d", "1"}, {"e", "1"}},
+	}
+	states := []map[string]string{
+		{"a": "1"},
+		{"a": "1", "b": "1"},
+		{"a": "1", "b": "1"},
+		{"a": "2", "b": "1", "c": "1"},
+		{"a": "3", "b": "1", "c": "1", "d": "1", "e": "1"},
+	}
+	roots := make([][]byte, 0)
+	for _, kvs := range blocks {
+		commitStateBlocks(t, strg, [][][2]string{kvs})
+		roots = append(roots, strg.GetMerkleRoot())
+	}
+	for h, want := range states {
+		root, err := strg.GetMerkleRootAtHeight(uint64(h))
+		assert.NoError(err)
+		assert.Equal(roots[h], root, "root at %d", h)
+		assertStateProofAtHeight(t, strg, uint64(h), want)
+	}
+	_, err := strg.ProveStateAtHeight(nil, []byte("c"), 2)
+	assert.Error(err, "created after height")
+	_, err = strg.GetMerkleRootAtHeight(5)
+	assert.ErrorIs(err, merkle.ErrVersionNotFound)
+
+	assert.NoError(strg.PruneMerkleVersions(10), "nothing to prune")
+	assert.NoError(strg.PruneMerkleVersions(1))
+	for h := 0; h < 3; h++ {
+		_, err = strg.GetMerkleRootAtHeight(uint64(h))
+		assert.ErrorIs(err, merkle.ErrVersionPruned)
+		_, err = strg.ProveStateAtHeight(nil, []byte("a"), uint64(h))
+		assert.ErrorIs(err, merkle.ErrVersionPruned)
+	}
+	for h := 3; h < len(states); h++ {
+		root, err := strg.GetMerkleRootAtHeight(uint64(h))
+		assert.NoError(err)
+		assert.Equal(roots[h], root, "root at %d", h)
+		assertStateProofAtHeight(t, strg, uint64(h), states[h])
+	}
+}
+
+func TestStorage_MerkleHistoryEnabledLater(t *testing.T) {
+	assert := assert.New(t)
+
+	db := createOnMemoryDB()
+	strg := New(db, DefaultConfig)
+	commitStateBlocks(t, strg, [][][2]string{
+		{{"a", "1"}, {"b", "1"}},
+	})
+	_, err := strg.GetMerkleRootAtHeight(0)
+	assert.ErrorIs(err, merkle.ErrVersionPruned, "no history")
+
+	config := DefaultConfig
+	config.KeepMerkleVersions = 100
+	strg = New(db, config)
+	root1 := strg.GetMerkleRoot()
+	commitStateBlocks(t, strg, [][][2]string{
+		{{"c", "1"}}, // history starts at 1
+		{{"a", "2"}},
+	})
+	_, err = strg.GetMerkleRootAtHeight(0)
+	assert.ErrorIs(err, merkle.ErrVersionPruned)
+	assertStateProofAtHeight(t, strg, 1, map[string]string{"a": "1", "b": "1", "c": "1"})
+	assertStateProofAtHeight(t, strg, 2, map[string]string{"a": "2", "b": "1", "c": "1"})
+	root, err := strg.GetMerkleRootAtHeight(1)
+	assert.NoError(err)
+	assert.NotEqual(root1, root)
+
+	strg = New(db, DefaultConfig)
+	assert.False(strg.merkleStore.getter.HasKey([]byte{colMerkleHistoryStart}), "history dropped")
+	_, err = strg.GetMerkleRootAtHeight(2)
+	assert.ErrorIs(err, merkle.ErrVersionPruned)
+}
+
+func TestStorage_MerkleHistoryBatch(t *testing.T) {
+	assert := assert.New(t)
+
+	config := DefaultConfig
+	config.KeepMerkleVersions = 10
+	strg := New(createOnMemoryDB(), config)
+
+	priv := core.GenerateKey(nil)
+	assert.NoError(strg.CommitBatch(newBatchTestData(priv, nil, [][][2]string{
+		{{"a", "1"}, {"b", "1"}},
+	})))
+	root0 := strg.GetMerkleRoot()
+	parent, _ := strg.GetLastBlock()
+	assert.NoError(strg.CommitBatch(newBatchTestData(priv, parent, [][][2]string{
+		{},
+		{{"a", "2"}, {"c", "1"}},
+		{},
+		{{"b", "2"}},
+		{},
+	})))
+
+	for _, h := range []uint64{0, 1} {
+		root, err := strg.GetMerkleRootAtHeight(h)
+		assert.NoError(err)
+		assert.Equal(root0, root, "unchanged before the batch changes at %d", h)
+	}
+	assertStateProofAtHeight(t, strg, 1, map[string]string{"a": "1", "b": "1"})
+	for _, h := range []uint64{2, 3} {
+		_, err := strg.GetMerkleRootAtHeight(h)
+		assert.ErrorIs(err, merkle.ErrVersionNotFound, "tree not kept in the batch at %d", h)
+	}
+	for _, h := range []uint64{4, 5} {
+		root, err := strg.GetMerkleRootAtHeight(h)
+		assert.NoError(err)
+		assert.Equal(strg.GetMerkleRoot(), root)
+		assertStateProofAtHeight(t, strg, h, map[string]string{"a": "2", "b": "2", "c": "1"})
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package storage
+
+import (
+	"errors"
+
+	"github.com/aungmawjj/juria-blockchain/logger"
+	"github.com/aungmawjj/juria-blockchain/merkle"
+	"github.com/dgraph-io/badger/v3"
+)
+
+// nodes moved in a migration transaction
+const merkleMigrationBatch = 1000
+
+/*
+Merkle nodes are stored by merkle.Position.Bytes, which sorts by level and index.
+Databases of format version 1 store them by merkle.Position.LegacyBytes in colLegacyMerkleNode.
+
+The nodes are migrated lazily. Updates write the nodes by the new positions and
+the nodes missing there are read from the legacy positions until none is left.
+A writable storage moves the legacy nodes in the background, see MigrateMerkleNodes.
+*/
+
+func hasLegacyMerkleNodes(db *badger.DB) bool {
+	found := false
+	db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{Prefix: []byte{colLegacyMerkleNode}})
+		defer it.Close()
+		it.Rewind()
+		found = it.Valid()
+		return nil
+	})
+	return found
+}
+
+// MigrateMerkleNodes moves the nodes stored by legacy positions in batches
+// and returns the number of nodes moved. A node already written by its new position is newer
+// and the legacy one is dropped. It does nothing once finished.
+func (strg *Storage) MigrateMerkleNodes() (int, error) {
+	if strg.readOnly {
+		return 0, ErrReadOnly
+	}
+	total := 0
+	for strg.merkleStore.legacy.exist() {
+		n, err := strg.migrateMerkleNodes()
+		total += n
+		if err != nil {
+			return total, err
+		}
+		select {
+		case <-strg.closeCh:
+			return total, nil
+		default:
+		}
+	}
+	return total, nil
+}
+
+func (strg *Storage) migrateMerkleNodesInBackground() {
+	defer strg.wgLoops.Done()
+	n, err := strg.MigrateMerkleNodes()
+	if err != nil {
+		logger.I().Errorw("migrate merkle nodes failed", "moved", n, "error", err)
+	}
+}
+
+// migrateMerkleNodes moves a batch of legacy nodes while commits are locked
+func (strg *Storage) migrateMerkleNodes() (int, error) {
+	strg.mtxCommit.Lock()
+	defer strg.mtxCommit.Unlock()
+
+	count := 0
+	err := strg.db.Update(func(txn *badger.Txn) error {
+		keys, values, err := readLegacyMerkleNodes(txn)
+		if err != nil {
+			return err
+		}
+		count = len(keys)
+		for i, key := range keys {
+			p := merkle.UnmarshalPosition(key[1:])
+			_, err := txn.Get(merkleNodeKey(p))
+			if errors.Is(err, badger.ErrKeyNotFound) {
+				err = txn.Set(merkleNodeKey(p), values[i])
+			}
+			if err != nil {
+				return err
+			}
+			if err := txn.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if count < merkleMigrationBatch {
+		strg.merkleStore.legacy.set(false)
+		logger.I().Info("migrated merkle nodes")
+	}
+	return count, nil
+}
+
+func readLegacyMerkleNodes(txn *badger.Txn) ([][]byte, [][]byte, error) {
+	keys := make([][]byte, 0)
+	values := make([][]byte, 0)
+	it := txn.NewIterator(badger.IteratorOptions{PrefetchValues: true, Prefix: []byte{colLegacyMerkleNode}})
+	defer it.Close()
+	for it.Rewind(); it.Valid() && len(keys) < merkleMigrationBatch; it.Next() {
+		key := it.Item().KeyCopy(nil)
+		if len(key) < 3 {
+			continue // not a legacy position
+		}
+		value, err := it.Item().ValueCopy(nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		keys = append(keys, key)
+		values = append(values, value)
+	}
+	return keys, values, nil
+}
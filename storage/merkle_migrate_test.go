@@ -0,0 +1,104 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package storage
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/aungmawjj/juria-blockchain/merkle"
+	"github.com/dgraph-io/badger/v3"
+	"github.com/stretchr/testify/assert"
+)
+
+// moveToLegacyPositions rewrites the merkle nodes by legacy positions as a v1 db stores them
+func moveToLegacyPositions(t *testing.T, db *badger.DB) int {
+	updFns := make([]updateFunc, 0)
+	err := db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{PrefetchValues: true, Prefix: []byte{colMerkleNodeByPosition}})
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			key := it.Item().KeyCopy(nil)
+			p, err := merkle.ParsePosition(key[1:])
+			if err != nil {
+				return err
+			}
+			value, err := it.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			updFns = append(updFns, deleteKey(key), setKey(legacyMerkleNodeKey(p), value))
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	for i := 0; i < len(updFns); i += 1000 {
+		end := i + 1000
+		if end > len(updFns) {
+			end = len(updFns)
+		}
+		assert.NoError(t, updateBadgerDB(db, updFns[i:end]))
+	}
+	return len(updFns) / 2
+}
+
+func TestStorage_MigrateMerkleNodes(t *testing.T) {
+	assert := assert.New(t)
+
+	// v1 fixture, more nodes than a migration batch
+	dir := t.TempDir()
+	current := migrations
+	setMigrations(t, 1, nil)
+	strg, err := Open(dir, DefaultConfig)
+	assert.NoError(err)
+	kvs := make([][2]string, 2000)
+	for i := range kvs {
+		kvs[i] = [2]string{fmt.Sprintf("k%d", i), fmt.Sprintf("v%d", i)}
+	}
+	commitStateBlocks(t, strg, [][][2]string{kvs[:1500], kvs[1500:]})
+	root := strg.GetMerkleRoot()
+	assert.NoError(strg.Close())
+
+	opts, err := DefaultConfig.badgerOptions(dir)
+	assert.NoError(err)
+	db, err := badger.Open(opts)
+	assert.NoError(err)
+	moved := moveToLegacyPositions(t, db)
+	assert.Greater(moved, merkleMigrationBatch)
+	assert.True(hasLegacyMerkleNodes(db))
+
+	// read from the legacy positions
+	ro := newStorage(db, DefaultConfig, true)
+	assert.Equal(root, ro.GetMerkleRoot())
+	assert.Equal([]byte("v10"), ro.VerifyState(nil, []byte("k10")))
+	assert.Equal([]byte("v1999"), ro.VerifyState(nil, []byte("k1999")))
+	assert.NoError(db.Close())
+
+	setMigrations(t, FormatVersion, current)
+	strg, err = Open(dir, DefaultConfig)
+	assert.NoError(err)
+	assert.EqualValues(FormatVersion, strg.Info().FormatVersion)
+	assert.Equal([]byte("v10"), strg.VerifyState(nil, []byte("k10")), "readable while migrating")
+	strg.wgLoops.Wait()
+
+	assert.False(strg.merkleStore.legacy.exist())
+	assert.False(hasLegacyMerkleNodes(strg.db))
+	n, err := strg.MigrateMerkleNodes()
+	assert.NoError(err)
+	assert.Zero(n, "finished")
+	assert.Equal(root, strg.GetMerkleRoot())
+	for _, kv := range kvs[:10] {
+		assert.Equal([]byte(kv[1]), strg.VerifyState(nil, []byte(kv[0])))
+	}
+
+	commitStateBlocks(t, strg, [][][2]string{{{"k10", "new"}}})
+	assert.NotEqual(root, strg.GetMerkleRoot())
+	assert.Equal([]byte("new"), strg.VerifyState(nil, []byte("k10")))
+	assert.NoError(strg.Close())
+
+	strg, err = Open(dir, DefaultConfig)
+	assert.NoError(err)
+	assert.Equal([]byte("new"), strg.VerifyState(nil, []byte("k10")))
+	assert.NoError(strg.Close())
+}
@@ -0,0 +1,210 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/aungmawjj/juria-blockchain/logger"
+	"github.com/aungmawjj/juria-blockchain/merkle"
+	"github.com/dgraph-io/badger/v3"
+)
+
+// errors
+var (
+	ErrLeafCountMismatch = errors.New("leaf count mismatch")
+)
+
+// leaves written in a rebuild transaction
+var merkleRebuildBatch = 1000
+
+/*
+RebuildMerkleTree recomputes the tree from the state values in two passes,
+so that the leaves are never loaded into memory at once.
+
+	1. the leaf of each state key is written by its leaf index, padded to the width of the leaf count
+	2. the leaves are read in index order and the tree is updated chunk by chunk
+
+An interrupted rebuild leaves the marker key of colMerkleRebuild, New warns about it
+and the rebuild must be run again.
+*/
+
+// RebuildMerkleTree recomputes the leaves from the state values, rebuilds the branch nodes
+// and returns the new root. Leaf indexes are kept, they are part of the state commited by the chain.
+// It warns if the new root is not the one recorded by the last block commit, see GetLastCommitedRoot.
+// Commits and state queries wait until it's done.
+func (strg *Storage) RebuildMerkleTree() ([]byte, error) {
+	if strg.readOnly {
+		return nil, ErrReadOnly
+	}
+	strg.mtxCommit.Lock()
+	defer strg.mtxCommit.Unlock()
+
+	root, err := strg.rebuildMerkleTree()
+	if err != nil {
+		return nil, err
+	}
+	if recorded, err := strg.GetLastCommitedRoot(); err == nil && !bytes.Equal(recorded, root) {
+		logger.I().Warnw("rebuilt merkle root doesn't match the last block commit",
+			"root", root, "recorded", recorded)
+	}
+	return root, nil
+}
+
+// rebuildMerkleTree rebuilds the tree while commits are locked
+func (strg *Storage) rebuildMerkleTree() ([]byte, error) {
+	strg.mtxWriteState.Lock()
+	defer strg.mtxWriteState.Unlock()
+	if strg.merkleCache != nil {
+		defer strg.merkleCache.Purge()
+	}
+
+	leafCount := strg.merkleStore.getLeafCount()
+	if leafCount.Sign() == 0 {
+		return nil, nil
+	}
+	if err := strg.db.DropPrefix([]byte{colMerkleRebuild}); err != nil {
+		return nil, err
+	}
+	if err := updateBadgerDB(strg.db, []updateFunc{setKey([]byte{colMerkleRebuild}, nil)}); err != nil {
+		return nil, err
+	}
+	if err := strg.writeRebuildLeaves(leafCount); err != nil {
+		return nil, err
+	}
+	// uncached tree, nodes are read from the db as they are rewritten
+	tree := merkle.NewTree(strg.merkleStore, strg.merkleConfig)
+	root, err := strg.rebuildBranches(tree, leafCount)
+	if err != nil {
+		return nil, err
+	}
+	if err := strg.db.DropPrefix([]byte{colMerkleRebuild}); err != nil {
+		return nil, err
+	}
+	logger.I().Infow("rebuilt merkle tree", "leaf count", leafCount, "root", root)
+	return root, nil
+}
+
+// GetLastCommitedRoot returns the merkle root recorded by the last block commit which changed state,
+// nil if no block changed state. Blocks without state changes don't record the root.
+func (strg *Storage) GetLastCommitedRoot() ([]byte, error) {
+	height, err := strg.chainStore.getBlockHeight()
+	if err != nil {
+		return nil, nil // no blocks
+	}
+	for ; ; height-- {
+		blk, err := strg.chainStore.getBlockByHeight(height)
+		if err != nil {
+			return nil, err
+		}
+		bcm, err := strg.chainStore.getBlockCommit(blk.Hash())
+		if err != nil {
+			return nil, fmt.Errorf("cannot load block commit %d, %w", height, err)
+		}
+		if len(bcm.MerkleRoot()) > 0 || height == 0 {
+			return bcm.MerkleRoot(), nil
+		}
+	}
+}
+
+// writeRebuildLeaves writes the leaf of each state key by its padded leaf index
+func (strg *Storage) writeRebuildLeaves(leafCount *big.Int) error {
+	width := len(leafCount.Bytes())
+	count := big.NewInt(0)
+	updFns := make([]updateFunc, 0, merkleRebuildBatch)
+	err := strg.db.View(func(txn *badger.Txn) error {
+		prefix := []byte{colMerkleIndexByStateKey}
+		it := txn.NewIterator(badger.IteratorOptions{PrefetchValues: true, PrefetchSize: 100, Prefix: prefix})
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			key := it.Item().KeyCopy(nil)[len(prefix):]
+			idx, err := it.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			if leafCount.Cmp(big.NewInt(0).SetBytes(idx)) != 1 {
+				return fmt.Errorf("%w, tree index %x of key %x", ErrLeafCountMismatch, idx, key)
+			}
+			leaf := clearedLeaf
+			value, err := strg.stateStore.getState(key)
+			if err == nil {
+				leaf = strg.stateStore.sumState(key, value)
+			} else if !errors.Is(err, badger.ErrKeyNotFound) {
+				return err
+			}
+			updFns = append(updFns, setKey(rebuildLeafKey(idx, width), leaf))
+			count.Add(count, big.NewInt(1))
+			if len(updFns) == merkleRebuildBatch {
+				if err := updateBadgerDB(strg.db, updFns); err != nil {
+					return err
+				}
+				updFns = updFns[:0]
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if count.Cmp(leafCount) != 0 {
+		return fmt.Errorf("%w, %d state keys for %d leaves", ErrLeafCountMismatch, count, leafCount)
+	}
+	return updateBadgerDB(strg.db, updFns)
+}
+
+// rebuildBranches updates the tree with the leaves in index order and returns the root
+func (strg *Storage) rebuildBranches(tree *merkle.Tree, leafCount *big.Int) ([]byte, error) {
+	var root []byte
+	nodes := make([]*merkle.Node, 0, merkleRebuildBatch)
+	update := func() error {
+		upd := tree.Update(nodes, leafCount)
+		root = upd.Root.Data
+		nodes = nodes[:0]
+		return updateBadgerDB(strg.db, strg.merkleStore.commitUpdate(upd))
+	}
+	err := strg.db.View(func(txn *badger.Txn) error {
+		prefix := []byte{colMerkleRebuild}
+		it := txn.NewIterator(badger.IteratorOptions{PrefetchValues: true, PrefetchSize: 100, Prefix: prefix})
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			key := it.Item().Key()
+			if len(key) == len(prefix) {
+				continue // marker
+			}
+			leaf, err := it.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			nodes = append(nodes, &merkle.Node{
+				Position: merkle.NewPosition(0, big.NewInt(0).SetBytes(key[len(prefix):])),
+				Data:     leaf,
+			})
+			if len(nodes) == merkleRebuildBatch {
+				if err := update(); err != nil {
+					return err
+				}
+			}
+		}
+		if len(nodes) > 0 {
+			return update()
+		}
+		return nil
+	})
+	return root, err
+}
+
+func rebuildLeafKey(idx []byte, width int) []byte {
+	key := make([]byte, 1+width)
+	key[0] = colMerkleRebuild
+	copy(key[1+width-len(idx):], idx)
+	return key
+}
+
+// hasInterruptedRebuild returns true if the marker of a rebuild in progress is found
+func (strg *Storage) hasInterruptedRebuild() bool {
+	return strg.chainStore.getter.HasKey([]byte{colMerkleRebuild})
+}
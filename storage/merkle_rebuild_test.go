@@ -0,0 +1,71 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package storage
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/aungmawjj/juria-blockchain/core"
+	"github.com/aungmawjj/juria-blockchain/merkle"
+	"github.com/stretchr/testify/assert"
+)
+
+func setMerkleRebuildBatch(t *testing.T, size int) {
+	old := merkleRebuildBatch
+	merkleRebuildBatch = size
+	t.Cleanup(func() { merkleRebuildBatch = old })
+}
+
+func TestStorage_RebuildMerkleTree(t *testing.T) {
+	assert := assert.New(t)
+	setMerkleRebuildBatch(t, 7)
+
+	strg := newTestStorage()
+	recorded, err := strg.GetLastCommitedRoot()
+	assert.NoError(err)
+	assert.Nil(recorded, "no blocks")
+	root, err := strg.RebuildMerkleTree()
+	assert.NoError(err)
+	assert.Nil(root, "empty state")
+
+	commitTestStates(t, strg, 5, 300)
+	commitStateChangeBlocks(t, strg, [][]*core.StateChange{{
+		core.NewStateChange().SetKey([]byte{0, 1}).SetDeleted(true),
+	}})
+	want := strg.GetMerkleRoot()
+	recorded, err = strg.GetLastCommitedRoot()
+	assert.NoError(err)
+	assert.Equal(want, recorded)
+
+	// corrupt a leaf, a branch and the root
+	height := strg.merkleStore.GetHeight()
+	corrupted := []*merkle.Node{
+		{Position: merkle.NewPosition(0, big.NewInt(3)), Data: []byte{1}},
+		{Position: merkle.NewPosition(1, big.NewInt(2)), Data: []byte{2}},
+		{Position: merkle.NewPosition(height-1, big.NewInt(0)), Data: []byte{3}},
+	}
+	updFns := strg.merkleStore.setNodes(corrupted)
+	updFns = append(updFns, strg.merkleStore.setRoot(strg.merkleStore.getLeafCount(), corrupted[2]))
+	assert.NoError(updateBadgerDB(strg.db, updFns))
+	strg.merkleCache.Purge()
+	assert.NotEqual(want, strg.GetMerkleRoot())
+
+	root, err = strg.RebuildMerkleTree()
+	assert.NoError(err)
+	assert.Equal(want, root)
+	assert.Equal(want, strg.GetMerkleRoot())
+	assert.False(strg.hasInterruptedRebuild())
+	for k := 0; k < 300; k++ {
+		key := []byte{uint8(k >> 8), uint8(k)}
+		assert.Equal(strg.GetState(nil, key), strg.VerifyState(nil, key))
+	}
+
+	// a leaf without state key can't be rebuilt
+	assert.NoError(updateBadgerDB(strg.db, []updateFunc{
+		deleteKey(concatBytes([]byte{colMerkleIndexByStateKey}, []byte{0, 2})),
+	}))
+	_, err = strg.RebuildMerkleTree()
+	assert.ErrorIs(err, ErrLeafCountMismatch)
+}
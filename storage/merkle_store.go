@@ -5,15 +5,68 @@ package storage
 
 import (
 	"math/big"
+	"sync/atomic"
 
 	"github.com/aungmawjj/juria-blockchain/merkle"
+	"github.com/dgraph-io/badger/v3"
 )
 
 type merkleStore struct {
 	getter getter
+	legacy *legacyNodes // nil if the nodes stored by legacy positions are not read
 }
 
-var _ merkle.Store = (*merkleStore)(nil)
+// legacyNodes reports whether nodes stored by legacy positions remain, see MigrateMerkleNodes
+type legacyNodes struct {
+	remain int32
+}
+
+func newLegacyNodes(remain bool) *legacyNodes {
+	ln := new(legacyNodes)
+	ln.set(remain)
+	return ln
+}
+
+func (ln *legacyNodes) exist() bool {
+	return ln != nil && atomic.LoadInt32(&ln.remain) == 1
+}
+
+func (ln *legacyNodes) set(remain bool) {
+	var v int32
+	if remain {
+		v = 1
+	}
+	atomic.StoreInt32(&ln.remain, v)
+}
+
+var (
+	_ merkle.Store         = (*merkleStore)(nil)
+	_ merkle.RootStore     = (*merkleStore)(nil)
+	_ merkle.SnapshotStore = (*merkleStore)(nil)
+)
+
+// merkleSnapshot reads the tree in a badger read transaction, discarded on Close
+type merkleSnapshot struct {
+	*merkleStore
+	txn *badger.Txn
+}
+
+// Snapshot implement merkle.SnapshotStore. A tree update is commited in one db transaction,
+// which the snapshot sees all or none of. The store of a batch reads its pending writes instead.
+func (ms *merkleStore) Snapshot() merkle.NodeReader {
+	bg, ok := ms.getter.(*badgerGetter)
+	if !ok {
+		return ms
+	}
+	txn := bg.db.NewTransaction(false)
+	return &merkleSnapshot{&merkleStore{&txnGetter{txn}, ms.legacy}, txn}
+}
+
+// Close discards the transaction
+func (s *merkleSnapshot) Close() error {
+	s.txn.Discard()
+	return nil
+}
 
 func (ms *merkleStore) GetLeafCount() *big.Int {
 	return ms.getLeafCount()
@@ -27,18 +80,60 @@ func (ms *merkleStore) GetNode(p *merkle.Position) []byte {
 	return ms.getNode(p)
 }
 
+// GetNodes reads the nodes missing by position from the legacy positions in the same transaction,
+// so that a node moved by MigrateMerkleNodes in between is found
+func (ms *merkleStore) GetNodes(positions []*merkle.Position) [][]byte {
+	legacy := ms.legacy.exist()
+	keys := make([][]byte, len(positions), 2*len(positions))
+	for i, p := range positions {
+		keys[i] = merkleNodeKey(p)
+	}
+	if legacy {
+		for _, p := range positions {
+			keys = append(keys, legacyMerkleNodeKey(p))
+		}
+	}
+	values, err := ms.getter.GetValues(keys)
+	if err != nil {
+		return make([][]byte, len(positions))
+	}
+	if legacy {
+		for i := range positions {
+			if values[i] == nil {
+				values[i] = values[len(positions)+i]
+			}
+		}
+	}
+	return values[:len(positions)]
+}
+
+func merkleNodeKey(p *merkle.Position) []byte {
+	return concatBytes([]byte{colMerkleNodeByPosition}, p.Bytes())
+}
+
+func legacyMerkleNodeKey(p *merkle.Position) []byte {
+	return concatBytes([]byte{colLegacyMerkleNode}, p.LegacyBytes())
+}
+
+func (ms *merkleStore) GetRoot(leafCount *big.Int) []byte {
+	val, _ := ms.getter.Get(concatBytes([]byte{colMerkleRootByLeafCount}, leafCount.Bytes()))
+	return val
+}
+
 func (ms *merkleStore) commitUpdate(upd *merkle.UpdateResult) []updateFunc {
 	ret := make([]updateFunc, 0)
 	ret = append(ret, ms.setNodes(upd.Leaves)...)
 	ret = append(ret, ms.setNodes(upd.Branches)...)
 	ret = append(ret, ms.setLeafCount(upd.LeafCount))
 	ret = append(ret, ms.setTreeHeight(upd.Height))
+	if upd.Root != nil {
+		ret = append(ret, ms.setRoot(upd.LeafCount, upd.Root))
+	}
 	return ret
 }
 
 func (ms *merkleStore) getNode(p *merkle.Position) []byte {
-	val, _ := ms.getter.Get(concatBytes([]byte{colMerkleNodeByPosition}, p.Bytes()))
-	return val
+	return ms.GetNodes([]*merkle.Position{p})[0]
 }
 
 func (ms *merkleStore) getLeafCount() *big.Int {
@@ -50,6 +145,14 @@ func (ms *merkleStore) getLeafCount() *big.Int {
 	return count
 }
 
+func (ms *merkleStore) getLeafCountAtHeight(height uint64) (*big.Int, error) {
+	val, err := ms.getter.Get(concatBytes([]byte{colMerkleLeafCountByHeight}, uint64BEBytes(height)))
+	if err != nil {
+		return nil, err
+	}
+	return big.NewInt(0).SetBytes(val), nil
+}
+
 func (ms *merkleStore) getHeight() uint8 {
 	var height uint8
 	val, _ := ms.getter.Get([]byte{colMerkleTreeHeight})
@@ -69,9 +172,7 @@ func (ms *merkleStore) setNodes(nodes []*merkle.Node) []updateFunc {
 
 func (ms *merkleStore) setNode(n *merkle.Node) updateFunc {
 	return func(setter setter) error {
-		return setter.Set(
-			concatBytes([]byte{colMerkleNodeByPosition}, n.Position.Bytes()), n.Data,
-		)
+		return setter.Set(merkleNodeKey(n.Position), n.Data)
 	}
 }
 
@@ -81,6 +182,22 @@ func (ms *merkleStore) setLeafCount(leafCount *big.Int) updateFunc {
 	}
 }
 
+func (ms *merkleStore) setLeafCountAtHeight(height uint64, leafCount *big.Int) updateFunc {
+	return func(setter setter) error {
+		return setter.Set(
+			concatBytes([]byte{colMerkleLeafCountByHeight}, uint64BEBytes(height)), leafCount.Bytes(),
+		)
+	}
+}
+
+func (ms *merkleStore) setRoot(leafCount *big.Int, root *merkle.Node) updateFunc {
+	return func(setter setter) error {
+		return setter.Set(
+			concatBytes([]byte{colMerkleRootByLeafCount}, leafCount.Bytes()), root.Data,
+		)
+	}
+}
+
 func (ms *merkleStore) setTreeHeight(height uint8) updateFunc {
 	return func(setter setter) error {
 		return setter.Set([]byte{colMerkleTreeHeight}, []byte{height})
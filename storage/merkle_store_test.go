@@ -4,6 +4,8 @@
 package storage
 
 import (
+	"crypto"
+	"io"
 	"math/big"
 	"testing"
 
@@ -21,7 +23,7 @@ func TestMerkleStore(t *testing.T) {
 	assert := assert.New(t)
 
 	db := createOnMemoryDB()
-	ms := &merkleStore{&badgerGetter{db}}
+	ms := &merkleStore{getter: &badgerGetter{db}}
 	assert.Equal(uint8(0), ms.GetHeight())
 	assert.Equal(big.NewInt(0), ms.GetLeafCount())
 
@@ -38,6 +40,8 @@ func TestMerkleStore(t *testing.T) {
 			{Position: merkle.NewPosition(1, big.NewInt(0)), Data: []byte{3, 3}},
 		},
 	}
+	upd.Root = upd.Branches[0]
+	assert.Nil(ms.GetRoot(upd.LeafCount))
 
 	updateBadgerDB(db, ms.commitUpdate(upd))
 
@@ -46,4 +50,61 @@ func TestMerkleStore(t *testing.T) {
 	assert.Equal([]byte{1, 1}, ms.GetNode(merkle.NewPosition(0, big.NewInt(0))))
 	assert.Equal([]byte{2, 2}, ms.GetNode(merkle.NewPosition(0, big.NewInt(1))))
 	assert.Equal([]byte{3, 3}, ms.GetNode(merkle.NewPosition(1, big.NewInt(0))))
+	assert.Equal([]byte{3, 3}, ms.GetRoot(upd.LeafCount))
+
+	assert.Equal([][]byte{{2, 2}, nil, {3, 3}}, ms.GetNodes([]*merkle.Position{
+		merkle.NewPosition(0, big.NewInt(1)),
+		merkle.NewPosition(0, big.NewInt(2)),
+		merkle.NewPosition(1, big.NewInt(0)),
+	}))
+	assert.Empty(ms.GetNodes(nil))
+	assert.Nil(ms.GetRoot(big.NewInt(1)))
+}
+
+func TestMerkleStore_Snapshot(t *testing.T) {
+	assert := assert.New(t)
+
+	db := createOnMemoryDB()
+	ms := &merkleStore{getter: &badgerGetter{db}}
+	tree := merkle.NewTree(ms, merkle.Config{Hash: crypto.SHA1, BranchFactor: 2})
+	commit := func(leaves []*merkle.Node, leafCount int64) {
+		upd := tree.Update(leaves, big.NewInt(leafCount))
+		assert.NoError(updateBadgerDB(db, ms.commitUpdate(upd)))
+	}
+	leaves := make([]*merkle.Node, 10)
+	for i := range leaves {
+		leaves[i] = &merkle.Node{Position: merkle.NewPosition(0, big.NewInt(int64(i))), Data: []byte{byte(i)}}
+	}
+	commit(leaves, int64(len(leaves)))
+	root := tree.Root()
+
+	snap := ms.Snapshot()
+	commit([]*merkle.Node{{Position: merkle.NewPosition(0, big.NewInt(10)), Data: []byte{10}}}, 11)
+	assert.EqualValues(10, snap.GetLeafCount().Int64())
+	assert.Equal(root.Data, snap.GetNode(root.Position), "root before the commit")
+	assert.NotEqual(root.Data, tree.Root().Data)
+	assert.NoError(snap.(io.Closer).Close())
+
+	// verify the unchanged leaves while the tree grows
+	done := make(chan struct{})
+	failed := make(chan bool)
+	go func() {
+		for {
+			select {
+			case <-done:
+				failed <- false
+				return
+			default:
+			}
+			if !tree.Verify(leaves[:1]) {
+				failed <- true
+				return
+			}
+		}
+	}()
+	for count := int64(11); count < 200; count++ {
+		commit([]*merkle.Node{{Position: merkle.NewPosition(0, big.NewInt(count)), Data: []byte{1}}}, count+1)
+	}
+	close(done)
+	assert.False(<-failed, "verify failed during commits")
 }
@@ -0,0 +1,139 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package storage
+
+import (
+	"crypto"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// errors
+var (
+	ErrMetadataMismatch  = errors.New("storage metadata mismatch")
+	ErrMigrationRequired = errors.New("storage migration required")
+)
+
+// FormatVersion of the data layout, recorded in the metadata.
+// Bump it with a migration from the previous version, see Migrate.
+const FormatVersion = 4
+
+// formatVersion is FormatVersion, replaced in tests
+var formatVersion uint8 = FormatVersion
+
+// Metadata is recorded with the first commit,
+// the db can't be opened with a different tree branch factor or state hash afterwards
+type Metadata struct {
+	FormatVersion      uint8       `json:"formatVersion"`
+	MerkleBranchFactor uint8       `json:"merkleBranchFactor"`
+	StateHashFunc      crypto.Hash `json:"stateHashFunc"`
+}
+
+// Info reports the metadata with the current height and state root
+type Info struct {
+	Metadata
+	Recorded    bool     `json:"recorded"` // false until the first commit
+	BlockHeight uint64   `json:"blockHeight"`
+	MerkleRoot  []byte   `json:"merkleRoot"`
+	LeafCount   *big.Int `json:"leafCount"`
+}
+
+// Info returns the metadata of the config if it's not recorded yet
+func (strg *Storage) Info() Info {
+	info := Info{
+		Metadata:    strg.metadata(),
+		BlockHeight: strg.GetBlockHeight(),
+		MerkleRoot:  strg.GetMerkleRoot(),
+		LeafCount:   strg.merkleStore.getLeafCount(),
+	}
+	if stored, err := strg.getMetadata(); err == nil {
+		info.Metadata = *stored
+		info.Recorded = true
+	}
+	return info
+}
+
+// metadata returns the metadata of the config
+func (strg *Storage) metadata() Metadata {
+	bfactor := strg.merkleConfig.BranchFactor
+	if bfactor < 2 {
+		bfactor = 2 // same as merkle.NewTree
+	}
+	return Metadata{
+		FormatVersion:      formatVersion,
+		MerkleBranchFactor: bfactor,
+		StateHashFunc:      strg.stateStore.hashFunc,
+	}
+}
+
+// checkMetadata compares the recorded metadata with the config.
+// It returns ErrMigrationRequired if the db has an older format version
+// or blocks without metadata, see Migrate.
+func (strg *Storage) checkMetadata() error {
+	stored, err := strg.getMetadata()
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		if strg.chainStore.getter.HasKey([]byte{colBlockHeight}) {
+			return fmt.Errorf("%w, db without metadata", ErrMigrationRequired)
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	strg.metaRecorded = true
+	want := strg.metadata()
+	if stored.FormatVersion > want.FormatVersion {
+		return fmt.Errorf("%w, db format version %d is newer than supported %d",
+			ErrMetadataMismatch, stored.FormatVersion, want.FormatVersion)
+	}
+	if stored.MerkleBranchFactor != want.MerkleBranchFactor {
+		return fmt.Errorf("%w, db merkle branch factor %d, configured %d",
+			ErrMetadataMismatch, stored.MerkleBranchFactor, want.MerkleBranchFactor)
+	}
+	if stored.StateHashFunc != want.StateHashFunc {
+		return fmt.Errorf("%w, db state hash %s, configured %s",
+			ErrMetadataMismatch, stored.StateHashFunc, want.StateHashFunc)
+	}
+	if stored.FormatVersion < want.FormatVersion {
+		return fmt.Errorf("%w, db format version %d, supported %d",
+			ErrMigrationRequired, stored.FormatVersion, want.FormatVersion)
+	}
+	return nil
+}
+
+func (strg *Storage) getMetadata() (*Metadata, error) {
+	b, err := strg.chainStore.getter.Get([]byte{colMetadata})
+	if err != nil {
+		return nil, err
+	}
+	var hash uint64
+	n := 0
+	if len(b) > 2 {
+		hash, n = binary.Uvarint(b[2:])
+	}
+	if n <= 0 {
+		return nil, fmt.Errorf("invalid storage metadata %x", b)
+	}
+	return &Metadata{
+		FormatVersion:      b[0],
+		MerkleBranchFactor: b[1],
+		StateHashFunc:      crypto.Hash(hash),
+	}, nil
+}
+
+// setMetadata records the metadata of the config, written with the first commit
+func (strg *Storage) setMetadata() updateFunc {
+	return putMetadata(strg.metadata())
+}
+
+func putMetadata(meta Metadata) updateFunc {
+	b := []byte{meta.FormatVersion, meta.MerkleBranchFactor}
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], uint64(meta.StateHashFunc))
+	return setKey([]byte{colMetadata}, append(b, buf[:n]...))
+}
@@ -0,0 +1,59 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package storage
+
+import (
+	"crypto"
+	"testing"
+
+	"github.com/aungmawjj/juria-blockchain/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStorage_Metadata(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	config := DefaultConfig
+	config.MerkleBranchFactor = 8
+	strg, err := Open(dir, config)
+	assert.NoError(err)
+	info := strg.Info()
+	assert.False(info.Recorded)
+	assert.EqualValues(8, info.MerkleBranchFactor)
+
+	commitStateBlocks(t, strg, [][][2]string{{{"a", "1"}}, {{"b", "2"}}})
+	info = strg.Info()
+	assert.True(info.Recorded)
+	assert.Equal(Metadata{FormatVersion, 8, crypto.SHA3_256}, info.Metadata)
+	assert.EqualValues(1, info.BlockHeight)
+	assert.Equal(strg.GetMerkleRoot(), info.MerkleRoot)
+	assert.EqualValues(2, info.LeafCount.Int64())
+	assert.NoError(strg.Close())
+
+	config.MerkleBranchFactor = 4
+	_, err = Open(dir, config)
+	assert.ErrorIs(err, ErrMetadataMismatch)
+	_, err = OpenReadOnly(dir, config)
+	assert.ErrorIs(err, ErrMetadataMismatch)
+
+	config.MerkleBranchFactor = 8
+	config.StateHashFunc = crypto.SHA256
+	_, err = Open(dir, config)
+	assert.ErrorIs(err, ErrMetadataMismatch)
+
+	config.StateHashFunc = crypto.SHA3_256
+	strg, err = Open(dir, config)
+	assert.NoError(err)
+	defer strg.Close()
+	assert.Equal([]byte("2"), strg.VerifyState(nil, []byte("b")))
+}
+
+func TestStorage_MetadataBatch(t *testing.T) {
+	assert := assert.New(t)
+
+	strg := newTestStorage()
+	assert.NoError(strg.CommitBatch(newBatchTestData(core.GenerateKey(nil), nil, [][][2]string{{{"a", "1"}}})))
+	assert.True(strg.Info().Recorded)
+}
@@ -0,0 +1,229 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package storage
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// LatencyBuckets are the upper bounds of latency histogram buckets,
+// the last bucket of LatencyStats counts the latencies above them
+var LatencyBuckets = []time.Duration{
+	100 * time.Microsecond,
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+}
+
+// LatencyStats is a snapshot of latency histogram
+type LatencyStats struct {
+	Count        uint64   `json:"count"`
+	TotalSeconds float64  `json:"totalSeconds"`
+	MaxSeconds   float64  `json:"maxSeconds"`
+	Buckets      []uint64 `json:"buckets"`
+}
+
+// Metrics is a snapshot of storage metrics since the storage is created
+type Metrics struct {
+	Commits         uint64 `json:"commits"`
+	CommitBytes     uint64 `json:"commitBytes"`     // key and value bytes written by all commits
+	LastCommitBytes uint64 `json:"lastCommitBytes"` // key and value bytes written by the last commit or batch
+
+	Commit LatencyStats `json:"commit"`
+	Merkle LatencyStats `json:"merkle"` // merkle update computation
+	Chain  LatencyStats `json:"chain"`  // block commit, block, txs and tx commits writes
+	State  LatencyStats `json:"state"`  // state, merkle tree and block height write
+
+	GetState LatencyStats `json:"getState"`
+	GetBlock LatencyStats `json:"getBlock"`
+
+	LSMSize  int64 `json:"lsmSize"`
+	VlogSize int64 `json:"vlogSize"`
+
+	// approximate keys by namespace (chain, state, merkle, other), counted from the key counts
+	// of the on-disk tables, including old versions and deletes, excluding memtables
+	KeyCounts map[string]uint64 `json:"keyCounts"`
+
+	BlockHeight uint64 `json:"blockHeight"`
+	LeafCount   uint64 `json:"leafCount"` // merkle tree leaves
+}
+
+// key namespaces of Metrics.KeyCounts
+const (
+	NamespaceChain  = "chain"
+	NamespaceState  = "state"
+	NamespaceMerkle = "merkle"
+	NamespaceOther  = "other"
+)
+
+// latencyHistogram is updated with atomic operations only, so that it is cheap on read path
+type latencyHistogram struct {
+	count   uint64
+	total   uint64 // nanoseconds
+	max     uint64 // nanoseconds
+	buckets []uint64
+}
+
+func newLatencyHistogram() *latencyHistogram {
+	return &latencyHistogram{buckets: make([]uint64, len(LatencyBuckets)+1)}
+}
+
+func (lh *latencyHistogram) observe(d time.Duration) {
+	atomic.AddUint64(&lh.count, 1)
+	atomic.AddUint64(&lh.total, uint64(d))
+	for {
+		max := atomic.LoadUint64(&lh.max)
+		if uint64(d) <= max || atomic.CompareAndSwapUint64(&lh.max, max, uint64(d)) {
+			break
+		}
+	}
+	i := 0
+	for i < len(LatencyBuckets) && d > LatencyBuckets[i] {
+		i++
+	}
+	atomic.AddUint64(&lh.buckets[i], 1)
+}
+
+func (lh *latencyHistogram) since(start time.Time) {
+	lh.observe(time.Since(start))
+}
+
+func (lh *latencyHistogram) stats() LatencyStats {
+	stats := LatencyStats{
+		Count:        atomic.LoadUint64(&lh.count),
+		TotalSeconds: time.Duration(atomic.LoadUint64(&lh.total)).Seconds(),
+		MaxSeconds:   time.Duration(atomic.LoadUint64(&lh.max)).Seconds(),
+		Buckets:      make([]uint64, len(lh.buckets)),
+	}
+	for i := range lh.buckets {
+		stats.Buckets[i] = atomic.LoadUint64(&lh.buckets[i])
+	}
+	return stats
+}
+
+type storageMetrics struct {
+	commits         uint64
+	commitBytes     uint64
+	lastCommitBytes uint64
+
+	commit *latencyHistogram
+	merkle *latencyHistogram
+	chain  *latencyHistogram
+	state  *latencyHistogram
+
+	getState *latencyHistogram
+	getBlock *latencyHistogram
+}
+
+func newStorageMetrics() *storageMetrics {
+	return &storageMetrics{
+		commit:   newLatencyHistogram(),
+		merkle:   newLatencyHistogram(),
+		chain:    newLatencyHistogram(),
+		state:    newLatencyHistogram(),
+		getState: newLatencyHistogram(),
+		getBlock: newLatencyHistogram(),
+	}
+}
+
+func (sm *storageMetrics) addCommits(count, written uint64) {
+	atomic.AddUint64(&sm.commits, count)
+	atomic.AddUint64(&sm.commitBytes, written)
+	atomic.StoreUint64(&sm.lastCommitBytes, written)
+}
+
+// Metrics returns a snapshot of the storage metrics
+func (strg *Storage) Metrics() Metrics {
+	sm := strg.metrics
+	m := Metrics{
+		Commits:         atomic.LoadUint64(&sm.commits),
+		CommitBytes:     atomic.LoadUint64(&sm.commitBytes),
+		LastCommitBytes: atomic.LoadUint64(&sm.lastCommitBytes),
+		Commit:          sm.commit.stats(),
+		Merkle:          sm.merkle.stats(),
+		Chain:           sm.chain.stats(),
+		State:           sm.state.stats(),
+		GetState:        sm.getState.stats(),
+		GetBlock:        sm.getBlock.stats(),
+	}
+	for _, level := range strg.db.Levels() {
+		m.LSMSize += level.Size
+	}
+	_, m.VlogSize = strg.db.Size()
+	m.KeyCounts = strg.keyCounts()
+	m.BlockHeight = strg.GetBlockHeight()
+	m.LeafCount = strg.merkleStore.getLeafCount().Uint64()
+	return m
+}
+
+// keyCounts splits the keys of a table evenly by the columns between its first and last keys
+func (strg *Storage) keyCounts() map[string]uint64 {
+	counts := map[string]uint64{
+		NamespaceChain:  0,
+		NamespaceState:  0,
+		NamespaceMerkle: 0,
+		NamespaceOther:  0,
+	}
+	for _, table := range strg.db.Tables() {
+		if len(table.Left) == 0 || len(table.Right) == 0 {
+			continue
+		}
+		first, last := int(table.Left[0]), int(table.Right[0])
+		cols := uint64(last - first + 1)
+		for col := first; col <= last; col++ {
+			counts[namespaceOf(byte(col))] += uint64(table.KeyCount) / cols
+		}
+	}
+	return counts
+}
+
+func namespaceOf(col byte) string {
+	switch col {
+	case colBlockByHash, colBlockHashByHeight, colBlockHeight, colLastQC,
+		colBlockCommitByHash, colTxCount, colTxByHash, colTxCommitByHash,
+		colGenesis, colCommitInProgress, colPrunedHeight, colTxHashBySender, colQCByBlockHash, colMetadata:
+		return NamespaceChain
+
+	case colStateValueByKey, colStateVersion, colStateHistoryStart, colStateNamespaces:
+		return NamespaceState
+
+	case colMerkleIndexByStateKey, colMerkleTreeHeight, colMerkleLeafCount, colMerkleNodeByPosition,
+		colLegacyMerkleNode, colMerkleLeafCountByHeight, colMerkleRootByLeafCount, colMerkleRebuild,
+		colMerkleNodeVersion, colMerkleHistoryStart, colMerkleCheckpoint:
+		return NamespaceMerkle
+
+	default:
+		return NamespaceOther
+	}
+}
+
+// countingSetter counts the bytes of keys and values written
+type countingSetter struct {
+	setter
+	written *uint64
+}
+
+func (cs *countingSetter) Set(key, value []byte) error {
+	atomic.AddUint64(cs.written, uint64(len(key)+len(value)))
+	return cs.setter.Set(key, value)
+}
+
+func (cs *countingSetter) Delete(key []byte) error {
+	atomic.AddUint64(cs.written, uint64(len(key)))
+	return cs.setter.Delete(key)
+}
+
+// countWrites wraps the update functions to count the bytes they write
+func countWrites(fns []updateFunc, written *uint64) []updateFunc {
+	ret := make([]updateFunc, len(fns))
+	for i, fn := range fns {
+		fn := fn
+		ret[i] = func(setter setter) error {
+			return fn(&countingSetter{setter, written})
+		}
+	}
+	return ret
+}
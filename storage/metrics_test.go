@@ -0,0 +1,87 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLatencyHistogram(t *testing.T) {
+	assert := assert.New(t)
+
+	lh := newLatencyHistogram()
+	lh.observe(50 * time.Microsecond)
+	lh.observe(5 * time.Millisecond)
+	lh.observe(2 * time.Second)
+	lh.observe(time.Millisecond)
+
+	stats := lh.stats()
+	assert.EqualValues(4, stats.Count)
+	assert.Equal([]uint64{1, 1, 1, 0, 0, 1}, stats.Buckets)
+	assert.Equal(2.0, stats.MaxSeconds)
+	assert.InDelta(2.00605, stats.TotalSeconds, 1e-9)
+}
+
+func TestStorage_Metrics(t *testing.T) {
+	assert := assert.New(t)
+
+	strg := newTestStorage()
+	assert.Zero(strg.Metrics().Commits)
+
+	commitTestBlocks(t, strg, 3)
+	blk, _ := strg.GetLastBlock()
+	strg.GetBlock(blk.Hash())
+	strg.GetState(nil, []byte{1})
+	strg.GetState(nil, []byte{2})
+
+	m := strg.Metrics()
+	assert.EqualValues(3, m.Commits)
+	assert.EqualValues(3, m.Commit.Count)
+	assert.EqualValues(3, m.Merkle.Count)
+	assert.EqualValues(3, m.Chain.Count)
+	assert.EqualValues(3, m.State.Count)
+	assert.Greater(m.LastCommitBytes, uint64(0))
+	assert.GreaterOrEqual(m.CommitBytes, 3*m.LastCommitBytes/2)
+	assert.EqualValues(2, m.GetState.Count)
+	assert.EqualValues(1, m.GetBlock.Count)
+	assert.Equal(strg.GetBlockHeight(), m.BlockHeight)
+	assert.Equal(strg.merkleStore.getLeafCount().Uint64(), m.LeafCount)
+}
+
+func TestStorage_MetricsKeyCounts(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	db, err := NewDB(dir)
+	assert.NoError(err)
+	strg := New(db, DefaultConfig)
+	commitStateBlocks(t, strg, [][][2]string{{{"a", "1"}, {"b", "1"}}, {{"c", "1"}}})
+	m := strg.Metrics()
+	assert.EqualValues(1, m.BlockHeight)
+	assert.EqualValues(3, m.LeafCount)
+	assert.NoError(db.Close())
+
+	// memtable is flushed on close
+	db, err = NewDB(dir)
+	assert.NoError(err)
+	defer db.Close()
+	m = New(db, DefaultConfig).Metrics()
+	assert.EqualValues(1, m.BlockHeight)
+	assert.EqualValues(3, m.LeafCount)
+	assert.Greater(m.KeyCounts[NamespaceChain], uint64(0))
+	assert.Greater(m.KeyCounts[NamespaceState], uint64(0))
+	assert.Greater(m.KeyCounts[NamespaceMerkle], uint64(0))
+}
+
+func TestNamespaceOf(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(NamespaceChain, namespaceOf(colTxByHash))
+	assert.Equal(NamespaceState, namespaceOf(colStateVersion))
+	assert.Equal(NamespaceMerkle, namespaceOf(colMerkleNodeByPosition))
+	assert.Equal(NamespaceOther, namespaceOf(colRestoredVersion))
+}
@@ -0,0 +1,105 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/aungmawjj/juria-blockchain/core"
+	"github.com/aungmawjj/juria-blockchain/logger"
+	"github.com/dgraph-io/badger/v3"
+)
+
+// migration upgrades the data layout from the previous format version
+type migration struct {
+	version uint8 // format version after the migration
+	name    string
+	run     func(strg *Storage) error // commits are locked, state reads are not
+}
+
+// migrations in order of version, the last one is FormatVersion
+var migrations = []migration{
+	{
+		version: 2,
+		name:    "sortable merkle positions",
+		run:     func(strg *Storage) error { return nil }, // nodes are moved lazily, see MigrateMerkleNodes
+	},
+	{
+		version: 3,
+		name:    "state namespaces",
+		run: func(strg *Storage) error {
+			if !strg.chainStore.getter.HasKey(flatStateKeysKey) {
+				return nil // keys are namespaced since the metadata is recorded
+			}
+			_, err := strg.migrateStateNamespaces(core.CodeAddrSize)
+			return err
+		},
+	},
+	{
+		version: 4,
+		name:    "keyed state leaves",
+		run: func(strg *Storage) error {
+			// the history has the leaves of the values only
+			if err := strg.dropMerkleHistory(); err != nil {
+				return err
+			}
+			_, err := strg.rebuildMerkleTree()
+			return err
+		},
+	},
+}
+
+// Migrate runs the migrations from the recorded format version up to FormatVersion in order.
+// The version is recorded after each migration, so an interrupted migration continues from there.
+// A db with blocks but without metadata was written before the metadata and is migrated from version zero.
+// An empty db is not migrated, the first commit records the current version.
+// New and Open run it, it's required before OpenReadOnly.
+func (strg *Storage) Migrate() error {
+	if strg.readOnly {
+		return ErrReadOnly
+	}
+	strg.mtxCommit.Lock()
+	defer strg.mtxCommit.Unlock()
+	return strg.migrate()
+}
+
+// migrate runs the migrations while commits are locked
+func (strg *Storage) migrate() error {
+	stored, err := strg.getMetadata()
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		if !strg.chainStore.getter.HasKey([]byte{colBlockHeight}) {
+			return nil
+		}
+		// older layout, the flat state keys are marked to be migrated after an interruption
+		meta := strg.metadata()
+		meta.FormatVersion = 0
+		stored, err = &meta, updateBadgerDB(strg.db, []updateFunc{
+			putMetadata(meta), setKey(flatStateKeysKey, nil),
+		})
+	}
+	if err != nil {
+		return err
+	}
+	strg.metaRecorded = true
+	if stored.FormatVersion > formatVersion {
+		return fmt.Errorf("%w, db format version %d is newer than supported %d",
+			ErrMetadataMismatch, stored.FormatVersion, formatVersion)
+	}
+	for _, m := range migrations {
+		if m.version <= stored.FormatVersion {
+			continue
+		}
+		logger.I().Infow("migrating storage", "version", m.version, "migration", m.name)
+		if err := m.run(strg); err != nil {
+			return fmt.Errorf("migrate to format version %d (%s), %w", m.version, m.name, err)
+		}
+		stored.FormatVersion = m.version
+		if err := updateBadgerDB(strg.db, []updateFunc{putMetadata(*stored)}); err != nil {
+			return err
+		}
+		strg.purgeCaches()
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func setMigrations(t *testing.T, version uint8, ms []migration) {
+	oldVersion, oldMigrations := formatVersion, migrations
+	formatVersion, migrations = version, ms
+	t.Cleanup(func() { formatVersion, migrations = oldVersion, oldMigrations })
+}
+
+func TestStorage_Migrate(t *testing.T) {
+	assert := assert.New(t)
+
+	// v1 fixture
+	dir := t.TempDir()
+	setMigrations(t, 1, nil)
+	strg, err := Open(dir, DefaultConfig)
+	assert.NoError(err)
+	commitStateBlocks(t, strg, [][][2]string{{{"a", "1"}}, {{"b", "2"}}})
+	assert.EqualValues(1, strg.Info().FormatVersion)
+	root := strg.GetMerkleRoot()
+	assert.NoError(strg.Close())
+
+	// v2 records a marker key, the first run fails
+	marker := []byte{colMetadata, 2}
+	runs := 0
+	setMigrations(t, 2, []migration{{
+		version: 2,
+		name:    "test",
+		run: func(strg *Storage) error {
+			runs++
+			if runs == 1 {
+				return errors.New("interrupted")
+			}
+			return updateBadgerDB(strg.db, []updateFunc{setKey(marker, []byte{1})})
+		},
+	}})
+	_, err = OpenReadOnly(dir, DefaultConfig)
+	assert.ErrorIs(err, ErrMigrationRequired)
+
+	_, err = Open(dir, DefaultConfig)
+	assert.Error(err)
+
+	strg, err = Open(dir, DefaultConfig)
+	assert.NoError(err)
+	assert.Equal(2, runs)
+	assert.EqualValues(2, strg.Info().FormatVersion)
+	assert.True(strg.chainStore.getter.HasKey(marker))
+	assert.Equal(root, strg.GetMerkleRoot())
+	assert.Equal([]byte("2"), strg.VerifyState(nil, []byte("b")))
+	assert.NoError(strg.Close())
+
+	// migrated once
+	strg, err = Open(dir, DefaultConfig)
+	assert.NoError(err)
+	assert.Equal(2, runs)
+	assert.NoError(strg.Close())
+
+	ro, err := OpenReadOnly(dir, DefaultConfig)
+	assert.NoError(err)
+	assert.NoError(ro.Close())
+
+	// the older binary refuses the newer db
+	setMigrations(t, 1, nil)
+	_, err = Open(dir, DefaultConfig)
+	assert.ErrorIs(err, ErrMetadataMismatch)
+	_, err = OpenReadOnly(dir, DefaultConfig)
+	assert.ErrorIs(err, ErrMetadataMismatch)
+}
+
+func TestStorage_MigrateWithoutMetadata(t *testing.T) {
+	assert := assert.New(t)
+
+	// flat state keys of a db written before the metadata
+	dir := t.TempDir()
+	strg, err := Open(dir, DefaultConfig)
+	assert.NoError(err)
+	code1 := bytes.Repeat([]byte{1}, 32)
+	commitStateBlocks(t, strg, [][][2]string{
+		{{string(code1) + "a", "1"}, {"system", "s"}},
+	})
+	leafCount := strg.merkleStore.getLeafCount()
+	assert.NoError(updateBadgerDB(strg.db, []updateFunc{deleteKey([]byte{colMetadata})}))
+	assert.NoError(strg.Close())
+
+	_, err = OpenReadOnly(dir, DefaultConfig)
+	assert.ErrorIs(err, ErrMigrationRequired)
+
+	strg, err = Open(dir, DefaultConfig)
+	if !assert.NoError(err) {
+		return
+	}
+	info := strg.Info()
+	assert.True(info.Recorded)
+	assert.EqualValues(FormatVersion, info.FormatVersion)
+	assert.Equal(leafCount, info.LeafCount)
+	assert.Equal([]byte("1"), strg.VerifyState(code1, []byte("a")))
+	assert.Equal([]byte("s"), strg.VerifyState(nil, []byte("system")))
+	assert.False(strg.chainStore.getter.HasKey(flatStateKeysKey))
+	assert.NoError(strg.Close())
+
+	// keys of a recorded db are namespaced already
+	strg, err = Open(dir, DefaultConfig)
+	assert.NoError(err)
+	assert.Equal([]byte("1"), strg.VerifyState(code1, []byte("a")))
+	assert.NoError(strg.Close())
+}
@@ -0,0 +1,197 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/aungmawjj/juria-blockchain/core"
+	"github.com/aungmawjj/juria-blockchain/logger"
+	"github.com/dgraph-io/badger/v3"
+	"golang.org/x/crypto/sha3"
+)
+
+// errors
+var (
+	ErrMissingCodeAddr = errors.New("missing code address")
+)
+
+// keys rewritten in a migration transaction
+const stateMigrationBatch = 1000
+
+// flatStateKeysKey marks a db with flat state keys until they are migrated
+var flatStateKeysKey = []byte{colStateNamespaces, 0}
+
+/*
+State keys are namespaced by code address, see core.StateKey.
+Older databases store the keys flat, the code address followed by the key.
+
+The state namespaces migration of format version 3 rewrites the state values, tree indexes and state versions
+of the flat keys in batches. It runs for a db written before the metadata, see Migrate. The hash of a code address is recorded with the first batch
+rewriting its keys, so that the rewritten keys are recognized after an interrupted migration.
+The merkle tree is unchanged, its leaves are rebuilt with the keys by the next migration.
+*/
+
+// verifyStateChanges rejects state changes without code address if it's required
+func (strg *Storage) verifyStateChanges(bcm *core.BlockCommit) error {
+	if !strg.requireCodeAddr || bcm == nil {
+		return nil
+	}
+	for _, sc := range bcm.StateChanges() {
+		if len(sc.CodeAddr()) == 0 {
+			return fmt.Errorf("%w, state key %x", ErrMissingCodeAddr, sc.Key())
+		}
+	}
+	return nil
+}
+
+// migrateStateNamespaces rewrites the flat state keys of an older database into the namespaced keys
+// and returns the number of keys rewritten, commits must be locked. The first codeAddrLen bytes of a flat key are the code address,
+// keys up to codeAddrLen bytes are system keys and kept.
+// It continues an interrupted migration and does nothing once finished.
+// The state changes of the stored block commits keep the flat keys.
+func (strg *Storage) migrateStateNamespaces(codeAddrLen int) (int, error) {
+	if strg.chainStore.getter.HasKey([]byte{colStateNamespaces}) {
+		return 0, nil // already migrated
+	}
+	started, err := strg.loadMigratedCodeAddrs()
+	if err != nil {
+		return 0, err
+	}
+	m := &stateMigration{strg: strg, codeAddrLen: codeAddrLen, started: started}
+	total := 0
+	for _, col := range []byte{colStateValueByKey, colMerkleIndexByStateKey, colStateVersion} {
+		n, err := m.migrateColumn(col)
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	if err := updateBadgerDB(strg.db, []updateFunc{
+		setKey([]byte{colStateNamespaces}, []byte{1}), deleteKey(flatStateKeysKey),
+	}); err != nil {
+		return total, err
+	}
+	logger.I().Infow("migrated state namespaces", "keys", total)
+	return total, nil
+}
+
+// loadMigratedCodeAddrs returns the hashes of code addresses whose keys are being rewritten
+func (strg *Storage) loadMigratedCodeAddrs() (map[string]struct{}, error) {
+	started := make(map[string]struct{})
+	err := strg.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{Prefix: []byte{colStateNamespaces}})
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			if key := it.Item().Key(); len(key) == 33 {
+				started[string(key[1:])] = struct{}{}
+			}
+		}
+		return nil
+	})
+	return started, err
+}
+
+type stateMigration struct {
+	strg        *Storage
+	codeAddrLen int
+	started     map[string]struct{} // hashes of code addresses
+}
+
+func (m *stateMigration) migrateColumn(col byte) (int, error) {
+	total := 0
+	seek := []byte{col}
+	for {
+		keys, values, err := m.readFlatKeys(col, seek)
+		if err != nil || len(keys) == 0 {
+			return total, err
+		}
+		if err := m.rewrite(col, keys, values); err != nil {
+			return total, err
+		}
+		total += len(keys)
+		// rewritten keys after the seek key are skipped as namespaced keys
+		seek = concatBytes(keys[len(keys)-1], []byte{0})
+	}
+}
+
+// readFlatKeys reads a batch of flat keys of the column starting at seek
+func (m *stateMigration) readFlatKeys(col byte, seek []byte) ([][]byte, [][]byte, error) {
+	keys := make([][]byte, 0)
+	values := make([][]byte, 0)
+	err := m.strg.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{PrefetchValues: true, Prefix: []byte{col}})
+		defer it.Close()
+		for it.Seek(seek); it.Valid() && len(keys) < stateMigrationBatch; it.Next() {
+			key := it.Item().KeyCopy(nil)
+			stateKey := m.stateKeyOf(col, key)
+			if len(stateKey) <= m.codeAddrLen {
+				continue // system key
+			}
+			if len(stateKey) >= 32 {
+				if _, ok := m.started[string(stateKey[:32])]; ok {
+					continue // namespaced key
+				}
+			}
+			value, err := it.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			keys = append(keys, key)
+			values = append(values, value)
+		}
+		return nil
+	})
+	return keys, values, err
+}
+
+func (m *stateMigration) rewrite(col byte, keys, values [][]byte) error {
+	updFns := make([]updateFunc, 0, 2*len(keys))
+	newStarted := make([][]byte, 0)
+	for i, key := range keys {
+		stateKey := m.stateKeyOf(col, key)
+		codeAddr := stateKey[:m.codeAddrLen]
+		h := sha3.Sum256(codeAddr)
+		if _, ok := m.started[string(h[:])]; !ok {
+			m.started[string(h[:])] = struct{}{}
+			newStarted = append(newStarted, h[:])
+		}
+		newKey := m.columnKey(col, key, core.StateKey(codeAddr, stateKey[m.codeAddrLen:]))
+		updFns = append(updFns, deleteKey(key), setKey(newKey, values[i]))
+	}
+	// recorded with the rewritten keys
+	for _, h := range newStarted {
+		updFns = append(updFns, setKey(concatBytes([]byte{colStateNamespaces}, h), nil))
+	}
+	m.strg.mtxWriteState.Lock()
+	defer m.strg.mtxWriteState.Unlock()
+	if m.strg.stateCache != nil {
+		m.strg.stateCache.purge()
+		defer m.strg.stateCache.purge()
+	}
+	return updateBadgerDB(m.strg.db, updFns)
+}
+
+// stateKeyOf returns the state key of a column key
+func (m *stateMigration) stateKeyOf(col byte, key []byte) []byte {
+	if col == colStateVersion {
+		return key[5 : len(key)-8] // prefix with key length, height
+	}
+	return key[1:]
+}
+
+// columnKey returns the column key of the new state key
+func (m *stateMigration) columnKey(col byte, key, stateKey []byte) []byte {
+	if col == colStateVersion {
+		return concatBytes(stateVersionPrefix(stateKey), key[len(key)-8:])
+	}
+	return concatBytes([]byte{col}, stateKey)
+}
+
+func setKey(key, value []byte) updateFunc {
+	return func(setter setter) error {
+		return setter.Set(key, value)
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package storage
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/aungmawjj/juria-blockchain/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func newCodeStateChange(codeAddr []byte, key, value string) *core.StateChange {
+	return core.NewStateChange().SetCodeAddr(codeAddr).SetKey([]byte(key)).SetValue([]byte(value))
+}
+
+func TestStorage_StateNamespaces(t *testing.T) {
+	assert := assert.New(t)
+
+	config := DefaultConfig
+	config.KeepStateVersions = 10
+	strg := New(createOnMemoryDB(), config)
+	code1 := bytes.Repeat([]byte{1}, 32)
+	code2 := bytes.Repeat([]byte{2}, 32)
+	commitStateChangeBlocks(t, strg, [][]*core.StateChange{
+		{newCodeStateChange(code1, "balance", "10"), newCodeStateChange(code2, "balance", "20")},
+		{newCodeStateChange(code1, "balance", "11")},
+	})
+
+	assert.Equal([]byte("11"), strg.VerifyState(code1, []byte("balance")))
+	assert.Equal([]byte("20"), strg.VerifyState(code2, []byte("balance")))
+	assert.Nil(strg.GetState(nil, []byte("balance")))
+	value, err := strg.GetStateAtHeight(code1, []byte("balance"), 0)
+	assert.NoError(err)
+	assert.Equal([]byte("10"), value)
+	assert.EqualValues(2, strg.merkleStore.getLeafCount().Int64())
+}
+
+func TestStorage_RequireCodeAddr(t *testing.T) {
+	assert := assert.New(t)
+
+	config := DefaultConfig
+	config.RequireCodeAddr = true
+	strg := New(createOnMemoryDB(), config)
+	blk := core.NewBlock().SetHeight(0).Sign(core.GenerateKey(nil))
+	err := strg.Commit(&CommitData{
+		Block: blk,
+		QC:    core.NewQuorumCert(),
+		BlockCommit: core.NewBlockCommit().SetHash(blk.Hash()).SetStateChanges([]*core.StateChange{
+			newCodeStateChange([]byte{1}, "a", "1"),
+			newCodeStateChange(nil, "b", "1"),
+		}),
+	})
+	assert.ErrorIs(err, ErrMissingCodeAddr)
+	assert.Nil(strg.GetState([]byte{1}, []byte("a")))
+}
+
+func TestStorage_MigrateStateNamespaces(t *testing.T) {
+	assert := assert.New(t)
+
+	config := DefaultConfig
+	config.KeepStateVersions = 10
+	strg := New(createOnMemoryDB(), config)
+	code1 := bytes.Repeat([]byte{1}, 32)
+	code2 := bytes.Repeat([]byte{2}, 32)
+	flat := func(codeAddr []byte, key string) string { return string(codeAddr) + key }
+	// flat keys of older databases
+	commitStateBlocks(t, strg, [][][2]string{
+		{{flat(code1, "a"), "1"}, {flat(code2, "a"), "2"}, {"system", "s"}},
+		{{flat(code1, "a"), "3"}, {flat(code2, "b"), "4"}},
+	})
+	leafCount := strg.merkleStore.getLeafCount()
+
+	// interrupted after the state values
+	m := &stateMigration{strg: strg, codeAddrLen: 32, started: make(map[string]struct{})}
+	n, err := m.migrateColumn(colStateValueByKey)
+	assert.NoError(err)
+	assert.Equal(3, n)
+
+	n, err = strg.migrateStateNamespaces(32)
+	assert.NoError(err)
+	assert.Equal(3+4, n, "tree indexes and state versions")
+	n, err = strg.migrateStateNamespaces(32)
+	assert.NoError(err)
+	assert.Zero(n, "already migrated")
+
+	// the leaves hash the new keys
+	_, err = strg.rebuildMerkleTree()
+	assert.NoError(err)
+	assert.Equal(leafCount, strg.merkleStore.getLeafCount())
+	assert.Equal([]byte("3"), strg.VerifyState(code1, []byte("a")))
+	assert.Equal([]byte("2"), strg.VerifyState(code2, []byte("a")))
+	assert.Equal([]byte("4"), strg.VerifyState(code2, []byte("b")))
+	assert.Equal([]byte("s"), strg.VerifyState(nil, []byte("system")))
+	assert.Nil(strg.GetState(nil, []byte(flat(code1, "a"))))
+	value, err := strg.GetStateAtHeight(code1, []byte("a"), 0)
+	assert.NoError(err)
+	assert.Equal([]byte("1"), value)
+
+	// the migrated keys keep their leaves
+	commitStateChangeBlocks(t, strg, [][]*core.StateChange{{newCodeStateChange(code1, "a", "5")}})
+	assert.Equal(leafCount, strg.merkleStore.getLeafCount())
+	assert.Equal([]byte("5"), strg.VerifyState(code1, []byte("a")))
+}
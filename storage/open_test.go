@@ -0,0 +1,61 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package storage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpen_InMemory(t *testing.T) {
+	assert := assert.New(t)
+
+	config := DefaultConfig
+	config.InMemory = true
+	config.Compression = CompressionZSTD
+	strg, err := Open("", config)
+	assert.NoError(err)
+
+	commitStateBlocks(t, strg, [][][2]string{{{"a", "1"}, {"b", "2"}}, {{"a", "3"}}})
+	assert.EqualValues(1, strg.GetBlockHeight())
+	assert.Equal([]byte("3"), strg.VerifyState(nil, []byte("a")))
+	assert.Equal([]byte("2"), strg.VerifyState(nil, []byte("b")))
+	sp, err := strg.GetStateKeyProof([]byte("a"))
+	assert.NoError(err)
+	assert.True(VerifyStateKeyProof(strg.GetMerkleRoot(), sp, config))
+
+	assert.NoError(strg.Close())
+	assert.True(strg.db.IsClosed(), "db opened by Open is closed")
+}
+
+func TestOpen_Options(t *testing.T) {
+	assert := assert.New(t)
+
+	config := DefaultConfig
+	config.Compression = "lz4"
+	_, err := Open(t.TempDir(), config)
+	assert.Error(err)
+
+	config = DefaultConfig
+	config.Compression = CompressionNone
+	config.SyncWrites = true
+	config.ValueLogFileSize = 1 << 20
+	opts, err := config.badgerOptions("dir")
+	assert.NoError(err)
+	assert.True(opts.SyncWrites)
+	assert.EqualValues(1<<20, opts.ValueLogFileSize)
+	assert.Equal("dir", opts.Dir)
+
+	dir := t.TempDir()
+	strg, err := Open(dir, config)
+	assert.NoError(err)
+	commitStateBlocks(t, strg, [][][2]string{{{"a", "1"}}})
+	assert.NoError(strg.Close())
+
+	strg, err = Open(dir, config)
+	assert.NoError(err)
+	defer strg.Close()
+	assert.Equal([]byte("1"), strg.VerifyState(nil, []byte("a")))
+}
@@ -0,0 +1,101 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package storage
+
+import (
+	"fmt"
+
+	"github.com/aungmawjj/juria-blockchain/core"
+	"github.com/aungmawjj/juria-blockchain/logger"
+	"github.com/dgraph-io/badger/v3"
+)
+
+// CleanOrphans deletes the stored blocks below beforeHeight which are not on the commited chain,
+// together with their qc and block commit, and returns the number of blocks deleted.
+// Consensus keeps uncommited blocks in memory, orphans are only left by chain data written outside of Commit.
+func (strg *Storage) CleanOrphans(beforeHeight uint64) (int, error) {
+	if strg.readOnly {
+		return 0, ErrReadOnly
+	}
+	strg.mtxCommit.Lock()
+	defer strg.mtxCommit.Unlock()
+
+	height, err := strg.chainStore.getBlockHeight()
+	if err != nil {
+		return 0, nil // no blocks yet
+	}
+	if beforeHeight > height {
+		beforeHeight = height
+	}
+	orphans, err := strg.findOrphans(beforeHeight)
+	if err != nil {
+		return 0, err
+	}
+	for _, hash := range orphans {
+		if err := updateBadgerDB(strg.db, []updateFunc{
+			deleteKey(concatBytes([]byte{colBlockByHash}, hash)),
+			deleteKey(concatBytes([]byte{colQCByBlockHash}, hash)),
+			deleteKey(concatBytes([]byte{colBlockCommitByHash}, hash)),
+		}); err != nil {
+			return 0, fmt.Errorf("delete orphan block %x, %w", hash, err)
+		}
+		strg.invalidateBlock(hash)
+	}
+	if len(orphans) > 0 {
+		logger.I().Infow("cleaned orphan blocks", "count", len(orphans), "before", beforeHeight)
+	}
+	return len(orphans), nil
+}
+
+// findOrphans returns the hashes of the stored blocks below beforeHeight
+// which are not indexed by height
+func (strg *Storage) findOrphans(beforeHeight uint64) ([][]byte, error) {
+	orphans := make([][]byte, 0)
+	err := strg.db.View(func(txn *badger.Txn) error {
+		canonical, err := loadCanonicalHashes(txn)
+		if err != nil {
+			return err
+		}
+		prefix := []byte{colBlockByHash}
+		it := txn.NewIterator(badger.IteratorOptions{Prefix: prefix})
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			hash := it.Item().KeyCopy(nil)[len(prefix):]
+			if _, found := canonical[string(hash)]; found {
+				continue
+			}
+			b, err := it.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			blk := core.NewBlock()
+			if err := blk.Unmarshal(b); err != nil {
+				return fmt.Errorf("cannot load block %x, %w", hash, err)
+			}
+			if blk.Height() < beforeHeight {
+				orphans = append(orphans, hash)
+			}
+		}
+		return nil
+	})
+	return orphans, err
+}
+
+// loadCanonicalHashes returns the hashes of the commited blocks
+func loadCanonicalHashes(txn *badger.Txn) (map[string]struct{}, error) {
+	canonical := make(map[string]struct{})
+	it := txn.NewIterator(badger.IteratorOptions{
+		PrefetchValues: true,
+		Prefix:         []byte{colBlockHashByHeight},
+	})
+	defer it.Close()
+	for it.Rewind(); it.Valid(); it.Next() {
+		hash, err := it.Item().ValueCopy(nil)
+		if err != nil {
+			return nil, err
+		}
+		canonical[string(hash)] = struct{}{}
+	}
+	return canonical, nil
+}
@@ -0,0 +1,76 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package storage
+
+import (
+	"testing"
+
+	"github.com/aungmawjj/juria-blockchain/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStorage_CleanOrphans(t *testing.T) {
+	assert := assert.New(t)
+
+	strg := newTestStorage()
+	n, err := strg.CleanOrphans(10)
+	assert.NoError(err)
+	assert.Zero(n, "no blocks")
+
+	commitTestBlocks(t, strg, 5)
+
+	// a fork from block 0 which lost, stored with its qc
+	priv := core.GenerateKey(nil)
+	parent, err := strg.GetBlockByHeight(0)
+	assert.NoError(err)
+	fork := make([]*core.Block, 3)
+	for i := range fork {
+		fork[i] = core.NewBlock().SetHeight(parent.Height() + 1).SetParentHash(parent.Hash()).
+			SetQuorumCert(core.NewQuorumCert().Build([]*core.Vote{parent.ProposerVote()})).
+			SetTimestamp(int64(i + 1)).Sign(priv)
+		qc := core.NewQuorumCert().Build([]*core.Vote{fork[i].ProposerVote()})
+		assert.NoError(updateBadgerDB(strg.db, []updateFunc{
+			strg.chainStore.setBlockByHash(fork[i]),
+			strg.chainStore.setQC(fork[i].Hash(), qc),
+		}))
+		parent = fork[i]
+	}
+	for _, blk := range fork {
+		_, err := strg.GetBlock(blk.Hash())
+		assert.NoError(err)
+	}
+
+	// fork blocks at height 1 and 2 are below 3
+	n, err = strg.CleanOrphans(3)
+	assert.NoError(err)
+	assert.Equal(2, n)
+	for _, blk := range fork[:2] {
+		_, err := strg.GetBlock(blk.Hash())
+		assert.Error(err)
+		_, err = strg.GetQC(blk.Hash())
+		assert.Error(err)
+	}
+	_, err = strg.GetBlock(fork[2].Hash())
+	assert.NoError(err)
+
+	// limited to the commited height
+	n, err = strg.CleanOrphans(10)
+	assert.NoError(err)
+	assert.Equal(1, n)
+	_, err = strg.GetBlock(fork[2].Hash())
+	assert.Error(err)
+
+	// canonical blocks remain
+	for h := uint64(0); h < 5; h++ {
+		blk, err := strg.GetBlockByHeight(h)
+		if assert.NoError(err) {
+			assert.Equal(h, blk.Height())
+		}
+		_, err = strg.GetQC(blk.Hash())
+		assert.NoError(err)
+	}
+	n, err = strg.CleanOrphans(10)
+	assert.NoError(err)
+	assert.Zero(n)
+}
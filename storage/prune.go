@@ -0,0 +1,156 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aungmawjj/juria-blockchain/core"
+	"github.com/aungmawjj/juria-blockchain/logger"
+	"github.com/dgraph-io/badger/v3"
+)
+
+// errors
+var (
+	ErrPruned = errors.New("pruned")
+)
+
+// Prune deletes txs with their sender index, tx commits, block commits and block bodies
+// of the blocks below last height - keepRecent. Block headers and qcs are kept for the qc chain,
+// see GetBlockHeader. Pruned txs leave an empty value so that they are still known as commited.
+func (strg *Storage) Prune(keepRecent uint64) error {
+	height, err := strg.chainStore.getBlockHeight()
+	if err != nil {
+		return nil // no blocks yet
+	}
+	if height < keepRecent {
+		return nil
+	}
+	return strg.PruneBefore(height - keepRecent)
+}
+
+// PruneBefore prunes the blocks below beforeHeight as Prune does.
+// The last block is not pruned, ErrHeightNotCommitted is returned if beforeHeight is above it.
+// It stops early once the storage is closed, the pruned height is kept to resume from.
+func (strg *Storage) PruneBefore(beforeHeight uint64) error {
+	if strg.readOnly {
+		return ErrReadOnly
+	}
+	strg.mtxPrune.Lock()
+	defer strg.mtxPrune.Unlock()
+
+	height, err := strg.chainStore.getBlockHeight()
+	if err != nil || beforeHeight > height {
+		return fmt.Errorf("%w, prune before %d", ErrHeightNotCommitted, beforeHeight)
+	}
+	start := strg.chainStore.getPrunedHeight()
+	h := start
+	for ; h < beforeHeight && !strg.isClosed(); h++ {
+		if err := strg.pruneBlock(h); err != nil {
+			return fmt.Errorf("prune block %d, %w", h, err)
+		}
+	}
+	if start < h {
+		logger.I().Infow("pruned blocks", "from", start, "to", h-1)
+	}
+	return nil
+}
+
+// pruneBlock prunes a block in one transaction, together with the pruned height
+func (strg *Storage) pruneBlock(height uint64) error {
+	blk, err := strg.chainStore.getBlockByHeight(height)
+	if err != nil {
+		return err
+	}
+	updFns := make([]updateFunc, 0, 2*len(blk.TransactionsRef())+3)
+	// txs commited by older blocks are below the height too
+	for i, hash := range blk.TransactionsRef() {
+		// txs of older blocks are already pruned, their sender index is at the older height
+		if tx, err := strg.chainStore.getTx(hash); err == nil && tx.Sender() != nil {
+			updFns = append(updFns, deleteKey(txBySenderKey(tx.Sender(), height, uint32(i))))
+		}
+		updFns = append(updFns, strg.chainStore.setTxPruned(hash))
+		updFns = append(updFns, deleteKey(concatBytes([]byte{colTxCommitByHash}, hash)))
+	}
+	updFns = append(updFns, deleteKey(concatBytes([]byte{colBlockCommitByHash}, blk.Hash())))
+	updFns = append(updFns, strg.chainStore.setBlockByHash(blk.Header()))
+	updFns = append(updFns, strg.chainStore.setPrunedHeight(height+1))
+	strg.invalidateBlock(blk.Hash())
+	defer strg.invalidateBlock(blk.Hash())
+	return updateBadgerDB(strg.db, updFns)
+}
+
+// pruneLoop prunes blocks, state and merkle versions, each is skipped if its keep count is zero
+func (strg *Storage) pruneLoop(interval time.Duration, keepRecent, keepVersions, keepMerkleVersions uint64) {
+	defer strg.wgLoops.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-strg.closeCh:
+			return
+		case <-ticker.C:
+		}
+		if keepRecent > 0 {
+			if err := strg.Prune(keepRecent); err != nil {
+				logger.I().Errorw("prune storage failed", "error", err)
+			}
+		}
+		if keepVersions > 0 {
+			if err := strg.PruneStateVersions(keepVersions); err != nil {
+				logger.I().Errorw("prune state versions failed", "error", err)
+			}
+		}
+		if keepMerkleVersions > 0 {
+			if err := strg.PruneMerkleVersions(keepMerkleVersions); err != nil {
+				logger.I().Errorw("prune merkle versions failed", "error", err)
+			}
+		}
+	}
+}
+
+// getPrunedHeight returns the lowest height of the blocks which are not pruned
+func (cs *chainStore) getPrunedHeight() uint64 {
+	b, err := cs.getter.Get([]byte{colPrunedHeight})
+	if err != nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(b)
+}
+
+func (cs *chainStore) isTxPruned(hash []byte) bool {
+	val, err := cs.getter.Get(concatBytes([]byte{colTxByHash}, hash))
+	return err == nil && len(val) == 0
+}
+
+func (cs *chainStore) setPrunedHeight(height uint64) updateFunc {
+	return func(setter setter) error {
+		return setter.Set([]byte{colPrunedHeight}, uint64BEBytes(height))
+	}
+}
+
+func (cs *chainStore) setTxPruned(hash []byte) updateFunc {
+	return func(setter setter) error {
+		return setter.Set(concatBytes([]byte{colTxByHash}, hash), []byte{})
+	}
+}
+
+// checkPruned returns ErrPruned if the body of blk is pruned
+func (cs *chainStore) checkPruned(blk *core.Block) (*core.Block, error) {
+	if blk.Height() < cs.getPrunedHeight() {
+		return nil, fmt.Errorf("%w, block %d", ErrPruned, blk.Height())
+	}
+	return blk, nil
+}
+
+// prunedError returns ErrPruned for the missing data of a pruned block
+func (cs *chainStore) prunedError(err error, height uint64) error {
+	if errors.Is(err, badger.ErrKeyNotFound) && height < cs.getPrunedHeight() {
+		return fmt.Errorf("%w, block %d", ErrPruned, height)
+	}
+	return err
+}
@@ -0,0 +1,197 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aungmawjj/juria-blockchain/core"
+	"github.com/stretchr/testify/assert"
+)
+
+// commitTestBlocks commits blocks with one tx each from the current height
+func commitTestBlocks(t *testing.T, strg *Storage, count int) []*core.Transaction {
+	priv := core.GenerateKey(nil)
+	txs := make([]*core.Transaction, count)
+	var parent *core.Block
+	start := uint64(0)
+	if blk, err := strg.GetLastBlock(); err == nil {
+		parent = blk
+		start = blk.Height() + 1
+	}
+	for i := range txs {
+		height := start + uint64(i)
+		txs[i] = core.NewTransaction().SetNonce(int64(height)).Sign(priv)
+		blk := core.NewBlock().SetHeight(height).SetTransactions([][]byte{txs[i].Hash()})
+		if parent != nil {
+			blk.SetParentHash(parent.Hash()).
+				SetQuorumCert(core.NewQuorumCert().Build([]*core.Vote{parent.ProposerVote()}))
+		}
+		blk.Sign(priv)
+		err := strg.Commit(&CommitData{
+			Block:        blk,
+			QC:           core.NewQuorumCert(),
+			Transactions: []*core.Transaction{txs[i]},
+			TxCommits: []*core.TxCommit{
+				core.NewTxCommit().SetHash(txs[i].Hash()).SetBlockHash(blk.Hash()).SetBlockHeight(height),
+			},
+			BlockCommit: core.NewBlockCommit().SetHash(blk.Hash()).
+				SetStateChanges([]*core.StateChange{
+					core.NewStateChange().SetKey([]byte{uint8(height)}).SetValue([]byte{1}),
+				}),
+		})
+		assert.NoError(t, err)
+		parent = blk
+	}
+	return txs
+}
+
+func TestStorage_Prune(t *testing.T) {
+	assert := assert.New(t)
+
+	strg := newTestStorage()
+	assert.NoError(strg.Prune(3), "no blocks")
+
+	txs := commitTestBlocks(t, strg, 10)
+	root := strg.GetMerkleRoot()
+
+	assert.NoError(strg.Prune(20), "nothing to prune")
+	assert.EqualValues(0, strg.GetPrunedHeight())
+
+	assert.NoError(strg.Prune(3))
+	assert.EqualValues(6, strg.GetPrunedHeight())
+
+	for i, tx := range txs {
+		blk, err := strg.GetBlockHeaderByHeight(uint64(i))
+		assert.NoError(err, "headers are kept")
+		assert.Empty(blk.Transactions())
+		_, err = strg.GetQC(blk.Hash())
+		assert.NoError(err, "qcs are kept")
+		assert.True(strg.HasTx(tx.Hash()))
+
+		stored, err := strg.chainStore.getBlock(blk.Hash())
+		assert.NoError(err)
+		_, errBlk := strg.GetBlock(blk.Hash())
+		_, errHeight := strg.GetBlockByHeight(uint64(i))
+
+		_, errTx := strg.GetTx(tx.Hash())
+		_, errTxc := strg.GetTxCommit(tx.Hash())
+		_, errBcm := strg.GetBlockCommit(blk.Hash())
+		_, errProof := strg.GetTxProof(tx.Hash())
+		if i < 6 {
+			assert.Empty(stored.Transactions(), "body is deleted")
+			assert.ErrorIs(errBlk, ErrPruned)
+			assert.ErrorIs(errHeight, ErrPruned)
+			assert.ErrorIs(errTx, ErrPruned)
+			assert.ErrorIs(errTxc, ErrPruned)
+			assert.ErrorIs(errBcm, ErrPruned)
+			assert.ErrorIs(errProof, ErrPruned)
+		} else {
+			assert.Equal([][]byte{tx.Hash()}, stored.Transactions())
+			assert.NoError(errBlk)
+			assert.NoError(errHeight)
+			assert.NoError(errTx)
+			assert.NoError(errTxc)
+			assert.NoError(errBcm)
+			assert.NoError(errProof)
+		}
+	}
+	_, err := strg.GetBlocksByRange(5, 7)
+	assert.ErrorIs(err, ErrPruned)
+	blocks, err := strg.GetBlocksByRange(6, 9)
+	assert.NoError(err)
+	assert.Len(blocks, 4)
+	assert.Equal(root, strg.GetMerkleRoot(), "state is not pruned")
+	assert.Equal([]byte{1}, strg.GetState(nil, []byte{0}))
+
+	_, err = strg.GetTx([]byte("unknown"))
+	assert.Error(err)
+	assert.NotErrorIs(err, ErrPruned)
+
+	assert.NoError(strg.Prune(3))
+	assert.EqualValues(6, strg.GetPrunedHeight())
+
+	// block with pruned old block tx
+	priv := core.GenerateKey(nil)
+	last, _ := strg.GetLastBlock()
+	tx := core.NewTransaction().SetNonce(100).Sign(priv)
+	blk := core.NewBlock().
+		SetHeight(10).
+		SetParentHash(last.Hash()).
+		SetQuorumCert(core.NewQuorumCert().Build([]*core.Vote{last.ProposerVote()})).
+		SetTransactions([][]byte{txs[1].Hash(), tx.Hash()}).
+		Sign(priv)
+	assert.NoError(strg.Commit(&CommitData{
+		Block:        blk,
+		QC:           core.NewQuorumCert(),
+		Transactions: []*core.Transaction{tx},
+		BlockCommit:  core.NewBlockCommit().SetHash(blk.Hash()).SetOldBlockTxs([][]byte{txs[1].Hash()}),
+	}))
+	assert.EqualValues(10, strg.GetBlockHeight())
+}
+
+func TestStorage_PruneBefore(t *testing.T) {
+	assert := assert.New(t)
+
+	strg := newTestStorage()
+	assert.ErrorIs(strg.PruneBefore(0), ErrHeightNotCommitted, "no blocks")
+
+	txs := commitTestBlocks(t, strg, 8)
+	assert.ErrorIs(strg.PruneBefore(8), ErrHeightNotCommitted, "above last block")
+	assert.EqualValues(0, strg.GetPrunedHeight())
+
+	assert.NoError(strg.PruneBefore(5))
+	assert.EqualValues(5, strg.GetPrunedHeight())
+	assert.NoError(strg.PruneBefore(2), "already pruned")
+	assert.EqualValues(5, strg.GetPrunedHeight())
+
+	for i, tx := range txs {
+		blk, err := strg.GetBlockHeaderByHeight(uint64(i))
+		assert.NoError(err, "headers are kept")
+		assert.Equal(uint64(i), blk.Height())
+		_, errBlk := strg.GetBlockByHeight(uint64(i))
+		_, err = strg.GetTx(tx.Hash())
+		if i < 5 {
+			assert.ErrorIs(errBlk, ErrPruned)
+			assert.ErrorIs(err, ErrPruned)
+		} else {
+			assert.NoError(errBlk)
+			assert.NoError(err)
+		}
+		assert.Equal([]byte{1}, strg.GetState(nil, []byte{uint8(i)}), "state is kept")
+	}
+	qc, err := strg.GetLastQC()
+	assert.NoError(err)
+	assert.NotNil(qc)
+
+	assert.NoError(strg.PruneBefore(7), "all but the last block")
+	_, err = strg.GetTx(txs[6].Hash())
+	assert.ErrorIs(err, ErrPruned)
+	_, err = strg.GetTx(txs[7].Hash())
+	assert.NoError(err)
+}
+
+func TestStorage_PruneLoop(t *testing.T) {
+	assert := assert.New(t)
+
+	config := DefaultConfig
+	config.PruneInterval = 10 * time.Millisecond
+	config.KeepRecentBlocks = 2
+	strg := New(createOnMemoryDB(), config)
+	commitTestBlocks(t, strg, 5)
+
+	assert.Eventually(func() bool {
+		return strg.GetPrunedHeight() == 2
+	}, time.Second, 10*time.Millisecond)
+
+	// the loop stops with the storage, the db is still open for the caller
+	assert.NoError(strg.Close())
+	commitTestBlocks(t, strg, 3)
+	time.Sleep(50 * time.Millisecond)
+	assert.EqualValues(2, strg.GetPrunedHeight())
+
+	assert.NoError(strg.PruneBefore(6))
+	assert.EqualValues(2, strg.GetPrunedHeight(), "closed storage isn't pruned")
+}
@@ -0,0 +1,156 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package storage
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/aungmawjj/juria-blockchain/core"
+)
+
+// readCache is a bounded lru cache for values read from db.
+// Writers call invalidate for the written keys before and after the db write,
+// so that a value is never served stale once the write is commited.
+type readCache struct {
+	capacity int
+
+	mtx     sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // front is most recently used
+
+	// increased on every invalidate, values read before it are not cached after it
+	generation uint64
+}
+
+type readCacheEntry struct {
+	key   string
+	value interface{}
+}
+
+// newReadCache returns nil if capacity is not positive, nil cache is disabled
+func newReadCache(capacity int) *readCache {
+	if capacity <= 0 {
+		return nil
+	}
+	return &readCache{
+		capacity: capacity,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// get returns the cached value and the generation to put the value read on miss
+func (rc *readCache) get(key string) (interface{}, bool, uint64) {
+	rc.mtx.Lock()
+	defer rc.mtx.Unlock()
+	if e, ok := rc.entries[key]; ok {
+		rc.order.MoveToFront(e)
+		return e.Value.(*readCacheEntry).value, true, rc.generation
+	}
+	return nil, false, rc.generation
+}
+
+// put caches the value unless the cache is invalidated since generation
+func (rc *readCache) put(key string, value interface{}, generation uint64) {
+	rc.mtx.Lock()
+	defer rc.mtx.Unlock()
+	if generation != rc.generation {
+		return
+	}
+	if e, ok := rc.entries[key]; ok {
+		e.Value.(*readCacheEntry).value = value
+		rc.order.MoveToFront(e)
+		return
+	}
+	rc.entries[key] = rc.order.PushFront(&readCacheEntry{key, value})
+	for rc.order.Len() > rc.capacity {
+		last := rc.order.Back()
+		rc.order.Remove(last)
+		delete(rc.entries, last.Value.(*readCacheEntry).key)
+	}
+}
+
+func (rc *readCache) invalidate(keys []string) {
+	rc.mtx.Lock()
+	defer rc.mtx.Unlock()
+	rc.generation++
+	for _, key := range keys {
+		if e, ok := rc.entries[key]; ok {
+			rc.order.Remove(e)
+			delete(rc.entries, key)
+		}
+	}
+}
+
+func (rc *readCache) purge() {
+	rc.mtx.Lock()
+	defer rc.mtx.Unlock()
+	rc.generation++
+	rc.entries = make(map[string]*list.Element)
+	rc.order.Init()
+}
+
+func (rc *readCache) len() int {
+	rc.mtx.Lock()
+	defer rc.mtx.Unlock()
+	return rc.order.Len()
+}
+
+// getState reads state value through the state cache, not found values are cached as nil
+func (strg *Storage) getState(key []byte) []byte {
+	if strg.stateCache == nil {
+		return strg.stateStore.getStateNotFoundNil(key)
+	}
+	v, ok, generation := strg.stateCache.get(string(key))
+	if ok {
+		return v.([]byte)
+	}
+	value := strg.stateStore.getStateNotFoundNil(key)
+	strg.stateCache.put(string(key), value, generation)
+	return value
+}
+
+// getBlock reads block through the block cache, blocks are immutable once commited
+func (strg *Storage) getBlock(hash []byte) (*core.Block, error) {
+	if strg.blockCache == nil {
+		return strg.chainStore.getBlock(hash)
+	}
+	v, ok, generation := strg.blockCache.get(string(hash))
+	if ok {
+		return v.(*core.Block), nil
+	}
+	blk, err := strg.chainStore.getBlock(hash)
+	if err != nil {
+		return nil, err
+	}
+	strg.blockCache.put(string(hash), blk, generation)
+	return blk, nil
+}
+
+func (strg *Storage) getBlockByHeight(height uint64) (*core.Block, error) {
+	hash, err := strg.chainStore.getBlockHashByHeight(height)
+	if err != nil {
+		return nil, err
+	}
+	return strg.getBlock(hash)
+}
+
+func (strg *Storage) invalidateStates(scList []*core.StateChange) {
+	if strg.stateCache == nil {
+		return
+	}
+	keys := make([]string, len(scList))
+	for i, sc := range scList {
+		keys[i] = string(sc.StateKey())
+	}
+	strg.stateCache.invalidate(keys)
+}
+
+func (strg *Storage) invalidateBlock(hash []byte) {
+	if strg.blockCache == nil {
+		return
+	}
+	strg.blockCache.invalidate([]string{string(hash)})
+}
@@ -0,0 +1,127 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package storage
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/aungmawjj/juria-blockchain/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadCache(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Nil(newReadCache(0))
+
+	rc := newReadCache(2)
+	_, ok, gen := rc.get("a")
+	assert.False(ok)
+	rc.put("a", 1, gen)
+	rc.put("b", 2, gen)
+	rc.get("a")
+	rc.put("c", 3, gen) // evicts b
+	assert.Equal(2, rc.len())
+	v, ok, _ := rc.get("a")
+	assert.True(ok)
+	assert.Equal(1, v)
+	_, ok, _ = rc.get("b")
+	assert.False(ok)
+
+	_, _, gen = rc.get("d")
+	rc.invalidate([]string{"a"})
+	rc.put("d", 4, gen)
+	_, ok, _ = rc.get("d")
+	assert.False(ok, "read before invalidate")
+	_, ok, _ = rc.get("a")
+	assert.False(ok)
+	_, ok, _ = rc.get("c")
+	assert.True(ok)
+
+	rc.purge()
+	assert.Equal(0, rc.len())
+}
+
+func TestStorage_ReadCache(t *testing.T) {
+	assert := assert.New(t)
+
+	config := DefaultConfig
+	config.StateCacheSize = 10
+	config.BlockCacheSize = 10
+	strg := New(createOnMemoryDB(), config)
+
+	assert.Nil(strg.GetState(nil, []byte("a")))
+	commitStateBlocks(t, strg, [][][2]string{{{"a", "1"}, {"b", "1"}}})
+	assert.Equal([]byte("1"), strg.GetState(nil, []byte("a")), "not found is invalidated")
+	assert.Equal([]byte("1"), strg.GetState(nil, []byte("b")))
+
+	commitStateBlocks(t, strg, [][][2]string{{{"a", "2"}}})
+	assert.Equal([]byte("2"), strg.GetState(nil, []byte("a")))
+	assert.Equal([]byte("1"), strg.GetState(nil, []byte("b")))
+	assert.Equal([]byte("2"), strg.VerifyState(nil, []byte("a")))
+
+	// batch commits and deletes invalidate the cached values too
+	blk, err := strg.GetLastBlock()
+	assert.NoError(err)
+	assert.NoError(strg.CommitBatch(newBatchTestData(core.GenerateKey(nil), blk, [][][2]string{{{"a", "3"}}})))
+	assert.Equal([]byte("3"), strg.GetState(nil, []byte("a")))
+	commitStateChangeBlocks(t, strg, [][]*core.StateChange{{
+		core.NewStateChange().SetKey([]byte("a")).SetDeleted(true),
+	}})
+	assert.Nil(strg.GetState(nil, []byte("a")))
+
+	blk, err = strg.GetLastBlock()
+	assert.NoError(err)
+	blk2, err := strg.GetBlock(blk.Hash())
+	assert.NoError(err)
+	assert.Same(blk, blk2, "cached block")
+	assert.Equal(4, strg.blockCache.len())
+
+	_, err = strg.GetBlock([]byte("unknown"))
+	assert.Error(err)
+	assert.Equal(4, strg.blockCache.len(), "not found blocks are not cached")
+}
+
+func benchmarkStorageGetState(b *testing.B, cacheSize int) {
+	config := DefaultConfig
+	config.StateCacheSize = cacheSize
+	strg := New(createOnMemoryDB(), config)
+
+	const keys = 10000
+	kvs := make([][2]string, keys)
+	for i := range kvs {
+		kvs[i] = [2]string{fmt.Sprintf("balance-%d", i), fmt.Sprintf("%d", i)}
+	}
+	blocks := make([][][2]string, 0)
+	for len(kvs) > 0 {
+		n := 1000
+		if n > len(kvs) {
+			n = len(kvs)
+		}
+		blocks = append(blocks, kvs[:n])
+		kvs = kvs[n:]
+	}
+	commitStateBlocks(b, strg, blocks)
+
+	// a few accounts are accessed by most txs
+	zipf := rand.NewZipf(rand.New(rand.NewSource(1)), 1.1, 1, keys-1)
+	reads := make([][]byte, 100000)
+	for i := range reads {
+		reads[i] = []byte(fmt.Sprintf("balance-%d", zipf.Uint64()))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		strg.GetState(nil, reads[i%len(reads)])
+	}
+}
+
+func BenchmarkStorage_GetStateNoCache(b *testing.B) {
+	benchmarkStorageGetState(b, 0)
+}
+
+func BenchmarkStorage_GetStateCache(b *testing.B) {
+	benchmarkStorageGetState(b, 1000)
+}
@@ -0,0 +1,40 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/aungmawjj/juria-blockchain/logger"
+	"github.com/dgraph-io/badger/v3"
+)
+
+// errors
+var (
+	ErrReadOnly = errors.New("read-only storage")
+)
+
+// OpenReadOnly opens the db at dir to inspect blocks and state, all writes return ErrReadOnly.
+// Badger doesn't allow it while the node has the db open, so it is meant for a stopped node or a restored backup.
+// A half-applied commit is not rolled back, reads see the commits applied before it.
+// Close closes the db.
+func OpenReadOnly(dir string, config Config) (*Storage, error) {
+	if !config.stateHashFunc().Available() {
+		return nil, fmt.Errorf("state hash function %d not available", config.StateHashFunc)
+	}
+	db, err := badger.Open(badger.DefaultOptions(dir).WithReadOnly(true).WithLoggingLevel(badger.WARNING))
+	if err != nil {
+		return nil, fmt.Errorf("open read-only db, %w", err)
+	}
+	strg := newStorage(db, config, true)
+	if err := strg.checkMetadata(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if strg.chainStore.getter.HasKey([]byte{colCommitInProgress}) {
+		logger.I().Warn("read-only storage has a half-applied commit")
+	}
+	return strg, nil
+}
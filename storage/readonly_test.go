@@ -0,0 +1,59 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package storage
+
+import (
+	"testing"
+
+	"github.com/aungmawjj/juria-blockchain/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenReadOnly(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	db, err := NewDB(dir)
+	assert.NoError(err)
+	strg := New(db, DefaultConfig)
+	commitStateBlocks(t, strg, [][][2]string{{{"a", "1"}, {"b", "1"}}, {{"a", "2"}}})
+	root := strg.GetMerkleRoot()
+
+	_, err = OpenReadOnly(dir, DefaultConfig)
+	assert.Error(err, "db opened by another storage")
+	assert.NoError(db.Close())
+
+	ro, err := OpenReadOnly(dir, DefaultConfig)
+	if !assert.NoError(err) {
+		return
+	}
+	ro2, err := OpenReadOnly(dir, DefaultConfig)
+	if assert.NoError(err, "multiple readers") {
+		assert.NoError(ro2.Close())
+	}
+
+	assert.EqualValues(1, ro.GetBlockHeight())
+	assert.Equal(root, ro.GetMerkleRoot())
+	assert.Equal([]byte("2"), ro.VerifyState(nil, []byte("a")))
+	assert.Equal([]byte("1"), ro.GetState(nil, []byte("b")))
+	blk, err := ro.GetBlockByHeight(1)
+	assert.NoError(err)
+	assert.EqualValues(1, blk.Height())
+
+	priv := core.GenerateKey(nil)
+	next := core.NewBlock().SetHeight(2).SetParentHash(blk.Hash()).Sign(priv)
+	err = ro.Commit(&CommitData{
+		Block:       next,
+		QC:          core.NewQuorumCert(),
+		BlockCommit: core.NewBlockCommit().SetHash(next.Hash()),
+	})
+	assert.ErrorIs(err, ErrReadOnly)
+	assert.ErrorIs(ro.CommitBatch(nil), ErrReadOnly)
+	assert.ErrorIs(ro.PruneBefore(1), ErrReadOnly)
+	assert.ErrorIs(ro.RunValueLogGC(0.5), ErrReadOnly)
+	assert.EqualValues(1, ro.GetBlockHeight())
+
+	assert.NoError(ro.Close())
+	assert.NoError(ro.Close())
+}
@@ -0,0 +1,524 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+
+	"github.com/aungmawjj/juria-blockchain/core"
+	"github.com/aungmawjj/juria-blockchain/logger"
+	"github.com/aungmawjj/juria-blockchain/merkle"
+	"github.com/dgraph-io/badger/v3"
+)
+
+// errors
+var (
+	ErrInvalidSnapshot  = errors.New("invalid snapshot")
+	ErrSnapshotMismatch = errors.New("snapshot mismatch")
+	ErrStorageNotEmpty  = errors.New("storage not empty")
+)
+
+var snapshotMagic = []byte("juriasnap")
+
+// SnapshotVersion follows the magic bytes of snapshot
+const SnapshotVersion = 2
+
+// snapshots before the deleted flag of entries
+const snapshotVersionNoDeletes = 1
+
+// state entries per chunk, a chunk is imported in one transaction
+var snapshotChunkSize = 1000
+
+// maximum length of a field, so that a corrupted length doesn't allocate too much memory
+const maxSnapshotFieldSize = 64 << 20
+
+// maximum entries of a chunk, the exporter may use a different chunk size
+const maxSnapshotChunkEntries = 1 << 20
+
+/*
+snapshot of the committed state at the last block height
+
+	magic, version (1 byte)
+	header (length prefixed)
+		last block, has qc (uvarint), last qc
+		block commit of the last block which changed state
+		leaf count, entry count (uvarint)
+	chunks
+		offset, entry count (uvarint)
+		key, value, tree index, deleted (uvarint) of each entry
+
+Deleted keys keep their cleared leaves, they are exported with empty values.
+
+Byte fields are prefixed with their length as uvarint.
+Chunks carry the offset of their first entry, so that an interrupted import
+can be resumed by importing the same snapshot again.
+*/
+type snapshotHeader struct {
+	lastBlock   *core.Block
+	lastQC      *core.QuorumCert
+	stateCommit *core.BlockCommit // nil if state is empty
+	leafCount   *big.Int
+	entryCount  uint64
+}
+
+type snapshotEntry struct {
+	key     []byte
+	value   []byte
+	index   []byte
+	deleted bool
+}
+
+// ExportSnapshot writes the committed state with the merkle leaf count,
+// and the last block with the block commit recording the state root.
+// The state is read in one transaction, so that commits during export don't change the snapshot.
+func (strg *Storage) ExportSnapshot(w io.Writer) error {
+	return strg.db.View(func(txn *badger.Txn) error {
+		getter := &txnGetter{txn}
+		header, err := readSnapshotHeader(getter)
+		if err != nil {
+			return err
+		}
+		// keys with leaves, including the deleted ones
+		prefix := []byte{colMerkleIndexByStateKey}
+		header.entryCount = countKeys(txn, prefix)
+
+		sw := &snapshotWriter{w: bufio.NewWriter(w)}
+		sw.write(snapshotMagic)
+		sw.write([]byte{SnapshotVersion})
+		hb, err := header.marshal()
+		if err != nil {
+			return err
+		}
+		sw.bytes(hb)
+
+		ss := &stateStore{getter: getter}
+		chunk := make([]*snapshotEntry, 0, snapshotChunkSize)
+		var offset uint64
+		it := txn.NewIterator(badger.IteratorOptions{PrefetchValues: true, PrefetchSize: 100, Prefix: prefix})
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			e := &snapshotEntry{key: it.Item().KeyCopy(nil)[len(prefix):]}
+			if e.index, err = it.Item().ValueCopy(nil); err != nil {
+				return err
+			}
+			e.value, err = ss.getState(e.key)
+			if errors.Is(err, badger.ErrKeyNotFound) {
+				e.deleted = true
+			} else if err != nil {
+				return err
+			}
+			chunk = append(chunk, e)
+			if len(chunk) == snapshotChunkSize {
+				sw.chunk(offset, chunk)
+				offset += uint64(len(chunk))
+				chunk = chunk[:0]
+			}
+		}
+		if len(chunk) > 0 {
+			sw.chunk(offset, chunk)
+		}
+		return sw.flush()
+	})
+}
+
+func readSnapshotHeader(getter getter) (*snapshotHeader, error) {
+	cs := &chainStore{getter}
+	ms := &merkleStore{getter: getter}
+	header := &snapshotHeader{leafCount: ms.getLeafCount()}
+	var err error
+	if header.lastBlock, err = cs.getLastBlock(); err != nil {
+		return nil, fmt.Errorf("cannot load last block, %w", err)
+	}
+	if header.lastQC, err = cs.getLastQC(); err != nil && !errors.Is(err, badger.ErrKeyNotFound) {
+		return nil, err
+	}
+	if header.leafCount.Sign() == 0 {
+		return header, nil
+	}
+	// blocks without state changes don't record the state root
+	for height := header.lastBlock.Height(); ; height-- {
+		blk, err := cs.getBlockByHeight(height)
+		if err != nil {
+			return nil, err
+		}
+		bcm, err := cs.getBlockCommit(blk.Hash())
+		if err != nil {
+			return nil, fmt.Errorf("cannot load block commit %d, %w", height, err)
+		}
+		if len(bcm.MerkleRoot()) > 0 {
+			header.stateCommit = bcm
+			return header, nil
+		}
+		if height == 0 {
+			return nil, fmt.Errorf("no block commit with state root")
+		}
+	}
+}
+
+func countKeys(txn *badger.Txn, prefix []byte) uint64 {
+	var count uint64
+	it := txn.NewIterator(badger.IteratorOptions{Prefix: prefix})
+	defer it.Close()
+	for it.Rewind(); it.Valid(); it.Next() {
+		count++
+	}
+	return count
+}
+
+func (header *snapshotHeader) marshal() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	sw := &snapshotWriter{w: bufio.NewWriter(buf)}
+
+	b, err := header.lastBlock.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	sw.bytes(b)
+	b = nil
+	if header.lastQC != nil {
+		if b, err = header.lastQC.Marshal(); err != nil {
+			return nil, err
+		}
+		sw.uvarint(1) // empty qc marshals to no bytes
+	} else {
+		sw.uvarint(0)
+	}
+	sw.bytes(b)
+	b = nil
+	if header.stateCommit != nil {
+		if b, err = header.stateCommit.Marshal(); err != nil {
+			return nil, err
+		}
+	}
+	sw.bytes(b)
+	sw.bytes(header.leafCount.Bytes())
+	sw.uvarint(header.entryCount)
+	if err := sw.flush(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func unmarshalSnapshotHeader(b []byte) (*snapshotHeader, error) {
+	sr := &snapshotReader{r: bufio.NewReader(bytes.NewReader(b))}
+	blkBytes := sr.bytes()
+	hasQC := sr.uvarint() == 1
+	qcBytes := sr.bytes()
+	bcmBytes := sr.bytes()
+	header := &snapshotHeader{leafCount: big.NewInt(0).SetBytes(sr.bytes())}
+	header.entryCount = sr.uvarint()
+	if sr.err != nil {
+		return nil, sr.err
+	}
+	header.lastBlock = core.NewBlock()
+	if err := header.lastBlock.Unmarshal(blkBytes); err != nil {
+		return nil, fmt.Errorf("%w, last block, %v", ErrInvalidSnapshot, err)
+	}
+	if hasQC {
+		header.lastQC = core.NewQuorumCert()
+		if err := header.lastQC.Unmarshal(qcBytes); err != nil {
+			return nil, fmt.Errorf("%w, last qc, %v", ErrInvalidSnapshot, err)
+		}
+		qcHash := header.lastQC.BlockHash()
+		if len(qcHash) > 0 && !bytes.Equal(qcHash, header.lastBlock.Hash()) {
+			return nil, fmt.Errorf("%w, last qc is not for last block", ErrInvalidSnapshot)
+		}
+	}
+	if len(bcmBytes) > 0 {
+		header.stateCommit = core.NewBlockCommit()
+		if err := header.stateCommit.Unmarshal(bcmBytes); err != nil {
+			return nil, fmt.Errorf("%w, block commit, %v", ErrInvalidSnapshot, err)
+		}
+	}
+	if header.leafCount.Sign() != 0 && header.stateCommit == nil {
+		return nil, fmt.Errorf("%w, no block commit for state root", ErrInvalidSnapshot)
+	}
+	if header.entryCount > header.leafCount.Uint64() || !header.leafCount.IsUint64() {
+		return nil, fmt.Errorf("%w, %d entries for %d leaves",
+			ErrInvalidSnapshot, header.entryCount, header.leafCount)
+	}
+	return header, nil
+}
+
+// ImportSnapshot rebuilds the state and merkle tree from the snapshot into an empty storage.
+// The recomputed state root must be the same as the one in the block commit of the snapshot.
+// The snapshot must come from a trusted source or its last block must be verified by the caller.
+// If the import is interrupted, import the same snapshot again to resume it.
+func (strg *Storage) ImportSnapshot(r io.Reader) error {
+	if strg.readOnly {
+		return ErrReadOnly
+	}
+	sr := &snapshotReader{r: bufio.NewReader(r)}
+	magic := sr.read(len(snapshotMagic))
+	version := sr.read(1)
+	if sr.err != nil {
+		return sr.err
+	}
+	if !bytes.Equal(magic, snapshotMagic) {
+		return fmt.Errorf("%w, unknown format", ErrInvalidSnapshot)
+	}
+	if version[0] != SnapshotVersion && version[0] != snapshotVersionNoDeletes {
+		return fmt.Errorf("%w, unsupported version %d", ErrInvalidSnapshot, version[0])
+	}
+	hb := sr.bytes()
+	if sr.err != nil {
+		return sr.err
+	}
+	header, err := unmarshalSnapshotHeader(hb)
+	if err != nil {
+		return err
+	}
+	digest := sha256.Sum256(hb)
+	imported, err := strg.getSnapshotImport(digest[:])
+	if err != nil {
+		return err
+	}
+	var offset uint64
+	for offset < header.entryCount {
+		chunkOffset, entries := sr.chunk(version[0])
+		if sr.err != nil {
+			return sr.err
+		}
+		if chunkOffset != offset || len(entries) == 0 {
+			return fmt.Errorf("%w, chunk offset %d, expected %d", ErrInvalidSnapshot, chunkOffset, offset)
+		}
+		offset += uint64(len(entries))
+		if offset > header.entryCount {
+			return fmt.Errorf("%w, more entries than %d", ErrInvalidSnapshot, header.entryCount)
+		}
+		if offset <= imported {
+			continue // imported before interrupted
+		}
+		if chunkOffset < imported {
+			return fmt.Errorf("%w, chunk doesn't match imported entries", ErrSnapshotMismatch)
+		}
+		if err := strg.importChunk(entries, header.leafCount, digest[:], offset); err != nil {
+			return err
+		}
+	}
+	return strg.finishSnapshotImport(header)
+}
+
+// ExportState streams the state key/values with their merkle leaf indexes, see ExportSnapshot
+func (strg *Storage) ExportState(w io.Writer) error {
+	return strg.ExportSnapshot(w)
+}
+
+// ImportState rebuilds the state and merkle tree from ExportState into an empty storage,
+// see ImportSnapshot. The import is accepted only if GetMerkleRoot of the rebuilt tree
+// is the state root recorded in the exported block commit.
+func (strg *Storage) ImportState(r io.Reader) error {
+	return strg.ImportSnapshot(r)
+}
+
+// getSnapshotImport returns the number of entries imported for the snapshot with the digest
+func (strg *Storage) getSnapshotImport(digest []byte) (uint64, error) {
+	val, err := strg.chainStore.getter.Get([]byte{colSnapshotImport})
+	if err == nil {
+		if !bytes.Equal(val[:len(val)-8], digest) {
+			return 0, fmt.Errorf("%w, another snapshot import in progress", ErrSnapshotMismatch)
+		}
+		return binary.BigEndian.Uint64(val[len(val)-8:]), nil
+	}
+	if !errors.Is(err, badger.ErrKeyNotFound) {
+		return 0, err
+	}
+	if _, err := strg.chainStore.getBlockHeight(); err == nil {
+		return 0, fmt.Errorf("%w, has commited blocks", ErrStorageNotEmpty)
+	}
+	if strg.merkleStore.getLeafCount().Sign() != 0 {
+		return 0, fmt.Errorf("%w, has state", ErrStorageNotEmpty)
+	}
+	return 0, nil
+}
+
+func (strg *Storage) importChunk(
+	entries []*snapshotEntry, leafCount *big.Int, digest []byte, imported uint64,
+) error {
+	updFns := make([]updateFunc, 0, 2*len(entries)+5)
+	nodes := make([]*merkle.Node, len(entries))
+	for i, e := range entries {
+		idx := big.NewInt(0).SetBytes(e.index)
+		if leafCount.Cmp(idx) != 1 {
+			return fmt.Errorf("%w, tree index %d out of %d leaves", ErrInvalidSnapshot, idx, leafCount)
+		}
+		nodes[i] = &merkle.Node{
+			Position: merkle.NewPosition(0, idx),
+			Data:     clearedLeaf,
+		}
+		if !e.deleted {
+			nodes[i].Data = strg.stateStore.sumState(e.key, e.value)
+			updFns = append(updFns, strg.stateStore.setState(e.key, e.value))
+		}
+		updFns = append(updFns, strg.stateStore.setTreeIndex(e.key, e.index))
+	}
+	// parents are recomputed by the later chunks with all leaves below them
+	upd := strg.merkleTree.Update(nodes, leafCount)
+	updFns = append(updFns, strg.merkleStore.commitUpdate(upd)...)
+	updFns = append(updFns, func(setter setter) error {
+		return setter.Set([]byte{colSnapshotImport}, concatBytes(digest, uint64BEBytes(imported)))
+	})
+
+	strg.mtxWriteState.Lock()
+	defer strg.mtxWriteState.Unlock()
+	if strg.stateCache != nil {
+		// not found values may be cached before import
+		strg.stateCache.purge()
+		defer strg.stateCache.purge()
+	}
+	if err := updateBadgerDB(strg.db, updFns); err != nil {
+		return err
+	}
+	if strg.merkleCache != nil {
+		strg.merkleCache.CommitUpdate(upd)
+	}
+	return nil
+}
+
+func (strg *Storage) finishSnapshotImport(header *snapshotHeader) error {
+	if header.stateCommit != nil {
+		root := strg.GetMerkleRoot()
+		if !bytes.Equal(root, header.stateCommit.MerkleRoot()) {
+			return fmt.Errorf("%w, state root %x, block commit has %x",
+				ErrSnapshotMismatch, root, header.stateCommit.MerkleRoot())
+		}
+		if !bytes.Equal(header.leafCount.Bytes(), header.stateCommit.LeafCount()) {
+			return fmt.Errorf("%w, leaf count %d doesn't match block commit",
+				ErrSnapshotMismatch, header.leafCount)
+		}
+	}
+	blk := header.lastBlock
+	updFns := strg.chainStore.setBlock(blk)
+	if header.stateCommit != nil {
+		updFns = append(updFns, strg.chainStore.setBlockCommit(header.stateCommit))
+	}
+	updFns = append(updFns, strg.chainStore.setLastQC(header.lastQC))
+	updFns = append(updFns, strg.merkleStore.setLeafCountAtHeight(blk.Height(), header.leafCount))
+	updFns = append(updFns, strg.chainStore.setBlockHeight(blk.Height()))
+	updFns = append(updFns, deleteKey([]byte{colSnapshotImport}))
+
+	strg.mtxWriteState.Lock()
+	defer strg.mtxWriteState.Unlock()
+	if err := updateBadgerDB(strg.db, updFns); err != nil {
+		return err
+	}
+	logger.I().Infow("imported snapshot", "height", blk.Height(), "leaf count", header.leafCount)
+	return nil
+}
+
+// snapshotWriter keeps the first error, later writes are ignored
+type snapshotWriter struct {
+	w   *bufio.Writer
+	err error
+}
+
+func (sw *snapshotWriter) write(b []byte) {
+	if sw.err == nil {
+		_, sw.err = sw.w.Write(b)
+	}
+}
+
+func (sw *snapshotWriter) uvarint(v uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	sw.write(buf[:n])
+}
+
+func (sw *snapshotWriter) bytes(b []byte) {
+	sw.uvarint(uint64(len(b)))
+	sw.write(b)
+}
+
+func (sw *snapshotWriter) chunk(offset uint64, entries []*snapshotEntry) {
+	sw.uvarint(offset)
+	sw.uvarint(uint64(len(entries)))
+	for _, e := range entries {
+		sw.bytes(e.key)
+		sw.bytes(e.value)
+		sw.bytes(e.index)
+		if e.deleted {
+			sw.uvarint(1)
+		} else {
+			sw.uvarint(0)
+		}
+	}
+}
+
+func (sw *snapshotWriter) flush() error {
+	if sw.err == nil {
+		sw.err = sw.w.Flush()
+	}
+	return sw.err
+}
+
+// snapshotReader keeps the first error, later reads return zero values
+type snapshotReader struct {
+	r   *bufio.Reader
+	err error
+}
+
+func (sr *snapshotReader) fail(err error) {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		err = fmt.Errorf("%w, unexpected end", ErrInvalidSnapshot)
+	}
+	sr.err = err
+}
+
+func (sr *snapshotReader) read(n int) []byte {
+	if sr.err != nil {
+		return nil
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(sr.r, b); err != nil {
+		sr.fail(err)
+		return nil
+	}
+	return b
+}
+
+func (sr *snapshotReader) uvarint() uint64 {
+	if sr.err != nil {
+		return 0
+	}
+	v, err := binary.ReadUvarint(sr.r)
+	if err != nil {
+		sr.fail(err)
+		return 0
+	}
+	return v
+}
+
+func (sr *snapshotReader) bytes() []byte {
+	l := sr.uvarint()
+	if sr.err == nil && l > maxSnapshotFieldSize {
+		sr.err = fmt.Errorf("%w, field of %d bytes", ErrInvalidSnapshot, l)
+	}
+	return sr.read(int(l))
+}
+
+func (sr *snapshotReader) chunk(version byte) (uint64, []*snapshotEntry) {
+	offset := sr.uvarint()
+	count := sr.uvarint()
+	if sr.err == nil && count > maxSnapshotChunkEntries {
+		sr.err = fmt.Errorf("%w, chunk of %d entries", ErrInvalidSnapshot, count)
+	}
+	if sr.err != nil {
+		return 0, nil
+	}
+	entries := make([]*snapshotEntry, count)
+	for i := range entries {
+		entries[i] = &snapshotEntry{key: sr.bytes(), value: sr.bytes(), index: sr.bytes()}
+		if version != snapshotVersionNoDeletes {
+			entries[i].deleted = sr.uvarint() == 1
+		}
+	}
+	return offset, entries
+}
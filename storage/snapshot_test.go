@@ -0,0 +1,228 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/aungmawjj/juria-blockchain/core"
+	"github.com/stretchr/testify/assert"
+)
+
+// commitTestStates commits blocks which set random values of the given keys
+func commitTestStates(t *testing.T, strg *Storage, blocks int, keys int) {
+	priv := core.GenerateKey(nil)
+	var parent *core.Block
+	start := uint64(0)
+	if blk, err := strg.GetLastBlock(); err == nil {
+		parent = blk
+		start = blk.Height() + 1
+	}
+	for i := 0; i < blocks; i++ {
+		blk := core.NewBlock().SetHeight(start + uint64(i))
+		if parent != nil {
+			blk.SetParentHash(parent.Hash()).
+				SetQuorumCert(core.NewQuorumCert().Build([]*core.Vote{parent.ProposerVote()}))
+		}
+		blk.Sign(priv)
+		scList := make([]*core.StateChange, 0, keys)
+		for k := 0; k < keys; k++ {
+			if rand.Intn(2) == 0 {
+				continue
+			}
+			value := make([]byte, 1+rand.Intn(20))
+			rand.Read(value)
+			scList = append(scList, core.NewStateChange().
+				SetKey([]byte{uint8(k >> 8), uint8(k)}).SetValue(value))
+		}
+		err := strg.Commit(&CommitData{
+			Block:       blk,
+			QC:          core.NewQuorumCert(),
+			BlockCommit: core.NewBlockCommit().SetHash(blk.Hash()).SetStateChanges(scList),
+		})
+		assert.NoError(t, err)
+		parent = blk
+	}
+}
+
+func assertSameState(t *testing.T, src, dst *Storage, keys int) {
+	assert := assert.New(t)
+	assert.Equal(src.GetMerkleRoot(), dst.GetMerkleRoot())
+	assert.Equal(src.GetBlockHeight(), dst.GetBlockHeight())
+	for i := 0; i < 50; i++ {
+		k := rand.Intn(keys)
+		key := []byte{uint8(k >> 8), uint8(k)}
+		assert.Equal(src.GetState(nil, key), dst.VerifyState(nil, key))
+	}
+	blk, err := dst.GetLastBlock()
+	if assert.NoError(err) {
+		srcBlk, _ := src.GetLastBlock()
+		assert.Equal(srcBlk.Hash(), blk.Hash())
+	}
+	srcQC, srcErr := src.GetLastQC()
+	qc, err := dst.GetLastQC()
+	assert.Equal(srcErr, err)
+	assert.Equal(srcQC, qc)
+}
+
+// failingReader fails after reading n bytes
+type failingReader struct {
+	r io.Reader
+	n int
+}
+
+var errReaderFailed = errors.New("reader failed")
+
+func (fr *failingReader) Read(p []byte) (int, error) {
+	if fr.n <= 0 {
+		return 0, errReaderFailed
+	}
+	if len(p) > fr.n {
+		p = p[:fr.n]
+	}
+	n, err := fr.r.Read(p)
+	fr.n -= n
+	return n, err
+}
+
+func setSnapshotChunkSize(t *testing.T, size int) {
+	old := snapshotChunkSize
+	snapshotChunkSize = size
+	t.Cleanup(func() { snapshotChunkSize = old })
+}
+
+func TestStorage_Snapshot(t *testing.T) {
+	assert := assert.New(t)
+	setSnapshotChunkSize(t, 30)
+
+	src := newTestStorage()
+	commitTestStates(t, src, 5, 300)
+	// the last blocks don't change state
+	commitTestStates(t, src, 2, 0)
+
+	buf := new(bytes.Buffer)
+	assert.NoError(src.ExportSnapshot(buf))
+
+	dst := newTestStorage()
+	assert.NoError(dst.ImportSnapshot(bytes.NewReader(buf.Bytes())))
+	assertSameState(t, src, dst, 300)
+
+	assert.ErrorIs(dst.ImportSnapshot(bytes.NewReader(buf.Bytes())), ErrStorageNotEmpty)
+
+	// imported storage continues to commit blocks
+	commitTestStates(t, src, 2, 300)
+	buf2 := new(bytes.Buffer)
+	assert.NoError(src.ExportSnapshot(buf2))
+	dst2 := newTestStorage()
+	assert.NoError(dst2.ImportSnapshot(buf2))
+	assertSameState(t, src, dst2, 300)
+}
+
+func TestStorage_ExportImportState(t *testing.T) {
+	assert := assert.New(t)
+
+	src := newTestStorage()
+	commitTestStates(t, src, 3, 100)
+	buf := new(bytes.Buffer)
+	assert.NoError(src.ExportState(buf))
+	data := buf.Bytes()
+
+	dst := newTestStorage()
+	assert.NoError(dst.ImportState(bytes.NewReader(data)))
+	assert.NotNil(dst.GetMerkleRoot())
+	assert.Equal(src.GetMerkleRoot(), dst.GetMerkleRoot())
+	assertSameState(t, src, dst, 100)
+
+	// rebuilt root doesn't match, the import is not accepted
+	tampered := append([]byte{}, data...)
+	for k := 0; k < 100; k++ {
+		value := src.GetState(nil, []byte{0, uint8(k)})
+		if i := bytes.LastIndex(tampered, value); len(value) > 8 && i >= 0 {
+			tampered[i]++
+			break
+		}
+	}
+	rejected := newTestStorage()
+	assert.ErrorIs(rejected.ImportState(bytes.NewReader(tampered)), ErrSnapshotMismatch)
+	_, err := rejected.GetLastBlock()
+	assert.Error(err, "not accepted")
+}
+
+func TestStorage_SnapshotEmptyState(t *testing.T) {
+	assert := assert.New(t)
+
+	src := newTestStorage()
+	commitTestStates(t, src, 2, 0)
+	buf := new(bytes.Buffer)
+	assert.NoError(src.ExportSnapshot(buf))
+
+	dst := newTestStorage()
+	assert.NoError(dst.ImportSnapshot(buf))
+	assert.EqualValues(1, dst.GetBlockHeight())
+	assert.Nil(dst.GetMerkleRoot())
+
+	assert.Error(newTestStorage().ExportSnapshot(new(bytes.Buffer)), "no blocks")
+}
+
+func TestStorage_SnapshotResume(t *testing.T) {
+	assert := assert.New(t)
+	setSnapshotChunkSize(t, 20)
+
+	src := newTestStorage()
+	commitTestStates(t, src, 3, 200)
+	buf := new(bytes.Buffer)
+	assert.NoError(src.ExportSnapshot(buf))
+	data := buf.Bytes()
+
+	dst := newTestStorage()
+	// the header carries the state changes of the last block commit
+	err := dst.ImportSnapshot(&failingReader{bytes.NewReader(data), len(data) - 1000})
+	assert.ErrorIs(err, errReaderFailed)
+	_, err = dst.GetLastBlock()
+	assert.Error(err, "not finished")
+	assert.NotNil(dst.GetMerkleRoot(), "imported chunks before failure")
+
+	other := newTestStorage()
+	commitTestStates(t, other, 1, 10)
+	otherBuf := new(bytes.Buffer)
+	assert.NoError(other.ExportSnapshot(otherBuf))
+	assert.ErrorIs(dst.ImportSnapshot(otherBuf), ErrSnapshotMismatch, "another snapshot")
+
+	assert.NoError(dst.ImportSnapshot(bytes.NewReader(data)))
+	assertSameState(t, src, dst, 200)
+}
+
+func TestStorage_SnapshotInvalid(t *testing.T) {
+	assert := assert.New(t)
+	setSnapshotChunkSize(t, 50)
+
+	src := newTestStorage()
+	commitTestStates(t, src, 2, 100)
+	buf := new(bytes.Buffer)
+	assert.NoError(src.ExportSnapshot(buf))
+	data := buf.Bytes()
+
+	// tamper a state value, the header may also have it in the block commit
+	tampered := append([]byte{}, data...)
+	for k := 0; k < 100; k++ {
+		value := src.GetState(nil, []byte{0, uint8(k)})
+		if i := bytes.LastIndex(tampered, value); len(value) > 8 && i >= 0 {
+			tampered[i]++
+			break
+		}
+	}
+	assert.ErrorIs(newTestStorage().ImportSnapshot(bytes.NewReader(tampered)), ErrSnapshotMismatch)
+
+	assert.ErrorIs(newTestStorage().ImportSnapshot(bytes.NewReader(data[:len(data)-10])),
+		ErrInvalidSnapshot, "truncated")
+
+	tampered = append([]byte{}, data...)
+	tampered[0]++
+	assert.ErrorIs(newTestStorage().ImportSnapshot(bytes.NewReader(tampered)),
+		ErrInvalidSnapshot, "wrong magic")
+}
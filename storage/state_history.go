@@ -0,0 +1,211 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/aungmawjj/juria-blockchain/core"
+	"github.com/aungmawjj/juria-blockchain/logger"
+	"github.com/dgraph-io/badger/v3"
+)
+
+// errors
+var (
+	ErrHeightNotCommitted = errors.New("height not commited")
+)
+
+// deletes of a version pruning transaction
+const stateVersionPruneBatch = 1000
+
+/*
+State versions are written with the state changes of each block while KeepStateVersions is set.
+
+	version key: column, key length (4 bytes), key, block height
+
+History starts from the first block commited with versions. A key without versions
+has not changed since then, so its current value is the value at every height of the history.
+When a key changes for the first time after the start, its previous value is recorded
+as the version at the start height.
+
+Each state change writes one more value with its key, so the history grows with
+the state changes of the kept blocks, not with the state size. Pruning keeps one version
+below the kept range for each key changed in it.
+*/
+
+// GetStateAtHeight returns the value of key after the block at height is commited, nil if not found.
+// It returns ErrPruned if the height is before the state history kept.
+func (strg *Storage) GetStateAtHeight(codeAddr, key []byte, height uint64) ([]byte, error) {
+	strg.mtxWriteState.RLock()
+	defer strg.mtxWriteState.RUnlock()
+
+	key = core.StateKey(codeAddr, key)
+	lastHeight, err := strg.chainStore.getBlockHeight()
+	if err != nil || height > lastHeight {
+		return nil, fmt.Errorf("%w, height %d", ErrHeightNotCommitted, height)
+	}
+	if height == lastHeight {
+		return strg.stateStore.getStateNotFoundNil(key), nil
+	}
+	start, err := strg.stateStore.getStateHistoryStart()
+	if err != nil || height < start {
+		return nil, fmt.Errorf("%w, state history at %d", ErrPruned, height)
+	}
+	var value []byte
+	err = strg.db.View(func(txn *badger.Txn) error {
+		prefix := stateVersionPrefix(key)
+		it := txn.NewIterator(badger.IteratorOptions{Reverse: true, Prefix: prefix})
+		defer it.Close()
+		// reverse seek finds the newest version at or below height
+		it.Seek(concatBytes(prefix, uint64BEBytes(height)))
+		if it.Valid() {
+			var err error
+			value, err = it.Item().ValueCopy(nil)
+			return err
+		}
+		if hasPrefixKey(txn, prefix) {
+			return nil // created after height
+		}
+		// unchanged since history start
+		value = strg.stateStore.getStateNotFoundNil(key)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+func hasPrefixKey(txn *badger.Txn, prefix []byte) bool {
+	it := txn.NewIterator(badger.IteratorOptions{Prefix: prefix})
+	defer it.Close()
+	it.Rewind()
+	return it.Valid()
+}
+
+// hasVersions also finds the versions written by the previous blocks of a batch
+func (strg *Storage) hasVersions(txn *badger.Txn, prefix []byte) bool {
+	if ov, ok := strg.stateStore.getter.(*overlayGetter); ok && ov.hasVersions(prefix) {
+		return true
+	}
+	return hasPrefixKey(txn, prefix)
+}
+
+// writeStateVersions records the values of state changes at height,
+// and the previous values of the keys changed for the first time since history start
+func (strg *Storage) writeStateVersions(scList []*core.StateChange, height uint64) ([]updateFunc, error) {
+	updFns := make([]updateFunc, 0, len(scList))
+	start, err := strg.stateStore.getStateHistoryStart()
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		start = height
+		updFns = append(updFns, strg.stateStore.setStateHistoryStart(height))
+	} else if err != nil {
+		return nil, err
+	}
+	err = strg.db.View(func(txn *badger.Txn) error {
+		for _, sc := range scList {
+			prefix := stateVersionPrefix(sc.StateKey())
+			if height > start && sc.PrevValue() != nil && !strg.hasVersions(txn, prefix) {
+				updFns = append(updFns, strg.stateStore.setStateVersion(sc.StateKey(), start, sc.PrevValue()))
+			}
+			updFns = append(updFns, strg.stateStore.setStateVersion(sc.StateKey(), height, sc.Value()))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return updFns, nil
+}
+
+// PruneStateVersions deletes the state versions which are not needed to query
+// the last keepVersions blocks. The newest version of a key below that is kept,
+// as it is the value at the heights until the next version.
+// It scans all versions, so it is meant for background pruning.
+func (strg *Storage) PruneStateVersions(keepVersions uint64) error {
+	if strg.readOnly {
+		return ErrReadOnly
+	}
+	strg.mtxPrune.Lock()
+	defer strg.mtxPrune.Unlock()
+
+	height, err := strg.chainStore.getBlockHeight()
+	if err != nil || height < keepVersions {
+		return nil
+	}
+	target := height - keepVersions
+	start, err := strg.stateStore.getStateHistoryStart()
+	if err != nil || start >= target {
+		return nil
+	}
+	keys := make([][]byte, 0)
+	err = strg.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{Prefix: []byte{colStateVersion}})
+		defer it.Close()
+		var prev []byte // newest version at or below target of the previous key
+		for it.Rewind(); it.Valid(); it.Next() {
+			key := it.Item().KeyCopy(nil)
+			if binary.BigEndian.Uint64(key[len(key)-8:]) > target {
+				continue
+			}
+			if prev != nil && bytes.Equal(prev[:len(prev)-8], key[:len(key)-8]) {
+				keys = append(keys, prev)
+			}
+			prev = key
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// queries below target would miss the deleted versions
+	if err := updateBadgerDB(strg.db, []updateFunc{strg.stateStore.setStateHistoryStart(target)}); err != nil {
+		return err
+	}
+	for len(keys) > 0 {
+		n := stateVersionPruneBatch
+		if n > len(keys) {
+			n = len(keys)
+		}
+		updFns := make([]updateFunc, n)
+		for i, key := range keys[:n] {
+			updFns[i] = deleteKey(key)
+		}
+		if err := updateBadgerDB(strg.db, updFns); err != nil {
+			return err
+		}
+		keys = keys[n:]
+	}
+	logger.I().Infow("pruned state versions", "from", start, "to", target)
+	return nil
+}
+
+func stateVersionPrefix(key []byte) []byte {
+	klen := make([]byte, 4)
+	binary.BigEndian.PutUint32(klen, uint32(len(key)))
+	return concatBytes([]byte{colStateVersion}, klen, key)
+}
+
+func (ss *stateStore) setStateVersion(key []byte, height uint64, value []byte) updateFunc {
+	return func(setter setter) error {
+		return setter.Set(concatBytes(stateVersionPrefix(key), uint64BEBytes(height)), value)
+	}
+}
+
+func (ss *stateStore) getStateHistoryStart() (uint64, error) {
+	b, err := ss.getter.Get([]byte{colStateHistoryStart})
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(b), nil
+}
+
+func (ss *stateStore) setStateHistoryStart(height uint64) updateFunc {
+	return func(setter setter) error {
+		return setter.Set([]byte{colStateHistoryStart}, uint64BEBytes(height))
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package storage
+
+import (
+	"testing"
+
+	"github.com/aungmawjj/juria-blockchain/core"
+	"github.com/stretchr/testify/assert"
+)
+
+// commitStateBlocks commits a block for each list of key value pairs
+func commitStateBlocks(t testing.TB, strg *Storage, blocks [][][2]string) {
+	changes := make([][]*core.StateChange, len(blocks))
+	for i, kvs := range blocks {
+		changes[i] = make([]*core.StateChange, len(kvs))
+		for j, kv := range kvs {
+			changes[i][j] = core.NewStateChange().SetKey([]byte(kv[0])).SetValue([]byte(kv[1]))
+		}
+	}
+	commitStateChangeBlocks(t, strg, changes)
+}
+
+// commitStateChangeBlocks commits a block for each list of state changes
+func commitStateChangeBlocks(t testing.TB, strg *Storage, blocks [][]*core.StateChange) {
+	priv := core.GenerateKey(nil)
+	var parent *core.Block
+	start := uint64(0)
+	if blk, err := strg.GetLastBlock(); err == nil {
+		parent = blk
+		start = blk.Height() + 1
+	}
+	for i, scList := range blocks {
+		blk := core.NewBlock().SetHeight(start + uint64(i))
+		if parent != nil {
+			blk.SetParentHash(parent.Hash()).
+				SetQuorumCert(core.NewQuorumCert().Build([]*core.Vote{parent.ProposerVote()}))
+		}
+		blk.Sign(priv)
+		assert.NoError(t, strg.Commit(&CommitData{
+			Block:       blk,
+			QC:          core.NewQuorumCert(),
+			BlockCommit: core.NewBlockCommit().SetHash(blk.Hash()).SetStateChanges(scList),
+		}))
+		parent = blk
+	}
+}
+
+func assertStateAtHeight(t *testing.T, strg *Storage, height uint64, want map[string]string) {
+	for _, key := range []string{"a", "b", "c"} {
+		value, err := strg.GetStateAtHeight(nil, []byte(key), height)
+		assert.NoError(t, err)
+		if v, ok := want[key]; ok {
+			assert.Equal(t, []byte(v), value, "key %s at %d", key, height)
+		} else {
+			assert.Nil(t, value, "key %s at %d", key, height)
+		}
+	}
+}
+
+func TestStorage_GetStateAtHeight(t *testing.T) {
+	assert := assert.New(t)
+
+	config := DefaultConfig
+	config.KeepStateVersions = 100
+	strg := New(createOnMemoryDB(), config)
+
+	_, err := strg.GetStateAtHeight(nil, []byte("a"), 0)
+	assert.ErrorIs(err, ErrHeightNotCommitted)
+
+	commitStateBlocks(t, strg, [][][2]string{
+		{{"a", "1"}},
+		{{"b", "1"}},
+		{},
+		{{"a", "2"}, {"c", "1"}},
+		{{"a", "3"}},
+	})
+	states := []map[string]string{
+		{"a": "1"},
+		{"a": "1", "b": "1"},
+		{"a": "1", "b": "1"},
+		{"a": "2", "b": "1", "c": "1"},
+		{"a": "3", "b": "1", "c": "1"},
+	}
+	for h, want := range states {
+		assertStateAtHeight(t, strg, uint64(h), want)
+	}
+	_, err = strg.GetStateAtHeight(nil, []byte("a"), 5)
+	assert.ErrorIs(err, ErrHeightNotCommitted)
+
+	assert.NoError(strg.PruneStateVersions(10), "nothing to prune")
+	assert.NoError(strg.PruneStateVersions(1))
+	for h := 0; h < 3; h++ {
+		_, err = strg.GetStateAtHeight(nil, []byte("a"), uint64(h))
+		assert.ErrorIs(err, ErrPruned)
+	}
+	for h := 3; h < len(states); h++ {
+		assertStateAtHeight(t, strg, uint64(h), states[h])
+	}
+	assert.False(strg.chainStore.getter.HasKey(
+		concatBytes(stateVersionPrefix([]byte("a")), uint64BEBytes(0))), "version replaced below target")
+	assert.True(strg.chainStore.getter.HasKey(
+		concatBytes(stateVersionPrefix([]byte("b")), uint64BEBytes(1))), "newest version below target")
+}
+
+func TestStorage_GetStateAtHeightEnabledLater(t *testing.T) {
+	assert := assert.New(t)
+
+	db := createOnMemoryDB()
+	strg := New(db, DefaultConfig)
+	commitStateBlocks(t, strg, [][][2]string{
+		{{"a", "1"}, {"b", "1"}},
+	})
+	_, err := strg.GetStateAtHeight(nil, []byte("a"), 0)
+	assert.NoError(err, "last height is the current state")
+	commitStateBlocks(t, strg, [][][2]string{{}})
+	_, err = strg.GetStateAtHeight(nil, []byte("a"), 0)
+	assert.ErrorIs(err, ErrPruned, "no history")
+
+	config := DefaultConfig
+	config.KeepStateVersions = 100
+	strg = New(db, config)
+	commitStateBlocks(t, strg, [][][2]string{
+		{{"c", "1"}}, // history starts at 2
+		{{"a", "2"}},
+		{{"c", "2"}},
+	})
+	_, err = strg.GetStateAtHeight(nil, []byte("a"), 1)
+	assert.ErrorIs(err, ErrPruned)
+	assertStateAtHeight(t, strg, 2, map[string]string{"a": "1", "b": "1", "c": "1"})
+	assertStateAtHeight(t, strg, 3, map[string]string{"a": "2", "b": "1", "c": "1"})
+	assertStateAtHeight(t, strg, 4, map[string]string{"a": "2", "b": "1", "c": "2"})
+}
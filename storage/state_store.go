@@ -6,6 +6,7 @@ package storage
 import (
 	"bytes"
 	"crypto"
+	"encoding/binary"
 	"math/big"
 	"sort"
 	"sync"
@@ -14,6 +15,10 @@ import (
 	"github.com/aungmawjj/juria-blockchain/merkle"
 )
 
+// clearedLeaf is the merkle leaf of a deleted key,
+// shorter than the hash of any value so that the leaf proves the key is deleted
+var clearedLeaf = []byte{0}
+
 type stateStore struct {
 	getter          getter
 	hashFunc        crypto.Hash
@@ -22,13 +27,13 @@ type stateStore struct {
 
 func (ss *stateStore) loadPrevValues(scList []*core.StateChange) {
 	for _, sc := range scList {
-		sc.SetPrevValue(ss.getStateNotFoundNil(sc.Key()))
+		sc.SetPrevValue(ss.getStateNotFoundNil(sc.StateKey()))
 	}
 }
 
 func (ss *stateStore) loadPrevTreeIndexes(scList []*core.StateChange) {
 	for _, sc := range scList {
-		val, err := ss.getMerkleIndex(sc.Key())
+		val, err := ss.getMerkleIndex(sc.StateKey())
 		if err == nil {
 			sc.SetPrevTreeIndex(val)
 		}
@@ -39,10 +44,10 @@ func (ss *stateStore) setNewTreeIndexes(scList []*core.StateChange, prevLC *big.
 	newKeys := make([]string, 0)
 	scByKey := make(map[string]int)
 	for i, sc := range scList {
-		if sc.PrevTreeIndex() != nil {
-			sc.SetTreeIndex(sc.PrevTreeIndex())
-		} else {
-			key := string(sc.Key())
+		if !sc.IsNewKey() {
+			sc.KeepTreeIndex()
+		} else if !sc.Deleted() { // deleting a missing key takes no leaf
+			key := string(sc.StateKey())
 			newKeys = append(newKeys, key)
 			scByKey[key] = i
 		}
@@ -50,21 +55,14 @@ func (ss *stateStore) setNewTreeIndexes(scList []*core.StateChange, prevLC *big.
 	sort.Strings(newKeys) // sort new keys to get consistent leaf indexes
 	leafCount := big.NewInt(0).Set(prevLC)
 	for _, key := range newKeys {
-		setLeafIndex(scList[scByKey[key]], leafCount)
+		scList[scByKey[key]].SetLeafIndex(leafCount)
 		leafCount.Add(leafCount, big.NewInt(1))
 	}
 	return leafCount
 }
 
-func setLeafIndex(sc *core.StateChange, idx *big.Int) {
-	idxB := idx.Bytes()
-	if len(idxB) == 0 {
-		idxB = []byte{0}
-	}
-	sc.SetTreeIndex(idxB)
-}
-
 func (ss *stateStore) computeUpdatedTreeNodes(scList []*core.StateChange) []*merkle.Node {
+	scList = changesWithLeaves(scList)
 	nodes := make([]*merkle.Node, len(scList))
 	jobs := make(chan int, ss.concurrentLimit)
 	defer close(jobs)
@@ -88,15 +86,39 @@ func (ss *stateStore) worker(
 	for i := range jobs {
 		sc := scList[i]
 		nodes[i] = &merkle.Node{
-			Position: merkle.NewPosition(0, big.NewInt(0).SetBytes(sc.TreeIndex())),
-			Data:     ss.sumStateValue(sc.Value()),
+			Position: merkle.NewPosition(0, sc.LeafIndex()),
+			Data:     ss.leafOf(sc),
 		}
 		wg.Done()
 	}
 }
 
-func (ss *stateStore) sumStateValue(value []byte) []byte {
+// changesWithLeaves filters out deletes of the keys which never had a leaf
+func changesWithLeaves(scList []*core.StateChange) []*core.StateChange {
+	ret := make([]*core.StateChange, 0, len(scList))
+	for _, sc := range scList {
+		if sc.TreeIndex() != nil {
+			ret = append(ret, sc)
+		}
+	}
+	return ret
+}
+
+func (ss *stateStore) leafOf(sc *core.StateChange) []byte {
+	if sc.Deleted() {
+		return clearedLeaf
+	}
+	return ss.sumState(sc.StateKey(), sc.Value())
+}
+
+// sumState returns the merkle leaf of the stored key and value,
+// the hash of the key length as uvarint, the key and the value
+func (ss *stateStore) sumState(key, value []byte) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], uint64(len(key)))
 	h := ss.hashFunc.New()
+	h.Write(buf[:n])
+	h.Write(key)
 	h.Write(value)
 	return h.Sum(nil)
 }
@@ -111,9 +133,13 @@ func (ss *stateStore) commitStateChanges(scList []*core.StateChange) []updateFun
 
 func (ss *stateStore) commitStateChange(sc *core.StateChange) []updateFunc {
 	ret := make([]updateFunc, 0)
-	ret = append(ret, ss.setState(sc.Key(), sc.Value()))
+	if sc.Deleted() {
+		// tree index is kept, the leaf position is reused if the key is set again
+		return append(ret, ss.deleteState(sc.StateKey()))
+	}
+	ret = append(ret, ss.setState(sc.StateKey(), sc.Value()))
 	if sc.PrevTreeIndex() == nil || !bytes.Equal(sc.PrevTreeIndex(), sc.TreeIndex()) {
-		ret = append(ret, ss.setTreeIndex(sc.Key(), sc.TreeIndex()))
+		ret = append(ret, ss.setTreeIndex(sc.StateKey(), sc.TreeIndex()))
 	}
 	return ret
 }
@@ -142,6 +168,10 @@ func (ss *stateStore) setState(key, value []byte) updateFunc {
 	}
 }
 
+func (ss *stateStore) deleteState(key []byte) updateFunc {
+	return deleteKey(concatBytes([]byte{colStateValueByKey}, key))
+}
+
 func (ss *stateStore) setTreeIndex(key, idx []byte) updateFunc {
 	return func(setter setter) error {
 		return setter.Set(
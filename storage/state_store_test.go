@@ -85,8 +85,8 @@ func TestStateStore_computeUpdatedTreeNodes(t *testing.T) {
 	assert.Equal(p0.Bytes(), nodes[0].Position.Bytes())
 	assert.Equal(p1.Bytes(), nodes[1].Position.Bytes())
 
-	d0 := ss.sumStateValue([]byte{10})
-	d1 := ss.sumStateValue([]byte{20})
+	d0 := ss.sumState([]byte{1}, []byte{10})
+	d1 := ss.sumState([]byte{2}, []byte{20})
 
 	assert.Equal(d0, nodes[0].Data)
 	assert.Equal(d1, nodes[1].Data)
@@ -4,18 +4,31 @@
 package storage
 
 import (
+	"bytes"
 	"crypto"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
 	"math/big"
 	"sync"
 	"time"
 
 	"github.com/aungmawjj/juria-blockchain/core"
+	"github.com/aungmawjj/juria-blockchain/emitter"
 	"github.com/aungmawjj/juria-blockchain/logger"
 	"github.com/aungmawjj/juria-blockchain/merkle"
 	"github.com/dgraph-io/badger/v3"
 	_ "golang.org/x/crypto/sha3"
 )
 
+// errors
+var (
+	ErrNoBlocks            = errors.New("no commited blocks")
+	ErrSenderIndexDisabled = errors.New("txs by sender are not indexed")
+	ErrNoTxRoot            = errors.New("block doesn't commit to tx root")
+)
+
 type CommitData struct {
 	Block        *core.Block
 	QC           *core.QuorumCert // QC for commited block
@@ -23,73 +36,444 @@ type CommitData struct {
 	BlockCommit  *core.BlockCommit
 	TxCommits    []*core.TxCommit
 	merkleUpdate *merkle.UpdateResult
+	written      uint64 // key and value bytes
+}
+
+// StateProof proves the state values of keys with a merkle multiproof.
+// MerkleRoot is the state root after executing the block at BlockHeight.
+type StateProof struct {
+	BlockHeight uint64             `json:"blockHeight"`
+	BlockHash   []byte             `json:"blockHash"`
+	MerkleRoot  []byte             `json:"merkleRoot"`
+	Keys        [][]byte           `json:"keys"`
+	Values      [][]byte           `json:"values"`
+	Proof       *merkle.MultiProof `json:"proof"`
+}
+
+// StateKeyProof proves the value of a single state key against the state root
+type StateKeyProof struct {
+	BlockHeight uint64        `json:"blockHeight"`
+	BlockHash   []byte        `json:"blockHash"`
+	MerkleRoot  []byte        `json:"merkleRoot"`
+	Key         []byte        `json:"key"`
+	Value       []byte        `json:"value"`
+	Proof       *merkle.Proof `json:"proof"`
+}
+
+// KeyValue is a state key with its value
+type KeyValue struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+}
+
+// TxProof proves that a tx is included in the block and commited with it.
+// Header is the block without tx hashes, certified by QC.
+type TxProof struct {
+	Header      *core.Block       `json:"header"`
+	QC          *core.QuorumCert  `json:"qc"`
+	Index       int               `json:"index"`
+	Path        [][]byte          `json:"path"`
+	TxCommit    *core.TxCommit    `json:"txCommit"`
+	BlockCommit *core.BlockCommit `json:"blockCommit"`
 }
 
 type Config struct {
 	MerkleBranchFactor uint8
 	ConcurrentLimit    int
+
+	// hash of state keys and values for merkle tree leaves, SHA3-256 if zero
+	StateHashFunc crypto.Hash
+
+	// reject state changes without code address
+	RequireCodeAddr bool
+
+	// don't index txs by sender to save a write per tx, GetTxsBySender fails if set.
+	// Txs commited while it's set are not indexed later.
+	DisableSenderIndex bool
+
+	// maximum merkle tree nodes cached in memory, cache is disabled if zero
+	MerkleCacheSize int
+
+	// interval to prune blocks older than KeepRecentBlocks, pruning is disabled if either is zero
+	PruneInterval    time.Duration
+	KeepRecentBlocks uint64
+
+	// number of recent blocks to query state at, state versions are not recorded if zero.
+	// Older versions are pruned every PruneInterval.
+	KeepStateVersions uint64
+
+	// number of recent blocks to prove state at, merkle node versions are not recorded if zero.
+	// Older versions are pruned every PruneInterval.
+	KeepMerkleVersions uint64
+
+	// maximum state values and decoded blocks cached in memory, cache is disabled if zero
+	StateCacheSize int
+	BlockCacheSize int
+
+	// interval of value log gc started by the node, gc is disabled if zero.
+	// A value log file is rewritten if at least GCDiscardRatio of it can be discarded.
+	GCInterval     time.Duration
+	GCDiscardRatio float64
+
+	// badger options of the db opened by Open, badger defaults if zero
+	ValueLogFileSize int64
+	Compression      Compression
+	SyncWrites       bool
+	NumCompactors    int
+
+	// Open keeps the db in memory and ignores the dir, for tests
+	InMemory bool
 }
 
 var DefaultConfig = Config{
 	MerkleBranchFactor: 8,
 	ConcurrentLimit:    20,
+	StateHashFunc:      crypto.SHA3_256,
+	MerkleCacheSize:    100000,
+	GCDiscardRatio:     0.5,
+	ValueLogFileSize:   1<<30 - 1,
+	Compression:        CompressionSnappy,
+	NumCompactors:      4,
+}
+
+func (config Config) stateHashFunc() crypto.Hash {
+	if config.StateHashFunc == 0 {
+		return crypto.SHA3_256
+	}
+	return config.StateHashFunc
 }
 
 type Storage struct {
-	db          *badger.DB
-	chainStore  *chainStore
-	stateStore  *stateStore
-	merkleStore *merkleStore
-	merkleTree  *merkle.Tree
+	db           *badger.DB
+	chainStore   *chainStore
+	stateStore   *stateStore
+	merkleStore  *merkleStore
+	merkleCache  *merkle.CachingStore // nil if disabled
+	merkleTree   *merkle.Tree
+	merkleConfig merkle.Config
+	stateCache   *readCache // nil if disabled
+	blockCache   *readCache // nil if disabled
+	metrics      *storageMetrics
 
-	// for writeStateTree and VerifyState
+	// emits *CommitData after each block is written
+	commitEmitter *emitter.Emitter
+
+	// for writeStateTree, VerifyState and GetStateProof
 	mtxWriteState sync.RWMutex
+
+	mtxPrune sync.Mutex
+
+	// for commits and value log gc
+	mtxCommit sync.Mutex
+
+	closeCh   chan struct{}
+	closeOnce sync.Once
+	wgLoops   sync.WaitGroup // gc, prune and merkle migration loops
+
+	keepStateVersions uint64
+
+	keepMerkleVersions uint64
+	requireCodeAddr    bool
+	indexSender        bool
+
+	readOnly     bool // opened by OpenReadOnly
+	metaRecorded bool // metadata is written with the first commit
+	ownsDB       bool // opened by Open, closed by Close
+
+	// called after each commit stage, an error aborts the commit (crash injection in tests)
+	afterStage func(stage commitStage) error
 }
 
+// New creates the storage on the db, it exits if the db doesn't match the config, see Open
 func New(db *badger.DB, config Config) *Storage {
+	strg, err := newWritable(db, config)
+	if err != nil {
+		logger.I().Fatalw("setup storage failed", "error", err)
+	}
+	return strg
+}
+
+func newWritable(db *badger.DB, config Config) (*Storage, error) {
+	if !config.stateHashFunc().Available() {
+		return nil, fmt.Errorf("state hash function %d not available", config.StateHashFunc)
+	}
+	strg := newStorage(db, config, false)
+	if err := strg.checkMetadata(); err != nil && !errors.Is(err, ErrMigrationRequired) {
+		return nil, err
+	}
+	if err := strg.recoverCommit(); err != nil {
+		return nil, fmt.Errorf("recover half-applied commit failed, %w", err)
+	}
+	if err := strg.Migrate(); err != nil {
+		return nil, fmt.Errorf("migrate storage failed, %w", err)
+	}
+	if strg.hasInterruptedRebuild() {
+		logger.I().Warn("merkle tree rebuild was interrupted, run it again")
+	}
+	if strg.merkleStore.legacy.exist() {
+		strg.wgLoops.Add(1)
+		go strg.migrateMerkleNodesInBackground()
+	}
+	if config.KeepMerkleVersions == 0 {
+		if err := strg.dropMerkleHistory(); err != nil {
+			return nil, fmt.Errorf("drop merkle history failed, %w", err)
+		}
+	}
+	if config.PruneInterval > 0 &&
+		(config.KeepRecentBlocks > 0 || config.KeepStateVersions > 0 || config.KeepMerkleVersions > 0) {
+		strg.wgLoops.Add(1)
+		go strg.pruneLoop(config.PruneInterval,
+			config.KeepRecentBlocks, config.KeepStateVersions, config.KeepMerkleVersions)
+	}
+	return strg, nil
+}
+
+// Open opens the db at dir with the badger options of config and creates the storage.
+// It returns ErrMetadataMismatch if the db was created with a different tree or state hash,
+// or has a newer format version. A db with an older format version is migrated, see Migrate.
+// Close closes the db.
+func Open(dir string, config Config) (*Storage, error) {
+	opts, err := config.badgerOptions(dir)
+	if err != nil {
+		return nil, err
+	}
+	db, err := badger.Open(opts)
+	if err != nil {
+		return nil, fmt.Errorf("open db, %w", err)
+	}
+	strg, err := newWritable(db, config)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	strg.ownsDB = true
+	return strg, nil
+}
+
+// newStorage doesn't cache merkle nodes for read-only storage, which never commits tree updates
+func newStorage(db *badger.DB, config Config, readOnly bool) *Storage {
 	strg := new(Storage)
 	strg.db = db
+	strg.readOnly = readOnly
+	strg.keepStateVersions = config.KeepStateVersions
+	strg.keepMerkleVersions = config.KeepMerkleVersions
+	strg.requireCodeAddr = config.RequireCodeAddr
+	strg.indexSender = !config.DisableSenderIndex
+	strg.stateCache = newReadCache(config.StateCacheSize)
+	strg.blockCache = newReadCache(config.BlockCacheSize)
+	strg.metrics = newStorageMetrics()
+	strg.commitEmitter = emitter.New()
+	strg.closeCh = make(chan struct{})
 	getter := &badgerGetter{db}
 	strg.chainStore = &chainStore{getter}
-	strg.stateStore = &stateStore{getter, crypto.SHA3_256, config.ConcurrentLimit}
-	strg.merkleStore = &merkleStore{getter}
-	strg.merkleTree = merkle.NewTree(strg.merkleStore, merkle.Config{
+	strg.stateStore = &stateStore{getter, config.stateHashFunc(), config.ConcurrentLimit}
+	strg.merkleStore = &merkleStore{getter, newLegacyNodes(hasLegacyMerkleNodes(db))}
+	var treeStore merkle.Store = strg.merkleStore
+	if config.MerkleCacheSize > 0 && !readOnly {
+		strg.merkleCache = merkle.NewCachingStore(strg.merkleStore, config.MerkleCacheSize)
+		treeStore = strg.merkleCache
+	}
+	strg.merkleConfig = merkle.Config{
 		Hash:            crypto.SHA3_256,
 		BranchFactor:    config.MerkleBranchFactor,
 		ConcurrentLimit: config.ConcurrentLimit,
-	})
+	}
+	strg.merkleTree = merkle.NewTree(treeStore, strg.merkleConfig)
 	return strg
 }
 
+// InitGenesis stores genesis on first boot.
+// Once stored, it returns core.ErrGenesisMismatch for a different genesis.
+func (strg *Storage) InitGenesis(g *core.Genesis) error {
+	stored, err := strg.chainStore.getGenesis()
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		if strg.readOnly {
+			return ErrReadOnly
+		}
+		return updateBadgerDB(strg.db, []updateFunc{strg.chainStore.setGenesis(g)})
+	}
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(stored.Hash(), g.Hash()) {
+		return fmt.Errorf("%w, stored %x, configured %x",
+			core.ErrGenesisMismatch, stored.Hash(), g.Hash())
+	}
+	return nil
+}
+
+func (strg *Storage) GetGenesis() (*core.Genesis, error) {
+	return strg.chainStore.getGenesis()
+}
+
 func (strg *Storage) Commit(data *CommitData) error {
 	return strg.commit(data)
 }
 
+// SubscribeCommitted subscribes the commited blocks as *CommitData events, in order of height.
+// Events are emitted by Commit and CommitBatch after the data is written to the db,
+// which is synced to disk before if Config.SyncWrites is set.
+// Events are dropped for the subscriber when its buffer is full.
+func (strg *Storage) SubscribeCommitted(buffer int) *emitter.Subscription {
+	return strg.commitEmitter.Subscribe(buffer)
+}
+
+// GetBlock returns ErrPruned if the block body is pruned
 func (strg *Storage) GetBlock(hash []byte) (*core.Block, error) {
-	return strg.chainStore.getBlock(hash)
+	defer strg.metrics.getBlock.since(time.Now())
+	blk, err := strg.getBlock(hash)
+	if err != nil {
+		return nil, err
+	}
+	return strg.chainStore.checkPruned(blk)
+}
+
+// GetBlockHeader returns the commited block without txs, pruned blocks included
+func (strg *Storage) GetBlockHeader(hash []byte) (*core.Block, error) {
+	blk, err := strg.getBlock(hash)
+	if err != nil {
+		return nil, err
+	}
+	return blk.Header(), nil
 }
 
 func (strg *Storage) GetLastBlock() (*core.Block, error) {
-	return strg.chainStore.getLastBlock()
+	height, err := strg.chainStore.getBlockHeight()
+	if err != nil {
+		return nil, err
+	}
+	return strg.getBlockByHeight(height)
 }
 
 func (strg *Storage) GetLastQC() (*core.QuorumCert, error) {
 	return strg.chainStore.getLastQC()
 }
 
+// GetLastCommitted returns the last commited block with the qc commited with it.
+// Both are read in one transaction, so they are consistent while blocks are being commited.
+// It returns ErrNoBlocks if the chain is not started.
+func (strg *Storage) GetLastCommitted() (*core.Block, *core.QuorumCert, error) {
+	var blk *core.Block
+	var qc *core.QuorumCert
+	err := strg.db.View(func(txn *badger.Txn) error {
+		cs := &chainStore{&txnGetter{txn}}
+		height, err := cs.getBlockHeight()
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return ErrNoBlocks
+		}
+		if err != nil {
+			return err
+		}
+		blk, err = cs.getBlockByHeight(height)
+		if err != nil {
+			return fmt.Errorf("cannot get last block %d, %w", height, err)
+		}
+		qc, err = cs.getLastQC()
+		if err != nil {
+			return fmt.Errorf("cannot get last qc %d, %w", blk.Height(), err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return blk, qc, nil
+}
+
+// GetQC returns the qc certifying the commited block.
+// Blocks commited by older versions have no qc stored, the qc of the child block certifies them.
+func (strg *Storage) GetQC(blockHash []byte) (*core.QuorumCert, error) {
+	return strg.chainStore.getQC(blockHash)
+}
+
 func (strg *Storage) GetBlockHeight() uint64 {
 	height, _ := strg.chainStore.getBlockHeight()
 	return height
 }
 
+// GetBlockByHeight returns ErrPruned if the block body is pruned
 func (strg *Storage) GetBlockByHeight(height uint64) (*core.Block, error) {
-	return strg.chainStore.getBlockByHeight(height)
+	blk, err := strg.getBlockByHeight(height)
+	if err != nil {
+		return nil, err
+	}
+	return strg.chainStore.checkPruned(blk)
 }
 
+// GetBlockHeaderByHeight returns the commited block without txs, pruned blocks included
+func (strg *Storage) GetBlockHeaderByHeight(height uint64) (*core.Block, error) {
+	blk, err := strg.getBlockByHeight(height)
+	if err != nil {
+		return nil, err
+	}
+	return blk.Header(), nil
+}
+
+// GetBlocksByRange returns the blocks from height to height (inclusive) in ascending order,
+// blocks above the last commited height are not returned.
+// It returns ErrPruned if the body of any block in the range is pruned.
+func (strg *Storage) GetBlocksByRange(from, to uint64) ([]*core.Block, error) {
+	blocks := make([]*core.Block, 0)
+	err := strg.db.View(func(txn *badger.Txn) error {
+		cs := &chainStore{&txnGetter{txn}}
+		last, err := cs.getBlockHeight()
+		if err != nil {
+			return nil // no blocks yet
+		}
+		if to > last {
+			to = last
+		}
+		if from > to {
+			return nil
+		}
+		if pruned := cs.getPrunedHeight(); from < pruned {
+			return fmt.Errorf("%w, blocks below %d", ErrPruned, pruned)
+		}
+		prefix := []byte{colBlockHashByHeight}
+		it := txn.NewIterator(badger.IteratorOptions{PrefetchValues: true, Prefix: prefix})
+		defer it.Close()
+		for it.Seek(concatBytes(prefix, uint64BEBytes(from))); it.Valid(); it.Next() {
+			height := binary.BigEndian.Uint64(it.Item().Key()[len(prefix):])
+			if height > to {
+				break
+			}
+			hash, err := it.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			blk, err := cs.getBlock(hash)
+			if err != nil {
+				return fmt.Errorf("block not found at %d, %w", height, err)
+			}
+			blocks = append(blocks, blk)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return blocks, nil
+}
+
+// GetBlockCommit returns ErrPruned if the block commit is pruned
 func (strg *Storage) GetBlockCommit(hash []byte) (*core.BlockCommit, error) {
-	return strg.chainStore.getBlockCommit(hash)
+	bcm, err := strg.chainStore.getBlockCommit(hash)
+	if err == nil {
+		return bcm, nil
+	}
+	blk, blkErr := strg.getBlock(hash)
+	if blkErr != nil {
+		return nil, err
+	}
+	return nil, strg.chainStore.prunedError(err, blk.Height())
+}
+
+// GetPrunedHeight returns the lowest height of the blocks which are not pruned
+func (strg *Storage) GetPrunedHeight() uint64 {
+	return strg.chainStore.getPrunedHeight()
 }
 
+// GetTx returns ErrPruned if the tx is pruned
 func (strg *Storage) GetTx(hash []byte) (*core.Transaction, error) {
 	return strg.chainStore.getTx(hash)
 }
@@ -98,29 +482,271 @@ func (strg *Storage) HasTx(hash []byte) bool {
 	return strg.chainStore.hasTx(hash)
 }
 
+// GetTxCommit returns ErrPruned if the tx commit is pruned
 func (strg *Storage) GetTxCommit(hash []byte) (*core.TxCommit, error) {
 	return strg.chainStore.getTxCommit(hash)
 }
 
-func (strg *Storage) GetState(key []byte) []byte {
-	return strg.stateStore.getStateNotFoundNil(key)
+// GetTxsBySender returns the txs submitted by the sender address in the blocks above afterHeight, in commit order.
+// The txs of a block are not split across pages, so the last block may exceed the limit.
+// Txs of pruned blocks are not returned.
+func (strg *Storage) GetTxsBySender(sender []byte, afterHeight uint64, limit int) ([]*core.Transaction, error) {
+	if !strg.indexSender {
+		return nil, ErrSenderIndexDisabled
+	}
+	txs := make([]*core.Transaction, 0)
+	if limit <= 0 || len(sender) == 0 || afterHeight == math.MaxUint64 {
+		return txs, nil
+	}
+	prefix := concatBytes([]byte{colTxHashBySender}, sender)
+	err := strg.db.View(func(txn *badger.Txn) error {
+		cs := &chainStore{&txnGetter{txn}}
+		it := txn.NewIterator(badger.IteratorOptions{PrefetchValues: true, PrefetchSize: limit, Prefix: prefix})
+		defer it.Close()
+		var lastHeight uint64
+		for it.Seek(concatBytes(prefix, uint64BEBytes(afterHeight+1))); it.Valid(); it.Next() {
+			key := it.Item().Key()
+			if len(key) != len(prefix)+12 {
+				continue // sender is a prefix of another sender
+			}
+			height := binary.BigEndian.Uint64(key[len(prefix):])
+			if len(txs) >= limit && height != lastHeight {
+				break
+			}
+			hash, err := it.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			tx, err := cs.getTx(hash)
+			if err != nil {
+				return fmt.Errorf("tx not found %x, %w", hash, err)
+			}
+			txs = append(txs, tx)
+			lastHeight = height
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return txs, nil
 }
 
-func (strg *Storage) VerifyState(key []byte) []byte {
-	strg.mtxWriteState.RLock()
-	defer strg.mtxWriteState.RUnlock()
+// GetLatestTxsBySender returns up to limit txs submitted by the sender address, the most recent first.
+// Txs of pruned blocks are not returned.
+func (strg *Storage) GetLatestTxsBySender(sender []byte, limit int) ([]*core.Transaction, error) {
+	if !strg.indexSender {
+		return nil, ErrSenderIndexDisabled
+	}
+	txs := make([]*core.Transaction, 0)
+	if limit <= 0 || len(sender) == 0 {
+		return txs, nil
+	}
+	prefix := concatBytes([]byte{colTxHashBySender}, sender)
+	err := strg.db.View(func(txn *badger.Txn) error {
+		cs := &chainStore{&txnGetter{txn}}
+		it := txn.NewIterator(badger.IteratorOptions{
+			PrefetchValues: true,
+			PrefetchSize:   limit,
+			Reverse:        true,
+			Prefix:         prefix,
+		})
+		defer it.Close()
+		// after the keys of the sender, height and index
+		seek := concatBytes(prefix, bytes.Repeat([]byte{0xff}, 13))
+		for it.Seek(seek); it.Valid() && len(txs) < limit; it.Next() {
+			if len(it.Item().Key()) != len(prefix)+12 {
+				continue // sender is a prefix of another sender
+			}
+			hash, err := it.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			tx, err := cs.getTx(hash)
+			if err != nil {
+				return fmt.Errorf("tx not found %x, %w", hash, err)
+			}
+			txs = append(txs, tx)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return txs, nil
+}
 
-	value, err := strg.stateStore.getState(key)
+// GetTxProof returns merkle path of commited tx within its block's tx list,
+// with the block header, its qc, the tx commit and block commit, see VerifyTxProof.
+// It fails with ErrNoTxRoot for the blocks hashing the tx list.
+func (strg *Storage) GetTxProof(hash []byte) (*TxProof, error) {
+	txc, err := strg.chainStore.getTxCommit(hash)
 	if err != nil {
-		// state not found
+		return nil, err
+	}
+	blk, err := strg.getBlock(txc.BlockHash())
+	if err != nil {
+		return nil, err
+	}
+	if blk.TxRoot() == nil {
+		return nil, ErrNoTxRoot
+	}
+	qc, err := strg.getCertifyingQC(blk)
+	if err != nil {
+		return nil, err
+	}
+	index := -1
+	for i, txHash := range blk.TransactionsRef() {
+		if bytes.Equal(txHash, hash) {
+			index = i
+			break
+		}
+	}
+	path, err := core.TxInclusionPath(blk.TransactionsRef(), index)
+	if err != nil {
+		return nil, err
+	}
+	bcm, err := strg.chainStore.getBlockCommit(blk.Hash())
+	if err != nil {
+		return nil, fmt.Errorf("cannot load block commit, %w", err)
+	}
+	return &TxProof{
+		Header:      blk.Header(),
+		QC:          qc,
+		Index:       index,
+		Path:        path,
+		TxCommit:    txc,
+		BlockCommit: bcm,
+	}, nil
+}
+
+// getCertifyingQC returns the qc of blk, or the qc of its child for blocks commited without qc
+func (strg *Storage) getCertifyingQC(blk *core.Block) (*core.QuorumCert, error) {
+	if qc, err := strg.chainStore.getQC(blk.Hash()); err == nil {
+		return qc, nil
+	}
+	child, err := strg.getBlockByHeight(blk.Height() + 1)
+	if err != nil || !bytes.Equal(child.QuorumCert().BlockHash(), blk.Hash()) {
+		return nil, fmt.Errorf("qc not found for block %d", blk.Height())
+	}
+	return child.QuorumCert(), nil
+}
+
+// VerifyTxProof checks that the tx is included in the block header certified by the qc
+// signed by the validators, and that the tx commit and block commit of the proof refer to it.
+// The tx root is taken from the header, which is authenticated by its hash.
+func VerifyTxProof(vs core.ValidatorStore, txHash []byte, proof *TxProof) bool {
+	if proof == nil || proof.Header == nil || proof.QC == nil ||
+		proof.TxCommit == nil || proof.BlockCommit == nil {
+		return false
+	}
+	header := proof.Header
+	if header.TxRoot() == nil || !bytes.Equal(header.Sum(), header.Hash()) {
+		return false
+	}
+	if proof.QC.Validate(vs) != nil || proof.QC.CheckBlock(header) != nil {
+		return false
+	}
+	if !bytes.Equal(proof.TxCommit.Hash(), txHash) ||
+		!bytes.Equal(proof.TxCommit.BlockHash(), header.Hash()) ||
+		proof.TxCommit.BlockHeight() != header.Height() {
+		return false
+	}
+	if !bytes.Equal(proof.BlockCommit.Hash(), header.Hash()) {
+		return false
+	}
+	return core.VerifyTxInclusion(header.TxRoot(), txHash, proof.Index, proof.Path)
+}
+
+// GetState returns the state of key in the namespace of codeAddr, codeAddr is nil for system keys
+func (strg *Storage) GetState(codeAddr, key []byte) []byte {
+	defer strg.metrics.getState.since(time.Now())
+	return strg.getState(core.StateKey(codeAddr, key))
+}
+
+// GetStatesByPrefix returns up to limit states whose keys start with prefix, ordered by key from start,
+// and the key to continue from, nil after the last state. Start is the prefix if nil.
+// Keys are the stored keys, see core.StateKey and GetCodeStatesByPrefix.
+func (strg *Storage) GetStatesByPrefix(prefix, start []byte, limit int) ([]KeyValue, []byte, error) {
+	if start == nil {
+		start = prefix
+	}
+	if !bytes.HasPrefix(start, prefix) {
+		return nil, nil, fmt.Errorf("start %x doesn't have prefix %x", start, prefix)
+	}
+	kvs := make([]KeyValue, 0)
+	if limit <= 0 {
+		return kvs, nil, nil
+	}
+	var next []byte
+	colPrefix := concatBytes([]byte{colStateValueByKey}, prefix)
+	err := strg.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{PrefetchValues: true, PrefetchSize: limit, Prefix: colPrefix})
+		defer it.Close()
+		for it.Seek(concatBytes([]byte{colStateValueByKey}, start)); it.Valid(); it.Next() {
+			key := it.Item().KeyCopy(nil)[1:]
+			if len(kvs) == limit {
+				next = key
+				return nil
+			}
+			value, err := it.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			kvs = append(kvs, KeyValue{Key: key, Value: value})
+		}
 		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return kvs, next, nil
+}
+
+// GetCodeStatesByPrefix is GetStatesByPrefix in the namespace of codeAddr,
+// keys are without the namespace like GetState. codeAddr is nil for system keys.
+func (strg *Storage) GetCodeStatesByPrefix(codeAddr, prefix, start []byte, limit int) ([]KeyValue, []byte, error) {
+	nsPrefix := core.StateKey(codeAddr, prefix)
+	var nsStart []byte
+	if start != nil {
+		nsStart = core.StateKey(codeAddr, start)
+	}
+	kvs, next, err := strg.GetStatesByPrefix(nsPrefix, nsStart, limit)
+	if err != nil {
+		return nil, nil, err
+	}
+	nsLen := len(nsPrefix) - len(prefix)
+	for i := range kvs {
+		kvs[i].Key = kvs[i].Key[nsLen:]
+	}
+	if next != nil {
+		next = next[nsLen:]
+	}
+	return kvs, next, nil
+}
+
+// VerifyState returns the state like GetState after verifying it with the state root,
+// the cleared leaf of a deleted key is verified too
+func (strg *Storage) VerifyState(codeAddr, key []byte) []byte {
+	strg.mtxWriteState.RLock()
+	defer strg.mtxWriteState.RUnlock()
+
+	key = core.StateKey(codeAddr, key)
+	value, errValue := strg.stateStore.getState(key)
+	leaf := clearedLeaf
+	if errValue == nil {
+		leaf = strg.stateStore.sumState(key, value)
 	}
 	merkleIdx, err := strg.stateStore.getMerkleIndex(key)
 	if err != nil {
-		panic("failed to get state merkle index")
+		if errValue == nil {
+			panic("failed to get state merkle index")
+		}
+		// state not found
+		return nil
 	}
 	node := &merkle.Node{
-		Data:     strg.stateStore.sumStateValue(value),
+		Data:     leaf,
 		Position: merkle.NewPosition(0, big.NewInt(0).SetBytes(merkleIdx)),
 	}
 	if !strg.merkleTree.Verify([]*merkle.Node{node}) {
@@ -129,6 +755,170 @@ func (strg *Storage) VerifyState(key []byte) []byte {
 	return value
 }
 
+// VerifyStateWithProof returns the state like VerifyState with its inclusion proof against the current state root,
+// so that clients can verify it again offline with merkle.VerifyProof. The proof of a deleted key is for the cleared leaf.
+func (strg *Storage) VerifyStateWithProof(codeAddr, key []byte) ([]byte, *merkle.Proof, error) {
+	strg.mtxWriteState.RLock()
+	defer strg.mtxWriteState.RUnlock()
+
+	key = core.StateKey(codeAddr, key)
+	merkleIdx, err := strg.stateStore.getMerkleIndex(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("state not found %x, %w", key, err)
+	}
+	value, err := strg.stateStore.getState(key)
+	leaf := &merkle.Node{
+		Position: merkle.NewPosition(0, big.NewInt(0).SetBytes(merkleIdx)),
+		Data:     clearedLeaf,
+	}
+	if err == nil {
+		leaf.Data = strg.stateStore.sumState(key, value)
+	} else if !errors.Is(err, badger.ErrKeyNotFound) {
+		return nil, nil, err
+	}
+	proof, err := strg.merkleTree.GenerateProof(leaf)
+	if err != nil {
+		return nil, nil, fmt.Errorf("state leaf %x, %w", key, err)
+	}
+	root := strg.GetMerkleRoot()
+	if !merkle.VerifyProof(root, proof, strg.merkleConfig.Hash, strg.metadata().MerkleBranchFactor) {
+		return nil, nil, fmt.Errorf("merkle verification failed, state key %x", key)
+	}
+	return value, proof, nil
+}
+
+// GetStateProof returns the values of keys with a multiproof against the current state root,
+// keys are the stored keys, see core.StateKey
+func (strg *Storage) GetStateProof(keys [][]byte) (*StateProof, error) {
+	strg.mtxWriteState.RLock()
+	defer strg.mtxWriteState.RUnlock()
+
+	sp := &StateProof{
+		BlockHeight: strg.GetBlockHeight(),
+		MerkleRoot:  strg.GetMerkleRoot(),
+		Keys:        keys,
+		Values:      make([][]byte, len(keys)),
+	}
+	blk, err := strg.getBlockByHeight(sp.BlockHeight)
+	if err != nil {
+		return nil, err
+	}
+	sp.BlockHash = blk.Hash()
+	indexes := make([]*big.Int, len(keys))
+	seen := make(map[string]struct{}, len(keys))
+	for i, key := range keys {
+		if _, found := seen[string(key)]; found {
+			return nil, fmt.Errorf("duplicate key %x", key)
+		}
+		seen[string(key)] = struct{}{}
+		sp.Values[i], err = strg.stateStore.getState(key)
+		if err != nil {
+			return nil, fmt.Errorf("state not found %x, %w", key, err)
+		}
+		merkleIdx, err := strg.stateStore.getMerkleIndex(key)
+		if err != nil {
+			return nil, fmt.Errorf("merkle index not found %x, %w", key, err)
+		}
+		indexes[i] = big.NewInt(0).SetBytes(merkleIdx)
+	}
+	sp.Proof, err = strg.merkleTree.Prove(indexes)
+	if err != nil {
+		return nil, err
+	}
+	return sp, nil
+}
+
+// VerifyStateProof checks the state proof against the trusted state root.
+// The leaves are hashed with config.StateHashFunc, the tree with config.MerkleBranchFactor.
+func VerifyStateProof(root []byte, sp *StateProof, config Config) bool {
+	if sp == nil || sp.Proof == nil || len(sp.Values) == 0 {
+		return false
+	}
+	// each value must be the leaf of its key
+	if len(sp.Proof.Leaves) != len(sp.Values) || len(sp.Keys) != len(sp.Values) {
+		return false
+	}
+	ss := &stateStore{hashFunc: config.stateHashFunc()}
+	for i, n := range sp.Proof.Leaves {
+		if n == nil || !bytes.Equal(n.Data, ss.sumState(sp.Keys[i], sp.Values[i])) {
+			return false
+		}
+	}
+	return merkle.VerifyMultiProof(merkle.Config{
+		Hash:         crypto.SHA3_256,
+		BranchFactor: config.MerkleBranchFactor,
+	}, root, sp.Proof)
+}
+
+// GetStateKeyProof returns the value of key with the sibling nodes up to the current state root,
+// so that clients without the tree can verify it. The key is the stored key, see core.StateKey.
+func (strg *Storage) GetStateKeyProof(key []byte) (*StateKeyProof, error) {
+	strg.mtxWriteState.RLock()
+	defer strg.mtxWriteState.RUnlock()
+
+	sp := &StateKeyProof{
+		BlockHeight: strg.GetBlockHeight(),
+		MerkleRoot:  strg.GetMerkleRoot(),
+		Key:         key,
+	}
+	blk, err := strg.getBlockByHeight(sp.BlockHeight)
+	if err != nil {
+		return nil, err
+	}
+	sp.BlockHash = blk.Hash()
+	sp.Value, err = strg.stateStore.getState(key)
+	if err != nil {
+		return nil, fmt.Errorf("state not found %x, %w", key, err)
+	}
+	merkleIdx, err := strg.stateStore.getMerkleIndex(key)
+	if err != nil {
+		return nil, fmt.Errorf("merkle index not found %x, %w", key, err)
+	}
+	sp.Proof, err = strg.merkleTree.GenerateProof(&merkle.Node{
+		Position: merkle.NewPosition(0, big.NewInt(0).SetBytes(merkleIdx)),
+		Data:     strg.stateStore.sumState(key, sp.Value),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sp, nil
+}
+
+// VerifyStateKeyProof checks the key and value of the proof against the trusted state root,
+// the leaf is hashed with config.StateHashFunc. The key is the stored key, see core.StateKey,
+// clients must check it's the key they asked for.
+func VerifyStateKeyProof(root []byte, sp *StateKeyProof, config Config) bool {
+	if sp == nil || sp.Proof == nil || sp.Proof.Leaf == nil {
+		return false
+	}
+	ss := &stateStore{hashFunc: config.stateHashFunc()}
+	if !bytes.Equal(sp.Proof.Leaf.Data, ss.sumState(sp.Key, sp.Value)) {
+		return false
+	}
+	return merkle.VerifyProof(root, sp.Proof, crypto.SHA3_256, config.MerkleBranchFactor)
+}
+
+// GetConsistencyProof proves that the current state tree is an append-only extension
+// of the state tree after executing the block at the given height.
+// It fails to verify if any state value of the old tree has been modified since.
+func (strg *Storage) GetConsistencyProof(height uint64) (*merkle.ConsistencyProof, error) {
+	strg.mtxWriteState.RLock()
+	defer strg.mtxWriteState.RUnlock()
+
+	oldLeafCount, err := strg.merkleStore.getLeafCountAtHeight(height)
+	if err != nil {
+		return nil, fmt.Errorf("leaf count not found at height %d, %w", height, err)
+	}
+	return strg.merkleTree.ConsistencyProof(oldLeafCount, strg.merkleStore.getLeafCount())
+}
+
+// GetMerkleStats returns the size of the state tree
+func (strg *Storage) GetMerkleStats() merkle.TreeStats {
+	strg.mtxWriteState.RLock()
+	defer strg.mtxWriteState.RUnlock()
+	return strg.merkleTree.Stats()
+}
+
 func (strg *Storage) GetMerkleRoot() []byte {
 	root := strg.merkleTree.Root()
 	if root == nil {
@@ -138,43 +928,144 @@ func (strg *Storage) GetMerkleRoot() []byte {
 }
 
 func (strg *Storage) commit(data *CommitData) error {
-	if len(data.BlockCommit.StateChanges()) > 0 {
-		start := time.Now()
-		strg.computeMerkleUpdate(data)
-		elapsed := time.Since(start)
-		data.BlockCommit.SetElapsedMerkle(elapsed.Seconds())
-		logger.I().Debugw("compute merkle update",
-			"leaf nodes", len(data.merkleUpdate.Leaves), "elapsed", elapsed)
+	if strg.readOnly {
+		return ErrReadOnly
 	}
+	strg.mtxCommit.Lock()
+	defer strg.mtxCommit.Unlock()
 
+	if err := strg.verifyBody(data); err != nil {
+		return err
+	}
 	start := time.Now()
 	if err := strg.writeCommitData(data); err != nil {
 		return err
 	}
 	elapsed := time.Since(start)
-	logger.I().Debugw("write commit data", "elapsed", elapsed)
+	strg.metrics.commit.observe(elapsed)
+	strg.metrics.addCommits(1, data.written)
+	logger.I().Debugw("write commit data", "elapsed", elapsed, "bytes", data.written)
+	strg.commitEmitter.Emit(data)
 	return nil
 }
 
+// verifyBody checks commit data txs against block.
+// Txs commited by older blocks are not in data.Transactions and must be in storage,
+// they may be pruned, so only their hashes are checked.
+func (strg *Storage) verifyBody(data *CommitData) error {
+	if err := strg.verifyStateChanges(data.BlockCommit); err != nil {
+		return err
+	}
+	var oldTxs [][]byte
+	if data.BlockCommit != nil {
+		oldTxs = data.BlockCommit.OldBlockTxs()
+	}
+	if len(oldTxs) == 0 {
+		return data.Block.VerifyBody(data.Transactions)
+	}
+	old := make(map[string]struct{}, len(oldTxs))
+	for _, hash := range oldTxs {
+		old[string(hash)] = struct{}{}
+	}
+	newTxs := data.Transactions
+	for i, hash := range data.Block.TransactionsRef() {
+		if _, found := old[string(hash)]; found {
+			if !strg.chainStore.hasTx(hash) {
+				return fmt.Errorf("old block tx not found %x", hash)
+			}
+			continue
+		}
+		if len(newTxs) == 0 {
+			return fmt.Errorf("%w, missing tx at %d", core.ErrInvalidBlockBody, i)
+		}
+		if newTxs[0] == nil || !bytes.Equal(newTxs[0].Hash(), hash) {
+			return fmt.Errorf("%w, tx %d doesn't match block", core.ErrInvalidBlockBody, i)
+		}
+		newTxs = newTxs[1:]
+	}
+	if len(newTxs) > 0 {
+		return fmt.Errorf("%w, %d extra txs", core.ErrInvalidBlockBody, len(newTxs))
+	}
+	return nil
+}
+
+// writeCommitData writes the commit in stages, the block is marked as commit in progress
+// until the last stage. The last stage writes state, merkle tree, last qc and block height
+// in one transaction and removes the marker, so that the commit is applied once it finishes.
+// A commit interrupted before that is rolled back by recoverCommit on the next start.
+// The merkle update is computed while chain data is written, the block commit is written
+// again with the merkle root in the last stage.
 func (strg *Storage) writeCommitData(data *CommitData) error {
-	if err := strg.writeChainData(data); err != nil {
+	if err := strg.setCommitInProgress(data.Block.Hash()); err != nil {
 		return err
 	}
+	if err := strg.stageDone(stageMarker); err != nil {
+		return err
+	}
+	chainStart := time.Now()
+	// block commit goes first, its old block txs tell which txs to keep on rollback
 	if err := strg.writeBlockCommit(data); err != nil {
 		return err
 	}
-	if err := strg.writeStateMerkleTree(data); err != nil {
+	if err := strg.stageDone(stageBlockCommit); err != nil {
+		return err
+	}
+	merkleDone := strg.startMerkleUpdate(data)
+	err := strg.writeChainData(data)
+	strg.metrics.chain.since(chainStart)
+	<-merkleDone
+	if err != nil {
+		return err
+	}
+	if err := strg.stageDone(stageChainData); err != nil {
 		return err
 	}
-	return strg.setCommitedBlockHeight(data.Block.Height())
+	// block height is updated with state, so that state proofs are anchored to the right block
+	strg.mtxWriteState.Lock()
+	defer strg.mtxWriteState.Unlock()
+
+	defer strg.metrics.state.since(time.Now())
+	return strg.writeStateMerkleTree(data)
+}
+
+func (strg *Storage) stageDone(stage commitStage) error {
+	if strg.afterStage == nil {
+		return nil
+	}
+	return strg.afterStage(stage)
+}
+
+// startMerkleUpdate computes the merkle update in a goroutine,
+// the returned channel is closed when it's done
+func (strg *Storage) startMerkleUpdate(data *CommitData) <-chan struct{} {
+	done := make(chan struct{})
+	if len(data.BlockCommit.StateChanges()) == 0 {
+		close(done)
+		return done
+	}
+	go func() {
+		defer close(done)
+		start := time.Now()
+		strg.computeMerkleUpdate(data)
+		elapsed := time.Since(start)
+		strg.metrics.merkle.observe(elapsed)
+		data.BlockCommit.SetElapsedMerkle(elapsed.Seconds())
+		if data.merkleUpdate != nil {
+			logger.I().Debugw("compute merkle update",
+				"leaf nodes", len(data.merkleUpdate.Leaves), "elapsed", elapsed)
+		}
+	}()
+	return done
 }
 
 func (strg *Storage) computeMerkleUpdate(data *CommitData) {
-	strg.stateStore.loadPrevValues(data.BlockCommit.StateChanges())
-	strg.stateStore.loadPrevTreeIndexes(data.BlockCommit.StateChanges())
-	prevLeafCount := strg.merkleStore.getLeafCount()
-	leafCount := strg.stateStore.setNewTreeIndexes(data.BlockCommit.StateChanges(), prevLeafCount)
+	leafCount := strg.setTreeIndexes(data.BlockCommit.StateChanges())
 	nodes := strg.stateStore.computeUpdatedTreeNodes(data.BlockCommit.StateChanges())
+	if len(nodes) == 0 {
+		// only deletes of missing keys, the tree is unchanged
+		data.BlockCommit.SetLeafCount(leafCount.Bytes())
+		return
+	}
 	data.merkleUpdate = strg.merkleTree.Update(nodes, leafCount)
 
 	data.BlockCommit.
@@ -182,34 +1073,88 @@ func (strg *Storage) computeMerkleUpdate(data *CommitData) {
 		SetMerkleRoot(data.merkleUpdate.Root.Data)
 }
 
+// setTreeIndexes loads the previous values and tree indexes of the state changes,
+// assigns new leaf indexes to new keys and returns the new leaf count
+func (strg *Storage) setTreeIndexes(scList []*core.StateChange) *big.Int {
+	strg.stateStore.loadPrevValues(scList)
+	strg.stateStore.loadPrevTreeIndexes(scList)
+	prevLeafCount := strg.merkleStore.getLeafCount()
+	return strg.stateStore.setNewTreeIndexes(scList, prevLeafCount)
+}
+
 func (strg *Storage) writeChainData(data *CommitData) error {
+	updFns := strg.chainDataFns(data)
+	strg.invalidateBlock(data.Block.Hash())
+	defer strg.invalidateBlock(data.Block.Hash())
+	return updateBadgerDB(strg.db, countWrites(updFns, &data.written))
+}
+
+func (strg *Storage) chainDataFns(data *CommitData) []updateFunc {
 	updFns := make([]updateFunc, 0)
 	updFns = append(updFns, strg.chainStore.setBlock(data.Block)...)
-	updFns = append(updFns, strg.chainStore.setLastQC(data.QC))
-	updFns = append(updFns, strg.chainStore.setTxs(data.Transactions)...)
+	updFns = append(updFns, strg.chainStore.setQC(data.Block.Hash(), data.QC))
+	updFns = append(updFns, strg.chainStore.setTxs(data.Block, data.Transactions, strg.indexSender)...)
 	updFns = append(updFns, strg.chainStore.setTxCommits(data.TxCommits)...)
-	return updateBadgerDB(strg.db, updFns)
+	return updFns
 }
 
 func (strg *Storage) writeBlockCommit(data *CommitData) error {
 	updFn := strg.chainStore.setBlockCommit(data.BlockCommit)
-	return updateBadgerDB(strg.db, []updateFunc{updFn})
+	return updateBadgerDB(strg.db, countWrites([]updateFunc{updFn}, &data.written))
 }
 
-// commit state values and merkle tree in one transaction
+// commit state values, merkle tree, block commit, last qc and block height in one transaction,
+// leaf count is recorded for every block to prove consistency with older roots
 func (strg *Storage) writeStateMerkleTree(data *CommitData) error {
-	if len(data.BlockCommit.StateChanges()) == 0 {
-		return nil
+	updFns, err := strg.stateMerkleTreeFns(data)
+	if err != nil {
+		return err
 	}
-	strg.mtxWriteState.Lock()
-	defer strg.mtxWriteState.Unlock()
-
-	updFns := strg.stateStore.commitStateChanges(data.BlockCommit.StateChanges())
-	updFns = append(updFns, strg.merkleStore.commitUpdate(data.merkleUpdate)...)
-	return updateBadgerDB(strg.db, updFns)
+	updFns = append(updFns, deleteCommitInProgress())
+	// invalidated before the write too, so that the old values are not served while it's commited
+	strg.invalidateStates(data.BlockCommit.StateChanges())
+	defer strg.invalidateStates(data.BlockCommit.StateChanges())
+	if err := updateBadgerDB(strg.db, countWrites(updFns, &data.written)); err != nil {
+		return err
+	}
+	strg.metaRecorded = true
+	if strg.merkleCache != nil && data.merkleUpdate != nil {
+		strg.merkleCache.CommitUpdate(data.merkleUpdate)
+	}
+	return nil
 }
 
-func (strg *Storage) setCommitedBlockHeight(height uint64) error {
-	updFn := strg.chainStore.setBlockHeight(height)
-	return updateBadgerDB(strg.db, []updateFunc{updFn})
+func (strg *Storage) stateMerkleTreeFns(data *CommitData) ([]updateFunc, error) {
+	leafCount := strg.merkleStore.getLeafCount()
+	updFns := make([]updateFunc, 0)
+	if len(data.BlockCommit.StateChanges()) > 0 {
+		updFns = strg.stateStore.commitStateChanges(data.BlockCommit.StateChanges())
+	}
+	if data.merkleUpdate != nil {
+		updFns = append(updFns, strg.merkleStore.commitUpdate(data.merkleUpdate)...)
+		leafCount = data.merkleUpdate.LeafCount
+	}
+	if strg.keepStateVersions > 0 {
+		verFns, err := strg.writeStateVersions(data.BlockCommit.StateChanges(), data.Block.Height())
+		if err != nil {
+			return nil, err
+		}
+		updFns = append(updFns, verFns...)
+	}
+	if strg.keepMerkleVersions > 0 {
+		changed := len(data.BlockCommit.StateChanges()) > 0
+		verFns, err := strg.writeMerkleVersions(data.merkleUpdate, data.Block.Height(), changed)
+		if err != nil {
+			return nil, err
+		}
+		updFns = append(updFns, verFns...)
+	}
+	updFns = append(updFns, strg.merkleStore.setLeafCountAtHeight(data.Block.Height(), leafCount))
+	updFns = append(updFns, strg.chainStore.setBlockCommit(data.BlockCommit))
+	updFns = append(updFns, strg.chainStore.setLastQC(data.QC))
+	updFns = append(updFns, strg.chainStore.setBlockHeight(data.Block.Height()))
+	if !strg.metaRecorded {
+		updFns = append(updFns, strg.setMetadata())
+	}
+	return updFns, nil
 }
@@ -4,15 +4,24 @@
 package storage
 
 import (
+	"bytes"
+	"crypto"
+	"encoding/json"
 	"math/big"
+	"math/rand"
 	"testing"
 
 	"github.com/aungmawjj/juria-blockchain/core"
+	"github.com/aungmawjj/juria-blockchain/merkle"
+	"github.com/dgraph-io/badger/v3"
 	"github.com/stretchr/testify/assert"
 )
 
 func newTestStorage() *Storage {
-	return New(createOnMemoryDB(), DefaultConfig)
+	config := DefaultConfig
+	config.InMemory = true
+	strg, _ := Open("", config)
+	return strg
 }
 
 func TestStorage_StateZero(t *testing.T) {
@@ -22,7 +31,7 @@ func TestStorage_StateZero(t *testing.T) {
 	assert.Nil(strg.GetMerkleRoot())
 	_, err := strg.GetLastBlock()
 	assert.Error(err)
-	assert.Nil(strg.GetState([]byte("some key")))
+	assert.Nil(strg.GetState(nil, []byte("some key")))
 }
 
 func TestStorage_Commit(t *testing.T) {
@@ -67,15 +76,18 @@ func TestStorage_Commit(t *testing.T) {
 	assert.Equal(big.NewInt(2).Bytes(), bcm.LeafCount())
 
 	h := hashFunc.New()
-	h.Write(strg.stateStore.sumStateValue([]byte{10}))
-	h.Write(strg.stateStore.sumStateValue([]byte{20}))
+	h.Write(strg.stateStore.sumState([]byte{1}, []byte{10}))
+	h.Write(strg.stateStore.sumState([]byte{2}, []byte{20}))
 	mroot := h.Sum(nil)
 	h.Reset()
 	assert.Equal(mroot, bcm.MerkleRoot())
 	assert.Equal(bcm.MerkleRoot(), strg.GetMerkleRoot())
 
-	assert.Equal([]byte{10}, strg.GetState([]byte{1}))
-	assert.Equal([]byte{20}, strg.GetState([]byte{2}))
+	assert.Equal([]byte{10}, strg.GetState(nil, []byte{1}))
+	assert.Equal([]byte{20}, strg.GetState(nil, []byte{2}))
+
+	tx1 := core.NewTransaction().SetNonce(1).Sign(priv)
+	tx2 := core.NewTransaction().SetNonce(2).Sign(priv)
 
 	qc := core.NewQuorumCert().Build([]*core.Vote{b0.ProposerVote()})
 	b1 := core.NewBlock().
@@ -83,11 +95,9 @@ func TestStorage_Commit(t *testing.T) {
 		SetQuorumCert(qc).
 		SetParentHash(b0.Hash()).
 		SetMerkleRoot(strg.GetMerkleRoot()).
+		SetTransactions([][]byte{tx1.Hash(), tx2.Hash()}).
 		Sign(priv)
 
-	tx1 := core.NewTransaction().SetNonce(1).Sign(priv)
-	tx2 := core.NewTransaction().SetNonce(2).Sign(priv)
-
 	txc1 := core.NewTxCommit().SetHash(tx1.Hash())
 	txc2 := core.NewTxCommit().SetHash(tx2.Hash())
 
@@ -137,25 +147,25 @@ func TestStorage_Commit(t *testing.T) {
 	assert.Equal(big.NewInt(2).Bytes(), bcm.StateChanges()[2].TreeIndex())
 	assert.Equal(big.NewInt(4).Bytes(), bcm.LeafCount())
 
-	h.Write(strg.stateStore.sumStateValue([]byte{20}))
-	h.Write(strg.stateStore.sumStateValue([]byte{20}))
-	h.Write(strg.stateStore.sumStateValue([]byte{30}))
-	h.Write(strg.stateStore.sumStateValue([]byte{50}))
+	h.Write(strg.stateStore.sumState([]byte{1}, []byte{20}))
+	h.Write(strg.stateStore.sumState([]byte{2}, []byte{20}))
+	h.Write(strg.stateStore.sumState([]byte{3}, []byte{30}))
+	h.Write(strg.stateStore.sumState([]byte{5}, []byte{50}))
 	mroot = h.Sum(nil)
 	h.Reset()
 	assert.Equal(mroot, bcm.MerkleRoot())
 	assert.Equal(bcm.MerkleRoot(), strg.GetMerkleRoot())
 
-	assert.Equal([]byte{50}, strg.GetState([]byte{5}))
+	assert.Equal([]byte{50}, strg.GetState(nil, []byte{5}))
 	var value []byte
 	assert.NotPanics(func() {
-		value = strg.VerifyState([]byte{5})
+		value = strg.VerifyState(nil, []byte{5})
 	})
 	assert.Equal([]byte{50}, value)
 
 	assert.NotPanics(func() {
 		// non existing state value
-		value = strg.VerifyState([]byte{10})
+		value = strg.VerifyState(nil, []byte{10})
 	})
 	assert.Nil(value)
 
@@ -165,7 +175,815 @@ func TestStorage_Commit(t *testing.T) {
 
 	// should panic
 	assert.Panics(func() {
-		value = strg.VerifyState([]byte{5})
+		value = strg.VerifyState(nil, []byte{5})
+	})
+	assert.Nil(value)
+}
+
+func TestStorage_InitGenesis(t *testing.T) {
+	assert := assert.New(t)
+
+	vlds := [][]byte{core.GenerateKey(nil).PublicKey().Bytes()}
+	g := core.NewGenesis([]byte{1}, vlds, nil)
+
+	strg := newTestStorage()
+	_, err := strg.GetGenesis()
+	assert.Error(err)
+
+	assert.NoError(strg.InitGenesis(g))
+	stored, err := strg.GetGenesis()
+	assert.NoError(err)
+	assert.Equal(g.Hash(), stored.Hash())
+
+	assert.NoError(strg.InitGenesis(core.NewGenesis([]byte{1}, vlds, nil)))
+	assert.ErrorIs(strg.InitGenesis(core.NewGenesis([]byte{2}, vlds, nil)), core.ErrGenesisMismatch)
+}
+
+func TestStorage_GetLastCommitted(t *testing.T) {
+	assert := assert.New(t)
+
+	strg := newTestStorage()
+	_, _, err := strg.GetLastCommitted()
+	assert.ErrorIs(err, ErrNoBlocks)
+
+	priv := core.GenerateKey(nil)
+	var parent *core.Block
+	var parentQC *core.QuorumCert
+	for h := uint64(0); h < 3; h++ {
+		blk := core.NewBlock().SetHeight(h)
+		if parent != nil {
+			blk.SetParentHash(parent.Hash()).SetQuorumCert(parentQC)
+		}
+		blk.Sign(priv)
+		qc := core.NewQuorumCert().Build([]*core.Vote{blk.ProposerVote()})
+		assert.NoError(strg.Commit(&CommitData{
+			Block:       blk,
+			QC:          qc,
+			BlockCommit: core.NewBlockCommit().SetHash(blk.Hash()),
+		}))
+		parent, parentQC = blk, qc
+
+		last, lastQC, err := strg.GetLastCommitted()
+		assert.NoError(err)
+		assert.Equal(blk.Hash(), last.Hash())
+		assert.Equal(blk.Hash(), lastQC.BlockHash())
+		assert.Equal(h, lastQC.BlockHeight())
+	}
+}
+
+func TestStorage_GetTxProof(t *testing.T) {
+	assert := assert.New(t)
+
+	strg := newTestStorage()
+	privs := make([]*core.PrivateKey, 4)
+	vlds := make([]*core.PublicKey, len(privs))
+	for i := range privs {
+		privs[i] = core.GenerateKey(nil)
+		vlds[i] = privs[i].PublicKey()
+	}
+	vs := core.NewValidatorStore(vlds)
+	newQC := func(blk *core.Block) *core.QuorumCert {
+		votes := make([]*core.Vote, 3)
+		for i := range votes {
+			votes[i] = blk.Vote(privs[i])
+		}
+		return core.NewQuorumCert().Build(votes)
+	}
+
+	b0 := core.NewBlock().SetHeight(0).Sign(privs[0])
+	assert.NoError(strg.Commit(&CommitData{
+		Block:       b0,
+		QC:          newQC(b0),
+		BlockCommit: core.NewBlockCommit().SetHash(b0.Hash()),
+	}))
+
+	txs := make([]*core.Transaction, 5)
+	txHashes := make([][]byte, len(txs))
+	for i := range txs {
+		txs[i] = core.NewTransaction().SetNonce(int64(i)).Sign(privs[0])
+		txHashes[i] = txs[i].Hash()
+	}
+	b1 := core.NewBlock().SetHeight(1).SetParentHash(b0.Hash()).SetQuorumCert(newQC(b0)).
+		SetTransactions(txHashes).Sign(privs[0])
+	txcs := make([]*core.TxCommit, len(txs))
+	for i, tx := range txs {
+		txcs[i] = core.NewTxCommit().SetHash(tx.Hash()).SetBlockHash(b1.Hash()).SetBlockHeight(1)
+	}
+	assert.NoError(strg.Commit(&CommitData{
+		Block:        b1,
+		QC:           newQC(b1),
+		Transactions: txs,
+		TxCommits:    txcs,
+		BlockCommit:  core.NewBlockCommit().SetHash(b1.Hash()),
+	}))
+
+	for i, txHash := range txHashes {
+		proof, err := strg.GetTxProof(txHash)
+		if !assert.NoError(err) {
+			return
+		}
+		assert.Equal(b1.Hash(), proof.Header.Hash())
+		assert.Empty(proof.Header.Transactions(), "header without txs")
+		assert.Equal(i, proof.Index)
+		assert.Equal(b1.Hash(), proof.BlockCommit.Hash())
+
+		b, err := json.Marshal(proof)
+		assert.NoError(err)
+		decoded := new(TxProof)
+		assert.NoError(json.Unmarshal(b, decoded))
+		assert.True(VerifyTxProof(vs, txHash, decoded))
+		assert.False(VerifyTxProof(vs, txHashes[(i+1)%len(txHashes)], decoded))
+		assert.False(VerifyTxProof(core.NewValidatorStore(vlds[:1]), txHash, decoded), "qc not signed by validators")
+	}
+	assert.False(VerifyTxProof(vs, txHashes[0], nil))
+
+	// the header must be certified by the qc
+	proof, err := strg.GetTxProof(txHashes[0])
+	assert.NoError(err)
+	proof.QC = newQC(b0)
+	assert.False(VerifyTxProof(vs, txHashes[0], proof))
+
+	// the tx root is authenticated by header hash
+	proof, err = strg.GetTxProof(txHashes[0])
+	assert.NoError(err)
+	forged := core.NewBlock().SetHeight(1).SetParentHash(b0.Hash()).SetQuorumCert(newQC(b0)).
+		SetTransactions([][]byte{txHashes[0]}).Sign(privs[0])
+	proof.Header = forged.Header()
+	proof.Index = 0
+	proof.Path = nil
+	assert.False(VerifyTxProof(vs, txHashes[0], proof))
+
+	_, err = strg.GetTxProof([]byte("not commited"))
+	assert.Error(err)
+}
+
+func TestStorage_CommitVerifyBody(t *testing.T) {
+	assert := assert.New(t)
+
+	strg := newTestStorage()
+	priv := core.GenerateKey(nil)
+
+	tx1 := core.NewTransaction().SetNonce(1).Sign(priv)
+	tx2 := core.NewTransaction().SetNonce(2).Sign(priv)
+	tx3 := core.NewTransaction().SetNonce(3).Sign(priv)
+
+	newData := func(height uint64, txHashes [][]byte, txs []*core.Transaction) *CommitData {
+		blk := core.NewBlock().SetHeight(height).SetTransactions(txHashes).Sign(priv)
+		return &CommitData{
+			Block:        blk,
+			QC:           core.NewQuorumCert(),
+			Transactions: txs,
+			BlockCommit:  core.NewBlockCommit().SetHash(blk.Hash()),
+		}
+	}
+
+	err := strg.Commit(newData(0, [][]byte{tx1.Hash(), tx2.Hash()}, []*core.Transaction{tx2, tx1}))
+	assert.ErrorIs(err, core.ErrInvalidBlockBody, "wrong order")
+
+	err = strg.Commit(newData(0, [][]byte{tx1.Hash(), tx2.Hash()}, []*core.Transaction{tx1}))
+	assert.ErrorIs(err, core.ErrInvalidBlockBody, "missing tx")
+	_, err = strg.GetLastBlock()
+	assert.Error(err, "rejected block should not be stored")
+
+	err = strg.Commit(newData(0, [][]byte{tx1.Hash()}, []*core.Transaction{tx1}))
+	assert.NoError(err)
+
+	// tx1 was commited by older block
+	data := newData(1, [][]byte{tx2.Hash(), tx1.Hash(), tx3.Hash()}, []*core.Transaction{tx2, tx3})
+	data.BlockCommit.SetOldBlockTxs([][]byte{tx1.Hash()})
+	assert.NoError(strg.Commit(data))
+
+	data = newData(2, [][]byte{tx3.Hash()}, []*core.Transaction{tx3, tx1})
+	assert.ErrorIs(strg.Commit(data), core.ErrInvalidBlockBody, "extra tx")
+
+	// old tx is not in storage
+	tx4 := core.NewTransaction().SetNonce(4).Sign(priv)
+	data = newData(2, [][]byte{tx4.Hash()}, nil)
+	data.BlockCommit.SetOldBlockTxs([][]byte{tx4.Hash()})
+	assert.Error(strg.Commit(data))
+}
+
+func TestStorage_GetStateProof(t *testing.T) {
+	assert := assert.New(t)
+
+	strg := newTestStorage()
+	priv := core.GenerateKey(nil)
+	b0 := core.NewBlock().SetHeight(0).Sign(priv)
+	scList := make([]*core.StateChange, 200)
+	for i := range scList {
+		scList[i] = core.NewStateChange().
+			SetKey([]byte{1, uint8(i)}).SetValue([]byte{2, uint8(i)})
+	}
+	err := strg.Commit(&CommitData{
+		Block:       b0,
+		QC:          core.NewQuorumCert(),
+		BlockCommit: core.NewBlockCommit().SetHash(b0.Hash()).SetStateChanges(scList),
 	})
+	assert.NoError(err)
+	root := strg.GetMerkleRoot()
+
+	keys := make([][]byte, 50)
+	for i := range keys {
+		keys[i] = scList[i*4].Key()
+	}
+	sp, err := strg.GetStateProof(keys)
+	assert.NoError(err)
+	assert.EqualValues(0, sp.BlockHeight)
+	assert.Equal(b0.Hash(), sp.BlockHash)
+	assert.Equal(root, sp.MerkleRoot)
+	assert.True(VerifyStateProof(root, sp, DefaultConfig))
+
+	multiSize := proofSize(t, sp)
+	singleSize := 0
+	for _, key := range keys {
+		single, err := strg.GetStateProof([][]byte{key})
+		assert.NoError(err)
+		assert.True(VerifyStateProof(root, single, DefaultConfig))
+		singleSize += proofSize(t, single)
+	}
+	assert.Less(multiSize*3, singleSize, "shared branches must be deduplicated")
+
+	other := DefaultConfig
+	other.StateHashFunc = crypto.SHA256
+	assert.False(VerifyStateProof(root, sp, other), "different state hash")
+
+	sp.Values[3], sp.Values[4] = sp.Values[4], sp.Values[3]
+	assert.False(VerifyStateProof(root, sp, DefaultConfig), "swapped values")
+	sp.Values[3], sp.Values[4] = sp.Values[4], sp.Values[3]
+
+	sp.Values[3] = []byte{3, 3}
+	assert.False(VerifyStateProof(root, sp, DefaultConfig), "tampered value")
+
+	_, err = strg.GetStateProof([][]byte{keys[0], keys[0]})
+	assert.Error(err, "duplicate key")
+
+	_, err = strg.GetStateProof([][]byte{[]byte("not found")})
+	assert.Error(err)
+}
+
+func TestStorage_GetStateKeyProof(t *testing.T) {
+	assert := assert.New(t)
+
+	strg := newTestStorage()
+	commitTestStates(t, strg, 3, 100)
+	root := strg.GetMerkleRoot()
+
+	for k := 0; k < 100; k += 7 {
+		key := []byte{0, uint8(k)}
+		sp, err := strg.GetStateKeyProof(key)
+		if strg.GetState(nil, key) == nil {
+			assert.Error(err, "not found")
+			continue
+		}
+		assert.NoError(err)
+		assert.EqualValues(2, sp.BlockHeight)
+		assert.Equal(root, sp.MerkleRoot)
+		assert.Equal(strg.GetState(nil, key), sp.Value)
+
+		// light clients receive the proof as json
+		b, err := json.Marshal(sp)
+		assert.NoError(err)
+		decoded := new(StateKeyProof)
+		assert.NoError(json.Unmarshal(b, decoded))
+		assert.True(VerifyStateKeyProof(root, decoded, DefaultConfig))
+
+		other := DefaultConfig
+		other.StateHashFunc = crypto.SHA256
+		assert.False(VerifyStateKeyProof(root, decoded, other), "different state hash")
+
+		// the value of another key at the same leaf
+		decoded.Key = []byte{0, uint8(k + 1)}
+		assert.False(VerifyStateKeyProof(root, decoded, DefaultConfig), "tampered key")
+		decoded.Key = key
+
+		decoded.Value = append(decoded.Value, 1)
+		assert.False(VerifyStateKeyProof(root, decoded, DefaultConfig), "tampered value")
+	}
+	assert.False(VerifyStateKeyProof(root, nil, DefaultConfig))
+
+	stats := strg.GetMerkleStats()
+	assert.Equal(strg.merkleStore.getLeafCount(), stats.LeafCount)
+	assert.Equal(strg.merkleStore.GetHeight(), stats.Height)
+	assert.Equal(1, stats.NodeCount.Cmp(stats.LeafCount))
+}
+
+func TestStorage_VerifyStateWithProof(t *testing.T) {
+	assert := assert.New(t)
+
+	strg := newTestStorage()
+	commitStateBlocks(t, strg, [][][2]string{{{"a", "1"}, {"b", "2"}, {"c", "3"}}})
+	commitStateChangeBlocks(t, strg, [][]*core.StateChange{{
+		core.NewStateChange().SetKey([]byte("c")).SetDeleted(true),
+	}})
+	root := strg.GetMerkleRoot()
+	bfactor := DefaultConfig.MerkleBranchFactor
+
+	value, proof, err := strg.VerifyStateWithProof(nil, []byte("b"))
+	assert.NoError(err)
+	assert.Equal([]byte("2"), value)
+	assert.True(merkle.VerifyProof(root, proof, crypto.SHA3_256, bfactor))
+	assert.Equal(strg.stateStore.sumState([]byte("b"), value), proof.Leaf.Data)
+
+	value, proof, err = strg.VerifyStateWithProof(nil, []byte("c"))
+	assert.NoError(err)
 	assert.Nil(value)
+	assert.Equal(clearedLeaf, proof.Leaf.Data, "deleted key")
+	assert.True(merkle.VerifyProof(root, proof, crypto.SHA3_256, bfactor))
+
+	_, _, err = strg.VerifyStateWithProof(nil, []byte("d"))
+	assert.ErrorIs(err, badger.ErrKeyNotFound)
+}
+
+func TestStorage_GetConsistencyProof(t *testing.T) {
+	assert := assert.New(t)
+
+	strg := newTestStorage()
+	priv := core.GenerateKey(nil)
+	commit := func(height uint64, scList []*core.StateChange) []byte {
+		blk := core.NewBlock().SetHeight(height).Sign(priv)
+		err := strg.Commit(&CommitData{
+			Block:       blk,
+			QC:          core.NewQuorumCert(),
+			BlockCommit: core.NewBlockCommit().SetHash(blk.Hash()).SetStateChanges(scList),
+		})
+		assert.NoError(err)
+		return strg.GetMerkleRoot()
+	}
+	newStates := func(from, to int) []*core.StateChange {
+		scList := make([]*core.StateChange, 0, to-from)
+		for i := from; i < to; i++ {
+			scList = append(scList, core.NewStateChange().
+				SetKey([]byte{1, uint8(i)}).SetValue([]byte{2, uint8(i)}))
+		}
+		return scList
+	}
+	config := merkle.Config{
+		Hash:         crypto.SHA3_256,
+		BranchFactor: DefaultConfig.MerkleBranchFactor,
+	}
+
+	root0 := commit(0, newStates(0, 20))
+	root1 := commit(1, nil) // leaf count is recorded without state changes
+	assert.Equal(root0, root1)
+	root2 := commit(2, newStates(20, 100))
+
+	for height, root := range [][]byte{root0, root1, root2} {
+		proof, err := strg.GetConsistencyProof(uint64(height))
+		assert.NoError(err)
+		assert.EqualValues(100, proof.NewLeafCount.Int64())
+		assert.True(merkle.VerifyConsistencyProof(config, root, root2, proof), "height %d", height)
+	}
+
+	// modified state value is not an append-only extension
+	root3 := commit(3, []*core.StateChange{
+		core.NewStateChange().SetKey([]byte{1, 5}).SetValue([]byte{3, 3}),
+	})
+	proof, err := strg.GetConsistencyProof(0)
+	assert.NoError(err)
+	assert.False(merkle.VerifyConsistencyProof(config, root0, root3, proof))
+
+	_, err = strg.GetConsistencyProof(10)
+	assert.Error(err)
+}
+
+func proofSize(t *testing.T, sp *StateProof) int {
+	b, err := json.Marshal(sp)
+	assert.NoError(t, err)
+	return len(b)
+}
+
+func TestStorage_MerkleCache(t *testing.T) {
+	assert := assert.New(t)
+
+	strg := newTestStorage()
+	assert.NotNil(strg.merkleCache)
+	priv := core.GenerateKey(nil)
+	commit := func(height uint64, value byte) {
+		blk := core.NewBlock().SetHeight(height)
+		if last, err := strg.GetLastBlock(); err == nil {
+			blk.SetParentHash(last.Hash()).
+				SetQuorumCert(core.NewQuorumCert().Build([]*core.Vote{last.ProposerVote()}))
+		}
+		blk.Sign(priv)
+		scList := []*core.StateChange{core.NewStateChange().SetKey([]byte{1}).SetValue([]byte{value})}
+		for i := 2; i < 30; i++ {
+			scList = append(scList, core.NewStateChange().SetKey([]byte{uint8(i)}).SetValue([]byte{value}))
+		}
+		assert.NoError(strg.Commit(&CommitData{
+			Block:       blk,
+			QC:          core.NewQuorumCert(),
+			BlockCommit: core.NewBlockCommit().SetHash(blk.Hash()).SetStateChanges(scList),
+		}))
+	}
+	for i := 0; i < 3; i++ {
+		commit(uint64(i), byte(i))
+		for j := 0; j < 2; j++ { // cached branches must be fresh for the second verification
+			assert.NotPanics(func() {
+				assert.Equal([]byte{byte(i)}, strg.VerifyState(nil, []byte{1}))
+			})
+		}
+	}
+	hits, _ := strg.merkleCache.Stats()
+	assert.Greater(hits, uint64(0))
+}
+
+func TestStorage_StateHashFunc(t *testing.T) {
+	assert := assert.New(t)
+
+	config := DefaultConfig
+	config.StateHashFunc = crypto.SHA256
+	strg := New(createOnMemoryDB(), config)
+	defaultStrg := newTestStorage()
+	blocks := [][][2]string{{{"a", "1"}, {"b", "1"}}, {{"a", "2"}, {"c", "1"}}}
+	commitStateBlocks(t, strg, blocks)
+	commitStateBlocks(t, defaultStrg, blocks)
+
+	for key, value := range map[string]string{"a": "2", "b": "1", "c": "1"} {
+		assert.NotPanics(func() {
+			assert.Equal([]byte(value), strg.VerifyState(nil, []byte(key)))
+		})
+	}
+	merkleIdx, err := strg.stateStore.getMerkleIndex([]byte("a"))
+	assert.NoError(err)
+	leaf := strg.merkleStore.getNode(merkle.NewPosition(0, big.NewInt(0).SetBytes(merkleIdx)))
+	h := crypto.SHA256.New()
+	h.Write([]byte{1}) // key length
+	h.Write([]byte("a"))
+	h.Write([]byte("2"))
+	assert.Equal(h.Sum(nil), leaf, "leaf is hashed with state hash func")
+	assert.NotEqual(defaultStrg.GetMerkleRoot(), strg.GetMerkleRoot())
+}
+
+func TestStorage_GetStatesByPrefix(t *testing.T) {
+	assert := assert.New(t)
+
+	strg := newTestStorage()
+	kvs, next, err := strg.GetStatesByPrefix([]byte("coin/"), nil, 10)
+	assert.NoError(err)
+	assert.Empty(kvs)
+	assert.Nil(next)
+
+	commitStateBlocks(t, strg, [][][2]string{
+		{{"coin/bob", "2"}, {"coin/alice", "1"}, {"coinbase", "x"}, {"nft/1", "a"}},
+		{{"coin/carol", "3"}, {"coin/alice", "4"}},
+	})
+	kvs, next, err = strg.GetStatesByPrefix([]byte("coin/"), nil, 10)
+	assert.NoError(err)
+	assert.Equal([]KeyValue{
+		{[]byte("coin/alice"), []byte("4")},
+		{[]byte("coin/bob"), []byte("2")},
+		{[]byte("coin/carol"), []byte("3")},
+	}, kvs)
+	assert.Nil(next)
+
+	// pages
+	kvs, next, err = strg.GetStatesByPrefix([]byte("coin/"), nil, 2)
+	assert.NoError(err)
+	assert.Len(kvs, 2)
+	assert.Equal([]byte("coin/carol"), next)
+	kvs, next, err = strg.GetStatesByPrefix([]byte("coin/"), next, 2)
+	assert.NoError(err)
+	assert.Equal([]KeyValue{{[]byte("coin/carol"), []byte("3")}}, kvs)
+	assert.Nil(next)
+
+	kvs, next, err = strg.GetStatesByPrefix([]byte("coin/"), nil, 3)
+	assert.NoError(err)
+	assert.Len(kvs, 3)
+	assert.Nil(next, "last page is full")
+
+	kvs, _, err = strg.GetStatesByPrefix(nil, nil, 100)
+	assert.NoError(err)
+	assert.Len(kvs, 5, "only state keys")
+
+	kvs, next, err = strg.GetStatesByPrefix([]byte("nft/2"), nil, 10)
+	assert.NoError(err)
+	assert.Empty(kvs)
+	assert.Nil(next)
+
+	kvs, _, err = strg.GetStatesByPrefix([]byte("coin/"), nil, 0)
+	assert.NoError(err)
+	assert.Empty(kvs)
+
+	_, _, err = strg.GetStatesByPrefix([]byte("coin/"), []byte("nft/1"), 10)
+	assert.Error(err, "start without prefix")
+}
+
+func TestStorage_GetCodeStatesByPrefix(t *testing.T) {
+	assert := assert.New(t)
+
+	strg := newTestStorage()
+	codeAddr := []byte("juriacoin")
+	commitStateChangeBlocks(t, strg, [][]*core.StateChange{{
+		core.NewStateChange().SetCodeAddr(codeAddr).SetKey([]byte("b/1")).SetValue([]byte("1")),
+		core.NewStateChange().SetCodeAddr(codeAddr).SetKey([]byte("b/2")).SetValue([]byte("2")),
+		core.NewStateChange().SetCodeAddr([]byte("other")).SetKey([]byte("b/3")).SetValue([]byte("3")),
+		core.NewStateChange().SetKey([]byte("b/4")).SetValue([]byte("4")),
+	}})
+
+	kvs, next, err := strg.GetCodeStatesByPrefix(codeAddr, []byte("b/"), nil, 1)
+	assert.NoError(err)
+	assert.Equal([]KeyValue{{[]byte("b/1"), []byte("1")}}, kvs)
+	assert.Equal([]byte("b/2"), next)
+	kvs, next, err = strg.GetCodeStatesByPrefix(codeAddr, []byte("b/"), next, 1)
+	assert.NoError(err)
+	assert.Equal([]KeyValue{{[]byte("b/2"), []byte("2")}}, kvs)
+	assert.Nil(next)
+
+	kvs, _, err = strg.GetCodeStatesByPrefix(nil, []byte("b/"), nil, 10)
+	assert.NoError(err)
+	assert.Equal([]KeyValue{{[]byte("b/4"), []byte("4")}}, kvs, "system keys")
+}
+
+func TestStorage_DeleteState(t *testing.T) {
+	assert := assert.New(t)
+
+	strg := newTestStorage()
+	commitStateBlocks(t, strg, [][][2]string{{{"a", "1"}, {"b", "2"}}})
+	root := strg.GetMerkleRoot()
+
+	deleteA := func() []*core.StateChange {
+		return []*core.StateChange{core.NewStateChange().SetKey([]byte("a")).SetDeleted(true)}
+	}
+	commitStateChangeBlocks(t, strg, [][]*core.StateChange{deleteA()})
+	assert.Nil(strg.GetState(nil, []byte("a")))
+	assert.Nil(strg.VerifyState(nil, []byte("a")), "cleared leaf verified")
+	assert.Equal([]byte("2"), strg.VerifyState(nil, []byte("b")))
+	assert.NotEqual(root, strg.GetMerkleRoot())
+	assert.EqualValues(2, strg.merkleStore.getLeafCount().Int64(), "leaf is kept")
+	deletedRoot := strg.GetMerkleRoot()
+
+	// same root with the cleared leaf computed in a batch
+	batch := newTestStorage()
+	commitStateBlocks(t, batch, [][][2]string{{{"a", "1"}, {"b", "2"}}})
+	blk, _ := batch.GetLastBlock()
+	data := newBatchTestData(core.GenerateKey(nil), blk, [][][2]string{nil})
+	data[0].BlockCommit.SetStateChanges(deleteA())
+	assert.NoError(batch.CommitBatch(data))
+	assert.Equal(deletedRoot, batch.GetMerkleRoot())
+
+	// deleting a missing key takes no leaf
+	commitStateChangeBlocks(t, strg, [][]*core.StateChange{{
+		core.NewStateChange().SetKey([]byte("c")).SetDeleted(true),
+	}})
+	assert.Nil(strg.VerifyState(nil, []byte("c")))
+	assert.EqualValues(2, strg.merkleStore.getLeafCount().Int64())
+	assert.Equal(deletedRoot, strg.GetMerkleRoot())
+
+	// snapshot keeps the cleared leaf
+	buf := new(bytes.Buffer)
+	assert.NoError(strg.ExportSnapshot(buf))
+	dst := newTestStorage()
+	assert.NoError(dst.ImportSnapshot(buf))
+	assert.Equal(deletedRoot, dst.GetMerkleRoot())
+	assert.Nil(dst.VerifyState(nil, []byte("a")))
+
+	// setting the key again reuses the leaf
+	commitStateBlocks(t, strg, [][][2]string{{{"a", "1"}}})
+	assert.Equal([]byte("1"), strg.VerifyState(nil, []byte("a")))
+	assert.EqualValues(2, strg.merkleStore.getLeafCount().Int64())
+	assert.Equal(root, strg.GetMerkleRoot())
+}
+
+func TestStorage_GetBlocksByRange(t *testing.T) {
+	assert := assert.New(t)
+
+	strg := newTestStorage()
+	blocks, err := strg.GetBlocksByRange(0, 5)
+	assert.NoError(err)
+	assert.Empty(blocks, "no blocks")
+
+	commitTestBlocks(t, strg, 10)
+
+	tests := []struct {
+		name     string
+		from, to uint64
+		want     []uint64
+	}{
+		{"single", 3, 3, []uint64{3}},
+		{"sub range", 2, 5, []uint64{2, 3, 4, 5}},
+		{"all", 0, 9, []uint64{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}},
+		{"beyond tip", 8, 20, []uint64{8, 9}},
+		{"above tip", 10, 20, []uint64{}},
+		{"from > to", 5, 2, []uint64{}},
+	}
+	for _, tt := range tests {
+		blocks, err := strg.GetBlocksByRange(tt.from, tt.to)
+		assert.NoError(err, tt.name)
+		heights := make([]uint64, len(blocks))
+		for i, blk := range blocks {
+			heights[i] = blk.Height()
+		}
+		assert.Equal(tt.want, heights, tt.name)
+	}
+	blocks, _ = strg.GetBlocksByRange(4, 4)
+	blk, _ := strg.GetBlockByHeight(4)
+	assert.Equal(blk.Hash(), blocks[0].Hash())
+}
+
+func TestStorage_GetTxsBySender(t *testing.T) {
+	assert := assert.New(t)
+
+	strg := newTestStorage()
+	alice, bob := core.GenerateKey(nil), core.GenerateKey(nil)
+	var parent *core.Block
+	aliceTxs := make([]*core.Transaction, 0)
+	// block height h has h txs of alice and one tx of bob
+	for h := 0; h < 5; h++ {
+		txs := make([]*core.Transaction, 0)
+		for i := 0; i < h; i++ {
+			tx := core.NewTransaction().SetNonce(int64(h*10 + i)).Sign(alice)
+			txs = append(txs, tx)
+			aliceTxs = append(aliceTxs, tx)
+		}
+		txs = append(txs, core.NewTransaction().SetNonce(int64(h)).Sign(bob))
+		hashes := make([][]byte, len(txs))
+		txcs := make([]*core.TxCommit, len(txs))
+		for i, tx := range txs {
+			hashes[i] = tx.Hash()
+			txcs[i] = core.NewTxCommit().SetHash(tx.Hash()).SetBlockHeight(uint64(h))
+		}
+		blk := core.NewBlock().SetHeight(uint64(h)).SetTransactions(hashes)
+		if parent != nil {
+			blk.SetParentHash(parent.Hash()).
+				SetQuorumCert(core.NewQuorumCert().Build([]*core.Vote{parent.ProposerVote()}))
+		}
+		blk.Sign(alice)
+		assert.NoError(strg.Commit(&CommitData{
+			Block:        blk,
+			QC:           core.NewQuorumCert(),
+			Transactions: txs,
+			TxCommits:    txcs,
+			BlockCommit:  core.NewBlockCommit().SetHash(blk.Hash()),
+		}))
+		parent = blk
+	}
+
+	hashesOf := func(txs []*core.Transaction) [][]byte {
+		ret := make([][]byte, len(txs))
+		for i, tx := range txs {
+			ret[i] = tx.Hash()
+		}
+		return ret
+	}
+
+	tests := []struct {
+		name        string
+		sender      []byte
+		afterHeight uint64
+		limit       int
+		want        []*core.Transaction
+	}{
+		{"all", alice.PublicKey().Address().Bytes(), 0, 100, aliceTxs},
+		{"limit 0", alice.PublicKey().Address().Bytes(), 0, 0, nil},
+		{"after height", alice.PublicKey().Address().Bytes(), 2, 100, aliceTxs[3:]},
+		{"block not split", alice.PublicKey().Address().Bytes(), 0, 2, aliceTxs[:3]},
+		{"limit at block end", alice.PublicKey().Address().Bytes(), 0, 3, aliceTxs[:3]},
+		{"after last block", alice.PublicKey().Address().Bytes(), 4, 100, nil},
+		{"no txs", core.GenerateKey(nil).PublicKey().Address().Bytes(), 0, 100, nil},
+		{"sender prefix", alice.PublicKey().Address().Bytes()[:4], 0, 100, nil},
+	}
+	for _, tt := range tests {
+		txs, err := strg.GetTxsBySender(tt.sender, tt.afterHeight, tt.limit)
+		assert.NoError(err, tt.name)
+		assert.Equal(hashesOf(tt.want), hashesOf(txs), tt.name)
+	}
+
+	txs, err := strg.GetTxsBySender(bob.PublicKey().Address().Bytes(), 0, 100)
+	assert.NoError(err)
+	assert.Len(txs, 4, "tx of genesis block is not after height 0")
+
+	reversed := func(txs []*core.Transaction) []*core.Transaction {
+		ret := make([]*core.Transaction, len(txs))
+		for i, tx := range txs {
+			ret[len(txs)-1-i] = tx
+		}
+		return ret
+	}
+	txs, err = strg.GetLatestTxsBySender(alice.PublicKey().Address().Bytes(), 100)
+	assert.NoError(err)
+	assert.Equal(hashesOf(reversed(aliceTxs)), hashesOf(txs))
+	txs, err = strg.GetLatestTxsBySender(alice.PublicKey().Address().Bytes(), 5)
+	assert.NoError(err)
+	assert.Equal(hashesOf(reversed(aliceTxs)[:5]), hashesOf(txs))
+	txs, err = strg.GetLatestTxsBySender(bob.PublicKey().Address().Bytes(), 100)
+	assert.NoError(err)
+	assert.Len(txs, 5)
+	txs, err = strg.GetLatestTxsBySender(alice.PublicKey().Address().Bytes()[:4], 100)
+	assert.NoError(err)
+	assert.Empty(txs, "sender prefix")
+
+	assert.NoError(strg.Prune(1))
+	txs, err = strg.GetTxsBySender(alice.PublicKey().Address().Bytes(), 0, 100)
+	assert.NoError(err)
+	assert.Equal(hashesOf(aliceTxs[3:]), hashesOf(txs), "pruned below height 3")
+
+	// txs commited with the index disabled
+	noIndex := newTestStorage()
+	noIndex.indexSender = false
+	sender := commitTestBlocks(t, noIndex, 2)[0].Sender().Address().Bytes()
+	_, err = noIndex.GetTxsBySender(sender, 0, 100)
+	assert.ErrorIs(err, ErrSenderIndexDisabled)
+	_, err = noIndex.GetLatestTxsBySender(sender, 100)
+	assert.ErrorIs(err, ErrSenderIndexDisabled)
+	noIndex.indexSender = true
+	txs, err = noIndex.GetLatestTxsBySender(sender, 100)
+	assert.NoError(err)
+	assert.Empty(txs, "not indexed")
+}
+
+// newBenchCommitData creates a block with txs and a block commit with stateChanges random state changes
+func newBenchCommitData(priv *core.PrivateKey, parent *core.Block, txCount, stateChanges int) *CommitData {
+	txs := make([]*core.Transaction, txCount)
+	hashes := make([][]byte, txCount)
+	for i := range txs {
+		txs[i] = core.NewTransaction().SetNonce(rand.Int63()).SetInput(make([]byte, 100)).Sign(priv)
+		hashes[i] = txs[i].Hash()
+	}
+	blk := core.NewBlock().SetTransactions(hashes)
+	if parent == nil {
+		blk.SetHeight(0)
+	} else {
+		blk.SetHeight(parent.Height() + 1).SetParentHash(parent.Hash()).
+			SetQuorumCert(core.NewQuorumCert().Build([]*core.Vote{parent.ProposerVote()}))
+	}
+	blk.Sign(priv)
+	txCommits := make([]*core.TxCommit, txCount)
+	for i, tx := range txs {
+		txCommits[i] = core.NewTxCommit().SetHash(tx.Hash()).
+			SetBlockHash(blk.Hash()).SetBlockHeight(blk.Height())
+	}
+	scList := make([]*core.StateChange, stateChanges)
+	for i := range scList {
+		key := make([]byte, 16)
+		rand.Read(key)
+		scList[i] = core.NewStateChange().SetKey(key).SetValue(key)
+	}
+	return &CommitData{
+		Block:        blk,
+		QC:           core.NewQuorumCert(),
+		Transactions: txs,
+		BlockCommit:  core.NewBlockCommit().SetHash(blk.Hash()).SetStateChanges(scList),
+		TxCommits:    txCommits,
+	}
+}
+
+func BenchmarkStorage_Commit(b *testing.B) {
+	strg := newTestStorage()
+	priv := core.GenerateKey(nil)
+	var parent *core.Block
+	data := make([]*CommitData, b.N)
+	for i := range data {
+		data[i] = newBenchCommitData(priv, parent, 2000, 10000)
+		parent = data[i].Block
+	}
+	b.ResetTimer()
+	for _, d := range data {
+		if err := strg.Commit(d); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestStorage_GetQC(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	db, err := NewDB(dir)
+	assert.NoError(err)
+	strg := New(db, DefaultConfig)
+
+	priv := core.GenerateKey(nil)
+	blocks := make([]*core.Block, 4)
+	var qc *core.QuorumCert
+	for i := range blocks {
+		blk := core.NewBlock().SetHeight(uint64(i))
+		if i > 0 {
+			blk.SetParentHash(blocks[i-1].Hash()).SetQuorumCert(qc)
+		}
+		blocks[i] = blk.Sign(priv)
+		qc = core.NewQuorumCert().Build([]*core.Vote{blocks[i].ProposerVote()})
+		assert.NoError(strg.Commit(&CommitData{
+			Block:       blocks[i],
+			QC:          qc,
+			BlockCommit: core.NewBlockCommit().SetHash(blocks[i].Hash()),
+		}))
+	}
+	assert.NoError(db.Close())
+
+	// restart
+	db, err = NewDB(dir)
+	assert.NoError(err)
+	defer db.Close()
+	strg = New(db, DefaultConfig)
+	for h := range blocks {
+		blk, err := strg.GetBlockByHeight(uint64(h))
+		assert.NoError(err)
+		qc, err := strg.GetQC(blk.Hash())
+		if assert.NoError(err) {
+			assert.Equal(blk.Hash(), qc.BlockHash())
+			assert.EqualValues(h, qc.BlockHeight())
+		}
+	}
+	_, err = strg.GetQC([]byte("unknown"))
+	assert.ErrorIs(err, badger.ErrKeyNotFound)
 }
@@ -4,6 +4,7 @@
 package cluster
 
 import (
+	"errors"
 	"time"
 
 	"github.com/aungmawjj/juria-blockchain/node"
@@ -23,9 +24,16 @@ type ClusterFactory interface {
 	SetupCluster(name string) (*Cluster, error)
 }
 
+// ErrStandbyNotSupported is returned by the clusters which cannot run standby nodes
+var ErrStandbyNotSupported = errors.New("standby node is not supported")
+
 type Cluster struct {
 	nodeConfig node.Config
 	nodes      []Node
+	standbys   []Node
+
+	// creates a standby node from the datadir of the node at the index, nil if not supported
+	newStandby func(idx int, leaseToken, adminToken string) (Node, error)
 }
 
 func (cls *Cluster) NodeConfig() node.Config {
@@ -45,6 +53,29 @@ func (cls *Cluster) Stop() {
 	for _, node := range cls.nodes {
 		node.Stop()
 	}
+	for _, node := range cls.standbys {
+		node.Stop()
+	}
+}
+
+// SetupStandby creates a standby node with a copy of the datadir of the node at idx,
+// the node must be stopped. The standby is not started.
+// It can be promoted with leaseToken through the admin api authorized with adminToken.
+func (cls *Cluster) SetupStandby(idx int, leaseToken, adminToken string) (Node, error) {
+	if cls.newStandby == nil {
+		return nil, ErrStandbyNotSupported
+	}
+	node, err := cls.newStandby(idx, leaseToken, adminToken)
+	if err != nil {
+		return nil, err
+	}
+	cls.standbys = append(cls.standbys, node)
+	return node, nil
+}
+
+// ReplaceNode replaces the node at idx, e.g. with its promoted standby
+func (cls *Cluster) ReplaceNode(idx int, node Node) {
+	cls.nodes[idx] = node
 }
 
 func (cls *Cluster) RemoveEffects() {
@@ -51,7 +51,8 @@ func (ftry *LocalFactory) setup() error {
 	}
 	keys := MakeRandomKeys(ftry.params.NodeCount)
 	peers := MakePeers(keys, addrs)
-	return SetupTemplateDir(ftry.templateDir, keys, peers)
+	return SetupTemplateDir(ftry.templateDir, keys, peers,
+		ftry.params.NodeConfig.ConsensusConfig.ChainID)
 }
 
 func (ftry *LocalFactory) makeAddrs() ([]multiaddr.Multiaddr, error) {
@@ -94,9 +95,39 @@ func (ftry *LocalFactory) SetupCluster(name string) (*Cluster, error) {
 	return &Cluster{
 		nodes:      nodes,
 		nodeConfig: ftry.params.NodeConfig,
+		newStandby: func(idx int, leaseToken, adminToken string) (Node, error) {
+			return ftry.setupStandby(clusterDir, idx, leaseToken, adminToken)
+		},
 	}, nil
 }
 
+// setupStandby creates a standby node with a copy of the datadir of the node at idx,
+// on the ports after the cluster nodes
+func (ftry *LocalFactory) setupStandby(
+	clusterDir string, idx int, leaseToken, adminToken string,
+) (Node, error) {
+	datadir := path.Join(clusterDir, fmt.Sprintf("%d-standby", idx))
+	if err := os.RemoveAll(datadir); err != nil {
+		return nil, err
+	}
+	err := exec.Command("cp", "-r",
+		path.Join(clusterDir, strconv.Itoa(idx)), datadir).Run()
+	if err != nil {
+		return nil, err
+	}
+	node := &LocalNode{
+		juriaPath: ftry.params.JuriaPath,
+		config:    ftry.params.NodeConfig,
+	}
+	node.config.Datadir = datadir
+	node.config.Port = node.config.Port + ftry.params.NodeCount + idx
+	node.config.APIPort = node.config.APIPort + ftry.params.NodeCount + idx
+	node.config.AdminToken = adminToken
+	node.config.ConsensusConfig.Standby = true
+	node.config.ConsensusConfig.StandbyLeaseToken = leaseToken
+	return node, nil
+}
+
 type LocalNode struct {
 	juriaPath string
 	config    node.Config
@@ -82,7 +82,8 @@ func (ftry *RemoteFactory) setup() error {
 	}
 	keys := MakeRandomKeys(ftry.params.NodeCount)
 	peers := MakePeers(keys, addrs)
-	if err := SetupTemplateDir(ftry.templateDir, keys, peers); err != nil {
+	if err := SetupTemplateDir(ftry.templateDir, keys, peers,
+		ftry.params.NodeConfig.ConsensusConfig.ChainID); err != nil {
 		return err
 	}
 	return ftry.sendTemplate()
@@ -53,6 +53,14 @@ func WriteGenesisFile(datadir string, genesis *node.Genesis) error {
 	return e.Encode(genesis)
 }
 
+func WriteGenesisBlockFile(datadir string, genesis *core.Genesis) error {
+	b, err := genesis.Marshal()
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path.Join(datadir, node.GenesisBlockFile), b, 0644)
+}
+
 func WritePeersFile(datadir string, peers []node.Peer) error {
 	f, err := os.Create(path.Join(datadir, node.PeersFile))
 	if err != nil {
@@ -84,7 +92,9 @@ func MakePeers(keys []*core.PrivateKey, addrs []multiaddr.Multiaddr) []node.Peer
 	return vlds
 }
 
-func SetupTemplateDir(dir string, keys []*core.PrivateKey, vlds []node.Peer) error {
+func SetupTemplateDir(
+	dir string, keys []*core.PrivateKey, vlds []node.Peer, chainID int64,
+) error {
 	if err := os.RemoveAll(dir); err != nil {
 		return err
 	}
@@ -97,6 +107,8 @@ func SetupTemplateDir(dir string, keys []*core.PrivateKey, vlds []node.Peer) err
 	for i, v := range keys {
 		genesis.Validators[i] = v.PublicKey().Bytes()
 	}
+	// shared genesis block pins chain id and validators for all nodes
+	genesisBlock := core.NewGenesis(node.ChainIDBytes(chainID), genesis.Validators, nil)
 	for i, key := range keys {
 		dir := path.Join(dir, strconv.Itoa(i))
 		os.Mkdir(dir, 0755)
@@ -106,6 +118,9 @@ func SetupTemplateDir(dir string, keys []*core.PrivateKey, vlds []node.Peer) err
 		if err := WriteGenesisFile(dir, genesis); err != nil {
 			return err
 		}
+		if err := WriteGenesisBlockFile(dir, genesisBlock); err != nil {
+			return err
+		}
 		if err := WritePeersFile(dir, vlds); err != nil {
 			return err
 		}
@@ -123,11 +138,27 @@ func AddJuriaFlags(cmd *exec.Cmd, config *node.Config) {
 	cmd.Args = append(cmd.Args, "-d", config.Datadir)
 	cmd.Args = append(cmd.Args, "-p", strconv.Itoa(config.Port))
 	cmd.Args = append(cmd.Args, "-P", strconv.Itoa(config.APIPort))
-	cmd.Args = append(cmd.Args, "--debug", strconv.FormatBool(config.Debug))
+	// bool flags need "=", a separate value would be parsed as a subcommand
+	cmd.Args = append(cmd.Args, "--debug="+strconv.FormatBool(config.Debug))
 
 	cmd.Args = append(cmd.Args, "--storage-merkleBranchFactor",
 		strconv.Itoa(int(config.StorageConfig.MerkleBranchFactor)))
 
+	cmd.Args = append(cmd.Args, "--storage-pruneInterval",
+		config.StorageConfig.PruneInterval.String())
+
+	cmd.Args = append(cmd.Args, "--storage-keepRecentBlocks",
+		strconv.FormatUint(config.StorageConfig.KeepRecentBlocks, 10))
+
+	cmd.Args = append(cmd.Args, "--storage-keepStateVersions",
+		strconv.FormatUint(config.StorageConfig.KeepStateVersions, 10))
+
+	cmd.Args = append(cmd.Args, "--storage-keepMerkleVersions",
+		strconv.FormatUint(config.StorageConfig.KeepMerkleVersions, 10))
+
+	cmd.Args = append(cmd.Args, "--storage-requireCodeAddr="+
+		strconv.FormatBool(config.StorageConfig.RequireCodeAddr))
+
 	cmd.Args = append(cmd.Args, "--execution-txExecTimeout",
 		config.ExecutionConfig.TxExecTimeout.String(),
 	)
@@ -140,6 +171,9 @@ func AddJuriaFlags(cmd *exec.Cmd, config *node.Config) {
 	cmd.Args = append(cmd.Args, "--consensus-blockTxLimit",
 		strconv.Itoa(config.ConsensusConfig.BlockTxLimit))
 
+	cmd.Args = append(cmd.Args, "--consensus-maxTxPerBlock",
+		strconv.Itoa(config.ConsensusConfig.MaxTxPerBlock))
+
 	cmd.Args = append(cmd.Args, "--consensus-txWaitTime",
 		config.ConsensusConfig.TxWaitTime.String())
 
@@ -154,4 +188,23 @@ func AddJuriaFlags(cmd *exec.Cmd, config *node.Config) {
 
 	cmd.Args = append(cmd.Args, "--consensus-leaderTimeout",
 		config.ConsensusConfig.LeaderTimeout.String())
+
+	cmd.Args = append(cmd.Args, "--domainSeparation="+
+		strconv.FormatBool(config.DomainSeparation.Enabled))
+
+	cmd.Args = append(cmd.Args, "--domainSeparationHeight",
+		strconv.FormatUint(config.DomainSeparation.Height, 10))
+
+	if config.AdminToken != "" {
+		cmd.Args = append(cmd.Args, "--adminToken", config.AdminToken)
+	}
+	if config.ConsensusConfig.Standby {
+		cmd.Args = append(cmd.Args, "--consensus-standby")
+
+		cmd.Args = append(cmd.Args, "--consensus-standbySilentViews",
+			strconv.Itoa(config.ConsensusConfig.StandbySilentViews))
+
+		cmd.Args = append(cmd.Args, "--consensus-standbyLeaseToken",
+			config.ConsensusConfig.StandbyLeaseToken)
+	}
 }
@@ -24,9 +24,39 @@ type Experiment interface {
 }
 
 type ExperimentRunner struct {
-	experiments []Experiment
-	cfactory    cluster.ClusterFactory
-	loadGen     *testutil.LoadGenerator
+	configs        []ExperimentConfig
+	makeFactory    func(cfg ExperimentConfig) (cluster.ClusterFactory, error)
+	makeLoadClient func(cfg LoadConfig) (testutil.LoadClient, error)
+
+	cfactory   cluster.ClusterFactory
+	factoryCfg ExperimentConfig
+	loadClient testutil.LoadClient
+	loadCfg    LoadConfig
+	loadGen    *testutil.LoadGenerator
+}
+
+// prepare rebuilds cluster factory and load client when they don't fit the config
+func (r *ExperimentRunner) prepare(cfg ExperimentConfig) error {
+	if r.cfactory == nil || r.factoryCfg.factoryChanged(cfg) {
+		r.cfactory = nil
+		cfactory, err := r.makeFactory(cfg)
+		if err != nil {
+			return fmt.Errorf("setup cluster factory failed, %w", err)
+		}
+		r.cfactory = cfactory
+		r.factoryCfg = cfg
+	}
+	if r.loadClient == nil || r.loadCfg.clientChanged(cfg.Load) {
+		r.loadClient = nil
+		client, err := r.makeLoadClient(cfg.Load)
+		if err != nil {
+			return fmt.Errorf("setup load client failed, %w", err)
+		}
+		r.loadClient = client
+	}
+	r.loadCfg = cfg.Load
+	r.loadGen = testutil.NewLoadGenerator(cfg.Load.TxPerSec, r.loadClient)
+	return nil
 }
 
 func (r *ExperimentRunner) Run() (pass, fail int) {
@@ -36,16 +66,20 @@ func (r *ExperimentRunner) Run() (pass, fail int) {
 	boldRed := color.New(color.Bold, color.FgRed)
 
 	fmt.Println("\nRunning Experiments")
-	for i, expm := range r.experiments {
-		bold.Printf("%3d. %s\n", i, expm.Name())
+	for i, cfg := range r.configs {
+		bold.Printf("%3d. %s\n", i, cfg.newExperiment().Name())
 	}
 
 	killed := make(chan os.Signal, 1)
 	signal.Notify(killed, os.Interrupt)
 
-	for i, expm := range r.experiments {
+	for i, cfg := range r.configs {
+		expm := cfg.newExperiment()
 		bold.Printf("\nExperiment %d. %s\n", i, expm.Name())
-		err := r.runSingleExperiment(expm)
+		err := r.prepare(cfg)
+		if err == nil {
+			err = r.runSingleExperiment(expm, cfg)
+		}
 		if err != nil {
 			fail++
 			fmt.Printf("%s %s\n", boldRed.Sprint("FAIL"), bold.Sprint(expm.Name()))
@@ -63,72 +97,109 @@ func (r *ExperimentRunner) Run() (pass, fail int) {
 	return pass, fail
 }
 
-func (r *ExperimentRunner) runSingleExperiment(expm Experiment) error {
-	var cls *cluster.Cluster
-	var err error
-	done := make(chan struct{})
-	loadCtx, stopLoad := context.WithCancel(context.Background())
+// runSingleExperiment sets up the cluster and runs the experiment until it's done,
+// timed out or interrupted. The cluster is stopped before it returns.
+func (r *ExperimentRunner) runSingleExperiment(expm Experiment, cfg ExperimentConfig) error {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeout)
+	defer cancel()
+
+	killed := make(chan os.Signal, 1)
+	signal.Notify(killed, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(killed)
 	go func() {
-		defer close(done)
-		fmt.Println("Setting up a new cluster")
-		cls, err = r.cfactory.SetupCluster(expm.Name())
-		if err != nil {
-			return
+		select {
+		case s := <-killed:
+			fmt.Println("\nGot signal:", s)
+			cancel()
+		case <-ctx.Done():
 		}
+	}()
 
-		fmt.Println("Starting cluster")
-		cls.Stop() // to make sure no existing process keeps running
-		err = cls.Start()
-		if err != nil {
-			return
-		}
-		fmt.Println("Started cluster")
-		testutil.Sleep(10 * time.Second)
+	fmt.Println("Setting up a new cluster")
+	cls, err := r.cfactory.SetupCluster(expm.Name())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		fmt.Println("Stopping cluster")
+		cls.Stop()
+		fmt.Println("Stopped cluster")
+	}()
 
-		fmt.Println("Setting up load generator")
-		err = r.loadGen.SetupOnCluster(cls)
-		if err != nil {
-			return
-		}
-		go r.loadGen.Run(loadCtx)
-		fmt.Println("Load generator running")
-		testutil.Sleep(10 * time.Second)
-
-		if err = health.CheckAllNodes(cls); err != nil {
-			fmt.Printf("health check failed before experiment, %+v\n", err)
-			cls.Stop()
-			os.Exit(1)
-			return
-		}
+	fmt.Println("Starting cluster")
+	cls.Stop() // to make sure no existing process keeps running
+	if err := cls.Start(); err != nil {
+		return err
+	}
+	fmt.Println("Started cluster")
+	if err := sleepContext(ctx, 10*time.Second); err != nil {
+		return experimentError(ctx, cfg)
+	}
 
-		fmt.Println("==> Running experiment")
-		err = expm.Run(cls)
-		if err != nil {
-			fmt.Println("==> Experiment failed")
-			return
-		}
-		fmt.Println("==> Finished experiment")
-		err = health.CheckAllNodes(cls)
-	}()
+	fmt.Println("Setting up load generator")
+	if err := r.loadGen.SetupOnCluster(cls); err != nil {
+		return err
+	}
+	loadCtx, stopLoad := context.WithCancel(ctx)
+	defer stopLoad()
+	go r.loadGen.Run(loadCtx)
+	fmt.Println("Load generator running")
+	if err := sleepContext(ctx, 10*time.Second); err != nil {
+		return experimentError(ctx, cfg)
+	}
 
-	killed := make(chan os.Signal, 1)
-	signal.Notify(killed, os.Interrupt, syscall.SIGTERM)
+	if err := health.CheckAllNodes(cls); err != nil {
+		fmt.Printf("health check failed before experiment, %+v\n", err)
+		cls.Stop()
+		os.Exit(1)
+	}
 
+	// the experiment can't be cancelled, it ends with an error once the cluster is stopped,
+	// and the result is buffered so that the goroutine doesn't block after timeout
+	done := make(chan error, 1)
+	go func() {
+		done <- runExperiment(expm, cls, cfg)
+	}()
 	select {
-	case s := <-killed:
-		fmt.Println("\nGot signal:", s)
-		err = errors.New("interrupted")
-		if cls != nil {
-			fmt.Println("Removing effects")
-			cls.RemoveEffects()
-		}
-	case <-done:
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		fmt.Println("Removing effects")
+		cls.RemoveEffects()
+		return experimentError(ctx, cfg)
 	}
-	stopLoad()
-	if cls != nil {
-		fmt.Println("Stopping cluster")
-		cls.Stop()
-		fmt.Println("Stopped cluster")
+}
+
+func runExperiment(expm Experiment, cls *cluster.Cluster, cfg ExperimentConfig) error {
+	fmt.Println("==> Running experiment")
+	if err := expm.Run(cls); err != nil {
+		fmt.Println("==> Experiment failed")
+		return err
+	}
+	fmt.Println("==> Finished experiment")
+	if *cfg.StrictSafety {
+		return health.CheckAllNodes(cls)
+	}
+	return health.CheckMajorityNodes(cls)
+}
+
+// experimentError returns the reason ctx is done
+func experimentError(ctx context.Context, cfg ExperimentConfig) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("experiment timeout after %s", cfg.Timeout)
+	}
+	return errors.New("interrupted")
+}
+
+// sleepContext sleeps for d unless ctx is done first
+func sleepContext(ctx context.Context, d time.Duration) error {
+	fmt.Printf("Wait for %s\n", d)
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
-	return err
 }
@@ -26,10 +26,14 @@ func (expm *CorrectExecution) Run(cls *cluster.Cluster) error {
 	acc1 := core.GenerateKey(nil)
 	acc2 := core.GenerateKey(nil)
 
-	i, err := testutil.SubmitTxAndWait(cls, jc.MakeMintTx(acc1.PublicKey(), 100))
+	mintTx := jc.MakeMintTx(acc1.PublicKey(), 100)
+	i, err := testutil.SubmitTxAndWait(cls, mintTx)
 	if err != nil {
 		return fmt.Errorf("submit mint tx failed. %w", err)
 	}
+	if err := testutil.VerifyTxProofAll(cls, mintTx); err != nil {
+		return fmt.Errorf("verify tx proof failed. %w", err)
+	}
 	b1, err := jc.QueryBalance(cls.GetNode(i), acc1.PublicKey())
 	if err != nil {
 		return fmt.Errorf("query balance failed %w", err)
@@ -0,0 +1,87 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package experiments
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aungmawjj/juria-blockchain/tests/cluster"
+	"github.com/aungmawjj/juria-blockchain/tests/testutil"
+)
+
+// tokens of the standby node started by the experiment
+const (
+	standbyLeaseToken = "standby-takeover-lease"
+	standbyAdminToken = "standby-takeover-admin"
+)
+
+type StandbyTakeover struct{}
+
+func (expm *StandbyTakeover) Name() string {
+	return "standby_takeover"
+}
+
+// Stop a validator (primary) and start a standby node with a copy of its datadir,
+// holding the same key and consensus wal. The standby must not be promoted until
+// the primary is silent for the standby silent views.
+// The blockchain should keep commiting blocks with the standby in place of the primary,
+// and no node should see two blocks signed at the same height.
+func (expm *StandbyTakeover) Run(cls *cluster.Cluster) error {
+	primary := testutil.PickUniqueRandoms(cls.NodeCount(), 1)[0]
+	cls.GetNode(primary).Stop()
+	fmt.Printf("Stopped primary node %d\n", primary)
+	testutil.Sleep(5 * time.Second) // wait for the process to exit
+
+	standby, err := cls.SetupStandby(primary, standbyLeaseToken, standbyAdminToken)
+	if err != nil {
+		return err
+	}
+	if err := standby.Start(); err != nil {
+		return err
+	}
+	fmt.Printf("Started standby of node %d\n", primary)
+	testutil.Sleep(10 * time.Second)
+
+	if err := testutil.PromoteStandby(standby, standbyAdminToken, standbyLeaseToken); err == nil {
+		return errors.New("promoted standby before primary is silent")
+	}
+	if err := expm.promote(cls, standby); err != nil {
+		return err
+	}
+	cls.ReplaceNode(primary, standby)
+	fmt.Printf("Promoted standby in place of node %d\n", primary)
+
+	before := testutil.GetStatusAll(cls)
+	testutil.Sleep(30 * time.Second)
+	after := testutil.GetStatusAll(cls)
+	for i, status := range after {
+		if status.Equivocations > 0 {
+			return fmt.Errorf("node %d saw %d equivocations", i, status.Equivocations)
+		}
+		if prev, ok := before[i]; ok && status.BExec <= prev.BExec {
+			return fmt.Errorf("node %d stopped commiting at height %d", i, status.BExec)
+		}
+	}
+	return nil
+}
+
+// promote retries until the primary has been silent for the standby silent views
+func (expm *StandbyTakeover) promote(cls *cluster.Cluster, standby cluster.Node) error {
+	config := cls.NodeConfig().ConsensusConfig
+	view := config.ViewWidth + config.LeaderTimeout
+	deadline := time.Now().Add(time.Duration(config.StandbySilentViews+2) * view)
+	for {
+		err := testutil.PromoteStandby(standby, standbyAdminToken, standbyLeaseToken)
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("cannot promote standby, %w", err)
+		}
+		fmt.Printf("Standby not promoted yet, %v\n", err)
+		time.Sleep(5 * time.Second)
+	}
+}
@@ -4,6 +4,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
@@ -13,15 +14,32 @@ import (
 	"strings"
 	"time"
 
+	"github.com/aungmawjj/juria-blockchain/core"
 	"github.com/aungmawjj/juria-blockchain/node"
 	"github.com/aungmawjj/juria-blockchain/tests/cluster"
-	"github.com/aungmawjj/juria-blockchain/tests/experiments"
 	"github.com/aungmawjj/juria-blockchain/tests/testutil"
 )
 
 var (
 	WorkDir   = "./workdir"
 	NodeCount = 4
+	NodeDebug = true
+
+	// yaml file with per-experiment overrides, see ExperimentSuite
+	SuiteFile = ""
+
+	ExperimentTimeout = 10 * time.Minute
+
+	// all nodes must be healthy after experiment, otherwise only majority nodes
+	StrictSafety = true
+
+	// nodes prune bodies of older blocks if not zero, health checks only need recent blocks
+	KeepRecentBlocks uint64 = 0
+	PruneInterval           = 10 * time.Second
+
+	// domain tags in block, tx and vote digests of the cluster,
+	// load clients sign txs with the same digest as the nodes
+	DomainSeparation = core.DomainSeparation{Enabled: true}
 
 	LoadTxPerSec     = 100
 	LoadMintAccounts = 100
@@ -46,39 +64,52 @@ var (
 	BenchLoads        = []int{1000, 2000, 3000, 4000, 4500, 5000, 5500, 6000, 7000}
 )
 
-func getNodeConfig() node.Config {
+func getNodeConfig(debug bool) node.Config {
 	config := node.DefaultConfig
-	config.Debug = true
+	config.Debug = debug
+	config.DomainSeparation = DomainSeparation
+	if KeepRecentBlocks > 0 {
+		config.StorageConfig.KeepRecentBlocks = KeepRecentBlocks
+		config.StorageConfig.PruneInterval = PruneInterval
+	}
 	return config
 }
 
-func setupExperiments() []Experiment {
-	expms := make([]Experiment, 0)
-	if RemoteLinuxCluster {
-		expms = append(expms, &experiments.NetworkDelay{
-			Delay: 100 * time.Millisecond,
-		})
-		expms = append(expms, &experiments.NetworkPacketLoss{
-			Percent: 10,
-		})
+func parseFlags() {
+	flag.StringVar(&SuiteFile, "suite", SuiteFile, "experiment suite yaml file")
+	flag.IntVar(&NodeCount, "nodes", NodeCount, "default node count")
+	flag.BoolVar(&NodeDebug, "debug", NodeDebug, "run nodes in debug mode")
+	flag.IntVar(&LoadTxPerSec, "tps", LoadTxPerSec, "default load tx per sec")
+	flag.DurationVar(&ExperimentTimeout, "timeout", ExperimentTimeout, "default experiment timeout")
+	flag.BoolVar(&StrictSafety, "strict", StrictSafety, "all nodes must be healthy after experiment")
+	flag.Uint64Var(&KeepRecentBlocks, "keepRecent", KeepRecentBlocks, "recent blocks kept by pruning nodes")
+	flag.BoolVar(&RemoteLinuxCluster, "remote", RemoteLinuxCluster, "run on remote linux cluster")
+	flag.BoolVar(&RunBenchmark, "benchmark", RunBenchmark, "run benchmark instead of experiments")
+	flag.Parse()
+}
+
+func setupExperimentSuite() *ExperimentSuite {
+	if SuiteFile == "" {
+		return DefaultExperimentSuite()
 	}
-	expms = append(expms, &experiments.MajorityKeepRunning{})
-	expms = append(expms, &experiments.CorrectExecution{})
-	expms = append(expms, &experiments.RestartCluster{})
-	return expms
+	suite, err := ReadExperimentSuite(SuiteFile)
+	check(err)
+	return suite
 }
 
 func main() {
+	parseFlags()
+	core.SetDomainSeparation(DomainSeparation)
+	suite := setupExperimentSuite()
+	RemoteLinuxCluster = suite.Remote
 	printVars()
 	os.Mkdir(WorkDir, 0755)
 	buildJuria()
 	setupTransport()
 	if RunBenchmark {
-		runBenchmark()
+		runBenchmark(suite.Defaults)
 	} else {
-		runExperiments(testutil.NewLoadGenerator(
-			LoadTxPerSec, makeLoadClient(),
-		))
+		runExperiments(suite)
 	}
 }
 
@@ -89,37 +120,36 @@ func setupTransport() {
 	transport.MaxIdleConnsPerHost = 100
 }
 
-func runBenchmark() {
+func runBenchmark(cfg ExperimentConfig) {
 	if !RemoteLinuxCluster {
 		fmt.Println("mush run benchmark on remote cluster")
 		os.Exit(1)
 		return
 	}
 	bm := &Benchmark{
-		workDir:    path.Join(WorkDir, "benchmarks"),
-		duration:   BenchmarkDuration,
-		interval:   5 * time.Second,
-		cfactory:   makeRemoteClusterFactory(),
-		loadClient: makeLoadClient(),
+		workDir:  path.Join(WorkDir, "benchmarks"),
+		duration: BenchmarkDuration,
+		interval: 5 * time.Second,
 	}
+	var err error
+	bm.cfactory, err = makeRemoteClusterFactory(cfg)
+	check(err)
+	bm.loadClient, err = makeLoadClient(cfg.Load)
+	check(err)
 	bm.Run()
 }
 
-func runExperiments(loadGen *testutil.LoadGenerator) {
-	var cfactory cluster.ClusterFactory
-	if RemoteLinuxCluster {
-		cfactory = makeRemoteClusterFactory()
-	} else {
-		cfactory = makeLocalClusterFactory()
-	}
+func runExperiments(suite *ExperimentSuite) {
+	configs, err := suite.Configs()
+	check(err)
 
 	r := &ExperimentRunner{
-		experiments: setupExperiments(),
-		cfactory:    cfactory,
-		loadGen:     loadGen,
+		configs:        configs,
+		makeFactory:    makeClusterFactory,
+		makeLoadClient: makeLoadClient,
 	}
 	pass, fail := r.Run()
-	fmt.Printf("\nTotal: %d  |  Pass: %d  |  Fail: %d\n", len(r.experiments), pass, fail)
+	fmt.Printf("\nTotal: %d  |  Pass: %d  |  Fail: %d\n", len(r.configs), pass, fail)
 }
 
 func printVars() {
@@ -128,6 +158,7 @@ func printVars() {
 	fmt.Println("LoadTxPerSec=", LoadTxPerSec)
 	fmt.Println("RemoteCluster =", RemoteLinuxCluster)
 	fmt.Println("RunBenchmark=", RunBenchmark)
+	fmt.Println("KeepRecentBlocks =", KeepRecentBlocks)
 	fmt.Println()
 }
 
@@ -142,14 +173,25 @@ func buildJuria() {
 	check(cmd.Run())
 }
 
-func makeLoadClient() testutil.LoadClient {
+func makeLoadClient(cfg LoadConfig) (testutil.LoadClient, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
 	var binccPath string
-	if JuriaCoinBinCC {
+	if cfg.Client == LoadClientJuriaCoinBinCC {
 		buildJuriaCoinBinCC()
 		binccPath = "./juriacoin"
 	}
 	fmt.Println("Preparing load client")
-	return testutil.NewJuriaCoinClient(LoadMintAccounts, LoadDestAccounts, binccPath)
+	seed, err := cfg.seed()
+	if err != nil {
+		return nil, err
+	}
+	if seed != nil {
+		return testutil.NewJuriaCoinClientFromSeed(
+			cfg.MintAccounts, cfg.DestAccounts, binccPath, seed)
+	}
+	return testutil.NewJuriaCoinClient(cfg.MintAccounts, cfg.DestAccounts, binccPath), nil
 }
 
 func buildJuriaCoinBinCC() {
@@ -165,23 +207,29 @@ func buildJuriaCoinBinCC() {
 	check(cmd.Run())
 }
 
-func makeLocalClusterFactory() *cluster.LocalFactory {
-	ftry, err := cluster.NewLocalFactory(cluster.LocalFactoryParams{
+func makeClusterFactory(cfg ExperimentConfig) (cluster.ClusterFactory, error) {
+	fmt.Printf("Preparing cluster factory for %d nodes\n", cfg.NodeCount)
+	if RemoteLinuxCluster {
+		return makeRemoteClusterFactory(cfg)
+	}
+	return makeLocalClusterFactory(cfg)
+}
+
+func makeLocalClusterFactory(cfg ExperimentConfig) (*cluster.LocalFactory, error) {
+	return cluster.NewLocalFactory(cluster.LocalFactoryParams{
 		JuriaPath:  "./juria",
 		WorkDir:    path.Join(WorkDir, "local-clusters"),
-		NodeCount:  NodeCount,
-		NodeConfig: getNodeConfig(),
+		NodeCount:  cfg.NodeCount,
+		NodeConfig: getNodeConfig(*cfg.Debug),
 	})
-	check(err)
-	return ftry
 }
 
-func makeRemoteClusterFactory() *cluster.RemoteFactory {
-	ftry, err := cluster.NewRemoteFactory(cluster.RemoteFactoryParams{
+func makeRemoteClusterFactory(cfg ExperimentConfig) (*cluster.RemoteFactory, error) {
+	return cluster.NewRemoteFactory(cluster.RemoteFactoryParams{
 		JuriaPath:     "./juria",
 		WorkDir:       path.Join(WorkDir, "remote-clusters"),
-		NodeCount:     NodeCount,
-		NodeConfig:    getNodeConfig(),
+		NodeCount:     cfg.NodeCount,
+		NodeConfig:    getNodeConfig(*cfg.Debug),
 		LoginName:     RemoteLoginName,
 		KeySSH:        RemoteKeySSH,
 		HostsPath:     RemoteHostsPath,
@@ -189,8 +237,6 @@ func makeRemoteClusterFactory() *cluster.RemoteFactory {
 		SetupRequired: RemoteSetupRequired,
 		NetworkDevice: RemoteNetworkDevice,
 	})
-	check(err)
-	return ftry
 }
 
 func check(err error) {
@@ -0,0 +1,280 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/aungmawjj/juria-blockchain/core"
+	"github.com/aungmawjj/juria-blockchain/tests/experiments"
+	"github.com/aungmawjj/juria-blockchain/tests/testutil"
+	"gopkg.in/yaml.v3"
+)
+
+// MinNodeCount is the smallest cluster that can tolerate one faulty node (3f+1)
+const MinNodeCount = 4
+
+// load client types
+const (
+	LoadClientJuriaCoin      = "juriacoin"
+	LoadClientJuriaCoinBinCC = "juriacoin_bincc"
+)
+
+// experiment names used in suite file
+const (
+	ExpmNetworkDelay        = "network_delay"
+	ExpmNetworkPacketLoss   = "network_packet_loss"
+	ExpmMajorityKeepRunning = "majority_keep_running"
+	ExpmCorrectExecution    = "correct_execution"
+	ExpmRestartCluster      = "restart_cluster"
+	ExpmStandbyTakeover     = "standby_takeover"
+)
+
+// errors
+var (
+	ErrUnknownExperiment = errors.New("unknown experiment")
+	ErrUnknownLoadClient = errors.New("unknown load client")
+	ErrRemoteOnly        = errors.New("experiment requires remote cluster")
+	ErrLocalOnly         = errors.New("experiment requires local cluster")
+	ErrNodeCount         = errors.New("node count below quorum needs")
+	ErrLoadRate          = errors.New("invalid load rate")
+)
+
+// LoadConfig specifies the load client and its parameters
+type LoadConfig struct {
+	Client       string `yaml:"client"`
+	TxPerSec     int    `yaml:"txPerSec"`
+	MintAccounts int    `yaml:"mintAccounts"`
+	DestAccounts int    `yaml:"destAccounts"`
+
+	// hex encoded seed to derive load accounts, accounts are random if empty
+	Seed string `yaml:"seed"`
+}
+
+// ExperimentConfig specifies how to run a single experiment.
+// Zero valued fields are filled from the suite defaults.
+type ExperimentConfig struct {
+	Name         string        `yaml:"name"`
+	NodeCount    int           `yaml:"nodeCount"`
+	Debug        *bool         `yaml:"debug"`
+	Timeout      time.Duration `yaml:"timeout"`
+	StrictSafety *bool         `yaml:"strictSafety"` // all nodes must be healthy after experiment
+	Load         LoadConfig    `yaml:"load"`
+
+	Delay   time.Duration `yaml:"delay"`   // for network_delay
+	Percent float32       `yaml:"percent"` // for network_packet_loss
+}
+
+// ExperimentSuite is the list of experiments to run with per-experiment overrides
+type ExperimentSuite struct {
+	Remote      bool               `yaml:"remote"`
+	Defaults    ExperimentConfig   `yaml:"defaults"`
+	Experiments []ExperimentConfig `yaml:"experiments"`
+}
+
+// DefaultExperimentSuite creates the suite from package level variables
+func DefaultExperimentSuite() *ExperimentSuite {
+	suite := &ExperimentSuite{
+		Remote: RemoteLinuxCluster,
+		Defaults: ExperimentConfig{
+			NodeCount:    NodeCount,
+			Debug:        boolPtr(NodeDebug),
+			Timeout:      ExperimentTimeout,
+			StrictSafety: boolPtr(StrictSafety),
+			Load: LoadConfig{
+				Client:       LoadClientJuriaCoin,
+				TxPerSec:     LoadTxPerSec,
+				MintAccounts: LoadMintAccounts,
+				DestAccounts: LoadDestAccounts,
+			},
+			Delay:   100 * time.Millisecond,
+			Percent: 10,
+		},
+	}
+	if JuriaCoinBinCC {
+		suite.Defaults.Load.Client = LoadClientJuriaCoinBinCC
+	}
+	if suite.Remote {
+		suite.Experiments = append(suite.Experiments,
+			ExperimentConfig{Name: ExpmNetworkDelay},
+			ExperimentConfig{Name: ExpmNetworkPacketLoss},
+		)
+	}
+	suite.Experiments = append(suite.Experiments,
+		ExperimentConfig{Name: ExpmMajorityKeepRunning},
+		ExperimentConfig{Name: ExpmCorrectExecution},
+		ExperimentConfig{Name: ExpmRestartCluster},
+	)
+	if !suite.Remote {
+		suite.Experiments = append(suite.Experiments,
+			ExperimentConfig{Name: ExpmStandbyTakeover},
+		)
+	}
+	return suite
+}
+
+// ReadExperimentSuite reads yaml suite file on top of the default suite
+func ReadExperimentSuite(file string) (*ExperimentSuite, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return ParseExperimentSuite(b)
+}
+
+// ParseExperimentSuite parses yaml suite on top of the default suite
+func ParseExperimentSuite(b []byte) (*ExperimentSuite, error) {
+	suite := DefaultExperimentSuite()
+	if err := yaml.Unmarshal(b, suite); err != nil {
+		return nil, fmt.Errorf("parse experiment suite failed, %w", err)
+	}
+	return suite, nil
+}
+
+// Configs returns validated experiment configs with defaults applied
+func (suite *ExperimentSuite) Configs() ([]ExperimentConfig, error) {
+	configs := make([]ExperimentConfig, len(suite.Experiments))
+	for i, cfg := range suite.Experiments {
+		cfg = cfg.withDefaults(suite.Defaults)
+		if err := cfg.Validate(suite.Remote); err != nil {
+			return nil, fmt.Errorf("experiment %d. %s, %w", i, cfg.Name, err)
+		}
+		configs[i] = cfg
+	}
+	return configs, nil
+}
+
+func (cfg ExperimentConfig) withDefaults(def ExperimentConfig) ExperimentConfig {
+	if cfg.NodeCount == 0 {
+		cfg.NodeCount = def.NodeCount
+	}
+	if cfg.Debug == nil {
+		cfg.Debug = def.Debug
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = def.Timeout
+	}
+	if cfg.StrictSafety == nil {
+		cfg.StrictSafety = def.StrictSafety
+	}
+	if cfg.Load.Client == "" {
+		cfg.Load.Client = def.Load.Client
+	}
+	if cfg.Load.TxPerSec == 0 {
+		cfg.Load.TxPerSec = def.Load.TxPerSec
+	}
+	if cfg.Load.MintAccounts == 0 {
+		cfg.Load.MintAccounts = def.Load.MintAccounts
+	}
+	if cfg.Load.DestAccounts == 0 {
+		cfg.Load.DestAccounts = def.Load.DestAccounts
+	}
+	if cfg.Load.Seed == "" {
+		cfg.Load.Seed = def.Load.Seed
+	}
+	if cfg.Delay == 0 {
+		cfg.Delay = def.Delay
+	}
+	if cfg.Percent == 0 {
+		cfg.Percent = def.Percent
+	}
+	return cfg
+}
+
+// Validate checks impossible combinations of the config
+func (cfg ExperimentConfig) Validate(remote bool) error {
+	switch cfg.Name {
+	case ExpmNetworkDelay, ExpmNetworkPacketLoss:
+		if !remote {
+			return ErrRemoteOnly
+		}
+	case ExpmStandbyTakeover:
+		if remote {
+			return ErrLocalOnly
+		}
+	case ExpmMajorityKeepRunning, ExpmCorrectExecution, ExpmRestartCluster:
+	default:
+		return fmt.Errorf("%w %q", ErrUnknownExperiment, cfg.Name)
+	}
+	if cfg.NodeCount < MinNodeCount {
+		return fmt.Errorf("%w, %d < %d", ErrNodeCount, cfg.NodeCount, MinNodeCount)
+	}
+	if cfg.Timeout <= 0 {
+		return fmt.Errorf("invalid timeout %s", cfg.Timeout)
+	}
+	return cfg.Load.Validate()
+}
+
+// Validate checks the load parameters
+func (cfg LoadConfig) Validate() error {
+	switch cfg.Client {
+	case LoadClientJuriaCoin, LoadClientJuriaCoinBinCC:
+	default:
+		return fmt.Errorf("%w %q", ErrUnknownLoadClient, cfg.Client)
+	}
+	if cfg.TxPerSec < testutil.MinTxPerSec {
+		return fmt.Errorf("%w, tx per sec %d < %d",
+			ErrLoadRate, cfg.TxPerSec, testutil.MinTxPerSec)
+	}
+	if cfg.MintAccounts <= 0 || cfg.DestAccounts <= 0 {
+		return fmt.Errorf("invalid accounts, mint: %d, dest: %d",
+			cfg.MintAccounts, cfg.DestAccounts)
+	}
+	if _, err := cfg.seed(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (cfg LoadConfig) seed() ([]byte, error) {
+	if cfg.Seed == "" {
+		return nil, nil
+	}
+	seed, err := hex.DecodeString(cfg.Seed)
+	if err != nil {
+		return nil, fmt.Errorf("invalid load seed, %w", err)
+	}
+	if _, err := core.NewMasterKey(seed); err != nil {
+		return nil, fmt.Errorf("invalid load seed, %w", err)
+	}
+	return seed, nil
+}
+
+// clientChanged reports whether load client built with cfg can't be reused for x
+func (cfg LoadConfig) clientChanged(x LoadConfig) bool {
+	return cfg.Client != x.Client ||
+		cfg.MintAccounts != x.MintAccounts || cfg.DestAccounts != x.DestAccounts ||
+		cfg.Seed != x.Seed
+}
+
+func (cfg ExperimentConfig) newExperiment() Experiment {
+	switch cfg.Name {
+	case ExpmNetworkDelay:
+		return &experiments.NetworkDelay{Delay: cfg.Delay}
+	case ExpmNetworkPacketLoss:
+		return &experiments.NetworkPacketLoss{Percent: cfg.Percent}
+	case ExpmMajorityKeepRunning:
+		return &experiments.MajorityKeepRunning{}
+	case ExpmCorrectExecution:
+		return &experiments.CorrectExecution{}
+	case ExpmRestartCluster:
+		return &experiments.RestartCluster{}
+	case ExpmStandbyTakeover:
+		return &experiments.StandbyTakeover{}
+	}
+	return nil
+}
+
+// factoryChanged reports whether cluster factory built with cfg can't be reused for x
+func (cfg ExperimentConfig) factoryChanged(x ExperimentConfig) bool {
+	return cfg.NodeCount != x.NodeCount || *cfg.Debug != *x.Debug
+}
+
+func boolPtr(v bool) *bool {
+	return &v
+}
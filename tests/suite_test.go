@@ -0,0 +1,198 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aungmawjj/juria-blockchain/core"
+	"github.com/aungmawjj/juria-blockchain/tests/cluster"
+	"github.com/aungmawjj/juria-blockchain/tests/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseExperimentSuite(t *testing.T) {
+	assert := assert.New(t)
+
+	suite, err := ParseExperimentSuite([]byte(`
+remote: true
+defaults:
+  nodeCount: 7
+  debug: false
+  timeout: 5m
+  load:
+    txPerSec: 200
+experiments:
+  - name: network_delay
+    delay: 200ms
+  - name: majority_keep_running
+    nodeCount: 10
+    strictSafety: false
+    load:
+      client: juriacoin_bincc
+      mintAccounts: 5
+`))
+	assert.NoError(err)
+	assert.True(suite.Remote)
+
+	configs, err := suite.Configs()
+	assert.NoError(err)
+	assert.Equal(2, len(configs))
+
+	assert.Equal(ExpmNetworkDelay, configs[0].Name)
+	assert.Equal(7, configs[0].NodeCount)
+	assert.False(*configs[0].Debug)
+	assert.Equal(5*time.Minute, configs[0].Timeout)
+	assert.Equal(StrictSafety, *configs[0].StrictSafety)
+	assert.Equal(200*time.Millisecond, configs[0].Delay)
+	assert.Equal(LoadConfig{
+		Client:       LoadClientJuriaCoin,
+		TxPerSec:     200,
+		MintAccounts: LoadMintAccounts,
+		DestAccounts: LoadDestAccounts,
+	}, configs[0].Load)
+	assert.Equal("network_delay_200ms", configs[0].newExperiment().Name())
+
+	assert.Equal(ExpmMajorityKeepRunning, configs[1].Name)
+	assert.Equal(10, configs[1].NodeCount)
+	assert.False(*configs[1].StrictSafety)
+	assert.Equal(LoadConfig{
+		Client:       LoadClientJuriaCoinBinCC,
+		TxPerSec:     200,
+		MintAccounts: 5,
+		DestAccounts: LoadDestAccounts,
+	}, configs[1].Load)
+
+	_, err = ParseExperimentSuite([]byte("defaults: [1, 2]"))
+	assert.Error(err)
+}
+
+func TestDefaultExperimentSuite(t *testing.T) {
+	assert := assert.New(t)
+
+	suite, err := ParseExperimentSuite([]byte(""))
+	assert.NoError(err)
+	assert.Equal(DefaultExperimentSuite(), suite)
+
+	configs, err := suite.Configs()
+	assert.NoError(err)
+	assert.Equal(len(suite.Experiments), len(configs))
+	for _, cfg := range configs {
+		assert.Equal(NodeCount, cfg.NodeCount)
+		assert.Equal(NodeDebug, *cfg.Debug)
+		assert.Equal(ExperimentTimeout, cfg.Timeout)
+		assert.Equal(LoadTxPerSec, cfg.Load.TxPerSec)
+		assert.NotNil(cfg.newExperiment())
+	}
+}
+
+func TestExperimentConfig_Validate(t *testing.T) {
+	valid := DefaultExperimentSuite().Defaults
+	valid.Name = ExpmCorrectExecution
+
+	tests := []struct {
+		name   string
+		modify func(cfg *ExperimentConfig)
+		remote bool
+		err    error
+	}{
+		{"valid", func(cfg *ExperimentConfig) {}, false, nil},
+		{"unknown experiment", func(cfg *ExperimentConfig) { cfg.Name = "unknown" }, false, ErrUnknownExperiment},
+		{"remote only", func(cfg *ExperimentConfig) { cfg.Name = ExpmNetworkDelay }, false, ErrRemoteOnly},
+		{"remote", func(cfg *ExperimentConfig) { cfg.Name = ExpmNetworkPacketLoss }, true, nil},
+		{"local only", func(cfg *ExperimentConfig) { cfg.Name = ExpmStandbyTakeover }, true, ErrLocalOnly},
+		{"local", func(cfg *ExperimentConfig) { cfg.Name = ExpmStandbyTakeover }, false, nil},
+		{"below quorum", func(cfg *ExperimentConfig) { cfg.NodeCount = MinNodeCount - 1 }, false, ErrNodeCount},
+		{"zero rate", func(cfg *ExperimentConfig) { cfg.Load.TxPerSec = 0 }, false, ErrLoadRate},
+		{"low rate", func(cfg *ExperimentConfig) { cfg.Load.TxPerSec = testutil.MinTxPerSec - 1 }, false, ErrLoadRate},
+		{"unknown client", func(cfg *ExperimentConfig) { cfg.Load.Client = "unknown" }, false, ErrUnknownLoadClient},
+		{"seed", func(cfg *ExperimentConfig) { cfg.Load.Seed = "000102030405060708090a0b0c0d0e0f" }, false, nil},
+		{"short seed", func(cfg *ExperimentConfig) { cfg.Load.Seed = "0001" }, false, core.ErrInvalidSeedSize},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := valid
+			tt.modify(&cfg)
+			err := cfg.Validate(tt.remote)
+			if tt.err == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestExperimentSuite_ConfigsInvalid(t *testing.T) {
+	suite, err := ParseExperimentSuite([]byte(`
+experiments:
+  - name: correct_execution
+  - name: restart_cluster
+    nodeCount: 3
+`))
+	assert.NoError(t, err)
+	_, err = suite.Configs()
+	assert.ErrorIs(t, err, ErrNodeCount)
+}
+
+type fakeFactory struct {
+	nodeCount int
+}
+
+func (f *fakeFactory) SetupCluster(name string) (*cluster.Cluster, error) {
+	return nil, nil
+}
+
+func TestExperimentRunner_Prepare(t *testing.T) {
+	assert := assert.New(t)
+
+	suite, err := ParseExperimentSuite([]byte(`
+experiments:
+  - name: correct_execution
+  - name: restart_cluster
+    nodeCount: 7
+    load:
+      txPerSec: 40
+  - name: majority_keep_running
+    nodeCount: 7
+`))
+	assert.NoError(err)
+	configs, err := suite.Configs()
+	assert.NoError(err)
+
+	var factories []*fakeFactory
+	var clientCount int
+	r := &ExperimentRunner{
+		configs: configs,
+		makeFactory: func(cfg ExperimentConfig) (cluster.ClusterFactory, error) {
+			f := &fakeFactory{nodeCount: cfg.NodeCount}
+			factories = append(factories, f)
+			return f, nil
+		},
+		makeLoadClient: func(cfg LoadConfig) (testutil.LoadClient, error) {
+			clientCount++
+			return &testutil.JuriaCoinClient{}, nil
+		},
+	}
+
+	assert.NoError(r.prepare(configs[0]))
+	assert.Equal(1, len(factories))
+	assert.Equal(NodeCount, factories[0].nodeCount)
+	assert.Equal(factories[0], r.cfactory)
+
+	// different node count, factory must be rebuilt
+	assert.NoError(r.prepare(configs[1]))
+	assert.Equal(2, len(factories))
+	assert.Equal(7, factories[1].nodeCount)
+	assert.Equal(factories[1], r.cfactory)
+
+	// same node count, factory is reused
+	assert.NoError(r.prepare(configs[2]))
+	assert.Equal(2, len(factories))
+	assert.Equal(factories[1], r.cfactory)
+
+	// only load rate changed, load client is reused
+	assert.Equal(1, clientCount)
+}
@@ -4,6 +4,7 @@
 package testutil
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -13,6 +14,8 @@ import (
 
 	"github.com/aungmawjj/juria-blockchain/consensus"
 	"github.com/aungmawjj/juria-blockchain/core"
+	"github.com/aungmawjj/juria-blockchain/node"
+	"github.com/aungmawjj/juria-blockchain/storage"
 	"github.com/aungmawjj/juria-blockchain/tests/cluster"
 	"github.com/aungmawjj/juria-blockchain/txpool"
 )
@@ -97,6 +100,30 @@ func GetStatusAll(cls *cluster.Cluster) map[int]*consensus.Status {
 	return resps
 }
 
+// PromoteStandby promotes the standby node with the leadership lease token
+func PromoteStandby(standby cluster.Node, adminToken, leaseToken string) error {
+	if !standby.IsRunning() {
+		return fmt.Errorf("node is not running")
+	}
+	b, err := json.Marshal(&node.PromoteRequest{Token: leaseToken})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost,
+		standby.GetEndpoint()+"/admin/promote", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+adminToken)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err := checkResponse(resp, err); err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
 func GetTxPoolStatusAll(cls *cluster.Cluster) map[int]*txpool.Status {
 	resps := make(map[int]*txpool.Status)
 	var mtx sync.Mutex
@@ -152,3 +179,156 @@ func GetBlockByHeightAll(cls *cluster.Cluster, height uint64) map[int]*core.Bloc
 	wg.Wait()
 	return resps
 }
+
+func GetBlockCommit(node cluster.Node, hash []byte) (*core.BlockCommit, error) {
+	if !node.IsRunning() {
+		return nil, fmt.Errorf("node is not running")
+	}
+	resp, err := getRequestWithRetry(fmt.Sprintf("%s/blocks/%x/commit", node.GetEndpoint(), hash))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	ret := core.NewBlockCommit()
+	if err := json.NewDecoder(resp.Body).Decode(ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+func GetStateProof(node cluster.Node, codeAddr, key []byte) (*storage.StateKeyProof, error) {
+	if !node.IsRunning() {
+		return nil, fmt.Errorf("node is not running")
+	}
+	b, err := json.Marshal(struct {
+		CodeAddr []byte `json:"codeAddr"`
+		Key      []byte `json:"key"`
+	}{codeAddr, key})
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.Post(node.GetEndpoint()+"/querystate/proof",
+		"application/json", bytes.NewReader(b))
+	err = checkResponse(resp, err)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get state proof %w", err)
+	}
+	defer resp.Body.Close()
+	ret := new(storage.StateKeyProof)
+	return ret, json.NewDecoder(resp.Body).Decode(ret)
+}
+
+// VerifyStateProof gets the state proof of key from the node and verifies it against
+// the state root of the latest block commit, blocks without state changes have no root.
+func VerifyStateProof(cls *cluster.Cluster, idx int, codeAddr, key []byte) ([]byte, error) {
+	node := cls.GetNode(idx)
+	proof, err := GetStateProof(node, codeAddr, key)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(proof.Key, core.StateKey(codeAddr, key)) {
+		return nil, fmt.Errorf("state proof of another key %x", proof.Key)
+	}
+	for height := proof.BlockHeight; ; height-- {
+		blk, err := GetBlockByHeight(node, height)
+		if err != nil {
+			return nil, err
+		}
+		bcm, err := GetBlockCommit(node, blk.Hash())
+		if err != nil {
+			return nil, err
+		}
+		if len(bcm.MerkleRoot()) > 0 {
+			if !storage.VerifyStateKeyProof(bcm.MerkleRoot(), proof, cls.NodeConfig().StorageConfig) {
+				return nil, fmt.Errorf("invalid state proof at height %d", height)
+			}
+			return proof.Value, nil
+		}
+		if height == 0 {
+			return nil, fmt.Errorf("no block commit with state root")
+		}
+	}
+}
+
+func GetTxProof(node cluster.Node, hash []byte) (*storage.TxProof, error) {
+	if !node.IsRunning() {
+		return nil, fmt.Errorf("node is not running")
+	}
+	resp, err := getRequestWithRetry(fmt.Sprintf("%s/transactions/%x/proof", node.GetEndpoint(), hash))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	ret := new(storage.TxProof)
+	if err := json.NewDecoder(resp.Body).Decode(ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// VerifyTxProofAll gets the commit proof of tx from every running node and verifies it against
+// the validators, the proofs must be for the same block on all nodes.
+func VerifyTxProofAll(cls *cluster.Cluster, tx *core.Transaction) error {
+	var vs core.ValidatorStore
+	var first *storage.TxProof
+	for i := 0; i < cls.NodeCount(); i++ {
+		node := cls.GetNode(i)
+		if !node.IsRunning() {
+			continue
+		}
+		if err := WaitTxCommited(node, tx); err != nil {
+			return fmt.Errorf("node %d, %w", i, err)
+		}
+		if vs == nil {
+			var err error
+			if vs, err = getValidatorStore(node); err != nil {
+				return fmt.Errorf("cannot get validators from node %d, %w", i, err)
+			}
+		}
+		proof, err := GetTxProof(node, tx.Hash())
+		if err != nil {
+			return fmt.Errorf("cannot get tx proof from node %d, %w", i, err)
+		}
+		if !storage.VerifyTxProof(vs, tx.Hash(), proof) {
+			return fmt.Errorf("invalid tx proof from node %d", i)
+		}
+		if first == nil {
+			first = proof
+		}
+		if !bytes.Equal(proof.Header.Hash(), first.Header.Hash()) ||
+			proof.Index != first.Index || !equalPaths(proof.Path, first.Path) {
+			return fmt.Errorf("different tx proof from node %d", i)
+		}
+	}
+	if first == nil {
+		return fmt.Errorf("no running node")
+	}
+	return nil
+}
+
+// getValidatorStore returns the validators listed in the leader schedule of node
+func getValidatorStore(node cluster.Node) (core.ValidatorStore, error) {
+	schedule, err := GetLeaderSchedule(node)
+	if err != nil {
+		return nil, err
+	}
+	validators := make([]*core.PublicKey, len(schedule.Validators))
+	for i, v := range schedule.Validators {
+		if validators[i], err = core.NewPublicKey(v); err != nil {
+			return nil, err
+		}
+	}
+	return core.NewValidatorStore(validators), nil
+}
+
+func equalPaths(a, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -50,6 +50,32 @@ func NewJuriaCoinClient(mintCount, destCount int, binccPath string) *JuriaCoinCl
 	return client
 }
 
+// NewJuriaCoinClientFromSeed derives minter m/0', accounts m/1'/i' and dests m/2'/i' from seed,
+// so that the same accounts are used across runs
+func NewJuriaCoinClientFromSeed(
+	mintCount, destCount int, binccPath string, seed []byte,
+) (*JuriaCoinClient, error) {
+	master, err := core.NewMasterKey(seed)
+	if err != nil {
+		return nil, err
+	}
+	client := &JuriaCoinClient{
+		binccPath: binccPath,
+		minter:    master.DeriveChild(0).PrivateKey(),
+		accounts:  deriveKeys(master.DeriveChild(1), mintCount),
+		dests:     deriveKeys(master.DeriveChild(2), destCount),
+	}
+	return client, nil
+}
+
+func deriveKeys(parent *core.ExtendedKey, count int) []*core.PrivateKey {
+	keys := make([]*core.PrivateKey, count)
+	for i := range keys {
+		keys[i] = parent.DeriveChild(uint32(i)).PrivateKey()
+	}
+	return keys
+}
+
 func (client *JuriaCoinClient) generateKeyConcurrent(keys []*core.PrivateKey) {
 	jobs := make(chan int, 100)
 	defer close(jobs)
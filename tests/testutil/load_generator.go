@@ -11,6 +11,12 @@ import (
 	"github.com/aungmawjj/juria-blockchain/tests/cluster"
 )
 
+// MinTxPerSec is the lowest load rate the generator can produce,
+// jobs are submitted in batches of jobPerTick
+const MinTxPerSec = jobPerTick
+
+const jobPerTick = 20
+
 type LoadGenerator struct {
 	txPerSec int
 	client   LoadClient
@@ -30,7 +36,6 @@ func (lg *LoadGenerator) SetupOnCluster(cls *cluster.Cluster) error {
 }
 
 func (lg *LoadGenerator) Run(ctx context.Context) {
-	jobPerTick := 20
 	delay := time.Second / time.Duration(lg.txPerSec/jobPerTick)
 	ticker := time.NewTicker(delay)
 	defer ticker.Stop()
@@ -0,0 +1,81 @@
+// Copyright (C) 2021 Aung Maw
+// Licensed under the GNU General Public License v3.0
+
+package testutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/aungmawjj/juria-blockchain/core"
+	"github.com/aungmawjj/juria-blockchain/node"
+	"github.com/aungmawjj/juria-blockchain/tests/cluster"
+)
+
+func GetLeaderSchedule(nd cluster.Node) (*node.LeaderScheduleResponse, error) {
+	if !nd.IsRunning() {
+		return nil, fmt.Errorf("node is not running")
+	}
+	resp, err := getRequestWithRetry(nd.GetEndpoint() + "/consensus/leaders")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	ret := new(node.LeaderScheduleResponse)
+	if err := json.NewDecoder(resp.Body).Decode(ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// VerifyProposers checks blocks of heights from..to on each running node.
+// Each node's blocks must be proposed by the leaders the node approved,
+// and all nodes must have the same block at each height.
+func VerifyProposers(cls *cluster.Cluster, from, to uint64) error {
+	var refNode int
+	var refBlocks []*core.Block
+	for i := 0; i < cls.NodeCount(); i++ {
+		nd := cls.GetNode(i)
+		if !nd.IsRunning() {
+			continue
+		}
+		blocks, err := verifyNodeProposers(nd, from, to)
+		if err != nil {
+			return fmt.Errorf("node %d, %w", i, err)
+		}
+		if refBlocks == nil {
+			refNode, refBlocks = i, blocks
+			continue
+		}
+		for j, blk := range blocks {
+			if !bytes.Equal(blk.Hash(), refBlocks[j].Hash()) {
+				return fmt.Errorf("node %d and %d diverge at height %d", refNode, i, blk.Height())
+			}
+		}
+	}
+	return nil
+}
+
+func verifyNodeProposers(nd cluster.Node, from, to uint64) ([]*core.Block, error) {
+	schedule, err := GetLeaderSchedule(nd)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get leader schedule, %w", err)
+	}
+	validators := make([]*core.PublicKey, len(schedule.Validators))
+	for i, v := range schedule.Validators {
+		if validators[i], err = core.NewPublicKey(v); err != nil {
+			return nil, err
+		}
+	}
+	blocks := make([]*core.Block, 0, to-from+1)
+	for h := from; h <= to; h++ {
+		blk, err := GetBlockByHeight(nd, h)
+		if err != nil {
+			return nil, fmt.Errorf("cannot get block %d, %w", h, err)
+		}
+		blocks = append(blocks, blk)
+	}
+	err = core.VerifyProposerSchedule(blocks, core.NewValidatorStore(validators), schedule.Leaders)
+	return blocks, err
+}
@@ -7,12 +7,18 @@ import (
 	"bytes"
 	"encoding/base64"
 	"errors"
+	"sync/atomic"
 
 	"github.com/aungmawjj/juria-blockchain/core"
 	"github.com/aungmawjj/juria-blockchain/emitter"
 	"github.com/aungmawjj/juria-blockchain/logger"
 )
 
+// errors
+var (
+	ErrLowDiskSpace = errors.New("not accepting new txs, low disk space")
+)
+
 type Status struct {
 	Total   int `json:"total"`
 	Pending int `json:"pending"`
@@ -21,6 +27,7 @@ type Status struct {
 
 type Storage interface {
 	HasTx(hash []byte) bool
+	GetBlockHeight() uint64
 }
 
 type Execution interface {
@@ -49,6 +56,10 @@ type TxPool struct {
 
 	store       *txStore
 	broadcaster *broadcaster
+
+	lowDiskSpace int32 // 1 when new txs are rejected
+
+	txLimits core.TxLimits
 }
 
 func New(storage Storage, execution Execution, msgSvc MsgService) *TxPool {
@@ -58,6 +69,7 @@ func New(storage Storage, execution Execution, msgSvc MsgService) *TxPool {
 		msgSvc:      msgSvc,
 		store:       newTxStore(),
 		broadcaster: newBroadcaster(msgSvc),
+		txLimits:    core.DefaultTxLimits,
 	}
 	go pool.subscribeTxs()
 	return pool
@@ -67,6 +79,26 @@ func (pool *TxPool) SubmitTx(tx *core.Transaction) error {
 	return pool.submitTx(tx)
 }
 
+// SetTxLimits sets the limits of tx fields submitted by clients, it must be called before receiving txs.
+// Txs received from peers may be in the blocks of other validators, they are not limited.
+func (pool *TxPool) SetTxLimits(limits core.TxLimits) {
+	pool.txLimits = limits
+}
+
+// SetLowDiskSpace makes the pool reject new txs from clients and gossip.
+// Txs of proposed blocks can still be synced.
+func (pool *TxPool) SetLowDiskSpace(val bool) {
+	var v int32
+	if val {
+		v = 1
+	}
+	atomic.StoreInt32(&pool.lowDiskSpace, v)
+}
+
+func (pool *TxPool) isLowDiskSpace() bool {
+	return atomic.LoadInt32(&pool.lowDiskSpace) == 1
+}
+
 func (pool *TxPool) SyncTxs(peer *core.PublicKey, hashes [][]byte) error {
 	return pool.syncTxs(peer, hashes)
 }
@@ -104,6 +136,12 @@ func (pool *TxPool) GetStatus() Status {
 }
 
 func (pool *TxPool) submitTx(tx *core.Transaction) error {
+	if pool.isLowDiskSpace() {
+		return ErrLowDiskSpace
+	}
+	if err := tx.ValidateBasic(pool.txLimits); err != nil {
+		return err
+	}
 	if err := pool.addNewTx(tx); err != nil {
 		return err
 	}
@@ -115,6 +153,9 @@ func (pool *TxPool) subscribeTxs() {
 	sub := pool.msgSvc.SubscribeTxList(100)
 	for e := range sub.Events() {
 		txList := e.(*core.TxList)
+		if pool.isLowDiskSpace() {
+			continue
+		}
 		if err := pool.addTxList(txList); err != nil {
 			logger.I().Warnf("add tx list failed %+v", err)
 		}
@@ -148,7 +189,12 @@ func (pool *TxPool) workerAddNewTx(jobCh <-chan *core.Transaction, out chan<- er
 }
 
 func (pool *TxPool) addNewTx(tx *core.Transaction) error {
-	if err := tx.Validate(); err != nil {
+	// tx is to be included in the next block
+	if err := tx.ValidateBasic(core.TxLimits{}); err != nil {
+		return err
+	}
+	opts := core.TxValidateOptions{Height: pool.storage.GetBlockHeight() + 1}
+	if err := tx.Validate(opts); err != nil {
 		return err
 	}
 	if pool.storage.HasTx(tx.Hash()) {
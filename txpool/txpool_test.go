@@ -26,6 +26,11 @@ func (m *MockStorage) HasTx(hash []byte) bool {
 	return args.Bool(0)
 }
 
+func (m *MockStorage) GetBlockHeight() uint64 {
+	args := m.Called()
+	return uint64(args.Int(0))
+}
+
 type MockExecution struct {
 	mock.Mock
 }
@@ -68,6 +73,7 @@ func TestTxPool_SubmitTx(t *testing.T) {
 	priv := core.GenerateKey(nil)
 
 	storage := new(MockStorage)
+	storage.On("GetBlockHeight").Return(0)
 	execution := new(MockExecution)
 	msgSvc := new(MockMsgService)
 
@@ -123,6 +129,7 @@ func TestTxPool_SubscribeTxList(t *testing.T) {
 	priv := core.GenerateKey(nil)
 
 	storage := new(MockStorage)
+	storage.On("GetBlockHeight").Return(0)
 	execution := new(MockExecution)
 	msgSvc := new(MockMsgService)
 
@@ -154,12 +161,82 @@ func TestTxPool_SubscribeTxList(t *testing.T) {
 	storage.AssertExpectations(t)
 }
 
+func TestTxPool_LowDiskSpace(t *testing.T) {
+	assert := assert.New(t)
+
+	priv := core.GenerateKey(nil)
+
+	storage := new(MockStorage)
+	storage.On("GetBlockHeight").Return(0)
+	execution := new(MockExecution)
+	msgSvc := new(MockMsgService)
+
+	txEmitter := emitter.New()
+	msgSvc.On("SubscribeTxList", mock.Anything).Return(txEmitter.Subscribe(10))
+
+	pool := New(storage, execution, msgSvc)
+	pool.broadcaster.timeout = time.Minute // to avoid timeout broadcast
+	pool.broadcaster.timer.Reset(time.Minute)
+
+	time.Sleep(time.Millisecond)
+
+	tx1 := core.NewTransaction().SetNonce(1).Sign(priv)
+	tx2 := core.NewTransaction().SetNonce(2).Sign(priv)
+
+	pool.SetLowDiskSpace(true)
+	assert.ErrorIs(pool.SubmitTx(tx1), ErrLowDiskSpace)
+
+	txEmitter.Emit(&core.TxList{tx2})
+	time.Sleep(5 * time.Millisecond)
+	assert.Equal(0, pool.GetStatus().Total, "should drop txs from gossip")
+
+	pool.SetLowDiskSpace(false)
+	storage.On("HasTx", tx1.Hash()).Return(false)
+	execution.On("VerifyTx", tx1).Return(nil)
+	assert.NoError(pool.SubmitTx(tx1))
+	assert.Equal(1, pool.GetStatus().Queue, "should accept txs after recovery")
+}
+
+func TestTxPool_TxLimits(t *testing.T) {
+	assert := assert.New(t)
+
+	priv := core.GenerateKey(nil)
+
+	storage := new(MockStorage)
+	storage.On("GetBlockHeight").Return(0)
+	execution := new(MockExecution)
+	msgSvc := new(MockMsgService)
+	msgSvc.On("SubscribeTxList", mock.Anything).Return(emitter.New().Subscribe(10))
+
+	pool := New(storage, execution, msgSvc)
+	pool.SetTxLimits(core.TxLimits{MaxInputSize: 4})
+
+	// limits are checked before the hash, tx is modified after signing
+	tx := core.NewTransaction().SetInput(make([]byte, 5)).Sign(priv)
+	tx.SetNonce(1)
+	assert.ErrorIs(pool.SubmitTx(tx), core.ErrTxInputTooLarge)
+
+	tx = core.NewTransaction().SetCodeAddr([]byte{1}).Sign(priv)
+	assert.ErrorIs(pool.SubmitTx(tx), core.ErrInvalidCodeAddr)
+	assert.Equal(0, pool.GetStatus().Total)
+	execution.AssertNotCalled(t, "VerifyTx", mock.Anything)
+
+	// txs of other validators' blocks are synced regardless of the limits
+	tx = core.NewTransaction().SetInput(make([]byte, 5)).Sign(priv)
+	storage.On("HasTx", tx.Hash()).Return(false)
+	execution.On("VerifyTx", tx).Return(nil)
+	msgSvc.On("RequestTxList", priv.PublicKey(), [][]byte{tx.Hash()}).Return(&core.TxList{tx}, nil)
+	assert.NoError(pool.SyncTxs(priv.PublicKey(), [][]byte{tx.Hash()}))
+	assert.NotNil(pool.GetTx(tx.Hash()))
+}
+
 func TestTxPool_Sync(t *testing.T) {
 	assert := assert.New(t)
 
 	priv := core.GenerateKey(nil)
 
 	storage := new(MockStorage)
+	storage.On("GetBlockHeight").Return(0)
 	execution := new(MockExecution)
 	msgSvc := new(MockMsgService)
 
@@ -217,6 +294,7 @@ func TestTxPool_GetTxsToExecute(t *testing.T) {
 	priv := core.GenerateKey(nil)
 
 	storage := new(MockStorage)
+	storage.On("GetBlockHeight").Return(0)
 	execution := new(MockExecution)
 	msgSvc := new(MockMsgService)
 